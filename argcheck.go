@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ============================================================================
+// Builtin Argument Checking
+// ============================================================================
+//
+// Builtins used to make do with whatever they were given: (substring 42 1)
+// was "", (+ 'a 1) was 1 and (nth lst "2") was the first item, so a
+// generated spec with a slip in it ran on with a plausible wrong answer.
+// builtinArgs declares the arguments of the builtins where that matters,
+// and apply checks them before the builtin runs. A call that doesn't fit
+// warns, with a stack trace, and returns an error value instead:
+//
+//   (substring 42 1)  ; => #error{(substring "a string for argument 1" 42)}
+//   (nth '(a b))      ; => #error{(nth "2 arguments" ((a b)))}
+//
+// An error value is a value tagged error holding the builtin's name, what
+// it expected, and the offending argument (or, for the wrong number of
+// arguments, all of them). (error? v) tells one apart.
+//
+// Signatures are a type word per argument. [type] is optional, and type...
+// takes any number of further arguments of that type.
+
+// builtinArgs are the signatures checked before a builtin runs
+var builtinArgs = map[string]string{
+	// Arithmetic
+	"+":     "number...",
+	"-":     "number...",
+	"*":     "number...",
+	"/":     "number number",
+	"mod":   "number number",
+	"float": "number",
+	"abs":   "number",
+	"min":   "number number...",
+	"max":   "number number...",
+	"floor": "number",
+	"ceil":  "number",
+	"sqrt":  "number",
+	"pow":   "number number",
+	"exp":   "number",
+	"ln":    "number",
+	"log":   "number",
+	"sin":   "number",
+	"cos":   "number",
+
+	// Comparison
+	"<":  "number number",
+	">":  "number number",
+	"<=": "number number",
+	">=": "number number",
+
+	// Lists
+	"first":      "list",
+	"rest":       "list",
+	"car":        "list",
+	"cdr":        "list",
+	"nth":        "list integer",
+	"length":     "list",
+	"sort":       "list",
+	"sort-by":    "list function",
+	"map":        "function list list...",
+	"filter":     "function list",
+	"reduce":     "function any [list]",
+	"fold":       "function any list",
+	"fold-left":  "function any list",
+	"fold-right": "function any list",
+	"for-each":   "function list list...",
+	"memoize":    "function [integer]",
+
+	// Strings and chars
+	"string-length":    "string",
+	"substring":        "string integer [integer]",
+	"string-index":     "string any",
+	"string-contains?": "string any",
+	"string-split":     "string [string]",
+	"string-join":      "list [string]",
+	"string-replace":   "string any any",
+	"string-upcase":    "string",
+	"string-downcase":  "string",
+	"string->symbol":   "text",
+	"string->number":   "string [integer]",
+	"string->list":     "string",
+	"list->string":     "list",
+	"char->number":     "char",
+	"number->char":     "integer",
+
+	// Tagged values, maps and sets
+	"tag-type":    "tagged",
+	"tag-value":   "tagged",
+	"map-get":     "map any [any]",
+	"map-set!":    "map any any",
+	"map-has?":    "map any",
+	"map-delete!": "map any",
+	"map-keys":    "map",
+	"map->alist":  "map",
+	"alist->map":  "list",
+	"list->set":   "list",
+	"set->list":   "set",
+	"set-add!":    "set any...",
+	"set-remove!": "set any",
+	"set-member?": "set any",
+	"set-size":    "set",
+
+	// Stacks and queues
+	"push!":          "stack any",
+	"pop!":           "stack",
+	"push-now!":      "stack any",
+	"pop-now!":       "stack",
+	"stack-peek":     "stack",
+	"stack-peek-now": "stack",
+	"stack-read":     "stack integer",
+	"stack-write!":   "stack integer any",
+	"stack-full?":    "stack",
+	"stack-empty?":   "stack",
+	"send!":          "queue any",
+	"recv!":          "queue",
+	"send-now!":      "queue any",
+	"recv-now!":      "queue",
+	"queue-peek":     "queue",
+	"queue-peek-now": "queue",
+	"queue-full?":    "queue",
+	"queue-empty?":   "queue",
+
+	// Printing and reading
+	"repr": "any",
+	"read": "string",
+
+	// Scheduler policies
+	"set-scheduler-policy!": "any [integer]",
+	"set-priority!":         "any integer",
+
+	// Topics
+	"publish!":   "any any",
+	"broadcast!": "any",
+
+	// Schedule hooks
+	"on-schedule-event!":    "function",
+	"remove-schedule-hook!": "integer",
+
+	// Actor introspection
+	"whereis":            "any",
+	"actor-exists?":      "any",
+	"actor-mailbox-size": "any",
+	"actor-result":       "any",
+	"actor-progress":     "any",
+	"lamport-time":       "[any]",
+	"vector-clocks!":     "any",
+	"auto-trace!":        "any",
+	"vector-time":        "[any]",
+
+	// Budgets
+	"set-actor-budget!": "any any",
+
+	// Virtual time
+	"advance-time!": "integer",
+
+	// Ask and reply
+	"ask!":   "any any",
+	"reply!": "any any",
+
+	// Promises
+	"deliver!":       "any any",
+	"await":          "any",
+	"promise-ready?": "any",
+
+	// Snapshots
+	"restore-world!": "any",
+
+	// Timers
+	"send-after!":      "integer any any",
+	"receive-timeout!": "integer",
+
+	// Time
+	"format-time": "number [any]",
+
+	// Datalog
+	"datalog-mode!": "any any...",
+}
+
+// argTypes describes each type word and says which values are of it
+var argTypes = map[string]struct {
+	desc string
+	is   func(Value) bool
+}{
+	"any":      {"anything", func(v Value) bool { return true }},
+	"number":   {"a number", func(v Value) bool { return v.Type == TypeNumber }},
+	"integer":  {"an integer", func(v Value) bool { return v.Type == TypeNumber && v.Number == math.Trunc(v.Number) }},
+	"string":   {"a string", func(v Value) bool { return v.Type == TypeString }},
+	"text":     {"a string or symbol", func(v Value) bool { return v.Type == TypeString || v.Type == TypeSymbol }},
+	"char":     {"a char", func(v Value) bool { return v.Type == TypeChar }},
+	"list":     {"a list", func(v Value) bool { return v.Type == TypeList || v.Type == TypeNil }},
+	"function": {"a function", func(v Value) bool { return v.Type == TypeFunc || v.Type == TypeBuiltin }},
+	"tagged":   {"a tagged value", func(v Value) bool { return v.Type == TypeTagged }},
+	"map":      {"a map", func(v Value) bool { return v.Type == TypeMap }},
+	"set":      {"a set", func(v Value) bool { return v.Type == TypeSet }},
+	"stack":    {"a stack", func(v Value) bool { return v.Type == TypeStack }},
+	"queue":    {"a queue", func(v Value) bool { return v.Type == TypeQueue }},
+}
+
+// argSpec is a signature parsed
+type argSpec struct {
+	types    []string // Required, then optional
+	required int
+	rest     string // Type of any further arguments, or ""
+}
+
+var argSpecs = parseArgSpecs(builtinArgs)
+
+func parseArgSpecs(sigs map[string]string) map[string]*argSpec {
+	specs := make(map[string]*argSpec, len(sigs))
+	for name, sig := range sigs {
+		spec := &argSpec{}
+		for _, word := range strings.Fields(sig) {
+			switch {
+			case strings.HasSuffix(word, "..."):
+				spec.rest = strings.TrimSuffix(word, "...")
+			case strings.HasPrefix(word, "["):
+				spec.types = append(spec.types, strings.Trim(word, "[]"))
+			default:
+				spec.types = append(spec.types, word)
+				spec.required++
+			}
+		}
+		specs[name] = spec
+	}
+	return specs
+}
+
+// arity describes how many arguments spec takes
+func (spec *argSpec) arity() string {
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 argument"
+		}
+		return fmt.Sprintf("%d arguments", n)
+	}
+	switch {
+	case spec.rest != "":
+		return fmt.Sprintf("at least %s", plural(spec.required))
+	case len(spec.types) > spec.required:
+		return fmt.Sprintf("%d to %s", spec.required, plural(len(spec.types)))
+	}
+	return plural(spec.required)
+}
+
+// checkArgs returns the error value for a call to the builtin fn that
+// doesn't fit its signature, warning about it, or false if the call is fine
+func (ev *Evaluator) checkArgs(fn Value, args []Value) (Value, bool) {
+	spec := argSpecs[fn.Symbol]
+	if spec == nil {
+		return Nil(), false
+	}
+	name := fn.Symbol
+	if len(args) < spec.required || (spec.rest == "" && len(args) > len(spec.types)) {
+		expected := spec.arity()
+		ev.warnTrace(name+":args", "%s: expected %s, got %d", name, expected, len(args))
+		return errorValue(name, expected, Lst(args...)), true
+	}
+	for i, arg := range args {
+		t := spec.rest
+		if i < len(spec.types) {
+			t = spec.types[i]
+		}
+		if argTypes[t].is(arg) {
+			continue
+		}
+		expected := fmt.Sprintf("%s for argument %d", argTypes[t].desc, i+1)
+		ev.warnTrace(name+":args", "%s: expected %s, got %s", name, expected, arg.String())
+		return errorValue(name, expected, arg), true
+	}
+	return Nil(), false
+}
+
+// errorValue is #error{(name expected got)}
+func errorValue(name, expected string, got Value) Value {
+	return Value{Type: TypeTagged, Tagged: &TaggedValue{Tag: "error", Value: Lst(Sym(name), Str(expected), got)}}
+}
+
+// nameBuiltins records each global builtin's name in its Symbol, so
+// apply knows which signature to check and profiles which name to report
+func (ev *Evaluator) nameBuiltins() {
+	for name, v := range ev.GlobalEnv.bindings {
+		if v.Type == TypeBuiltin {
+			v.Symbol = name
+			ev.GlobalEnv.bindings[name] = v
+		}
+	}
+}
+
+// (error? v) - true for the error values builtins return
+func builtinIsError(ev *Evaluator, args []Value, env *Env) Value {
+	return Bool(len(args) > 0 && args[0].Type == TypeTagged && args[0].Tagged.Tag == "error")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Builtin Argument Checking Tests
+// ============================================================================
+
+func TestBuiltinArgsChecked(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	tests := []struct {
+		code     string
+		expected string
+		warning  string
+	}{
+		{`(substring 42 1)`, `#error{(substring "a string for argument 1" 42)}`, "substring: expected a string for argument 1, got 42"},
+		{`(nth '(a b))`, `#error{(nth "2 arguments" ((a b)))}`, "nth: expected 2 arguments, got 1"},
+		{`(nth '(a b) "1")`, `#error{(nth "an integer for argument 2" "1")}`, "nth: expected an integer for argument 2"},
+		{`(+ 1 'a 2)`, `#error{(+ "a number for argument 2" a)}`, "+: expected a number for argument 2, got a"},
+		{`(substring "abc" 0 1 2)`, `#error{(substring "2 to 3 arguments" ("abc" 0 1 2))}`, "substring: expected 2 to 3 arguments, got 4"},
+		{`(min)`, `#error{(min "at least 1 argument" ())}`, "min: expected at least 1 argument, got 0"},
+		{`(map-get (make-set) 'a)`, `#error{(map-get "a map for argument 1" #{})}`, "map-get: expected a map for argument 1"},
+		{`(map 'f '(1 2))`, `#error{(map "a function for argument 1" f)}`, "map: expected a function for argument 1"},
+		{`(car "abc")`, `#error{(car "a list for argument 1" "abc")}`, "car: expected a list for argument 1"},
+		{`(apply first '(x))`, `#error{(first "a list for argument 1" x)}`, "first: expected a list for argument 1"},
+	}
+	for _, tt := range tests {
+		ev.ResetWarnings()
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+		if w := lastWarning(ev, tt.warning); w == nil || len(w.Stack) == 0 {
+			t.Errorf("%s: no warning %q with a trace", tt.code, tt.warning)
+		}
+	}
+
+	// Calls that fit run as before
+	fine := []struct {
+		code     string
+		expected string
+	}{
+		{`(+)`, "0"},
+		{`(nth '(a b) 1.0)`, "b"},
+		{`(first nil)`, "nil"},
+		{`(string->symbol 'already)`, "already"},
+		{`(map + '(1 2) '(10 20))`, "(11 22)"},
+		{`(list (error? (car 1)) (error? 'error) (error? (tag 'ok 1)))`, "(true false false)"},
+		{`(tag-value (substring 1 2))`, `(substring "a string for argument 1" 1)`},
+	}
+	for _, tt := range fine {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+}
+
+func TestBuiltinArgsAreBuiltins(t *testing.T) {
+	ev := NewEvaluator(1000)
+	for name, spec := range argSpecs {
+		if v, ok := ev.GlobalEnv.bindings[name]; !ok || v.Type != TypeBuiltin || v.Symbol != name {
+			t.Errorf("builtinArgs has a signature for %s, which isn't a named builtin", name)
+		}
+		for _, typ := range append(spec.types, spec.rest) {
+			if _, ok := argTypes[typ]; !ok && typ != "" {
+				t.Errorf("%s: unknown argument type %q", name, typ)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// ============================================================================
+// Arithmetic Goals
+// ============================================================================
+//
+// The comparison builtins only test terms that are already bound, so a
+// rule can check that a balance went down but not say by how much. An is
+// goal computes a value and binds it:
+//
+//   (rule 'delta '(inventory-delta ?item ?d)
+//     '(stock ?item ?before ?t1) '(stock ?item ?after ?t2) '(< ?t1 ?t2)
+//     '(is ?d (- ?after ?before)))
+//
+// The right-hand side is a number, a variable bound to one, or an
+// expression (op arg ...) over those with op one of + - * / mod min max
+// abs. Every variable in it must be bound by an earlier goal; if one
+// isn't, or an argument isn't a number, or it divides by zero, the goal
+// fails. The result is unified with the left-hand side, so (is 10 (+ ?a
+// ?b)) checks a sum rather than binding anything.
+//
+// Bottom-up, a recursive rule with an is goal could compute new numbers
+// forever, (count ?n) :- (count ?m), (is ?n (+ ?m 1)), so such rules
+// are only solved top-down.
+
+// solveIs solves (is lhs expr) and the goals after it
+func (db *DatalogDB) solveIs(goal Goal, rest []Goal, bindings Binding, depth int) []Binding {
+	if len(goal.Args) != 2 {
+		return nil
+	}
+	n, err := evalArith(bindings.Deref(goal.Args[1]))
+	if err != nil {
+		return nil
+	}
+	newB, ok := Unify(goal.Args[0], NumTerm(n), bindings)
+	if !ok {
+		return nil
+	}
+	return db.solve(rest, newB, depth+1)
+}
+
+// evalArith computes the value of a ground arithmetic term
+func evalArith(t Term) (float64, error) {
+	if t.IsNum {
+		return t.Num, nil
+	}
+	if !t.IsList || len(t.List) == 0 || t.List[0].IsVar || t.List[0].IsList {
+		return 0, fmt.Errorf("not a number or an expression: %s", t.String())
+	}
+	op := t.List[0].Name
+	args := make([]float64, len(t.List)-1)
+	for i, a := range t.List[1:] {
+		v, err := evalArith(a)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+
+	switch {
+	case op == "abs" && len(args) == 1:
+		return math.Abs(args[0]), nil
+	case op == "-" && len(args) == 1:
+		return -args[0], nil
+	case op == "+" || op == "*" || op == "min" || op == "max":
+		if len(args) == 0 {
+			break
+		}
+		acc := args[0]
+		for _, v := range args[1:] {
+			switch op {
+			case "+":
+				acc += v
+			case "*":
+				acc *= v
+			case "min":
+				acc = math.Min(acc, v)
+			case "max":
+				acc = math.Max(acc, v)
+			}
+		}
+		return acc, nil
+	case len(args) != 2:
+		break
+	case op == "-":
+		return args[0] - args[1], nil
+	case op == "/" && args[1] != 0:
+		return args[0] / args[1], nil
+	case op == "mod" && args[1] != 0:
+		return math.Mod(args[0], args[1]), nil
+	}
+	return 0, fmt.Errorf("can't compute %s", t.String())
+}
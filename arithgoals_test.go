@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Arithmetic Goal Tests
+// ============================================================================
+
+func TestIsGoals(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(assert! 'stock 'flour 40 1)
+		(assert! 'stock 'flour 25 2)
+		(assert! 'price 'flour 3)
+		(rule 'delta '(inventory-delta ?item ?d)
+		  '(stock ?item ?before 1) '(stock ?item ?after 2)
+		  '(is ?d (- ?after ?before)))
+		(rule 'value '(stock-value ?item ?t ?v)
+		  '(stock ?item ?n ?t) '(price ?item ?p)
+		  '(is ?v (max 0 (* ?n ?p))))
+		(rule 'halved '(halved ?item ?h) '(stock ?item ?n 1) '(is ?h (/ ?n 0)))
+		(rule 'unbound '(unbound ?item ?x) '(stock ?item ?n 1) '(is ?x (+ ?n ?missing)))
+		(rule 'sum-check '(sums-to-65 ?item) '(stock ?item ?a 1) '(stock ?item ?b 2) '(is 65 (+ ?a ?b)))`)
+	db := ev.DatalogDB
+
+	tests := []struct {
+		goal     Goal
+		variable string
+		expected string
+	}{
+		{Goal{Predicate: "inventory-delta", Args: []Term{Atom("flour"), Var("D")}}, "D", "-15 "},
+		{Goal{Predicate: "stock-value", Args: []Term{Atom("flour"), NumTerm(2), Var("V")}}, "V", "75 "},
+		{Goal{Predicate: "halved", Args: []Term{Atom("flour"), Var("H")}}, "H", ""},
+		{Goal{Predicate: "unbound", Args: []Term{Atom("flour"), Var("X")}}, "X", ""},
+		{Goal{Predicate: "sums-to-65", Args: []Term{Var("I")}}, "I", "flour "},
+	}
+	for _, tt := range tests {
+		if got := answers(db.QueryGoals(tt.goal), tt.variable); got != tt.expected {
+			t.Errorf("%s: ?%s = %q, want %q", tt.goal.String(), tt.variable, got, tt.expected)
+		}
+		results, err := db.QueryGoalsBottomUp(tt.goal)
+		if got := answers(results, tt.variable); err != nil || got != tt.expected {
+			t.Errorf("%s bottom-up: ?%s = %q (%v), want %q", tt.goal.String(), tt.variable, got, err, tt.expected)
+		}
+	}
+	if got := evalLast(ev, "(query-all '(stock flour ?n 1) '(is ?half (/ ?n 2)))").String(); got != "(((half 20) (n 40)))" {
+		t.Errorf("query-all with is = %s", got)
+	}
+
+	// A running balance, each one computed from the one before
+	runCode(ev, `
+		(assert! 'txn 1 10)
+		(assert! 'txn 2 -3)
+		(assert! 'txn 3 5)
+		(rule 'balance-start '(balance 0 0) '(txn 1 ?any))
+		(rule 'balance-step '(balance ?n ?b)
+		  '(txn ?n ?amount) '(is ?m (- ?n 1)) '(balance ?m ?a) '(is ?b (+ ?a ?amount)))`)
+	if got := answers(db.Query("balance", NumTerm(3), Var("B")), "B"); got != "12 " {
+		t.Errorf("balance after 3 = %s, want 12", got)
+	}
+	// Bottom-up, counting up like that might never stop
+	if err := db.CheckBottomUp(); err == nil {
+		t.Error("the recursive balance rule computes with is but was allowed bottom-up")
+	}
+}
+
+func TestEvalArith(t *testing.T) {
+	tests := []struct {
+		term     Term
+		expected float64
+		ok       bool
+	}{
+		{NumTerm(4), 4, true},
+		{ListTerm(Atom("+"), NumTerm(1), NumTerm(2), NumTerm(3)), 6, true},
+		{ListTerm(Atom("-"), NumTerm(5)), -5, true},
+		{ListTerm(Atom("mod"), NumTerm(7), NumTerm(3)), 1, true},
+		{ListTerm(Atom("abs"), ListTerm(Atom("-"), NumTerm(2), NumTerm(9))), 7, true},
+		{ListTerm(Atom("min"), NumTerm(2), NumTerm(-1)), -1, true},
+		{ListTerm(Atom("mod"), NumTerm(7), NumTerm(0)), 0, false},
+		{ListTerm(Atom("+"), Atom("a"), NumTerm(1)), 0, false},
+		{ListTerm(Atom("sqrt"), NumTerm(4)), 0, false},
+		{Var("X"), 0, false},
+	}
+	for _, tt := range tests {
+		got, err := evalArith(tt.term)
+		if (err == nil) != tt.ok || got != tt.expected {
+			t.Errorf("evalArith(%s) = %v, %v; want %v, ok %v", tt.term.String(), got, err, tt.expected, tt.ok)
+		}
+	}
+}
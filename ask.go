@@ -0,0 +1,86 @@
+package main
+
+import "fmt"
+
+// ============================================================================
+// Ask and Reply
+// ============================================================================
+//
+// A request that wants an answer needs an id the answer can be matched
+// to, or a client with two requests out can't tell the replies apart, and
+// a reply can arrive behind unrelated messages. ask! does both halves:
+//
+//   (ask! 'bank '(balance alice))   ; in the client: => 40, the answer
+//
+// sends (ask id client msg) to the bank, with a fresh id, and waits until
+// (reply id value) arrives, taking it from the mailbox ahead of anything
+// else and leaving other messages where they are. The server answers with
+//
+//   (let ((req (receive!)))         ; (ask 3 client (balance alice))
+//     (reply! req 40))              ; sends (reply 3 40) to client
+//
+// While it waits the client is blocked on "ask (reply id)", woken only
+// by the reply, so a client whose request is never answered shows up in
+// a deadlock. A retried step doesn't ask again: the actor remembers the
+// id it is waiting on.
+
+// (ask! target msg) - send msg to target as a request and wait for the
+// reply; returns the value replied with
+func builtinAsk(ev *Evaluator, args []Value, env *Env) Value {
+	ev.markGuardSeen() // CSP: ask is a send and a receive
+	actor := ev.Scheduler.GetActor(ev.Scheduler.CurrentActor)
+	if actor == nil {
+		ev.warn("ask-no-actor", "ask!: no current actor")
+		return Nil()
+	}
+	if actor.Asking == 0 {
+		name := valueToString(args[0])
+		target := ev.Scheduler.GetActor(name)
+		if target == nil {
+			ev.warnTrace("ask-unknown:"+name, "ask!: unknown actor %s", name)
+			return Nil()
+		}
+		id := ev.Scheduler.asks + 1
+		if sent := ev.send(target, Lst(Sym("ask"), Int(id), Sym(actor.Name), args[1]), 0); !sent.IsSymbol() || sent.Symbol != "ok" {
+			return sent
+		}
+		ev.Scheduler.asks = id
+		actor.Asking = id
+	}
+	if i, ok := actor.Mailbox.Find(actor.isReply); ok {
+		msg, stamp := actor.Mailbox.RecvAt(i)
+		actor.Asking = 0
+		actor.Received++
+		actor.mergeClock(stamp)
+		ev.TraceReceive(actor.Name, msg)
+		ev.assertReceivedStamp(actor, msg)
+		return msg.List[2]
+	}
+	ev.Scheduler.BlockActor(actor.Name, fmt.Sprintf("ask (reply %d)", actor.Asking))
+	return Blocked(BlockQueueEmpty)
+}
+
+// (reply! request value) - answer an ask! request, sending
+// (reply id value) to the actor that asked
+func builtinReply(ev *Evaluator, args []Value, env *Env) Value {
+	req := args[0]
+	if req.Type != TypeList || len(req.List) != 4 || !req.List[0].IsSymbol() || req.List[0].Symbol != "ask" {
+		ev.warnTrace("", "reply!: not an ask! request: %s", req.String())
+		return Nil()
+	}
+	name := valueToString(req.List[2])
+	asker := ev.Scheduler.GetActor(name)
+	if asker == nil {
+		ev.warnTrace("", "reply!: unknown actor %s", name)
+		return Nil()
+	}
+	ev.markGuardSeen() // CSP: send is a synchronization point
+	return ev.send(asker, Lst(Sym("reply"), req.List[1], args[1]), 0)
+}
+
+// isReply reports whether msg answers the ask! a is waiting on
+func (a *Actor) isReply(msg Value) bool {
+	return a.Asking != 0 && msg.Type == TypeList && len(msg.List) == 3 &&
+		msg.List[0].IsSymbol() && msg.List[0].Symbol == "reply" &&
+		msg.List[1].Type == TypeNumber && int64(msg.List[1].Number) == a.Asking
+}
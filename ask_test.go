@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Ask and Reply Tests
+// ============================================================================
+
+func TestAskReply(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (bank)
+		  (let ((req (receive!)))
+		    (reply! req (* 10 (car (cdr (car (cdr (cdr (cdr req))))))))
+		    (list 'become '(bank))))
+		(define (client)
+		  (send-to! 'client 'note)
+		  (let ((a (ask! 'bank '(balance 4))) (b (ask! 'bank '(balance 5))))
+		    (assert! 'got a b (receive!)))
+		  'done)
+		(define (lonely) (ask! 'silent 'hello) 'done)
+		(define (silent) (receive!) (list 'become '(silent)))
+		(spawn-actor 'bank 4 '(bank))
+		(spawn-actor 'client 4 '(client))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(run-scheduler 50)", `(deadlock 8 ((bank "recv (empty)")))`},
+		// Each reply is matched to its request; the note waits its turn
+		{"(query 'got '?a '?b '?c)", "(((a 40) (b 50) (c note)))"},
+		{"(query 'sent 'client 'bank '?m)", "(((m (ask 1 client (balance 4)))) ((m (ask 2 client (balance 5)))))"},
+		{"(query 'received 'client '?m)", "(((m (reply 1 40))) ((m (reply 2 50))) ((m note)))"},
+		// A request nobody answers leaves the asker blocked, once asked
+		{"(begin (spawn-actor 'lonely 2 '(lonely)) (spawn-actor 'silent 2 '(silent)) (run-scheduler 50))",
+			`(deadlock 3 ((bank "recv (empty)") (lonely "ask (reply 3)") (silent "recv (empty)")))`},
+		{"(length (query 'sent 'lonely 'silent '?m))", "1"},
+		{"(reply! '(nope) 1)", "nil"},
+		{"(ask! 'bank 1)", "nil"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	for _, w := range []string{"reply!: not an ask! request: (nope)", "ask!: no current actor"} {
+		if lastWarning(ev, w) == nil {
+			t.Errorf("expected a warning %q, got %v", w, ev.Warnings)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import "strings"
+
+// ============================================================================
+// Automatic Tracing
+// ============================================================================
+//
+// Messages are always traced: every send records (sent from to msg) and
+// every receive (received actor msg). (auto-trace! true) has actors
+// record the rest of what their steps do as well, so a spec can check
+// the CSP pattern, guard then effect, without an assert! in every actor:
+//
+//   (guard actor receive|send)           it received or sent a message
+//   (effect actor op target)             set (a set!, target the variable)
+//                                        or an operation on a queue or
+//                                        stack, such as push or recv-now,
+//                                        target its name, or queue or stack
+//   (state-change actor var old new)     what a set! replaced, and with what
+//
+// Only actors are traced; code at the top level isn't. Like the other
+// facts a step asserts, these are recorded once even if the step blocks
+// and is retried.
+
+// (auto-trace! on) - turn tracing of guards and effects on or off
+func builtinAutoTrace(ev *Evaluator, args []Value, env *Env) Value {
+	ev.Scheduler.AutoTrace = args[0].IsTruthy()
+	return Sym("ok")
+}
+
+// tracing is the running actor while auto-tracing is on, or ""
+func (ev *Evaluator) tracing() string {
+	if ev.Scheduler == nil || !ev.Scheduler.AutoTrace {
+		return ""
+	}
+	return ev.Scheduler.CurrentActor
+}
+
+// traceSet records a set! of name from old to val by the running actor
+func (ev *Evaluator) traceSet(name string, old, val Value) {
+	if actor := ev.tracing(); actor != "" {
+		ev.TraceEffect(actor, "set", name)
+		ev.TraceStateChange(actor, name, old, val)
+	}
+}
+
+// traceOp records an operation, a builtin such as push!, on the queue or
+// stack called name by the running actor
+func (ev *Evaluator) traceOp(builtin, name, kind string) {
+	if actor := ev.tracing(); actor != "" {
+		if name == "" {
+			name = kind
+		}
+		ev.TraceEffect(actor, strings.TrimSuffix(builtin, "!"), name)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Automatic Tracing Tests
+// ============================================================================
+
+func TestAutoTrace(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define jobs (make-queue 4 'jobs))
+		(define done (make-stack 4))
+		(define total 0)
+		(define (worker)
+		  (let ((m (receive!)))
+		    (set! total (+ total m))
+		    (send! jobs m)
+		    (push! done m)
+		    (send-to! 'sink m))
+		  (list 'become '(worker)))
+		(define (sink) (receive!) (list 'become '(sink)))
+		(spawn-actor 'worker 2 '(worker))
+		(spawn-actor 'sink 1 '(sink))
+		(send-to! 'worker 5)`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		// Off, only messages are traced
+		{"(run-scheduler 20)", `(deadlock 4 ((sink "recv (empty)") (worker "recv (empty)")))`},
+		{"(query 'sent 'worker 'sink '?m)", "(((m 5)))"},
+		{"(query 'effect '?a '?op '?x)", "()"},
+		{"(auto-trace! true)", "ok"},
+		{"(set! total 0)", "0"},
+		{"(send-to! 'worker 6)", "ok"},
+		{"(run-scheduler 20)", `(deadlock 4 ((sink "recv (empty)") (worker "recv (empty)")))`},
+		{"(query 'guard '?a '?kind)", "(((a worker) (kind receive)) ((a worker) (kind send)) ((a sink) (kind receive)))"},
+		{"(query 'effect '?a '?op '?x)", "(((a worker) (op set) (x total)) ((a worker) (op send) (x jobs)) ((a worker) (op push) (x stack)))"},
+		{"(query 'state-change 'worker '?v '?old '?new)", "(((new 6) (old 0) (v total)))"},
+		// The top level isn't traced
+		{"(push-now! done 1)", "ok"},
+		{"(length (query 'effect '?a 'push-now '?x))", "0"},
+		{"(auto-trace! false)", "ok"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+}
@@ -48,7 +48,7 @@
 
 (define (trucks-init)
   (let msg (receive!)                          ; Guard: wait for bread
-    (if (= (first msg) 'bread)
+    (if (equal? (first msg) 'bread)
       (let quantity (nth msg 1)
         (registry-set! 'bread-delivered 
           (+ (registry-get 'bread-delivered) quantity))
@@ -63,13 +63,13 @@
 (define (storefront-init)
   (let msg (receive!)                          ; Guard: wait for message
     (let cmd (first msg)
-      (if (= cmd 'delivery)
+      (if (equal? cmd 'delivery)
         ;; Handle delivery
         (let quantity (nth msg 1)
           (registry-set! 'inventory 
             (+ (registry-get 'inventory) quantity))
           (list 'become '(storefront-init)))
-        (if (= cmd 'buy)
+        (if (equal? cmd 'buy)
           ;; Handle purchase
           (let customer (nth msg 1)
             (let want (nth msg 2)
@@ -98,7 +98,7 @@
 
 (define (customer-alice)
   (let msg (receive!)                          ; Guard
-    (if (= (first msg) 'go-shopping)
+    (if (equal? (first msg) 'go-shopping)
       (let want (+ 2 (rand 3))                 ; Want 2-4 loaves
         (send-to! 'storefront (list 'buy 'customer-alice want))
         (list 'become '(customer-wait-alice)))
@@ -110,7 +110,7 @@
 
 (define (customer-bob)
   (let msg (receive!)
-    (if (= (first msg) 'go-shopping)
+    (if (equal? (first msg) 'go-shopping)
       (let want (+ 1 (rand 4))                 ; Want 1-4 loaves
         (send-to! 'storefront (list 'buy 'customer-bob want))
         (list 'become '(customer-wait-bob)))
@@ -122,7 +122,7 @@
 
 (define (customer-carol)
   (let msg (receive!)
-    (if (= (first msg) 'go-shopping)
+    (if (equal? (first msg) 'go-shopping)
       (let want (+ 2 (rand 5))                 ; Want 2-6 loaves
         (send-to! 'storefront (list 'buy 'customer-carol want))
         (list 'become '(customer-wait-carol)))
@@ -138,7 +138,7 @@
 
 (define (day-controller)
   (let msg (receive!)                          ; Guard
-    (if (= msg 'tick)
+    (if (equal? msg 'tick)
       (let d (+ 1 (registry-get 'day))
         (registry-set! 'day d)
         ;; Start production
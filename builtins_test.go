@@ -0,0 +1,1473 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// evalLast evaluates every expression in code and returns the last result
+func evalLast(ev *Evaluator, code string) Value {
+	result := Nil()
+	for _, expr := range parseAll(NewParser(code)) {
+		result = ev.Eval(expr, ev.GlobalEnv)
+	}
+	return result
+}
+
+// ============================================================================
+// Hash Map Tests
+// ============================================================================
+
+func TestHashMapBuiltins(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	evalLast(ev, `
+		(define m (make-map 'alice 1))
+		(map-set! m 'bob 2)
+		(map-set! m "carol" 3)
+	`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(map-get m 'alice)`, "1"},
+		{`(map-get m 'bob)`, "2"},
+		{`(map-get m "carol")`, "3"},
+		{`(map-get m 'carol)`, "nil"},
+		{`(map-get m 'nobody 0)`, "0"},
+		{`(map-has? m 'bob)`, "true"},
+		{`(map-keys m)`, `("carol" alice bob)`},
+		{`(map? m)`, "true"},
+		{`(map-delete! m 'bob)`, "true"},
+		{`(map-has? m 'bob)`, "false"},
+		{`m`, `{"carol" 3 alice 1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAssocListBuiltins(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+
+	evalLast(ev, `
+		(assert! 'order 7 2)
+		(define row (first (query 'order '?id '?qty)))
+	`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`row`, "((id 7) (qty 2))"},
+		{`(assoc 'qty row)`, "(qty 2)"},
+		{`(assoc 'note row)`, "nil"},
+		{`(assoc "a" '(("a" 1) (a 2)))`, `("a" 1)`},
+		{`(assoc '(1 2) '(((1 2) pair)))`, "((1 2) pair)"},
+		{`(assoc-set 'qty 3 row)`, "((id 7) (qty 3))"},
+		{`(assoc-set 'note "rush" row)`, `((id 7) (qty 2) (note "rush"))`},
+		{`(assoc-set 'a 1 '())`, "((a 1))"},
+		{`row`, "((id 7) (qty 2))"},
+		{`(alist->map row)`, "{id 7 qty 2}"},
+		{`(map-get (alist->map '((a 1) (a 2))) 'a)`, "1"},
+		{`(alist->map '((a 1) (b)))`, "nil"},
+		{`(map->alist (make-map 'b 2 "a" 1))`, `(("a" 1) (b 2))`},
+		{`(map->alist (make-map))`, "()"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSetBuiltins(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	evalLast(ev, `
+		(define contacted (make-set 'bakery 'mill))
+		(set-add! contacted 'farm 'bakery)
+	`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`contacted`, "#{bakery farm mill}"},
+		{`(set-size contacted)`, "3"},
+		{`(set-member? contacted 'farm)`, "true"},
+		{`(set-member? contacted 'shop)`, "false"},
+		{`(set-member? (make-set 1 "1") 1)`, "true"},
+		{`(set-size (make-set 1 "1" 1))`, "2"},
+		{`(set-union contacted '(shop mill))`, "#{bakery farm mill shop}"},
+		{`(set-intersect contacted (make-set 'mill 'farm 'shop))`, "#{farm mill}"},
+		{`(set-intersect contacted '(mill) '(farm))`, "#{}"},
+		{`(set-difference contacted '(mill))`, "#{bakery farm}"},
+		{`(set->list (list->set '(3 1 2 1)))`, "(1 2 3)"},
+		{`(equal? (make-set 1 2) (list->set '(2 1)))`, "true"},
+		{`(equal? (make-set 1 2) (make-set 1))`, "false"},
+		{`(set-remove! contacted 'mill)`, "true"},
+		{`(set-remove! contacted 'mill)`, "false"},
+		{`(set? contacted)`, "true"},
+		{`(set? '(a))`, "false"},
+		{`contacted`, "#{bakery farm}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
+
+// ============================================================================
+// Warning Aggregation Tests
+// ============================================================================
+
+func TestWarningsAttributedToActor(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	evalLast(ev, `
+		(define (lost)
+		  (send-to! 'nobody 'hello)
+		  (send-to! 'nobody 'again)
+		  'done)
+		(spawn-actor 'sender 4 '(lost))
+		(run-scheduler 10)
+	`)
+
+	if len(ev.Warnings) != 1 {
+		t.Fatalf("expected 1 deduplicated warning, got %d: %v", len(ev.Warnings), ev.Warnings)
+	}
+	w := ev.Warnings[0]
+	if w.Actor != "sender" {
+		t.Errorf("expected actor sender, got %q", w.Actor)
+	}
+	if w.Count != 2 {
+		t.Errorf("expected count 2, got %d", w.Count)
+	}
+	if w.Message != "send-to!: unknown actor nobody" {
+		t.Errorf("unexpected message %q", w.Message)
+	}
+
+	ev.ResetWarnings()
+	evalLast(ev, `undefined-thing`)
+	if len(ev.Warnings) != 1 || ev.Warnings[0].Actor != "" {
+		t.Errorf("expected one top-level warning after reset, got %v", ev.Warnings)
+	}
+}
+
+func TestWarningsAreCapped(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	for i := 0; i < maxWarnings+5; i++ {
+		ev.warn("", "unkeyed %d", i)
+	}
+	if len(ev.Warnings) != maxWarnings || ev.WarningsDropped != 5 {
+		t.Errorf("kept %d warnings and dropped %d, want %d and 5", len(ev.Warnings), ev.WarningsDropped, maxWarnings)
+	}
+	ev.ResetWarnings()
+	if len(ev.Warnings) != 0 || ev.WarningsDropped != 0 {
+		t.Errorf("reset kept %d warnings and %d dropped", len(ev.Warnings), ev.WarningsDropped)
+	}
+}
+
+// ============================================================================
+// String Library Tests
+// ============================================================================
+
+func TestStringBuiltins(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(substring "hello world" 6)`, `"world"`},
+		{`(substring "hello world" 0 5)`, `"hello"`},
+		{`(substring "héllo" 1 2)`, `"é"`},
+		{`(substring "abc" 2 1)`, `""`},
+		{`(string-split "a,b,,c" ",")`, `("a" "b" "" "c")`},
+		{`(string-split "  order  42 ")`, `("order" "42")`},
+		{`(string-join '(a 1 "b") "-")`, `"a-1-b"`},
+		{`(string-replace "a.b.c" "." "/")`, `"a/b/c"`},
+		{`(string-upcase "Bread")`, `"BREAD"`},
+		{`(string-downcase "Bread")`, `"bread"`},
+		{`(string-contains? "sourdough" "dough")`, "true"},
+		{`(string-contains? "sourdough" "rye")`, "false"},
+		{`(string-length "héllo")`, "5"},
+		{`(string-index "héllo" "llo")`, "2"},
+		{`(string-index "hello" "z")`, "-1"},
+		{`(string->number "42")`, "42"},
+		{`(integer? (string->number "-7"))`, "true"},
+		{`(string->number "2.5e1")`, "25"},
+		{`(float? (string->number "2.5e1"))`, "true"},
+		{`(string->number "ff" 16)`, "255"},
+		{`(string->number "-101" 2)`, "-5"},
+		{`(string->number "12" 2)`, "nil"},
+		{`(string->number "1.5" 16)`, "nil"},
+		{`(string->number " 42")`, "nil"},
+		{`(string->number "42abc")`, "nil"},
+		{`(string->number "0x10")`, "nil"},
+		{`(string->number "inf")`, "nil"},
+		{`(string->number "")`, "nil"},
+		{`(string->number "10" 1)`, "nil"},
+		{`(string->number (number->string 9007199254740993))`, "9007199254740993"},
+		{`(regex-match "order-(\\d+)" "got order-42 ok")`, `("order-42" "42")`},
+		{`(regex-match "(a)|(b)" "b")`, `("b" nil "b")`},
+		{`(regex-match "^ack" "nack")`, "nil"},
+		{`(regex-match "(" "x")`, "nil"},
+		{`(regex-find-all "[a-z]+=\\d" "x=1 y=22 z=")`, `("x=1" "y=2")`},
+		{`(regex-find-all "\\d" "a1b2c3" 2)`, `("1" "2")`},
+		{`(regex-find-all "\\d" "none")`, "()"},
+		{`(regex-replace "(\\w+)@(\\w+)" "alice@shop bob@bank" "$2:$1")`, `"shop:alice bank:bob"`},
+		{`(regex-replace "\\s+" "a   b  c" " ")`, `"a b c"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCharBuiltins(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`#\a`, `#\a`},
+		{`(list #\space #\newline #\tab #\( #\;)`, `(#\space #\newline #\tab #\( #\;)`},
+		{`#\x3bb`, `#\λ`},
+		{`(number->char 7)`, `#\x7`},
+		{`(string->list "héllo")`, `(#\h #\é #\l #\l #\o)`},
+		{`(string->list "")`, `()`},
+		{`(list->string (list #\o #\k))`, `"ok"`},
+		{`(list->string (cons #\c (string->list "ab")))`, `"cab"`},
+		{`(list->string '(a b))`, `""`},
+		{`(char->number #\A)`, "65"},
+		{`(number->char 955)`, `#\λ`},
+		{`(number->char -1)`, "nil"},
+		{`(list (char? #\a) (char? "a") (string? #\a))`, "(true false false)"},
+		{`(list (equal? #\a #\a) (eq? #\a #\a) (equal? #\a "a"))`, "(true true false)"},
+		{`(sort (list #\c "b" #\a 'z 1))`, `(1 "b" #\a #\c z)`},
+		{`(string-append "x" #\= "1")`, `"x=1"`},
+		{`(str #\a)`, `"a"`},
+		{`(match #\y (#\n 'no) (#\y 'yes))`, "yes"},
+		{`(case #\b ((#\a #\b) 'ab) (else 'other))`, "ab"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTimeBuiltins(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+
+	before := time.Now()
+	now, millis := evalLast(ev, "(now)"), evalLast(ev, "(now-millis)")
+	after := time.Now()
+	if !now.IsInt || now.Int < before.Unix() || now.Int > after.Unix() {
+		t.Errorf("(now) = %s, want between %d and %d", now.String(), before.Unix(), after.Unix())
+	}
+	if !millis.IsInt || millis.Int < before.UnixMilli() || millis.Int > after.UnixMilli() {
+		t.Errorf("(now-millis) = %s, want between %d and %d", millis.String(), before.UnixMilli(), after.UnixMilli())
+	}
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(format-time 1760611200)`, `"2025-10-16T10:40:00Z"`},
+		{`(format-time 1760611200 'date)`, `"2025-10-16"`},
+		{`(format-time 1760611200 'datetime)`, `"2025-10-16 10:40:00"`},
+		{`(format-time (/ 1760611200123 1000) 'millis)`, `"2025-10-16T10:40:00.123Z"`},
+		{`(format-time 1760611200 "Jan 2 15:04")`, `"Oct 16 10:40"`},
+		{`(format-time 0 'time)`, `"00:00:00"`},
+		{`(format-time 0 'fortnight)`, "nil"},
+		{`(format-time "today")`, `#error{(format-time "a number for argument 1" "today")}`},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+}
+
+// ============================================================================
+// Higher-Order List Function Tests
+// ============================================================================
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(map (lambda (x) (* x 2)) '(1 2 3))`, "(2 4 6)"},
+		{`(map + '(1 2 3) '(10 20))`, "(11 22)"},
+		{`(map first '())`, "()"},
+		{`(filter (lambda (x) (> x 2)) '(1 2 3 4))`, "(3 4)"},
+		{`(reduce + '(1 2 3 4))`, "10"},
+		{`(reduce + '())`, "nil"},
+		{`(reduce + 100 '(1 2))`, "103"},
+		{`(fold-left (lambda (acc x) (cons x acc)) '() '(1 2 3))`, "(3 2 1)"},
+		{`(fold (lambda (acc x) (- acc x)) 10 '(1 2))`, "7"},
+		{`(fold-right cons '() '(1 2 3))`, "(1 2 3)"},
+		{`(fold-right (lambda (x acc) (- x acc)) 0 '(1 2 3))`, "2"},
+		{`(begin (define total 0) (for-each (lambda (x) (set! total (+ total x))) '(1 2 3)) total)`, "6"},
+		{`(apply + '(1 2 3))`, "6"},
+		{`(apply + '())`, "0"},
+		{`(apply list 'a 'b '(c d))`, "(a b c d)"},
+		{`(apply (lambda (x (y 5)) (list x y)) '(1))`, "(1 5)"},
+		{`(begin (define (deposit n) (list 'deposited n)) (define msg '(deposit 10)) (apply (eval (first msg)) (rest msg)))`, "(deposited 10)"},
+		{`(apply 3 '(1))`, "nil"},
+		{`(apply + 1)`, "nil"},
+		{`((partial + 1 2) 10)`, "13"},
+		{`((partial list 'a))`, "(a)"},
+		{`(map (partial * 2) '(1 2 3))`, "(2 4 6)"},
+		{`(begin (define (vol l w h) (* l w h)) (define cv (curry vol)) (list (((cv 2) 3) 4) ((cv 2 3) 4) (cv 2 3 4)))`, "(24 24 24)"},
+		{`(((curry + 2) 1) 2)`, "3"},
+		{`(curry (lambda () 'now))`, "<function>"},
+		{`(curry +)`, "nil"},
+		{`(partial 3)`, "nil"},
+		{`(begin (define calls 0) (define sq (memoize (lambda (x) (set! calls (+ calls 1)) (* x x)))) (list (sq 3) (sq 3) (sq 4) (sq 3.0) calls))`, "(9 9 16 9 2)"},
+		{`(begin (define calls 0) (define pair (memoize (lambda (a b) (set! calls (+ calls 1)) (list a b)))) (pair '(x) "y") (pair '(x) "y") (pair 'x "y") calls)`, "2"},
+		{`(begin (define calls 0) (define id (memoize (lambda (x) (set! calls (+ calls 1)) x) 1)) (id 'a) (id 'b) (id 'a) (id 'b) calls)`, "3"},
+		{`(begin (define fib (memoize (lambda (n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2))))))) (fib 60))`, "1548008755920"},
+		{`((memoize +) 1 2)`, "3"},
+		{`(memoize + 0)`, "nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+
+	// Lists far longer than the call stack
+	small := NewEvaluator(64)
+	result := evalLast(small, `
+		(define (build n acc) (if (= n 0) acc (build (- n 1) (cons n acc))))
+		(define xs (build 5000 '()))
+		(reduce + (filter (lambda (x) (= (mod x 2) 0)) (map (lambda (x) (* x 1)) xs)))
+	`)
+	if result.String() != "6252500" {
+		t.Errorf("expected 6252500 on a 5000 element list, got %s", result.String())
+	}
+}
+
+func TestSortBuiltins(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(sort '(3 1 2))`, "(1 2 3)"},
+		{`(sort '("pear" "apple"))`, `("apple" "pear")`},
+		{`(sort '(b 2 "a" a 1))`, `(1 2 "a" a b)`},
+		{`(sort '((2 a) (1 b) (1 a)))`, "((1 a) (1 b) (2 a))"},
+		{`(sort '())`, "()"},
+		{`(sort-by '(1 3 2) >)`, "(3 2 1)"},
+		{`(sort-by '((bob 3) (amy 1) (cy 3)) (lambda (a b) (< (nth a 1) (nth b 1))))`, "((amy 1) (bob 3) (cy 3))"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+
+	// The input list is not modified
+	if got := evalLast(ev, `(define xs '(2 1)) (sort xs) xs`).String(); got != "(2 1)" {
+		t.Errorf("sort mutated its argument: %s", got)
+	}
+
+	// Lists compare by their sign, however much longer one is
+	if c := compareValues(Lst(Int(1), Int(2), Int(3)), Lst(Int(1))); c != 1 {
+		t.Errorf("longer list compared as %d, want 1", c)
+	}
+	if c := compareValues(Lst(), Lst(Int(1), Int(2))); c != -1 {
+		t.Errorf("shorter list compared as %d, want -1", c)
+	}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(+ 9007199254740993 1)`, "9007199254740994"},
+		{`(- 9007199254740993 9007199254740992)`, "1"},
+		{`(* 3037000499 3037000499)`, "9223372030926249001"},
+		{`(* 9223372036854775807 2)`, "1.8446744073709552e+19"}, // overflow promotes
+		{`(+ 1 0.5)`, "1.5"},
+		{`(/ 6 3)`, "2"},
+		{`(/ 1 2)`, "0.5"},
+		{`(mod 9007199254740993 10)`, "3"},
+		{`(mod -7 3)`, "-1"},
+		{`(mod 7.5 2)`, "1.5"},
+		{`(= 9007199254740993 9007199254740992)`, "false"},
+		{`(< 9007199254740992 9007199254740993)`, "true"},
+		{`(= 2 2.0)`, "true"},
+		{`(integer? 2)`, "true"},
+		{`(integer? 2.0)`, "false"},
+		{`(integer? (/ 6 3))`, "true"},
+		{`(float? (float 2))`, "true"},
+		{`(integer? (floor 2.5))`, "true"},
+		{`(integer? (length '(a b)))`, "true"},
+		{`(max 1 9007199254740993 2)`, "9007199254740993"},
+		{`(abs -9007199254740993)`, "9007199254740993"},
+		{`(number->string 9007199254740993)`, `"9007199254740993"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEquality(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	evalLast(ev, `
+		(define xs (list 1 2))
+		(define s (make-stack 4))
+		(define q (make-queue 4))
+		(define m (make-map))
+	`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(= 1 1.0 1)`, "true"},
+		{`(= 1 1 2)`, "false"},
+		{`(!= 1 2)`, "true"},
+		{`(equal? 'ping 'ping)`, "true"},
+		{`(equal? '(a (b "c")) '(a (b "c")))`, "true"},
+		{`(equal? '(a (b "c")) '(a (b c)))`, "false"},
+		{`(equal? (tag 'order '(1)) (tag 'order '(1)))`, "true"},
+		{`(equal? (tag 'order '(1)) (tag 'refund '(1)))`, "false"},
+		{`(equal? s s)`, "true"},
+		{`(equal? s (make-stack 4))`, "false"},
+		{`(equal? m (make-map))`, "true"},
+		{`(equals 1 1)`, "true"},
+		{`(eq? 'ping 'ping)`, "true"},
+		{`(eq? "a" "a")`, "true"},
+		{`(eq? 2 2.0)`, "true"},
+		{`(eq? xs xs)`, "true"},
+		{`(eq? xs (list 1 2))`, "false"},
+		{`(eq? '() '())`, "true"},
+		{`(eq? s s)`, "true"},
+		{`(eq? q q)`, "true"},
+		{`(eq? s q)`, "false"},
+		{`(eq? m (make-map))`, "false"},
+		{`(eq? car car)`, "true"},
+		{`(eq? car cdr)`, "false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+
+	// = on anything but numbers is a type error, not a quiet false
+	for _, tt := range []struct{ code, warning string }{
+		{`(= 'ping 'ping)`, "=: expected numbers"},
+		{`(= s s)`, "=: expected numbers"},
+		{`(!= "a" "b")`, "!=: expected numbers"},
+	} {
+		ev.ResetWarnings()
+		evalLast(ev, tt.code)
+		if w := lastWarning(ev, tt.warning); w == nil || len(w.Stack) == 0 {
+			t.Errorf("%s: no type error with a trace", tt.code)
+		}
+	}
+	if got := evalLast(ev, `(= 1 'ping)`).String(); got != `#error{(= "a number for argument 2" ping)}` {
+		t.Errorf("(= 1 'ping) = %s, want an error value", got)
+	}
+	if got := evalLast(ev, `(error? (!= 'ping 'pong))`).String(); got != "true" {
+		t.Errorf("(!= 'ping 'pong) should be an error value, got %s", got)
+	}
+}
+
+func TestFileBuiltins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.txt")
+	quoted := strconv.Quote(path)
+
+	// Off unless the evaluator allows it
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	if got := evalLast(ev, `(write-file `+quoted+` "x")`).String(); got != "nil" {
+		t.Errorf("write-file without file access = %s, want nil", got)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Fatalf("write-file wrote without file access")
+	}
+
+	ev = NewEvaluator(1000)
+	ev.FileAccess = true
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(file-exists? ` + quoted + `)`, "false"},
+		{`(write-file ` + quoted + ` "sales " 3 "\n")`, "true"},
+		{`(append-file ` + quoted + ` "done")`, "true"},
+		{`(read-file ` + quoted + `)`, `"sales 3\ndone"`},
+		{`(file-exists? ` + quoted + `)`, "true"},
+		{`(read-file "` + filepath.Join(t.TempDir(), "missing") + `")`, "nil"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+}
+
+func TestCallStackExhaustion(t *testing.T) {
+	ev := NewEvaluator(16)
+	ev.Quiet = true
+	runCode(ev, `(define (count-down n) (if (= n 0) 0 (+ 1 (count-down (- n 1)))))`)
+
+	result := evalLast(ev, `(+ 1 (count-down 20))`)
+	if result.Type != TypeBlocked || result.Blocked.Reason != BlockCallStackFull {
+		t.Fatalf("expected a call stack full block, got %s", result.String())
+	}
+	chain := result.Blocked.Resource.(Value)
+	if len(chain.List) != 17 || chain.List[0].String() != "(count-down 20)" || chain.List[16].String() != "(count-down 4)" {
+		t.Errorf("unexpected call chain %s", chain.String())
+	}
+	if len(ev.Warnings) != 1 || len(ev.Warnings[0].Stack) != 17 ||
+		!strings.Contains(ev.Warnings[0].Message, "count-down x17") {
+		t.Errorf("expected one warning with the call chain, got %+v", ev.Warnings)
+	}
+	if got := evalLast(ev, `(fact-count 'call-stack-full)`).String(); got != "1" {
+		t.Errorf("expected one call-stack-full fact, got %s", got)
+	}
+	if got := evalLast(ev, `(eventually? '(call-stack-full external count-down 16))`).String(); got != "true" {
+		t.Errorf("expected (call-stack-full external count-down 16)")
+	}
+
+	// Raising the bound lets the same call finish
+	if got := evalLast(ev, `(set-call-stack-depth! 32)`).String(); got != "16" {
+		t.Errorf("expected previous depth 16, got %s", got)
+	}
+	if got := evalLast(ev, `(count-down 20)`).String(); got != "20" {
+		t.Errorf("expected 20 with a deeper stack, got %s", got)
+	}
+
+	// Past the limit the depth is clamped, so deep recursion can't
+	// overflow the Go stack
+	evalLast(ev, `(set-call-stack-depth! 100000000)`)
+	if got := evalLast(ev, `(set-call-stack-depth! 32)`).String(); got != strconv.Itoa(maxCallStackDepth) {
+		t.Errorf("expected the depth clamped to %d, got %s", maxCallStackDepth, got)
+	}
+
+	// An actor that runs out of stack is blocked with the reason
+	runCode(ev, `
+		(define (deep) (count-down 100) 'done)
+		(spawn-actor 'deep 4 '(deep))
+	`)
+	if got := evalLast(ev, `(run-scheduler 10)`).String(); got != `(deadlock 1 ((deep "call stack full in count-down")))` {
+		t.Errorf("unexpected run result %s", got)
+	}
+}
+
+func TestStackDepthFlag(t *testing.T) {
+	defer func() { callStackDepth = defaultCallStackDepth }()
+	parse := func(args ...string) ([]string, error) {
+		callStackDepth = defaultCallStackDepth
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		stackDepthFlag(fs)
+		fs.Bool("repl", false, "")
+		fs.Int64("fuel", 0, "")
+		err := fs.Parse(args)
+		return fs.Args(), err
+	}
+	tests := []struct {
+		args  []string
+		rest  string
+		depth int
+	}{
+		{[]string{"spec.lisp"}, "spec.lisp", defaultCallStackDepth},
+		{[]string{"-stack-depth", "512", "run", "spec.lisp"}, "run spec.lisp", 512},
+		{[]string{"--stack-depth=300", "--fuel", "9", "spec.lisp"}, "spec.lisp", 300},
+		{[]string{"-repl", "-stack-depth=8"}, "", 8},
+	}
+	for _, tt := range tests {
+		rest, err := parse(tt.args...)
+		if err != nil || strings.Join(rest, " ") != tt.rest || callStackDepth != tt.depth {
+			t.Errorf("%v: got %v, depth %d, %v; want %s, depth %d", tt.args, rest, callStackDepth, err, tt.rest, tt.depth)
+		}
+	}
+	for _, bad := range [][]string{{"-stack-depth"}, {"-stack-depth", "0", "x.lisp"}, {"-stack-depth=deep"}, {"-stack-depth", "100000000"}} {
+		if _, err := parse(bad...); err == nil {
+			t.Errorf("%v: no error", bad)
+		}
+	}
+}
+
+func TestMessageSizeAccounting(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(message-size "hello")`, "5"},
+		{`(message-size '(order "ab" 3))`, "15"},
+		{`(message-size nil)`, "1"},
+		{`(define q (make-queue 4 'link :bytes 10))`, "<queue 0/4>"},
+		{`(send! q "12345678")`, "ok"},
+		{`(send-now! q "abc")`, "full"},
+		{`(mailbox-bytes q)`, "8"},
+		{`(send! q "this will never fit")`, "oversized"},
+		{`(eventually? '(oversized external link 19 10))`, "true"},
+		{`(recv! q)`, `"12345678"`},
+		{`(send! q "abc")`, "ok"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+
+	// A sender blocked for bytes runs again once the receiver makes room
+	runCode(ev, `
+		(spawn-actor 'producer 4 '(begin (send-to! 'sink "abcdefgh") 'done))
+		(spawn-actor 'sink 4 '(begin (receive!) 'done) :bytes 10)
+		(send-to! 'sink "0123456789")
+	`)
+	if got := evalLast(ev, `(mailbox-bytes 'sink)`).String(); got != "10" {
+		t.Errorf("expected 10 bytes waiting, got %s", got)
+	}
+	runCode(ev, `(run-scheduler 20)`)
+	for name, actor := range ev.Scheduler.Actors {
+		if actor.State != ActorDone {
+			t.Errorf("actor %s not done: %s", name, actor.BlockedOn)
+		}
+	}
+	if got := evalLast(ev, `(mailbox-bytes 'sink)`).String(); got != "8" {
+		t.Errorf("expected the producer's 8 bytes delivered, got %s", got)
+	}
+	if got := evalLast(ev, `(send-to! 'sink (list "a" "b" "c" "d" "e" "f" "g" "h" "i" "j" "k"))`).String(); got != "oversized" {
+		t.Errorf("expected oversized, got %s", got)
+	}
+}
+
+func TestMessagePriority(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	got := evalLast(ev, `
+		(define got '())
+		(define (worker) (set! got (cons (receive!) got)) (list 'become '(worker)))
+		(spawn-actor 'worker 8 '(worker))
+		(send-to! 'worker '(data 1))
+		(send-to! 'worker '(data 2))
+		(send-to! 'worker 'shutdown :priority 10)
+		(send-to! 'worker '(data 3))
+		(send-to! 'worker 'reconfigure :priority 5)
+		(send-to! 'worker 'urgent :priority 10)
+		(send-to! 'worker 'later :priority -1)
+		(send-to! 'worker '(data 4))
+		(run-scheduler 20)
+		got`)
+	want := "(later (data 4) (data 3) (data 2) (data 1) reconfigure urgent shutdown)"
+	if got.String() != want {
+		t.Errorf("received %s (latest first), want %s", got.String(), want)
+	}
+
+	// A full mailbox still blocks a high-priority send
+	if got := evalLast(ev, `
+		(spawn-actor 'tiny 1 ''yield)
+		(send-to! 'tiny 'bulk)
+		(send-to! 'tiny 'stop :priority 9)`).Type; got != TypeBlocked {
+		t.Errorf("expected a send to a full mailbox to block, got %v", got)
+	}
+}
+
+// ============================================================================
+// Parameter Tests
+// ============================================================================
+
+func TestSpawnWithFunction(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	got := evalLast(ev, `
+		(define shelf (make-stack 8))
+		(define (producer s items) (for-each (lambda (x) (push-now! s x)) items) 'done)
+		(define (make-counter) (let ((n 0)) (lambda () (set! n (+ n 1)) (push-now! shelf n) (if (< n 2) 'yield 'done))))
+		(spawn-actor 'p 4 producer shelf '(bread cake))
+		(spawn-actor 'c 4 (make-counter))
+		(list (run-scheduler 20) (pop-now! shelf) (pop-now! shelf) (pop-now! shelf) (pop-now! shelf))`)
+	if want := "((completed 3) 2 1 cake bread)"; got.String() != want {
+		t.Errorf("got %s, want %s", got.String(), want)
+	}
+
+	evalLast(ev, "(spawn-actor 'q 4 '(producer) 5)")
+	if lastWarning(ev, "spawn-actor: arguments given with quoted code (producer)") == nil {
+		t.Errorf("expected a warning about arguments to quoted code, got %v", ev.Warnings)
+	}
+}
+
+func TestAnonymousSpawn(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (customer n) (send-to! 'shop (list 'order (self) n)) 'done)
+		(define (shop) (let ((m (receive!))) (assert! 'ordered (car (cdr m)) (car (cdr (cdr m)))) (list 'become '(shop))))
+		(spawn-actor 'shop 8 '(shop))
+		(spawn-actor 'actor-2 4 ''done)
+		(define customers (map (lambda (n) (spawn 4 customer n)) '(1 2)))
+		(define waiter (spawn 4 (lambda () (receive!) 'done)))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"customers", "(<actor:actor-1> <actor:actor-3>)"},
+		{"(list (equal? waiter waiter) (equal? waiter (car customers)) (actor-state waiter))", `(true false (runnable "" 0 4))`},
+		{"(run-scheduler 30) (query 'ordered '?c '?n)", "(((c actor-1) (n 1)) ((c actor-3) (n 2)))"},
+		{"(send-to! waiter 'go) (run-scheduler 10) (actor-state waiter)", `(done "" 0 4)`},
+		{"(spawn-actor 'starter 4 '(begin (spawn 4 ''done) (receive!) 'done)) (run-scheduler 5) (send-to! 'starter 'go) (run-scheduler 5) (list-actors-sched)",
+			"(actor-1 actor-2 actor-3 actor-4 actor-5 shop starter)"},
+		{"(spawn 4)", "nil"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	if lastWarning(ev, "spawn: need mailbox-size, body") == nil {
+		t.Errorf("expected a warning for spawn without a body, got %v", ev.Warnings)
+	}
+}
+
+func TestActorIntrospection(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(spawn-actor 'w 4 '(begin (receive!) 'done) :bytes 100)
+		(send-to! 'w "hi")`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(list (whereis 'w) (actor-exists? 'w) (actor-result 'w))", "(<actor:w> true nil)"},
+		{"(actor-mailbox-size 'w)", "{byte-capacity 100 bytes 2 capacity 4 messages 1}"},
+		{"(list (whereis 'nobody) (actor-exists? 'nobody) (actor-mailbox-size 'nobody) (actor-result 'nobody))", "(nil false nil nil)"},
+		{"(run-scheduler 10) (list (whereis 'w) (actor-exists? (spawn 4 ''done)) (actor-result 'w))", "(nil true done)"},
+		{"(actor-mailbox-size (whereis 'actor-1))", "{byte-capacity 0 bytes 0 capacity 4 messages 0}"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+}
+
+func TestBecomeClosure(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	got := evalLast(ev, `
+		(define q (make-queue 8))
+		(define (counter n)
+		  (lambda ()
+		    (send-now! q n)
+		    (if (< n 3) (become (counter (+ n 1))) 'done)))
+		(define (relay out)
+		  (let ((m (receive!)))
+		    (list 'become (lambda () (send-now! out m) (become relay out)))))
+		(spawn-actor 'c 4 (counter 1))
+		(spawn-actor 'r 4 relay q)
+		(send-to! 'r 'hello)
+		(list (run-scheduler 30) (recv-now! q) (recv-now! q) (recv-now! q) (recv-now! q))`)
+	if want := "((deadlock 6 ((r \"recv (empty)\"))) 1 2 hello 3)"; got.String() != want {
+		t.Errorf("got %s, want %s", got.String(), want)
+	}
+	if got := evalLast(ev, "(query 'state-change 'r '?from '?to)").String(); got != "(((from relay) (to lambda)) ((from lambda) (to relay)))" {
+		t.Errorf("state changes = %s", got)
+	}
+}
+
+func TestOptionalAndKeywordParams(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	runCode(ev, `
+		(define (shop name (mailbox 16) &key (stock 10) verbose)
+		  (list name mailbox stock verbose))
+		(define (scale x (factor (* x 2))) (* x factor))
+		(define (log-all level &key tag . msgs) (list level tag msgs))
+	`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(shop 'bakery)`, "(bakery 16 10 nil)"},
+		{`(shop 'bakery 4)`, "(bakery 4 10 nil)"},
+		{`(shop 'bakery :stock 3)`, "(bakery 16 3 nil)"},
+		{`(shop 'bakery 4 :verbose true :stock 0)`, "(bakery 4 0 true)"},
+		{`(scale 3)`, "18"}, // default sees earlier params
+		{`(scale 3 10)`, "30"},
+		{`((lambda (a (b 5)) (+ a b)) 1)`, "6"},
+		{`((lambda (&key (n 1)) n) :n 7)`, "7"},
+		{`(log-all 'warn "a" :tag 'db "b")`, `(warn db ("a" "b"))`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
+
+// ============================================================================
+// Tail Call Tests
+// ============================================================================
+
+func TestAutomaticTailCalls(t *testing.T) {
+	// Small call stack: only tail calls can recurse this deep
+	ev := NewEvaluator(16)
+
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{"if", `(define (f n) (if (= n 0) 'done (f (- n 1)))) (f 10000)`, "done"},
+		{"cond", `(define (g n) (cond ((= n 0) 'done) (else (g (- n 1))))) (g 10000)`, "done"},
+		{"begin", `(define (h n) (begin 1 (if (= n 0) 'done (h (- n 1))))) (h 10000)`, "done"},
+		{"let", `(define (k n acc) (if (= n 0) acc (let m (- n 1) (k m (+ acc 1))))) (k 10000 0)`, "10000"},
+		{"mutual", `(define (ev? n) (if (= n 0) true (od? (- n 1))))
+		            (define (od? n) (if (= n 0) false (ev? (- n 1))))
+		            (ev? 10001)`, "false"},
+		{"loop", `(loop ((i 0) (acc 0)) (if (= i 10000) acc (recur (+ i 1) (+ acc 1))))`, "10000"},
+		{"loop in a function", `(define (count-to n) (loop ((i 0)) (if (= i n) i (recur (+ i 1))))) (list (count-to 10000) (count-to 20000))`, "(10000 20000)"},
+		{"nested loop", `(loop ((i 0) (total 0))
+		                  (if (= i 100) total
+		                    (recur (+ i 1) (+ total (loop ((j 0)) (if (= j i) j (recur (+ j 1))))))))`, "4950"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+
+	// Non-tail recursion is still bounded by the call stack
+	result := evalLast(ev, `(define (deep n) (if (= n 0) 0 (+ 1 (deep (- n 1))))) (deep 100)`)
+	if result.String() == "100" {
+		t.Errorf("non-tail recursion should not exceed the call stack bound")
+	}
+}
+
+func TestWhileAndDotimes(t *testing.T) {
+	ev := NewEvaluator(16)
+	ev.Quiet = true
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(define n 0) (list (while (< n 5) (set! n (+ n 1))) n)`, "(nil 5)"},
+		{`(while false (car))`, "nil"},
+		{`(define acc '()) (list (dotimes (i 4) (set! acc (cons i acc))) acc)`, "(nil (3 2 1 0))"},
+		{`(define acc '()) (dotimes (i 3 (list i acc)) (set! acc (cons i acc)))`, "(3 (2 1 0))"},
+		{`(dotimes (i 0 'none))`, "none"},
+		{`(dotimes (i 'x) 1)`, "nil"},
+		// Far more iterations than the call stack has frames
+		{`(define total 0) (dotimes (i 10000) (set! total (+ total i))) total`, "49995000"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+
+	// A body that blocks stops the loop and the actor's step, as a
+	// blocked argument stops a call
+	ev = NewEvaluator(1000)
+	evalLast(ev, `
+		(define (collector)
+		  (dotimes (i 3) (assert! 'got i (receive!)))
+		  (done!))
+		(define (spawner)
+		  (dotimes (i 3) (spawn-actor (string->symbol (string-append "sender-" (number->string i))) 2 (list 'sender i)))
+		  (done!))
+		(define (sender i) (send-to! 'collector i) (done!))
+		(spawn-actor 'collector 4 '(collector))
+		(spawn-actor 'spawner 4 '(spawner))
+		(run-scheduler 50)`)
+	if got := evalLast(ev, `(query 'got '?i '?m)`).String(); got != "(((i 0) (m 0)) ((i 1) (m 1)) ((i 2) (m 2)))" {
+		t.Errorf("the collector got %s", got)
+	}
+	if got := evalLast(ev, `(list-actors-sched)`).String(); got != "(collector sender-0 sender-1 sender-2 spawner)" {
+		t.Errorf("actors %s, want three senders", got)
+	}
+}
+
+func TestWhenAndUnless(t *testing.T) {
+	ev := NewEvaluator(16)
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(define n 0) (list (when (> 1 0) (set! n 1) 'yes) n)`, "(yes 1)"},
+		{`(when false (car))`, "nil"},
+		{`(when true)`, "nil"},
+		{`(unless false 'ran)`, "ran"},
+		{`(unless 1 'ran)`, "nil"},
+		// Bodies are in tail position
+		{`(define (down n) (when (> n 0) (down (- n 1)))) (down 10000)`, "nil"},
+		{`(define (down2 n) (unless (= n 0) (down2 (- n 1)))) (list (down2 10000) (down2 10000))`, "(nil nil)"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+
+	// A test that blocks blocks the actor rather than skipping the body
+	ev = NewEvaluator(1000)
+	evalLast(ev, `
+		(define (waiter) (when (receive!) (assert! 'woke (self))) (done!))
+		(spawn-actor 'waiter 4 '(waiter))
+		(run-scheduler 5)
+		(send-to! 'waiter 'go)
+		(run-scheduler 5)`)
+	if got := evalLast(ev, `(query 'woke '?a)`).String(); got != "(((a waiter)))" {
+		t.Errorf("woke facts = %s", got)
+	}
+}
+
+func TestCaseForm(t *testing.T) {
+	ev := NewEvaluator(1000)
+	evalLast(ev, `
+		(define (handle msg)
+		  (case (nth msg 0)
+		    (('delivery) (list 'got (nth msg 1)))
+		    ((buy sell) 'trade)
+		    (("x" 3) 'literal)
+		    (ping 'pong)
+		    (((a b)) 'pair)
+		    (else 'unknown)))`)
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(map handle '((delivery 5) (buy) (sell) ("x") (3) (ping) ((a b)) (nope)))`, "((got 5) trade trade literal literal pong pair unknown)"},
+		{`(handle '(delivery 6))`, "(got 6)"},
+		{`(case 'zzz ((a) 1))`, "nil"},
+		{`(case 2 ((1) 'one) ((2) (define seen 'two) seen))`, "two"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+}
+
+func TestDestructure(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(destructure (a b c) '(1 2 3) (list c b a))`, "(3 2 1)"},
+		{`(destructure (kind . args) '(order 7 2) (list kind args))`, "(order (7 2))"},
+		{`(destructure (kind . args) '(ping) args)`, "()"},
+		{`(destructure (op (x y) _ z) '(move (1 2) skipped 3) (list op x y z))`, "(move 1 2 3)"},
+		{`(destructure (a b) '(1) (list a b))`, "(1 nil)"},
+		{`(destructure (a) '(1 2 3) a)`, "1"},
+		{`(destructure msg '(a b) msg)`, "(a b)"},
+		{`(destructure (a b) '(1 2) (define total (+ a b)) (* total 10))`, "30"},
+		{`(destructure (a (b c)) '(1 2) (list a b c))`, "nil"},
+		{`(let a 'outer (list (destructure (a) '(inner) a) a))`, "(inner outer)"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	if w := lastWarning(ev, "destructure: expected a list for (b c), got 2"); w == nil {
+		t.Errorf("no warning for a list pattern meeting a number")
+	}
+}
+
+// ============================================================================
+// Capability Tests
+// ============================================================================
+
+func TestCapabilitiesGateSharedResources(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	runCode(ev, `
+		(capability-mode! true)
+		(registry-set! 'ledger 100)
+		(grant 'auditor (cap 'ledger 'read))
+		(grant 'teller (cap 'ledger 'write))
+
+		(define (audit) (registry-set! 'seen (registry-get 'ledger)) 'done)
+		(define (teller) (registry-set! 'ledger 90) (registry-get 'ledger) 'done)
+
+		(spawn-actor 'auditor 4 '(audit))
+		(spawn-actor 'teller 4 '(teller))
+		(run-scheduler 10)
+	`)
+
+	if got := evalLast(ev, `(registry-get 'ledger)`).String(); got != "90" {
+		t.Errorf("teller write should succeed, ledger = %s", got)
+	}
+	if got := evalLast(ev, `(registry-has? 'seen)`).String(); got != "false" {
+		t.Errorf("auditor has no write cap on 'seen")
+	}
+
+	// Only the auditor reads the ledger: teller's read is a violation
+	if got := evalLast(ev, `(query 'cap-violation '?who 'ledger 'read)`).String(); !strings.Contains(got, "teller") {
+		t.Errorf("expected teller read violation, got %s", got)
+	}
+	if got := evalLast(ev, `(query 'accessed 'auditor 'ledger 'read)`).String(); got == "()" {
+		t.Errorf("expected auditor read access fact")
+	}
+
+	evalLast(ev, `(revoke! 'teller (cap 'ledger 'write))`)
+	if got := evalLast(ev, `(capabilities 'teller)`).String(); got != "()" {
+		t.Errorf("expected no caps after revoke, got %s", got)
+	}
+}
+
+func TestCapabilitiesNamedStack(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	runCode(ev, `
+		(capability-mode! true)
+		(define vault (make-stack 4 'vault))
+		(define (thief) (registry-set! 'loot (push-now! vault 'gold)) 'done)
+		(spawn-actor 'thief 4 '(thief))
+		(run-scheduler 10)
+	`)
+	if got := evalLast(ev, `(stack-empty? vault)`).String(); got != "true" {
+		t.Errorf("push without capability should be denied")
+	}
+}
+
+// ============================================================================
+// Module Tests
+// ============================================================================
+
+func TestModulesAndImport(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	runCode(ev, `
+		(module math
+		  (export mean)
+		  (define (sum xs) (if (empty? xs) 0 (+ (first xs) (sum (rest xs)))))
+		  (define (mean xs) (/ (sum xs) (length xs))))
+
+		(define (sum xs) 'global-sum)
+	`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(math/mean '(1 2 3 6))`, "3"},
+		{`(sum '(1 2))`, "global-sum"}, // module define did not clobber global
+		{`math/sum`, "nil"},            // not exported
+		{`(import math)`, "(mean)"},
+		{`(mean '(2 4))`, "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+
+	// Import inside a function body stays local
+	result := evalLast(ev, `
+		(module util (define (twice x) (* 2 x)))
+		(define (use-util y) (import util) (twice y))
+		(use-util 21)
+	`)
+	if result.String() != "42" {
+		t.Errorf("expected 42, got %s", result.String())
+	}
+	if _, ok := ev.GlobalEnv.bindings["twice"]; ok {
+		t.Errorf("import inside function leaked twice into globals")
+	}
+}
+
+// ============================================================================
+// Cost Tests
+// ============================================================================
+
+func TestActionCosts(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	runCode(ev, `
+		(set-cost! 'send-to! 2)
+		(set-cost! 'message-size 0.5)
+		(set-budget! 'chatty 5)
+
+		(define (quiet) (send-to! 'sink 'a) 'done)
+		(define (chatty) (send-to! 'sink 'bb) (send-to! 'sink 'cc) 'done)
+		(define (sink) 'done)
+
+		(spawn-actor 'sink 8 '(sink))
+		(spawn-actor 'quiet 4 '(quiet))
+		(spawn-actor 'chatty 4 '(chatty))
+		(run-scheduler 20)
+	`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(total-cost 'quiet)`, "2.5"}, // 2 + 0.5*1
+		{`(total-cost 'chatty)`, "6"},  // 2*(2 + 0.5*2)
+		{`(total-cost)`, "8.5"},
+		{`(cost-report)`, "((chatty 6) (quiet 2.5))"},
+		{`(sum-facts 'cost 2)`, "8.5"},
+		{`(never? '(over-budget quiet ?c ?b))`, "true"},
+		{`(never? '(over-budget chatty ?c ?b))`, "false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
+
+// ============================================================================
+// Parse Error Tests
+// ============================================================================
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected []string
+	}{
+		{"balanced", "(define x 1)\n(+ x 2)", nil},
+		{"unclosed", "(define x 1)\n(define (f y)\n  (+ y 1)", []string{"line 2, col 1: unclosed '('"}},
+		{"stray close", "(+ 1 2))", []string{"line 1, col 8: unexpected ')'"}},
+		{"unterminated string", "(print \"hello)", []string{"line 1, col 1: unclosed '('", "line 1, col 8: unterminated string"}},
+		{"dangling quote", "(list 'a ')", []string{"line 1, col 10: quote with nothing to quote"}},
+		{"dangling unquote", "`(a ,)", []string{"line 1, col 5: unquote with nothing to quote"}},
+		{"utf8 columns", "(print \"é\") )", []string{"line 1, col 13: unexpected ')'"}},
+		{"unknown char", "(list #\\a #\\bell)", []string{"line 1, col 11: unknown character #\\bell"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := NewParser(tt.code).Parse()
+			var got []string
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+			if strings.Join(got, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+
+	// Well-formed expressions before the error are still recovered
+	exprs, errs := NewParser("(define x 1) (foo").Parse()
+	if len(exprs) != 2 || len(errs) != 1 {
+		t.Errorf("expected 2 exprs and 1 error, got %d and %d", len(exprs), len(errs))
+	}
+}
+
+func TestQuasiquote(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `(define n 4) (define xs (list 1 2))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"`(become (counter ,(+ n 1)))", "(become (counter 5))"},
+		{"`(a ,@xs b)", "(a 1 2 b)"},
+		{"`(a ,@nil b)", "(a b)"},
+		{"`(,@xs)", "(1 2)"},
+		{"`sym", "sym"},
+		{"`(a (b ,n) ,'c)", "(a (b 4) c)"},
+		{"`(1 `(2 ,(3 ,n)))", "(1 (quasiquote (2 (unquote (3 4)))))"},
+		{"'(a,b)", "(a (unquote b))"},
+		{"(unquote n)", "nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+
+	// Actor continuations built with quasiquote
+	runCode(ev, `
+		(define (counter k) (if (< k 3) `+"`"+`(become (counter ,(+ k 1))) 'done))
+		(spawn-actor 'counter 4 '(counter 0))
+		(run-scheduler 10)
+	`)
+	if actor := ev.Scheduler.GetActor("counter"); actor.State != ActorDone {
+		t.Errorf("expected counter to finish, state %v", actor.State)
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	spec := `
+		(define retries 0)
+		(when-feature 'retries
+		  (define retries 3))
+		(define (attempts) (+ 1 retries))
+	`
+
+	base := NewEvaluator(1000)
+	runCode(base, spec)
+	if got := evalLast(base, `(attempts)`).String(); got != "1" {
+		t.Errorf("without retries, attempts = %s, want 1", got)
+	}
+	if got := evalLast(base, `(when-feature 'retries 'yes)`).String(); got != "nil" {
+		t.Errorf("when-feature without the feature = %s, want nil", got)
+	}
+
+	ev := NewEvaluator(1000)
+	ev.Features["retries"] = true
+	ev.Features["partition-tolerance"] = true
+	runCode(ev, spec)
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(attempts)`, "4"},
+		{`(feature? 'retries)`, "true"},
+		{`(feature? 'batching)`, "false"},
+		{`(features)`, "(partition-tolerance retries)"},
+		{`(enable-feature! 'retries false)`, "false"},
+		{`(when-feature 'retries 'yes)`, "nil"},
+		{`(enable-feature! 'retries)`, "true"},
+		{`(when-feature 'retries 'yes)`, "yes"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+
+	// The properties table says which variant it checked
+	out := NewToolRegistry(ev).Process("{{properties}}")
+	if !strings.Contains(out, "*Features: partition-tolerance, retries*") {
+		t.Errorf("properties table doesn't list the features:\n%s", out)
+	}
+	if out := NewToolRegistry(base).Process("{{properties}}"); strings.Contains(out, "Features:") {
+		t.Errorf("properties table lists features for a base run:\n%s", out)
+	}
+}
+
+// ============================================================================
+// Deterministic Ordering Tests
+// ============================================================================
+
+func TestDeterministicOrdering(t *testing.T) {
+	setup := `
+		(define (waiter) (receive!) 'done)
+		(spawn-actor 'carol 4 '(waiter))
+		(spawn-actor 'alice 4 '(waiter))
+		(spawn-actor 'bob 4 '(waiter))
+		(registry-set! 'zeta 1)
+		(registry-set! 'alpha 2)
+		(registry-set! 'mid 3)
+		(assert! 'edge 'a 'b)
+		(assert! 'sale 'zed 5)
+		(assert! 'sale 'abe 3)
+		(assert! 'sale 'moe 4)
+		(assert! 'sale 'abe 1)`
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(list-actors-sched)", "(alice bob carol)"},
+		{"(registry-keys)", "(alpha mid zeta)"},
+		{"(run-scheduler 10)", `(deadlock 3 ((alice "recv (empty)") (bob "recv (empty)") (carol "recv (empty)")))`},
+		{"(run-scheduler 10) (scheduler-status)", `((alice blocked "recv (empty)" 0 4 1) (bob blocked "recv (empty)" 0 4 1) (carol blocked "recv (empty)" 0 4 1))`},
+		{"(send-to! 'bob 'hi) (scheduler-status)", `((alice runnable "" 0 4 0) (bob runnable "" 1 4 0) (carol runnable "" 0 4 0))`},
+		{"(query 'edge '?y '?x)", "(((x b) (y a)))"},
+		{"(group-count 'sale 0)", `(("abe" 2) ("moe" 1) ("zed" 1))`},
+		{"(group-sum 'sale 0 1)", `(("abe" 4) ("moe" 4) ("zed" 5))`},
+		{"(timeseries 'sale 1)", "((0 5) (0 3) (0 4) (0 1))"},
+	}
+	for _, tt := range tests {
+		// Fresh evaluators each time so map iteration order gets a chance to differ
+		for i := 0; i < 5; i++ {
+			ev := NewEvaluator(64)
+			ev.Quiet = true
+			runCode(ev, setup)
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Fatalf("%s: expected %s, got %s", tt.code, tt.expected, got)
+			}
+		}
+	}
+
+	ev := NewEvaluator(64)
+	ev.Quiet = true
+	runCode(ev, setup)
+	status := ev.Scheduler.Status()
+	a, b, c := strings.Index(status, "alice"), strings.Index(status, "bob"), strings.Index(status, "carol")
+	if !(a < b && b < c) {
+		t.Errorf("status not sorted by name:\n%s", status)
+	}
+}
+
+func TestUnblockOrderIsRepeatable(t *testing.T) {
+	// Several senders block on a full mailbox and are released together;
+	// the resulting schedule must be the same every run.
+	code := `
+		(spawn-actor 'sink 1 '(begin (receive!) (receive!) (receive!) (receive!) 'done))
+		(spawn-actor 'p3 4 '(begin (send-to! 'sink 3) 'done))
+		(spawn-actor 'p1 4 '(begin (send-to! 'sink 1) 'done))
+		(spawn-actor 'p2 4 '(begin (send-to! 'sink 2) 'done))
+		(run-scheduler 50)`
+	var first []string
+	for i := 0; i < 10; i++ {
+		ev := NewEvaluator(64)
+		ev.Quiet = true
+		rec, finish := recordRun(ev, "")
+		runCode(ev, code)
+		finish()
+		if i == 0 {
+			first = rec.Schedule
+			continue
+		}
+		if strings.Join(rec.Schedule, " ") != strings.Join(first, " ") {
+			t.Fatalf("schedule changed between runs:\n%v\n%v", first, rec.Schedule)
+		}
+	}
+}
+
+func TestCSPEnforcement(t *testing.T) {
+	setup := `
+		(define count 0)
+		(define (sloppy) (set! count (+ count 1)) (assert! 'early count) (receive!) (assert! 'late count) 'done)
+		(define (careful) (let ((m (receive!))) (set! count (+ count 10)) (assert! 'late count) 'done))
+		(spawn-actor 's 4 '(sloppy))
+		(spawn-actor 'c 4 '(careful))
+		(send-to! 'c 'go)`
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{"off by default", `
+			(send-to! 's 'go)
+			(list (run-scheduler 10) (csp-violations) count)`,
+			"((completed 2) () 11)"},
+		{"violations are recorded once, as facts too", `
+			(csp-enforce! true)
+			(run-scheduler 10)
+			(send-to! 's 'go)
+			(list (run-scheduler 10) (csp-violations 's) (query 'csp-violation '?a '?op '?what) count)`,
+			`((completed 1) ("CSP violation: set! 'count' before guard in actor 's'" "CSP violation: assert! 'early' before guard in actor 's'") (((a s) (op set!) (what count)) ((a s) (op assert!) (what early))) 11)`},
+		{"strict actors skip early effects", `
+			(csp-enforce! true)
+			(csp-strict! 's true)
+			(send-to! 's 'go)
+			(list (run-scheduler 10) count (query 'early '?n))`,
+			"((completed 2) 10 ())"},
+		{"abort stops the run", `
+			(csp-enforce! 'abort)
+			(list (run-scheduler 10) (actor-state 'c))`,
+			`((csp-violation 1 s "CSP violation: set! 'count' before guard in actor 's'") (runnable "" 1 4))`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := NewEvaluator(1000)
+			ev.Quiet = true
+			runCode(ev, setup)
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSchedulerStep(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(spawn-actor 'a 4 '(begin (receive!) 'done))
+		(spawn-actor 'b 4 '(begin (send-to! 'a 'hi) 'done))`)
+	steps := []struct {
+		code     string
+		expected string
+	}{
+		{"(runnable-actors)", "(a b)"},
+		{"(scheduler-step!)", "(a <blocked: queue empty> blocked)"},
+		{"(runnable-actors)", "(b)"},
+		{"(scheduler-step!)", "(b done done)"},
+		{"(runnable-actors)", "(a)"},
+		{"(scheduler-step!)", "(a done done)"},
+		{"(scheduler-step!)", "(completed 3)"},
+		{"(runnable-actors)", "()"},
+	}
+	for _, tt := range steps {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ============================================================================
+// Channels and select!
+// ============================================================================
+//
+// A queue made with make-queue can be shared between actors, through a
+// global or a message, and used as a channel. In an actor, send! to a
+// full channel and recv! from an empty one block it in the scheduler, on
+// "channel send name" or "channel recv name", until the channel can take
+// or give a value; deadlock detection sees these waits like any other.
+//
+// (select! clause ...) waits on several channel operations at once and
+// makes whichever can go first:
+//
+//   (select!
+//     ((recv jobs job) (work job))        ; job is bound to the value received
+//     ((send results last) 'sent)
+//     (timeout 5 'idle))                 ; after 5 steps with neither
+//
+// The channels and values are evaluated first, in order. Then the first
+// operation that can proceed is made and its body evaluated; with no
+// body, select! returns the value received, ok for a send or timeout. If
+// none can, the actor blocks on "select (recv jobs, send results, ...)"
+// until one can, or until the timeout fires. A timeout of 0 makes
+// select! poll. Like a builtin ending in !, the operation select! made
+// isn't made again when a later call in the step blocks and the step is
+// retried.
+
+// chanWait is a channel operation an actor is blocked waiting to make
+type chanWait struct {
+	Queue *BoundedQueue
+	Send  bool
+	Value Value // For a send, the value it sends
+}
+
+func (w chanWait) ready() bool {
+	if w.Send {
+		return w.Queue.Fits(w.Value)
+	}
+	return !w.Queue.IsEmpty()
+}
+
+func (w chanWait) String() string {
+	name := w.Queue.Name
+	if name == "" {
+		name = "queue"
+	}
+	if w.Send {
+		return "send " + name
+	}
+	return "recv " + name
+}
+
+// blockOnChannels blocks the running actor until one of waits can go
+// ahead; false outside an actor, where there is nothing to block
+func (ev *Evaluator) blockOnChannels(reason string, waits []chanWait) bool {
+	actor := ev.Scheduler.GetActor(ev.Scheduler.CurrentActor)
+	if actor == nil {
+		return false
+	}
+	actor.Waits = waits
+	ev.Scheduler.BlockActor(actor.Name, reason)
+	return true
+}
+
+// channelReady reports whether a is blocked on channels one of which can
+// now go ahead
+func (a *Actor) channelReady() bool {
+	if a.State != ActorBlocked || !(strings.HasPrefix(a.BlockedOn, "channel ") || strings.HasPrefix(a.BlockedOn, "select ")) {
+		return false
+	}
+	for _, w := range a.Waits {
+		if w.ready() {
+			return true
+		}
+	}
+	return false
+}
+
+// wakeChannelWaiters makes runnable again the actors whose channel waits
+// can now go ahead
+func (ev *Evaluator) wakeChannelWaiters() {
+	for _, name := range ev.Scheduler.Names() {
+		if a := ev.Scheduler.Actors[name]; a.channelReady() {
+			a.Waits = nil
+			ev.Scheduler.UnblockActor(name)
+		}
+	}
+}
+
+// selectAlt is one clause of a select!
+type selectAlt struct {
+	wait    chanWait
+	timeout bool
+	steps   int64  // For a timeout, how long to wait
+	bind    *Value // For a recv, the symbol bound to the value received
+	body    []Value
+}
+
+// evalSelect evaluates (select! clause ...)
+func (ev *Evaluator) evalSelect(clauses []Value, env *Env) Value {
+	alts := make([]selectAlt, 0, len(clauses))
+	for _, clause := range clauses {
+		if !clause.IsList() || len(clause.List) == 0 {
+			ev.warnTrace("", "select!: bad clause %s", clause.String())
+			return Nil()
+		}
+		head := clause.List[0]
+		if head.IsSymbol() && head.Symbol == "timeout" && len(clause.List) > 1 {
+			n := ev.Eval(clause.List[1], env)
+			if n.Type == TypeBlocked {
+				return n
+			}
+			if n.Type != TypeNumber {
+				ev.warnTrace("", "select!: timeout needs a number of steps, got %s", n.String())
+				return Nil()
+			}
+			alts = append(alts, selectAlt{timeout: true, steps: int64(n.Number), body: clause.List[2:]})
+			continue
+		}
+		if !head.IsList() || len(head.List) < 2 || !head.List[0].IsSymbol() ||
+			head.List[0].Symbol != "recv" && head.List[0].Symbol != "send" {
+			ev.warnTrace("", "select!: clause must start with (recv ch [var]), (send ch v) or timeout: %s", clause.String())
+			return Nil()
+		}
+		ch := ev.Eval(head.List[1], env)
+		if ch.Type == TypeBlocked {
+			return ch
+		}
+		if ch.Type != TypeQueue {
+			ev.warnTrace("", "select!: not a channel: %s", ch.String())
+			return Nil()
+		}
+		if !ev.checkCap(ch.Queue.Name, "write") {
+			return Sym("denied")
+		}
+		alt := selectAlt{wait: chanWait{Queue: ch.Queue}, body: clause.List[1:]}
+		if head.List[0].Symbol == "send" {
+			if len(head.List) != 3 {
+				ev.warnTrace("", "select!: send needs a channel and a value: %s", head.String())
+				return Nil()
+			}
+			v := ev.Eval(head.List[2], env)
+			if v.Type == TypeBlocked {
+				return v
+			}
+			alt.wait.Send, alt.wait.Value = true, v
+		} else if len(head.List) > 2 && head.List[2].IsSymbol() {
+			alt.bind = &head.List[2]
+		}
+		alts = append(alts, alt)
+	}
+
+	pick := func() Value { return ev.selectPick(alts) }
+	var choice Value
+	if ev.journaling != nil {
+		choice = ev.journalCall("select!", pick, func(Value) bool { return true })
+	} else {
+		choice = pick()
+	}
+	if choice.Type != TypeList {
+		return choice
+	}
+	alt, result := alts[int(choice.List[0].Number)], choice.List[1]
+	if len(alt.body) == 0 {
+		return result
+	}
+	if alt.bind != nil {
+		bodyEnv := NewFrame(env, 1)
+		bodyEnv.setSym(*alt.bind, result)
+		env = bodyEnv
+	}
+	last := len(alt.body) - 1
+	for _, e := range alt.body[:last] {
+		if r := ev.Eval(e, env); r.Type == TypeBlocked {
+			return r
+		}
+	}
+	return tailExpr(alt.body[last], env)
+}
+
+// selectPick makes the first of alts that can go ahead and returns
+// (index result), or blocks the running actor until one can
+func (ev *Evaluator) selectPick(alts []selectAlt) Value {
+	ev.markGuardSeen() // CSP: select is a synchronization point
+	actor := ev.Scheduler.GetActor(ev.Scheduler.CurrentActor)
+	for i, alt := range alts {
+		if alt.timeout || !alt.wait.ready() {
+			continue
+		}
+		result := Sym("ok")
+		if alt.wait.Send {
+			alt.wait.Queue.SendNow(alt.wait.Value)
+		} else {
+			result, _ = alt.wait.Queue.RecvNow()
+		}
+		if actor != nil {
+			actor.Timeout, actor.TimedOut = 0, false
+		}
+		ev.wakeChannelWaiters()
+		return Lst(Int(int64(i)), result)
+	}
+
+	var waits []chanWait
+	var names []string
+	timeout := -1
+	for i, alt := range alts {
+		if alt.timeout {
+			if timeout < 0 {
+				timeout = i
+				names = append(names, fmt.Sprintf("timeout %d", alt.steps))
+			}
+			continue
+		}
+		waits = append(waits, alt.wait)
+		names = append(names, alt.wait.String())
+	}
+	if timeout >= 0 && (alts[timeout].steps <= 0 || actor != nil && actor.TimedOut) {
+		if actor != nil {
+			actor.Timeout, actor.TimedOut = 0, false
+		}
+		return Lst(Int(int64(timeout)), Sym("timeout"))
+	}
+	if actor != nil && timeout >= 0 && actor.Timeout == 0 {
+		// A retried step waits on the timer its first try set
+		ev.Scheduler.timeouts++
+		actor.Timeout = ev.Scheduler.timeouts
+		ev.Scheduler.addTimer(Timer{Due: ev.now() + alts[timeout].steps, Target: actor.Name, Timeout: actor.Timeout})
+	}
+	ev.blockOnChannels("select ("+strings.Join(names, ", ")+")", waits)
+	return Blocked(BlockQueueEmpty)
+}
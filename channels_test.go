@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Channel Tests
+// ============================================================================
+
+func TestChannels(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define jobs (make-queue 1 'jobs))
+		(define (taker) (assert! 'took (recv! jobs)) (list 'become '(taker)))
+		(define (giver n) (send! jobs n) (if (< n 3) (list 'become (list 'giver (+ n 1))) 'done))
+		(spawn-actor 'taker 2 '(taker))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		// Waiting on an empty channel blocks rather than spinning
+		{"(run-scheduler 50)", `(deadlock 1 ((taker "channel recv jobs")))`},
+		{"(send! jobs 0)", "ok"},
+		{"(actor-state 'taker)", `(runnable "" 0 2)`},
+		{"(spawn-actor 'giver 2 '(giver 1))", "<actor:giver>"},
+		{"(run-scheduler 50)", `(deadlock 8 ((taker "channel recv jobs")))`},
+		{"(query 'took '?n)", "(((n 0)) ((n 1)) ((n 2)) ((n 3)))"},
+		// A full one blocks the sender
+		{"(send-now! jobs 4)", "ok"},
+		{"(spawn-actor 'giver2 2 '(giver 5))", "<actor:giver2>"},
+		{"(kill-actor! 'taker)", "()"},
+		{"(run-scheduler 50)", `(deadlock 1 ((giver2 "channel send jobs")))`},
+		{"(recv-now! jobs)", "4"},
+		{"(run-scheduler 50)", "(completed 1)"},
+		{"(recv-now! jobs)", "5"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define a (make-queue 1 'a))
+		(define b (make-queue 1 'b))
+		(define (either)
+		  (select!
+		    ((recv a x) (assert! 'from 'a x))
+		    ((recv b x) (assert! 'from 'b x)))
+		  (receive!)
+		  'done)
+		(define (idle)
+		  (select! ((recv a x) x) (timeout 5 (assert! 'timed-out (self))))
+		  'done)
+		(spawn-actor 'either 2 '(either))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(select! ((recv a x) x) (timeout 0 'nothing))", "nothing"},
+		{"(select! ((send a 1)))", "ok"},
+		{"(select! ((send a 2)) ((recv a x) (list 'got x)))", "(got 1)"},
+		{"(select! ((recv a)) (timeout 0))", "timeout"},
+		// Blocks on both channels, and wakes for either
+		{"(run-scheduler 50)", `(deadlock 1 ((either "select (recv a, recv b)")))`},
+		{"(send! b 7)", "ok"},
+		{"(run-scheduler 50)", `(deadlock 1 ((either "recv (empty)")))`},
+		// The retried step doesn't take from b again
+		{"(send! b 8)", "ok"},
+		{"(send-to! 'either 'go)", "ok"},
+		{"(run-scheduler 50)", "(completed 1)"},
+		{"(query 'from '?c '?x)", "(((c b) (x 7)))"},
+		{"(recv-now! b)", "8"},
+		// With nothing to receive, the timeout fires
+		{"(spawn-actor 'idle 2 '(idle))", "<actor:idle>"},
+		{"(run-scheduler 50)", "(completed 2)"},
+		{"(query 'timed-out '?a)", "(((a idle)))"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+
+	for _, tt := range []struct{ code, warning string }{
+		{"(select! (recv a))", "select!: clause must start with"},
+		{"(select! ((recv 5) 1))", "select!: not a channel: 5"},
+		{"(select! (timeout 'soon))", "select!: timeout needs a number of steps"},
+	} {
+		if got := evalLast(ev, tt.code); got.Type != TypeNil {
+			t.Errorf("%s = %s, want nil", tt.code, got.String())
+		}
+		if lastWarning(ev, tt.warning) == nil {
+			t.Errorf("%s: expected a warning starting %q", tt.code, tt.warning)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+// ============================================================================
+// Lamport Clocks
+// ============================================================================
+//
+// Step numbers order everything one run happened to do, but not what
+// caused what: two steps far apart may be unrelated, and a different
+// schedule would swap them. Each actor keeps a Lamport clock that counts
+// its own steps and jumps forward on receipt:
+//
+//   - every step an actor takes advances its clock by one
+//   - a message carries its sender's clock, as of the send
+//   - receiving it sets the receiver's clock to one past the later of
+//     its own and the message's
+//
+// So if one event could have caused another, through a chain of steps
+// and messages, it has the lower clock. Sends and receives are recorded
+// with the clocks as (lamport-sent from to msg clock) and
+// (lamport-received actor msg clock), alongside the sent and received
+// facts, and (lamport-time) is the running actor's clock. The converse
+// doesn't hold: a lower clock doesn't mean a cause. Vector clocks, which
+// ride on the same stamps, tell the two apart (see vclocks.go).
+
+// Stamp is the clocks a message carries: its sender's, as of the send
+type Stamp struct {
+	Lamport int64
+	Vector  VectorClock // nil unless vector clocks are on
+}
+
+func (s Stamp) zero() bool { return s.Lamport == 0 && s.Vector == nil }
+
+// clock is the Lamport clock of the running actor, or 0 outside one
+func (ev *Evaluator) clock() int64 {
+	if a := ev.Scheduler.GetActor(ev.Scheduler.CurrentActor); a != nil {
+		return a.Clock
+	}
+	return 0
+}
+
+// stamp is what a message sent now carries
+func (ev *Evaluator) stamp() Stamp {
+	if a := ev.Scheduler.GetActor(ev.Scheduler.CurrentActor); a != nil {
+		return a.stamp()
+	}
+	return Stamp{}
+}
+
+func (a *Actor) stamp() Stamp {
+	return Stamp{Lamport: a.Clock, Vector: a.VClock.copy()}
+}
+
+// tick advances actor's clocks for a step it is about to take
+func (ev *Evaluator) tick(actor *Actor) {
+	actor.Clock++
+	if ev.Scheduler.VectorClocks {
+		if actor.VClock == nil {
+			actor.VClock = make(VectorClock)
+		}
+		actor.VClock[actor.Name]++
+	}
+}
+
+// mergeClock moves a's clocks past stamp, the clocks of a message it has
+// just received
+func (a *Actor) mergeClock(stamp Stamp) {
+	if stamp.Lamport > a.Clock {
+		a.Clock = stamp.Lamport
+	}
+	a.Clock++
+	if a.VClock != nil {
+		a.VClock.merge(stamp.Vector)
+		a.VClock[a.Name]++
+	}
+}
+
+func (ev *Evaluator) assertSentStamp(from, to string, msg Value, stamp Stamp) {
+	ev.DatalogDB.AssertAtTime("lamport-sent", ev.now(),
+		Atom(from), Atom(to), ValueToTerm(msg), NumTerm(float64(stamp.Lamport)))
+	if stamp.Vector != nil {
+		ev.DatalogDB.AssertAtTime("vector-sent", ev.now(),
+			Atom(from), Atom(to), ValueToTerm(msg), stamp.Vector.term())
+	}
+}
+
+func (ev *Evaluator) assertReceivedStamp(actor *Actor, msg Value) {
+	ev.DatalogDB.AssertAtTime("lamport-received", ev.now(),
+		Atom(actor.Name), ValueToTerm(msg), NumTerm(float64(actor.Clock)))
+	if actor.VClock != nil {
+		ev.DatalogDB.AssertAtTime("vector-received", ev.now(),
+			Atom(actor.Name), ValueToTerm(msg), actor.VClock.term())
+	}
+}
+
+// (lamport-time [actor]) - the Lamport clock of actor, or of the running
+// actor; 0 outside any
+func builtinLamportTime(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Int(ev.clock())
+	}
+	name := valueToString(args[0])
+	a := ev.Scheduler.GetActor(name)
+	if a == nil {
+		ev.warnTrace("", "lamport-time: unknown actor %s", name)
+		return Nil()
+	}
+	return Int(a.Clock)
+}
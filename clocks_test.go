@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Lamport Clock Tests
+// ============================================================================
+
+func TestLamportClocks(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (busy n) (if (> n 1) (list 'become (list 'busy (- n 1))) (begin (send-to! 'relay 'ping) 'done)))
+		(define (relay) (send-to! 'sink (list (receive!) (lamport-time))) 'done)
+		(define (sink) (receive!) 'done)
+		(spawn-actor 'busy 4 '(busy 5))
+		(spawn-actor 'relay 4 '(relay))
+		(spawn-actor 'sink 4 '(sink))
+		(run-scheduler 50)`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		// busy sends at its fifth step; relay, having stepped less, jumps past it
+		{"(query 'lamport-sent 'busy '?to '?msg '?c)", "(((c 5) (msg ping) (to relay)))"},
+		{"(query 'lamport-received 'relay '?msg '?c)", "(((c 6) (msg ping)))"},
+		{"(query 'lamport-received 'sink '?msg '?c)", "(((c 7) (msg (ping 6))))"},
+		{"(list (lamport-time 'busy) (lamport-time 'relay) (lamport-time 'sink) (lamport-time))", "(5 6 7 0)"},
+		{"(lamport-time 'nobody)", "nil"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	if lastWarning(ev, "lamport-time: unknown actor nobody") == nil {
+		t.Errorf("expected a warning about the unknown actor, got %v", ev.Warnings)
+	}
+}
+
+func TestQueueStamps(t *testing.T) {
+	q := NewQueue(4)
+	q.SendNow(Sym("a"))
+	q.SendStamped(Sym("b"), 5, Stamp{Lamport: 7})
+	q.SendStamped(Sym("c"), 0, Stamp{Lamport: 3})
+	want := []struct {
+		msg   string
+		stamp int64
+	}{{"b", 7}, {"a", 0}, {"c", 3}}
+	for _, w := range want {
+		msg, stamp, ok := q.RecvStamped()
+		if !ok || msg.String() != w.msg || stamp.Lamport != w.stamp {
+			t.Errorf("received %s at %d, want %s at %d", msg.String(), stamp.Lamport, w.msg, w.stamp)
+		}
+	}
+	q.SendStamped(Sym("d"), 0, Stamp{Lamport: 2})
+	q.Clear()
+	q.SendNow(Sym("e"))
+	if _, stamp, _ := q.RecvStamped(); !stamp.zero() {
+		t.Errorf("a cleared queue kept stamp %v", stamp)
+	}
+}
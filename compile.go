@@ -0,0 +1,548 @@
+package main
+
+import (
+	"strings"
+)
+
+// ============================================================================
+// Compiled Evaluation
+// ============================================================================
+//
+// evalStep walks the expression tree: every time round an actor's loop it
+// re-decides that (if ...) is an if, that (send-to! ...) isn't a special
+// form, and sends each symbol and number back through the trampoline. A
+// function's body is instead compiled, the second time it is called, into
+// a tree of closures with those decisions made once:
+//
+//   - numbers, strings and other constants return themselves
+//   - symbols look up their binding without a trampoline round trip, and
+//     the function's parameters and let names by frame position
+//   - if, cond, let, begin and quote run pre-split clauses
+//   - calls evaluate a pre-compiled head and arguments
+//
+// Every other special form is handed to evalStep as before. Compiled code
+// behaves exactly like the interpreter: list expressions still go through
+// the trampoline, so stack traces, the debugger, evaluation counts and
+// profiles see the same expressions, and bindings are still looked up
+// when the code runs, so redefining a function takes effect immediately.
+// Set Evaluator.Interpret to turn compilation off.
+
+// code is an expression compiled to closures
+type code struct {
+	expr Value                                            // The expression compiled
+	step func(ev *Evaluator, env *Env, inBody bool) Value // One trampoline step, like evalStep
+	leaf func(ev *Evaluator, env *Env) Value              // For constants and symbols: the whole evaluation
+}
+
+// scope is what the compiler knows of the frames an expression will run
+// in, innermost first: the names of the lets around it, then the
+// function's parameters
+type scope struct {
+	names  []SymbolID // In slot order
+	parent *scope
+}
+
+// resolve finds the frame depth and slot id will be bound at
+func (s *scope) resolve(id SymbolID) (depth, slot int, ok bool) {
+	for ; s != nil; s = s.parent {
+		for i, name := range s.names {
+			if name == id {
+				return depth, i, true
+			}
+		}
+		depth++
+	}
+	return 0, 0, false
+}
+
+// specialForms are the list heads evalStep handles itself rather than
+// as function calls
+var specialForms = map[string]bool{
+	"quote": true, "quasiquote": true, "unquote": true, "unquote-splicing": true,
+	"if": true, "cond": true, "let": true, "let*": true, "set!": true, "define": true,
+	"lambda": true, "fn": true, "tail": true, "do": true, "begin": true,
+	"time": true, "profile": true, "when-feature": true, "module": true,
+	"import": true, "match": true, "loop": true, "while": true, "dotimes": true,
+	"case": true, "when": true, "unless": true, "destructure": true, "select!": true,
+	"with-scheduler": true, "explain": true,
+}
+
+// compiledBody returns f's body compiled, compiling it on f's second call
+// so a lambda that is only called once isn't compiled at all. nil means
+// interpret it.
+func (ev *Evaluator) compiledBody(f *Function) *code {
+	if ev.Interpret {
+		return nil
+	}
+	if f.compiled == nil {
+		if f.calls++; f.calls < 2 {
+			return nil
+		}
+		f.compiled = compile(f.Body, &scope{names: f.frameLayout()})
+	}
+	return f.compiled
+}
+
+// evalCode evaluates c where it isn't in tail position, like Eval
+func (ev *Evaluator) evalCode(c *code, env *Env) Value {
+	if c.leaf != nil {
+		return c.leaf(ev, env)
+	}
+	return ev.run(c.expr, c, env, false)
+}
+
+// tailCode continues the trampoline with c in env, like tailExpr
+func tailCode(c *code, env *Env) Value {
+	return Value{Type: TypeTailCall, Tail: &TailCall{Expr: c.expr, Env: env, Code: c}}
+}
+
+// compile turns expr into closures
+func compile(expr Value, sc *scope) *code {
+	switch expr.Type {
+	case TypeNil, TypeNumber, TypeString, TypeBool, TypeChar, TypeFunc, TypeBuiltin, TypeStack, TypeQueue, TypeMap, TypeSet:
+		return compileConstant(expr)
+	case TypeSymbol:
+		return compileSymbol(expr, sc)
+	case TypeList:
+		if len(expr.List) == 0 {
+			break
+		}
+		head := expr.List[0]
+		if !head.IsSymbol() || !specialForms[head.Symbol] {
+			return compileCall(expr, sc)
+		}
+		switch head.Symbol {
+		case "quote":
+			return compileQuote(expr)
+		case "if":
+			return compileIf(expr, sc)
+		case "cond":
+			return compileCond(expr, sc)
+		case "when", "unless":
+			return compileWhen(expr, sc)
+		case "case":
+			return compileCase(expr, sc)
+		case "destructure":
+			return compileDestructure(expr, sc)
+		case "let":
+			return compileLet(expr, sc)
+		case "loop":
+			return compileLoop(expr, sc)
+		case "while":
+			return compileWhile(expr, sc)
+		case "dotimes":
+			return compileDotimes(expr, sc)
+		case "do", "begin":
+			return compileBegin(expr, sc)
+		}
+	}
+	return interpreted(expr)
+}
+
+// interpreted is expr left to evalStep
+func interpreted(expr Value) *code {
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		return ev.evalStep(expr, env, inBody)
+	}}
+}
+
+func compileConstant(v Value) *code {
+	return &code{
+		expr: v,
+		step: func(ev *Evaluator, env *Env, inBody bool) Value { return v },
+		leaf: func(ev *Evaluator, env *Env) Value {
+			ev.countEval()
+			return v
+		},
+	}
+}
+
+func compileSymbol(expr Value, sc *scope) *code {
+	name := expr.Symbol
+	keyword := strings.HasPrefix(name, ":") && len(name) > 1
+	find := func(ev *Evaluator, env *Env) (Value, bool) {
+		if v, ok := env.Lookup(expr); ok {
+			return v, true
+		}
+		if v, ok := ev.lookupQualified(name); ok {
+			return v, true
+		}
+		if keyword {
+			return expr, true // :keyword evaluates to itself
+		}
+		return Nil(), false
+	}
+	lookup := func(ev *Evaluator, env *Env) (Value, bool) {
+		if ev.SymbolRefs != nil {
+			ev.SymbolRefs[name] = true
+		}
+		return find(ev, env)
+	}
+	if depth, slot, ok := sc.resolve(expr.SymID); ok && expr.SymID != 0 {
+		lookup = func(ev *Evaluator, env *Env) (Value, bool) {
+			if ev.SymbolRefs != nil {
+				ev.SymbolRefs[name] = true
+			}
+			e := env
+			for d := depth; d > 0 && e != nil; d-- {
+				e = e.parent
+			}
+			if e != nil && slot < len(e.frame) && e.frame[slot].ID == expr.SymID {
+				return e.frame[slot].Value, true
+			}
+			return find(ev, env) // Not laid out as compiled; search
+		}
+	}
+	undefined := func(ev *Evaluator) Value {
+		ev.warnTrace("undefined:"+name, "Undefined symbol: %s", name)
+		return Nil()
+	}
+	return &code{
+		expr: expr,
+		step: func(ev *Evaluator, env *Env, inBody bool) Value {
+			if v, ok := lookup(ev, env); ok {
+				return v
+			}
+			return undefined(ev)
+		},
+		leaf: func(ev *Evaluator, env *Env) Value {
+			ev.countEval()
+			if v, ok := lookup(ev, env); ok {
+				return v
+			}
+			// The trace ends at the symbol, as it would interpreted
+			top := ev.pushExpr(expr)
+			v := undefined(ev)
+			ev.Exprs = ev.Exprs[:top]
+			return v
+		},
+	}
+}
+
+func compileQuote(expr Value) *code {
+	quoted := Nil()
+	if len(expr.List) > 1 {
+		quoted = expr.List[1]
+	}
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		return quoted
+	}}
+}
+
+func compileIf(expr Value, sc *scope) *code {
+	if len(expr.List) < 3 {
+		return interpreted(expr)
+	}
+	cond, then := compile(expr.List[1], sc), compile(expr.List[2], sc)
+	var otherwise *code
+	if len(expr.List) > 3 {
+		otherwise = compile(expr.List[3], sc)
+	}
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		if ev.evalCode(cond, env).IsTruthy() {
+			return tailCode(then, env)
+		} else if otherwise != nil {
+			return tailCode(otherwise, env)
+		}
+		return Nil()
+	}}
+}
+
+func compileCond(expr Value, sc *scope) *code {
+	type clause struct {
+		test   *code // nil for else
+		result *code
+	}
+	var clauses []clause
+	for _, c := range expr.List[1:] {
+		if !c.IsList() || len(c.List) < 2 {
+			continue
+		}
+		cl := clause{result: compile(c.List[1], sc)}
+		if test := c.List[0]; !test.IsSymbol() || test.Symbol != "else" {
+			cl.test = compile(test, sc)
+		}
+		clauses = append(clauses, cl)
+	}
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		for _, cl := range clauses {
+			if cl.test == nil || ev.evalCode(cl.test, env).IsTruthy() {
+				return tailCode(cl.result, env)
+			}
+		}
+		return Nil()
+	}}
+}
+
+func compileWhen(expr Value, sc *scope) *code {
+	if len(expr.List) < 3 {
+		return interpreted(expr)
+	}
+	when := expr.List[0].Symbol == "when"
+	test, body := compile(expr.List[1], sc), compile(bodyExpr(expr.List[2:]), sc)
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		t := ev.evalCode(test, env)
+		if t.Type == TypeBlocked {
+			return t
+		}
+		if t.IsTruthy() != when {
+			return Nil()
+		}
+		return tailCode(body, env)
+	}}
+}
+
+func compileCase(expr Value, sc *scope) *code {
+	if len(expr.List) < 2 {
+		return interpreted(expr)
+	}
+	type clause struct {
+		keys   []Value
+		isElse bool
+		body   *code
+	}
+	key := compile(expr.List[1], sc)
+	var clauses []clause
+	for _, c := range expr.List[2:] {
+		if !c.IsList() || len(c.List) < 2 {
+			continue
+		}
+		keys, isElse := caseKeys(c.List[0])
+		clauses = append(clauses, clause{keys, isElse, compile(bodyExpr(c.List[1:]), sc)})
+	}
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		k := ev.evalCode(key, env)
+		if k.Type == TypeBlocked {
+			return k
+		}
+		for _, cl := range clauses {
+			if cl.isElse || caseMatches(cl.keys, k) {
+				return tailCode(cl.body, env)
+			}
+		}
+		return Nil()
+	}}
+}
+
+func compileDestructure(expr Value, sc *scope) *code {
+	if len(expr.List) < 3 {
+		return interpreted(expr)
+	}
+	pattern, val := expr.List[1], compile(expr.List[2], sc)
+	inner := &scope{parent: sc}
+	for _, name := range destructureNames(pattern, nil) {
+		seen := false
+		for _, id := range inner.names {
+			seen = seen || id == name.SymID
+		}
+		if !seen {
+			inner.names = append(inner.names, name.SymID) // A repeated name reuses its slot
+		}
+	}
+	body := compile(bodyExpr(expr.List[3:]), inner)
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		v := ev.evalCode(val, env)
+		if v.Type == TypeBlocked {
+			return v
+		}
+		newEnv := NewFrame(env, len(inner.names))
+		if !ev.destructure(pattern, v, newEnv) {
+			return Nil()
+		}
+		return tailCode(body, newEnv)
+	}}
+}
+
+func compileLet(expr Value, sc *scope) *code {
+	if len(expr.List) < 3 {
+		return interpreted(expr)
+	}
+	if expr.List[1].IsList() {
+		return compileSchemeLet(expr, sc)
+	}
+	name, val := expr.List[1], compile(expr.List[2], sc)
+	inner := &scope{names: []SymbolID{name.SymID}, parent: sc}
+	var body *code
+	switch {
+	case len(expr.List) == 4:
+		body = compile(expr.List[3], inner)
+	case len(expr.List) > 4:
+		// Multiple body expressions - wrap in begin
+		body = compile(Lst(append([]Value{Sym("begin")}, expr.List[3:]...)...), inner)
+	}
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		v := ev.evalCode(val, env)
+		// Propagate blocked status
+		if v.Type == TypeBlocked || body == nil {
+			return v
+		}
+		newEnv := NewFrame(env, 1)
+		newEnv.setSym(name, v)
+		return tailCode(body, newEnv)
+	}}
+}
+
+// compileSchemeLet compiles (let ((x 1) (y 2)) body ...)
+func compileSchemeLet(expr Value, sc *scope) *code {
+	names, inits := letBindings(expr.List[1])
+	vals := make([]*code, len(inits))
+	for i, init := range inits {
+		vals[i] = compile(init, sc)
+	}
+	inner := &scope{parent: sc}
+	for _, name := range names {
+		seen := false
+		for _, id := range inner.names {
+			seen = seen || id == name.SymID
+		}
+		if !seen {
+			inner.names = append(inner.names, name.SymID) // A repeated name reuses its slot
+		}
+	}
+	body := compile(bodyExpr(expr.List[2:]), inner)
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		newEnv := NewFrame(env, len(names))
+		for i, val := range vals {
+			v := ev.evalCode(val, env)
+			// Propagate blocked status
+			if v.Type == TypeBlocked {
+				return v
+			}
+			newEnv.setSym(names[i], v)
+		}
+		return tailCode(body, newEnv)
+	}}
+}
+
+// compileLoop compiles the loop's body once, rather than each time the
+// loop starts
+func compileLoop(expr Value, sc *scope) *code {
+	if len(expr.List) < 3 || !expr.List[1].IsList() {
+		return interpreted(expr)
+	}
+	head := expr.List[0]
+	names, inits := letBindings(expr.List[1])
+	vals := make([]*code, len(inits))
+	for i, init := range inits {
+		vals[i] = compile(init, sc)
+	}
+	body := bodyExpr(expr.List[2:])
+	recurScope := &scope{names: []SymbolID{Intern("recur")}, parent: sc}
+	compiled := compile(body, &scope{names: newFunction(names, body, nil).frameLayout(), parent: recurScope})
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		args := make([]Value, len(vals))
+		for i, val := range vals {
+			// Propagate blocked status
+			if args[i] = ev.evalCode(val, env); args[i].Type == TypeBlocked {
+				return args[i]
+			}
+		}
+		return ev.call(head, loopFunction(names, body, env, compiled), args, env, inBody)
+	}}
+}
+
+func compileWhile(expr Value, sc *scope) *code {
+	if len(expr.List) < 2 {
+		return interpreted(expr)
+	}
+	test := compile(expr.List[1], sc)
+	body := make([]*code, len(expr.List)-2)
+	for i, e := range expr.List[2:] {
+		body[i] = compile(e, sc)
+	}
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		for {
+			t := ev.evalCode(test, env)
+			if t.Type == TypeBlocked {
+				return t
+			}
+			if !t.IsTruthy() {
+				return Nil()
+			}
+			for _, c := range body {
+				if result := ev.evalCode(c, env); result.Type == TypeBlocked {
+					return result
+				}
+			}
+		}
+	}}
+}
+
+func compileDotimes(expr Value, sc *scope) *code {
+	if len(expr.List) < 2 || !expr.List[1].IsList() || len(expr.List[1].List) < 2 || !expr.List[1].List[0].IsSymbol() {
+		return interpreted(expr)
+	}
+	spec := expr.List[1].List
+	name, count := spec[0], compile(spec[1], sc)
+	inner := &scope{names: []SymbolID{name.SymID}, parent: sc}
+	body := make([]*code, len(expr.List)-2)
+	for i, e := range expr.List[2:] {
+		body[i] = compile(e, inner)
+	}
+	var result *code
+	if len(spec) > 2 {
+		result = compile(spec[2], inner)
+	}
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		n, ok := ev.dotimesCount(ev.evalCode(count, env))
+		if !ok {
+			return n
+		}
+		for i := int64(0); i < n.Int; i++ {
+			iterEnv := NewFrame(env, 1)
+			iterEnv.setSym(name, Int(i))
+			for _, c := range body {
+				if r := ev.evalCode(c, iterEnv); r.Type == TypeBlocked {
+					return r
+				}
+			}
+		}
+		if result == nil {
+			return Nil()
+		}
+		resultEnv := NewFrame(env, 1)
+		resultEnv.setSym(name, n)
+		return tailCode(result, resultEnv)
+	}}
+}
+
+func compileBegin(expr Value, sc *scope) *code {
+	if len(expr.List) < 2 {
+		return interpreted(expr)
+	}
+	exprs := make([]*code, len(expr.List)-1)
+	for i, e := range expr.List[1:] {
+		exprs[i] = compile(e, sc)
+	}
+	last := len(exprs) - 1
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		for _, c := range exprs[:last] {
+			// Propagate blocked status
+			if result := ev.evalCode(c, env); result.Type == TypeBlocked {
+				return result
+			}
+		}
+		return tailCode(exprs[last], env)
+	}}
+}
+
+func compileCall(expr Value, sc *scope) *code {
+	head := expr.List[0]
+	fn := compile(head, sc)
+	args := make([]*code, len(expr.List)-1)
+	for i, arg := range expr.List[1:] {
+		args[i] = compile(arg, sc)
+	}
+	return &code{expr: expr, step: func(ev *Evaluator, env *Env, inBody bool) Value {
+		f := ev.evalCode(fn, env)
+		vals := make([]Value, len(args))
+		for i, arg := range args {
+			vals[i] = ev.evalCode(arg, env)
+			if vals[i].Type == TypeBlocked {
+				// An argument couldn't be computed; neither can the call
+				return vals[i]
+			}
+		}
+		return ev.call(head, f, vals, env, inBody)
+	}}
+}
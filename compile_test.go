@@ -0,0 +1,243 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// Compiled Evaluation Tests
+// ============================================================================
+
+// scaleProgram is the scale prompt: 3 producers making 10 a tick and 3
+// consumers using 5 a tick, for 50 ticks
+const scaleProgram = `
+	(define (producer id consumer tick)
+	  (if (> tick 50)
+	    (done!)
+	    (begin
+	      (assert! 'produced id tick 10)
+	      (send-to! consumer (list 'batch tick 10))
+	      (list 'become (list 'producer id (list 'quote consumer) (+ tick 1))))))
+
+	(define (consumer id stock)
+	  (let msg (receive!)
+	    (let left (- (+ stock (nth msg 2)) 5)
+	      (assert! 'consumed id (nth msg 1) 5)
+	      (assert! 'inventory-change id left)
+	      (cond ((< left 0) (done!))
+	            (else (list 'become (list 'consumer id left)))))))
+
+	(spawn-actor 'producer-1 10 '(producer 1 'consumer-1 1))
+	(spawn-actor 'producer-2 10 '(producer 2 'consumer-2 1))
+	(spawn-actor 'producer-3 10 '(producer 3 'consumer-3 1))
+	(spawn-actor 'consumer-1 10 '(consumer 1 0))
+	(spawn-actor 'consumer-2 10 '(consumer 2 0))
+	(spawn-actor 'consumer-3 10 '(consumer 3 0))
+	(run-scheduler 1000)`
+
+// runBoth runs code in a fresh, seeded evaluator, compiled or interpreted
+func runBoth(code string, interpret bool) (*Evaluator, Value) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	ev.SetSeed(7)
+	ev.Interpret = interpret
+	return ev, evalLast(ev, code)
+}
+
+func TestCompiledMatchesInterpreted(t *testing.T) {
+	breadco, err := os.ReadFile("breadco.lisp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		code string
+	}{
+		{"scale", scaleProgram},
+		{"breadco", string(breadco) + "\n(list (registry-get 'bread-sold) (registry-get 'revenue))"},
+		{"recursion", `
+			(define (fib n) (cond ((< n 2) n) (else (+ (fib (- n 1)) (fib (- n 2))))))
+			(define (sum-down n acc) (if (= n 0) acc (sum-down (- n 1) (+ acc n))))
+			(list (fib 12) (sum-down 5000 0))`},
+		{"blocked argument", `
+			(define (take) (list 'got (receive!)))
+			(list (take) (take))`},
+		{"let and begin", `
+			(define (f x) (let y (* x 2) (set! x (+ x y)) (begin (+ x y))))
+			(list (f 1) (f 2) (f 3))`},
+		{"loop", `
+			(define (collect n) (loop ((i 0) (acc '())) (if (= i n) acc (recur (+ i 1) (cons i acc)))))
+			(list (collect 3) (collect 5) (loop ((x 1) (y)) (list x y)))`},
+		{"while and dotimes", `
+			(define (sum-to n) (let total 0 (dotimes (i n) (set! total (+ total i))) total))
+			(define (halve n) (let steps 0 (while (> n 1) (set! n (/ n 2)) (set! steps (+ steps 1))) steps))
+			(list (sum-to 10) (sum-to 100) (halve 64) (halve 1024) (dotimes (i 3 i)))`},
+		{"case", `
+			(define (handle msg)
+			  (case (nth msg 0)
+			    (('delivery) (list 'got (nth msg 1)))
+			    ((buy sell) 'trade)
+			    (else 'unknown)))
+			(map handle '((delivery 5) (buy) (sell) (nope) (delivery 6)))`},
+		{"destructure", `
+			(define (handle msg)
+			  (destructure (kind (from to) . rest) msg
+			    (list kind to from rest)))
+			(define (swap p) (destructure (a a b) p (list b a)))
+			(list (map handle '((move (1 2) x y) (stop (3)) (go))) (swap '(1 2 3)))`},
+		{"redefinition", `
+			(define (g) 1)
+			(define (h) (g))
+			(define a (list (h) (h)))
+			(define (g) 2)
+			(list a (h))`},
+	}
+	for _, tt := range tests {
+		compiledEv, compiled := runBoth(tt.code, false)
+		interpretedEv, interpreted := runBoth(tt.code, true)
+		if compiled.String() != interpreted.String() {
+			t.Errorf("%s: compiled %s, interpreted %s", tt.name, compiled.String(), interpreted.String())
+		}
+		if compiledEv.EvalCount != interpretedEv.EvalCount {
+			t.Errorf("%s: compiled took %d evaluations, interpreted %d", tt.name, compiledEv.EvalCount, interpretedEv.EvalCount)
+		}
+		if a, b := len(compiledEv.DatalogDB.Facts), len(interpretedEv.DatalogDB.Facts); a != b {
+			t.Errorf("%s: compiled recorded %d facts, interpreted %d", tt.name, a, b)
+		}
+	}
+}
+
+func TestCompiledStackTraces(t *testing.T) {
+	// The second call is compiled
+	code := `
+		(define (area r) (if (> r 0) (* pi r r) 0))
+		(define (report r) (list 'area (area r)))
+		(report 0)
+		(report 2)
+		(define (twice f x) (f (f x)))
+		(twice inc 1)
+		(twice 3 1)`
+	compiledEv, _ := runBoth(code, false)
+	interpretedEv, _ := runBoth(code, true)
+	for _, prefix := range []string{"Undefined symbol: pi", "Cannot apply f"} {
+		compiled, interpreted := lastWarning(compiledEv, prefix), lastWarning(interpretedEv, prefix)
+		if compiled == nil || interpreted == nil {
+			t.Fatalf("%s: compiled warning %v, interpreted %v", prefix, compiled, interpreted)
+		}
+		if !reflect.DeepEqual(compiled.Stack, interpreted.Stack) {
+			t.Errorf("%s: compiled trace\n%v\ninterpreted\n%v", prefix, compiled.Stack, interpreted.Stack)
+		}
+	}
+}
+
+func TestCompiledOnSecondCall(t *testing.T) {
+	ev := NewEvaluator(1000)
+	runCode(ev, "(define (f x) (+ x 1)) (f 1)")
+	f, _ := ev.GlobalEnv.Get("f")
+	if f.Func.compiled != nil {
+		t.Errorf("f was compiled after one call")
+	}
+	runCode(ev, "(f 2)")
+	if f.Func.compiled == nil {
+		t.Errorf("f wasn't compiled after two calls")
+	}
+
+	ev = NewEvaluator(1000)
+	ev.Interpret = true
+	runCode(ev, "(define (f x) (+ x 1)) (f 1) (f 2) (f 3)")
+	if f, _ := ev.GlobalEnv.Get("f"); f.Func.compiled != nil {
+		t.Errorf("f was compiled with Interpret set")
+	}
+}
+
+// TestSpecialFormsListed keeps specialForms in step with evalStep: a
+// special form compiled as a call would evaluate its arguments
+func TestSpecialFormsListed(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "main.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if fn, ok := n.(*ast.FuncDecl); ok && fn.Name.Name != "evalStep" {
+			return false
+		}
+		sw, ok := n.(*ast.SwitchStmt)
+		if !ok {
+			return true
+		}
+		if sel, ok := sw.Tag.(*ast.SelectorExpr); !ok || sel.Sel.Name != "Symbol" {
+			return true
+		}
+		for _, stmt := range sw.Body.List {
+			for _, e := range stmt.(*ast.CaseClause).List {
+				if lit, ok := e.(*ast.BasicLit); ok {
+					name, _ := strconv.Unquote(lit.Value)
+					found[name] = true
+				}
+			}
+		}
+		return true
+	})
+	if len(found) == 0 {
+		t.Fatal("no special forms found in evalStep")
+	}
+	if !reflect.DeepEqual(found, specialForms) {
+		t.Errorf("evalStep handles %v, specialForms lists %v", sortedKeys(found), sortedKeys(specialForms))
+	}
+}
+
+// ============================================================================
+// Benchmarks
+// ============================================================================
+
+// benchmarkProgram runs code interpreted and compiled in turn, each on a
+// new evaluator, and reports the time each mode took and how many times
+// faster compiled is
+func benchmarkProgram(b *testing.B, code string) {
+	exprs := parseAll(NewParser(code))
+	run := func(interpret bool) time.Duration {
+		start := time.Now()
+		ev := NewEvaluator(1000)
+		ev.Quiet = true
+		ev.SetSeed(7)
+		ev.Interpret = interpret
+		for _, expr := range exprs {
+			ev.Eval(expr, ev.GlobalEnv)
+		}
+		return time.Since(start)
+	}
+	var interpreted, compiled time.Duration
+	for i := 0; i < b.N; i++ {
+		interpreted += run(true)
+		compiled += run(false)
+	}
+	b.ReportMetric(float64(interpreted.Nanoseconds())/float64(b.N), "interpreted-ns/op")
+	b.ReportMetric(float64(compiled.Nanoseconds())/float64(b.N), "compiled-ns/op")
+	b.ReportMetric(float64(interpreted)/float64(compiled), "speedup")
+}
+
+func BenchmarkBreadCo(b *testing.B) {
+	code, err := os.ReadFile("breadco.lisp")
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkProgram(b, string(code))
+}
+
+func BenchmarkScale(b *testing.B) {
+	benchmarkProgram(b, scaleProgram)
+}
+
+func BenchmarkArithmetic(b *testing.B) {
+	benchmarkProgram(b, `
+		(define (fib n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))
+		(fib 18)`)
+}
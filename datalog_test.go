@@ -799,7 +799,7 @@ func TestListFacts(t *testing.T) {
 	ev := NewEvaluator(1000)
 	
 	// Assert some facts via LISP
-	exprs := NewParser(`
+	exprs, _ := NewParser(`
 		(assert! 'sale 'store1 100)
 		(assert! 'sale 'store2 200)
 		(assert! 'inventory 'store1 50)
@@ -809,13 +809,13 @@ func TestListFacts(t *testing.T) {
 	}
 	
 	// List all facts
-	result := ev.Eval(NewParser(`(list-facts)`).Parse()[0], ev.GlobalEnv)
+	result := ev.Eval(parseAll(NewParser(`(list-facts)`))[0], ev.GlobalEnv)
 	if result.Type != TypeList || len(result.List) != 3 {
 		t.Errorf("expected 3 facts, got %d: %v", len(result.List), result)
 	}
 	
 	// List filtered by predicate
-	result = ev.Eval(NewParser(`(list-facts 'sale)`).Parse()[0], ev.GlobalEnv)
+	result = ev.Eval(parseAll(NewParser(`(list-facts 'sale)`))[0], ev.GlobalEnv)
 	if result.Type != TypeList || len(result.List) != 2 {
 		t.Errorf("expected 2 sale facts, got %d: %v", len(result.List), result)
 	}
@@ -825,7 +825,7 @@ func TestFactCount(t *testing.T) {
 	ev := NewEvaluator(1000)
 	
 	// Assert some facts via LISP
-	exprs := NewParser(`
+	exprs, _ := NewParser(`
 		(assert! 'sale 'store1 100)
 		(assert! 'sale 'store2 200)
 		(assert! 'inventory 'store1 50)
@@ -835,18 +835,18 @@ func TestFactCount(t *testing.T) {
 	}
 	
 	// Count all
-	result := ev.Eval(NewParser(`(fact-count)`).Parse()[0], ev.GlobalEnv)
+	result := ev.Eval(parseAll(NewParser(`(fact-count)`))[0], ev.GlobalEnv)
 	if result.Number != 3 {
 		t.Errorf("expected 3 total facts, got %v", result.Number)
 	}
 	
 	// Count by predicate
-	result = ev.Eval(NewParser(`(fact-count 'sale)`).Parse()[0], ev.GlobalEnv)
+	result = ev.Eval(parseAll(NewParser(`(fact-count 'sale)`))[0], ev.GlobalEnv)
 	if result.Number != 2 {
 		t.Errorf("expected 2 sale facts, got %v", result.Number)
 	}
 	
-	result = ev.Eval(NewParser(`(fact-count 'inventory)`).Parse()[0], ev.GlobalEnv)
+	result = ev.Eval(parseAll(NewParser(`(fact-count 'inventory)`))[0], ev.GlobalEnv)
 	if result.Number != 1 {
 		t.Errorf("expected 1 inventory fact, got %v", result.Number)
 	}
@@ -856,7 +856,7 @@ func TestToolSubstitution(t *testing.T) {
 	ev := NewEvaluator(1000)
 	
 	// Assert some facts via LISP
-	exprs := NewParser(`
+	exprs, _ := NewParser(`
 		(assert! 'sale 'store1 100)
 		(assert! 'sale 'store2 200)
 	`).Parse()
@@ -897,7 +897,7 @@ func TestLispExecutionPopulatesFacts(t *testing.T) {
 	`
 	
 	parser := NewParser(lisp)
-	exprs := parser.Parse()
+	exprs := parseAll(parser)
 	for _, expr := range exprs {
 		ev.Eval(expr, ev.GlobalEnv)
 	}
@@ -945,7 +945,7 @@ func TestAutoTracing(t *testing.T) {
 	`
 	
 	parser := NewParser(code)
-	exprs := parser.Parse()
+	exprs := parseAll(parser)
 	for _, expr := range exprs {
 		ev.Eval(expr, ev.GlobalEnv)
 	}
@@ -1003,30 +1003,30 @@ func TestAggregationFunctions(t *testing.T) {
 		(assert! 'sale 'store2 250)
 	`
 	parser := NewParser(code)
-	for _, expr := range parser.Parse() {
+	for _, expr := range parseAll(parser) {
 		ev.Eval(expr, ev.GlobalEnv)
 	}
 	
 	// Test sum-facts (sum field at index 1)
-	result := ev.Eval(NewParser(`(sum-facts 'sale 1)`).Parse()[0], ev.GlobalEnv)
+	result := ev.Eval(parseAll(NewParser(`(sum-facts 'sale 1)`))[0], ev.GlobalEnv)
 	if result.Number != 700 {
 		t.Errorf("expected sum 700, got %v", result.Number)
 	}
 	
 	// Test max-facts
-	result = ev.Eval(NewParser(`(max-facts 'sale 1)`).Parse()[0], ev.GlobalEnv)
+	result = ev.Eval(parseAll(NewParser(`(max-facts 'sale 1)`))[0], ev.GlobalEnv)
 	if result.Number != 250 {
 		t.Errorf("expected max 250, got %v", result.Number)
 	}
 	
 	// Test group-count (count by store - field 0)
-	result = ev.Eval(NewParser(`(group-count 'sale 0)`).Parse()[0], ev.GlobalEnv)
+	result = ev.Eval(parseAll(NewParser(`(group-count 'sale 0)`))[0], ev.GlobalEnv)
 	if result.Type != TypeList || len(result.List) != 2 {
 		t.Errorf("expected 2 groups, got %v", result)
 	}
 	
 	// Test group-sum (sum by store)
-	result = ev.Eval(NewParser(`(group-sum 'sale 0 1)`).Parse()[0], ev.GlobalEnv)
+	result = ev.Eval(parseAll(NewParser(`(group-sum 'sale 0 1)`))[0], ev.GlobalEnv)
 	if result.Type != TypeList || len(result.List) != 2 {
 		t.Errorf("expected 2 groups, got %v", result)
 	}
@@ -1055,7 +1055,7 @@ func TestStateChangeTracing(t *testing.T) {
 	`
 	
 	parser := NewParser(code)
-	for _, expr := range parser.Parse() {
+	for _, expr := range parseAll(parser) {
 		ev.Eval(expr, ev.GlobalEnv)
 	}
 	
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ============================================================================
+// REPL Debugger
+// ============================================================================
+//
+// In the REPL, (break) pauses evaluation and reads debugger commands:
+//
+//   :s  :step       run to the next expression, stepping into calls
+//   :n  :next       run to the next expression at this depth or outside it
+//   :c  :continue   resume until the next (break)
+//   :env            bindings visible here, innermost scope first
+//   :bt             the chain of expressions being evaluated
+//   :actors         every actor's state and mailbox size
+//   :mailbox name   the messages waiting for an actor
+//
+// Anything else is evaluated as LISP where evaluation is paused, so
+// (send-to! 'bank 'ping) or (* balance 2) work as they would in the code.
+// Outside the REPL there is no debugger and (break) does nothing.
+
+// Debugger pauses evaluation and reads commands from the REPL's input
+type Debugger struct {
+	in        *bufio.Scanner
+	out       io.Writer
+	stepping  bool // Pause before the next expression
+	nextDepth int  // With stepping, only pause at this many expressions deep or fewer; 0 = any
+	paused    bool // In the command loop; expressions typed there aren't stepped
+}
+
+// NewDebugger reads commands from in and writes to out
+func NewDebugger(in *bufio.Scanner, out io.Writer) *Debugger {
+	return &Debugger{in: in, out: out}
+}
+
+// reset stops stepping once the REPL has its prompt back
+func (d *Debugger) reset() {
+	d.stepping, d.nextDepth = false, 0
+}
+
+// beforeEval is called for each expression the evaluator is about to
+// evaluate and pauses there when stepping
+func (d *Debugger) beforeEval(ev *Evaluator, expr Value, env *Env) {
+	if !d.stepping || d.paused || expr.Type != TypeList {
+		return
+	}
+	if d.nextDepth > 0 && len(ev.Exprs) > d.nextDepth {
+		return
+	}
+	d.pause(ev, env, "step")
+}
+
+// pause prints where evaluation is and runs the command loop until the
+// user continues or steps
+func (d *Debugger) pause(ev *Evaluator, env *Env, why string) {
+	d.paused = true
+	defer func() { d.paused = false }()
+	d.stepping, d.nextDepth = false, 0
+
+	fmt.Fprintf(d.out, "%s %s\n", why, d.location(ev))
+	if n := len(ev.Exprs); n > 0 {
+		fmt.Fprintf(d.out, "  %s\n", ev.stackTrace()[n-1])
+	}
+	for {
+		fmt.Fprint(d.out, "debug> ")
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			return
+		}
+		cmd := strings.Fields(d.in.Text())
+		if len(cmd) == 0 {
+			continue
+		}
+		switch cmd[0] {
+		case ":c", ":continue":
+			return
+		case ":s", ":step":
+			d.stepping = true
+			return
+		case ":n", ":next":
+			d.stepping, d.nextDepth = true, len(ev.Exprs)
+			return
+		case ":env":
+			d.printEnv(ev, env)
+		case ":bt":
+			for _, line := range ev.stackTrace() {
+				fmt.Fprintf(d.out, "  %s\n", line)
+			}
+		case ":actors":
+			fmt.Fprint(d.out, ev.Scheduler.Status())
+		case ":mailbox":
+			d.printMailbox(ev, cmd[1:])
+		case ":help", ":h":
+			fmt.Fprintln(d.out, "  :s step  :n next  :c continue  :env  :bt  :actors  :mailbox name  or any expression")
+		default:
+			d.evalInput(ev, env, d.in.Text())
+		}
+	}
+}
+
+// location says which actor and step evaluation is paused in
+func (d *Debugger) location(ev *Evaluator) string {
+	if ev.Scheduler.CurrentActor == "" {
+		return "at top level"
+	}
+	return fmt.Sprintf("in actor %s, step %d", ev.Scheduler.CurrentActor, ev.Scheduler.StepCount)
+}
+
+// printEnv prints the local scopes from env out to, but not including,
+// the global environment
+func (d *Debugger) printEnv(ev *Evaluator, env *Env) {
+	depth := 0
+	for e := env; e != nil && e != ev.GlobalEnv; e = e.parent {
+		local := e.local()
+		for _, name := range sortedKeys(local) {
+			fmt.Fprintf(d.out, "  %s%s = %s\n", strings.Repeat("  ", depth), name, local[name].String())
+		}
+		depth++
+	}
+	if depth == 0 {
+		fmt.Fprintln(d.out, "  (global scope)")
+	}
+}
+
+func (d *Debugger) printMailbox(ev *Evaluator, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(d.out, "  usage: :mailbox actor-name")
+		return
+	}
+	actor := ev.Scheduler.GetActor(args[0])
+	if actor == nil {
+		fmt.Fprintf(d.out, "  no actor %s\n", args[0])
+		return
+	}
+	fmt.Fprintf(d.out, "  %d/%d messages\n", len(actor.Mailbox.Data), actor.Mailbox.Capacity)
+	for i, msg := range actor.Mailbox.Data {
+		fmt.Fprintf(d.out, "  %d: %s\n", i, msg.String())
+	}
+}
+
+// evalInput evaluates what the user typed in the paused environment
+func (d *Debugger) evalInput(ev *Evaluator, env *Env, input string) {
+	exprs, errs := NewParser(input).Parse()
+	for _, e := range errs {
+		fmt.Fprintf(d.out, "  %d:%d: %s\n", e.Line, e.Col, e.Msg)
+	}
+	if len(errs) > 0 {
+		return
+	}
+	for _, expr := range exprs {
+		fmt.Fprintf(d.out, "  %s\n", PrettyPrint(ev.Eval(expr, env)))
+	}
+}
+
+// (break) - in the REPL, pause here and read debugger commands
+func builtinBreak(ev *Evaluator, args []Value, env *Env) Value {
+	if ev.Debugger != nil && !ev.Debugger.paused && !ev.Sandbox {
+		ev.Debugger.pause(ev, env, "break")
+	}
+	return Nil()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Debugger Tests
+// ============================================================================
+
+// debugSession runs code with a debugger reading commands and returns
+// what it printed
+func debugSession(t *testing.T, ev *Evaluator, code, commands string) string {
+	t.Helper()
+	var out strings.Builder
+	ev.Debugger = NewDebugger(bufio.NewScanner(strings.NewReader(commands)), &out)
+	runCode(ev, code)
+	return out.String()
+}
+
+func TestBreakInspectsAndContinues(t *testing.T) {
+	ev := NewEvaluator(100)
+	ev.Quiet = true
+	out := debugSession(t, ev,
+		"(define (f x) (let y (* x 2) (begin (break) (+ x y)))) (define result (f 3))",
+		":env\n(* y 10)\n:bt\n:c\n")
+	for _, want := range []string{
+		"break at top level\n  in f: (break)\n",
+		"  y = 6\n    x = 3\n",
+		"debug>   60\n",
+		"  in top level: (define result (f 3))\n  in top level: (f 3)\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in:\n%s", want, out)
+		}
+	}
+	if got := evalLast(ev, "result").String(); got != "9" {
+		t.Errorf("evaluation should carry on after :c, got %s", got)
+	}
+}
+
+func TestDebuggerSteps(t *testing.T) {
+	ev := NewEvaluator(100)
+	ev.Quiet = true
+	out := debugSession(t, ev,
+		"(define (g x) (+ x 1)) (define (f x) (begin (break) (g (* x 2)) (g x))) (f 3)",
+		":n\n:s\n:s\n:c\n")
+	steps := []string{
+		"step at top level\n  in f: (g (* x 2))\n",
+		"step at top level\n  in f: (* x 2)\n",
+		"step at top level\n  in g: (+ x 1)\n",
+	}
+	last := 0
+	for _, want := range steps {
+		i := strings.Index(out[last:], want)
+		if i < 0 {
+			t.Fatalf("expected %q after offset %d in:\n%s", want, last, out)
+		}
+		last += i + len(want)
+	}
+}
+
+func TestDebuggerInActor(t *testing.T) {
+	ev := NewEvaluator(100)
+	ev.Quiet = true
+	out := debugSession(t, ev, `
+		(define (worker n) (let msg (receive!) (begin (break) (list 'become (list 'worker (+ n 1))))))
+		(spawn-actor 'w 4 '(worker 0))
+		(send-to! 'w 'hello)
+		(send-to! 'w 'again)
+		(run-scheduler 1)`,
+		":actors\n:mailbox w\nmsg\n:c\n")
+	for _, want := range []string{
+		"break in actor w, step 0\n",
+		"  w: runnable (mailbox: 1/4)\n",
+		"  1/4 messages\n  0: again\n",
+		"debug>   hello\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestBreakWithoutDebugger(t *testing.T) {
+	ev := NewEvaluator(100)
+	if got := evalLast(ev, "(begin (break) 42)").String(); got != "42" {
+		t.Errorf("(break) outside the REPL should do nothing, got %s", got)
+	}
+}
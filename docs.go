@@ -0,0 +1,427 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// Documentation
+// ============================================================================
+//
+// (doc 'f) describes a builtin or function: its signature and what it does.
+// Functions get their description from a docstring, a string literal
+// before the body:
+//
+//   (define (restock n)
+//     "Orders n loaves from the mill."
+//     (send-to! 'mill (list 'order n)))
+//
+// (apropos "stack") lists the builtins and functions whose name or
+// description mentions a word.
+
+// builtinDocs describes each Go builtin as "(signature) - what it does".
+// Every builtin registered in setupBuiltins needs an entry here.
+var builtinDocs = map[string]string{
+	// Arithmetic
+	"+":      "(+ a b ...) - sum; integers stay exact",
+	"-":      "(- a b ...) - first minus the rest; (- a) negates",
+	"*":      "(* a b ...) - product",
+	"/":      "(/ a b) - division; exact when it divides evenly",
+	"mod":    "(mod a b) - remainder of integer division",
+	"abs":    "(abs x) - absolute value",
+	"min":    "(min a b ...) - smallest number",
+	"max":    "(max a b ...) - largest number",
+	"floor":  "(floor x) - round down to an integer",
+	"ceil":   "(ceil x) - round up to an integer",
+	"sqrt":   "(sqrt x) - square root",
+	"pow":    "(pow x y) - x to the power y",
+	"exp":    "(exp x) - e to the power x",
+	"ln":     "(ln x) - natural logarithm",
+	"log":    "(log x) - natural logarithm, same as ln",
+	"sin":    "(sin x) - sine of x radians",
+	"cos":    "(cos x) - cosine of x radians",
+	"float":  "(float n) - n as a float",
+	"rand":   "(rand [n]) - random float in [0, 1), or integer in [0, n); seeded per run",
+	"random": "(random [n]) - same as rand",
+
+	// Wall-clock time
+	"now":         "(now) - seconds since the Unix epoch",
+	"now-millis":  "(now-millis) - milliseconds since the Unix epoch",
+	"format-time": "(format-time t [layout]) - t seconds written in UTC; layout is rfc3339, millis, date, time, datetime or a Go layout",
+
+	// Comparison
+	"=":      "(= a b ...) - numeric equality; other values are a type error, use equal? or eq?",
+	"!=":     "(!= a b) - numbers not equal",
+	"<":      "(< a b) - less than",
+	">":      "(> a b) - greater than",
+	"<=":     "(<= a b) - less than or equal",
+	">=":     "(>= a b) - greater than or equal",
+	"eq?":    "(eq? a b) - identity: equal atoms, or the same list, map, set, stack, queue or function",
+	"equal?": "(equal? a b) - deep comparison of lists, maps, sets and tagged values",
+	"equals": "(equals a b) - same as equal?",
+
+	// Boolean logic
+	"and": "(and a b ...) - true if every argument is truthy",
+	"or":  "(or a b ...) - true if any argument is truthy",
+	"not": "(not x) - true for nil and false",
+
+	// Lists
+	"list":       "(list x ...) - a list of the arguments",
+	"cons":       "(cons x lst) - lst with x in front",
+	"first":      "(first lst) - first element, nil if empty",
+	"rest":       "(rest lst) - all but the first element",
+	"car":        "(car lst) - same as first",
+	"cdr":        "(cdr lst) - same as rest",
+	"nth":        "(nth lst i) - element at 0-based index i",
+	"length":     "(length lst) - number of elements",
+	"append":     "(append lst ...) - the lists joined into one",
+	"empty?":     "(empty? lst) - true for nil or '()",
+	"map":        "(map f lst ...) - f applied to each element; with several lists, to one from each",
+	"filter":     "(filter pred lst) - elements for which pred is truthy",
+	"reduce":     "(reduce f lst) - fold from the first element; nil for '()",
+	"fold":       "(fold f init lst) - same as fold-left",
+	"fold-left":  "(fold-left f init lst) - (f (f init x1) x2) ...",
+	"fold-right": "(fold-right f init lst) - (f x1 (f x2 init)) ...",
+	"for-each":   "(for-each f lst ...) - call f for its effects; returns nil",
+	"apply":      "(apply f arg ... lst) - call f with the args followed by the items of lst",
+	"partial":    "(partial f a ...) - a function that calls f with a ... followed by its own arguments",
+	"curry":      "(curry f [n]) - a function taking f's n arguments over one or more calls; n is required for builtins",
+	"memoize":    "(memoize f [n]) - f with results cached by equal? arguments, at most n of them",
+	"sort":       "(sort lst) - ascending: numbers < strings < symbols < lists",
+	"sort-by":    "(sort-by lst less?) - stable sort with (less? a b) true when a comes first",
+
+	// Type predicates
+	"nil?":     "(nil? x) - true for nil",
+	"number?":  "(number? x) - true for numbers",
+	"integer?": "(integer? x) - true for exact integers",
+	"float?":   "(float? x) - true for floats",
+	"string?":  "(string? x) - true for strings",
+	"char?":    "(char? x) - true for chars",
+	"symbol?":  "(symbol? x) - true for symbols",
+	"list?":    "(list? x) - true for lists",
+	"map?":     "(map? x) - true for hash maps",
+	"set?":     "(set? x) - true for sets",
+	"tagged?":  "(tagged? x) - true for tagged values",
+	"error?":   "(error? x) - true for the error values builtins return on bad arguments",
+
+	// Strings
+	"str":              "(str x) - display form of x as a string",
+	"concat":           "(concat x ...) - display forms of the arguments joined",
+	"string-append":    "(string-append s ...) - the strings joined",
+	"string-length":    "(string-length s) - length in characters",
+	"substring":        "(substring s start [end]) - characters from start up to end",
+	"string-index":     "(string-index s sub) - index of the first sub in s, or -1",
+	"string-contains?": "(string-contains? s sub) - true if sub occurs in s",
+	"string-split":     "(string-split s [sep]) - pieces between seps, or between runs of whitespace",
+	"string-join":      "(string-join lst [sep]) - display forms joined with sep",
+	"string-replace":   "(string-replace s old new) - s with every old replaced by new",
+	"string-upcase":    "(string-upcase s) - s in upper case",
+	"string-downcase":  "(string-downcase s) - s in lower case",
+	"symbol->string":   "(symbol->string sym) - the symbol's name",
+	"string->symbol":   "(string->symbol s) - the symbol named s",
+	"number->string":   "(number->string n) - n printed as a string",
+	"string->number":   "(string->number s [radix]) - the number s spells, or nil",
+	"string->list":     "(string->list s) - the chars of s",
+	"list->string":     "(list->string chars) - the string made of a list of chars",
+	"char->number":     "(char->number c) - the Unicode code point of c",
+	"number->char":     "(number->char n) - the char with code point n",
+	"regex-match":      "(regex-match pattern s) - (match group ...) for the first match, or nil",
+	"regex-find-all":   "(regex-find-all pattern s [limit]) - every non-overlapping match",
+	"regex-replace":    "(regex-replace pattern s repl) - replace every match; $1 refers to groups",
+
+	// Tagged values
+	"tag":       "(tag 'type value) - value tagged with type",
+	"tag-type":  "(tag-type v) - the tag of a tagged value",
+	"tag-value": "(tag-value v) - the value inside a tagged value",
+	"tag-is?":   "(tag-is? v 'type) - true if v is tagged with type",
+
+	// Hash maps
+	"make-map":    "(make-map [k v ...]) - a new map, optionally with entries",
+	"map-get":     "(map-get m key [default]) - value for key, or default (nil)",
+	"map-set!":    "(map-set! m key value) - set key in place; returns m",
+	"map-has?":    "(map-has? m key) - true if key is present",
+	"map-delete!": "(map-delete! m key) - remove key; true if it was present",
+	"map-keys":    "(map-keys m) - keys in sorted order",
+
+	// Association lists
+	"assoc":      "(assoc key alist) - the first (key value) entry for key, or nil",
+	"assoc-set":  "(assoc-set key value alist) - a copy with key's entry replaced or added",
+	"alist->map": "(alist->map alist) - a map of the (key value) entries",
+	"map->alist": "(map->alist m) - ((key value) ...) in key order",
+
+	// Sets
+	"make-set":       "(make-set item ...) - a set of the arguments",
+	"list->set":      "(list->set lst) - a set of the list's elements",
+	"set->list":      "(set->list s) - items in sorted order",
+	"set-add!":       "(set-add! s item ...) - add in place; returns s",
+	"set-remove!":    "(set-remove! s item) - remove in place; true if it was there",
+	"set-member?":    "(set-member? s item) - true if item is in s",
+	"set-size":       "(set-size s) - number of items",
+	"set-union":      "(set-union a b ...) - items in any argument",
+	"set-intersect":  "(set-intersect a b ...) - items in every argument",
+	"set-difference": "(set-difference a b ...) - items of a in none of the others",
+
+	// Bounded stacks and queues
+	"make-stack":     "(make-stack capacity [name]) - a bounded stack",
+	"push!":          "(push! stack v) - push, blocking while full",
+	"pop!":           "(pop! stack) - pop, blocking while empty",
+	"push-now!":      "(push-now! stack v) - push; 'ok or 'full",
+	"pop-now!":       "(pop-now! stack) - pop; the value or 'empty",
+	"stack-peek":     "(stack-peek stack) - top value or 'empty",
+	"stack-peek-now": "(stack-peek-now stack) - top value or 'empty",
+	"stack-read":     "(stack-read stack i) - value at index i from the bottom",
+	"stack-write!":   "(stack-write! stack i v) - overwrite index i; 'ok or 'error",
+	"stack-full?":    "(stack-full? stack) - true if at capacity",
+	"stack-empty?":   "(stack-empty? stack) - true if empty",
+	"make-queue":     "(make-queue capacity [name] [:bytes n]) - a bounded FIFO queue",
+	"send!":          "(send! queue v) - enqueue, blocking while full",
+	"recv!":          "(recv! queue) - dequeue, blocking while empty",
+	"send-now!":      "(send-now! queue v) - enqueue; 'ok or 'full",
+	"recv-now!":      "(recv-now! queue) - dequeue; the value or 'empty",
+	"queue-peek":     "(queue-peek queue) - front value, blocking while empty",
+	"queue-peek-now": "(queue-peek-now queue) - front value or 'empty",
+	"queue-full?":    "(queue-full? queue) - true if at capacity",
+	"queue-empty?":   "(queue-empty? queue) - true if empty",
+
+	// I/O and files
+	"print":          "(print x ...) - print the arguments on one line",
+	"println":        "(println x ...) - same as print",
+	"pp":             "(pp v [:width n]) - print v wrapped and indented",
+	"break":          "(break) - in the REPL, pause and read debugger commands (:s :n :c :env :bt :actors :mailbox)",
+	"repr":           "(repr x) - x as a string that read turns back into x; printed form for functions",
+	"read-file":      "(read-file path) - file contents as a string, nil on error",
+	"write-file":     "(write-file path x ...) - write the display forms; true on success",
+	"append-file":    "(append-file path x ...) - append the display forms; true on success",
+	"file-exists?":   "(file-exists? path) - true if the file exists",
+	"load":           "(load path) - evaluate a file found on the load path",
+	"add-load-path!": "(add-load-path! dir) - search dir after the rest of the load path",
+	"load-path":      "(load-path) - directories load searches, in order",
+
+	// Serialization and JSON
+	"write-value":    "(write-value v) - canonical serialized string, nil if v can't be serialized",
+	"read-value":     "(read-value s) - the value a write-value string stands for",
+	"read":           "(read s) - the value s stands for: repr or write-value output, or source text unevaluated",
+	"json-parse":     "(json-parse s) - the value for a JSON document, nil if it doesn't parse",
+	"json-stringify": "(json-stringify v [:indent n]) - JSON text for v, nil if it has none",
+
+	// Evaluation and the environment
+	"eval":                  "(eval expr) - evaluate expr in the global environment",
+	"gensym":                "(gensym [prefix]) - a fresh symbol",
+	"set-call-stack-depth!": "(set-call-stack-depth! n) - allow n nested calls, at most 10000; returns the previous depth",
+	"doc":                   "(doc 'name) - signature and description of a builtin or function",
+	"apropos":               "(apropos \"word\") - builtins and functions whose name or description mentions word",
+
+	// Feature flags
+	"feature?":        "(feature? 'name) - true if the feature is enabled for this run",
+	"features":        "(features) - the enabled features, sorted",
+	"enable-feature!": "(enable-feature! 'name [on]) - turn a feature on, or off with false",
+
+	// Registry
+	"registry-set!":    "(registry-set! name v) - store v under name",
+	"registry-get":     "(registry-get name) - value stored under name, or nil",
+	"registry-has?":    "(registry-has? name) - true if name is stored",
+	"registry-delete!": "(registry-delete! name) - remove name; true if it was there",
+	"registry-keys":    "(registry-keys) - stored names, sorted",
+
+	// Actors
+	"spawn-actor":            "(spawn-actor name mailbox-size body-or-function [args...] [:bytes n] [:priority n] [:on-start f] [:on-stop f] [:on-block f]) - start an actor",
+	"spawn":                  "(spawn mailbox-size body-or-function [args...] [options...]) - spawn-actor under a fresh name; returns the actor ref",
+	"self":                   "(self) - name of the running actor",
+	"become":                 "(become code-or-function [args...]) - return from a step to run code, or call the function with args, from the next step on",
+	"send-to!":               "(send-to! actor msg [:priority n]) - send to an actor's mailbox, blocking while it is full; higher priorities are received first",
+	"receive!":               "(receive!) - next message from own mailbox, blocking while empty",
+	"receive-now!":           "(receive-now!) - next message, or 'empty",
+	"mailbox-empty?":         "(mailbox-empty?) - true if own mailbox is empty",
+	"mailbox-full?":          "(mailbox-full? [actor]) - true if the actor's mailbox is full",
+	"mailbox-bytes":          "(mailbox-bytes [actor-or-queue]) - bytes waiting in a mailbox or queue",
+	"message-size":           "(message-size v) - approximate size of a message in bytes",
+	"yield!":                 "(yield!) - give up the rest of this step",
+	"done!":                  "(done!) - mark the running actor finished",
+	"send-after!":            "(send-after! n target msg) - send msg to target once n more scheduler steps have run",
+	"receive-timeout!":       "(receive-timeout! n) - receive!, or timeout if nothing arrives within n steps",
+	"pending-timers":         "(pending-timers) - ((due from target msg) ...) for send-after! messages not yet sent",
+	"actor-state":            "(actor-state actor) - runnable, blocked or done",
+	"whereis":                "(whereis name) - a ref to the named actor while it is alive, else nil",
+	"actor-exists?":          "(actor-exists? name) - true if an actor of that name was spawned, done or not",
+	"actor-mailbox-size":     "(actor-mailbox-size actor) - {messages capacity bytes byte-capacity} of its mailbox",
+	"actor-result":           "(actor-result actor) - what the actor's last step returned",
+	"on-schedule-event!":     "(on-schedule-event! fn) - call fn with each scheduler event, e.g. (before-step actor); returns an id",
+	"remove-schedule-hook!":  "(remove-schedule-hook! id) - stop calling an on-schedule-event! hook",
+	"subscribe!":             "(subscribe! pattern-or-topic [actor]) - deliver matching facts, or messages published to the topic, to the mailbox",
+	"unsubscribe!":           "(unsubscribe! pattern-or-topic [actor]) - stop delivering matching facts or the topic's messages",
+	"publish!":               "(publish! topic msg) - send msg to every actor subscribed to topic; returns how many",
+	"broadcast!":             "(broadcast! msg) - send msg to every other live actor; returns how many",
+	"link!":                  "(link! [a] b) - a and b each get (exit name reason) when the other exits; a defaults to self",
+	"monitor!":               "(monitor! [a] b) - a gets (exit b reason) when b exits; a defaults to self",
+	"kill-actor!":            "(kill-actor! actor [reason] [:mailbox drain|discard]) - stop an actor now; returns the messages it hadn't received",
+	"run-scheduler":          "(run-scheduler max-steps-or-config) - run actors until done, deadlocked or out of steps",
+	"run-config":             "(run-config :max-steps n :seed n :policy p :trace b :stop-on-property-failure checks :quiescence b :record file :livelock n :starvation n) - a run configuration",
+	"scheduler-step!":        "(scheduler-step!) - run one actor step; (actor result state), or how the run ended",
+	"runnable-actors":        "(runnable-actors) - actors that can run, in round-robin order",
+	"scheduler-status":       "(scheduler-status) - ((name state blocked-on mailbox-len mailbox-cap steps-run) ...), one per actor",
+	"print-scheduler-status": "(print-scheduler-status) - print each actor's state",
+	"list-actors-sched":      "(list-actors-sched) - actor names, sorted",
+	"reset-scheduler":        "(reset-scheduler) - remove all actors",
+	"set-trace!":             "(set-trace! on) - print each scheduler step",
+	"set-quiescence!":        "(set-quiescence! on) - report (quiescent n) instead of deadlock when all actors wait on empty mailboxes",
+	"quiescent?":             "(quiescent?) - true if every live actor waits on an empty mailbox and no timers or stimuli are due",
+	"actor-progress":         "(actor-progress actor) - {received sent becomes idle waiting}: what the actor has done and how long it has gone without progress or a turn",
+	"lamport-time":           "(lamport-time [actor]) - the Lamport clock of actor, or of the running actor",
+	"vector-clocks!":         "(vector-clocks! on) - turn per-actor vector clocks on or off",
+	"auto-trace!":            "(auto-trace! on) - have actors record guard, effect and state-change facts, or stop",
+	"inject-faults!":         "(inject-faults! [:drop-rate p] [:dup-rate p] [:max-delay n] [:seed n]) - make send-to! drop, duplicate and delay messages, each fault asserted as a fact; false makes sends reliable again",
+	"vector-time":            "(vector-time [actor]) - the vector clock of actor, or of the running actor, as ((actor count) ...)",
+	"set-actor-budget!":      "(set-actor-budget! actor steps) - let actor take steps more steps before it blocks; nil lifts the limit",
+	"fairness-report":        "(fairness-report) - each actor's (name steps longest-wait budget-left), also asserted as fairness facts",
+	"advance-time!":          "(advance-time! n) - move the virtual clock n ahead, making time in the run virtual; returns the new time",
+	"virtual-time":           "(virtual-time) - the virtual clock, 0 until advance-time! is used",
+	"ask!":                   "(ask! target msg) - send (ask id self msg) to target and wait for its (reply id value); returns value",
+	"reply!":                 "(reply! request value) - answer an ask! request, sending (reply id value) to the actor that asked",
+	"make-promise":           "(make-promise) - a new undelivered promise, #promise{n}",
+	"deliver!":               "(deliver! p v) - fill promise p with v, waking the actors awaiting it",
+	"await":                  "(await p) - the value of promise p, blocking until it is delivered",
+	"promise-ready?":         "(promise-ready? p) - whether promise p has been delivered",
+	"make-scheduler":         "(make-scheduler) - a new, empty scheduler for spawn-actor, run-scheduler and with-scheduler to work on",
+	"current-scheduler":      "(current-scheduler) - the scheduler actor builtins work on",
+	"replay-trace":           "(replay-trace file) - run again as a :record trace went; (diverged step expected got) if it doesn't",
+	"set-scheduler-policy!":  "(set-scheduler-policy! policy [seed]) - round-robin, random or priority; returns the previous policy",
+	"scheduler-policy":       "(scheduler-policy) - the policy picking the next actor",
+	"set-priority!":          "(set-priority! actor n) - higher runs first under the priority policy; returns the previous priority",
+
+	// CSP enforcement
+	"csp-enforce!":          "(csp-enforce! [on]) - turn CSP checking on or off, or 'abort to stop runs at the first violation; returns whether it is on",
+	"csp-strict!":           "(csp-strict! actor [on]) - block the actor on a CSP violation instead of recording it",
+	"csp-violations":        "(csp-violations [actor]) - recorded CSP violations",
+	"csp-clear-violations!": "(csp-clear-violations! [actor]) - forget recorded CSP violations",
+
+	// Capabilities
+	"cap":              "(cap resource right) - a capability value",
+	"grant":            "(grant actor cap) - give an actor a capability",
+	"revoke!":          "(revoke! actor cap) - take a capability away",
+	"capabilities":     "(capabilities actor) - capabilities the actor holds",
+	"capability-mode!": "(capability-mode! on) - require capabilities for shared resources",
+
+	// Costs
+	"set-cost!":    "(set-cost! action cost) - cost charged each time an actor calls action",
+	"set-budget!":  "(set-budget! actor amount) - most an actor may spend",
+	"total-cost":   "(total-cost [actor]) - cost spent by everyone, or by one actor",
+	"cost-report":  "(cost-report) - ((actor cost) ...) sorted by actor",
+	"reset-costs!": "(reset-costs!) - clear spent costs, keeping the table and budgets",
+
+	// Scenarios, equivalence and tests
+	"find-trace-satisfying":      "(find-trace-satisfying goal :tries n :steps n :stimuli '((actor msg) ...)) - search for a run where goal holds",
+	"run-scenario":               "(run-scenario script) - replay a script from find-trace-satisfying",
+	"snapshot-world":             "(snapshot-world) - save a copy of the actors, mailboxes, environments, registry and facts",
+	"restore-world!":             "(restore-world! snap) - put the world back the way it was when snap was taken",
+	"schedule-stimuli!":          "(schedule-stimuli! '((step actor message) ...)) - script messages delivered from outside as runs reach each step",
+	"pending-stimuli":            "(pending-stimuli) - the scripted stimuli not yet delivered, as (step actor message)",
+	"equivalent?":                "(equivalent? 'a 'b :inputs '(msg ...) :depth n :upto n) - true if two actors behave the same on every input sequence up to :depth; nil if :upto ran out first",
+	"equivalence-counterexample": "(equivalence-counterexample 'a 'b ...) - inputs that tell two actors apart, or nil",
+	"deftest":                    "(deftest name :spec file :seed n :schedule '(actor ...) ...) - replay a recorded run and check it",
+
+	// Datalog
+	"assert!":              "(assert! pred arg ...) - add a fact at the current time",
+	"assert-at!":           "(assert-at! time pred arg ...) - add a fact at time",
+	"retract!":             "(retract! pred arg ...) - remove matching facts",
+	"rule":                 "(rule name head goal ...) - add a Datalog rule",
+	"query":                "(query pred arg ... [:mode 'bottom-up]) - bindings for each matching fact; ?x arguments are variables",
+	"query-all":            "(query-all goal ... [:mode 'bottom-up]) - bindings satisfying every goal",
+	"datalog-mode!":        "(datalog-mode! 'bottom-up|'top-down [pred ...]) - solve goals on the given predicates, or on all, from rules run to fixpoint or top-down",
+	"fact-count":           "(fact-count [pred]) - number of facts",
+	"list-facts":           "(list-facts [pred]) - facts, all or for one predicate",
+	"datalog-facts":        "(datalog-facts) - all facts",
+	"datalog-rules":        "(datalog-rules) - all rules",
+	"datalog-clear!":       "(datalog-clear!) - remove all facts",
+	"datalog-clear-rules!": "(datalog-clear-rules!) - remove all rules",
+	"datalog-time":         "(datalog-time) - the current fact time",
+	"datalog-time!":        "(datalog-time! n) - set the fact time",
+	"property-status":      "(property-status name) - pass, fail or pending for a defproperty, as the Properties table shows it",
+	"always?":              "(always? goal) - true if goal holds at every time",
+	"eventually?":          "(eventually? goal) - true if goal holds at some time",
+	"possibly?":            "(possibly? goal) - true if goal might hold; same as eventually? on one trace",
+	"never?":               "(never? goal) - true if goal never holds",
+	"sum-facts":            "(sum-facts pred i) - sum of the numbers at argument i",
+	"max-facts":            "(max-facts pred i) - largest number at argument i",
+	"group-count":          "(group-count pred i) - ((group count) ...) grouped by argument i",
+	"group-sum":            "(group-sum pred group-i value-i) - ((group sum) ...)",
+	"timeseries":           "(timeseries pred value-i) - ((time value) ...) sorted by time",
+}
+
+// docString describes the builtin or function bound to name, and whether
+// there was anything to describe
+func (ev *Evaluator) docString(name string, env *Env) (string, bool) {
+	v, ok := env.Get(name)
+	if !ok {
+		v, ok = ev.lookupQualified(name)
+	}
+	if !ok {
+		return "", false
+	}
+	switch v.Type {
+	case TypeBuiltin:
+		doc, ok := builtinDocs[name]
+		return doc, ok
+	case TypeFunc:
+		sig := functionSignature(name, v.Func)
+		if v.Func.Doc == "" {
+			return sig, true
+		}
+		return sig + " - " + v.Func.Doc, true
+	}
+	return "", false
+}
+
+// functionBody splits the docstring off a function body. A string is only
+// a docstring when something follows it; otherwise it is the result.
+func functionBody(exprs []Value) (body Value, doc string) {
+	if len(exprs) > 1 && exprs[0].Type == TypeString {
+		doc, exprs = exprs[0].Str, exprs[1:]
+	}
+	if len(exprs) == 1 {
+		return exprs[0], doc
+	}
+	return Lst(append([]Value{Sym("begin")}, exprs...)...), doc
+}
+
+// (doc 'name) - "(signature) - description" for a builtin or function
+func builtinDoc(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 || (args[0].Type != TypeSymbol && args[0].Type != TypeString) {
+		ev.warn("", "doc: expected a name")
+		return Nil()
+	}
+	doc, ok := ev.docString(valueToString(args[0]), env)
+	if !ok {
+		return Nil()
+	}
+	return Str(doc)
+}
+
+// (apropos "word") - names of the builtins and functions whose name or
+// description contains word, ignoring case, sorted
+func builtinApropos(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Lst()
+	}
+	word := strings.ToLower(valueToString(args[0]))
+	var names []string
+	for e := env; e != nil; e = e.parent {
+		for name := range e.local() {
+			doc, ok := ev.docString(name, env)
+			if !ok {
+				continue
+			}
+			if strings.Contains(strings.ToLower(name), word) || strings.Contains(strings.ToLower(doc), word) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	result := make([]Value, 0, len(names))
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			result = append(result, Sym(name))
+		}
+	}
+	return Lst(result...)
+}
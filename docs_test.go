@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Documentation Tests
+// ============================================================================
+
+func TestEveryBuiltinDocumented(t *testing.T) {
+	ev := NewEvaluator(1000)
+	for name, v := range ev.GlobalEnv.bindings {
+		if v.Type != TypeBuiltin {
+			continue
+		}
+		doc, ok := builtinDocs[name]
+		if !ok {
+			t.Errorf("builtin %s has no entry in builtinDocs", name)
+		} else if !strings.HasPrefix(doc, "("+name) {
+			t.Errorf("doc for %s should start with its signature: %q", name, doc)
+		}
+	}
+	for name := range builtinDocs {
+		if v, ok := ev.GlobalEnv.bindings[name]; !ok || v.Type != TypeBuiltin {
+			t.Errorf("builtinDocs documents %s, which isn't a builtin", name)
+		}
+	}
+}
+
+func TestDocAndApropos(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (restock n)
+		  "Orders n loaves from the mill."
+		  (list 'order n))
+		(define (undocumented x) x)
+		(define (just-a-string) "not a docstring")
+		(define stacker (lambda (s) "Pushes onto the stack s." s))
+		(module bakery
+		  (define (open-shop) "Opens the shop." 'open))
+	`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(doc 'restock)`, `"(restock n) - Orders n loaves from the mill."`},
+		{`(restock 3)`, `(order 3)`},
+		{`(doc 'undocumented)`, `"(undocumented x)"`},
+		{`(just-a-string)`, `"not a docstring"`},
+		{`(doc 'just-a-string)`, `"(just-a-string)"`},
+		{`(doc 'stacker)`, `"(stacker s) - Pushes onto the stack s."`},
+		{`(doc 'bakery/open-shop)`, `"(bakery/open-shop) - Opens the shop."`},
+		{`(doc 'push-now!)`, `"(push-now! stack v) - push; 'ok or 'full"`},
+		{`(doc 'no-such-thing)`, `nil`},
+		{`(apropos "restock")`, `(restock)`},
+		{`(apropos "LOAVES")`, `(restock)`},
+		{`(apropos "regex")`, `(regex-find-all regex-match regex-replace)`},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+
+	// Searching descriptions finds builtins whose names don't mention the word
+	stack := evalLast(ev, `(apropos "stack")`).String()
+	for _, name := range []string{"make-stack", "push!", "pop-now!", "stacker"} {
+		if !strings.Contains(stack, name) {
+			t.Errorf("(apropos \"stack\") = %s, missing %s", stack, name)
+		}
+	}
+}
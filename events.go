@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ============================================================================
+// Scheduler Event Bus
+// ============================================================================
+//
+// The scheduler publishes what happens during a run as events instead of
+// hard-coding each observer into builtinRunScheduler. Tracing, schedule
+// hooks, fact subscriptions and trace recording hang off the bus as
+// subscribers. The per-actor counters (steps, sends, receives, becomes)
+// stay in the scheduler, which needs them itself for progress and budgets.
+
+type SchedEventKind int
+
+const (
+	EventActorScheduled SchedEventKind = iota
+	EventMessageSent
+	EventFactAsserted
+	EventActorBlocked
+	EventRunFinished
+	EventActorStepped
+)
+
+func (k SchedEventKind) String() string {
+	switch k {
+	case EventActorScheduled:
+		return "actor-scheduled"
+	case EventMessageSent:
+		return "message-sent"
+	case EventFactAsserted:
+		return "fact-asserted"
+	case EventActorBlocked:
+		return "actor-blocked"
+	case EventRunFinished:
+		return "run-finished"
+	case EventActorStepped:
+		return "actor-stepped"
+	default:
+		return "unknown"
+	}
+}
+
+// SchedEvent carries the details of one event. Only the fields relevant
+// to the Kind are set.
+type SchedEvent struct {
+	Kind    SchedEventKind
+	Step    int64
+	Actor   string // Scheduled/blocked actor, or sender
+	Target  string // Message recipient
+	Message Value  // Message payload, or actor code for ActorScheduled
+	Fact    *Fact  // Asserted fact
+	Reason  string // What the actor is blocked on
+	Result  Value  // Step result for ActorStepped, run result for RunFinished
+}
+
+type EventSubscriber func(ev *Evaluator, e SchedEvent)
+
+type EventBus struct {
+	subscribers map[int]EventSubscriber
+	order       []int
+	nextID      int
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[int]EventSubscriber)}
+}
+
+// Subscribe registers fn and returns an id for Unsubscribe.
+// Subscribers are called in registration order.
+func (b *EventBus) Subscribe(fn EventSubscriber) int {
+	b.nextID++
+	b.subscribers[b.nextID] = fn
+	b.order = append(b.order, b.nextID)
+	return b.nextID
+}
+
+func (b *EventBus) Unsubscribe(id int) {
+	if _, ok := b.subscribers[id]; !ok {
+		return
+	}
+	delete(b.subscribers, id)
+	for i, sid := range b.order {
+		if sid == id {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (b *EventBus) Publish(ev *Evaluator, e SchedEvent) {
+	for _, id := range b.order {
+		// A subscriber may unsubscribe itself or another as it runs
+		if fn, ok := b.subscribers[id]; ok {
+			fn(ev, e)
+		}
+	}
+}
+
+// emit publishes an event stamped with the current scheduler step
+func (ev *Evaluator) emit(e SchedEvent) {
+	if ev.Events == nil {
+		return
+	}
+	e.Step = ev.Scheduler.StepCount
+	ev.Events.Publish(ev, e)
+}
+
+// traceSubscriber prints scheduling decisions when (set-trace! true) is on
+func traceSubscriber(ev *Evaluator, e SchedEvent) {
+	if !ev.Scheduler.Trace {
+		return
+	}
+	switch e.Kind {
+	case EventActorScheduled:
+		fmt.Printf("[%d] Running %s\n", e.Step, e.Actor)
+		fmt.Printf("    code: %s\n", e.Message.String())
+	case EventActorBlocked:
+		fmt.Printf("    %s blocked: %s\n", e.Actor, e.Reason)
+	case EventActorStepped:
+		traceStep(e.Actor, e.Result)
+	}
+}
+
+// traceStep prints what an actor's step came to; a blocked step has
+// already been printed by its EventActorBlocked
+func traceStep(name string, result Value) {
+	switch {
+	case result.Type == TypeBlocked:
+		return
+	case result.Type == TypeSymbol && result.Symbol == "yield":
+		fmt.Printf("    result: %s\n", result.String())
+		fmt.Printf("    %s yielded\n", name)
+	case result.Type == TypeSymbol && result.Symbol == "done":
+		fmt.Printf("    result: %s\n", result.String())
+		fmt.Printf("    %s done\n", name)
+	case result.Type == TypeTagged && result.Tagged.Tag == "error":
+		fmt.Printf("    %s stopped: %s\n", name, result.String())
+	case result.IsList() && len(result.List) >= 2 && result.List[0].IsSymbol() && result.List[0].Symbol == "become":
+		fmt.Printf("    %s become %s\n", name, nextCode(result).String())
+	default:
+		fmt.Printf("    result: %s\n", result.String())
+	}
+}
+
+// ============================================================================
+// Schedule Hooks
+// ============================================================================
+//
+// (on-schedule-event! fn) calls fn with each scheduler event as a list:
+//
+//   (before-step actor)          an actor is about to take a step
+//   (after-step actor result)    it has taken it
+//   (blocked actor reason)       the step blocked
+//   (sent from to msg)           a message went into a mailbox
+//   (asserted (pred args...))    a fact was asserted
+//   (run-finished result)        run-scheduler returned
+//
+// so instrumentation, invariant checks and fault injection can be written
+// in LISP. The hook runs as top-level code, outside the running actor and
+// its step's journal, so its sends come from external and never block an
+// actor. The events it causes itself aren't passed back to it.
+
+// scheduleEventValue is e as a hook sees it; false for events hooks
+// don't get
+func scheduleEventValue(e SchedEvent) (Value, bool) {
+	switch e.Kind {
+	case EventActorScheduled:
+		return Lst(Sym("before-step"), Sym(e.Actor)), true
+	case EventActorStepped:
+		return Lst(Sym("after-step"), Sym(e.Actor), e.Result), true
+	case EventActorBlocked:
+		return Lst(Sym("blocked"), Sym(e.Actor), Str(e.Reason)), true
+	case EventMessageSent:
+		return Lst(Sym("sent"), Sym(e.Actor), Sym(e.Target), e.Message), true
+	case EventFactAsserted:
+		return Lst(Sym("asserted"), factValue(*e.Fact)), true
+	case EventRunFinished:
+		return Lst(Sym("run-finished"), e.Result), true
+	}
+	return Nil(), false
+}
+
+// (on-schedule-event! fn) - call fn with every scheduler event; returns
+// an id for remove-schedule-hook!
+func builtinOnScheduleEvent(ev *Evaluator, args []Value, env *Env) Value {
+	fn := args[0]
+	running := false
+	id := ev.Events.Subscribe(func(ev *Evaluator, e SchedEvent) {
+		event, ok := scheduleEventValue(e)
+		if !ok || running {
+			return
+		}
+		running = true
+		journaling, current := ev.journaling, ev.Scheduler.CurrentActor
+		ev.journaling, ev.Scheduler.CurrentActor = nil, ""
+		if result := ev.apply(fn, []Value{event}, ev.GlobalEnv); result.Type == TypeBlocked {
+			ev.warn("schedule-hook-blocked", "on-schedule-event!: hook would block on %s; hooks must not block", event.String())
+		}
+		ev.journaling, ev.Scheduler.CurrentActor = journaling, current
+		running = false
+	})
+	if ev.scheduleHooks == nil {
+		ev.scheduleHooks = make(map[int]bool)
+	}
+	ev.scheduleHooks[id] = true
+	return Int(int64(id))
+}
+
+// (remove-schedule-hook! id) - stop calling a hook; true if it was set
+func builtinRemoveScheduleHook(ev *Evaluator, args []Value, env *Env) Value {
+	id := int(args[0].Number)
+	if !ev.scheduleHooks[id] {
+		return Bool(false)
+	}
+	delete(ev.scheduleHooks, id)
+	ev.Events.Unsubscribe(id)
+	return Bool(true)
+}
+
+// ============================================================================
+// Fact Subscriptions
+// ============================================================================
+//
+// (subscribe! '(stockout ?day)) puts a copy of every matching fact asserted
+// from then on into the subscribing actor's mailbox, as the list
+// (stockout 3). Observers react to facts by receiving instead of polling
+// the database every step.
+
+// factSubscriber delivers asserted facts to the actors subscribed to them
+func factSubscriber(ev *Evaluator, e SchedEvent) {
+	if e.Kind != EventFactAsserted {
+		return
+	}
+	for _, name := range ev.Scheduler.Names() {
+		actor := ev.Scheduler.Actors[name]
+		if actor.State == ActorDone {
+			continue
+		}
+		for _, pattern := range actor.Subscriptions {
+			if pattern.Predicate != e.Fact.Predicate || len(pattern.Args) != len(e.Fact.Args) {
+				continue
+			}
+			if _, ok := UnifyArgs(pattern.Args, e.Fact.Args, make(Binding)); !ok {
+				continue
+			}
+			msg := factValue(*e.Fact)
+			if !actor.Mailbox.SendStamped(msg, 0, ev.stamp()) {
+				ev.warn("subscription-full:"+name, "%s: mailbox full, dropped subscribed fact %s", name, msg.String())
+			} else if actor.State == ActorBlocked && strings.HasPrefix(actor.BlockedOn, "recv") {
+				ev.Scheduler.UnblockActor(name)
+			}
+			break // One copy per fact, however many patterns match
+		}
+	}
+}
+
+// factValue is a fact as the list (predicate args...)
+func factValue(f Fact) Value {
+	items := []Value{Sym(f.Predicate)}
+	for _, a := range f.Args {
+		items = append(items, TermToValue(a))
+	}
+	return Lst(items...)
+}
+
+// subscriptionArgs finds the pattern and the actor for subscribe!/unsubscribe!
+func (ev *Evaluator) subscriptionArgs(name string, args []Value) (*Actor, Goal, bool) {
+	if len(args) == 0 || args[0].Type != TypeList || len(args[0].List) == 0 || !args[0].List[0].IsSymbol() {
+		ev.warn("", "%s: expected a fact pattern like '(stockout ?day)", name)
+		return nil, Goal{}, false
+	}
+	pattern := parseGoal(args[0])
+	if pattern.Negated || pattern.IsBuiltin {
+		ev.warn("", "%s: pattern must be a plain fact, got %s", name, args[0].String())
+		return nil, Goal{}, false
+	}
+	actor := ev.subscriber(name, args)
+	return actor, pattern, actor != nil
+}
+
+// subscriber is the actor subscribe!/unsubscribe! acts for: the one named
+// after the pattern or topic, or else the running actor
+func (ev *Evaluator) subscriber(name string, args []Value) *Actor {
+	actorName := ev.Scheduler.CurrentActor
+	if len(args) > 1 {
+		actorName = valueToString(args[1])
+	}
+	actor := ev.Scheduler.GetActor(actorName)
+	if actor == nil {
+		ev.warn(name+"-no-actor", "%s: no actor to subscribe (call it from an actor or name one)", name)
+	}
+	return actor
+}
+
+// (subscribe! pattern [actor]) - deliver facts matching pattern to the
+// current actor's mailbox (or actor's); subscribing twice is harmless.
+// (subscribe! 'topic [actor]) subscribes to a topic instead.
+func builtinSubscribe(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) > 0 && args[0].IsSymbol() {
+		return ev.subscribeTopic("subscribe!", args)
+	}
+	actor, pattern, ok := ev.subscriptionArgs("subscribe!", args)
+	if !ok {
+		return Nil()
+	}
+	for _, p := range actor.Subscriptions {
+		if goalString(p) == goalString(pattern) {
+			return Sym("ok")
+		}
+	}
+	actor.Subscriptions = append(actor.Subscriptions, pattern)
+	return Sym("ok")
+}
+
+// (unsubscribe! pattern [actor]) - stop delivering facts matching pattern,
+// or with a topic, messages published to it
+func builtinUnsubscribe(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) > 0 && args[0].IsSymbol() {
+		return ev.unsubscribeTopic("unsubscribe!", args)
+	}
+	actor, pattern, ok := ev.subscriptionArgs("unsubscribe!", args)
+	if !ok {
+		return Nil()
+	}
+	for i, p := range actor.Subscriptions {
+		if goalString(p) == goalString(pattern) {
+			actor.Subscriptions = append(actor.Subscriptions[:i:i], actor.Subscriptions[i+1:]...)
+			return Bool(true)
+		}
+	}
+	return Bool(false)
+}
+
+func goalString(g Goal) string {
+	return factString(g.Predicate, g.Args)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Event Bus Tests
+// ============================================================================
+
+func TestEventBusPublishesSchedulerEvents(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	counts := make(map[SchedEventKind]int)
+	var finished Value
+	id := ev.Events.Subscribe(func(ev *Evaluator, e SchedEvent) {
+		counts[e.Kind]++
+		if e.Kind == EventRunFinished {
+			finished = e.Result
+		}
+	})
+
+	runCode(ev, `
+		(define (ping) (send-to! 'pong 'hi) 'done)
+		(define (pong) (let msg (receive!) 'done))
+		(spawn-actor 'pong 4 '(pong))
+		(spawn-actor 'ping 4 '(ping))
+		(run-scheduler 20)
+	`)
+
+	if counts[EventMessageSent] != 1 {
+		t.Errorf("expected 1 message-sent event, got %d", counts[EventMessageSent])
+	}
+	if counts[EventActorBlocked] != 1 {
+		t.Errorf("expected pong to block once, got %d", counts[EventActorBlocked])
+	}
+	if counts[EventActorScheduled] < 3 {
+		t.Errorf("expected at least 3 scheduled events, got %d", counts[EventActorScheduled])
+	}
+	// spawned x2, sent, received
+	if counts[EventFactAsserted] < 4 {
+		t.Errorf("expected at least 4 fact-asserted events, got %d", counts[EventFactAsserted])
+	}
+	if counts[EventRunFinished] != 1 || finished.String() != "(completed 3)" {
+		t.Errorf("expected one run-finished with (completed 3), got %d %s", counts[EventRunFinished], finished.String())
+	}
+
+	ev.Events.Unsubscribe(id)
+	runCode(ev, `(run-scheduler 1)`)
+	if counts[EventRunFinished] != 1 {
+		t.Errorf("unsubscribed handler still called")
+	}
+}
+
+func TestTraceComesFromTheBus(t *testing.T) {
+	ev := NewEvaluator(1000)
+	runCode(ev, `
+		(define (ping) (send-to! 'pong 'hi) 'done)
+		(define (pong) (let msg (receive!) (list 'become '(pong))))
+		(spawn-actor 'pong 4 '(pong))
+		(spawn-actor 'ping 4 '(ping))
+		(set-trace! true)
+	`)
+	r, w, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = w
+	runCode(ev, `(run-scheduler 4)`)
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	for _, want := range []string{"pong blocked: recv", "ping done", "pong become (pong)"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("trace has no %q:\n%s", want, out)
+		}
+	}
+
+	// Without its subscriber there is no trace
+	ev = NewEvaluator(1000)
+	ev.Events = NewEventBus()
+	r, w, _ = os.Pipe()
+	os.Stdout = w
+	runCode(ev, `
+		(define (ping) 'yield)
+		(spawn-actor 'ping 4 '(ping))
+		(set-trace! true)
+		(run-scheduler 2)
+	`)
+	os.Stdout = stdout
+	w.Close()
+	if out, _ := io.ReadAll(r); strings.Contains(string(out), "ping") {
+		t.Errorf("traced without a subscriber:\n%s", out)
+	}
+}
+
+func TestFactSubscriptions(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (auditor)
+		  (subscribe! '(stockout ?day))
+		  `+"`"+`(become (audit-loop)))
+		(define (audit-loop)
+		  (let msg (receive!)
+		    (begin (assert! 'alerted (nth msg 1))
+		           `+"`"+`(become (audit-loop)))))
+		(define (baker)
+		  (assert! 'stockout 1)
+		  (assert! 'sold 2)
+		  (assert! 'stockout 3)
+		  'done)
+		(spawn-actor 'auditor 8 '(auditor))
+		(spawn-actor 'baker 8 '(baker))
+		(run-scheduler 20)
+	`)
+
+	if got := evalLast(ev, `(fact-count 'alerted)`).String(); got != "2" {
+		t.Errorf("expected 2 alerts, got %s", got)
+	}
+	if got := evalLast(ev, `(eventually? '(alerted 3))`).String(); got != "true" {
+		t.Errorf("expected an alert for day 3")
+	}
+	if a := ev.Scheduler.GetActor("auditor"); a.State != ActorBlocked || !a.Mailbox.IsEmpty() {
+		t.Errorf("auditor should be waiting on an empty mailbox, state %v", a.State)
+	}
+
+	// Subscribing from outside, constants in the pattern, and unsubscribing
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(subscribe! '(stockout 5) 'baker)`, "ok"},
+		{`(subscribe! '(stockout 5) 'baker)`, "ok"},
+		{`(assert! 'stockout 4)`, "ok"},
+		{`(assert! 'stockout 5)`, "ok"},
+		{`(unsubscribe! '(stockout 5) 'baker)`, "true"},
+		{`(unsubscribe! '(stockout 5) 'baker)`, "false"},
+		{`(subscribe! '(stockout ?d))`, "nil"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	// baker is done, so nothing was delivered; the auditor got day 4 and 5
+	if n := len(ev.Scheduler.GetActor("auditor").Mailbox.Data); n != 2 {
+		t.Errorf("expected 2 queued alerts for the auditor, got %d", n)
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	ev := NewEvaluator(1000)
+	runCode(ev, `
+		(define log '())
+		(define (note what) (set! log (append log (list (list (self) what)))))
+		(define (worker) (let msg (receive!) 'done))
+		(spawn-actor 'worker 4 '(worker)
+		  :on-start (lambda () (note 'start))
+		  :on-block (lambda () (note 'block))
+		  :on-stop (lambda () (note 'stop)))
+		(spawn-actor 'boss 4 '(begin (send-to! 'worker 'go) 'done)
+		  :on-stop (lambda () (note 'stop)))
+		(run-scheduler 20)
+	`)
+	want := "((worker start) (worker block) (boss stop) (worker stop))"
+	if got := evalLast(ev, "log").String(); got != want {
+		t.Errorf("hooks ran as %s, want %s", got, want)
+	}
+
+	// A hook that would block is abandoned and the actor carries on
+	ev = NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(spawn-actor 'stuck 4 '(begin (assert! 'ran) 'done)
+		  :on-start (lambda () (receive!)))
+		(spawn-actor 'typo 4 ''done :on-stop 'not-a-function)
+		(run-scheduler 20)
+	`)
+	if got := evalLast(ev, "(fact-count 'ran)").String(); got != "1" {
+		t.Errorf("body should run once after a blocking hook, ran %s times", got)
+	}
+	if a := ev.Scheduler.GetActor("stuck"); a.State != ActorDone {
+		t.Errorf("stuck should be done, state %v", a.State)
+	}
+	if !ev.SeenErrors["hook-blocked:stuck:on-start"] {
+		t.Errorf("expected a warning for the blocking hook")
+	}
+}
+
+func TestScheduleHooks(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define events '())
+		(define hook (on-schedule-event! (lambda (e) (set! events (cons e events)))))
+		(spawn-actor 'pong 4 '(begin (receive!) 'done))
+		(spawn-actor 'ping 4 '(begin (send-to! 'pong 'hi) 'done))
+		(run-scheduler 20)
+		(remove-schedule-hook! hook)
+		(run-scheduler 20)
+	`)
+	want := []string{
+		"(asserted (spawned pong))",
+		"(asserted (spawned ping))",
+		"(before-step pong)",
+		`(blocked pong "recv (empty)")`,
+		"(after-step pong <blocked: queue empty>)",
+		`(asserted (blocked pong "recv (empty)"))`,
+		"(before-step ping)",
+		"(asserted (sent ping pong hi))",
+		"(asserted (lamport-sent ping pong hi 1))",
+		"(sent ping pong hi)",
+		"(after-step ping done)",
+		"(asserted (done ping))",
+		"(asserted (unblocked pong))",
+		"(before-step pong)",
+		"(asserted (received pong hi))",
+		"(asserted (lamport-received pong hi 3))",
+		"(after-step pong done)",
+		"(asserted (done pong))",
+		"(run-finished (completed 3))",
+	}
+	var got []string
+	for _, e := range evalLast(ev, "events").List {
+		got = append([]string{e.String()}, got...)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("hook saw:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if got := evalLast(ev, "(remove-schedule-hook! hook)").String(); got != "false" {
+		t.Errorf("removing a hook twice = %s, want false", got)
+	}
+
+	// A hook can check invariants and inject faults
+	ev = NewEvaluator(1000)
+	ev.Quiet = true
+	result := evalLast(ev, `
+		(on-schedule-event! (lambda (e)
+		  (if (and (equal? (first e) 'before-step) (equal? (nth e 1) 'flaky))
+		      (kill-actor! 'flaky 'injected))))
+		(define seen '())
+		(spawn-actor 'watcher 4 '(begin (set! seen (receive!)) 'done))
+		(spawn-actor 'flaky 4 '(begin (assert! 'ran) 'done))
+		(monitor! 'watcher 'flaky)
+		(list (run-scheduler 20) seen (fact-count 'ran))`)
+	if got := result.String(); got != "((completed 2) (exit flaky injected) 0)" {
+		t.Errorf("fault injection gave %s", got)
+	}
+
+	// Sends from a hook come from outside and never block an actor
+	ev = NewEvaluator(1000)
+	ev.Quiet = true
+	result = evalLast(ev, `
+		(spawn-actor 'log 1 ''yield)
+		(on-schedule-event! (lambda (e) (if (equal? (first e) 'after-step) (send-to! 'log e))))
+		(spawn-actor 'w 4 '(begin 'done))
+		(list (run-scheduler 6) (query 'sent 'external 'log '?m))`)
+	if got := result.String(); got != "((max-steps 6) (((m (after-step log yield)))))" {
+		t.Errorf("hook sends gave %s", got)
+	}
+	if !ev.SeenErrors["schedule-hook-blocked"] {
+		t.Errorf("expected a warning for the send that would block")
+	}
+}
@@ -28,7 +28,7 @@
   (and (list? form)
        (not (empty? form))
        (symbol? (first form))
-       (= (first form) name)))
+       (equal? (first form) name)))
 
 ; Check if form is a blocking operation (guard)
 (define (is-guard? form)
@@ -54,7 +54,7 @@
   (or (is-form? form 'become)
       (and (is-form? form 'list)
            (>= (length form) 2)
-           (= (second form) (quote 'become)))))
+           (equal? (second form) (quote 'become)))))
 
 ; ----------------------------------------------------------------------------
 ; Violation Detection
@@ -216,7 +216,7 @@
 (define (contains-symbol? form sym)
   (cond
     ((nil? form) false)
-    ((symbol? form) (= form sym))
+    ((symbol? form) (equal? form sym))
     ((not (list? form)) false)
     ((empty? form) false)
     (true (or (contains-symbol? (first form) sym)
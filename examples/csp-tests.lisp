@@ -23,7 +23,7 @@
   (and (list? form)
        (not (empty? form))
        (symbol? (first form))
-       (= (first form) name)))
+       (equal? (first form) name)))
 
 (define (is-guard? form)
   (or (is-form? form 'receive!)
@@ -128,9 +128,9 @@
 (define (counter-loop)
   (let msg (receive!)           ; GUARD first
     (cond
-      ((= msg 'inc)
+      ((equal? msg 'inc)
        (set! count (+ count 1)))  ; Effect after guard
-      ((= msg 'dec)
+      ((equal? msg 'dec)
        (set! count (- count 1)))  ; Effect after guard
       (true nil))
     (if (>= count max-count)
@@ -172,9 +172,9 @@
 ; Run scheduler
 (let result (run-scheduler 50)
   (cond
-    ((= (first result) 'completed)
+    ((equal? (first result) 'completed)
      (println "  ✓ Actors completed successfully"))
-    ((= (first result) 'deadlock)
+    ((equal? (first result) 'deadlock)
      (println "  ✗ Deadlock detected: " result))
     (true
      (println "  ⚠ Max steps reached: " result))))
@@ -275,7 +275,7 @@
 
 (let result (run-scheduler 100)
   (cond
-    ((= (first result) 'completed)
+    ((equal? (first result) 'completed)
      (do
        (println "  ✓ Ping-pong completed")
        (println "  Exchanges: " *ping-pong-count*)
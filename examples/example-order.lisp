@@ -33,10 +33,10 @@
 ; Customer States
 ; ============================================================================
 
-(defstate 'customer 'idle '(= status idle))
-(defstate 'customer 'ordering '(= status ordering))
-(defstate 'customer 'awaiting '(= status awaiting))
-(defstate 'customer 'complete '(= status complete))
+(defstate 'customer 'idle '(equal? status idle))
+(defstate 'customer 'ordering '(equal? status ordering))
+(defstate 'customer 'awaiting '(equal? status awaiting))
+(defstate 'customer 'complete '(equal? status complete))
 
 ; ============================================================================
 ; Customer Transitions
@@ -66,7 +66,7 @@
 
 (defstate 'merchant 'ready '(> inventory 0))
 (defstate 'merchant 'out-of-stock '(= inventory 0))
-(defstate 'merchant 'processing '(= mstatus processing))
+(defstate 'merchant 'processing '(equal? mstatus processing))
 
 ; ============================================================================
 ; Merchant Transitions
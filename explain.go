@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ============================================================================
+// Explanations
+// ============================================================================
+//
+// A query says that (deadlock a b) holds but not why. Wrapping it,
+//
+//   (explain (query 'deadlock '?x '?y))
+//
+// answers it as usual and gives, with each answer, a proof of it: a tree
+// whose root is the query goal as answered and whose nodes are the rule
+// instances that derived each goal, down to the facts, negations and
+// comparisons they rest on. query-all is explained the same way, one
+// proof per goal.
+//
+// Answers are found first, then each is proved again goal by goal: a
+// fact matching the goal is its own proof, and otherwise each rule for
+// it is tried, over each solution of its body, until every body goal
+// has a proof. A goal isn't proved in terms of itself, so cycles in the
+// data give the shortest derivations rather than endless ones, and a
+// goal proved once is reused wherever else it appears. Only one proof is
+// given for each answer, however many there are.
+//
+// The {{explanation}} tool draws the proofs as mermaid flowcharts or as
+// nested lists.
+
+// Proof is why a goal holds
+type Proof struct {
+	Kind     string   // "fact", "rule", "not", "check" or "unexplained"
+	Goal     Goal     // The goal as proved, variables bound
+	Rule     string   // For "rule", the rule that derived it
+	Time     int64    // For "fact", when the fact was asserted
+	Premises []*Proof // For "rule", proofs of the rule's body goals
+}
+
+// Explanation is one answer to a query and the proofs of its goals
+type Explanation struct {
+	Answer Binding
+	Proofs []*Proof
+}
+
+// prover proves the goals of one explained query
+type prover struct {
+	db      *DatalogDB
+	proved  map[string]*Proof // Ground goals already proved
+	path    map[string]bool   // Goals being proved, by callKey
+	renamed int               // Rule instances so far, for fresh variables
+}
+
+// Explain answers goals top-down, with a proof of each answer
+func (db *DatalogDB) Explain(goals ...Goal) []Explanation {
+	p := &prover{db: db, proved: make(map[string]*Proof), path: make(map[string]bool)}
+	var out []Explanation
+	for _, answer := range db.QueryGoals(goals...) {
+		e := Explanation{Answer: answer}
+		b := answer
+		for _, g := range goals {
+			proof, nb := p.prove(g, b)
+			if proof == nil {
+				proof = &Proof{Kind: "unexplained", Goal: bindGoal(g, b)}
+			} else {
+				b = nb
+			}
+			e.Proofs = append(e.Proofs, proof)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// prove finds a proof of goal under b, and b extended by whatever the
+// proof bound, or nil if there is none
+func (p *prover) prove(goal Goal, b Binding) (*Proof, Binding) {
+	db := p.db
+	if goal.Negated || goal.IsBuiltin || isTemporalPredicate(goal.Predicate) {
+		solutions := db.solve([]Goal{goal}, b, 0)
+		if len(solutions) == 0 {
+			return nil, nil
+		}
+		kind := "check"
+		if goal.Negated {
+			kind = "not"
+		}
+		return &Proof{Kind: kind, Goal: bindGoal(goal, solutions[0])}, solutions[0]
+	}
+
+	key, _ := callKey(goal, b)
+	ground := groundTerms(bindGoal(goal, b).Args)
+	if proof, ok := p.proved[key]; ok {
+		nb, _ := UnifyArgs(goal.Args, proof.Goal.Args, b)
+		return proof, nb
+	}
+	if p.path[key] || len(p.path) >= maxTableNesting {
+		return nil, nil
+	}
+	p.path[key] = true
+	defer delete(p.path, key)
+
+	for _, i := range db.factsFor(goal.Predicate, goal.Args, b) {
+		if nb, ok := UnifyArgs(goal.Args, db.Facts[i].Args, b); ok {
+			return p.remember(key, ground, &Proof{Kind: "fact", Goal: bindGoal(goal, nb), Time: db.Facts[i].Time}), nb
+		}
+	}
+	for _, rule := range db.Rules {
+		if rule.Head.Predicate != goal.Predicate {
+			continue
+		}
+		// Past any depth solve renames at, so the names are fresh
+		p.renamed++
+		r := db.renameVars(rule, maxDepth+p.renamed)
+		nb, ok := UnifyArgs(goal.Args, r.Head.Args, b)
+		if !ok {
+			continue
+		}
+		for _, body := range db.solve(r.Body, nb, 0) {
+			if premises, bb := p.proveAll(r.Body, body); premises != nil {
+				proof := &Proof{Kind: "rule", Goal: bindGoal(goal, bb), Rule: rule.Name, Premises: premises}
+				return p.remember(key, ground, proof), bb
+			}
+		}
+	}
+	return nil, nil
+}
+
+// proveAll proves each of goals in turn, or gives nil if one can't be
+func (p *prover) proveAll(goals []Goal, b Binding) ([]*Proof, Binding) {
+	proofs := make([]*Proof, 0, len(goals))
+	for _, g := range goals {
+		proof, nb := p.prove(g, b)
+		if proof == nil {
+			return nil, nil
+		}
+		proofs = append(proofs, proof)
+		b = nb
+	}
+	return proofs, b
+}
+
+// remember keeps proof for reuse if its goal was ground when asked
+func (p *prover) remember(key string, ground bool, proof *Proof) *Proof {
+	if ground {
+		p.proved[key] = proof
+	}
+	return proof
+}
+
+func isTemporalPredicate(pred string) bool {
+	switch pred {
+	case "at-time", "before", "after", "between":
+		return true
+	}
+	return false
+}
+
+// bindGoal is goal with its variables replaced by their values in b
+func bindGoal(goal Goal, b Binding) Goal {
+	bound := goal
+	bound.Args = make([]Term, len(goal.Args))
+	for i, a := range goal.Args {
+		bound.Args[i] = b.Deref(a)
+	}
+	return bound
+}
+
+// label describes what a proof step shows, as in "(edge a b) fact @ t=3"
+func (pr *Proof) label() string {
+	s := pr.Goal.String()
+	switch pr.Kind {
+	case "rule":
+		return s + " by " + pr.Rule
+	case "fact":
+		if pr.Time > 0 {
+			return fmt.Sprintf("%s fact @ t=%d", s, pr.Time)
+		}
+		return s + " fact"
+	case "unexplained":
+		return s + " unexplained"
+	}
+	return s
+}
+
+// goalValue is a goal as a LISP list, without any not
+func goalValue(g Goal) Value {
+	pred := g.Predicate
+	if g.IsBuiltin {
+		pred = g.Builtin
+	}
+	items := []Value{Sym(pred)}
+	for _, a := range g.Args {
+		items = append(items, TermToValue(a))
+	}
+	return Lst(items...)
+}
+
+// proofValue is a proof as LISP: (fact goal [time]), (rule name goal
+// premise...), (not goal), (check goal) or (unexplained goal)
+func proofValue(pr *Proof) Value {
+	items := []Value{Sym(pr.Kind)}
+	switch pr.Kind {
+	case "rule":
+		items = append(items, Sym(pr.Rule), goalValue(pr.Goal))
+		for _, premise := range pr.Premises {
+			items = append(items, proofValue(premise))
+		}
+	case "fact":
+		items = append(items, goalValue(pr.Goal))
+		if pr.Time > 0 {
+			items = append(items, Int(pr.Time))
+		}
+	default:
+		items = append(items, goalValue(pr.Goal))
+	}
+	return Lst(items...)
+}
+
+// evalExplain is the explain special form: (explain (query ...)) or
+// (explain (query-all ...)), each answer as (bindings proof...)
+func (ev *Evaluator) evalExplain(args []Value, env *Env) Value {
+	if len(args) == 0 || args[0].Type != TypeList || len(args[0].List) == 0 ||
+		!args[0].List[0].IsSymbol() || (args[0].List[0].Symbol != "query" && args[0].List[0].Symbol != "query-all") {
+		ev.warnTrace("", "explain: needs a (query ...) or (query-all ...) to explain")
+		return Nil()
+	}
+	name := args[0].List[0].Symbol
+	vals := make([]Value, 0, len(args[0].List)-1)
+	for _, a := range args[0].List[1:] {
+		v := ev.Eval(a, env)
+		if v.Type == TypeBlocked {
+			return v
+		}
+		vals = append(vals, v)
+	}
+	// Answers are the same either way, and proofs are found top-down
+	vals, opts := keywordArgs(vals)
+	if _, ok := queryMode(ev, name, opts); !ok {
+		return Nil()
+	}
+	goals := queryGoals(name, vals)
+	if len(goals) == 0 {
+		return Lst()
+	}
+
+	var rows []Value
+	for _, e := range ev.DatalogDB.Explain(goals...) {
+		row := []Value{BindingsToValue(e.Answer)}
+		for _, pr := range e.Proofs {
+			row = append(row, proofValue(pr))
+		}
+		rows = append(rows, Lst(row...))
+	}
+	return Lst(rows...)
+}
+
+// toolExplanation renders the proofs of a query's answers as mermaid
+// flowcharts, or with format="markdown" as nested lists
+func toolExplanation(ev *Evaluator, args map[string]string) string {
+	src := args["query"]
+	if src == "" {
+		return "<!-- explanation: missing query arg -->"
+	}
+	exprs, errs := NewParser(src).Parse()
+	if len(errs) > 0 {
+		return fmt.Sprintf("<!-- explanation: can't parse query: %s -->", errs[0].Msg)
+	}
+	var goals []Goal
+	for _, e := range exprs {
+		if e.Type == TypeList && len(e.List) == 2 && e.List[0].IsSymbol() && e.List[0].Symbol == "quote" {
+			e = e.List[1]
+		}
+		if g := parseGoal(e); g.Predicate != "" || g.IsBuiltin {
+			goals = append(goals, g)
+		}
+	}
+	if len(goals) == 0 {
+		return "<!-- explanation: query has no goals -->"
+	}
+	limit := 3
+	if l, ok := args["limit"]; ok {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+
+	explanations := ev.DatalogDB.Explain(goals...)
+	if len(explanations) == 0 {
+		return fmt.Sprintf("*No answers to `%s`*\n", strings.TrimSpace(src))
+	}
+	var sb strings.Builder
+	for i, e := range explanations {
+		if i >= limit {
+			sb.WriteString(fmt.Sprintf("*...and %d more answers*\n", len(explanations)-limit))
+			break
+		}
+		sb.WriteString(fmt.Sprintf("**%s**\n\n", answerString(e.Answer)))
+		if args["format"] == "markdown" {
+			for _, pr := range e.Proofs {
+				writeProofList(&sb, pr, 0)
+			}
+			sb.WriteString("\n")
+		} else {
+			writeProofFlowchart(&sb, e.Proofs)
+		}
+	}
+	return sb.String()
+}
+
+// answerString is an answer's bindings as "?x = a, ?y = b"
+func answerString(b Binding) string {
+	if len(b) == 0 {
+		return "yes"
+	}
+	parts := make([]string, 0, len(b))
+	for _, k := range sortedKeys(b) {
+		parts = append(parts, fmt.Sprintf("?%s = %s", k, b[k].String()))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func writeProofList(sb *strings.Builder, pr *Proof, depth int) {
+	fmt.Fprintf(sb, "%s- `%s`", strings.Repeat("  ", depth), pr.Goal.String())
+	switch pr.Kind {
+	case "rule":
+		fmt.Fprintf(sb, " by rule `%s`", pr.Rule)
+	case "fact":
+		sb.WriteString(" fact")
+		if pr.Time > 0 {
+			fmt.Fprintf(sb, " @ t=%d", pr.Time)
+		}
+	case "unexplained":
+		sb.WriteString(" *unexplained*")
+	}
+	sb.WriteString("\n")
+	for _, premise := range pr.Premises {
+		writeProofList(sb, premise, depth+1)
+	}
+}
+
+// writeProofFlowchart draws proofs top-down from the goals answered, a
+// proof shared by several rule instances drawn once
+func writeProofFlowchart(sb *strings.Builder, proofs []*Proof) {
+	sb.WriteString("```mermaid\nflowchart TD\n")
+	ids := make(map[*Proof]string)
+	var draw func(pr *Proof) string
+	draw = func(pr *Proof) string {
+		if id, ok := ids[pr]; ok {
+			return id
+		}
+		id := fmt.Sprintf("p%d", len(ids))
+		ids[pr] = id
+		fmt.Fprintf(sb, "    %s[\"%s\"]\n", id, strings.ReplaceAll(pr.label(), "\"", "#quot;"))
+		for _, premise := range pr.Premises {
+			fmt.Fprintf(sb, "    %s --> %s\n", id, draw(premise))
+		}
+		return id
+	}
+	for _, pr := range proofs {
+		draw(pr)
+	}
+	sb.WriteString("```\n\n")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Explanation Tests
+// ============================================================================
+
+func TestExplainRecursiveRule(t *testing.T) {
+	// A cycle, so (reach a ?) could be proved from itself
+	db := edgesDB("a>b", "b>c", "c>a", "c>d")
+	db.AddRule("reach-edge",
+		Fact{Predicate: "reach", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "edge", Args: []Term{Var("X"), Var("Y")}},
+	)
+	db.AddRule("reach-more",
+		Fact{Predicate: "reach", Args: []Term{Var("X"), Var("Z")}},
+		Goal{Predicate: "reach", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "edge", Args: []Term{Var("Y"), Var("Z")}},
+	)
+
+	explanations := db.Explain(Goal{Predicate: "reach", Args: []Term{Atom("a"), Atom("d")}})
+	if len(explanations) != 1 || len(explanations[0].Proofs) != 1 {
+		t.Fatalf("expected one answer with one proof, got %+v", explanations)
+	}
+	want := "(rule reach-more (reach a d)" +
+		" (rule reach-more (reach a c) (rule reach-edge (reach a b) (fact (edge a b))) (fact (edge b c)))" +
+		" (fact (edge c d)))"
+	if got := proofValue(explanations[0].Proofs[0]).String(); got != want {
+		t.Errorf("proof of (reach a d) =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := len(db.Explain(Goal{Predicate: "reach", Args: []Term{Atom("a"), Var("Y")}})); got != 4 {
+		t.Errorf("explained %d answers to (reach a ?y), want 4", got)
+	}
+	for _, e := range db.Explain(Goal{Predicate: "reach", Args: []Term{Var("X"), Var("Y")}}) {
+		if e.Proofs[0].Kind != "rule" {
+			t.Errorf("answer %s has no derivation: %s", answerString(e.Answer), e.Proofs[0].label())
+		}
+	}
+}
+
+func TestExplainSpecialForm(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(assert-at! 3 'waits 'p1 'p2)
+		(assert-at! 4 'waits 'p2 'p1)
+		(assert! 'waits 'p3 'p3)
+		(assert! 'done 'p3)
+		(rule 'deadlock '(deadlock ?a ?b) '(waits ?a ?b) '(waits ?b ?a) '(not (done ?a)))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(explain (query 'deadlock 'p1 '?who))",
+			"((((who p2)) (rule deadlock (deadlock p1 p2) (fact (waits p1 p2) 3) (fact (waits p2 p1) 4) (not (done p1)))))"},
+		{"(explain (query-all '(deadlock ?a p1) '(> 2 1)))",
+			"((((a p2)) (rule deadlock (deadlock p2 p1) (fact (waits p2 p1) 4) (fact (waits p1 p2) 3) (not (done p2))) (check (> 2 1))))"},
+		{"(explain (query 'deadlock 'p3 '?who))", "()"},
+		{"(length (explain (query 'waits '?a '?b :mode 'bottom-up)))", "3"},
+		{"(explain (+ 1 2))", "nil"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s =\n%s\nwant\n%s", tt.code, got, tt.expected)
+		}
+	}
+	if lastWarning(ev, "explain: needs a (query ...)") == nil {
+		t.Errorf("expected a warning about explaining a non-query, got %v", ev.Warnings)
+	}
+}
+
+func TestExplanationTool(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(assert-at! 3 'waits 'p1 'p2)
+		(assert-at! 4 'waits 'p2 'p1)
+		(rule 'deadlock '(deadlock ?a ?b) '(waits ?a ?b) '(waits ?b ?a))`)
+	tr := NewToolRegistry(ev)
+
+	out := tr.Process(`{{explanation query="(deadlock p1 ?who)"}}`)
+	for _, want := range []string{
+		"**?who = p2**",
+		"```mermaid\nflowchart TD\n",
+		`p0["(deadlock p1 p2) by deadlock"]`,
+		`p1["(waits p1 p2) fact @ t=3"]`,
+		"p0 --> p1",
+		"p0 --> p2",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("flowchart has no %s:\n%s", want, out)
+		}
+	}
+
+	out = tr.Process(`{{explanation query="'(deadlock ?a ?b)" format="markdown" limit="1"}}`)
+	for _, want := range []string{
+		"**?a = p1, ?b = p2**",
+		"- `(deadlock p1 p2)` by rule `deadlock`\n",
+		"  - `(waits p2 p1)` fact @ t=4\n",
+		"*...and 1 more answers*",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("list has no %q:\n%s", want, out)
+		}
+	}
+
+	if out := tr.Process(`{{explanation query="(deadlock p1 p1)"}}`); !strings.Contains(out, "*No answers") {
+		t.Errorf("expected a note that there are no answers, got:\n%s", out)
+	}
+}
@@ -0,0 +1,630 @@
+package main
+
+import (
+	"math/rand"
+)
+
+// ============================================================================
+// Scenario Search
+// ============================================================================
+//
+// find-trace-satisfying explores alternative runs of the current world:
+// random schedules of the runnable actors, optionally interleaved with
+// scripted stimuli from outside. Each run starts from a copy of the world,
+// so the caller's actors, mailboxes and facts are untouched. The first run
+// that satisfies the condition is returned as a scenario script:
+//
+//   ((step customer) (deliver bakery (order 10)) (step bakery) ...)
+//
+// which run-scenario replays step for step.
+
+// worldCopier deep-copies evaluator state. Shared mutable objects (envs,
+// stacks, queues, maps, sets, closures) are copied once so sharing is preserved.
+type worldCopier struct {
+	envs   map[*Env]*Env
+	stacks map[*BoundedStack]*BoundedStack
+	queues map[*BoundedQueue]*BoundedQueue
+	maps   map[*HashMap]*HashMap
+	sets   map[*HashSet]*HashSet
+	funcs  map[*Function]*Function
+}
+
+func newWorldCopier() *worldCopier {
+	return &worldCopier{
+		envs:   make(map[*Env]*Env),
+		stacks: make(map[*BoundedStack]*BoundedStack),
+		queues: make(map[*BoundedQueue]*BoundedQueue),
+		maps:   make(map[*HashMap]*HashMap),
+		sets:   make(map[*HashSet]*HashSet),
+		funcs:  make(map[*Function]*Function),
+	}
+}
+
+func (c *worldCopier) env(e *Env) *Env {
+	if e == nil {
+		return nil
+	}
+	if cp, ok := c.envs[e]; ok {
+		return cp
+	}
+	cp := &Env{}
+	c.envs[e] = cp
+	c.fill(cp, e)
+	return cp
+}
+
+// fill makes cp a copy of e, replacing whatever cp held
+func (c *worldCopier) fill(cp, e *Env) {
+	cp.parent = c.env(e.parent)
+	if e.bindings == nil {
+		cp.bindings = nil
+		cp.frame = make([]binding, len(e.frame))
+		for i, b := range e.frame {
+			cp.frame[i] = binding{ID: b.ID, Name: b.Name, Value: c.value(b.Value)}
+		}
+		return
+	}
+	cp.frame = nil
+	cp.bindings = make(map[string]Value, len(e.bindings))
+	for k, v := range e.bindings {
+		cp.bindings[k] = c.value(v)
+	}
+}
+
+func (c *worldCopier) values(vs []Value) []Value {
+	if vs == nil {
+		return nil
+	}
+	cp := make([]Value, len(vs))
+	for i, v := range vs {
+		cp[i] = c.value(v)
+	}
+	return cp
+}
+
+func (c *worldCopier) stack(s *BoundedStack) *BoundedStack {
+	if cp, ok := c.stacks[s]; ok {
+		return cp
+	}
+	cp := &BoundedStack{Capacity: s.Capacity, Name: s.Name}
+	c.stacks[s] = cp
+	cp.Data = append(make([]Value, 0, s.Capacity), c.values(s.Data)...)
+	return cp
+}
+
+func (c *worldCopier) queue(q *BoundedQueue) *BoundedQueue {
+	if cp, ok := c.queues[q]; ok {
+		return cp
+	}
+	cp := &BoundedQueue{Capacity: q.Capacity, ByteCapacity: q.ByteCapacity, Name: q.Name}
+	c.queues[q] = cp
+	cp.Data = append(make([]Value, 0, q.Capacity), c.values(q.Data)...)
+	cp.Priorities = append([]int(nil), q.Priorities...)
+	cp.Stamps = append([]Stamp(nil), q.Stamps...)
+	return cp
+}
+
+func (c *worldCopier) value(v Value) Value {
+	switch v.Type {
+	case TypeList:
+		v.List = c.values(v.List)
+	case TypeFunc:
+		if cp, ok := c.funcs[v.Func]; ok {
+			v.Func = cp
+			break
+		}
+		f := *v.Func
+		c.funcs[v.Func] = &f
+		f.Env = c.env(v.Func.Env)
+		v.Func = &f
+	case TypeStack:
+		v.Stack = c.stack(v.Stack)
+	case TypeQueue:
+		v.Queue = c.queue(v.Queue)
+	case TypeTagged:
+		v.Tagged = &TaggedValue{Tag: v.Tagged.Tag, Value: c.value(v.Tagged.Value)}
+	case TypeMap:
+		if cp, ok := c.maps[v.Map]; ok {
+			v.Map = cp
+			break
+		}
+		m := NewHashMap()
+		c.maps[v.Map] = m
+		for k, val := range v.Map.Data {
+			m.Data[k] = c.value(val)
+			m.Keys[k] = v.Map.Keys[k]
+		}
+		v.Map = m
+	case TypeSet:
+		if cp, ok := c.sets[v.Set]; ok {
+			v.Set = cp
+			break
+		}
+		set := NewHashSet()
+		c.sets[v.Set] = set
+		for k, item := range v.Set.Items {
+			set.Items[k] = c.value(item)
+		}
+		v.Set = set
+	}
+	return v
+}
+
+// cloneWorld returns an independent copy of ev's actors, mailboxes,
+// environments, registry, modules, capabilities, costs, facts and rand
+// dice.
+// The copy is quiet: prints and warnings are suppressed.
+func cloneWorld(ev *Evaluator) *Evaluator {
+	return newWorldCopier().world(ev)
+}
+
+func (c *worldCopier) world(ev *Evaluator) *Evaluator {
+	w := &Evaluator{
+		CallStack:   NewStack(ev.CallStack.Capacity),
+		GlobalEnv:   c.env(ev.GlobalEnv),
+		Registry:    make(map[string]Value, len(ev.Registry)),
+		GensymCount: ev.GensymCount,
+		Scheduler:   NewScheduler(),
+		DatalogDB:   NewDatalogDB(),
+		SeenErrors:  make(map[string]bool),
+		Events:      NewEventBus(),
+		CapMode:     ev.CapMode,
+		Grants:      make(map[string][]Capability, len(ev.Grants)),
+		Modules:     make(map[string]*Module, len(ev.Modules)),
+		Costs:       NewCostTracker(),
+		Quiet:       true,
+		FileAccess:  ev.FileAccess,
+		Sandbox:     ev.Sandbox,
+		Features:    make(map[string]bool, len(ev.Features)),
+		LoadPath:    append([]string(nil), ev.LoadPath...),
+		snapshots:   ev.snapshots,
+	}
+	ev.copyRand(w)
+	for k, v := range ev.Features {
+		w.Features[k] = v
+	}
+	for k, v := range ev.Registry {
+		w.Registry[k] = c.value(v)
+	}
+
+	s := ev.Scheduler
+	ws := w.Scheduler
+	ws.RunQueue = append(ws.RunQueue, s.RunQueue...)
+	ws.StepCount = s.StepCount
+	ws.MaxSteps = s.MaxSteps
+	ws.CSPEnforce = s.CSPEnforce
+	ws.CSPAbort = s.CSPAbort
+	ws.VectorClocks = s.VectorClocks
+	ws.AutoTrace = s.AutoTrace
+	ws.Faults = s.Faults.copy()
+	ws.Virtual = s.Virtual
+	ws.VirtualTime = s.VirtualTime
+	ws.Trace = s.Trace
+	ws.Policy = s.Policy
+	ws.Quiescence = s.Quiescence
+	for _, stim := range s.Stimuli {
+		ws.Stimuli = append(ws.Stimuli, ScriptedStimulus{stim.Step, stim.Actor, c.value(stim.Message)})
+	}
+	ws.NextStimulus = s.NextStimulus
+	ws.timeouts = s.timeouts
+	ws.asks = s.asks
+	for _, p := range s.Promises {
+		ws.Promises = append(ws.Promises, Promise{p.Delivered, c.value(p.Value)})
+	}
+	for _, step := range s.timerSteps() {
+		for _, t := range s.Timers[step] {
+			t.Message = c.value(t.Message)
+			ws.addTimer(t)
+		}
+	}
+	for name, a := range s.Actors {
+		cp := *a
+		cp.Mailbox = c.queue(a.Mailbox)
+		cp.VClock = a.VClock.copy()
+		cp.Env = c.env(a.Env)
+		cp.Code = c.value(a.Code)
+		cp.Result = c.value(a.Result)
+		cp.OnStart = c.value(a.OnStart)
+		cp.OnStop = c.value(a.OnStop)
+		cp.OnBlock = c.value(a.OnBlock)
+		cp.CSPViolations = append([]string(nil), a.CSPViolations...)
+		cp.Subscriptions = append([]Goal(nil), a.Subscriptions...)
+		cp.Topics = append([]string(nil), a.Topics...)
+		cp.Watchers = append([]string(nil), a.Watchers...)
+		cp.Waits = nil
+		for _, w := range a.Waits {
+			cp.Waits = append(cp.Waits, chanWait{c.queue(w.Queue), w.Send, c.value(w.Value)})
+		}
+		cp.ExitReason = c.value(a.ExitReason)
+		cp.Journal = nil
+		for _, e := range a.Journal {
+			e.Result = c.value(e.Result)
+			cp.Journal = append(cp.Journal, e)
+		}
+		ws.Actors[name] = &cp
+	}
+
+	db := ev.DatalogDB
+	w.DatalogDB.Facts = append(w.DatalogDB.Facts, db.Facts...)
+	w.DatalogDB.Rules = append(w.DatalogDB.Rules, db.Rules...)
+	w.DatalogDB.TimeNow = db.TimeNow
+	w.DatalogDB.AutoTime = db.AutoTime
+	w.DatalogDB.Mode = db.Mode
+	for p := range db.BottomUp {
+		if w.DatalogDB.BottomUp == nil {
+			w.DatalogDB.BottomUp = make(map[string]bool)
+		}
+		w.DatalogDB.BottomUp[p] = true
+	}
+	w.DatalogDB.OnAssert = func(f Fact) {
+		w.emit(SchedEvent{Kind: EventFactAsserted, Fact: &f})
+	}
+	w.Events.Subscribe(traceSubscriber)
+	w.Events.Subscribe(factSubscriber)
+
+	for actor, caps := range ev.Grants {
+		w.Grants[actor] = append([]Capability(nil), caps...)
+	}
+	for name, m := range ev.Modules {
+		w.Modules[name] = &Module{Name: m.Name, Env: c.env(m.Env), Exports: m.Exports}
+	}
+	for k, v := range ev.Costs.Table {
+		w.Costs.Table[k] = v
+	}
+	for k, v := range ev.Costs.ByActor {
+		w.Costs.ByActor[k] = v
+	}
+	for k, v := range ev.Costs.Budgets {
+		w.Costs.Budgets[k] = v
+	}
+	w.Costs.Total = ev.Costs.Total
+	return w
+}
+
+// scenarioGoal is the condition a searched run must exhibit
+type scenarioGoal struct {
+	Mode  string // "eventually", "always" or "never"
+	Goals []Goal
+}
+
+// parseScenarioGoal accepts (eventually g...), (always g), (never g),
+// or a bare goal meaning eventually.
+func parseScenarioGoal(v Value) (scenarioGoal, bool) {
+	if v.Type != TypeList || len(v.List) == 0 {
+		return scenarioGoal{}, false
+	}
+	mode := "eventually"
+	goals := []Value{v}
+	if head := v.List[0]; head.IsSymbol() {
+		switch head.Symbol {
+		case "eventually", "always", "never":
+			mode = head.Symbol
+			goals = v.List[1:]
+		}
+	}
+	if len(goals) == 0 {
+		return scenarioGoal{}, false
+	}
+	sg := scenarioGoal{Mode: mode}
+	for _, g := range goals {
+		if g.Type != TypeList || len(g.List) == 0 {
+			return scenarioGoal{}, false
+		}
+		sg.Goals = append(sg.Goals, parseGoal(g))
+	}
+	return sg, true
+}
+
+func (g scenarioGoal) holds(db *DatalogDB) bool {
+	switch g.Mode {
+	case "always":
+		for _, goal := range g.Goals {
+			if !db.Always(goal) {
+				return false
+			}
+		}
+		return true
+	case "never":
+		return len(db.QueryGoals(g.Goals...)) == 0
+	default:
+		return len(db.QueryGoals(g.Goals...)) > 0
+	}
+}
+
+// scenarioSearch bounds the exploration
+type scenarioSearch struct {
+	Goal       scenarioGoal
+	Tries      int
+	Steps      int64
+	Stimuli    []Value // (actor message) pairs that may be delivered
+	MaxStimuli int
+	Seed       int64
+}
+
+// tryScenario makes one random run from a copy of ev and returns its
+// script if the run satisfies the goal.
+func tryScenario(ev *Evaluator, search scenarioSearch, rng *rand.Rand) ([]Value, bool) {
+	w := cloneWorld(ev)
+	w.Scheduler.StepCount = 0
+	w.Scheduler.Trace = false
+	var script []Value
+	delivered := 0
+
+	for w.Scheduler.StepCount < search.Steps {
+		if search.Goal.Mode == "eventually" && search.Goal.holds(w.DatalogDB) {
+			return script, true
+		}
+		runnable := w.Scheduler.RunQueue
+		canDeliver := delivered < search.MaxStimuli && len(search.Stimuli) > 0
+		if len(runnable) == 0 && !canDeliver {
+			break
+		}
+		if canDeliver && (len(runnable) == 0 || rng.Intn(len(runnable)+1) == 0) {
+			stim := search.Stimuli[rng.Intn(len(search.Stimuli))]
+			delivered++
+			if deliverStimulus(w, stim.List[0], stim.List[1]) {
+				script = append(script, Lst(Sym("deliver"), stim.List[0], stim.List[1]))
+			}
+			continue
+		}
+		name := runnable[rng.Intn(len(runnable))]
+		w.stepActor(w.Scheduler.Pick(name))
+		w.Scheduler.CurrentActor = ""
+		script = append(script, Lst(Sym("step"), Sym(name)))
+	}
+	return script, search.Goal.holds(w.DatalogDB)
+}
+
+// deliverStimulus sends msg to actor from outside the system
+func deliverStimulus(ev *Evaluator, actor, msg Value) bool {
+	ev.Scheduler.CurrentActor = ""
+	result := builtinSendTo(ev, []Value{actor, msg}, ev.GlobalEnv)
+	return result.IsSymbol() && result.Symbol == "ok"
+}
+
+// (find-trace-satisfying goal :tries n :steps n :stimuli '((actor msg) ...)
+// :max-stimuli n :seed n) - all options are optional.
+// Returns the scenario script of the first run exhibiting goal, or nil.
+func builtinFindTraceSatisfying(ev *Evaluator, args []Value, env *Env) Value {
+	positional, opts := keywordArgs(args)
+	if len(positional) < 1 {
+		return Nil()
+	}
+	goal, ok := parseScenarioGoal(positional[0])
+	if !ok {
+		ev.warn("", "find-trace-satisfying: goal must be (eventually g), (always g), (never g) or a goal")
+		return Nil()
+	}
+	search := scenarioSearch{Goal: goal, Tries: 200, Steps: 200, MaxStimuli: 3, Seed: 1}
+	if v, ok := opts["tries"]; ok && v.Type == TypeNumber {
+		search.Tries = int(v.Number)
+	}
+	if v, ok := opts["steps"]; ok && v.Type == TypeNumber {
+		search.Steps = int64(v.Number)
+	}
+	if v, ok := opts["max-stimuli"]; ok && v.Type == TypeNumber {
+		search.MaxStimuli = int(v.Number)
+	}
+	if v, ok := opts["seed"]; ok && v.Type == TypeNumber {
+		search.Seed = int64(v.Number)
+	}
+	if v, ok := opts["stimuli"]; ok && v.Type == TypeList {
+		for _, s := range v.List {
+			if s.Type == TypeList && len(s.List) == 2 {
+				search.Stimuli = append(search.Stimuli, s)
+			}
+		}
+	}
+
+	for i := 0; i < search.Tries; i++ {
+		rng := rand.New(rand.NewSource(search.Seed + int64(i)))
+		if script, found := tryScenario(ev, search, rng); found {
+			return Lst(script...)
+		}
+	}
+	return Nil()
+}
+
+// (run-scenario script) - replay a script from find-trace-satisfying.
+// Steps for actors that are not runnable are skipped.
+func builtinRunScenario(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeList {
+		return Nil()
+	}
+	ev.Scheduler.StepCount = 0
+	defer func() { ev.Scheduler.CurrentActor = "" }()
+	for _, entry := range args[0].List {
+		if entry.Type != TypeList || len(entry.List) < 2 || !entry.List[0].IsSymbol() {
+			continue
+		}
+		switch entry.List[0].Symbol {
+		case "step":
+			name := valueToString(entry.List[1])
+			if actor := ev.Scheduler.Pick(name); actor != nil {
+				ev.stepActor(actor)
+			}
+		case "deliver":
+			if len(entry.List) == 3 {
+				deliverStimulus(ev, entry.List[1], entry.List[2])
+			}
+		}
+	}
+	return Lst(Sym("ok"), Int(int64(ev.Scheduler.StepCount)))
+}
+
+// ============================================================================
+// Behavioral Equivalence
+// ============================================================================
+//
+// Two actors are equivalent (up to a bound) if, fed the same sequence of
+// input messages, they send the same messages and end up in the same kind
+// of state after every input. Each actor runs alone on a copy of the
+// world; other actors never run and their mailboxes always have room.
+// For deterministic actors this per-prefix comparison is bisimulation.
+
+// equivSearch bounds an equivalence check
+type equivSearch struct {
+	Inputs []Value // Alphabet of input messages
+	Upto   int     // Input sequences to try
+	Depth  int     // Longest sequence; 0 = as long as Upto allows
+	Steps  int     // Actor steps allowed per input
+}
+
+// observeActor feeds inputs to actor name one at a time and describes
+// what it did before the first input and after each one.
+func observeActor(ev *Evaluator, name string, inputs []Value, steps int) ([]Value, bool) {
+	w := cloneWorld(ev)
+	w.Scheduler.StepCount = 0
+	w.Scheduler.Trace = false
+	actor := w.Scheduler.Actors[name]
+	if actor == nil {
+		return nil, false
+	}
+
+	var sent []Value
+	w.Events.Subscribe(func(w *Evaluator, e SchedEvent) {
+		if e.Kind == EventMessageSent && e.Actor == name {
+			sent = append(sent, Lst(renameSymbol(Sym(e.Target), name), renameSymbol(e.Message, name)))
+		}
+	})
+
+	observe := func() Value {
+		sent = nil
+		for i := 0; i < steps && actor.State == ActorRunnable; i++ {
+			w.stepActor(w.Scheduler.Pick(name))
+			w.Scheduler.CurrentActor = ""
+			for other, a := range w.Scheduler.Actors {
+				if other != name {
+					a.Mailbox.Clear()
+				}
+			}
+			w.tryUnblockActors()
+		}
+		state := "runnable"
+		switch actor.State {
+		case ActorBlocked:
+			state = "blocked"
+		case ActorDone:
+			state = "done"
+		}
+		return Lst(Sym(state), Lst(sent...))
+	}
+
+	obs := []Value{observe()}
+	for _, msg := range inputs {
+		deliverStimulus(w, Sym(name), msg)
+		obs = append(obs, observe())
+	}
+	return obs, true
+}
+
+// renameSymbol replaces an actor's own name with self so two actors that
+// mention themselves can be compared
+func renameSymbol(v Value, name string) Value {
+	switch v.Type {
+	case TypeSymbol:
+		if v.Symbol == name {
+			return Sym("self")
+		}
+	case TypeList:
+		items := make([]Value, len(v.List))
+		for i, x := range v.List {
+			items[i] = renameSymbol(x, name)
+		}
+		return Lst(items...)
+	}
+	return v
+}
+
+// findInequivalence returns a counterexample
+// (inputs (a-state a-sent) (b-state b-sent)) or nil if none was found, and
+// whether every input sequence up to search.Depth was tried
+func findInequivalence(ev *Evaluator, a, b string, search equivSearch) (Value, bool) {
+	queue := [][]Value{nil}
+	for tried := 0; len(queue) > 0 && tried < search.Upto; tried++ {
+		inputs := queue[0]
+		queue = queue[1:]
+
+		obsA, okA := observeActor(ev, a, inputs, search.Steps)
+		obsB, okB := observeActor(ev, b, inputs, search.Steps)
+		if !okA || !okB {
+			return Lst(Sym("unknown-actor")), true
+		}
+		for i := range obsA {
+			if obsA[i].String() != obsB[i].String() {
+				return Lst(Lst(inputs[:i]...), obsA[i], obsB[i]), true
+			}
+		}
+
+		if search.Depth == 0 || len(inputs) < search.Depth {
+			for _, msg := range search.Inputs {
+				next := append(append([]Value(nil), inputs...), msg)
+				queue = append(queue, next)
+			}
+		}
+	}
+	return Nil(), len(queue) == 0
+}
+
+// equivSearchFromArgs reads the search from op's options; false, having
+// warned, without any :inputs to try
+func (ev *Evaluator) equivSearchFromArgs(op string, opts map[string]Value) (equivSearch, bool) {
+	search := equivSearch{Upto: 100, Steps: 50}
+	if v, ok := opts["inputs"]; ok && v.Type == TypeList {
+		search.Inputs = v.List
+	}
+	if len(search.Inputs) == 0 {
+		ev.warn("", "%s: needs :inputs, the messages to feed the actors", op)
+		return search, false
+	}
+	if v, ok := opts["upto"]; ok && v.Type == TypeNumber {
+		search.Upto = int(v.Number)
+	}
+	if v, ok := opts["depth"]; ok && v.Type == TypeNumber {
+		search.Depth = int(v.Number)
+	}
+	if v, ok := opts["steps"]; ok && v.Type == TypeNumber {
+		search.Steps = int(v.Number)
+	}
+	return search, true
+}
+
+// (equivalent? 'a 'b :inputs '(msg ...) :upto n :depth n :steps n) - true
+// if the actors agree on every input sequence up to :depth, false if they
+// don't, and nil if :upto ran out first, so nothing was proved
+func builtinEquivalent(ev *Evaluator, args []Value, env *Env) Value {
+	positional, opts := keywordArgs(args)
+	if len(positional) < 2 {
+		return Bool(false)
+	}
+	search, ok := ev.equivSearchFromArgs("equivalent?", opts)
+	if !ok {
+		return Nil()
+	}
+	a, b := valueToString(positional[0]), valueToString(positional[1])
+	cex, complete := findInequivalence(ev, a, b, search)
+	if cex.Type != TypeNil {
+		ev.warn("", "equivalent?: %s and %s differ: %s", a, b, cex.String())
+		return Bool(false)
+	}
+	if !complete {
+		ev.warn("", "equivalent?: no counterexample in %d input sequences, but there are more to try; give a :depth to check them all", search.Upto)
+		return Nil()
+	}
+	return Bool(true)
+}
+
+// (equivalence-counterexample 'a 'b ...) - same arguments as equivalent?;
+// returns (inputs (a-state a-sent) (b-state b-sent)) or nil
+func builtinEquivalenceCounterexample(ev *Evaluator, args []Value, env *Env) Value {
+	positional, opts := keywordArgs(args)
+	if len(positional) < 2 {
+		return Nil()
+	}
+	search, ok := ev.equivSearchFromArgs("equivalence-counterexample", opts)
+	if !ok {
+		return Nil()
+	}
+	cex, _ := findInequivalence(ev, valueToString(positional[0]), valueToString(positional[1]), search)
+	return cex
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Scenario Search Tests
+// ============================================================================
+
+const shopModel = `
+	(define stock 5)
+	(define (shop)
+	  (let msg (receive!)
+	    (if (> (nth msg 1) stock)
+	        (begin (assert! 'stockout 'bread) (list 'become '(shop)))
+	        (begin (set! stock (- stock (nth msg 1))) (list 'become '(shop))))))
+	(spawn-actor 'shop 4 '(shop))
+`
+
+func TestFindTraceSatisfyingStimuli(t *testing.T) {
+	ev := NewEvaluator(1000)
+	runCode(ev, shopModel)
+
+	script := evalLast(ev, `
+		(find-trace-satisfying '(eventually (stockout ?d))
+		  :stimuli '((shop (order 1)) (shop (order 10)))
+		  :steps 20)
+	`)
+	if script.Type != TypeList || len(script.List) == 0 {
+		t.Fatalf("expected a scenario script, got %s", script.String())
+	}
+	if !strings.Contains(script.String(), "(deliver shop (order 10))") {
+		t.Errorf("stockout needs the large order, got %s", script.String())
+	}
+
+	// Search ran on copies: the real world is untouched
+	if got := evalLast(ev, `(fact-count 'stockout)`).String(); got != "0" {
+		t.Errorf("search leaked facts into the world: %s", got)
+	}
+	if got := evalLast(ev, `stock`).String(); got != "5" {
+		t.Errorf("search leaked state into the world: stock = %s", got)
+	}
+
+	// Replaying the script reproduces the condition
+	ev.Eval(Lst(Sym("run-scenario"), Lst(Sym("quote"), script)), ev.GlobalEnv)
+	if got := evalLast(ev, `(fact-count 'stockout)`).String(); got != "1" {
+		t.Errorf("replay should reproduce the stockout, got %s facts", got)
+	}
+}
+
+func TestFindTraceSatisfyingSchedule(t *testing.T) {
+	ev := NewEvaluator(1000)
+	runCode(ev, `
+		(registry-set! 'first 'none)
+		(define (racer) (if (eq? (registry-get 'first) 'none) (registry-set! 'first (self))) 'done)
+		(define (judge) (assert! 'winner (registry-get 'first)) 'done)
+		(spawn-actor 'a 1 '(racer))
+		(spawn-actor 'b 1 '(racer))
+		(spawn-actor 'judge 1 '(judge))
+	`)
+
+	// Round-robin always lets a win; only a different schedule lets b win
+	script := evalLast(ev, `(find-trace-satisfying '(winner b) :steps 10)`)
+	if script.Type != TypeList {
+		t.Fatalf("expected a schedule where b wins, got %s", script.String())
+	}
+	if strings.Index(script.String(), "(step b)") > strings.Index(script.String(), "(step a)") &&
+		strings.Contains(script.String(), "(step a)") {
+		t.Errorf("b must run before a, got %s", script.String())
+	}
+
+	if got := evalLast(ev, `(find-trace-satisfying '(winner judge) :steps 10 :tries 20)`); got.Type != TypeNil {
+		t.Errorf("impossible condition should find nothing, got %s", got.String())
+	}
+}
+
+// ============================================================================
+// Behavioral Equivalence Tests
+// ============================================================================
+
+const counterModels = `
+	; Counts with a number
+	(define (counter-a n)
+	  (let m (receive!)
+	    (cond ((eq? m 'inc) (list 'become (list 'counter-a (+ n 1))))
+	          ((eq? m 'report) (begin (send-to! 'sink (list 'count n (self))) (list 'become (list 'counter-a n))))
+	          (true (list 'become (list 'counter-a n))))))
+
+	; Same behavior, keeps the history instead
+	(define (counter-b seen)
+	  (let m (receive!)
+	    (cond ((eq? m 'inc) (list 'become (list 'counter-b (list 'quote (cons 'inc seen)))))
+	          ((eq? m 'report) (begin (send-to! 'sink (list 'count (length seen) (self))) (list 'become (list 'counter-b (list 'quote seen)))))
+	          (true (list 'become (list 'counter-b (list 'quote seen)))))))
+
+	; Off by one after two increments
+	(define (counter-c n)
+	  (let m (receive!)
+	    (cond ((eq? m 'inc) (list 'become (list 'counter-c (if (= n 1) (+ n 2) (+ n 1)))))
+	          ((eq? m 'report) (begin (send-to! 'sink (list 'count n (self))) (list 'become (list 'counter-c n))))
+	          (true (list 'become (list 'counter-c n))))))
+
+	(define (sink) (receive!) (list 'become '(sink)))
+	(spawn-actor 'sink 4 '(sink))
+	(spawn-actor 'a 4 '(counter-a 0))
+	(spawn-actor 'b 4 '(counter-b '()))
+	(spawn-actor 'c 4 '(counter-c 0))
+`
+
+func TestEquivalentActors(t *testing.T) {
+	ev := NewEvaluator(1000)
+	runCode(ev, counterModels)
+
+	if got := evalLast(ev, `(equivalent? 'a 'b :inputs '(inc report) :depth 4 :upto 200)`).String(); got != "true" {
+		t.Errorf("refactored counter should be equivalent, got %s", got)
+	}
+	// Running out of :upto before every sequence is tried proves nothing
+	if got := evalLast(ev, `(equivalent? 'a 'b :inputs '(inc report) :upto 200)`).String(); got != "nil" ||
+		lastWarning(ev, "equivalent?: no counterexample in 200 input sequences, but there are more to try; give a :depth to check them all") == nil {
+		t.Errorf("expected nil and a warning without :depth, got %s", got)
+	}
+	if got := evalLast(ev, `(equivalent? 'a 'b :inputs '(inc report) :depth 8 :upto 200)`).String(); got != "nil" {
+		t.Errorf("expected nil when :upto is too small for :depth, got %s", got)
+	}
+	if got := evalLast(ev, `(equivalent? 'a 'c)`).String(); got != "nil" ||
+		lastWarning(ev, "equivalent?: needs :inputs, the messages to feed the actors") == nil {
+		t.Errorf("expected nil and a warning without :inputs, got %s", got)
+	}
+	if got := evalLast(ev, `(equivalent? 'a 'c :inputs '(inc report) :upto 200)`).String(); got != "false" {
+		t.Errorf("buggy counter should not be equivalent, got %s", got)
+	}
+
+	// Shortest distinguishing input comes first (breadth-first)
+	cex := evalLast(ev, `(equivalence-counterexample 'a 'c :inputs '(inc report))`).String()
+	if cex != "((inc inc report) (blocked ((sink (count 2 self)))) (blocked ((sink (count 3 self)))))" {
+		t.Errorf("unexpected counterexample %s", cex)
+	}
+
+	// Nothing ran in the real world
+	if got := evalLast(ev, `(fact-count 'sent)`).String(); got != "0" {
+		t.Errorf("equivalence check leaked %s sent facts", got)
+	}
+}
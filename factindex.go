@@ -0,0 +1,140 @@
+package main
+
+import "sort"
+
+// ============================================================================
+// Fact Indexes
+// ============================================================================
+//
+// Every goal used to be matched against every fact, so a query over a
+// long trace paid for all of its facts at each step of a join. The
+// database keeps two indexes into Facts instead:
+//
+//   - by predicate, so a goal only meets facts it could match
+//   - by predicate and first argument, so a goal whose first argument is
+//     bound, (sent 'bakery ?to ?msg) or the second goal of a join, only
+//     meets facts about that
+//
+// The indexes hold positions in Facts, in the order the facts were
+// asserted, so answers come back in the same order a scan gives. Facts
+// are indexed lazily, on the first lookup after they were added, so
+// Facts can still be appended to directly, as copying a world does.
+// Retracting a fact shifts the ones after it, so it drops the indexes,
+// and the next lookup rebuilds them.
+
+// factIndex locates facts in a DatalogDB's Facts
+type factIndex struct {
+	indexed int                         // Facts indexed so far
+	byPred  map[string][]int            // Positions of each predicate's facts
+	byFirst map[string]map[string][]int // ... by the key of their first argument
+	loose   map[string][]int            // ... whose first argument isn't ground
+}
+
+// reset drops the indexes, to be rebuilt on the next lookup
+func (x *factIndex) reset() {
+	*x = factIndex{}
+}
+
+// firstKey is what the index files a first argument under, and false if
+// it has a variable in it and could match anything
+func firstKey(t Term) (string, bool) {
+	if !t.ground() {
+		return "", false
+	}
+	return t.String(), true
+}
+
+// ground reports whether t has no variables in it
+func (t Term) ground() bool {
+	if t.IsVar {
+		return false
+	}
+	for _, x := range t.List {
+		if !x.ground() {
+			return false
+		}
+	}
+	return true
+}
+
+// catchUp indexes the facts added since the last lookup
+func (db *DatalogDB) catchUp() {
+	x := &db.index
+	if x.indexed > len(db.Facts) {
+		x.reset()
+	}
+	if x.byPred == nil {
+		x.byPred = make(map[string][]int)
+		x.byFirst = make(map[string]map[string][]int)
+		x.loose = make(map[string][]int)
+	}
+	for i := x.indexed; i < len(db.Facts); i++ {
+		f := db.Facts[i]
+		x.byPred[f.Predicate] = append(x.byPred[f.Predicate], i)
+		if len(f.Args) == 0 {
+			continue
+		}
+		key, ok := firstKey(f.Args[0])
+		if !ok {
+			x.loose[f.Predicate] = append(x.loose[f.Predicate], i)
+			continue
+		}
+		if x.byFirst[f.Predicate] == nil {
+			x.byFirst[f.Predicate] = make(map[string][]int)
+		}
+		x.byFirst[f.Predicate][key] = append(x.byFirst[f.Predicate][key], i)
+	}
+	x.indexed = len(db.Facts)
+}
+
+// factsFor returns the positions of the facts of pred that could match
+// args under b, in the order they were asserted
+func (db *DatalogDB) factsFor(pred string, args []Term, b Binding) []int {
+	db.catchUp()
+	if len(args) == 0 {
+		return db.index.byPred[pred]
+	}
+	key, ok := firstKey(b.Deref(args[0]))
+	if !ok {
+		return db.index.byPred[pred]
+	}
+	keyed, loose := db.index.byFirst[pred][key], db.index.loose[pred]
+	if len(loose) == 0 {
+		return keyed
+	}
+	return mergePositions(keyed, loose)
+}
+
+// mergePositions merges two ascending lists of positions
+func mergePositions(a, b []int) []int {
+	merged := make([]int, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0] < b[0] {
+			merged, a = append(merged, a[0]), a[1:]
+		} else {
+			merged, b = append(merged, b[0]), b[1:]
+		}
+	}
+	return append(append(merged, a...), b...)
+}
+
+// temporalFacts returns the positions of the facts a temporal goal
+// (at-time, before, after, between) with predicate term pred looks at:
+// that predicate's, or every fact if pred is a variable. Only asserted
+// facts have times, so a materialized view leaves out the derived ones.
+func (db *DatalogDB) temporalFacts(pred Term) []int {
+	n := len(db.Facts)
+	if db.derived {
+		n = db.asserted
+	}
+	if !pred.IsVar {
+		db.catchUp()
+		positions := db.index.byPred[pred.Name]
+		return positions[:sort.SearchInts(positions, n)]
+	}
+	all := make([]int, n)
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// ============================================================================
+// Fact Index Tests
+// ============================================================================
+
+func answers(results []Binding, name string) string {
+	var s string
+	for _, b := range results {
+		s += b.Deref(Var(name)).String() + " "
+	}
+	return s
+}
+
+func TestFactIndexKeepsOrder(t *testing.T) {
+	db := NewDatalogDB()
+	db.Assert("sent", Atom("a"), Atom("x"))
+	db.Assert("sent", Atom("b"), Atom("y"))
+	db.Assert("sent", Var("Anyone"), Atom("z")) // matches any sender
+	db.Assert("sent", Atom("a"), Atom("w"))
+	db.Assert("received", Atom("a"), Atom("x"))
+
+	if got := answers(db.Query("sent", Atom("a"), Var("M")), "M"); got != "x z w " {
+		t.Errorf("a sent %s, want x z w", got)
+	}
+	if got := answers(db.Query("sent", Var("S"), Var("M")), "M"); got != "x y z w " {
+		t.Errorf("all sent %s, want x y z w", got)
+	}
+	if got := answers(db.Query("sent", Atom("c"), Var("M")), "M"); got != "z " {
+		t.Errorf("c sent %s, want z", got)
+	}
+
+	// A join binds the first argument of its second goal
+	results := db.QueryGoals(
+		Goal{Predicate: "received", Args: []Term{Var("S"), Var("M")}},
+		Goal{Predicate: "sent", Args: []Term{Var("S"), Var("M2")}},
+	)
+	if got := answers(results, "M2"); got != "x z w " {
+		t.Errorf("joined %s, want x z w", got)
+	}
+}
+
+func TestFactIndexFollowsChanges(t *testing.T) {
+	db := NewDatalogDB()
+	db.Assert("item", NumTerm(1), Atom("a"))
+	db.Assert("item", NumTerm(2), Atom("b"))
+	db.Assert("item", NumTerm(1), Atom("c"))
+	if got := answers(db.Query("item", NumTerm(1), Var("X")), "X"); got != "a c " {
+		t.Fatalf("item 1 is %s, want a c", got)
+	}
+
+	db.Retract("item", NumTerm(1), Atom("a"))
+	if got := answers(db.Query("item", NumTerm(1), Var("X")), "X"); got != "c " {
+		t.Errorf("after retract item 1 is %s, want c", got)
+	}
+	if got := answers(db.Query("item", NumTerm(2), Var("X")), "X"); got != "b " {
+		t.Errorf("after retract item 2 is %s, want b", got)
+	}
+
+	// Facts appended directly, as copying a world does, are found too
+	db.Facts = append(db.Facts, Fact{Predicate: "item", Args: []Term{NumTerm(2), Atom("d")}})
+	if got := answers(db.Query("item", NumTerm(2), Var("X")), "X"); got != "b d " {
+		t.Errorf("after append item 2 is %s, want b d", got)
+	}
+
+	db.ClearFacts()
+	db.Assert("item", NumTerm(2), Atom("e"))
+	if got := answers(db.Query("item", NumTerm(2), Var("X")), "X"); got != "e " {
+		t.Errorf("after clear item 2 is %s, want e", got)
+	}
+	if got := answers(db.Query("between", Atom("item"), NumTerm(2), Var("X"), NumTerm(0), NumTerm(10)), "X"); got != "e " {
+		t.Errorf("between found %s, want e", got)
+	}
+}
+
+// ============================================================================
+// Benchmarks
+// ============================================================================
+
+// traceDB is a run's worth of sent facts among actors actors
+func traceDB(facts, actors int) *DatalogDB {
+	db := NewDatalogDB()
+	for i := 0; i < facts; i++ {
+		db.AssertAtTime("sent", int64(i),
+			Atom(fmt.Sprintf("actor%d", i%actors)), Atom(fmt.Sprintf("actor%d", (i+1)%actors)), NumTerm(float64(i)))
+		db.AssertAtTime("received", int64(i),
+			Atom(fmt.Sprintf("actor%d", (i+1)%actors)), NumTerm(float64(i)))
+	}
+	return db
+}
+
+func BenchmarkIndexedLookup(b *testing.B) {
+	db := traceDB(100000, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.Query("sent", Atom("actor7"), Var("To"), Var("Msg"))
+	}
+}
+
+func BenchmarkIndexedJoin(b *testing.B) {
+	db := traceDB(5000, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.QueryGoals(
+			Goal{Predicate: "received", Args: []Term{Var("A"), Var("M")}},
+			Goal{Predicate: "sent", Args: []Term{Var("A"), Var("To"), Var("M2")}},
+		)
+	}
+}
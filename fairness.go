@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ============================================================================
+// Step Budgets and Fairness
+// ============================================================================
+//
+// Round-robin gives every runnable actor a turn, but an actor that never
+// blocks takes a turn every round for as long as the run lasts, and
+// nothing in the result says so. Each actor's steps are counted, along
+// with the longest it has waited for a turn, and
+//
+//   (set-actor-budget! 'worker 100)
+//
+// lets worker take 100 more steps. When they are spent it blocks with
+// reason "out of budget", with a warning and a
+// (budget-exhausted actor step) fact, until it is given more;
+// (set-actor-budget! 'worker nil) lifts the limit.
+//
+// (fairness-report) gives every actor's accounting and asserts it as
+// (fairness actor steps longest-wait) facts, and the {{fairness_report}}
+// tool shows it as a table with each actor's share of all steps taken.
+
+// outOfBudget is the reason an actor that has spent its budget is blocked
+const outOfBudget = "out of budget"
+
+// spendBudget charges actor for the step it just took, stopping it if
+// that was its last
+func (ev *Evaluator) spendBudget(actor *Actor) {
+	if !actor.Budgeted || actor.State == ActorDone {
+		return
+	}
+	actor.Budget--
+	if actor.Budget <= 0 {
+		ev.exhaustBudget(actor)
+	}
+}
+
+func (ev *Evaluator) exhaustBudget(actor *Actor) {
+	ev.warn("budget:"+actor.Name, "%s: out of budget after %d steps", actor.Name, actor.Steps)
+	ev.DatalogDB.AssertAtTime("budget-exhausted", ev.now(),
+		Atom(actor.Name), NumTerm(float64(ev.Scheduler.StepCount)))
+	ev.Scheduler.BlockActor(actor.Name, outOfBudget)
+}
+
+// (fairness-report) - each actor, sorted by name, as
+// (name steps longest-wait budget-left), budget-left nil without a budget;
+// also asserts (fairness name steps longest-wait) for each
+func builtinFairnessReport(ev *Evaluator, args []Value, env *Env) Value {
+	s := ev.Scheduler
+	report := make([]Value, 0, len(s.Actors))
+	for _, name := range s.Names() {
+		a := s.Actors[name]
+		budget := Nil()
+		if a.Budgeted {
+			budget = Int(a.Budget)
+		}
+		report = append(report, Lst(Sym(name), Int(a.Steps), Int(a.LongestWait), budget))
+		ev.DatalogDB.AssertAtTime("fairness", ev.now(),
+			Atom(name), NumTerm(float64(a.Steps)), NumTerm(float64(a.LongestWait)))
+	}
+	return Lst(report...)
+}
+
+// (set-actor-budget! actor steps) - let actor take steps more steps, or
+// any number with nil
+func builtinSetActorBudget(ev *Evaluator, args []Value, env *Env) Value {
+	name := valueToString(args[0])
+	a := ev.Scheduler.GetActor(name)
+	if a == nil {
+		ev.warnTrace("", "set-actor-budget!: unknown actor %s", name)
+		return Nil()
+	}
+	switch {
+	case args[1].Type == TypeNil:
+		a.Budgeted = false
+	case args[1].Type == TypeNumber && args[1].Number >= 0:
+		a.Budgeted, a.Budget = true, int64(args[1].Number)
+	default:
+		ev.warnTrace("", "set-actor-budget!: need a step count or nil, got %s", args[1].String())
+		return Nil()
+	}
+	if a.State == ActorBlocked && a.BlockedOn == outOfBudget && (!a.Budgeted || a.Budget > 0) {
+		ev.Scheduler.UnblockActor(name)
+	} else if a.Budgeted && a.Budget <= 0 && a.State != ActorDone && name != ev.Scheduler.CurrentActor {
+		ev.exhaustBudget(a)
+	}
+	return Sym("ok")
+}
+
+// toolFairnessReport renders each actor's steps, share of the steps
+// taken, longest wait and remaining budget as a table
+func toolFairnessReport(ev *Evaluator, args map[string]string) string {
+	s := ev.Scheduler
+	var total int64
+	for _, a := range s.Actors {
+		total += a.Steps
+	}
+	var sb strings.Builder
+	sb.WriteString("| Actor | Steps | Share | Longest wait | Budget left |\n")
+	sb.WriteString("|-------|-------|-------|--------------|-------------|\n")
+	for _, name := range s.Names() {
+		a := s.Actors[name]
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(a.Steps) / float64(total)
+		}
+		budget := "-"
+		if a.Budgeted {
+			budget = fmt.Sprintf("%d", a.Budget)
+		}
+		sb.WriteString(fmt.Sprintf("| %s | %d | %.1f%% | %d | %s |\n", name, a.Steps, share, a.LongestWait, budget))
+	}
+	if len(s.Actors) == 0 {
+		sb.WriteString("\n*No actors*\n")
+	}
+	return sb.String()
+}
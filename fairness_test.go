@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Step Budget and Fairness Tests
+// ============================================================================
+
+func TestActorBudgets(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (spin) 'yield)
+		(define (work n) (if (> n 0) (list 'become (list 'work (- n 1))) 'done))
+		(spawn-actor 'spin 2 '(spin))
+		(spawn-actor 'worker 2 '(work 5))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		// Without a budget spin would run until max-steps
+		{"(set-actor-budget! 'spin 3)", "ok"},
+		{"(run-scheduler 20)", `(deadlock 9 ((spin "out of budget")))`},
+		{"(query 'budget-exhausted '?a '?step)", "(((a spin) (step 5)))"},
+		{"(fairness-report)", "((spin 3 1 0) (worker 6 1 nil))"},
+		{"(query 'fairness '?a '?steps '?wait)", "(((a spin) (steps 3) (wait 1)) ((a worker) (steps 6) (wait 1)))"},
+		// More budget wakes it
+		{"(set-actor-budget! 'spin 2)", "ok"},
+		{"(run-scheduler 20)", `(deadlock 2 ((spin "out of budget")))`},
+		{"(set-actor-budget! 'spin nil)", "ok"},
+		{"(run-scheduler 4)", "(max-steps 4)"},
+		// A budget of 0 stops it at once
+		{"(set-actor-budget! 'spin 0)", "ok"},
+		{"(car (scheduler-status))", `(spin blocked "out of budget" 0 2 9)`},
+		{"(set-actor-budget! 'spin -1)", "nil"},
+		{"(set-actor-budget! 'nobody 1)", "nil"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	for _, w := range []string{
+		"spin: out of budget after 3 steps",
+		"set-actor-budget!: need a step count or nil, got -1",
+		"set-actor-budget!: unknown actor nobody",
+	} {
+		if lastWarning(ev, w) == nil {
+			t.Errorf("expected a warning %q, got %v", w, ev.Warnings)
+		}
+	}
+}
+
+func TestFairnessReport(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	tr := NewToolRegistry(ev)
+	if out := tr.Process("{{fairness_report}}"); !strings.Contains(out, "*No actors*") {
+		t.Errorf("expected a note that there are no actors, got:\n%s", out)
+	}
+
+	runCode(ev, `
+		(define (spin) 'yield)
+		(define (once) 'done)
+		(spawn-actor 'spin 2 '(spin))
+		(spawn-actor 'once 2 '(once))
+		(set-actor-budget! 'spin 10)
+		(run-scheduler 4)`)
+	out := tr.Process("{{fairness_report}}")
+	for _, want := range []string{
+		"| Actor | Steps | Share | Longest wait | Budget left |",
+		"| once | 1 | 25.0% | 1 | - |",
+		"| spin | 3 | 75.0% | 1 | 7 |",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report has no line %s:\n%s", want, out)
+		}
+	}
+}
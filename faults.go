@@ -0,0 +1,145 @@
+package main
+
+import "math/rand"
+
+// ============================================================================
+// Fault Injection
+// ============================================================================
+//
+// Every send-to! arrives, exactly once and in order, so a protocol meant
+// to survive an unreliable network never meets one. (inject-faults!)
+// makes sends unreliable:
+//
+//   (inject-faults! :drop-rate 0.1 :dup-rate 0.05 :max-delay 3 :seed 7)
+//
+// From then on each send-to! may be
+//
+//   - dropped, with probability drop-rate: the sender is told ok, and
+//     nothing arrives; recorded as (fault-dropped from to msg)
+//   - delayed by 1 to max-delay steps, or units of virtual time, the way
+//     send-after! delays it; recorded as (fault-delayed from to msg n)
+//   - duplicated, with probability dup-rate: a second copy arrives right
+//     after the first; recorded as (fault-duplicated from to msg)
+//
+// Each fault fact is stamped with the time of the send. The dice are
+// rolled from their own source, seeded by :seed (the run's seed if none
+// is given), so the same program with the same seed loses, repeats and
+// delays the same messages. Only send-to! is affected: ask!, reply!,
+// timers and scripted stimuli are delivered as they would be.
+// (inject-faults! false) makes sends reliable again.
+
+// Faults is how unreliable sends are, and the dice deciding which
+type Faults struct {
+	DropRate float64
+	DupRate  float64
+	MaxDelay int64
+	Seed     int64
+	Rolls    int64 // Dice rolled so far
+	src      *randSource
+	rng      *rand.Rand
+}
+
+func newFaults(seed int64) *Faults {
+	src := newRandSource(seed)
+	return &Faults{Seed: seed, src: src, rng: rand.New(src)}
+}
+
+// roll is the next number from the dice, in [0, 1)
+func (f *Faults) roll() float64 {
+	f.Rolls++
+	return f.rng.Float64()
+}
+
+// copy is f with dice of its own, at the same point in their sequence
+func (f *Faults) copy() *Faults {
+	if f == nil {
+		return nil
+	}
+	cp := *f
+	cp.src = f.src.clone()
+	cp.rng = rand.New(cp.src)
+	return &cp
+}
+
+// (inject-faults! [:drop-rate p] [:dup-rate p] [:max-delay n] [:seed n]),
+// or (inject-faults! false) - make send-to! unreliable, or reliable again
+func builtinInjectFaults(ev *Evaluator, args []Value, env *Env) Value {
+	args, opts := keywordArgs(args)
+	if len(args) > 0 && !args[0].IsTruthy() {
+		ev.Scheduler.Faults = nil
+		return Sym("ok")
+	}
+	seed := ev.Seed
+	if v, ok := opts["seed"]; ok && v.Type == TypeNumber {
+		seed = int64(v.Number)
+	}
+	f := newFaults(seed)
+	for _, name := range []string{"drop-rate", "dup-rate"} {
+		v, ok := opts[name]
+		if !ok {
+			continue
+		}
+		if v.Type != TypeNumber || v.Number < 0 || v.Number > 1 {
+			ev.warnTrace("", "inject-faults!: :%s must be between 0 and 1, got %s", name, v.String())
+			return Nil()
+		}
+		if name == "drop-rate" {
+			f.DropRate = v.Number
+		} else {
+			f.DupRate = v.Number
+		}
+	}
+	if v, ok := opts["max-delay"]; ok {
+		if v.Type != TypeNumber || v.Number < 0 {
+			ev.warnTrace("", "inject-faults!: :max-delay must be a number of steps, got %s", v.String())
+			return Nil()
+		}
+		f.MaxDelay = int64(v.Number)
+	}
+	ev.Scheduler.Faults = f
+	return Sym("ok")
+}
+
+// sendFaulty is send, with the faults in force rolled for
+func (ev *Evaluator) sendFaulty(target *Actor, message Value, priority int) Value {
+	f := ev.Scheduler.Faults
+	sender := ev.Scheduler.CurrentActor
+	if sender == "" {
+		sender = "external"
+	}
+	fact := func(pred string, extra ...Term) {
+		terms := append([]Term{Atom(sender), Atom(target.Name), ValueToTerm(message)}, extra...)
+		ev.DatalogDB.AssertAtTime(pred, ev.now(), terms...)
+	}
+
+	if f.DropRate > 0 && f.roll() < f.DropRate {
+		fact("fault-dropped")
+		return Sym("ok")
+	}
+	var delay int64
+	if f.MaxDelay > 0 {
+		delay = int64(f.roll() * float64(f.MaxDelay+1))
+	}
+	copies := 1
+	if f.DupRate > 0 && f.roll() < f.DupRate {
+		copies = 2
+	}
+
+	if delay > 0 {
+		fact("fault-delayed", NumTerm(float64(delay)))
+		for i := 0; i < copies; i++ {
+			ev.Scheduler.addTimer(Timer{Due: ev.now() + delay, From: sender, Target: target.Name, Message: message, Stamp: ev.stamp()})
+		}
+	} else if sent := ev.send(target, message, priority); copies == 1 || !sent.IsSymbol() || sent.Symbol != "ok" {
+		return sent
+	} else if target.Mailbox.SendStamped(message, priority, ev.stamp()) {
+		ev.delivered(target, message)
+	} else {
+		// No room for the copy: it is lost, which a duplicate may as well be
+		return Sym("ok")
+	}
+	if copies == 2 {
+		fact("fault-duplicated")
+	}
+	return Sym("ok")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Fault Injection Tests
+// ============================================================================
+
+const faultyPipeline = `
+	(define (producer n) (if (> n 0) (begin (send-to! 'sink n) (list 'become (list 'producer (- n 1)))) 'done))
+	(define (sink) (receive!) (list 'become '(sink)))
+	(spawn-actor 'producer 4 '(producer 20))
+	(spawn-actor 'sink 64 '(sink))`
+
+func faultyRun(t *testing.T, faults string) *Evaluator {
+	t.Helper()
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, faults+faultyPipeline+"(run-scheduler 200)")
+	return ev
+}
+
+func TestInjectFaults(t *testing.T) {
+	ev := faultyRun(t, "(inject-faults! :drop-rate 0.3 :dup-rate 0.3 :max-delay 2 :seed 7)")
+	count := func(pred string) int {
+		return int(evalLast(ev, "(length (query '"+pred+" '?from '?to '?msg))").Number)
+	}
+	dropped, duplicated := count("fault-dropped"), count("fault-duplicated")
+	delayed := int(evalLast(ev, "(length (query 'fault-delayed '?from '?to '?msg '?n))").Number)
+	if dropped == 0 || duplicated == 0 || delayed == 0 {
+		t.Fatalf("expected some of each fault, got %d dropped, %d duplicated, %d delayed", dropped, duplicated, delayed)
+	}
+	received := int(evalLast(ev, "(length (query 'received 'sink '?msg))").Number)
+	if received != 20-dropped+duplicated {
+		t.Errorf("sink received %d messages, want 20 - %d dropped + %d duplicated", received, dropped, duplicated)
+	}
+	short := int(evalLast(ev, "(length (query 'fault-delayed '?from '?to '?msg 1))").Number)
+	long := int(evalLast(ev, "(length (query 'fault-delayed '?from '?to '?msg 2))").Number)
+	if short+long != delayed {
+		t.Errorf("%d delays of 1 and %d of 2, want %d in all", short, long, delayed)
+	}
+
+	// The same seed loses and repeats the same messages
+	again := faultyRun(t, "(inject-faults! :drop-rate 0.3 :dup-rate 0.3 :max-delay 2 :seed 7)")
+	for _, q := range []string{"(query 'fault-dropped '?f '?t '?m)", "(query 'fault-duplicated '?f '?t '?m)", "(query 'fault-delayed '?f '?t '?m '?n)"} {
+		if a, b := evalLast(ev, q).String(), evalLast(again, q).String(); a != b {
+			t.Errorf("%s differs between runs with the same seed:\n%s\n%s", q, a, b)
+		}
+	}
+}
+
+func TestInjectFaultsOff(t *testing.T) {
+	ev := faultyRun(t, "(inject-faults! :drop-rate 1 :seed 1) (inject-faults! false)")
+	if got := evalLast(ev, "(length (query 'received 'sink '?msg))").String(); got != "20" {
+		t.Errorf("sink received %s messages with faults off, want 20", got)
+	}
+	if got := evalLast(ev, "(inject-faults! :drop-rate 2)").String(); got != "nil" {
+		t.Errorf("a drop rate over 1 gave %s, want nil", got)
+	}
+}
+
+func TestFaultsCopy(t *testing.T) {
+	f := newFaults(3)
+	f.roll()
+	f.roll()
+	cp := f.copy()
+	if a, b := f.roll(), cp.roll(); a != b || cp.Rolls != 3 {
+		t.Errorf("copy rolled %v after %d rolls, original %v", b, cp.Rolls, a)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// ============================================================================
+// Evaluation Fuel
+// ============================================================================
+//
+// The scheduler's step limit doesn't stop a loop that never reaches the
+// scheduler: (define (spin) (spin)) at top level, or an actor step that
+// recurses forever. Fuel bounds how many expressions one top-level Eval
+// may evaluate, nested evaluations and actor steps included. When it runs
+// out, every evaluation still in progress returns <blocked: out of fuel>,
+// which unwinds the rest quickly, and the run is reported with:
+//
+//   - a warning with the stack trace where the fuel ran out
+//   - an (out-of-fuel actor fuel) fact (actor is external at top level)
+//   - inside an actor, the actor blocking with reason out of fuel
+//
+// Fuel is off (0) by default. The server gives each evaluation
+// defaultServerFuel, or $KRIPKE_FUEL, and an /eval request can ask for
+// less with "fuel"; philosopher run takes --fuel.
+
+// defaultServerFuel is the server's fuel per evaluation: far more than any
+// reasonable spec needs, far less than forever
+const defaultServerFuel = 50_000_000
+
+// serverFuel is the fuel the server gives each evaluation
+func serverFuel() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("KRIPKE_FUEL"), 10, 64); err == nil && n >= 0 {
+		return n
+	}
+	return defaultServerFuel
+}
+
+// requestFuel is the fuel for an /eval request that asked for asked: no
+// more than the server gives, and the server's if it asked for 0 or less
+func requestFuel(asked int64) int64 {
+	limit := serverFuel()
+	if asked > 0 && (limit == 0 || asked < limit) {
+		return asked
+	}
+	return limit
+}
+
+// fuelSpent reports whether the current top-level Eval is out of fuel
+func (ev *Evaluator) fuelSpent() bool {
+	return ev.fuelEnd != 0 && ev.EvalCount > ev.fuelEnd
+}
+
+// outOfFuel reports running out of fuel the first time it happens in a
+// top-level Eval and returns the blocked value every evaluation returns
+// after that. Compiled leaves count evaluations without checking fuel, so
+// the count may already be past the end; fuelEnd becomes -1 once reported.
+func (ev *Evaluator) outOfFuel() Value {
+	if ev.fuelEnd > 0 {
+		ev.fuelEnd = -1
+		actor := ev.Scheduler.CurrentActor
+		if actor == "" {
+			actor = "external"
+		}
+		ev.warnTrace("out-of-fuel:"+actor, "Out of fuel: evaluation stopped after %d evaluations", ev.Fuel)
+		ev.DatalogDB.AssertAtTime("out-of-fuel", ev.now(), Atom(actor), NumTerm(float64(ev.Fuel)))
+		if ev.Scheduler.GetActor(actor) != nil {
+			ev.Scheduler.BlockActor(actor, "out of fuel")
+		}
+	}
+	return Blocked(BlockOutOfFuel)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+// ============================================================================
+// Evaluation Fuel Tests
+// ============================================================================
+
+func TestFuelStopsRunawayLoops(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+	}{
+		{"tail loop", "(define (spin) (spin)) (spin)"},
+		{"loop in an argument", "(define (spin n) (spin (+ n 1))) (list 1 (spin 0))"},
+		{"loop in a builtin's callback", "(define (spin x) (spin x)) (map spin '(1 2 3))"},
+	}
+	for _, tt := range tests {
+		ev := NewEvaluator(1000)
+		ev.Quiet = true
+		ev.Fuel = 10000
+		result := evalLast(ev, tt.code)
+		if result.Type != TypeBlocked || result.Blocked.Reason != BlockOutOfFuel {
+			t.Errorf("%s: got %s, want <blocked: out of fuel>", tt.name, result.String())
+		}
+		if w := lastWarning(ev, "Out of fuel"); w == nil || w.Count != 1 || len(w.Stack) == 0 {
+			t.Errorf("%s: want one out-of-fuel warning with a trace, got %+v", tt.name, w)
+		}
+		// The next evaluation gets fresh fuel
+		if got := evalLast(ev, "(+ 1 2)").String(); got != "3" {
+			t.Errorf("%s: after running out, (+ 1 2) = %s", tt.name, got)
+		}
+	}
+}
+
+func TestFuelInActors(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	ev.Fuel = 10000
+	result := evalLast(ev, `
+		(define (spin) (spin))
+		(define (ticker n) (list 'become (list 'ticker (+ n 1))))
+		(spawn-actor 'ticker 4 '(ticker 0))
+		(spawn-actor 'spinner 4 '(spin))
+		(run-scheduler 1000)`)
+	if result.Type != TypeBlocked || result.Blocked.Reason != BlockOutOfFuel {
+		t.Fatalf("run-scheduler returned %s, want <blocked: out of fuel>", result.String())
+	}
+	if spinner := ev.Scheduler.GetActor("spinner"); spinner.State != ActorBlocked || spinner.BlockedOn != "out of fuel" {
+		t.Errorf("spinner is %v on %q, want blocked on out of fuel", spinner.State, spinner.BlockedOn)
+	}
+	if got := evalLast(ev, "(query 'out-of-fuel '?a '?n)").String(); got != "(((a spinner) (n 10000)))" {
+		t.Errorf("out-of-fuel facts = %s", got)
+	}
+
+	// Unlimited without fuel
+	ev = NewEvaluator(1000)
+	if got := evalLast(ev, "(define (count n) (if (= n 0) 'done (count (- n 1)))) (count 20000)").String(); got != "done" {
+		t.Errorf("without fuel: got %s", got)
+	}
+}
+
+func TestEvalRequestFuel(t *testing.T) {
+	saved := globalEv
+	defer func() { globalEv = saved }()
+	globalEv = NewEvaluator(1000)
+	globalEv.Quiet = true
+
+	body, _ := json.Marshal(map[string]interface{}{"code": "(define (spin) (spin)) (spin) (+ 1 2)", "fuel": 5000})
+	rec := httptest.NewRecorder()
+	handleEval(rec, httptest.NewRequest("POST", "/eval", bytes.NewReader(body)))
+
+	var resp struct {
+		Results []string `json:"results"`
+		Errors  []string `json:"errors"`
+		Success bool     `json:"success"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 3 || resp.Results[1] != "<blocked: out of fuel>" || resp.Results[2] != "3" {
+		t.Errorf("results = %v", resp.Results)
+	}
+	if resp.Success || len(resp.Errors) != 1 {
+		t.Errorf("success = %v, errors = %v; want the out-of-fuel result reported", resp.Success, resp.Errors)
+	}
+	if globalEv.Fuel != 0 {
+		t.Errorf("the request's fuel outlived it: %d", globalEv.Fuel)
+	}
+}
+
+func TestRequestFuelIsCapped(t *testing.T) {
+	t.Setenv("KRIPKE_FUEL", "1000")
+	tests := []struct {
+		asked, expected int64
+	}{
+		{500, 500},
+		{1000, 1000},
+		{5000, 1000}, // no more than the server gives
+		{0, 1000},    // not unlimited
+		{-1, 1000},
+	}
+	for _, tt := range tests {
+		if got := requestFuel(tt.asked); got != tt.expected {
+			t.Errorf("requestFuel(%d) = %d, want %d", tt.asked, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============================================================================
+// Recorded Runs and Generated Tests
+// ============================================================================
+//
+// philosopher run --record trace.jsonl spec.lisp writes a trace file in
+// the format run-scheduler's :record uses (see tracefile.go), covering the
+// whole program: a program-started line with the seed and features it ran
+// with, every actor scheduled, message sent, fact asserted and actor
+// blocked, and a program-finished line with how many facts of each
+// predicate it asserted and whether each rule's head was derivable at
+// the end.
+//
+// philosopher gen-test trace.jsonl turns that into a deftest, which runs
+// the spec again under the recorded schedule and checks the run still
+// does the same thing, so a scenario found by hand becomes a regression
+// test.
+
+// RecordedRun is what a deftest checks of a run: the schedule, the
+// messages sent in from outside and the outcomes
+type RecordedRun struct {
+	Spec       string
+	Features   []string
+	Seed       int64
+	Schedule   []string
+	Stimuli    []Stimulus
+	Facts      map[string]int
+	Properties map[string]bool
+}
+
+// Stimulus is a message sent to an actor from outside the system
+type Stimulus struct {
+	Actor   string
+	Message string // write-value form
+}
+
+// recordRun starts recording ev's schedule and stimuli into a RecordedRun;
+// call finish once the spec has run to fill in the outcomes.
+func recordRun(ev *Evaluator, spec string) (rec *RecordedRun, finish func()) {
+	rec = &RecordedRun{Spec: spec, Seed: ev.Seed, Features: ev.featureNames(), Schedule: []string{}}
+	id := ev.Events.Subscribe(func(ev *Evaluator, e SchedEvent) {
+		switch e.Kind {
+		case EventActorScheduled:
+			rec.Schedule = append(rec.Schedule, e.Actor)
+		case EventMessageSent:
+			if e.Actor == "external" {
+				msg, err := WriteValue(e.Message)
+				if err != nil {
+					msg = e.Message.String()
+				}
+				rec.Stimuli = append(rec.Stimuli, Stimulus{Actor: e.Target, Message: msg})
+			}
+		}
+	})
+	return rec, func() {
+		ev.Events.Unsubscribe(id)
+		rec.Facts, rec.Properties = runOutcomes(ev.DatalogDB)
+	}
+}
+
+// runOutcomes counts the asserted facts by predicate and checks whether
+// each rule head has a solution.
+func runOutcomes(db *DatalogDB) (map[string]int, map[string]bool) {
+	facts := make(map[string]int)
+	for _, f := range db.Facts {
+		facts[f.Predicate]++
+	}
+	props := make(map[string]bool)
+	for _, r := range db.Rules {
+		pred := r.Head.Predicate
+		if _, seen := props[pred]; seen {
+			continue
+		}
+		args := make([]Term, len(r.Head.Args))
+		for i := range args {
+			args[i] = Var(fmt.Sprintf("?a%d", i))
+		}
+		props[pred] = len(db.Query(pred, args...)) > 0
+	}
+	return facts, props
+}
+
+// recordProgram starts writing a trace of the whole of spec's run to
+// path; finish it with programFinished once the spec has run
+func (ev *Evaluator) recordProgram(spec, path string) (*TraceRecorder, error) {
+	return ev.newTraceRecorder(path, TraceEvent{
+		Event:    "program-started",
+		Spec:     spec,
+		Seed:     ev.Seed,
+		Features: strings.Join(ev.featureNames(), ","),
+	})
+}
+
+// programFinished is the last line of a program's trace, with its outcomes
+func programFinished(ev *Evaluator) TraceEvent {
+	facts, props := runOutcomes(ev.DatalogDB)
+	var counts, holds []Value
+	for _, pred := range sortedKeys(facts) {
+		counts = append(counts, Lst(Sym(pred), Int(int64(facts[pred]))))
+	}
+	for _, head := range sortedKeys(props) {
+		holds = append(holds, Lst(Sym(head), Bool(props[head])))
+	}
+	return TraceEvent{
+		Step:       ev.Scheduler.StepCount,
+		Event:      "program-finished",
+		FactCounts: writtenForm(Lst(counts...)),
+		Properties: writtenForm(Lst(holds...)),
+	}
+}
+
+// readRecordedRun reads a trace written by philosopher run --record
+func readRecordedRun(path string) (*RecordedRun, error) {
+	events, err := readTrace(path)
+	if err != nil {
+		return nil, err
+	}
+	start, end := events[0], events[len(events)-1]
+	if start.Event != "program-started" {
+		return nil, fmt.Errorf("%s: records one run-scheduler run, not a program; record it with philosopher run --record", path)
+	}
+	if end.Event != "program-finished" {
+		return nil, fmt.Errorf("%s: the program's run wasn't finished", path)
+	}
+	rec := &RecordedRun{Spec: start.Spec, Seed: start.Seed, Schedule: []string{},
+		Facts: make(map[string]int), Properties: make(map[string]bool)}
+	if start.Features != "" {
+		rec.Features = strings.Split(start.Features, ",")
+	}
+	for _, te := range events {
+		switch {
+		case te.Event == "actor-scheduled":
+			rec.Schedule = append(rec.Schedule, te.Actor)
+		case te.Event == "message-sent" && te.Actor == "external":
+			rec.Stimuli = append(rec.Stimuli, Stimulus{Actor: te.Target, Message: te.Message})
+		}
+	}
+	counts, err := ReadValue(end.FactCounts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad fact counts: %v", path, err)
+	}
+	for _, c := range counts.List {
+		if c.Type == TypeList && len(c.List) == 2 {
+			rec.Facts[valueToString(c.List[0])] = int(c.List[1].Number)
+		}
+	}
+	holds, err := ReadValue(end.Properties)
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad properties: %v", path, err)
+	}
+	for _, h := range holds.List {
+		if h.Type == TypeList && len(h.List) == 2 {
+			rec.Properties[valueToString(h.List[0])] = h.List[1].IsTruthy()
+		}
+	}
+	if rec.Spec == "" {
+		return nil, fmt.Errorf("%s: no spec recorded", path)
+	}
+	return rec, nil
+}
+
+// generateTest writes a recorded run as a deftest
+func generateTest(name, source string, rec *RecordedRun) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, ";; Regression test generated by philosopher gen-test from %s\n", source)
+	sb.WriteString(";; Replays the recorded schedule and checks the run still does the same thing.\n\n")
+	fmt.Fprintf(&sb, "(deftest %q\n", name)
+	fmt.Fprintf(&sb, "  :spec %q\n", rec.Spec)
+	if len(rec.Features) > 0 {
+		fmt.Fprintf(&sb, "  :features '(%s)\n", strings.Join(rec.Features, " "))
+	}
+	fmt.Fprintf(&sb, "  :seed %d\n", rec.Seed)
+
+	sb.WriteString("  :schedule '(")
+	for i, actor := range rec.Schedule {
+		if i > 0 && i%12 == 0 {
+			sb.WriteString("\n              ")
+		} else if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(Sym(actor).String())
+	}
+	sb.WriteString(")\n")
+
+	if len(rec.Stimuli) > 0 {
+		// Messages that don't read back as plain literals are rebuilt with read-value
+		sb.WriteString("  :stimuli `(")
+		for i, s := range rec.Stimuli {
+			if i > 0 {
+				sb.WriteString("\n              ")
+			}
+			msg := s.Message
+			if strings.Contains(msg, "#(") {
+				msg = fmt.Sprintf(",(read-value %q)", msg)
+			}
+			fmt.Fprintf(&sb, "(%s %s)", s.Actor, msg)
+		}
+		sb.WriteString(")\n")
+	}
+
+	sb.WriteString("  :expect-facts '(")
+	for i, pred := range sortedKeys(rec.Facts) {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "(%s %d)", pred, rec.Facts[pred])
+	}
+	sb.WriteString(")")
+
+	if len(rec.Properties) > 0 {
+		sb.WriteString("\n  :expect-properties '(")
+		for i, name := range sortedKeys(rec.Properties) {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			fmt.Fprintf(&sb, "(%s %t)", name, rec.Properties[name])
+		}
+		sb.WriteString(")")
+	}
+	sb.WriteString(")\n")
+	return sb.String()
+}
+
+// runGenTest is philosopher gen-test trace.jsonl [--out file] [--name name]
+func runGenTest(args []string) {
+	fs := flag.NewFlagSet("gen-test", flag.ExitOnError)
+	out := fs.String("out", "", "test file to write (default: <spec>_test.lisp)")
+	name := fs.String("name", "", "test name (default: the trace file name)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: philosopher gen-test <trace.jsonl> [--out spec_test.lisp] [--name name]")
+		fs.PrintDefaults()
+	}
+	// The trace file may come before or after the flags
+	var trace string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		trace, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+	if trace == "" && fs.NArg() > 0 {
+		trace = fs.Arg(0)
+	}
+	if trace == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	rec, err := readRecordedRun(trace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "philosopher gen-test: %v\n", err)
+		os.Exit(1)
+	}
+	if *name == "" {
+		*name = strings.TrimSuffix(filepath.Base(trace), filepath.Ext(trace))
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(rec.Spec, filepath.Ext(rec.Spec)) + "_test.lisp"
+	}
+	if err := os.WriteFile(*out, []byte(generateTest(*name, trace, rec)), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "philosopher gen-test: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s\n", *out)
+}
+
+// (deftest name :spec "file.lisp" :features '(f...) :seed n :schedule '(actor...)
+// :stimuli '((actor msg)...) :expect-facts '((pred count)...)
+// :expect-properties '((pred holds)...)) - runs the spec on a fresh world
+// under the schedule and compares what happens with the expectations.
+// Prints PASS or FAIL with each difference; returns true if it passed.
+func builtinDeftest(ev *Evaluator, args []Value, env *Env) Value {
+	positional, opts := keywordArgs(args)
+	if len(positional) == 0 {
+		ev.warn("", "deftest: expected a name")
+		return Bool(false)
+	}
+	name := valueToString(positional[0])
+	spec, ok := ev.filePath("deftest", []Value{opts["spec"]})
+	if !ok {
+		return Bool(false)
+	}
+	failures := ev.replaySpec(spec, opts)
+	if !ev.Quiet {
+		if len(failures) == 0 {
+			fmt.Printf("PASS %s\n", name)
+		} else {
+			fmt.Printf("FAIL %s\n", name)
+			for _, f := range failures {
+				fmt.Printf("  %s\n", f)
+			}
+		}
+	}
+	return Bool(len(failures) == 0)
+}
+
+// replaySpec runs spec in a new evaluator set up from a deftest's options
+// and lists how the run differs from the expected one.
+func (ev *Evaluator) replaySpec(spec string, opts map[string]Value) []string {
+	w := NewEvaluator(ev.CallStack.Capacity)
+	w.Quiet = true
+	w.FileAccess = ev.FileAccess
+	w.Sandbox = ev.Sandbox
+	if v, ok := opts["seed"]; ok && v.Type == TypeNumber {
+		w.SetSeed(int64(v.Number))
+	}
+	for _, f := range opts["features"].List {
+		w.Features[valueToString(f)] = true
+	}
+	var schedule []string
+	for _, a := range opts["schedule"].List {
+		schedule = append(schedule, valueToString(a))
+	}
+	w.Scheduler.Script = append([]string(nil), schedule...)
+
+	rec, finish := recordRun(w, spec)
+	_, err := w.loadFile(spec)
+	finish()
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var failures []string
+	if w.Scheduler.Diverged || len(rec.Schedule) != len(schedule) {
+		step := 0
+		for step < len(rec.Schedule) && step < len(schedule) && rec.Schedule[step] == schedule[step] {
+			step++
+		}
+		failures = append(failures, fmt.Sprintf("schedule diverged at step %d (%d steps recorded, %d replayed)",
+			step, len(schedule), len(rec.Schedule)))
+	}
+	if stimuli, ok := opts["stimuli"]; ok {
+		var want []string
+		for _, s := range stimuli.List {
+			if s.Type == TypeList && len(s.List) == 2 {
+				msg, _ := WriteValue(s.List[1])
+				want = append(want, valueToString(s.List[0])+" "+msg)
+			}
+		}
+		var got []string
+		for _, s := range rec.Stimuli {
+			got = append(got, s.Actor+" "+s.Message)
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			failures = append(failures, fmt.Sprintf("stimuli: expected %d messages %v, got %d %v", len(want), want, len(got), got))
+		}
+	}
+	for _, e := range opts["expect-facts"].List {
+		if e.Type != TypeList || len(e.List) != 2 {
+			continue
+		}
+		pred := valueToString(e.List[0])
+		if got := rec.Facts[pred]; int64(got) != int64(e.List[1].Number) {
+			failures = append(failures, fmt.Sprintf("%s: expected %s facts, got %d", pred, e.List[1].String(), got))
+		}
+	}
+	for _, e := range opts["expect-properties"].List {
+		if e.Type != TypeList || len(e.List) != 2 {
+			continue
+		}
+		pred := valueToString(e.List[0])
+		got, ok := rec.Properties[pred]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("%s: no such rule", pred))
+		} else if got != e.List[1].IsTruthy() {
+			failures = append(failures, fmt.Sprintf("%s: expected %s, got %t", pred, e.List[1].String(), got))
+		}
+	}
+	return failures
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Recorded Run and Generated Test Tests
+// ============================================================================
+
+const jobsSpec = `
+(rule 'handled '(handled ?m) '(received server ?m))
+(rule 'dropped '(dropped ?m) '(sent ?from server ?m) '(not (received server ?m)))
+(spawn-actor 'server 4 '(begin (receive!) 'done))
+(spawn-actor 'worker 4 '(begin (send-to! 'server (list 'job (rand 1000))) 'done))
+(send-to! 'server (let m (make-map) (begin (map-set! m 'priority 1) m)))
+(run-scheduler 50)
+`
+
+func recordJobs(t *testing.T) *RecordedRun {
+	spec := filepath.Join(t.TempDir(), "jobs.lisp")
+	if err := os.WriteFile(spec, []byte(jobsSpec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ev := NewEvaluator(64)
+	ev.Quiet = true
+	ev.SetSeed(3)
+	rec, finish := recordRun(ev, spec)
+	runCode(ev, jobsSpec)
+	finish()
+	return rec
+}
+
+func TestRecordRun(t *testing.T) {
+	rec := recordJobs(t)
+	if rec.Seed != 3 {
+		t.Errorf("seed = %d, want 3", rec.Seed)
+	}
+	if strings.Join(rec.Schedule, " ") != "server worker" {
+		t.Errorf("schedule = %v", rec.Schedule)
+	}
+	if len(rec.Stimuli) != 1 || rec.Stimuli[0].Actor != "server" || rec.Stimuli[0].Message != "#(map (priority 1))" {
+		t.Errorf("stimuli = %+v", rec.Stimuli)
+	}
+	if rec.Facts["sent"] != 2 || rec.Facts["spawned"] != 2 {
+		t.Errorf("facts = %v", rec.Facts)
+	}
+	if !rec.Properties["handled"] || !rec.Properties["dropped"] {
+		t.Errorf("properties = %v", rec.Properties)
+	}
+}
+
+func TestRecordedProgramTrace(t *testing.T) {
+	dir := t.TempDir()
+	spec, trace := filepath.Join(dir, "jobs.lisp"), filepath.Join(dir, "jobs.jsonl")
+	if err := os.WriteFile(spec, []byte(jobsSpec), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ev := NewEvaluator(64)
+	ev.Quiet = true
+	ev.SetSeed(3)
+	ev.Features["retries"] = true
+	recorder, err := ev.recordProgram(spec, trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runCode(ev, jobsSpec)
+	if err := recorder.finish(ev, programFinished(ev)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The trace is the same JSONL run-scheduler :record writes
+	events, err := readTrace(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events[0].Event != "program-started" || events[len(events)-1].Event != "program-finished" {
+		t.Errorf("trace runs from %s to %s", events[0].Event, events[len(events)-1].Event)
+	}
+
+	rec, err := readRecordedRun(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := recordJobs(t)
+	want.Spec, want.Features = spec, []string{"retries"}
+	if !reflect.DeepEqual(rec, want) {
+		t.Errorf("read back %+v, want %+v", rec, want)
+	}
+
+	// A trace of one run-scheduler run isn't a program's
+	ev.FileAccess = true
+	runCode(ev, `(run-scheduler :max-steps 5 :record "`+filepath.Join(dir, "run.jsonl")+`")`)
+	if _, err := readRecordedRun(filepath.Join(dir, "run.jsonl")); err == nil || !strings.Contains(err.Error(), "not a program") {
+		t.Errorf("reading a run-scheduler trace: %v", err)
+	}
+}
+
+func TestGeneratedTestReplays(t *testing.T) {
+	rec := recordJobs(t)
+	test := generateTest("jobs", "trace.jsonl", rec)
+	if !strings.Contains(test, `(deftest "jobs"`) || !strings.Contains(test, `,(read-value "#(map (priority 1))")`) {
+		t.Fatalf("unexpected test:\n%s", test)
+	}
+
+	run := func(test string) string {
+		ev := NewEvaluator(64)
+		ev.Quiet = true
+		ev.FileAccess = true
+		return evalLast(ev, test).String()
+	}
+	if got := run(test); got != "true" {
+		t.Errorf("generated test should pass against its own run:\n%s", test)
+	}
+
+	// A different outcome or schedule fails
+	rec.Facts["sent"]++
+	if got := run(generateTest("jobs", "trace.jsonl", rec)); got != "false" {
+		t.Errorf("expected a fact count mismatch to fail")
+	}
+	rec.Facts["sent"]--
+	rec.Schedule = []string{"worker", "worker"}
+	if got := run(generateTest("jobs", "trace.jsonl", rec)); got != "false" {
+		t.Errorf("expected a diverging schedule to fail")
+	}
+
+	// Without file access the spec can't be read
+	ev := NewEvaluator(64)
+	ev.Quiet = true
+	if got := evalLast(ev, test).String(); got != "false" {
+		t.Errorf("deftest without file access = %s, want false", got)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// JSON
+// ============================================================================
+//
+// JSON objects are maps with string keys, arrays are lists, null is nil.
+// Integers stay exact both ways. Going to JSON, symbols and chars become strings,
+// tagged values become {"tag": ..., "value": ...}, and sets, stacks and
+// queues become arrays of their contents.
+
+// valueToJSON converts v to a value encoding/json can marshal
+func valueToJSON(v Value) (interface{}, error) {
+	if hasCycle(v) {
+		return nil, fmt.Errorf("%s contains itself and has no JSON form", v.String())
+	}
+	return jsonValue(v)
+}
+
+func jsonValue(v Value) (interface{}, error) {
+	switch v.Type {
+	case TypeNil:
+		return nil, nil
+	case TypeBool:
+		return v.Bool, nil
+	case TypeNumber:
+		if v.IsInt {
+			return v.Int, nil
+		}
+		if math.IsInf(v.Number, 0) || math.IsNaN(v.Number) {
+			return nil, fmt.Errorf("%s has no JSON form", v.String())
+		}
+		return v.Number, nil
+	case TypeString:
+		return v.Str, nil
+	case TypeChar:
+		return string(rune(v.Int)), nil
+	case TypeSymbol:
+		return v.Symbol, nil
+	case TypeList:
+		return jsonArray(v.List)
+	case TypeSet:
+		return jsonArray(v.Set.Sorted())
+	case TypeStack:
+		return jsonArray(v.Stack.Data)
+	case TypeQueue:
+		return jsonArray(v.Queue.Data)
+	case TypeMap:
+		obj := make(map[string]interface{}, len(v.Map.Data))
+		for k, item := range v.Map.Data {
+			j, err := jsonValue(item)
+			if err != nil {
+				return nil, err
+			}
+			obj[jsonKey(v.Map.Keys[k])] = j
+		}
+		return obj, nil
+	case TypeTagged:
+		inner, err := jsonValue(v.Tagged.Value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"tag": v.Tagged.Tag, "value": inner}, nil
+	}
+	return nil, fmt.Errorf("%s has no JSON form", v.String())
+}
+
+func jsonArray(items []Value) ([]interface{}, error) {
+	arr := make([]interface{}, len(items))
+	for i, item := range items {
+		j, err := jsonValue(item)
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = j
+	}
+	return arr, nil
+}
+
+// jsonKey is the object key for a map key: strings and symbols as their text
+func jsonKey(k Value) string {
+	switch k.Type {
+	case TypeString:
+		return k.Str
+	case TypeSymbol:
+		return k.Symbol
+	}
+	return k.String()
+}
+
+// jsonToValue converts a value decoded with UseNumber into a Value
+func jsonToValue(j interface{}) Value {
+	switch x := j.(type) {
+	case nil:
+		return Nil()
+	case bool:
+		return Bool(x)
+	case json.Number:
+		if n, err := strconv.ParseInt(string(x), 10, 64); err == nil {
+			return Int(n)
+		}
+		f, _ := x.Float64()
+		return Num(f)
+	case string:
+		return Str(x)
+	case []interface{}:
+		items := make([]Value, len(x))
+		for i, item := range x {
+			items[i] = jsonToValue(item)
+		}
+		return Lst(items...)
+	case map[string]interface{}:
+		m := NewHashMap()
+		for k, item := range x {
+			key := Str(k)
+			m.Keys[key.String()] = key
+			m.Data[key.String()] = jsonToValue(item)
+		}
+		return Value{Type: TypeMap, Map: m}
+	}
+	return Nil()
+}
+
+// (json-parse str) - the value for a JSON document, nil if it doesn't parse
+func builtinJSONParse(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 || args[0].Type != TypeString {
+		ev.warn("", "json-parse: expected a string")
+		return Nil()
+	}
+	dec := json.NewDecoder(strings.NewReader(args[0].Str))
+	dec.UseNumber()
+	var j interface{}
+	if err := dec.Decode(&j); err != nil {
+		ev.warn("", "json-parse: %v", err)
+		return Nil()
+	}
+	if dec.More() {
+		ev.warn("", "json-parse: unexpected data after the JSON value")
+		return Nil()
+	}
+	return jsonToValue(j)
+}
+
+// (json-stringify value [:indent n]) - JSON text for value, nil if it has none
+func builtinJSONStringify(ev *Evaluator, args []Value, env *Env) Value {
+	args, opts := keywordArgs(args)
+	if len(args) == 0 {
+		return Nil()
+	}
+	j, err := valueToJSON(args[0])
+	if err != nil {
+		ev.warn("", "json-stringify: %v", err)
+		return Nil()
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if n, ok := opts["indent"]; ok && n.Type == TypeNumber {
+		enc.SetIndent("", strings.Repeat(" ", int(n.Number)))
+	}
+	if err := enc.Encode(j); err != nil {
+		ev.warn("", "json-stringify: %v", err)
+		return Nil()
+	}
+	return Str(strings.TrimSuffix(buf.String(), "\n"))
+}
+
+// jsonOrString is v's JSON form, or its printed form when it has none
+func jsonOrString(v Value) interface{} {
+	if j, err := valueToJSON(v); err == nil {
+		return j
+	}
+	return v.String()
+}
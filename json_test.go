@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+// ============================================================================
+// JSON Tests
+// ============================================================================
+
+func TestJSONStringify(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(json-stringify 42)`, `42`},
+		{`(json-stringify 2.5)`, `2.5`},
+		{`(json-stringify "a<b \"q\"")`, `"a<b \"q\""`},
+		{`(json-stringify nil)`, `null`},
+		{`(json-stringify true)`, `true`},
+		{`(json-stringify 'sym)`, `"sym"`},
+		{`(json-stringify '())`, `[]`},
+		{`(json-stringify (list 1 "two" (list 3)))`, `[1,"two",[3]]`},
+		{`(json-stringify (let m (make-map) (begin (map-set! m 'b 2) (map-set! m "a" (list 1)) m)))`, `{"a":[1],"b":2}`},
+		{`(json-stringify (tag 'point (list 1 2)))`, `{"tag":"point","value":[1,2]}`},
+		{`(json-stringify (make-set 'b 'a))`, `["a","b"]`},
+		{`(json-stringify (list 1 2) :indent 2)`, "[\n  1,\n  2\n]"},
+		{`(json-stringify (lambda (x) x))`, `nil`},
+		{`(json-stringify (/ 1.0 0))`, `nil`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			result := evalLast(ev, tt.code)
+			got := result.String()
+			if result.Type == TypeString {
+				got = result.Str
+			}
+			if got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestJSONParse(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(json-parse "42")`, `42`},
+		{`(integer? (json-parse "9007199254740993"))`, `true`},
+		{`(json-parse "9007199254740993")`, `9007199254740993`},
+		{`(float? (json-parse "1.5"))`, `true`},
+		{`(json-parse "null")`, `nil`},
+		{`(json-parse "[1, \"a\", [true, false]]")`, `(1 "a" (true false))`},
+		{`(map-get (json-parse "{\"name\": \"alice\", \"age\": 30}") "name")`, `"alice"`},
+		{`(map-get (json-parse "{\"order\": {\"items\": [1, 2]}}") "order")`, `{"items" (1 2)}`},
+		{`(json-parse "{\"a\": 1")`, `nil`},
+		{`(json-parse "1 2")`, `nil`},
+		{`(json-stringify (json-parse "{\"b\":[1,2.5,null],\"a\":{}}"))`, `"{\"a\":{},\"b\":[1,2.5,null]}"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEvalReturnsValues(t *testing.T) {
+	saved := globalEv
+	defer func() { globalEv = saved }()
+	globalEv = NewEvaluator(1000)
+
+	body, _ := json.Marshal(map[string]string{"code": `(list 1 "a" (json-parse "{\"k\": true}"))`})
+	rec := httptest.NewRecorder()
+	handleEval(rec, httptest.NewRequest("POST", "/eval", bytes.NewReader(body)))
+
+	var resp struct {
+		Values []interface{} `json:"values"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(resp.Values)
+	if want := `[[1,"a",{"k":true}]]`; string(got) != want {
+		t.Errorf("values = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+// ============================================================================
+// Lifecycle Facts
+// ============================================================================
+//
+// Every actor's lifecycle is recorded as facts, stamped with the time, so
+// specs can query it without asserting it themselves:
+//
+//   (spawned actor)           spawn-actor or spawn made it
+//   (became actor state)      a step returned (become ...) or (continue ...)
+//   (blocked actor reason)    it stopped running, on reason
+//   (unblocked actor)         it can run again
+//   (done actor)              it finished, stopped on an error or was killed
+//
+// The scheduler notes changes of state after every step, stamping them
+// with the step's time, and when it delivers timers and stimuli, so a
+// change made at the top level, such as a send that wakes an actor, is
+// recorded when the run next looks. They are asserted with assertLifecycle,
+// which marks them as the scheduler's, and their stamps aren't among the
+// times always? checks a property at, so recording them doesn't change
+// it. A spec's own (done x) facts are not marked and count as usual.
+
+// assertLifecycle records a lifecycle fact, marked as the scheduler's
+func (db *DatalogDB) assertLifecycle(pred string, time int64, args ...Term) {
+	db.assertFact(Fact{Predicate: pred, Args: args, Time: time, Lifecycle: true})
+}
+
+// noteLifecycle asserts a fact, stamped at, for each actor whose state
+// has changed since it last looked
+func (ev *Evaluator) noteLifecycle(at int64) {
+	for _, name := range ev.Scheduler.Names() {
+		a := ev.Scheduler.Actors[name]
+		if a.State == a.noted {
+			continue
+		}
+		switch a.State {
+		case ActorBlocked:
+			ev.DatalogDB.assertLifecycle("blocked", at, Atom(name), StrTerm(a.BlockedOn))
+		case ActorRunnable:
+			if a.noted == ActorBlocked {
+				ev.DatalogDB.assertLifecycle("unblocked", at, Atom(name))
+			}
+		case ActorDone:
+			ev.DatalogDB.assertLifecycle("done", at, Atom(name))
+		}
+		a.noted = a.State
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Lifecycle Fact Tests
+// ============================================================================
+
+func TestLifecycleFacts(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (waiter) (receive!) (list 'become '(relay)))
+		(define (relay) (send-to! 'sink 'fwd) 'done)
+		(define (sink) (receive!) (list 'become '(sink)))
+		(spawn-actor 'waiter 2 '(waiter))
+		(spawn-actor 'sink 2 '(sink))
+		(run-scheduler 20)`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(query 'at-time 'spawned '?a '?t)", "(((a waiter) (t 0)) ((a sink) (t 0)))"},
+		{"(query 'at-time 'blocked '?a '?why '?t)", `(((a waiter) (t 0) (why "recv (empty)")) ((a sink) (t 1) (why "recv (empty)")))`},
+		// A send from the top level is noted when the next run looks
+		{"(send-to! 'waiter 'hi)", "ok"},
+		{"(query 'unblocked '?a)", "()"},
+		{"(run-scheduler 20)", `(deadlock 4 ((sink "recv (empty)")))`},
+		{"(query 'at-time 'unblocked '?a '?t)", "(((a waiter) (t 0)) ((a sink) (t 1)))"},
+		{"(query 'at-time 'became '?a '?s '?t)", "(((a waiter) (s relay) (t 0)) ((a sink) (s sink) (t 2)))"},
+		{"(query 'at-time 'done '?a '?t)", "(((a waiter) (t 1)))"},
+		// Killing an actor finishes it too
+		{"(kill-actor! 'sink)", "()"},
+		{"(run-scheduler 20)", "(completed 0)"},
+		{"(query 'done '?a)", "(((a waiter)) ((a sink)))"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+}
+
+func TestAlwaysCountsUserLifecycleNames(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(assert-at! 1 'temperature 'ok)
+		(assert-at! 2 'done 'x)`)
+	// (done x) is the spec's own fact, so t=2 is checked, and it
+	// has no (temperature ok)
+	if got := evalLast(ev, `(always? '(temperature ok))`).String(); got != "false" {
+		t.Errorf("always? = %s, want false", got)
+	}
+
+	// The scheduler's own (done ...) doesn't add a time to check at
+	ev = NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(assert-at! 0 'temperature 'ok)
+		(define (w) 'done)
+		(spawn-actor 'w 2 '(w))
+		(run-scheduler 5)`)
+	if got := evalLast(ev, `(always? '(temperature ok))`).String(); got != "true" {
+		t.Errorf("always? with lifecycle facts = %s, want true", got)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+)
+
+// ============================================================================
+// Links and Monitors
+// ============================================================================
+//
+// An actor that finishes, or whose step ends in an error value, is gone
+// without a trace as far as the other actors are concerned. Links and
+// monitors make it visible: the watching actor gets an exit message
+//
+//   (exit name reason)
+//
+// in its mailbox, where reason is done for an actor that finished and the
+// error value for one that stopped on an error. (link! a b) watches both
+// ways; (monitor! a b) has a watch b. Either with one argument makes the
+// running actor the other party. Watching an actor that has already exited
+// sends the exit message straight away.
+//
+// (kill-actor! name reason) stops an actor from outside, wherever it is,
+// and its watchers get (exit name reason) the same way.
+
+// (link! a b) or (link! b) - each of a and b is told when the other exits
+func builtinLink(ev *Evaluator, args []Value, env *Env) Value {
+	a, b, ok := ev.watchArgs("link!", args)
+	if !ok {
+		return Nil()
+	}
+	ev.watch(a, b)
+	ev.watch(b, a)
+	return Sym("ok")
+}
+
+// (monitor! a b) or (monitor! b) - a is told when b exits
+func builtinMonitor(ev *Evaluator, args []Value, env *Env) Value {
+	a, b, ok := ev.watchArgs("monitor!", args)
+	if !ok {
+		return Nil()
+	}
+	ev.watch(a, b)
+	return Sym("ok")
+}
+
+// (kill-actor! actor [reason] [:mailbox drain|discard]) - stop actor now,
+// running its on-stop hook and telling its watchers, with reason killed if
+// none is given. Returns the messages left in its mailbox, () if they
+// were discarded, or nil if it had already stopped.
+func builtinKillActor(ev *Evaluator, args []Value, env *Env) Value {
+	args, opts := keywordArgs(args)
+	if len(args) == 0 || len(args) > 2 {
+		ev.warnTrace("", "kill-actor!: expected (kill-actor! actor [reason])")
+		return Nil()
+	}
+	name := valueToString(args[0])
+	actor := ev.Scheduler.GetActor(name)
+	if actor == nil {
+		ev.warnTrace("", "kill-actor!: unknown actor %s", name)
+		return Nil()
+	}
+	reason := Sym("killed")
+	if len(args) > 1 {
+		reason = args[1]
+	}
+	mailbox := "drain"
+	if v, ok := opts["mailbox"]; ok {
+		mailbox = valueToString(v)
+	}
+	if mailbox != "drain" && mailbox != "discard" {
+		ev.warnTrace("", "kill-actor!: :mailbox must be drain or discard, got %s", mailbox)
+		return Nil()
+	}
+	if actor.State == ActorDone {
+		return Nil()
+	}
+
+	var left []Value
+	for {
+		msg, ok := actor.Mailbox.RecvNow()
+		if !ok {
+			break
+		}
+		if mailbox == "drain" {
+			left = append(left, msg)
+		}
+	}
+	ev.Scheduler.MarkDone(name)
+	ev.DatalogDB.AssertAtTime("killed", ev.now(), Atom(name), ValueToTerm(reason))
+	ev.runHook(actor, "on-stop", actor.OnStop)
+	ev.actorExited(actor, reason)
+	// Actors waiting to send to it can carry on
+	ev.tryUnblockActors()
+	return Lst(left...)
+}
+
+// watchArgs finds the watching and watched actors for link! and monitor!
+func (ev *Evaluator) watchArgs(name string, args []Value) (*Actor, *Actor, bool) {
+	if len(args) == 0 || len(args) > 2 {
+		ev.warnTrace("", "%s: expected (%s actor) or (%s watcher actor)", name, name, name)
+		return nil, nil, false
+	}
+	names := []string{ev.Scheduler.CurrentActor}
+	for _, arg := range args {
+		names = append(names, valueToString(arg))
+	}
+	names = names[len(names)-2:]
+	actors := make([]*Actor, 2)
+	for i, n := range names {
+		if actors[i] = ev.Scheduler.GetActor(n); actors[i] == nil {
+			if n == "" {
+				ev.warnTrace("", "%s: no running actor to watch from; name both actors", name)
+			} else {
+				ev.warnTrace("", "%s: unknown actor %s", name, n)
+			}
+			return nil, nil, false
+		}
+	}
+	return actors[0], actors[1], true
+}
+
+// watch has watcher told when target exits, now if it already has
+func (ev *Evaluator) watch(watcher, target *Actor) {
+	if watcher == target {
+		return
+	}
+	if target.State == ActorDone {
+		ev.sendExit(watcher, target)
+		return
+	}
+	for _, w := range target.Watchers {
+		if w == watcher.Name {
+			return
+		}
+	}
+	target.Watchers = append(target.Watchers, watcher.Name)
+}
+
+// actorExited records why actor finished and tells its watchers
+func (ev *Evaluator) actorExited(actor *Actor, reason Value) {
+	actor.ExitReason = reason
+	for _, name := range actor.Watchers {
+		if w := ev.Scheduler.GetActor(name); w != nil && w.State != ActorDone {
+			ev.sendExit(w, actor)
+		}
+	}
+}
+
+// sendExit puts (exit name reason) for actor in watcher's mailbox
+func (ev *Evaluator) sendExit(watcher, actor *Actor) {
+	msg := Lst(Sym("exit"), Sym(actor.Name), actor.ExitReason)
+	if !watcher.Mailbox.SendStamped(msg, 0, actor.stamp()) {
+		ev.warn("exit-full:"+watcher.Name, "%s: mailbox full, dropped %s", watcher.Name, msg.String())
+		return
+	}
+	if watcher.State == ActorBlocked && strings.HasPrefix(watcher.BlockedOn, "recv") {
+		ev.Scheduler.UnblockActor(watcher.Name)
+	}
+}
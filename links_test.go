@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Link and Monitor Tests
+// ============================================================================
+
+func TestLinksAndMonitors(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{"monitor sees a finish", `
+			(define seen '())
+			(define (watcher) (set! seen (cons (receive!) seen)) (list 'become '(watcher)))
+			(spawn-actor 'watcher 4 '(watcher))
+			(spawn-actor 'worker 4 '(begin (receive!) 'done))
+			(monitor! 'watcher 'worker)
+			(send-to! 'worker 'go)
+			(run-scheduler 20)
+			seen`,
+			"((exit worker done))"},
+		{"monitoring is one way", `
+			(define seen '())
+			(define (watcher) (set! seen (cons (receive!) seen)) (list 'become '(watcher)))
+			(spawn-actor 'watcher 4 '(watcher))
+			(spawn-actor 'worker 4 '(begin (monitor! 'watcher) (receive!) 'done))
+			(run-scheduler 5)
+			(send-to! 'watcher 'stop)
+			(run-scheduler 5)
+			(list seen (mailbox-empty? 'worker))`,
+			"((stop) true)"},
+		{"links go both ways and carry the error", `
+			(define seen '())
+			(define (watcher) (set! seen (cons (receive!) seen)) (list 'become '(watcher)))
+			(spawn-actor 'watcher 4 '(watcher))
+			(spawn-actor 'crasher 4 '(begin (link! 'watcher) (receive!) (car 'oops)))
+			(run-scheduler 5)
+			(send-to! 'crasher 'go)
+			(run-scheduler 5)
+			(list seen (first (actor-state 'crasher)))`,
+			`(((exit crasher #error{(car "a list for argument 1" oops)})) done)`},
+		{"watching an actor that already exited", `
+			(define seen '())
+			(spawn-actor 'gone 4 ''done)
+			(run-scheduler 5)
+			(spawn-actor 'late 4 '(begin (monitor! 'gone) (set! seen (receive!)) 'done))
+			(run-scheduler 5)
+			seen`,
+			"(exit gone done)"},
+		{"linking twice sends one exit", `
+			(define seen '())
+			(define (watcher) (set! seen (cons (receive!) seen)) (list 'become '(watcher)))
+			(spawn-actor 'a 4 '(watcher))
+			(spawn-actor 'b 4 '(begin (receive!) 'done))
+			(link! 'a 'b)
+			(link! 'b 'a)
+			(send-to! 'b 'go)
+			(run-scheduler 20)
+			seen`,
+			"((exit b done))"},
+		{"unknown actor", `(link! 'nobody 'else)`, "nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := NewEvaluator(1000)
+			ev.Quiet = true
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestKillActor(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{"a runaway actor is stopped and its monitor told", `
+			(define seen '())
+			(define (watcher) (set! seen (cons (receive!) seen)) (list 'become '(watcher)))
+			(spawn-actor 'watcher 4 '(watcher))
+			(spawn-actor 'spinner 4 ''yield)
+			(monitor! 'watcher 'spinner)
+			(run-scheduler 5)
+			(kill-actor! 'spinner 'runaway)
+			(list (run-scheduler 10) seen (query 'killed 'spinner '?why))`,
+			"((deadlock 2 ((watcher \"recv (empty)\"))) ((exit spinner runaway)) (((why runaway))))"},
+		{"its mailbox is drained by default", `
+			(spawn-actor 'idle 4 '(begin (receive!) 'done))
+			(spawn-actor 'other 4 '(begin (receive!) 'done))
+			(send-to! 'idle 'a)
+			(send-to! 'idle 'b)
+			(send-to! 'other 'c)
+			(list (kill-actor! 'idle) (kill-actor! 'other 'stop :mailbox 'discard) (mailbox-empty? 'other))`,
+			"((a b) () true)"},
+		{"the on-stop hook runs", `
+			(define stopped false)
+			(spawn-actor 'w 4 '(begin (receive!) 'done) :on-stop (lambda () (set! stopped true)))
+			(run-scheduler 5)
+			(kill-actor! 'w)
+			(list stopped (first (actor-state 'w)))`,
+			"(true done)"},
+		{"senders waiting on its mailbox carry on", `
+			(spawn-actor 'sink 1 ''yield)
+			(spawn-actor 'source 4 '(begin (send-to! 'sink 1) (send-to! 'sink 2) 'done))
+			(run-scheduler 5)
+			(kill-actor! 'sink)
+			(run-scheduler 5)`,
+			"(completed 1)"},
+		{"an actor can kill itself", `
+			(define seen '())
+			(spawn-actor 'watcher 4 '(begin (set! seen (receive!)) 'done))
+			(spawn-actor 'quitter 4 '(begin (kill-actor! (self) 'bored) 'done))
+			(monitor! 'watcher 'quitter)
+			(run-scheduler 10)
+			seen`,
+			"(exit quitter bored)"},
+		{"killing a finished actor does nothing", `
+			(spawn-actor 'gone 4 ''done)
+			(run-scheduler 5)
+			(kill-actor! 'gone)`,
+			"nil"},
+		{"unknown actor", `(kill-actor! 'nobody)`, "nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := NewEvaluator(1000)
+			ev.Quiet = true
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ============================================================================
+// Loading Files
+// ============================================================================
+//
+// (load "protocols/handshake.lisp") evaluates a file in the global
+// environment, so shared protocol libraries can live in their own files.
+// A relative path is looked for next to the file doing the loading first,
+// then in each directory of the load path: the working directory, the
+// directories in PHILOSOPHER_PATH, and any added with add-load-path!.
+// A file that ends up loading itself, directly or through others, is
+// reported as a cycle instead of recursing until the stack runs out.
+
+// defaultLoadPath is the load path a new evaluator starts with
+func defaultLoadPath() []string {
+	path := []string{"."}
+	for _, dir := range filepath.SplitList(os.Getenv("PHILOSOPHER_PATH")) {
+		if dir != "" {
+			path = append(path, dir)
+		}
+	}
+	return path
+}
+
+// resolveLoad finds the file that loading name refers to
+func (ev *Evaluator) resolveLoad(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	var dirs []string
+	if n := len(ev.Loading); n > 0 {
+		dirs = append(dirs, filepath.Dir(ev.Loading[n-1]))
+	}
+	dirs = append(dirs, ev.LoadPath...)
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in %s", name, strings.Join(dirs, string(filepath.ListSeparator)))
+}
+
+// loadFile parses path and evaluates it in the global environment,
+// returning the value of its last expression. Nothing is evaluated if the
+// file doesn't parse.
+func (ev *Evaluator) loadFile(path string) (Value, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return Nil(), err
+	}
+	for i, loading := range ev.Loading {
+		if loading == abs {
+			var chain []string
+			for _, f := range ev.Loading[i:] {
+				chain = append(chain, filepath.Base(f))
+			}
+			chain = append(chain, filepath.Base(abs))
+			return Nil(), fmt.Errorf("cycle %s", strings.Join(chain, " -> "))
+		}
+	}
+	content, err := os.ReadFile(abs)
+	if err != nil {
+		return Nil(), err
+	}
+	exprs, errs := NewParser(string(content)).Parse()
+	if len(errs) > 0 {
+		return Nil(), fmt.Errorf("%s:%d:%d: %s", path, errs[0].Line, errs[0].Col, errs[0].Msg)
+	}
+
+	ev.Loading = append(ev.Loading, abs)
+	defer func() { ev.Loading = ev.Loading[:len(ev.Loading)-1] }()
+	result := Nil()
+	for _, expr := range exprs {
+		result = ev.Eval(expr, nil)
+	}
+	return result, nil
+}
+
+// (load "file.lisp") - evaluate a file found on the load path; returns the
+// value of its last expression, nil if it can't be loaded
+func builtinLoad(ev *Evaluator, args []Value, env *Env) Value {
+	name, ok := ev.filePath("load", args)
+	if !ok {
+		return Nil()
+	}
+	path, err := ev.resolveLoad(name)
+	if err == nil {
+		var result Value
+		if result, err = ev.loadFile(path); err == nil {
+			return result
+		}
+	}
+	ev.warn("", "load: %v", err)
+	return Nil()
+}
+
+// (add-load-path! "dir") - search dir after the rest of the load path
+func builtinAddLoadPath(ev *Evaluator, args []Value, env *Env) Value {
+	if ev.sandboxDenied("add-load-path!") {
+		return Nil()
+	}
+	if len(args) == 0 || (args[0].Type != TypeString && args[0].Type != TypeSymbol) {
+		ev.warn("", "add-load-path!: expected a directory")
+		return Nil()
+	}
+	dir := valueToString(args[0])
+	for _, d := range ev.LoadPath {
+		if d == dir {
+			return Sym("ok")
+		}
+	}
+	ev.LoadPath = append(ev.LoadPath, dir)
+	return Sym("ok")
+}
+
+// (load-path) - the directories load searches, in order
+func builtinLoadPath(ev *Evaluator, args []Value, env *Env) Value {
+	dirs := make([]Value, len(ev.LoadPath))
+	for i, d := range ev.LoadPath {
+		dirs[i] = Str(d)
+	}
+	return Lst(dirs...)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ============================================================================
+// Load Tests
+// ============================================================================
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib")
+	files := map[string]string{
+		"spec.lisp":        `(load "protocols.lisp") (define from-spec (handshake 'a 'b))`,
+		"protocols.lisp":   `(load "util.lisp") (define (handshake x y) (pair x y))`,
+		"util.lisp":        `(define (pair x y) (list x y)) 'util-loaded`,
+		"lib/shared.lisp":  `(define shared-answer 42)`,
+		"loop-a.lisp":      `(load "loop-b.lisp")`,
+		"loop-b.lisp":      `(load "loop-a.lisp")`,
+		"broken.lisp":      `(define broken-ran true) (oops`,
+		"lib/nested.lisp":  `(load "sibling.lisp")`,
+		"lib/sibling.lisp": `'sibling`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	ev.FileAccess = true
+	ev.LoadPath = []string{dir}
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		// Relative loads inside a loaded file resolve next to it
+		{`(load "spec.lisp")`, "(a b)"},
+		{`(handshake 1 2)`, "(1 2)"},
+		{`(load "util.lisp")`, "util-loaded"},
+		{`(load "shared.lisp")`, "nil"},
+		{`(add-load-path! "` + lib + `")`, "ok"},
+		{`(load "shared.lisp")`, "42"},
+		{`(load "nested.lisp")`, "sibling"},
+		{`(load-path)`, `("` + dir + `" "` + lib + `")`},
+		{`(load "loop-a.lisp")`, "nil"},
+		{`(load "broken.lisp")`, "nil"},
+		{`(load "missing.lisp")`, "nil"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	if v, ok := ev.GlobalEnv.Get("broken-ran"); ok && v.IsTruthy() {
+		t.Errorf("a file that doesn't parse shouldn't be evaluated")
+	}
+	if len(ev.Loading) != 0 {
+		t.Errorf("loading stack not unwound: %v", ev.Loading)
+	}
+	found := false
+	for _, w := range ev.Warnings {
+		if w.Message == "load: cycle loop-a.lisp -> loop-b.lisp -> loop-a.lisp" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected a cycle warning, got %v", ev.Warnings)
+	}
+
+	ev.FileAccess = false
+	if got := evalLast(ev, `(load "util.lisp")`).String(); got != "nil" {
+		t.Errorf("load without file access = %s, want nil", got)
+	}
+}
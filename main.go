@@ -4,18 +4,23 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"math"
 	"math/rand"
+	randv2 "math/rand/v2"
 	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
 // ============================================================================
@@ -38,12 +43,18 @@ const (
 	TypeTailCall
 	TypeBlocked
 	TypeTagged
+	TypeMap
+	TypeSet
+	TypeChar // A Unicode code point, kept in Int
 )
 
 type Value struct {
 	Type    ValueType
 	Symbol  string
-	Number  float64
+	Number  float64 // Always set for numbers; approximate when IsInt
+	Int     int64   // Exact value of an integer
+	IsInt   bool
+	SymID   SymbolID // Interned Symbol, from the parser; 0 if made at run time. Next to IsInt so Value doesn't grow
 	Str     string
 	List    []Value
 	Func    *Function
@@ -54,6 +65,8 @@ type Value struct {
 	Tail    *TailCall
 	Blocked *BlockedOp
 	Tagged  *TaggedValue
+	Map     *HashMap
+	Set     *HashSet
 }
 
 type TaggedValue struct {
@@ -61,17 +74,103 @@ type TaggedValue struct {
 	Value Value
 }
 
+// HashMap is a mutable map keyed by the printed form of the key value.
+// Keys holds the original key values so map-keys can return them.
+type HashMap struct {
+	Data map[string]Value
+	Keys map[string]Value
+}
+
+func NewHashMap() *HashMap {
+	return &HashMap{
+		Data: make(map[string]Value),
+		Keys: make(map[string]Value),
+	}
+}
+
+// SortedKeys returns the map's key strings in sorted order so printing
+// and map-keys are stable across runs.
+func (m *HashMap) SortedKeys() []string {
+	keys := make([]string, 0, len(m.Data))
+	for k := range m.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedKeys returns a map's keys in sorted order, for output that must
+// not depend on Go's map iteration order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// HashSet is a mutable set keyed, like HashMap, by the printed form of each item.
+type HashSet struct {
+	Items map[string]Value
+}
+
+func NewHashSet() *HashSet {
+	return &HashSet{Items: make(map[string]Value)}
+}
+
+func (s *HashSet) Add(v Value) { s.Items[v.String()] = v }
+
+func (s *HashSet) Has(v Value) bool {
+	_, ok := s.Items[v.String()]
+	return ok
+}
+
+// Sorted returns the items in key order so printing and set->list are
+// stable across runs.
+func (s *HashSet) Sorted() []Value {
+	keys := make([]string, 0, len(s.Items))
+	for k := range s.Items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	items := make([]Value, len(keys))
+	for i, k := range keys {
+		items[i] = s.Items[k]
+	}
+	return items
+}
+
 type Function struct {
 	Params    []string
+	Optional  []Param // Positional params with defaults: (y 10)
+	Keys      []Param // Keyword params after &key, passed as :name value
 	RestParam string
 	Body      Value
 	Env       *Env
 	IsTail    bool
+	Name      string     // Name it was defined under, for call stack reports
+	Doc       string     // Docstring, for doc and apropos
+	calls     int        // Calls so far, until the body is compiled
+	compiled  *code      // Body compiled, once it has been called twice
+	paramIDs  []SymbolID // Params interned
+	restID    SymbolID   // RestParam interned
+}
+
+// Param is an optional or keyword parameter. Default is an expression
+// evaluated at call time, after the params before it are bound.
+type Param struct {
+	Name    string
+	Default Value
+	ID      SymbolID // Name interned
 }
 
 type TailCall struct {
 	Func Value
 	Args []Value
+	Expr Value // Set with Env: continue evaluating Expr in Env
+	Env  *Env
+	Code *code // Expr compiled, if it was
 }
 
 type BlockReason int
@@ -83,20 +182,44 @@ const (
 	BlockQueueFull
 	BlockQueueEmpty
 	BlockCallStackFull
+	BlockOutOfFuel
+	BlockPromise
 )
 
+func (r BlockReason) String() string {
+	switch r {
+	case BlockStackFull:
+		return "stack full"
+	case BlockStackEmpty:
+		return "stack empty"
+	case BlockQueueFull:
+		return "queue full"
+	case BlockQueueEmpty:
+		return "queue empty"
+	case BlockCallStackFull:
+		return "call stack full"
+	case BlockOutOfFuel:
+		return "out of fuel"
+	case BlockPromise:
+		return "promise not delivered"
+	}
+	return "none"
+}
+
 type BlockedOp struct {
 	Reason   BlockReason
-	Resource interface{}
+	Resource interface{} // For BlockCallStackFull, the call chain as a list of frames
 }
 
 // Value constructors
 func Nil() Value                     { return Value{Type: TypeNil} }
 func Sym(s string) Value             { return Value{Type: TypeSymbol, Symbol: s} }
 func Num(n float64) Value            { return Value{Type: TypeNumber, Number: n} }
+func Int(n int64) Value              { return Value{Type: TypeNumber, Number: float64(n), Int: n, IsInt: true} }
 func Str(s string) Value             { return Value{Type: TypeString, Str: s} }
 func Lst(items ...Value) Value       { return Value{Type: TypeList, List: items} }
 func Bool(b bool) Value              { return Value{Type: TypeBool, Bool: b} }
+func Char(r rune) Value              { return Value{Type: TypeChar, Int: int64(r)} }
 func Blocked(r BlockReason) Value    { return Value{Type: TypeBlocked, Blocked: &BlockedOp{Reason: r}} }
 
 func (v Value) IsNil() bool    { return v.Type == TypeNil }
@@ -120,12 +243,28 @@ func (v Value) IsTruthy() bool {
 }
 
 func (v Value) String() string {
+	return v.format(nil)
+}
+
+// format prints v inside the containers in enclosing. A map, set, stack
+// or queue can be made to hold itself, with map-set! or stack-write!;
+// inside itself it prints as #cycle.
+func (v Value) format(enclosing []interface{}) string {
+	if c := containerOf(v); c != nil {
+		if encloses(enclosing, c) {
+			return "#cycle"
+		}
+		enclosing = append(enclosing, c)
+	}
 	switch v.Type {
 	case TypeNil:
 		return "nil"
 	case TypeSymbol:
 		return v.Symbol
 	case TypeNumber:
+		if v.IsInt {
+			return strconv.FormatInt(v.Int, 10)
+		}
 		if v.Number == float64(int64(v.Number)) {
 			return fmt.Sprintf("%d", int64(v.Number))
 		}
@@ -140,7 +279,7 @@ func (v Value) String() string {
 	case TypeList:
 		parts := make([]string, len(v.List))
 		for i, item := range v.List {
-			parts[i] = item.String()
+			parts[i] = item.format(enclosing)
 		}
 		return "(" + strings.Join(parts, " ") + ")"
 	case TypeFunc:
@@ -152,9 +291,23 @@ func (v Value) String() string {
 	case TypeQueue:
 		return fmt.Sprintf("<queue %d/%d>", len(v.Queue.Data), v.Queue.Capacity)
 	case TypeBlocked:
-		return fmt.Sprintf("<blocked: %d>", v.Blocked.Reason)
+		return fmt.Sprintf("<blocked: %s>", v.Blocked.Reason)
 	case TypeTagged:
-		return fmt.Sprintf("#%s{%s}", v.Tagged.Tag, v.Tagged.Value.String())
+		return fmt.Sprintf("#%s{%s}", v.Tagged.Tag, v.Tagged.Value.format(enclosing))
+	case TypeMap:
+		parts := make([]string, 0, len(v.Map.Data))
+		for _, k := range v.Map.SortedKeys() {
+			parts = append(parts, k+" "+v.Map.Data[k].format(enclosing))
+		}
+		return "{" + strings.Join(parts, " ") + "}"
+	case TypeSet:
+		parts := make([]string, 0, len(v.Set.Items))
+		for _, item := range v.Set.Sorted() {
+			parts = append(parts, item.format(enclosing))
+		}
+		return "#{" + strings.Join(parts, " ") + "}"
+	case TypeChar:
+		return charLiteral(rune(v.Int))
 	case TypeActor:
 		return fmt.Sprintf("<actor:%s>", v.Symbol)
 	default:
@@ -162,6 +315,70 @@ func (v Value) String() string {
 	}
 }
 
+// containerOf is the map, set, stack or queue behind v, or nil if v is
+// none of those mutable containers
+func containerOf(v Value) interface{} {
+	switch v.Type {
+	case TypeMap:
+		return v.Map
+	case TypeSet:
+		return v.Set
+	case TypeStack:
+		return v.Stack
+	case TypeQueue:
+		return v.Queue
+	}
+	return nil
+}
+
+// encloses reports whether container c is one of enclosing
+func encloses(enclosing []interface{}, c interface{}) bool {
+	for _, e := range enclosing {
+		if e == c {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCycle reports whether v holds, at any depth, a container that holds
+// itself. Printing copes with that, but JSON and serialized values can't.
+func hasCycle(v Value) bool {
+	return cycleWithin(v, nil)
+}
+
+func cycleWithin(v Value, enclosing []interface{}) bool {
+	if c := containerOf(v); c != nil {
+		if encloses(enclosing, c) {
+			return true
+		}
+		enclosing = append(enclosing, c)
+	}
+	var items []Value
+	switch v.Type {
+	case TypeList:
+		items = v.List
+	case TypeTagged:
+		items = []Value{v.Tagged.Value}
+	case TypeMap:
+		for k, item := range v.Map.Data {
+			items = append(items, v.Map.Keys[k], item)
+		}
+	case TypeSet:
+		items = v.Set.Sorted()
+	case TypeStack:
+		items = v.Stack.Data
+	case TypeQueue:
+		items = v.Queue.Data
+	}
+	for _, item := range items {
+		if cycleWithin(item, enclosing) {
+			return true
+		}
+	}
+	return false
+}
+
 // ============================================================================
 // Bounded Data Structures
 // ============================================================================
@@ -169,6 +386,7 @@ func (v Value) String() string {
 type BoundedStack struct {
 	Capacity int
 	Data     []Value
+	Name     string // Resource name for capability checks (optional)
 }
 
 func NewStack(capacity int) *BoundedStack {
@@ -221,8 +439,12 @@ func (s *BoundedStack) Write(index int, v Value) bool {
 }
 
 type BoundedQueue struct {
-	Capacity int
-	Data     []Value
+	Capacity     int
+	ByteCapacity int // Total message size the queue holds, 0 for no limit
+	Data         []Value
+	Priorities   []int   // Priority of each item in Data; nil while all are 0
+	Stamps       []Stamp // Clocks each item in Data was sent with; nil while all are zero
+	Name         string  // Resource name for capability checks (optional)
 }
 
 func NewQueue(capacity int) *BoundedQueue {
@@ -235,21 +457,120 @@ func NewQueue(capacity int) *BoundedQueue {
 func (q *BoundedQueue) IsFull() bool  { return len(q.Data) >= q.Capacity }
 func (q *BoundedQueue) IsEmpty() bool { return len(q.Data) == 0 }
 
-func (q *BoundedQueue) SendNow(v Value) bool {
+// Bytes is the total size of the messages waiting in the queue
+func (q *BoundedQueue) Bytes() int {
+	total := 0
+	for _, v := range q.Data {
+		total += valueSize(v)
+	}
+	return total
+}
+
+// Oversized reports whether v could never fit, even in an empty queue
+func (q *BoundedQueue) Oversized(v Value) bool {
+	return q.ByteCapacity > 0 && valueSize(v) > q.ByteCapacity
+}
+
+// Fits reports whether there is room for v now, by count and by bytes
+func (q *BoundedQueue) Fits(v Value) bool { return q.HasRoom(valueSize(v)) }
+
+// HasRoom reports whether a message of size bytes would fit now
+func (q *BoundedQueue) HasRoom(size int) bool {
 	if q.IsFull() {
 		return false
 	}
-	q.Data = append(q.Data, v)
+	return q.ByteCapacity == 0 || q.Bytes()+size <= q.ByteCapacity
+}
+
+func (q *BoundedQueue) SendNow(v Value) bool {
+	return q.SendPriority(v, 0)
+}
+
+// SendPriority queues v ahead of everything with a lower priority and
+// behind everything else, so items of equal priority stay in order
+func (q *BoundedQueue) SendPriority(v Value, priority int) bool {
+	return q.SendStamped(v, priority, Stamp{})
+}
+
+// SendStamped is SendPriority for a message sent with the clocks in stamp
+// (see clocks.go)
+func (q *BoundedQueue) SendStamped(v Value, priority int, stamp Stamp) bool {
+	if !q.Fits(v) {
+		return false
+	}
+	if !stamp.zero() && q.Stamps == nil {
+		q.Stamps = make([]Stamp, len(q.Data))
+	}
+	i := len(q.Data)
+	if priority != 0 || q.Priorities != nil {
+		if q.Priorities == nil {
+			q.Priorities = make([]int, len(q.Data))
+		}
+		for i > 0 && q.Priorities[i-1] < priority {
+			i--
+		}
+		q.Priorities = append(q.Priorities[:i], append([]int{priority}, q.Priorities[i:]...)...)
+	}
+	q.Data = append(q.Data[:i], append([]Value{v}, q.Data[i:]...)...)
+	if q.Stamps != nil {
+		q.Stamps = append(q.Stamps[:i], append([]Stamp{stamp}, q.Stamps[i:]...)...)
+	}
 	return true
 }
 
+// Find returns the position of the first item match accepts
+func (q *BoundedQueue) Find(match func(Value) bool) (int, bool) {
+	for i, v := range q.Data {
+		if match(v) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// RecvAt removes and returns the item at position i, with its stamp
+func (q *BoundedQueue) RecvAt(i int) (Value, Stamp) {
+	v := q.Data[i]
+	q.Data = append(q.Data[:i], q.Data[i+1:]...)
+	if q.Priorities != nil {
+		q.Priorities = append(q.Priorities[:i], q.Priorities[i+1:]...)
+	}
+	var stamp Stamp
+	if q.Stamps != nil {
+		stamp = q.Stamps[i]
+		q.Stamps = append(q.Stamps[:i], q.Stamps[i+1:]...)
+	}
+	return v, stamp
+}
+
 func (q *BoundedQueue) RecvNow() (Value, bool) {
+	v, _, ok := q.RecvStamped()
+	return v, ok
+}
+
+// RecvStamped is RecvNow that also gives the clocks the item was sent
+// with
+func (q *BoundedQueue) RecvStamped() (Value, Stamp, bool) {
 	if q.IsEmpty() {
-		return Nil(), false
+		return Nil(), Stamp{}, false
 	}
 	v := q.Data[0]
 	q.Data = q.Data[1:]
-	return v, true
+	if q.Priorities != nil {
+		q.Priorities = q.Priorities[1:]
+	}
+	var stamp Stamp
+	if q.Stamps != nil {
+		stamp, q.Stamps = q.Stamps[0], q.Stamps[1:]
+	}
+	return v, stamp, true
+}
+
+// Clear empties the queue
+func (q *BoundedQueue) Clear() {
+	q.Data = q.Data[:0]
+	q.Priorities = nil
+	q.Stamps = nil
 }
 
 func (q *BoundedQueue) PeekNow() (Value, bool) {
@@ -259,6 +580,47 @@ func (q *BoundedQueue) PeekNow() (Value, bool) {
 	return q.Data[0], true
 }
 
+// valueSize approximates the size of a value in bytes, for message and
+// mailbox accounting: strings and symbols count their bytes, numbers 8,
+// nil and booleans 1, and lists, maps and tagged values the sum of their parts.
+func valueSize(v Value) int {
+	switch v.Type {
+	case TypeNil, TypeBool:
+		return 1
+	case TypeString:
+		return len(v.Str)
+	case TypeChar:
+		return utf8.RuneLen(rune(v.Int))
+	case TypeSymbol, TypeActor:
+		return len(v.Symbol)
+	case TypeList:
+		return sizeOfValues(v.List)
+	case TypeMap:
+		total := 0
+		for k, item := range v.Map.Data {
+			total += valueSize(v.Map.Keys[k]) + valueSize(item)
+		}
+		return total
+	case TypeTagged:
+		return len(v.Tagged.Tag) + valueSize(v.Tagged.Value)
+	case TypeSet:
+		return sizeOfValues(v.Set.Sorted())
+	case TypeStack:
+		return sizeOfValues(v.Stack.Data)
+	case TypeQueue:
+		return sizeOfValues(v.Queue.Data)
+	}
+	return 8
+}
+
+func sizeOfValues(items []Value) int {
+	total := 0
+	for _, item := range items {
+		total += valueSize(item)
+	}
+	return total
+}
+
 // ============================================================================
 // Tokenizer
 // ============================================================================
@@ -269,9 +631,13 @@ const (
 	TokLParen TokenType = iota
 	TokRParen
 	TokQuote
+	TokQuasiquote
+	TokUnquote
+	TokUnquoteSplicing
 	TokSymbol
 	TokNumber
 	TokString
+	TokChar
 	TokEOF
 )
 
@@ -279,15 +645,31 @@ type Token struct {
 	Type   TokenType
 	Text   string
 	Number float64
+	Line   int
+	Col    int
+}
+
+// ParseError describes malformed input at a 1-based line and column
+type ParseError struct {
+	Line int
+	Col  int
+	Msg  string
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("line %d, col %d: %s", e.Line, e.Col, e.Msg)
 }
 
 type Tokenizer struct {
-	input []rune
-	pos   int
+	input  []rune
+	pos    int
+	line   int
+	col    int
+	Errors []ParseError
 }
 
 func NewTokenizer(input string) *Tokenizer {
-	return &Tokenizer{input: []rune(input), pos: 0}
+	return &Tokenizer{input: []rune(input), pos: 0, line: 1, col: 1}
 }
 
 func (t *Tokenizer) peek() rune {
@@ -303,6 +685,12 @@ func (t *Tokenizer) advance() rune {
 	}
 	r := t.input[t.pos]
 	t.pos++
+	if r == '\n' {
+		t.line++
+		t.col = 1
+	} else {
+		t.col++
+	}
 	return r
 }
 
@@ -321,11 +709,17 @@ func (t *Tokenizer) skipWhitespace() {
 	}
 }
 
+// isDelimiter reports whether c ends a symbol, number or char token
+func isDelimiter(c rune) bool {
+	return unicode.IsSpace(c) || strings.ContainsRune("()'`,\"", c)
+}
+
 func (t *Tokenizer) Next() Token {
 	t.skipWhitespace()
 
+	line, col := t.line, t.col
 	if t.pos >= len(t.input) {
-		return Token{Type: TokEOF}
+		return Token{Type: TokEOF, Line: line, Col: col}
 	}
 
 	c := t.peek()
@@ -333,13 +727,23 @@ func (t *Tokenizer) Next() Token {
 	switch c {
 	case '(':
 		t.advance()
-		return Token{Type: TokLParen}
+		return Token{Type: TokLParen, Line: line, Col: col}
 	case ')':
 		t.advance()
-		return Token{Type: TokRParen}
+		return Token{Type: TokRParen, Line: line, Col: col}
 	case '\'':
 		t.advance()
-		return Token{Type: TokQuote}
+		return Token{Type: TokQuote, Line: line, Col: col}
+	case '`':
+		t.advance()
+		return Token{Type: TokQuasiquote, Line: line, Col: col}
+	case ',':
+		t.advance()
+		if t.peek() == '@' {
+			t.advance()
+			return Token{Type: TokUnquoteSplicing, Line: line, Col: col}
+		}
+		return Token{Type: TokUnquote, Line: line, Col: col}
 	case '"':
 		t.advance()
 		var sb strings.Builder
@@ -363,26 +767,81 @@ func (t *Tokenizer) Next() Token {
 				sb.WriteRune(t.advance())
 			}
 		}
+		if t.pos >= len(t.input) {
+			t.Errors = append(t.Errors, ParseError{line, col, "unterminated string"})
+		}
 		t.advance() // closing quote
-		return Token{Type: TokString, Text: sb.String()}
+		return Token{Type: TokString, Text: sb.String(), Line: line, Col: col}
+	case '#':
+		if t.pos+1 < len(t.input) && t.input[t.pos+1] == '\\' {
+			// #\a, #\space: the first rune after the backslash is always
+			// part of the char, so #\( and #\; read as chars
+			t.advance()
+			t.advance()
+			var sb strings.Builder
+			if t.pos < len(t.input) {
+				sb.WriteRune(t.advance())
+			}
+			for t.pos < len(t.input) && !isDelimiter(t.peek()) {
+				sb.WriteRune(t.advance())
+			}
+			return Token{Type: TokChar, Text: sb.String(), Line: line, Col: col}
+		}
+		fallthrough
 	default:
 		var sb strings.Builder
-		for t.pos < len(t.input) {
-			c := t.peek()
-			if unicode.IsSpace(c) || c == '(' || c == ')' || c == '\'' || c == '"' {
-				break
-			}
+		for t.pos < len(t.input) && !isDelimiter(t.peek()) {
 			sb.WriteRune(t.advance())
 		}
 		text := sb.String()
 
 		// Try parsing as number
 		if n, err := strconv.ParseFloat(text, 64); err == nil {
-			return Token{Type: TokNumber, Number: n, Text: text}
+			return Token{Type: TokNumber, Number: n, Text: text, Line: line, Col: col}
+		}
+
+		return Token{Type: TokSymbol, Text: text, Line: line, Col: col}
+	}
+}
+
+// charNames are the chars written by name: #\space, #\newline, ...
+var charNames = map[string]rune{
+	"space":   ' ',
+	"newline": '\n',
+	"tab":     '\t',
+	"return":  '\r',
+	"nul":     0,
+}
+
+// parseChar reads the text after #\: a single rune, a name from
+// charNames, or x and a hex code point (#\x3bb)
+func parseChar(text string) (rune, bool) {
+	if utf8.RuneCountInString(text) == 1 {
+		r, _ := utf8.DecodeRuneInString(text)
+		return r, true
+	}
+	if r, ok := charNames[text]; ok {
+		return r, true
+	}
+	if strings.HasPrefix(text, "x") {
+		if n, err := strconv.ParseUint(text[1:], 16, 32); err == nil && utf8.ValidRune(rune(n)) {
+			return rune(n), true
 		}
+	}
+	return 0, false
+}
 
-		return Token{Type: TokSymbol, Text: text}
+// charLiteral writes r the way the reader reads it back
+func charLiteral(r rune) string {
+	for name, c := range charNames {
+		if c == r {
+			return `#\` + name
+		}
+	}
+	if !unicode.IsGraphic(r) {
+		return fmt.Sprintf(`#\x%x`, r)
 	}
+	return `#\` + string(r)
 }
 
 // ============================================================================
@@ -392,6 +851,7 @@ func (t *Tokenizer) Next() Token {
 type Parser struct {
 	tokenizer *Tokenizer
 	current   Token
+	errors    []ParseError
 }
 
 func NewParser(input string) *Parser {
@@ -406,41 +866,88 @@ func (p *Parser) advance() Token {
 	return tok
 }
 
-func (p *Parser) Parse() []Value {
+// Parse reads every top-level expression. Malformed input is reported
+// in the returned errors; whatever could be recovered is still returned.
+func (p *Parser) Parse() ([]Value, []ParseError) {
 	var exprs []Value
 	for p.current.Type != TokEOF {
+		if p.current.Type == TokRParen {
+			p.errorf(p.current, "unexpected ')'")
+			p.advance()
+			continue
+		}
 		exprs = append(exprs, p.parseExpr())
 	}
-	return exprs
+	errs := append(p.tokenizer.Errors, p.errors...)
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].Line != errs[j].Line {
+			return errs[i].Line < errs[j].Line
+		}
+		return errs[i].Col < errs[j].Col
+	})
+	return exprs, errs
+}
+
+func (p *Parser) errorf(tok Token, format string, args ...interface{}) {
+	p.errors = append(p.errors, ParseError{Line: tok.Line, Col: tok.Col, Msg: fmt.Sprintf(format, args...)})
 }
 
 func (p *Parser) parseExpr() Value {
 	switch p.current.Type {
 	case TokLParen:
-		p.advance()
+		open := p.advance()
 		
 		// Normal list
 		var items []Value
 		for p.current.Type != TokRParen && p.current.Type != TokEOF {
 			items = append(items, p.parseExpr())
 		}
+		if p.current.Type == TokEOF {
+			p.errorf(open, "unclosed '('")
+		}
 		p.advance() // consume ')'
 		return Lst(items...)
 
 	case TokQuote:
-		p.advance()
+		quote := p.advance()
+		if p.current.Type == TokEOF || p.current.Type == TokRParen {
+			p.errorf(quote, "quote with nothing to quote")
+			return Nil()
+		}
 		// Quote wraps next expression: 'x -> (quote x)
 		expr := p.parseExpr()
-		return Lst(Sym("quote"), expr)
+		return Lst(InternedSym("quote"), expr)
+
+	case TokQuasiquote, TokUnquote, TokUnquoteSplicing:
+		// `x -> (quasiquote x), ,x -> (unquote x), ,@x -> (unquote-splicing x)
+		tok := p.advance()
+		name := map[TokenType]string{TokQuasiquote: "quasiquote", TokUnquote: "unquote", TokUnquoteSplicing: "unquote-splicing"}[tok.Type]
+		if p.current.Type == TokEOF || p.current.Type == TokRParen {
+			p.errorf(tok, "%s with nothing to quote", name)
+			return Nil()
+		}
+		return Lst(InternedSym(name), p.parseExpr())
 
 	case TokNumber:
 		tok := p.advance()
+		if n, err := strconv.ParseInt(tok.Text, 10, 64); err == nil {
+			return Int(n)
+		}
 		return Num(tok.Number)
 
 	case TokString:
 		tok := p.advance()
 		return Str(tok.Text)
 
+	case TokChar:
+		tok := p.advance()
+		r, ok := parseChar(tok.Text)
+		if !ok {
+			p.errorf(tok, "unknown character #\\%s", tok.Text)
+			return Nil()
+		}
+		return Char(r)
+
 	case TokSymbol:
 		tok := p.advance()
 		switch tok.Text {
@@ -451,7 +958,7 @@ func (p *Parser) parseExpr() Value {
 		case "nil":
 			return Nil()
 		default:
-			return Sym(tok.Text)
+			return InternedSym(tok.Text)
 		}
 
 	default:
@@ -464,11 +971,22 @@ func (p *Parser) parseExpr() Value {
 // Environment
 // ============================================================================
 
+// Env is a scope. Global, module and actor scopes are maps by name.
+// Function calls and let bind a few names each, so their scopes are
+// frames: slices searched by interned symbol ID (see symbols.go).
 type Env struct {
-	bindings map[string]Value
+	bindings map[string]Value // Made by NewEnv; nil for a frame
+	frame    []binding        // Made by NewFrame, in the order bound
 	parent   *Env
 }
 
+// binding is a name bound in a frame
+type binding struct {
+	ID    SymbolID
+	Name  string
+	Value Value
+}
+
 func NewEnv(parent *Env) *Env {
 	return &Env{
 		bindings: make(map[string]Value),
@@ -476,23 +994,107 @@ func NewEnv(parent *Env) *Env {
 	}
 }
 
+// NewFrame makes a slice-backed scope with room for size bindings
+func NewFrame(parent *Env, size int) *Env {
+	return &Env{frame: make([]binding, 0, size), parent: parent}
+}
+
 func (e *Env) Get(name string) (Value, bool) {
-	if v, ok := e.bindings[name]; ok {
-		return v, true
+	for ; e != nil; e = e.parent {
+		if e.bindings != nil {
+			if v, ok := e.bindings[name]; ok {
+				return v, true
+			}
+			continue
+		}
+		for i := range e.frame {
+			if e.frame[i].Name == name {
+				return e.frame[i].Value, true
+			}
+		}
 	}
-	if e.parent != nil {
-		return e.parent.Get(name)
+	return Nil(), false
+}
+
+// Lookup is Get for a symbol, comparing IDs instead of names in frames
+// when the symbol was interned
+func (e *Env) Lookup(sym Value) (Value, bool) {
+	if sym.SymID == 0 {
+		return e.Get(sym.Symbol)
+	}
+	for ; e != nil; e = e.parent {
+		if e.bindings != nil {
+			if v, ok := e.bindings[sym.Symbol]; ok {
+				return v, true
+			}
+			continue
+		}
+		for i := range e.frame {
+			if e.frame[i].ID == sym.SymID {
+				return e.frame[i].Value, true
+			}
+		}
 	}
 	return Nil(), false
 }
 
+// setSym sets a symbol, without interning it again in a frame
+func (e *Env) setSym(sym Value, val Value) {
+	if e.bindings != nil || sym.SymID == 0 {
+		e.Set(sym.Symbol, val)
+		return
+	}
+	e.bind(sym.SymID, sym.Symbol, val)
+}
+
 func (e *Env) Set(name string, val Value) {
-	e.bindings[name] = val
+	if e.bindings != nil {
+		e.bindings[name] = val
+		return
+	}
+	e.bind(Intern(name), name, val)
+}
+
+// bind sets an interned name in a frame
+func (e *Env) bind(id SymbolID, name string, val Value) {
+	for i := range e.frame {
+		if e.frame[i].ID == id {
+			e.frame[i].Value = val
+			return
+		}
+	}
+	e.frame = append(e.frame, binding{ID: id, Name: name, Value: val})
+}
+
+// has reports whether name is bound in e itself
+func (e *Env) has(name string) bool {
+	if e.bindings != nil {
+		_, ok := e.bindings[name]
+		return ok
+	}
+	for i := range e.frame {
+		if e.frame[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// local returns the bindings made in e itself, by name
+func (e *Env) local() map[string]Value {
+	if e.bindings != nil {
+		return e.bindings
+	}
+	m := make(map[string]Value, len(e.frame))
+	for _, b := range e.frame {
+		m[b.Name] = b.Value
+	}
+	return m
 }
 
 func (e *Env) SetLocal(name string, val Value) {
-	if _, ok := e.bindings[name]; ok {
-		e.bindings[name] = val
+	if e.has(name) {
+		e.Set(name, val)
 		return
 	}
 	if e.parent != nil {
@@ -501,7 +1103,7 @@ func (e *Env) SetLocal(name string, val Value) {
 			return
 		}
 	}
-	e.bindings[name] = val
+	e.Set(name, val)
 }
 
 // ============================================================================
@@ -516,6 +1118,93 @@ type Evaluator struct {
 	Scheduler    *Scheduler
 	DatalogDB    *DatalogDB  // Embedded Datalog for temporal reasoning
 	SeenErrors   map[string]bool // Avoid repeating same error
+	Warnings     []Warning       // Runtime warnings for the current evaluation, at most maxWarnings
+	WarningsDropped int          // Warnings past maxWarnings, printed but not kept
+	Events       *EventBus       // Scheduler events for tracing and other observers
+	CapMode      bool                    // Require capabilities for shared resources
+	Grants       map[string][]Capability // Capabilities held by each actor
+	Modules      map[string]*Module      // Modules by name
+	Costs        *CostTracker            // Per-action costs and budgets
+	CurModule    *Module                 // Module whose body is being evaluated
+	Quiet        bool                    // Suppress print output and warnings (scenario search)
+	SymbolRefs   map[string]bool         // When set, records every symbol looked up (re-simulation)
+	FileAccess   bool                    // Allow the file I/O builtins; off for code from the web and MCP
+	Sandbox      bool                    // Deny host I/O and registry writes, for /eval requests; see sandbox.go
+	Features     map[string]bool         // Spec features enabled for this run, for when-feature
+	Rand         *rand.Rand              // Source for the rand builtin; see SetSeed
+	Seed         int64                   // Seed Rand was last given
+	randSrc      *randSource             // Rand's source, to copy it from
+	Exprs        []evalFrame             // Expressions being evaluated, outermost first, for stack traces
+	stepExprs    []evalFrame             // Spare Exprs buffer for actor steps
+	Debugger     *Debugger               // REPL debugger for (break) and stepping; nil outside the REPL
+	EvalCount    int64                   // Expressions evaluated so far, for time and profile
+	Profile      map[string]*profileEntry // Counts per function while profiling; nil otherwise
+	builtinNames map[string]string       // Builtin function pointer to global name, for profile reports
+	Interpret    bool                    // Never compile function bodies; walk the expression tree instead
+	Fuel         int64                   // Evaluations one top-level Eval may take before it is stopped; 0 = unlimited
+	fuelEnd      int64                   // EvalCount at which the current top-level Eval runs out; 0 outside one, -1 once out
+	LoadPath     []string                // Directories load searches after the loading file's own
+	Loading      []string                // Files being loaded, outermost first, to catch cycles
+	journaling   *Actor                  // Actor whose step is running, whose effects are journaled; see replay.go
+	scheduleHooks map[int]bool           // Event bus ids of on-schedule-event! hooks
+	snapshots    []*Evaluator            // Worlds saved by snapshot-world, never run; see snapshot.go
+	schedulers   []*Scheduler            // Schedulers named by #scheduler{n} values; see schedulers.go
+}
+
+// Warning is a runtime diagnostic attributed to the actor and scheduler
+// step that produced it. Repeats of the same warning bump Count.
+type Warning struct {
+	Key     string `json:"-"`
+	Message string `json:"message"`
+	Actor   string `json:"actor,omitempty"`
+	Step    int64    `json:"step"`
+	Count   int      `json:"count"`
+	Stack   []string `json:"stack,omitempty"` // Call chain or stack trace, outermost first
+}
+
+// maxWarnings is how many warnings an evaluator keeps. ResetWarnings
+// empties the list, but only /eval calls it, so a long run, the REPL or
+// -watch would otherwise keep every unkeyed warning it ever gave.
+const maxWarnings = 1000
+
+// warn records a runtime warning and prints it to stderr the first time
+// the key is seen. An empty key disables deduplication. Past maxWarnings
+// warnings are still printed but only counted in WarningsDropped.
+func (ev *Evaluator) warn(key string, format string, args ...interface{}) {
+	if key != "" && ev.SeenErrors[key] {
+		for i := range ev.Warnings {
+			if ev.Warnings[i].Key == key {
+				ev.Warnings[i].Count++
+				break
+			}
+		}
+		return
+	}
+	if key != "" {
+		ev.SeenErrors[key] = true
+	}
+	msg := fmt.Sprintf(format, args...)
+	w := Warning{Key: key, Message: msg, Count: 1}
+	if ev.Scheduler != nil {
+		w.Actor = ev.Scheduler.CurrentActor
+		w.Step = ev.Scheduler.StepCount
+	}
+	if len(ev.Warnings) < maxWarnings {
+		ev.Warnings = append(ev.Warnings, w)
+	} else {
+		ev.WarningsDropped++
+	}
+	if !ev.Quiet {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+}
+
+// ResetWarnings clears collected warnings so the next evaluation starts
+// fresh and previously suppressed warnings are reported again.
+func (ev *Evaluator) ResetWarnings() {
+	ev.Warnings = nil
+	ev.WarningsDropped = 0
+	ev.SeenErrors = make(map[string]bool)
 }
 
 // ============================================================================
@@ -542,6 +1231,43 @@ type Actor struct {
 	GuardSeen     bool
 	CSPStrict     bool
 	CSPViolations []string
+	Subscriptions []Goal   // Fact patterns delivered to the mailbox when asserted
+	Topics        []string // Topics whose published messages it receives (see topics.go)
+	// Lifecycle hooks: thunks run when the actor first runs, finishes, and blocks
+	OnStart Value
+	OnStop  Value
+	OnBlock Value
+	Started bool
+	// Actors sent (exit name reason) when this one exits (see links.go)
+	Watchers   []string
+	ExitReason Value // done, or the error value it stopped on
+	// The receive-timeout! or select! wait in progress, and whether its
+	// time ran out
+	Timeout  int
+	TimedOut bool
+	Priority int         // Higher runs first under the priority policy
+	Steps    int64       // Steps it has taken
+	Clock    int64       // Lamport clock (see clocks.go)
+	VClock   VectorClock // Vector clock, while vector clocks are on (see vclocks.go)
+	// Progress, for livelock and starvation detection (see progress.go)
+	Received, Sent, Becomes int
+	Idle                    int64 // Steps in a row taken without progress
+	Waiting                 int64 // Steps others have taken since it was last scheduled
+	// Fairness accounting (see fairness.go)
+	Budget      int64 // Steps it may still take, while Budgeted
+	Budgeted    bool
+	LongestWait int64 // Most steps others have taken while it waited to run
+	// The ask! it is waiting for a reply to, 0 for none (see ask.go)
+	Asking int64
+	// The channel operations it is blocked waiting to make (see channels.go)
+	Waits []chanWait
+	noted ActorState // State as of its last lifecycle fact (see lifecycle.go)
+	// Calls with effects made by a step that blocked, answered from here
+	// when it is retried (see replay.go)
+	Journal   []JournalEntry
+	BlockedAt int // Builtin calls the blocked step made, up to the one that blocked
+	calls     int // Builtin calls made so far this step
+	replayed  int // Journal entries used so far this step
 }
 
 type Scheduler struct {
@@ -552,6 +1278,22 @@ type Scheduler struct {
 	MaxSteps     int64         // 0 = unlimited
 	Trace        bool          // Print execution trace
 	CSPEnforce   bool          // CSP enforcement mode
+	CSPAbort     bool          // Stop the run at the first CSP violation
+	VectorClocks bool          // Actors keep vector clocks (see vclocks.go)
+	AutoTrace    bool          // Actors record guard, effect and state-change facts (see autotrace.go)
+	Virtual      bool          // Time is VirtualTime rather than StepCount (see vtime.go)
+	VirtualTime  int64         // The clock advance-time! moves
+	Script       []string      // When replaying a recorded run, the actor for each upcoming step
+	Diverged     bool          // The replay asked for an actor that wasn't runnable
+	Policy       string        // How the next actor is chosen (see policies.go); "" is round-robin
+	Quiescence   bool          // Report (quiescent n) instead of deadlock when all wait on empty mailboxes
+	Stimuli      []ScriptedStimulus // Scripted messages from outside, in step order
+	NextStimulus int                // Index of the first stimulus not yet delivered
+	Timers       map[int64][]Timer  // Timer wheel: pending timers by the step they are due (see timers.go)
+	timeouts     int                // receive-timeout! waits started so far
+	asks         int64              // ask! requests sent so far
+	Promises     []Promise          // Every promise made, by number less one (see promises.go)
+	Faults       *Faults            // Unreliable sends, or nil (see faults.go)
 }
 
 func NewScheduler() *Scheduler {
@@ -583,40 +1325,279 @@ func (ev *Evaluator) resetCSPState(actorName string) {
 	}
 }
 
-func (ev *Evaluator) checkCSPViolation(varName string) bool {
-	if ev.Scheduler == nil || !ev.Scheduler.CSPEnforce || ev.Scheduler.CurrentActor == "" {
-		return false
-	}
-	actor := ev.Scheduler.GetActor(ev.Scheduler.CurrentActor)
-	if actor == nil || actor.GuardSeen {
-		return false
+// ============================================================================
+// Capabilities
+// ============================================================================
+
+// Capability grants an actor a right ("read" or "write") on a named
+// shared resource: a registry key or a named stack/queue.
+type Capability struct {
+	Resource string
+	Right    string
+}
+
+func (c Capability) Value() Value {
+	return Value{Type: TypeTagged, Tagged: &TaggedValue{
+		Tag:   "capability",
+		Value: Lst(Sym(c.Resource), Sym(c.Right)),
+	}}
+}
+
+func capabilityFromValue(v Value) (Capability, bool) {
+	if v.Type != TypeTagged || v.Tagged.Tag != "capability" {
+		return Capability{}, false
 	}
-	violation := fmt.Sprintf("CSP violation: set! '%s' before guard in actor '%s'", varName, ev.Scheduler.CurrentActor)
-	actor.CSPViolations = append(actor.CSPViolations, violation)
-	if ev.Scheduler.Trace {
-		fmt.Fprintln(os.Stderr, "  ⚠ "+violation)
+	parts := v.Tagged.Value.List
+	if len(parts) != 2 {
+		return Capability{}, false
 	}
-	return actor.CSPStrict
+	return Capability{Resource: valueToString(parts[0]), Right: valueToString(parts[1])}, true
 }
 
-func (s *Scheduler) AddActor(name string, mailboxSize int, env *Env, code Value) *Actor {
-	actor := &Actor{
-		Name:    name,
-		Mailbox: NewQueue(mailboxSize),
-		State:   ActorRunnable,
-		Env:     env,
-		Code:    code,
-		Result:  Nil(),
+func (ev *Evaluator) hasCapability(actor, resource, right string) bool {
+	for _, c := range ev.Grants[actor] {
+		if c.Resource == resource && c.Right == right {
+			return true
+		}
 	}
-	s.Actors[name] = actor
-	s.RunQueue = append(s.RunQueue, name)
-	return actor
+	return false
+}
+
+// checkCap reports whether the current actor may use resource with right.
+// Only actor code is checked; top-level setup code and unnamed resources
+// are always allowed. In capability mode every checked access is recorded
+// as an (accessed actor resource right) or (cap-violation ...) fact.
+func (ev *Evaluator) checkCap(resource, right string) bool {
+	if !ev.CapMode || resource == "" || ev.Scheduler.CurrentActor == "" {
+		return true
+	}
+	actor := ev.Scheduler.CurrentActor
+	if ev.hasCapability(actor, resource, right) {
+		ev.DatalogDB.AssertAtTime("accessed", ev.now(),
+			Atom(actor), Atom(resource), Atom(right))
+		return true
+	}
+	ev.DatalogDB.AssertAtTime("cap-violation", ev.now(),
+		Atom(actor), Atom(resource), Atom(right))
+	ev.warn("cap:"+actor+":"+resource+":"+right,
+		"capability violation: %s lacks %s on %s", actor, right, resource)
+	return false
+}
+
+// (cap resource right)
+func builtinCap(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 {
+		return Nil()
+	}
+	return Capability{Resource: valueToString(args[0]), Right: valueToString(args[1])}.Value()
+}
+
+// (grant actor cap)
+func builtinGrant(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 {
+		return Bool(false)
+	}
+	c, ok := capabilityFromValue(args[1])
+	if !ok {
+		return Bool(false)
+	}
+	actor := valueToString(args[0])
+	if !ev.hasCapability(actor, c.Resource, c.Right) {
+		ev.Grants[actor] = append(ev.Grants[actor], c)
+	}
+	return Bool(true)
+}
+
+// (revoke! actor cap)
+func builtinRevoke(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 {
+		return Bool(false)
+	}
+	c, ok := capabilityFromValue(args[1])
+	if !ok {
+		return Bool(false)
+	}
+	actor := valueToString(args[0])
+	caps := ev.Grants[actor]
+	for i, held := range caps {
+		if held == c {
+			ev.Grants[actor] = append(caps[:i], caps[i+1:]...)
+			return Bool(true)
+		}
+	}
+	return Bool(false)
+}
+
+// (capabilities actor) - list of caps held by actor
+func builtinCapabilities(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 {
+		return Lst()
+	}
+	var result []Value
+	for _, c := range ev.Grants[valueToString(args[0])] {
+		result = append(result, c.Value())
+	}
+	return Lst(result...)
+}
+
+// (capability-mode! bool)
+func builtinCapabilityMode(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) > 0 {
+		ev.CapMode = args[0].IsTruthy()
+	}
+	return Bool(ev.CapMode)
+}
+
+// ============================================================================
+// Costs
+// ============================================================================
+
+// CostTracker accumulates the cost of actions, per actor and globally.
+// Table maps an action (a function name, or "message-size" for a cost per
+// character of each sent message) to its cost.
+type CostTracker struct {
+	Table   map[string]float64
+	Total   float64
+	ByActor map[string]float64
+	Budgets map[string]float64
+}
+
+func NewCostTracker() *CostTracker {
+	return &CostTracker{
+		Table:   make(map[string]float64),
+		ByActor: make(map[string]float64),
+		Budgets: make(map[string]float64),
+	}
+}
+
+// chargeAction charges the cost of calling head, if it has one
+func (ev *Evaluator) chargeAction(head Value) {
+	if len(ev.Costs.Table) == 0 || !head.IsSymbol() {
+		return
+	}
+	if cost, ok := ev.Costs.Table[head.Symbol]; ok {
+		ev.addCost(head.Symbol, cost)
+	}
+}
+
+// addCost charges the current actor ("external" at top level), asserts
+// a (cost actor action amount) fact, and flags budget overruns with an
+// (over-budget actor total budget) fact.
+func (ev *Evaluator) addCost(action string, amount float64) {
+	actor := ev.Scheduler.CurrentActor
+	if actor == "" {
+		actor = "external"
+	}
+	c := ev.Costs
+	before := c.ByActor[actor]
+	c.Total += amount
+	c.ByActor[actor] = before + amount
+	ev.DatalogDB.AssertAtTime("cost", ev.now(),
+		Atom(actor), Atom(action), NumTerm(amount))
+	if budget, ok := c.Budgets[actor]; ok && before <= budget && c.ByActor[actor] > budget {
+		ev.DatalogDB.AssertAtTime("over-budget", ev.now(),
+			Atom(actor), NumTerm(c.ByActor[actor]), NumTerm(budget))
+		ev.warn("over-budget:"+actor, "%s exceeded its budget of %s", actor, Num(budget).String())
+	}
+}
+
+// (set-cost! action cost)
+func builtinSetCost(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 || args[1].Type != TypeNumber {
+		return Nil()
+	}
+	ev.Costs.Table[valueToString(args[0])] = args[1].Number
+	return args[1]
+}
+
+// (set-budget! actor amount)
+func builtinSetBudget(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 || args[1].Type != TypeNumber {
+		return Nil()
+	}
+	ev.Costs.Budgets[valueToString(args[0])] = args[1].Number
+	return args[1]
+}
+
+// (total-cost) or (total-cost actor)
+func builtinTotalCost(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) > 0 {
+		return Num(ev.Costs.ByActor[valueToString(args[0])])
+	}
+	return Num(ev.Costs.Total)
+}
+
+// (cost-report) - ((actor cost) ...) sorted by actor name
+func builtinCostReport(ev *Evaluator, args []Value, env *Env) Value {
+	names := make([]string, 0, len(ev.Costs.ByActor))
+	for name := range ev.Costs.ByActor {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]Value, len(names))
+	for i, name := range names {
+		result[i] = Lst(Sym(name), Num(ev.Costs.ByActor[name]))
+	}
+	return Lst(result...)
+}
+
+// (reset-costs!) - clear accumulated costs, keeping the cost table and budgets
+func builtinResetCosts(ev *Evaluator, args []Value, env *Env) Value {
+	ev.Costs.Total = 0
+	ev.Costs.ByActor = make(map[string]float64)
+	return Sym("ok")
+}
+
+// checkCSPViolation records an effect (op on target: set!, define or
+// assert!) made before the step's first guard (a receive or send) as a
+// violation on the actor and a (csp-violation actor op target) fact.
+// Reports whether the actor is strict, so the effect should be skipped.
+func (ev *Evaluator) checkCSPViolation(op, target string) bool {
+	if ev.Scheduler == nil || !ev.Scheduler.CSPEnforce || ev.Scheduler.CurrentActor == "" {
+		return false
+	}
+	actor := ev.Scheduler.GetActor(ev.Scheduler.CurrentActor)
+	if actor == nil || actor.GuardSeen {
+		return false
+	}
+	if ev.replaying() {
+		// Recorded by the try of the step that blocked
+		return actor.CSPStrict
+	}
+	violation := fmt.Sprintf("CSP violation: %s '%s' before guard in actor '%s'", op, target, actor.Name)
+	actor.CSPViolations = append(actor.CSPViolations, violation)
+	ev.DatalogDB.AssertAtTime("csp-violation", ev.now(),
+		Atom(actor.Name), Atom(op), Atom(target))
+	if ev.Scheduler.Trace {
+		fmt.Fprintln(os.Stderr, "  ⚠ "+violation)
+	}
+	return actor.CSPStrict
+}
+
+func (s *Scheduler) AddActor(name string, mailboxSize int, env *Env, code Value) *Actor {
+	actor := &Actor{
+		Name:    name,
+		Mailbox: NewQueue(mailboxSize),
+		State:   ActorRunnable,
+		Env:     env,
+		Code:    code,
+		Result:  Nil(),
+	}
+	s.Actors[name] = actor
+	s.RunQueue = append(s.RunQueue, name)
+	return actor
 }
 
 func (s *Scheduler) GetActor(name string) *Actor {
 	return s.Actors[name]
 }
 
+// Names returns the actor names in sorted order. Anything that lists,
+// prints or visits every actor goes through it so runs are repeatable.
+func (s *Scheduler) Names() []string {
+	return sortedKeys(s.Actors)
+}
+
 func (s *Scheduler) BlockActor(name string, reason string) {
 	if actor, ok := s.Actors[name]; ok {
 		actor.State = ActorBlocked
@@ -656,6 +1637,24 @@ func (s *Scheduler) MarkDone(name string) {
 	}
 }
 
+// IsQuiescent reports whether every actor that isn't done is waiting on an
+// empty mailbox with no timer or scripted stimulus still to come: nothing
+// is stuck, there is just nothing left to do.
+func (s *Scheduler) IsQuiescent() bool {
+	if len(s.Timers) > 0 || s.NextStimulus < len(s.Stimuli) {
+		return false
+	}
+	for _, actor := range s.Actors {
+		if actor.State == ActorRunnable {
+			return false
+		}
+		if actor.State == ActorBlocked && (!strings.HasPrefix(actor.BlockedOn, "recv") || !actor.Mailbox.IsEmpty()) {
+			return false
+		}
+	}
+	return true
+}
+
 func (s *Scheduler) IsDeadlocked() bool {
 	// Deadlock if no actors are runnable and at least one is blocked
 	if len(s.RunQueue) > 0 {
@@ -678,7 +1677,27 @@ func (s *Scheduler) AllDone() bool {
 	return len(s.Actors) > 0
 }
 
+// StateName is runnable, blocked or done
+func (a *Actor) StateName() string {
+	switch a.State {
+	case ActorRunnable:
+		return "runnable"
+	case ActorBlocked:
+		return "blocked"
+	case ActorDone:
+		return "done"
+	}
+	return "unknown"
+}
+
 func (s *Scheduler) NextActor() *Actor {
+	if len(s.Script) > 0 {
+		if actor := s.Pick(s.Script[0]); actor != nil {
+			return actor
+		}
+		s.Diverged = true // The recorded actor can't run here
+		s.Script = nil
+	}
 	if len(s.RunQueue) == 0 {
 		return nil
 	}
@@ -689,10 +1708,40 @@ func (s *Scheduler) NextActor() *Actor {
 	return s.Actors[name]
 }
 
+// Pick schedules a specific runnable actor instead of the next in turn,
+// moving it to the back of the run queue. Returns nil if it isn't runnable.
+func (s *Scheduler) Pick(name string) *Actor {
+	s.followScript(name)
+	for i, n := range s.RunQueue {
+		if n == name {
+			s.RunQueue = append(append(s.RunQueue[:i:i], s.RunQueue[i+1:]...), name)
+			s.CurrentActor = name
+			return s.Actors[name]
+		}
+	}
+	return nil
+}
+
+// followScript consumes the replay script's next entry, which should be the
+// runnable actor being scheduled. Once the run strays from the script it
+// stops steering and the scheduler goes back to round-robin.
+func (s *Scheduler) followScript(name string) {
+	if len(s.Script) == 0 || s.Actors[name] == nil || s.Actors[name].State != ActorRunnable {
+		return
+	}
+	if s.Script[0] != name {
+		s.Diverged = true
+		s.Script = nil
+		return
+	}
+	s.Script = s.Script[1:]
+}
+
 func (s *Scheduler) Status() string {
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("Step %d:\n", s.StepCount))
-	for name, actor := range s.Actors {
+	for _, name := range s.Names() {
+		actor := s.Actors[name]
 		state := "runnable"
 		extra := ""
 		switch actor.State {
@@ -717,7 +1766,19 @@ func NewEvaluator(callStackDepth int) *Evaluator {
 		Scheduler:   NewScheduler(),
 		DatalogDB:   NewDatalogDB(),
 		SeenErrors:  make(map[string]bool),
-	}
+		Events:      NewEventBus(),
+		Grants:      make(map[string][]Capability),
+		Modules:     make(map[string]*Module),
+		Costs:       NewCostTracker(),
+		Features:    make(map[string]bool),
+		LoadPath:    defaultLoadPath(),
+	}
+	ev.SetSeed(time.Now().UnixNano())
+	ev.DatalogDB.OnAssert = func(f Fact) {
+		ev.emit(SchedEvent{Kind: EventFactAsserted, Fact: &f})
+	}
+	ev.Events.Subscribe(traceSubscriber)
+	ev.Events.Subscribe(factSubscriber)
 	ev.setupBuiltins()
 	return ev
 }
@@ -731,6 +1792,7 @@ func (ev *Evaluator) setupBuiltins() {
 	env.Set("*", Value{Type: TypeBuiltin, Builtin: builtinMul})
 	env.Set("/", Value{Type: TypeBuiltin, Builtin: builtinDiv})
 	env.Set("mod", Value{Type: TypeBuiltin, Builtin: builtinMod})
+	env.Set("float", Value{Type: TypeBuiltin, Builtin: builtinFloat})
 
 	// Math functions
 	env.Set("ln", Value{Type: TypeBuiltin, Builtin: builtinLn})
@@ -748,14 +1810,20 @@ func (ev *Evaluator) setupBuiltins() {
 	env.Set("rand", Value{Type: TypeBuiltin, Builtin: builtinRand})
 	env.Set("random", Value{Type: TypeBuiltin, Builtin: builtinRand}) // alias
 
+	// Wall-clock time
+	env.Set("now", Value{Type: TypeBuiltin, Builtin: builtinNow})
+	env.Set("now-millis", Value{Type: TypeBuiltin, Builtin: builtinNowMillis})
+	env.Set("format-time", Value{Type: TypeBuiltin, Builtin: builtinFormatTime})
+
 	// String functions
 	env.Set("concat", Value{Type: TypeBuiltin, Builtin: builtinConcat})
 	env.Set("str", Value{Type: TypeBuiltin, Builtin: builtinStr})
 
 	// Comparison
-	env.Set("=", Value{Type: TypeBuiltin, Builtin: builtinEq})
-	env.Set("eq?", Value{Type: TypeBuiltin, Builtin: builtinEq})     // alias
-	env.Set("equals", Value{Type: TypeBuiltin, Builtin: builtinEq})  // alias
+	env.Set("=", Value{Type: TypeBuiltin, Builtin: builtinNumEq})
+	env.Set("eq?", Value{Type: TypeBuiltin, Builtin: builtinEq})
+	env.Set("equal?", Value{Type: TypeBuiltin, Builtin: builtinEqual})
+	env.Set("equals", Value{Type: TypeBuiltin, Builtin: builtinEqual}) // alias
 	env.Set("!=", Value{Type: TypeBuiltin, Builtin: builtinNeq})
 	env.Set("<", Value{Type: TypeBuiltin, Builtin: builtinLt})
 	env.Set("<=", Value{Type: TypeBuiltin, Builtin: builtinLte})
@@ -779,15 +1847,39 @@ func (ev *Evaluator) setupBuiltins() {
 	env.Set("length", Value{Type: TypeBuiltin, Builtin: builtinLength})
 	env.Set("nth", Value{Type: TypeBuiltin, Builtin: builtinNth})
 
+	// Higher-order list functions
+	env.Set("map", Value{Type: TypeBuiltin, Builtin: builtinMap})
+	env.Set("filter", Value{Type: TypeBuiltin, Builtin: builtinFilter})
+	env.Set("reduce", Value{Type: TypeBuiltin, Builtin: builtinReduce})
+	env.Set("fold-left", Value{Type: TypeBuiltin, Builtin: builtinFoldLeft})
+	env.Set("fold", Value{Type: TypeBuiltin, Builtin: builtinFoldLeft}) // alias
+	env.Set("fold-right", Value{Type: TypeBuiltin, Builtin: builtinFoldRight})
+	env.Set("for-each", Value{Type: TypeBuiltin, Builtin: builtinForEach})
+	env.Set("apply", Value{Type: TypeBuiltin, Builtin: builtinApply})
+	env.Set("partial", Value{Type: TypeBuiltin, Builtin: builtinPartial})
+	env.Set("curry", Value{Type: TypeBuiltin, Builtin: builtinCurry})
+	env.Set("memoize", Value{Type: TypeBuiltin, Builtin: builtinMemoize})
+	env.Set("sort", Value{Type: TypeBuiltin, Builtin: builtinSort})
+	env.Set("sort-by", Value{Type: TypeBuiltin, Builtin: builtinSortBy})
+
 	// Type checks
 	env.Set("list?", Value{Type: TypeBuiltin, Builtin: builtinIsList})
 	env.Set("number?", Value{Type: TypeBuiltin, Builtin: builtinIsNumber})
+	env.Set("integer?", Value{Type: TypeBuiltin, Builtin: builtinIsInteger})
+	env.Set("float?", Value{Type: TypeBuiltin, Builtin: builtinIsFloat})
 	env.Set("symbol?", Value{Type: TypeBuiltin, Builtin: builtinIsSymbol})
 	env.Set("string?", Value{Type: TypeBuiltin, Builtin: builtinIsString})
+	env.Set("char?", Value{Type: TypeBuiltin, Builtin: builtinIsChar})
 	env.Set("nil?", Value{Type: TypeBuiltin, Builtin: builtinIsNil})
 
 	// Evaluation
 	env.Set("eval", Value{Type: TypeBuiltin, Builtin: builtinEval})
+	env.Set("set-call-stack-depth!", Value{Type: TypeBuiltin, Builtin: builtinSetCallStackDepth})
+
+	// Features (see when-feature)
+	env.Set("feature?", Value{Type: TypeBuiltin, Builtin: builtinFeatureP})
+	env.Set("features", Value{Type: TypeBuiltin, Builtin: builtinFeatures})
+	env.Set("enable-feature!", Value{Type: TypeBuiltin, Builtin: builtinEnableFeature})
 
 	// Bounded structures
 	env.Set("make-stack", Value{Type: TypeBuiltin, Builtin: builtinMakeStack})
@@ -819,12 +1911,55 @@ func (ev *Evaluator) setupBuiltins() {
 	env.Set("print", Value{Type: TypeBuiltin, Builtin: builtinPrint})
 	env.Set("println", Value{Type: TypeBuiltin, Builtin: builtinPrintln})
 	env.Set("repr", Value{Type: TypeBuiltin, Builtin: builtinRepr})
+	env.Set("pp", Value{Type: TypeBuiltin, Builtin: builtinPP})
+
+	// Debugging
+	env.Set("break", Value{Type: TypeBuiltin, Builtin: builtinBreak})
+
+	// Documentation
+	env.Set("doc", Value{Type: TypeBuiltin, Builtin: builtinDoc})
+	env.Set("apropos", Value{Type: TypeBuiltin, Builtin: builtinApropos})
+
+	// Serialization
+	env.Set("write-value", Value{Type: TypeBuiltin, Builtin: builtinWriteValue})
+	env.Set("read-value", Value{Type: TypeBuiltin, Builtin: builtinReadValue})
+	env.Set("read", Value{Type: TypeBuiltin, Builtin: builtinRead})
+
+	// JSON
+	env.Set("json-parse", Value{Type: TypeBuiltin, Builtin: builtinJSONParse})
+	env.Set("json-stringify", Value{Type: TypeBuiltin, Builtin: builtinJSONStringify})
+
+	// File I/O (only with FileAccess)
+	env.Set("read-file", Value{Type: TypeBuiltin, Builtin: builtinReadFile})
+	env.Set("write-file", Value{Type: TypeBuiltin, Builtin: builtinWriteFile})
+	env.Set("append-file", Value{Type: TypeBuiltin, Builtin: builtinAppendFile})
+	env.Set("file-exists?", Value{Type: TypeBuiltin, Builtin: builtinFileExists})
+	env.Set("load", Value{Type: TypeBuiltin, Builtin: builtinLoad})
+	env.Set("add-load-path!", Value{Type: TypeBuiltin, Builtin: builtinAddLoadPath})
+	env.Set("load-path", Value{Type: TypeBuiltin, Builtin: builtinLoadPath})
 
 	// String operations
 	env.Set("string-append", Value{Type: TypeBuiltin, Builtin: builtinStringAppend})
 	env.Set("symbol->string", Value{Type: TypeBuiltin, Builtin: builtinSymbolToString})
 	env.Set("string->symbol", Value{Type: TypeBuiltin, Builtin: builtinStringToSymbol})
 	env.Set("number->string", Value{Type: TypeBuiltin, Builtin: builtinNumberToString})
+	env.Set("string->number", Value{Type: TypeBuiltin, Builtin: builtinStringToNumber})
+	env.Set("substring", Value{Type: TypeBuiltin, Builtin: builtinSubstring})
+	env.Set("string-split", Value{Type: TypeBuiltin, Builtin: builtinStringSplit})
+	env.Set("string-join", Value{Type: TypeBuiltin, Builtin: builtinStringJoin})
+	env.Set("string-replace", Value{Type: TypeBuiltin, Builtin: builtinStringReplace})
+	env.Set("string-upcase", Value{Type: TypeBuiltin, Builtin: builtinStringUpcase})
+	env.Set("string-downcase", Value{Type: TypeBuiltin, Builtin: builtinStringDowncase})
+	env.Set("string-contains?", Value{Type: TypeBuiltin, Builtin: builtinStringContains})
+	env.Set("string-length", Value{Type: TypeBuiltin, Builtin: builtinStringLength})
+	env.Set("string-index", Value{Type: TypeBuiltin, Builtin: builtinStringIndex})
+	env.Set("string->list", Value{Type: TypeBuiltin, Builtin: builtinStringToList})
+	env.Set("list->string", Value{Type: TypeBuiltin, Builtin: builtinListToString})
+	env.Set("char->number", Value{Type: TypeBuiltin, Builtin: builtinCharToNumber})
+	env.Set("number->char", Value{Type: TypeBuiltin, Builtin: builtinNumberToChar})
+	env.Set("regex-match", Value{Type: TypeBuiltin, Builtin: builtinRegexMatch})
+	env.Set("regex-find-all", Value{Type: TypeBuiltin, Builtin: builtinRegexFindAll})
+	env.Set("regex-replace", Value{Type: TypeBuiltin, Builtin: builtinRegexReplace})
 
 	// Registry
 	env.Set("registry-set!", Value{Type: TypeBuiltin, Builtin: builtinRegistrySet})
@@ -833,6 +1968,34 @@ func (ev *Evaluator) setupBuiltins() {
 	env.Set("registry-has?", Value{Type: TypeBuiltin, Builtin: builtinRegistryHas})
 	env.Set("registry-delete!", Value{Type: TypeBuiltin, Builtin: builtinRegistryDelete})
 
+	// Hash maps
+	env.Set("make-map", Value{Type: TypeBuiltin, Builtin: builtinMakeMap})
+	env.Set("map-get", Value{Type: TypeBuiltin, Builtin: builtinMapGet})
+	env.Set("map-set!", Value{Type: TypeBuiltin, Builtin: builtinMapSet})
+	env.Set("map-keys", Value{Type: TypeBuiltin, Builtin: builtinMapKeys})
+	env.Set("map-has?", Value{Type: TypeBuiltin, Builtin: builtinMapHas})
+	env.Set("map-delete!", Value{Type: TypeBuiltin, Builtin: builtinMapDelete})
+	env.Set("map?", Value{Type: TypeBuiltin, Builtin: builtinIsMap})
+
+	// Association lists: ((key value) ...), as query returns bindings
+	env.Set("assoc", Value{Type: TypeBuiltin, Builtin: builtinAssoc})
+	env.Set("assoc-set", Value{Type: TypeBuiltin, Builtin: builtinAssocSet})
+	env.Set("alist->map", Value{Type: TypeBuiltin, Builtin: builtinAlistToMap})
+	env.Set("map->alist", Value{Type: TypeBuiltin, Builtin: builtinMapToAlist})
+
+	// Sets
+	env.Set("make-set", Value{Type: TypeBuiltin, Builtin: builtinMakeSet})
+	env.Set("list->set", Value{Type: TypeBuiltin, Builtin: builtinListToSet})
+	env.Set("set-add!", Value{Type: TypeBuiltin, Builtin: builtinSetAdd})
+	env.Set("set-remove!", Value{Type: TypeBuiltin, Builtin: builtinSetRemove})
+	env.Set("set-member?", Value{Type: TypeBuiltin, Builtin: builtinSetMember})
+	env.Set("set-size", Value{Type: TypeBuiltin, Builtin: builtinSetSize})
+	env.Set("set-union", Value{Type: TypeBuiltin, Builtin: builtinSetUnion})
+	env.Set("set-intersect", Value{Type: TypeBuiltin, Builtin: builtinSetIntersect})
+	env.Set("set-difference", Value{Type: TypeBuiltin, Builtin: builtinSetDifference})
+	env.Set("set->list", Value{Type: TypeBuiltin, Builtin: builtinSetToList})
+	env.Set("set?", Value{Type: TypeBuiltin, Builtin: builtinIsSet})
+
 	// Type tagging
 	env.Set("tag", Value{Type: TypeBuiltin, Builtin: builtinTag})
 	env.Set("tag-type", Value{Type: TypeBuiltin, Builtin: builtinTagType})
@@ -845,25 +2008,101 @@ func (ev *Evaluator) setupBuiltins() {
 
 	// Scheduler and actor management
 	env.Set("spawn-actor", Value{Type: TypeBuiltin, Builtin: builtinSpawnActor})
+	env.Set("spawn", Value{Type: TypeBuiltin, Builtin: builtinSpawn})
 	env.Set("self", Value{Type: TypeBuiltin, Builtin: builtinSelf})
+	env.Set("become", Value{Type: TypeBuiltin, Builtin: builtinBecome})
 	env.Set("send-to!", Value{Type: TypeBuiltin, Builtin: builtinSendTo})
 	env.Set("receive!", Value{Type: TypeBuiltin, Builtin: builtinReceive})
 	env.Set("receive-now!", Value{Type: TypeBuiltin, Builtin: builtinReceiveNow})
 	env.Set("mailbox-empty?", Value{Type: TypeBuiltin, Builtin: builtinMailboxEmpty})
 	env.Set("mailbox-full?", Value{Type: TypeBuiltin, Builtin: builtinMailboxFull})
+	env.Set("mailbox-bytes", Value{Type: TypeBuiltin, Builtin: builtinMailboxBytes})
+	env.Set("on-schedule-event!", Value{Type: TypeBuiltin, Builtin: builtinOnScheduleEvent})
+	env.Set("remove-schedule-hook!", Value{Type: TypeBuiltin, Builtin: builtinRemoveScheduleHook})
+	env.Set("subscribe!", Value{Type: TypeBuiltin, Builtin: builtinSubscribe})
+	env.Set("unsubscribe!", Value{Type: TypeBuiltin, Builtin: builtinUnsubscribe})
+	env.Set("link!", Value{Type: TypeBuiltin, Builtin: builtinLink})
+	env.Set("monitor!", Value{Type: TypeBuiltin, Builtin: builtinMonitor})
+	env.Set("kill-actor!", Value{Type: TypeBuiltin, Builtin: builtinKillActor})
+	env.Set("publish!", Value{Type: TypeBuiltin, Builtin: builtinPublish})
+	env.Set("broadcast!", Value{Type: TypeBuiltin, Builtin: builtinBroadcast})
+	env.Set("message-size", Value{Type: TypeBuiltin, Builtin: builtinMessageSize})
 	env.Set("yield!", Value{Type: TypeBuiltin, Builtin: builtinYield})
 	env.Set("done!", Value{Type: TypeBuiltin, Builtin: builtinDone})
+	env.Set("send-after!", Value{Type: TypeBuiltin, Builtin: builtinSendAfter})
+	env.Set("receive-timeout!", Value{Type: TypeBuiltin, Builtin: builtinReceiveTimeout})
+	env.Set("pending-timers", Value{Type: TypeBuiltin, Builtin: builtinPendingTimers})
 	env.Set("run-scheduler", Value{Type: TypeBuiltin, Builtin: builtinRunScheduler})
+	env.Set("run-config", Value{Type: TypeBuiltin, Builtin: builtinRunConfig})
+	env.Set("scheduler-step!", Value{Type: TypeBuiltin, Builtin: builtinSchedulerStep})
+	env.Set("runnable-actors", Value{Type: TypeBuiltin, Builtin: builtinRunnableActors})
 	env.Set("scheduler-status", Value{Type: TypeBuiltin, Builtin: builtinSchedulerStatus})
+	env.Set("print-scheduler-status", Value{Type: TypeBuiltin, Builtin: builtinPrintSchedulerStatus})
 	env.Set("set-trace!", Value{Type: TypeBuiltin, Builtin: builtinSetTrace})
+	env.Set("set-quiescence!", Value{Type: TypeBuiltin, Builtin: builtinSetQuiescence})
+	env.Set("quiescent?", Value{Type: TypeBuiltin, Builtin: builtinQuiescent})
+	env.Set("actor-progress", Value{Type: TypeBuiltin, Builtin: builtinActorProgress})
+	env.Set("lamport-time", Value{Type: TypeBuiltin, Builtin: builtinLamportTime})
+	env.Set("vector-clocks!", Value{Type: TypeBuiltin, Builtin: builtinVectorClocks})
+	env.Set("vector-time", Value{Type: TypeBuiltin, Builtin: builtinVectorTime})
+	env.Set("set-actor-budget!", Value{Type: TypeBuiltin, Builtin: builtinSetActorBudget})
+	env.Set("fairness-report", Value{Type: TypeBuiltin, Builtin: builtinFairnessReport})
+	env.Set("advance-time!", Value{Type: TypeBuiltin, Builtin: builtinAdvanceTime})
+	env.Set("virtual-time", Value{Type: TypeBuiltin, Builtin: builtinVirtualTime})
+	env.Set("ask!", Value{Type: TypeBuiltin, Builtin: builtinAsk})
+	env.Set("reply!", Value{Type: TypeBuiltin, Builtin: builtinReply})
+	env.Set("make-promise", Value{Type: TypeBuiltin, Builtin: builtinMakePromise})
+	env.Set("deliver!", Value{Type: TypeBuiltin, Builtin: builtinDeliver})
+	env.Set("await", Value{Type: TypeBuiltin, Builtin: builtinAwait})
+	env.Set("promise-ready?", Value{Type: TypeBuiltin, Builtin: builtinPromiseReady})
+	env.Set("make-scheduler", Value{Type: TypeBuiltin, Builtin: builtinMakeScheduler})
+	env.Set("current-scheduler", Value{Type: TypeBuiltin, Builtin: builtinCurrentScheduler})
+	env.Set("auto-trace!", Value{Type: TypeBuiltin, Builtin: builtinAutoTrace})
+	env.Set("inject-faults!", Value{Type: TypeBuiltin, Builtin: builtinInjectFaults})
+	env.Set("replay-trace", Value{Type: TypeBuiltin, Builtin: builtinReplayTrace})
+	env.Set("set-scheduler-policy!", Value{Type: TypeBuiltin, Builtin: builtinSetSchedulerPolicy})
+	env.Set("scheduler-policy", Value{Type: TypeBuiltin, Builtin: builtinSchedulerPolicy})
+	env.Set("set-priority!", Value{Type: TypeBuiltin, Builtin: builtinSetPriority})
 	env.Set("actor-state", Value{Type: TypeBuiltin, Builtin: builtinActorState})
+	env.Set("whereis", Value{Type: TypeBuiltin, Builtin: builtinWhereis})
+	env.Set("actor-exists?", Value{Type: TypeBuiltin, Builtin: builtinActorExists})
+	env.Set("actor-mailbox-size", Value{Type: TypeBuiltin, Builtin: builtinActorMailboxSize})
+	env.Set("actor-result", Value{Type: TypeBuiltin, Builtin: builtinActorResult})
 	env.Set("list-actors-sched", Value{Type: TypeBuiltin, Builtin: builtinListActorsSched})
 	env.Set("reset-scheduler", Value{Type: TypeBuiltin, Builtin: builtinResetScheduler})
 
+	// Scenario search
+	env.Set("find-trace-satisfying", Value{Type: TypeBuiltin, Builtin: builtinFindTraceSatisfying})
+	env.Set("run-scenario", Value{Type: TypeBuiltin, Builtin: builtinRunScenario})
+	env.Set("snapshot-world", Value{Type: TypeBuiltin, Builtin: builtinSnapshotWorld})
+	env.Set("restore-world!", Value{Type: TypeBuiltin, Builtin: builtinRestoreWorld})
+	env.Set("schedule-stimuli!", Value{Type: TypeBuiltin, Builtin: builtinScheduleStimuli})
+	env.Set("pending-stimuli", Value{Type: TypeBuiltin, Builtin: builtinPendingStimuli})
+	env.Set("equivalent?", Value{Type: TypeBuiltin, Builtin: builtinEquivalent})
+	env.Set("equivalence-counterexample", Value{Type: TypeBuiltin, Builtin: builtinEquivalenceCounterexample})
+
+	// Regression tests from recorded runs
+	env.Set("deftest", Value{Type: TypeBuiltin, Builtin: builtinDeftest})
+
+	// Capabilities
+	env.Set("cap", Value{Type: TypeBuiltin, Builtin: builtinCap})
+	env.Set("grant", Value{Type: TypeBuiltin, Builtin: builtinGrant})
+	env.Set("revoke!", Value{Type: TypeBuiltin, Builtin: builtinRevoke})
+	env.Set("capabilities", Value{Type: TypeBuiltin, Builtin: builtinCapabilities})
+	env.Set("capability-mode!", Value{Type: TypeBuiltin, Builtin: builtinCapabilityMode})
+
+	// Costs
+	env.Set("set-cost!", Value{Type: TypeBuiltin, Builtin: builtinSetCost})
+	env.Set("set-budget!", Value{Type: TypeBuiltin, Builtin: builtinSetBudget})
+	env.Set("total-cost", Value{Type: TypeBuiltin, Builtin: builtinTotalCost})
+	env.Set("cost-report", Value{Type: TypeBuiltin, Builtin: builtinCostReport})
+	env.Set("reset-costs!", Value{Type: TypeBuiltin, Builtin: builtinResetCosts})
+
 	// CSP enforcement builtins
 	env.Set("csp-enforce!", Value{Type: TypeBuiltin, Builtin: func(ev *Evaluator, args []Value, env *Env) Value {
 		if len(args) > 0 {
 			ev.Scheduler.CSPEnforce = args[0].IsTruthy()
+			ev.Scheduler.CSPAbort = args[0].IsSymbol() && args[0].Symbol == "abort"
 		}
 		return Bool(ev.Scheduler.CSPEnforce)
 	}})
@@ -901,8 +2140,8 @@ func (ev *Evaluator) setupBuiltins() {
 			}
 		} else {
 			// Get all violations
-			for actorName, actor := range ev.Scheduler.Actors {
-				for _, v := range actor.CSPViolations {
+			for _, actorName := range ev.Scheduler.Names() {
+				for _, v := range ev.Scheduler.Actors[actorName].CSPViolations {
 					result = append(result, Lst(Sym(actorName), Str(v)))
 				}
 			}
@@ -930,70 +2169,257 @@ func (ev *Evaluator) setupBuiltins() {
 
 	// Register Datalog builtins
 	RegisterDatalogBuiltins(ev)
+
+	// Argument checking (see argcheck.go)
+	env.Set("error?", Value{Type: TypeBuiltin, Builtin: builtinIsError})
+	ev.nameBuiltins()
 }
 
 func (ev *Evaluator) Eval(expr Value, env *Env) Value {
+	if ev.Fuel > 0 && ev.fuelEnd == 0 {
+		// A top-level evaluation: the fuel is for it and everything it evaluates
+		ev.fuelEnd = ev.EvalCount + ev.Fuel
+		defer func() { ev.fuelEnd = 0 }()
+	}
+	return ev.eval(expr, env, false)
+}
+
+// tailExpr asks the trampoline to continue with expr in env instead of
+// recursing, so if/cond/begin/let bodies don't grow the Go stack.
+func tailExpr(expr Value, env *Env) Value {
+	return Value{Type: TypeTailCall, Tail: &TailCall{Expr: expr, Env: env}}
+}
+
+// letBindings splits the bindings of a Scheme-style (let ((x 1) (y 2)) ...)
+// into names and init expressions. A binding of just a name, bare or as
+// (x), is bound to nil.
+func letBindings(bindings Value) (names, inits []Value) {
+	for _, b := range bindings.List {
+		switch {
+		case b.IsSymbol():
+			names, inits = append(names, b), append(inits, Nil())
+		case b.IsList() && len(b.List) > 0 && b.List[0].IsSymbol():
+			init := Nil()
+			if len(b.List) > 1 {
+				init = b.List[1]
+			}
+			names, inits = append(names, b.List[0]), append(inits, init)
+		}
+	}
+	return names, inits
+}
+
+// bodyExpr is a body of several expressions as one, wrapped in begin
+func bodyExpr(exprs []Value) Value {
+	if len(exprs) == 1 {
+		return exprs[0]
+	}
+	return Lst(append([]Value{Sym("begin")}, exprs...)...)
+}
+
+// loopFunction is the function a loop form calls: its names are the
+// parameters, and recur is bound to the function itself, so a recur in
+// tail position is a tail call the trampoline runs without growing either
+// stack. compiled is the body already compiled, or nil.
+func loopFunction(names []Value, body Value, env *Env, compiled *code) Value {
+	recurEnv := NewFrame(env, 1)
+	f := newFunction(names, body, recurEnv)
+	f.Name = "loop"
+	f.compiled = compiled
+	fn := Value{Type: TypeFunc, Func: f}
+	recurEnv.setSym(InternedSym("recur"), fn)
+	return fn
+}
+
+// dotimesCount checks a dotimes count: a blocked count is passed on, and
+// anything but a number is warned about and returns nil
+func (ev *Evaluator) dotimesCount(n Value) (Value, bool) {
+	switch {
+	case n.Type == TypeBlocked:
+		return n, false
+	case n.Type != TypeNumber:
+		ev.warnTrace("", "dotimes: expected a count, got %s", n.String())
+		return Nil(), false
+	case !n.IsInt:
+		return Int(int64(math.Ceil(n.Number))), true
+	}
+	return n, true
+}
+
+// caseKeys reads the values a case clause matches: a list of literals,
+// or one on its own. Literals aren't evaluated, but 'delivery is read as
+// delivery so quoting them does no harm.
+func caseKeys(datums Value) (keys []Value, isElse bool) {
+	unquote := func(v Value) Value {
+		if v.IsList() && len(v.List) == 2 && v.List[0].IsSymbol() && v.List[0].Symbol == "quote" {
+			return v.List[1]
+		}
+		return v
+	}
+	switch {
+	case datums.IsSymbol() && datums.Symbol == "else":
+		return nil, true
+	case datums.IsList() && len(datums.List) == 2 && datums.List[0].IsSymbol() && datums.List[0].Symbol == "quote":
+		return []Value{datums.List[1]}, false
+	case datums.IsList():
+		for _, d := range datums.List {
+			keys = append(keys, unquote(d))
+		}
+		return keys, false
+	}
+	return []Value{datums}, false
+}
+
+// caseMatches reports whether key is equal? to one of keys
+func caseMatches(keys []Value, key Value) bool {
+	for _, k := range keys {
+		if valuesEqual(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// destructureNames appends the names pattern binds to names, in the order
+// destructure binds them. _ binds nothing.
+func destructureNames(pattern Value, names []Value) []Value {
+	switch {
+	case pattern.IsSymbol() && pattern.Symbol != "_" && pattern.Symbol != ".":
+		return append(names, pattern)
+	case pattern.IsList():
+		for _, p := range pattern.List {
+			names = destructureNames(p, names)
+		}
+	}
+	return names
+}
+
+// destructure binds pattern's names in frame to the matching parts of v.
+// A list pattern takes items by position, missing ones are nil, and
+// (a . rest) binds rest to what is left. It warns and returns false if a
+// list pattern meets something other than a list.
+func (ev *Evaluator) destructure(pattern, v Value, frame *Env) bool {
+	switch {
+	case pattern.IsSymbol():
+		if pattern.Symbol != "_" {
+			frame.setSym(pattern, v)
+		}
+		return true
+	case !pattern.IsList():
+		return true
+	case v.Type != TypeList && v.Type != TypeNil:
+		ev.warnTrace("destructure:type", "destructure: expected a list for %s, got %s", pattern.String(), v.String())
+		return false
+	}
+	items := v.List
+	for i, p := range pattern.List {
+		if p.IsSymbol() && p.Symbol == "." {
+			if i+1 < len(pattern.List) {
+				rest := Lst()
+				if i < len(items) {
+					rest = Lst(items[i:]...)
+				}
+				return ev.destructure(pattern.List[i+1], rest, frame)
+			}
+			return true
+		}
+		item := Nil()
+		if i < len(items) {
+			item = items[i]
+		}
+		if !ev.destructure(p, item, frame) {
+			return false
+		}
+	}
+	return true
+}
+
+// eval runs the trampoline. inBody is true while evaluating a function
+// body; calls in tail position then reuse the current call-stack frame
+// instead of pushing a new one, so (tail f ...) is only needed for clarity.
+func (ev *Evaluator) eval(expr Value, env *Env, inBody bool) Value {
+	return ev.run(expr, nil, env, inBody)
+}
+
+// run is the trampoline. c is expr compiled, or nil to interpret it;
+// function bodies are compiled when called (see compile.go).
+func (ev *Evaluator) run(expr Value, c *code, env *Env, inBody bool) Value {
 	if env == nil {
 		env = ev.GlobalEnv
 	}
 
+	top := ev.pushExpr(expr)
+
 	// Trampoline loop for tail calls
 	for {
-		result := ev.evalStep(expr, env)
-
-		if result.Type == TypeTailCall {
-			tc := result.Tail
-			if tc.Func.Type == TypeFunc {
-				fn := tc.Func.Func
-				env = NewEnv(fn.Env)
-				
-				// Bind regular parameters
-				for i, param := range fn.Params {
-					if i < len(tc.Args) {
-						env.Set(param, tc.Args[i])
-					} else {
-						env.Set(param, Nil())
-					}
-				}
-				
-				// Bind rest parameter if present
-				if fn.RestParam != "" {
-					restArgs := make([]Value, 0)
-					if len(tc.Args) > len(fn.Params) {
-						restArgs = tc.Args[len(fn.Params):]
-					}
-					env.Set(fn.RestParam, Lst(restArgs...))
-				}
-				
-				expr = fn.Body
-			} else {
-				// Not a function, just call normally
-				args := make([]Value, len(tc.Args))
-				for i, arg := range tc.Args {
-					args[i] = ev.Eval(arg, env)
-				}
-				return ev.apply(tc.Func, args, env)
-			}
+		ev.countEval()
+		if ev.fuelSpent() {
+			result := ev.outOfFuel()
+			ev.Exprs = ev.Exprs[:top]
+			return result
+		}
+		if ev.Debugger != nil {
+			ev.Debugger.beforeEval(ev, expr, env)
+		}
+		var result Value
+		if c != nil {
+			result = c.step(ev, env, inBody)
 		} else {
+			result = ev.evalStep(expr, env, inBody)
+		}
+
+		if result.Type != TypeTailCall {
+			ev.Exprs = ev.Exprs[:top]
+			return result
+		}
+		tc := result.Tail
+		if tc.Env != nil {
+			// Tail expression of a special form
+			expr, c, env = tc.Expr, tc.Code, tc.Env
+			ev.Exprs[top].Expr = expr
+			continue
+		}
+		if tc.Func.Type != TypeFunc {
+			result = ev.apply(tc.Func, tc.Args, env)
+			ev.Exprs = ev.Exprs[:top]
 			return result
 		}
+		if ev.Profile != nil {
+			ev.countCall(tc.Func)
+		}
+
+		fn := tc.Func.Func
+		env = ev.bindParams(fn, tc.Args)
+		// The tail call reuses the caller's frame
+		if n := len(ev.CallStack.Data); n > 0 {
+			ev.CallStack.Data[n-1] = callFrame(fn, tc.Args)
+		}
+		
+		expr, c = fn.Body, ev.compiledBody(fn)
+		inBody = true
+		ev.Exprs[top].Expr = expr
 	}
 }
 
-func (ev *Evaluator) evalStep(expr Value, env *Env) Value {
+func (ev *Evaluator) evalStep(expr Value, env *Env, inBody bool) Value {
 	switch expr.Type {
-	case TypeNil, TypeNumber, TypeString, TypeBool, TypeFunc, TypeBuiltin, TypeStack, TypeQueue:
+	case TypeNil, TypeNumber, TypeString, TypeBool, TypeChar, TypeFunc, TypeBuiltin, TypeStack, TypeQueue, TypeMap, TypeSet:
 		return expr
 
 	case TypeSymbol:
-		if v, ok := env.Get(expr.Symbol); ok {
+		if ev.SymbolRefs != nil {
+			ev.SymbolRefs[expr.Symbol] = true
+		}
+		if v, ok := env.Lookup(expr); ok {
+			return v
+		}
+		if v, ok := ev.lookupQualified(expr.Symbol); ok {
 			return v
 		}
-		errKey := "undefined:" + expr.Symbol
-		if !ev.SeenErrors[errKey] {
-			ev.SeenErrors[errKey] = true
-			fmt.Fprintf(os.Stderr, "Undefined symbol: %s\n", expr.Symbol)
+		if strings.HasPrefix(expr.Symbol, ":") && len(expr.Symbol) > 1 {
+			return expr // :keyword evaluates to itself
 		}
+		ev.warnTrace("undefined:"+expr.Symbol, "Undefined symbol: %s", expr.Symbol)
 		return Nil()
 
 	case TypeList:
@@ -1012,15 +2438,25 @@ func (ev *Evaluator) evalStep(expr Value, env *Env) Value {
 				}
 				return Nil()
 
+			case "quasiquote": // Quasiquote - template with unquoted holes
+				if len(expr.List) > 1 {
+					return ev.quasiquote(expr.List[1], env, 1)
+				}
+				return Nil()
+
+			case "unquote", "unquote-splicing":
+				ev.warn("unquote-outside", "%s: only allowed inside a quasiquote", head.Symbol)
+				return Nil()
+
 			case "if":
 				if len(expr.List) < 3 {
 					return Nil()
 				}
 				cond := ev.Eval(expr.List[1], env)
 				if cond.IsTruthy() {
-					return ev.Eval(expr.List[2], env)
+					return tailExpr(expr.List[2], env)
 				} else if len(expr.List) > 3 {
-					return ev.Eval(expr.List[3], env)
+					return tailExpr(expr.List[3], env)
 				}
 				return Nil()
 
@@ -1032,61 +2468,123 @@ func (ev *Evaluator) evalStep(expr Value, env *Env) Value {
 					}
 					test := clause.List[0]
 					if test.IsSymbol() && test.Symbol == "else" {
-						return ev.Eval(clause.List[1], env)
+						return tailExpr(clause.List[1], env)
 					}
 					if ev.Eval(test, env).IsTruthy() {
-						return ev.Eval(clause.List[1], env)
+						return tailExpr(clause.List[1], env)
 					}
 				}
 				return Nil()
 
-			case "let":
-				if len(expr.List) < 3 {
+			case "when", "unless":
+				// (when test body ...) - body if test is truthy, else nil;
+				// unless the other way round
+				if len(expr.List) < 2 {
 					return Nil()
 				}
-				name := expr.List[1]
-				val := ev.Eval(expr.List[2], env)
-				// Propagate blocked status
-				if val.Type == TypeBlocked {
-					return val
+				test := ev.Eval(expr.List[1], env)
+				if test.Type == TypeBlocked {
+					return test
 				}
-				newEnv := NewEnv(env)
-				newEnv.Set(name.Symbol, val)
-				if len(expr.List) == 4 {
-					// Single body expression
-					return ev.Eval(expr.List[3], newEnv)
-				} else if len(expr.List) > 4 {
-					// Multiple body expressions - wrap in begin
-					bodyExprs := make([]Value, len(expr.List)-3+1)
-					bodyExprs[0] = Sym("begin")
-					copy(bodyExprs[1:], expr.List[3:])
-					return ev.Eval(Lst(bodyExprs...), newEnv)
+				if test.IsTruthy() != (head.Symbol == "when") || len(expr.List) < 3 {
+					return Nil()
 				}
-				return val
+				return tailExpr(bodyExpr(expr.List[2:]), env)
 
-			case "let*":
+			case "case":
+				// (case expr ((k1 k2) body ...) ... (else body ...))
+				if len(expr.List) < 2 {
+					return Nil()
+				}
+				key := ev.Eval(expr.List[1], env)
+				if key.Type == TypeBlocked {
+					return key
+				}
+				for _, clause := range expr.List[2:] {
+					if !clause.IsList() || len(clause.List) < 2 {
+						continue
+					}
+					if keys, isElse := caseKeys(clause.List[0]); isElse || caseMatches(keys, key) {
+						return tailExpr(bodyExpr(clause.List[1:]), env)
+					}
+				}
+				return Nil()
+
+			case "destructure":
+				// (destructure (a (b c) . rest) expr body ...)
+				if len(expr.List) < 3 {
+					return Nil()
+				}
+				val := ev.Eval(expr.List[2], env)
+				if val.Type == TypeBlocked {
+					return val
+				}
+				newEnv := NewFrame(env, len(destructureNames(expr.List[1], nil)))
+				if !ev.destructure(expr.List[1], val, newEnv) {
+					return Nil()
+				}
+				return tailExpr(bodyExpr(expr.List[3:]), newEnv)
+
+			case "let":
+				if len(expr.List) < 3 {
+					return Nil()
+				}
+				if expr.List[1].IsList() {
+					// Scheme style: (let ((x 1) (y 2)) body ...), every
+					// init evaluated in the enclosing scope
+					names, inits := letBindings(expr.List[1])
+					newEnv := NewFrame(env, len(names))
+					for i, init := range inits {
+						val := ev.Eval(init, env)
+						if val.Type == TypeBlocked {
+							return val
+						}
+						newEnv.setSym(names[i], val)
+					}
+					return tailExpr(bodyExpr(expr.List[2:]), newEnv)
+				}
+				name := expr.List[1]
+				val := ev.Eval(expr.List[2], env)
+				// Propagate blocked status
+				if val.Type == TypeBlocked {
+					return val
+				}
+				newEnv := NewFrame(env, 1)
+				newEnv.setSym(name, val)
+				if len(expr.List) == 4 {
+					// Single body expression
+					return tailExpr(expr.List[3], newEnv)
+				} else if len(expr.List) > 4 {
+					// Multiple body expressions - wrap in begin
+					bodyExprs := make([]Value, len(expr.List)-3+1)
+					bodyExprs[0] = Sym("begin")
+					copy(bodyExprs[1:], expr.List[3:])
+					return tailExpr(Lst(bodyExprs...), newEnv)
+				}
+				return val
+
+			case "let*":
 				if len(expr.List) < 3 {
 					return Nil()
 				}
 				bindings := expr.List[1]
-				newEnv := NewEnv(env)
+				newEnv := NewFrame(env, len(bindings.List))
 				if bindings.IsList() {
 					for _, binding := range bindings.List {
 						if binding.IsList() && len(binding.List) >= 2 {
-							name := binding.List[0].Symbol
 							val := ev.Eval(binding.List[1], newEnv)
-							newEnv.Set(name, val)
+							newEnv.setSym(binding.List[0], val)
 						}
 					}
 				}
 				if len(expr.List) == 3 {
-					return ev.Eval(expr.List[2], newEnv)
+					return tailExpr(expr.List[2], newEnv)
 				} else {
 					// Multiple body expressions - wrap in begin
 					bodyExprs := make([]Value, len(expr.List)-2+1)
 					bodyExprs[0] = Sym("begin")
 					copy(bodyExprs[1:], expr.List[2:])
-					return ev.Eval(Lst(bodyExprs...), newEnv)
+					return tailExpr(Lst(bodyExprs...), newEnv)
 				}
 
 			case "set!":
@@ -1095,17 +2593,29 @@ func (ev *Evaluator) evalStep(expr Value, env *Env) Value {
 				}
 				name := expr.List[1].Symbol
                 // CSP enforcement: check for violation before guard
-				if ev.checkCSPViolation(name) {
+				if ev.checkCSPViolation("set!", name) {
 					return Nil() // Block in strict mode
 				}
-				val := ev.Eval(expr.List[2], env)
-				// Try to set in existing scope, fall back to global
-				if _, found := env.Get(name); found {
-					env.SetLocal(name, val)
-				} else {
-					ev.GlobalEnv.Set(name, val)
+				assign := func() Value {
+					val := ev.Eval(expr.List[2], env)
+					if val.Type == TypeBlocked {
+						return val
+					}
+					// Try to set in existing scope, fall back to global
+					old, found := env.Get(name)
+					if found {
+						env.SetLocal(name, val)
+					} else {
+						ev.GlobalEnv.Set(name, val)
+					}
+					ev.traceSet(name, old, val)
+					return val
 				}
-				return val
+				if ev.journaling != nil && outlivesStep(name, env) {
+					// Done once, even if the step blocks later and is retried
+					return ev.journalCall("set!", assign, func(Value) bool { return true })
+				}
+				return assign()
 
 			case "define":
 				if len(expr.List) < 3 {
@@ -1116,50 +2626,25 @@ func (ev *Evaluator) evalStep(expr Value, env *Env) Value {
 					// Function shorthand
 					sig := expr.List[1].List
 					name := sig[0].Symbol
-					params := make([]string, 0)
-					restParam := ""
-					sigParams := sig[1:] // Parameters part of signature
-					for i := 0; i < len(sigParams); i++ {
-						p := sigParams[i]
-						if p.IsSymbol() && p.Symbol == "." {
-							// Rest parameter: next symbol is the rest param name
-							if i+1 < len(sigParams) && sigParams[i+1].IsSymbol() {
-								restParam = sigParams[i+1].Symbol
-							}
-							break
-						}
-						if p.IsSymbol() {
-							params = append(params, p.Symbol)
-						}
-					}
-					// Handle multi-expression body: wrap in implicit begin
-					var body Value
-					if len(expr.List) == 3 {
-						body = expr.List[2]
-					} else {
-						// Multiple body expressions - wrap in begin
-						bodyExprs := make([]Value, len(expr.List)-2+1)
-						bodyExprs[0] = Sym("begin")
-						copy(bodyExprs[1:], expr.List[2:])
-						body = Lst(bodyExprs...)
-					}
-					fn := &Function{
-						Params:    params,
-						RestParam: restParam,
-						Body:      body,
-						Env:       env,
-					}
+					// Multiple body expressions are wrapped in an implicit begin
+					body, doc := functionBody(expr.List[2:])
+					fn := newFunction(sig[1:], body, env)
+					fn.Name = name
+					fn.Doc = doc
 					val := Value{Type: TypeFunc, Func: fn}
-					ev.GlobalEnv.Set(name, val)
+					ev.defineEnv().Set(name, val)
 					return val
 				} else {
 					name := expr.List[1].Symbol
                 // CSP enforcement: check for violation before guard
-				if ev.checkCSPViolation(name) {
+				if ev.checkCSPViolation("define", name) {
 					return Nil() // Block in strict mode
 				}
 					val := ev.Eval(expr.List[2], env)
-					ev.GlobalEnv.Set(name, val)
+					if val.Type == TypeFunc && val.Func.Name == "" {
+						val.Func.Name = name
+					}
+					ev.defineEnv().Set(name, val)
 					return val
 				}
 
@@ -1167,43 +2652,72 @@ func (ev *Evaluator) evalStep(expr Value, env *Env) Value {
 				if len(expr.List) < 3 {
 					return Nil()
 				}
-				params := make([]string, 0)
-				restParam := ""
-				if expr.List[1].IsList() {
-					paramList := expr.List[1].List
-					for i := 0; i < len(paramList); i++ {
-						p := paramList[i]
-						if p.IsSymbol() && p.Symbol == "." {
-							// Rest parameter: next symbol is the rest param name
-							if i+1 < len(paramList) && paramList[i+1].IsSymbol() {
-								restParam = paramList[i+1].Symbol
-							}
-							break
-						}
-						if p.IsSymbol() {
-							params = append(params, p.Symbol)
+				body, doc := functionBody(expr.List[2:])
+				fn := newFunction(expr.List[1].List, body, env)
+				fn.Doc = doc
+				return Value{Type: TypeFunc, Func: fn}
+
+			case "loop":
+				// (loop ((name init) ...) body ...) runs body with the names
+				// bound; (recur v ...) in tail position runs it again
+				if len(expr.List) < 3 || !expr.List[1].IsList() {
+					return Nil()
+				}
+				names, inits := letBindings(expr.List[1])
+				args := make([]Value, len(inits))
+				for i, init := range inits {
+					if args[i] = ev.Eval(init, env); args[i].Type == TypeBlocked {
+						return args[i]
+					}
+				}
+				fn := loopFunction(names, bodyExpr(expr.List[2:]), env, nil)
+				return ev.call(head, fn, args, env, inBody)
+
+			case "while":
+				// (while test body ...) - nil once test is false
+				if len(expr.List) < 2 {
+					return Nil()
+				}
+				for {
+					test := ev.Eval(expr.List[1], env)
+					if test.Type == TypeBlocked {
+						return test
+					}
+					if !test.IsTruthy() {
+						return Nil()
+					}
+					for _, e := range expr.List[2:] {
+						if result := ev.Eval(e, env); result.Type == TypeBlocked {
+							return result
 						}
 					}
 				}
-				// Handle multi-expression body
-				var body Value
-				if len(expr.List) == 3 {
-					body = expr.List[2]
-				} else {
-					bodyExprs := make([]Value, len(expr.List)-2+1)
-					bodyExprs[0] = Sym("begin")
-					copy(bodyExprs[1:], expr.List[2:])
-					body = Lst(bodyExprs...)
+
+			case "dotimes":
+				// (dotimes (i n [result]) body ...) - body for i from 0 to n-1
+				if len(expr.List) < 2 || !expr.List[1].IsList() || len(expr.List[1].List) < 2 || !expr.List[1].List[0].IsSymbol() {
+					return Nil()
 				}
-				return Value{
-					Type: TypeFunc,
-					Func: &Function{
-						Params:    params,
-						RestParam: restParam,
-						Body:      body,
-						Env:       env,
-					},
+				spec := expr.List[1].List
+				n, ok := ev.dotimesCount(ev.Eval(spec[1], env))
+				if !ok {
+					return n
+				}
+				for i := int64(0); i < n.Int; i++ {
+					iterEnv := NewFrame(env, 1)
+					iterEnv.setSym(spec[0], Int(i))
+					for _, e := range expr.List[2:] {
+						if result := ev.Eval(e, iterEnv); result.Type == TypeBlocked {
+							return result
+						}
+					}
 				}
+				if len(spec) > 2 {
+					resultEnv := NewFrame(env, 1)
+					resultEnv.setSym(spec[0], n)
+					return tailExpr(spec[2], resultEnv)
+				}
+				return Nil()
 
 			case "tail":
 				// Tail call - evaluate args but return TailCall marker
@@ -1221,15 +2735,66 @@ func (ev *Evaluator) evalStep(expr Value, env *Env) Value {
 				}
 
 			case "do", "begin":
-				var result Value = Nil()
-				for _, e := range expr.List[1:] {
-					result = ev.Eval(e, env)
+				if len(expr.List) < 2 {
+					return Nil()
+				}
+				last := len(expr.List) - 1
+				for _, e := range expr.List[1:last] {
+					result := ev.Eval(e, env)
 					// Propagate blocked status
 					if result.Type == TypeBlocked {
 						return result
 					}
 				}
-				return result
+				return tailExpr(expr.List[last], env)
+
+			case "time":
+				// (time expr) - evaluate expr, reporting wall time, steps and evaluations
+				return ev.evalTime(expr.List[1:], env)
+
+			case "with-scheduler":
+				// (with-scheduler s body...) - evaluate body with s as the current scheduler
+				return ev.evalWithScheduler(expr.List[1:], env)
+
+			case "select!":
+				// (select! ((recv ch [var]) body...) ((send ch v) body...) (timeout n body...))
+				return ev.evalSelect(expr.List[1:], env)
+
+			case "profile":
+				// (profile expr [:top n]) - time expr and count evaluations per function
+				return ev.evalProfile(expr.List[1:], env)
+
+			case "explain":
+				// (explain (query ...)) - each answer with a proof of it
+				return ev.evalExplain(expr.List[1:], env)
+
+			case "when-feature":
+				// (when-feature 'name body...) - body only when the feature is enabled
+				if len(expr.List) < 3 {
+					return Nil()
+				}
+				name := ev.Eval(expr.List[1], env)
+				if name.Type == TypeBlocked {
+					return name
+				}
+				if !ev.Features[valueToString(name)] {
+					return Nil()
+				}
+				return tailExpr(Lst(append([]Value{Sym("begin")}, expr.List[2:]...)...), env)
+
+			case "module":
+				// (module name body...)
+				if len(expr.List) < 2 || !expr.List[1].IsSymbol() {
+					return Nil()
+				}
+				return ev.evalModule(expr.List[1].Symbol, expr.List[2:])
+
+			case "import":
+				// (import name) or (import name sym...)
+				if len(expr.List) < 2 || !expr.List[1].IsSymbol() {
+					return Nil()
+				}
+				return ev.importModule(expr.List[1].Symbol, expr.List[2:], env)
 
 			case "match":
 				if len(expr.List) < 2 {
@@ -1244,11 +2809,11 @@ func (ev *Evaluator) evalStep(expr Value, env *Env) Value {
 					pattern := clause.List[0]
 					body := clause.List[1]
 					if bindings, ok := ev.match(pattern, target, env); ok {
-						newEnv := NewEnv(env)
+						newEnv := NewFrame(env, len(bindings))
 						for k, v := range bindings {
 							newEnv.Set(k, v)
 						}
-						return ev.Eval(body, newEnv)
+						return tailExpr(body, newEnv)
 					}
 				}
 				return Nil()
@@ -1260,46 +2825,179 @@ func (ev *Evaluator) evalStep(expr Value, env *Env) Value {
 		args := make([]Value, len(expr.List)-1)
 		for i, arg := range expr.List[1:] {
 			args[i] = ev.Eval(arg, env)
+			if args[i].Type == TypeBlocked {
+				// An argument couldn't be computed; neither can the call
+				return args[i]
+			}
 		}
-		return ev.apply(fn, args, env)
+		return ev.call(head, fn, args, env, inBody)
 	}
 
 	return Nil()
 }
 
-func (ev *Evaluator) apply(fn Value, args []Value, env *Env) Value {
-	switch fn.Type {
-	case TypeBuiltin:
-		return fn.Builtin(ev, args, env)
+// call applies fn, the value of head, to args. In a function body's tail
+// position a call to a function is returned as a tail call instead.
+func (ev *Evaluator) call(head, fn Value, args []Value, env *Env, inBody bool) Value {
+	if fn.Type != TypeFunc && fn.Type != TypeBuiltin && !ev.undefinedHead(head, env) {
+		ev.warnTrace("not-a-function:"+head.String(), "Cannot apply %s: %s is not a function", head.String(), fn.String())
+		return Nil()
+	}
+	if inBody && fn.Type == TypeFunc {
+		// Call in tail position of a function body
+		ev.chargeAction(head)
+		return Value{Type: TypeTailCall, Tail: &TailCall{Func: fn, Args: args}}
+	}
+	result := ev.apply(fn, args, env)
+	if result.Type != TypeBlocked && !ev.replaying() {
+		ev.chargeAction(head)
+	}
+	return result
+}
+
+// newFunction builds a function from a parameter list:
+//   (x y)              required
+//   (x (y 10))         y is optional, defaulting to 10
+//   (x &key (n 3) v)   n and v are passed as :n 3 :v true
+//   (x . rest)         rest gets the remaining args as a list
+func newFunction(params []Value, body Value, env *Env) *Function {
+	f := &Function{Params: make([]string, 0), Body: body, Env: env}
+	keys := false
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		if p.IsSymbol() && p.Symbol == "." {
+			// Rest parameter: next symbol is the rest param name
+			if i+1 < len(params) && params[i+1].IsSymbol() {
+				f.RestParam = params[i+1].Symbol
+				f.restID = Intern(f.RestParam)
+			}
+			break
+		}
+		if p.IsSymbol() && p.Symbol == "&key" {
+			keys = true
+			continue
+		}
+		var param Param
+		if p.IsSymbol() {
+			param = Param{Name: p.Symbol, Default: Nil(), ID: Intern(p.Symbol)}
+		} else if p.IsList() && len(p.List) > 0 && p.List[0].IsSymbol() {
+			param = Param{Name: p.List[0].Symbol, Default: Nil(), ID: Intern(p.List[0].Symbol)}
+			if len(p.List) > 1 {
+				param.Default = p.List[1]
+			}
+		} else {
+			continue
+		}
+		switch {
+		case keys:
+			f.Keys = append(f.Keys, param)
+		case p.IsList() || len(f.Optional) > 0:
+			f.Optional = append(f.Optional, param)
+		default:
+			f.Params = append(f.Params, param.Name)
+			f.paramIDs = append(f.paramIDs, param.ID)
+		}
+	}
+	return f
+}
 
-	case TypeFunc:
-		f := fn.Func
-		newEnv := NewEnv(f.Env)
-		
-		// Bind regular parameters
-		for i, param := range f.Params {
-			if i < len(args) {
-				newEnv.Set(param, args[i])
+// bindParams creates the environment for a call of f. Missing required
+// params are nil, missing optional and keyword params get their defaults,
+// and args left over go to the rest param.
+func (ev *Evaluator) bindParams(f *Function, args []Value) *Env {
+	env := NewFrame(f.Env, len(f.Params)+len(f.Optional)+len(f.Keys)+1)
+	set := func(id SymbolID, name string, val Value) {
+		if id == 0 {
+			env.Set(name, val)
+		} else {
+			env.bind(id, name, val)
+		}
+	}
+	isKey := func(v Value) bool {
+		if len(f.Keys) == 0 || !v.IsSymbol() || !strings.HasPrefix(v.Symbol, ":") {
+			return false
+		}
+		for _, k := range f.Keys {
+			if k.Name == v.Symbol[1:] {
+				return true
+			}
+		}
+		return false
+	}
+
+	i := 0
+	for n, param := range f.Params {
+		var id SymbolID
+		if n < len(f.paramIDs) {
+			id = f.paramIDs[n]
+		}
+		if i < len(args) {
+			set(id, param, args[i])
+			i++
+		} else {
+			set(id, param, Nil())
+		}
+	}
+	for _, param := range f.Optional {
+		if i < len(args) && !isKey(args[i]) {
+			set(param.ID, param.Name, args[i])
+			i++
+		} else {
+			set(param.ID, param.Name, ev.Eval(param.Default, env))
+		}
+	}
+
+	var rest []Value
+	if len(f.Keys) > 0 {
+		given := make(map[string]Value)
+		for ; i < len(args); i++ {
+			if isKey(args[i]) && i+1 < len(args) {
+				given[args[i].Symbol[1:]] = args[i+1]
+				i++
 			} else {
-				newEnv.Set(param, Nil())
+				rest = append(rest, args[i])
 			}
 		}
-		
-		// Bind rest parameter if present
-		if f.RestParam != "" {
-			restArgs := make([]Value, 0)
-			if len(args) > len(f.Params) {
-				restArgs = args[len(f.Params):]
+		for _, k := range f.Keys {
+			if v, ok := given[k.Name]; ok {
+				set(k.ID, k.Name, v)
+			} else {
+				set(k.ID, k.Name, ev.Eval(k.Default, env))
 			}
-			newEnv.Set(f.RestParam, Lst(restArgs...))
 		}
+	} else if i < len(args) {
+		rest = args[i:]
+	}
+
+	// Bind rest parameter if present
+	if f.RestParam != "" {
+		set(f.restID, f.RestParam, Lst(rest...))
+	}
+	return env
+}
+
+func (ev *Evaluator) apply(fn Value, args []Value, env *Env) Value {
+	if ev.Profile != nil {
+		ev.countCall(fn)
+	}
+	switch fn.Type {
+	case TypeBuiltin:
+		if err, bad := ev.checkArgs(fn, args); bad {
+			return err
+		}
+		return ev.applyBuiltin(fn, args, env)
+
+	case TypeFunc:
+		f := fn.Func
+		newEnv := ev.bindParams(f, args)
 
 		// Check call stack bounds
-		if !ev.CallStack.PushNow(Lst(args...)) {
-			return Blocked(BlockCallStackFull)
+		frame := callFrame(f, args)
+		if !ev.CallStack.PushNow(frame) {
+			return ev.callStackFull(frame)
 		}
 
-		result := ev.Eval(f.Body, newEnv)
+		result := ev.run(f.Body, ev.compiledBody(f), newEnv, true)
 		ev.CallStack.PopNow()
 		return result
 	}
@@ -1307,6 +3005,236 @@ func (ev *Evaluator) apply(fn Value, args []Value, env *Env) Value {
 	return Nil()
 }
 
+// callFrame is what the call stack holds for a call: (name args...)
+func callFrame(f *Function, args []Value) Value {
+	name := f.Name
+	if name == "" {
+		name = "lambda"
+	}
+	return Lst(append([]Value{Sym(name)}, args...)...)
+}
+
+// callStackFull reports a call that didn't fit on the call stack: a
+// warning with the call chain, a (call-stack-full actor fn depth) fact,
+// and, inside an actor, blocking the actor with the reason. The returned
+// blocked value carries the chain.
+func (ev *Evaluator) callStackFull(frame Value) Value {
+	chain := append(append([]Value(nil), ev.CallStack.Data...), frame)
+	fn := frame.List[0].Symbol
+	actor := ev.Scheduler.CurrentActor
+	if actor == "" {
+		actor = "external"
+	}
+
+	stack := make([]string, len(chain))
+	for i, f := range chain {
+		stack[i] = f.String()
+	}
+	n := len(ev.Warnings)
+	ev.warn("call-stack-full:"+actor+":"+fn, "call stack full (%d frames) calling %s: %s",
+		ev.CallStack.Capacity, frame.String(), summarizeCallChain(chain))
+	if len(ev.Warnings) > n {
+		ev.Warnings[n].Stack = stack
+	}
+	ev.DatalogDB.AssertAtTime("call-stack-full", ev.now(),
+		Atom(actor), Atom(fn), NumTerm(float64(ev.CallStack.Capacity)))
+	if ev.Scheduler.GetActor(actor) != nil {
+		ev.Scheduler.BlockActor(actor, "call stack full in "+fn)
+	}
+
+	b := Blocked(BlockCallStackFull)
+	b.Blocked.Resource = Lst(chain...)
+	return b
+}
+
+// summarizeCallChain names the functions on a call chain, innermost
+// first, collapsing runs of the same function: "count x64 <- main".
+func summarizeCallChain(chain []Value) string {
+	var parts []string
+	for i := len(chain) - 1; i >= 0; {
+		name := chain[i].List[0].Symbol
+		j := i
+		for j >= 0 && chain[j].List[0].Symbol == name {
+			j--
+		}
+		if run := i - j; run > 1 {
+			parts = append(parts, fmt.Sprintf("%s x%d", name, run))
+		} else {
+			parts = append(parts, name)
+		}
+		i = j
+	}
+	return strings.Join(parts, " <- ")
+}
+
+// ============================================================================
+// Quasiquote
+// ============================================================================
+
+// quasiquote fills in a template: ,x is replaced by the value of x and ,@x
+// by the items of the list x. Nested quasiquotes raise the depth, so only
+// unquotes belonging to the outermost one are evaluated.
+func (ev *Evaluator) quasiquote(tmpl Value, env *Env, depth int) Value {
+	if tmpl.Type != TypeList || len(tmpl.List) == 0 {
+		return tmpl
+	}
+	if head := tmpl.List[0]; head.IsSymbol() && len(tmpl.List) == 2 {
+		switch head.Symbol {
+		case "unquote":
+			if depth == 1 {
+				return ev.Eval(tmpl.List[1], env)
+			}
+			return ev.quasiquoteForm(head, tmpl.List[1], env, depth-1)
+		case "unquote-splicing":
+			if depth > 1 {
+				return ev.quasiquoteForm(head, tmpl.List[1], env, depth-1)
+			}
+		case "quasiquote":
+			return ev.quasiquoteForm(head, tmpl.List[1], env, depth+1)
+		}
+	}
+
+	items := make([]Value, 0, len(tmpl.List))
+	for _, item := range tmpl.List {
+		if item.Type == TypeList && len(item.List) == 2 && item.List[0].IsSymbol() &&
+			item.List[0].Symbol == "unquote-splicing" && depth == 1 {
+			spliced := ev.Eval(item.List[1], env)
+			switch spliced.Type {
+			case TypeBlocked:
+				return spliced
+			case TypeList:
+				items = append(items, spliced.List...)
+			case TypeNil:
+			default:
+				ev.warn("unquote-splicing", "unquote-splicing: %s is not a list", spliced.String())
+				items = append(items, spliced)
+			}
+			continue
+		}
+		v := ev.quasiquote(item, env, depth)
+		if v.Type == TypeBlocked {
+			return v
+		}
+		items = append(items, v)
+	}
+	return Lst(items...)
+}
+
+// quasiquoteForm keeps a nested (quasiquote x) or (unquote x) form, filling in x
+func (ev *Evaluator) quasiquoteForm(head, body Value, env *Env, depth int) Value {
+	v := ev.quasiquote(body, env, depth)
+	if v.Type == TypeBlocked {
+		return v
+	}
+	return Lst(head, v)
+}
+
+// ============================================================================
+// Modules
+// ============================================================================
+
+// Module is a named environment. Definitions made while its body is
+// evaluated go into Env instead of the global environment; they are
+// reachable as name/symbol or brought into scope with import.
+type Module struct {
+	Name    string
+	Env     *Env
+	Exports []string // Names visible outside; defaults to everything defined
+}
+
+// defineEnv is where define writes: the current module, or globals
+func (ev *Evaluator) defineEnv() *Env {
+	if ev.CurModule != nil {
+		return ev.CurModule.Env
+	}
+	return ev.GlobalEnv
+}
+
+func (ev *Evaluator) evalModule(name string, body []Value) Value {
+	mod, ok := ev.Modules[name]
+	if !ok {
+		mod = &Module{Name: name, Env: NewEnv(ev.GlobalEnv)}
+		ev.Modules[name] = mod
+	}
+	prev := ev.CurModule
+	ev.CurModule = mod
+	defer func() { ev.CurModule = prev }()
+
+	for _, form := range body {
+		// (export sym...) restricts what import and qualified lookup see
+		if form.IsList() && len(form.List) > 0 && form.List[0].IsSymbol() && form.List[0].Symbol == "export" {
+			for _, sym := range form.List[1:] {
+				if sym.IsSymbol() {
+					mod.Exports = append(mod.Exports, sym.Symbol)
+				}
+			}
+			continue
+		}
+		if v := ev.Eval(form, mod.Env); v.Type == TypeBlocked {
+			return v
+		}
+	}
+	return Sym(name)
+}
+
+func (m *Module) exported(name string) bool {
+	if len(m.Exports) == 0 {
+		return true
+	}
+	for _, e := range m.Exports {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupQualified resolves module/name symbols
+func (ev *Evaluator) lookupQualified(sym string) (Value, bool) {
+	i := strings.LastIndex(sym, "/")
+	if i <= 0 || i == len(sym)-1 {
+		return Nil(), false
+	}
+	mod, ok := ev.Modules[sym[:i]]
+	if !ok || !mod.exported(sym[i+1:]) {
+		return Nil(), false
+	}
+	v, ok := mod.Env.bindings[sym[i+1:]]
+	return v, ok
+}
+
+func (ev *Evaluator) importModule(name string, only []Value, env *Env) Value {
+	mod, ok := ev.Modules[name]
+	if !ok {
+		ev.warn("import:"+name, "import: unknown module %s", name)
+		return Nil()
+	}
+	var names []string
+	if len(only) > 0 {
+		for _, sym := range only {
+			if sym.IsSymbol() {
+				names = append(names, sym.Symbol)
+			}
+		}
+	} else {
+		for k := range mod.Env.bindings {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+	}
+	imported := make([]Value, 0, len(names))
+	for _, n := range names {
+		if !mod.exported(n) {
+			continue
+		}
+		if v, ok := mod.Env.bindings[n]; ok {
+			env.Set(n, v)
+			imported = append(imported, Sym(n))
+		}
+	}
+	return Lst(imported...)
+}
+
 func (ev *Evaluator) match(pattern, target Value, env *Env) (map[string]Value, bool) {
 	bindings := make(map[string]Value)
 
@@ -1351,6 +3279,10 @@ func (ev *Evaluator) match(pattern, target Value, env *Env) (map[string]Value, b
 			if pattern.Bool == target.Bool {
 				return bindings, true
 			}
+		case TypeChar:
+			if pattern.Int == target.Int {
+				return bindings, true
+			}
 		case TypeList:
 			if len(pattern.List) != len(target.List) {
 				return nil, false
@@ -1375,7 +3307,72 @@ func (ev *Evaluator) match(pattern, target Value, env *Env) (map[string]Value, b
 // Builtins
 // ============================================================================
 
+// Integers are exact: +, -, * and mod on integers give integers, and
+// fall back to floats only on overflow or when any argument is a float.
+
+func allInts(args []Value) bool {
+	for _, a := range args {
+		if !a.IsInt {
+			return false
+		}
+	}
+	return true
+}
+
+func addInts(a, b int64) (int64, bool) {
+	c := a + b
+	return c, (c > a) == (b > 0)
+}
+
+func subInts(a, b int64) (int64, bool) {
+	c := a - b
+	return c, (c < a) == (b > 0)
+}
+
+func mulInts(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	if c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+	return c, true
+}
+
+// compareNumbers orders two numbers, exactly when both are integers
+func compareNumbers(a, b Value) int {
+	if a.IsInt && b.IsInt {
+		switch {
+		case a.Int < b.Int:
+			return -1
+		case a.Int > b.Int:
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case a.Number < b.Number:
+		return -1
+	case a.Number > b.Number:
+		return 1
+	}
+	return 0
+}
+
 func builtinAdd(ev *Evaluator, args []Value, env *Env) Value {
+	if allInts(args) {
+		var sum int64
+		ok := true
+		for _, a := range args {
+			if sum, ok = addInts(sum, a.Int); !ok {
+				break
+			}
+		}
+		if ok {
+			return Int(sum)
+		}
+	}
 	sum := 0.0
 	for _, a := range args {
 		sum += a.Number
@@ -1385,11 +3382,26 @@ func builtinAdd(ev *Evaluator, args []Value, env *Env) Value {
 
 func builtinSub(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) == 0 {
-		return Num(0)
+		return Int(0)
 	}
 	if len(args) == 1 {
+		if args[0].IsInt && args[0].Int != math.MinInt64 {
+			return Int(-args[0].Int)
+		}
 		return Num(-args[0].Number)
 	}
+	if allInts(args) {
+		result := args[0].Int
+		ok := true
+		for _, a := range args[1:] {
+			if result, ok = subInts(result, a.Int); !ok {
+				break
+			}
+		}
+		if ok {
+			return Int(result)
+		}
+	}
 	result := args[0].Number
 	for _, a := range args[1:] {
 		result -= a.Number
@@ -1398,6 +3410,18 @@ func builtinSub(ev *Evaluator, args []Value, env *Env) Value {
 }
 
 func builtinMul(ev *Evaluator, args []Value, env *Env) Value {
+	if allInts(args) {
+		var product int64 = 1
+		ok := true
+		for _, a := range args {
+			if product, ok = mulInts(product, a.Int); !ok {
+				break
+			}
+		}
+		if ok {
+			return Int(product)
+		}
+	}
 	product := 1.0
 	for _, a := range args {
 		product *= a.Number
@@ -1405,18 +3429,42 @@ func builtinMul(ev *Evaluator, args []Value, env *Env) Value {
 	return Num(product)
 }
 
+// builtinDiv returns an integer when integers divide evenly
 func builtinDiv(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 {
 		return Num(0)
 	}
-	return Num(args[0].Number / args[1].Number)
+	a, b := args[0], args[1]
+	if a.IsInt && b.IsInt && b.Int != 0 && a.Int%b.Int == 0 &&
+		!(a.Int == math.MinInt64 && b.Int == -1) {
+		return Int(a.Int / b.Int)
+	}
+	return Num(a.Number / b.Number)
 }
 
 func builtinMod(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 {
 		return Num(0)
 	}
-	return Num(float64(int64(args[0].Number) % int64(args[1].Number)))
+	a, b := args[0], args[1]
+	if a.IsInt && b.IsInt {
+		if b.Int == 0 || b.Int == -1 {
+			return Int(0)
+		}
+		return Int(a.Int % b.Int)
+	}
+	if b.Number == 0 {
+		return Num(0)
+	}
+	return Num(math.Mod(a.Number, b.Number))
+}
+
+// builtinFloat converts an integer to a float, e.g. to force (/ (float a) b)
+func builtinFloat(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeNumber {
+		return Num(0)
+	}
+	return Num(args[0].Number)
 }
 
 // Math functions
@@ -1466,20 +3514,34 @@ func builtinFloor(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 || args[0].Type != TypeNumber {
 		return Num(0)
 	}
-	return Num(math.Floor(args[0].Number))
+	return floatToInt(math.Floor(args[0].Number))
 }
 
 func builtinCeil(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 || args[0].Type != TypeNumber {
 		return Num(0)
 	}
-	return Num(math.Ceil(args[0].Number))
+	return floatToInt(math.Ceil(args[0].Number))
+}
+
+// floatToInt returns a whole float as an integer when it fits
+func floatToInt(f float64) Value {
+	if f >= math.MinInt64 && f < math.MaxInt64 {
+		return Int(int64(f))
+	}
+	return Num(f)
 }
 
 func builtinAbs(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 || args[0].Type != TypeNumber {
 		return Num(0)
 	}
+	if args[0].IsInt && args[0].Int != math.MinInt64 {
+		if args[0].Int < 0 {
+			return Int(-args[0].Int)
+		}
+		return args[0]
+	}
 	return Num(math.Abs(args[0].Number))
 }
 
@@ -1487,42 +3549,134 @@ func builtinMin(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 {
 		return Num(0)
 	}
-	min := args[0].Number
+	min := args[0]
 	for _, a := range args[1:] {
-		if a.Type == TypeNumber && a.Number < min {
-			min = a.Number
+		if a.Type == TypeNumber && compareNumbers(a, min) < 0 {
+			min = a
 		}
 	}
-	return Num(min)
+	return min
 }
 
 func builtinMax(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 {
 		return Num(0)
 	}
-	max := args[0].Number
+	max := args[0]
 	for _, a := range args[1:] {
-		if a.Type == TypeNumber && a.Number > max {
-			max = a.Number
+		if a.Type == TypeNumber && compareNumbers(a, max) > 0 {
+			max = a
 		}
 	}
-	return Num(max)
+	return max
+}
+
+// SetSeed restarts the rand builtin's sequence, so a run can be repeated
+func (ev *Evaluator) SetSeed(seed int64) {
+	ev.Seed = seed
+	ev.randSrc = newRandSource(seed)
+	ev.Rand = rand.New(ev.randSrc)
+}
+
+// randSource is a rand source whose whole state is a PCG's two words, so
+// a copy of the world can take the sequence on from where it is
+type randSource struct {
+	pcg randv2.PCG
+}
+
+func newRandSource(seed int64) *randSource {
+	return &randSource{pcg: *randv2.NewPCG(uint64(seed), 0)}
+}
+
+func (r *randSource) Int63() int64    { return int64(r.pcg.Uint64() >> 1) }
+func (r *randSource) Uint64() uint64  { return r.pcg.Uint64() }
+func (r *randSource) Seed(seed int64) { r.pcg.Seed(uint64(seed), 0) }
+
+// clone is a source that goes on from where r is, independently of it
+func (r *randSource) clone() *randSource {
+	cp := *r
+	return &cp
+}
+
+// copyRand gives w rand builtin dice of its own, at the same point in
+// their sequence as ev's
+func (ev *Evaluator) copyRand(w *Evaluator) {
+	w.Seed = ev.Seed
+	if ev.randSrc == nil {
+		w.Rand = ev.Rand // Not seeded through SetSeed; nothing to copy
+		return
+	}
+	w.randSrc = ev.randSrc.clone()
+	w.Rand = rand.New(w.randSrc)
 }
 
 func builtinRand(ev *Evaluator, args []Value, env *Env) Value {
 	// (rand) -> random float [0, 1)
 	// (rand n) -> random int [0, n)
 	if len(args) == 0 {
-		return Num(rand.Float64())
+		return Num(ev.Rand.Float64())
 	}
 	if args[0].Type == TypeNumber {
 		n := int(args[0].Number)
 		if n <= 0 {
 			return Num(0)
 		}
-		return Num(float64(rand.Intn(n)))
+		return Int(int64(ev.Rand.Intn(n)))
 	}
-	return Num(rand.Float64())
+	return Num(ev.Rand.Float64())
+}
+
+// (now) - seconds since the Unix epoch
+func builtinNow(ev *Evaluator, args []Value, env *Env) Value {
+	return Int(time.Now().Unix())
+}
+
+// (now-millis) - milliseconds since the Unix epoch
+func builtinNowMillis(ev *Evaluator, args []Value, env *Env) Value {
+	return Int(time.Now().UnixMilli())
+}
+
+// timeLayouts are the layouts format-time knows by name
+var timeLayouts = map[string]string{
+	"rfc3339":  time.RFC3339,
+	"millis":   "2006-01-02T15:04:05.000Z07:00",
+	"date":     "2006-01-02",
+	"time":     "15:04:05",
+	"datetime": "2006-01-02 15:04:05",
+}
+
+// (format-time t [layout]) - t, in seconds since the Unix epoch, written
+// in UTC. layout is a name from timeLayouts or a Go layout string such as
+// "Jan 2 15:04"; the default is rfc3339.
+func builtinFormatTime(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeNumber {
+		ev.warn("", "format-time: expected seconds since the epoch")
+		return Nil()
+	}
+	layout := time.RFC3339
+	if len(args) > 1 {
+		switch args[1].Type {
+		case TypeSymbol:
+			named, ok := timeLayouts[args[1].Symbol]
+			if !ok {
+				ev.warn("", "format-time: unknown layout %s", args[1].Symbol)
+				return Nil()
+			}
+			layout = named
+		case TypeString:
+			layout = args[1].Str
+		default:
+			ev.warn("", "format-time: expected a layout name or string, got %s", args[1].String())
+			return Nil()
+		}
+	}
+	var t time.Time
+	if args[0].IsInt {
+		t = time.Unix(args[0].Int, 0)
+	} else {
+		t = time.UnixMilli(int64(math.Round(args[0].Number * 1000)))
+	}
+	return Str(t.UTC().Format(layout))
 }
 
 func builtinConcat(ev *Evaluator, args []Value, env *Env) Value {
@@ -1540,16 +3694,36 @@ func builtinStr(ev *Evaluator, args []Value, env *Env) Value {
 	return Str(valueToString(args[0]))
 }
 
+// keywordArgs splits trailing :name value pairs from positional args.
+// (f a b :steps 10) gives positional (a b) and {"steps": 10}.
+func keywordArgs(args []Value) ([]Value, map[string]Value) {
+	opts := make(map[string]Value)
+	for i, a := range args {
+		if a.IsSymbol() && strings.HasPrefix(a.Symbol, ":") {
+			for j := i; j+1 < len(args); j += 2 {
+				opts[strings.TrimPrefix(args[j].Symbol, ":")] = args[j+1]
+			}
+			return args[:i], opts
+		}
+	}
+	return args, opts
+}
+
 func valueToString(v Value) string {
 	switch v.Type {
 	case TypeString:
 		return v.Str
+	case TypeChar:
+		return string(rune(v.Int))
 	case TypeNumber:
+		if v.IsInt {
+			return strconv.FormatInt(v.Int, 10)
+		}
 		if v.Number == float64(int(v.Number)) {
 			return strconv.Itoa(int(v.Number))
 		}
 		return strconv.FormatFloat(v.Number, 'f', -1, 64)
-	case TypeSymbol:
+	case TypeSymbol, TypeActor:
 		return v.Symbol
 	case TypeBool:
 		if v.Bool {
@@ -1562,31 +3736,98 @@ func valueToString(v Value) string {
 			parts = append(parts, valueToString(elem))
 		}
 		return "(" + strings.Join(parts, " ") + ")"
+	case TypeMap, TypeSet:
+		return v.String()
 	default:
 		return fmt.Sprintf("%v", v)
 	}
 }
 
+// (= a b ...) compares numbers only; anything else is a type error, so a
+// spec comparing symbols or stacks with = gets an error value instead of
+// quietly getting false
+func builtinNumEq(ev *Evaluator, args []Value, env *Env) Value {
+	return ev.numbersEqual("=", args)
+}
+
+// numbersEqual is = for name: whether args are all the same number, or an
+// error value for the first that isn't a number
+func (ev *Evaluator) numbersEqual(name string, args []Value) Value {
+	for i, a := range args {
+		if a.Type != TypeNumber {
+			ev.warnTrace(name+":type", "%s: expected numbers, got %s; use equal? or eq? for other values", name, a.String())
+			return errorValue(name, fmt.Sprintf("a number for argument %d", i+1), a)
+		}
+	}
+	for i := 1; i < len(args); i++ {
+		if compareNumbers(args[0], args[i]) != 0 {
+			return Bool(false)
+		}
+	}
+	return Bool(true)
+}
+
+// (eq? a b) - the same value: equal numbers, strings, symbols and
+// booleans, or the very same list, map, set, stack, queue or function
 func builtinEq(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 {
+		return Bool(true)
+	}
+	return Bool(valuesIdentical(args[0], args[1]))
+}
+
+// (equal? a b) - deep comparison of contents
+func builtinEqual(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 {
 		return Bool(true)
 	}
 	return Bool(valuesEqual(args[0], args[1]))
 }
 
+// valuesIdentical compares atoms by value and everything else by identity.
+// Lists are slices, so two lists are identical if they share their items.
+func valuesIdentical(a, b Value) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	switch a.Type {
+	case TypeNumber, TypeString, TypeSymbol, TypeBool, TypeChar, TypeNil, TypeActor:
+		return valuesEqual(a, b)
+	case TypeList:
+		return len(a.List) == len(b.List) && (len(a.List) == 0 || &a.List[0] == &b.List[0])
+	case TypeFunc:
+		return a.Func == b.Func
+	case TypeBuiltin:
+		return fmt.Sprintf("%p", a.Builtin) == fmt.Sprintf("%p", b.Builtin)
+	case TypeStack:
+		return a.Stack == b.Stack
+	case TypeQueue:
+		return a.Queue == b.Queue
+	case TypeTagged:
+		return a.Tagged == b.Tagged
+	case TypeMap:
+		return a.Map == b.Map
+	case TypeSet:
+		return a.Set == b.Set
+	}
+	return false
+}
+
 func valuesEqual(a, b Value) bool {
 	if a.Type != b.Type {
 		return false
 	}
 	switch a.Type {
 	case TypeNumber:
-		return a.Number == b.Number
+		return compareNumbers(a, b) == 0
 	case TypeString:
 		return a.Str == b.Str
-	case TypeSymbol:
+	case TypeSymbol, TypeActor:
 		return a.Symbol == b.Symbol
 	case TypeBool:
 		return a.Bool == b.Bool
+	case TypeChar:
+		return a.Int == b.Int
 	case TypeNil:
 		return true
 	case TypeList:
@@ -1599,40 +3840,68 @@ func valuesEqual(a, b Value) bool {
 			}
 		}
 		return true
+	case TypeMap:
+		if len(a.Map.Data) != len(b.Map.Data) {
+			return false
+		}
+		for k, av := range a.Map.Data {
+			bv, ok := b.Map.Data[k]
+			if !ok || !valuesEqual(av, bv) {
+				return false
+			}
+		}
+		return true
+	case TypeSet:
+		if len(a.Set.Items) != len(b.Set.Items) {
+			return false
+		}
+		for k := range a.Set.Items {
+			if _, ok := b.Set.Items[k]; !ok {
+				return false
+			}
+		}
+		return true
+	case TypeTagged:
+		return a.Tagged.Tag == b.Tagged.Tag && valuesEqual(a.Tagged.Value, b.Tagged.Value)
 	}
-	return false
+	// Stacks, queues and functions have no contents to compare
+	return valuesIdentical(a, b)
 }
 
 func builtinNeq(ev *Evaluator, args []Value, env *Env) Value {
-	return Bool(!builtinEq(ev, args, env).Bool)
+	eq := ev.numbersEqual("!=", args)
+	if eq.Type != TypeBool {
+		return eq
+	}
+	return Bool(!eq.Bool)
 }
 
 func builtinLt(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 {
 		return Bool(false)
 	}
-	return Bool(args[0].Number < args[1].Number)
+	return Bool(compareNumbers(args[0], args[1]) < 0)
 }
 
 func builtinLte(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 {
 		return Bool(false)
 	}
-	return Bool(args[0].Number <= args[1].Number)
+	return Bool(compareNumbers(args[0], args[1]) <= 0)
 }
 
 func builtinGt(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 {
 		return Bool(false)
 	}
-	return Bool(args[0].Number > args[1].Number)
+	return Bool(compareNumbers(args[0], args[1]) > 0)
 }
 
 func builtinGte(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 {
 		return Bool(false)
 	}
-	return Bool(args[0].Number >= args[1].Number)
+	return Bool(compareNumbers(args[0], args[1]) >= 0)
 }
 
 func builtinAnd(ev *Evaluator, args []Value, env *Env) Value {
@@ -1678,54 +3947,353 @@ func builtinCons(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 {
 		return Lst()
 	}
-	if args[1].IsList() {
-		return Lst(append([]Value{args[0]}, args[1].List...)...)
+	if args[1].IsList() {
+		return Lst(append([]Value{args[0]}, args[1].List...)...)
+	}
+	return Lst(args[0], args[1])
+}
+
+func builtinAppend(ev *Evaluator, args []Value, env *Env) Value {
+	var result []Value
+	for _, a := range args {
+		if a.IsList() {
+			result = append(result, a.List...)
+		} else {
+			result = append(result, a)
+		}
+	}
+	return Lst(result...)
+}
+
+func builtinList(ev *Evaluator, args []Value, env *Env) Value {
+	return Lst(args...)
+}
+
+func builtinEmpty(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Bool(true)
+	}
+	if args[0].IsList() {
+		return Bool(len(args[0].List) == 0)
+	}
+	return Bool(true)
+}
+
+func builtinLength(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 || !args[0].IsList() {
+		return Num(0)
+	}
+	return Int(int64(len(args[0].List)))
+}
+
+func builtinNth(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 || !args[0].IsList() {
+		return Nil()
+	}
+	idx := int(args[1].Number)
+	if idx >= 0 && idx < len(args[0].List) {
+		return args[0].List[idx]
+	}
+	return Nil()
+}
+
+// ============================================================================
+// Higher-Order List Functions
+// ============================================================================
+//
+// Implemented in Go so long lists don't use up the call stack. A call
+// that blocks (e.g. a send inside for-each) stops the iteration and the
+// blocked value is returned.
+
+// (map f list...) - with several lists, f gets one element from each and
+// the result is as long as the shortest list
+func builtinMap(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 {
+		return Lst()
+	}
+	lists := args[1:]
+	n := len(lists[0].List)
+	for _, l := range lists[1:] {
+		if len(l.List) < n {
+			n = len(l.List)
+		}
+	}
+	result := make([]Value, 0, n)
+	for i := 0; i < n; i++ {
+		callArgs := make([]Value, len(lists))
+		for j, l := range lists {
+			callArgs[j] = l.List[i]
+		}
+		r := ev.apply(args[0], callArgs, env)
+		if r.Type == TypeBlocked {
+			return r
+		}
+		result = append(result, r)
+	}
+	return Lst(result...)
+}
+
+// (filter pred list)
+func builtinFilter(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 {
+		return Lst()
+	}
+	var result []Value
+	for _, x := range args[1].List {
+		r := ev.apply(args[0], []Value{x}, env)
+		if r.Type == TypeBlocked {
+			return r
+		}
+		if r.IsTruthy() {
+			result = append(result, x)
+		}
+	}
+	return Lst(result...)
+}
+
+// (fold-left f init list) - (f (f (f init x1) x2) x3)
+func builtinFoldLeft(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 3 {
+		return Nil()
+	}
+	acc := args[1]
+	for _, x := range args[2].List {
+		acc = ev.apply(args[0], []Value{acc, x}, env)
+		if acc.Type == TypeBlocked {
+			return acc
+		}
+	}
+	return acc
+}
+
+// (fold-right f init list) - (f x1 (f x2 (f x3 init)))
+func builtinFoldRight(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 3 {
+		return Nil()
+	}
+	acc := args[1]
+	items := args[2].List
+	for i := len(items) - 1; i >= 0; i-- {
+		acc = ev.apply(args[0], []Value{items[i], acc}, env)
+		if acc.Type == TypeBlocked {
+			return acc
+		}
+	}
+	return acc
+}
+
+// (reduce f list) folds from the first element; nil for an empty list.
+// (reduce f init list) is fold-left.
+func builtinReduce(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) >= 3 {
+		return builtinFoldLeft(ev, args, env)
+	}
+	if len(args) < 2 || len(args[1].List) == 0 {
+		return Nil()
+	}
+	items := args[1].List
+	return builtinFoldLeft(ev, []Value{args[0], items[0], Lst(items[1:]...)}, env)
+}
+
+// (for-each f list...) - call f for its effects; returns nil
+func builtinForEach(ev *Evaluator, args []Value, env *Env) Value {
+	if r := builtinMap(ev, args, env); r.Type == TypeBlocked {
+		return r
+	}
+	return Nil()
+}
+
+// (apply f arg ... arglist) - call f with the args followed by arglist's items
+func builtinApply(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 {
+		ev.warn("", "apply: expected a function and a list of arguments")
+		return Nil()
+	}
+	fn, last := args[0], args[len(args)-1]
+	if fn.Type != TypeFunc && fn.Type != TypeBuiltin {
+		ev.warnTrace("", "apply: %s is not a function", fn.String())
+		return Nil()
+	}
+	if last.Type != TypeList && last.Type != TypeNil {
+		ev.warnTrace("", "apply: expected a list of arguments, got %s", last.String())
+		return Nil()
+	}
+	callArgs := make([]Value, 0, len(args)-2+len(last.List))
+	callArgs = append(callArgs, args[1:len(args)-1]...)
+	callArgs = append(callArgs, last.List...)
+	return ev.apply(fn, callArgs, env)
+}
+
+// (partial f a b) - a function that calls f with a and b before its own args
+func builtinPartial(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 || (args[0].Type != TypeFunc && args[0].Type != TypeBuiltin) {
+		ev.warnTrace("", "partial: expected a function")
+		return Nil()
+	}
+	return applyingFunction("partial", args[0], args[1:], ev.GlobalEnv)
+}
+
+// (curry f [n]) - a function that collects f's n arguments over as many
+// calls as it takes, then calls f. n defaults to f's required parameters;
+// builtins need it given.
+func builtinCurry(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 || (args[0].Type != TypeFunc && args[0].Type != TypeBuiltin) {
+		ev.warnTrace("", "curry: expected a function")
+		return Nil()
+	}
+	f := args[0]
+	var n Value
+	switch {
+	case len(args) > 1 && args[1].IsInt && args[1].Int >= 0:
+		n = args[1]
+	case len(args) > 1:
+		ev.warnTrace("", "curry: expected a count of arguments, got %s", args[1].String())
+		return Nil()
+	case f.Type == TypeFunc:
+		n = Int(int64(len(f.Func.Params)))
+	default:
+		ev.warnTrace("", "curry: give the number of arguments a builtin takes, as in (curry + 2)")
+		return Nil()
+	}
+	if n.Int == 0 {
+		return f
 	}
-	return Lst(args[0], args[1])
+	return applyingFunction("curry", Value{Type: TypeBuiltin, Builtin: builtinCurryStep}, []Value{f, n, Lst()}, ev.GlobalEnv)
 }
 
-func builtinAppend(ev *Evaluator, args []Value, env *Env) Value {
-	var result []Value
-	for _, a := range args {
-		if a.IsList() {
-			result = append(result, a.List...)
-		} else {
-			result = append(result, a)
-		}
+// builtinCurryStep takes f, n, the arguments collected so far, and more
+func builtinCurryStep(ev *Evaluator, args []Value, env *Env) Value {
+	f, n := args[0], args[1].Int
+	have := append(append([]Value(nil), args[2].List...), args[3:]...)
+	if int64(len(have)) >= n {
+		return ev.apply(f, have, env)
 	}
-	return Lst(result...)
+	return applyingFunction("curry", Value{Type: TypeBuiltin, Builtin: builtinCurryStep}, []Value{f, args[1], Lst(have...)}, ev.GlobalEnv)
 }
 
-func builtinList(ev *Evaluator, args []Value, env *Env) Value {
-	return Lst(args...)
+// applyingBody is the body of the functions partial and curry make
+var applyingBody = Lst(InternedSym("apply"), InternedSym("f"), Lst(InternedSym("append"), InternedSym("fixed"), InternedSym("args")))
+
+// applyingFunction makes a function of any number of arguments that
+// applies fn to fixed followed by them
+func applyingFunction(name string, fn Value, fixed []Value, global *Env) Value {
+	env := NewEnv(global)
+	env.Set("apply", Value{Type: TypeBuiltin, Builtin: builtinApply})
+	env.Set("append", Value{Type: TypeBuiltin, Builtin: builtinAppend})
+	env.Set("f", fn)
+	env.Set("fixed", Lst(fixed...))
+	f := newFunction([]Value{InternedSym("."), InternedSym("args")}, applyingBody, env)
+	f.Name = name
+	return Value{Type: TypeFunc, Func: f}
+}
+
+// (memoize f [n]) - a function that calls f once for each distinct list
+// of arguments and answers repeats from a cache. Arguments match when they
+// are equal?. With n, the cache is a bounded stack of n argument lists:
+// once it is full, calls with new arguments still run f but aren't cached.
+// Blocked results aren't cached, so a call that blocked runs again.
+func builtinMemoize(ev *Evaluator, args []Value, env *Env) Value {
+	f := args[0]
+	var bound *BoundedStack
+	if len(args) > 1 {
+		if args[1].Number < 1 {
+			ev.warnTrace("", "memoize: expected a positive cache size, got %s", args[1].String())
+			return Nil()
+		}
+		bound = NewStack(int(args[1].Number))
+	}
+	// Entries are bucketed by the arguments' printed form, then matched
+	// with valuesEqual, since functions, stacks and queues print alike
+	type entry struct{ args, result Value }
+	cache := make(map[string][]entry)
+	lookup := func(ev *Evaluator, args []Value, env *Env) Value {
+		key := Lst(args...)
+		printed := key.String()
+		for _, e := range cache[printed] {
+			if valuesEqual(e.args, key) {
+				return e.result
+			}
+		}
+		result := ev.apply(f, args, env)
+		if result.Type == TypeBlocked || (bound != nil && !bound.PushNow(key)) {
+			return result
+		}
+		cache[printed] = append(cache[printed], entry{key, result})
+		return result
+	}
+	return applyingFunction("memoize", Value{Type: TypeBuiltin, Builtin: lookup}, nil, ev.GlobalEnv)
 }
 
-func builtinEmpty(ev *Evaluator, args []Value, env *Env) Value {
-	if len(args) == 0 {
-		return Bool(true)
+// compareValues orders values for sort: numbers, then strings, then
+// chars, then symbols, then lists (element by element), then anything
+// else by its printed form. Returns -1, 0 or 1.
+func compareValues(a, b Value) int {
+	rank := func(v Value) int {
+		switch v.Type {
+		case TypeNumber:
+			return 0
+		case TypeString:
+			return 1
+		case TypeChar:
+			return 2
+		case TypeSymbol:
+			return 3
+		case TypeList:
+			return 4
+		default:
+			return 5
+		}
 	}
-	if args[0].IsList() {
-		return Bool(len(args[0].List) == 0)
+	if ra, rb := rank(a), rank(b); ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	switch a.Type {
+	case TypeNumber:
+		return compareNumbers(a, b)
+	case TypeString:
+		return strings.Compare(a.Str, b.Str)
+	case TypeChar:
+		return compareNumbers(Int(a.Int), Int(b.Int))
+	case TypeSymbol:
+		return strings.Compare(a.Symbol, b.Symbol)
+	case TypeList:
+		for i := 0; i < len(a.List) && i < len(b.List); i++ {
+			if c := compareValues(a.List[i], b.List[i]); c != 0 {
+				return c
+			}
+		}
+		return compareNumbers(Int(int64(len(a.List))), Int(int64(len(b.List))))
+	default:
+		return strings.Compare(a.String(), b.String())
 	}
-	return Bool(true)
 }
 
-func builtinLength(ev *Evaluator, args []Value, env *Env) Value {
-	if len(args) == 0 || !args[0].IsList() {
-		return Num(0)
+// (sort lst) - ascending in the natural order of compareValues
+func builtinSort(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 {
+		return Lst()
 	}
-	return Num(float64(len(args[0].List)))
+	items := append([]Value(nil), args[0].List...)
+	sort.SliceStable(items, func(i, j int) bool {
+		return compareValues(items[i], items[j]) < 0
+	})
+	return Lst(items...)
 }
 
-func builtinNth(ev *Evaluator, args []Value, env *Env) Value {
-	if len(args) < 2 || !args[0].IsList() {
-		return Nil()
-	}
-	idx := int(args[1].Number)
-	if idx >= 0 && idx < len(args[0].List) {
-		return args[0].List[idx]
+// (sort-by lst less?) - stable sort where (less? a b) is true when a
+// comes before b
+func builtinSortBy(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 {
+		return Lst()
 	}
-	return Nil()
+	items := append([]Value(nil), args[0].List...)
+	sort.SliceStable(items, func(i, j int) bool {
+		return ev.apply(args[1], []Value{items[i], items[j]}, env).IsTruthy()
+	})
+	return Lst(items...)
 }
 
 func builtinIsList(ev *Evaluator, args []Value, env *Env) Value {
@@ -1742,6 +4310,20 @@ func builtinIsNumber(ev *Evaluator, args []Value, env *Env) Value {
 	return Bool(args[0].Type == TypeNumber)
 }
 
+func builtinIsInteger(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Bool(false)
+	}
+	return Bool(args[0].Type == TypeNumber && args[0].IsInt)
+}
+
+func builtinIsFloat(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Bool(false)
+	}
+	return Bool(args[0].Type == TypeNumber && !args[0].IsInt)
+}
+
 func builtinIsSymbol(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) == 0 {
 		return Bool(false)
@@ -1756,6 +4338,13 @@ func builtinIsString(ev *Evaluator, args []Value, env *Env) Value {
 	return Bool(args[0].Type == TypeString)
 }
 
+func builtinIsChar(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Bool(false)
+	}
+	return Bool(args[0].Type == TypeChar)
+}
+
 func builtinIsNil(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) == 0 {
 		return Bool(true)
@@ -1772,20 +4361,94 @@ func builtinEval(ev *Evaluator, args []Value, env *Env) Value {
 	return ev.Eval(args[0], ev.GlobalEnv)
 }
 
+// (set-call-stack-depth! n) - how many nested calls are allowed; returns
+// the previous depth. Calls already deeper than n can still return.
+func builtinSetCallStackDepth(ev *Evaluator, args []Value, env *Env) Value {
+	prev := Int(int64(ev.CallStack.Capacity))
+	if ev.sandboxDenied("set-call-stack-depth!") {
+		return prev
+	}
+	if len(args) == 0 || args[0].Type != TypeNumber || args[0].Number < 1 {
+		ev.warn("", "set-call-stack-depth!: expected a positive number")
+		return prev
+	}
+	depth := int(args[0].Number)
+	if args[0].Number > maxCallStackDepth {
+		ev.warn("", fmt.Sprintf("set-call-stack-depth!: %s frames is past the limit, using %d", args[0].String(), maxCallStackDepth))
+		depth = maxCallStackDepth
+	}
+	ev.CallStack.Capacity = depth
+	return prev
+}
+
+// (feature? 'name) - whether the feature is enabled for this run
+func builtinFeatureP(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Bool(false)
+	}
+	return Bool(ev.Features[valueToString(args[0])])
+}
+
+// (features) - the enabled features, sorted
+func builtinFeatures(ev *Evaluator, args []Value, env *Env) Value {
+	names := ev.featureNames()
+	result := make([]Value, len(names))
+	for i, name := range names {
+		result[i] = Sym(name)
+	}
+	return Lst(result...)
+}
+
+// (enable-feature! 'name [on]) - turns a feature on (or off with false)
+func builtinEnableFeature(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Nil()
+	}
+	name := valueToString(args[0])
+	if len(args) > 1 && !args[1].IsTruthy() {
+		delete(ev.Features, name)
+		return Bool(false)
+	}
+	ev.Features[name] = true
+	return Bool(true)
+}
+
+func (ev *Evaluator) featureNames() []string {
+	names := make([]string, 0, len(ev.Features))
+	for name := range ev.Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func builtinMakeStack(ev *Evaluator, args []Value, env *Env) Value {
 	capacity := 16
 	if len(args) > 0 {
 		capacity = int(args[0].Number)
 	}
-	return Value{Type: TypeStack, Stack: NewStack(capacity)}
+	stack := NewStack(capacity)
+	if len(args) > 1 {
+		stack.Name = valueToString(args[1])
+	}
+	return Value{Type: TypeStack, Stack: stack}
 }
 
+// (make-queue [capacity] [name] [:bytes n])
 func builtinMakeQueue(ev *Evaluator, args []Value, env *Env) Value {
+	args, opts := keywordArgs(args)
 	capacity := 16
 	if len(args) > 0 {
 		capacity = int(args[0].Number)
 	}
-	return Value{Type: TypeQueue, Queue: NewQueue(capacity)}
+	queue := NewQueue(capacity)
+	if len(args) > 1 {
+		queue.Name = valueToString(args[1])
+	}
+	if n, ok := opts["bytes"]; ok && n.Type == TypeNumber {
+		queue.ByteCapacity = int(n.Number)
+	}
+	return Value{Type: TypeQueue, Queue: queue}
 }
 
 // Stack operations
@@ -1793,11 +4456,15 @@ func builtinPush(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 || args[0].Type != TypeStack {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Stack.Name, "write") {
+		return Sym("denied")
+	}
 	stack := args[0].Stack
 	if stack.IsFull() {
 		return Blocked(BlockStackFull)
 	}
 	stack.PushNow(args[1])
+	ev.traceOp("push!", stack.Name, "stack")
 	return Sym("ok")
 }
 
@@ -1805,11 +4472,15 @@ func builtinPop(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 || args[0].Type != TypeStack {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Stack.Name, "write") {
+		return Sym("denied")
+	}
 	stack := args[0].Stack
 	if stack.IsEmpty() {
 		return Blocked(BlockStackEmpty)
 	}
 	v, _ := stack.PopNow()
+	ev.traceOp("pop!", stack.Name, "stack")
 	return v
 }
 
@@ -1817,7 +4488,11 @@ func builtinPushNow(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 || args[0].Type != TypeStack {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Stack.Name, "write") {
+		return Sym("denied")
+	}
 	if args[0].Stack.PushNow(args[1]) {
+		ev.traceOp("push-now!", args[0].Stack.Name, "stack")
 		return Sym("ok")
 	}
 	return Sym("full")
@@ -1827,8 +4502,12 @@ func builtinPopNow(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 || args[0].Type != TypeStack {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Stack.Name, "write") {
+		return Sym("denied")
+	}
 	v, ok := args[0].Stack.PopNow()
 	if ok {
+		ev.traceOp("pop-now!", args[0].Stack.Name, "stack")
 		return v
 	}
 	return Sym("empty")
@@ -1838,6 +4517,9 @@ func builtinStackPeek(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 || args[0].Type != TypeStack {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Stack.Name, "read") {
+		return Sym("denied")
+	}
 	stack := args[0].Stack
 	if stack.IsEmpty() {
 		return Blocked(BlockStackEmpty)
@@ -1850,6 +4532,9 @@ func builtinStackPeekNow(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 || args[0].Type != TypeStack {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Stack.Name, "read") {
+		return Sym("denied")
+	}
 	v, ok := args[0].Stack.PeekNow()
 	if ok {
 		return v
@@ -1861,6 +4546,9 @@ func builtinStackRead(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 || args[0].Type != TypeStack {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Stack.Name, "read") {
+		return Sym("denied")
+	}
 	v, ok := args[0].Stack.Read(int(args[1].Number))
 	if ok {
 		return v
@@ -1872,6 +4560,9 @@ func builtinStackWrite(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 3 || args[0].Type != TypeStack {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Stack.Name, "write") {
+		return Sym("denied")
+	}
 	if args[0].Stack.Write(int(args[1].Number), args[2]) {
 		return Sym("ok")
 	}
@@ -1897,11 +4588,21 @@ func builtinSend(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 || args[0].Type != TypeQueue {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Queue.Name, "write") {
+		return Sym("denied")
+	}
 	queue := args[0].Queue
-	if queue.IsFull() {
+	if queue.Oversized(args[1]) {
+		return ev.oversizedSend(queue.Name, queue, args[1])
+	}
+	if !queue.Fits(args[1]) {
+		wait := chanWait{Queue: queue, Send: true, Value: args[1]}
+		ev.blockOnChannels("channel "+wait.String(), []chanWait{wait})
 		return Blocked(BlockQueueFull)
 	}
 	queue.SendNow(args[1])
+	ev.traceOp("send!", queue.Name, "queue")
+	ev.wakeChannelWaiters()
 	return Sym("ok")
 }
 
@@ -1909,11 +4610,18 @@ func builtinRecv(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 || args[0].Type != TypeQueue {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Queue.Name, "write") {
+		return Sym("denied")
+	}
 	queue := args[0].Queue
 	if queue.IsEmpty() {
+		wait := chanWait{Queue: queue}
+		ev.blockOnChannels("channel "+wait.String(), []chanWait{wait})
 		return Blocked(BlockQueueEmpty)
 	}
 	v, _ := queue.RecvNow()
+	ev.traceOp("recv!", queue.Name, "queue")
+	ev.wakeChannelWaiters()
 	return v
 }
 
@@ -1921,7 +4629,15 @@ func builtinSendNow(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 2 || args[0].Type != TypeQueue {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Queue.Name, "write") {
+		return Sym("denied")
+	}
+	if args[0].Queue.Oversized(args[1]) {
+		return ev.oversizedSend(args[0].Queue.Name, args[0].Queue, args[1])
+	}
 	if args[0].Queue.SendNow(args[1]) {
+		ev.traceOp("send-now!", args[0].Queue.Name, "queue")
+		ev.wakeChannelWaiters()
 		return Sym("ok")
 	}
 	return Sym("full")
@@ -1931,8 +4647,13 @@ func builtinRecvNow(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 || args[0].Type != TypeQueue {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Queue.Name, "write") {
+		return Sym("denied")
+	}
 	v, ok := args[0].Queue.RecvNow()
 	if ok {
+		ev.traceOp("recv-now!", args[0].Queue.Name, "queue")
+		ev.wakeChannelWaiters()
 		return v
 	}
 	return Sym("empty")
@@ -1942,6 +4663,9 @@ func builtinQueuePeek(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 || args[0].Type != TypeQueue {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Queue.Name, "read") {
+		return Sym("denied")
+	}
 	queue := args[0].Queue
 	if queue.IsEmpty() {
 		return Blocked(BlockQueueEmpty)
@@ -1954,6 +4678,9 @@ func builtinQueuePeekNow(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) < 1 || args[0].Type != TypeQueue {
 		return Nil()
 	}
+	if !ev.checkCap(args[0].Queue.Name, "read") {
+		return Sym("denied")
+	}
 	v, ok := args[0].Queue.PeekNow()
 	if ok {
 		return v
@@ -1985,7 +4712,9 @@ func builtinPrint(ev *Evaluator, args []Value, env *Env) Value {
 			parts[i] = a.String()
 		}
 	}
-	fmt.Println(strings.Join(parts, " "))
+	if ev.hostOutput() {
+		fmt.Println(strings.Join(parts, " "))
+	}
 	return Nil()
 }
 
@@ -1993,11 +4722,81 @@ func builtinPrintln(ev *Evaluator, args []Value, env *Env) Value {
 	return builtinPrint(ev, args, env) // same as print now
 }
 
-func builtinRepr(ev *Evaluator, args []Value, env *Env) Value {
-	if len(args) == 0 {
-		return Str("")
+// File I/O. Paths are relative to the working directory. Errors are
+// warnings and return nil. Copies of the world made for scenario search
+// can read files but never write them.
+
+// filePath checks that file access is allowed and returns the path argument
+func (ev *Evaluator) filePath(name string, args []Value) (string, bool) {
+	if ev.sandboxDenied(name) {
+		return "", false
 	}
-	return Str(args[0].String())
+	if !ev.FileAccess {
+		ev.warn(name+":denied", "%s: file access is disabled", name)
+		return "", false
+	}
+	if len(args) == 0 || (args[0].Type != TypeString && args[0].Type != TypeSymbol) {
+		ev.warn("", "%s: expected a path", name)
+		return "", false
+	}
+	return valueToString(args[0]), true
+}
+
+func builtinReadFile(ev *Evaluator, args []Value, env *Env) Value {
+	path, ok := ev.filePath("read-file", args)
+	if !ok {
+		return Nil()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		ev.warn("", "read-file: %v", err)
+		return Nil()
+	}
+	return Str(string(data))
+}
+
+func builtinWriteFile(ev *Evaluator, args []Value, env *Env) Value {
+	return ev.writeFile("write-file", args, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+}
+
+func builtinAppendFile(ev *Evaluator, args []Value, env *Env) Value {
+	return ev.writeFile("append-file", args, os.O_CREATE|os.O_WRONLY|os.O_APPEND)
+}
+
+// writeFile writes the display form of each argument after the path
+func (ev *Evaluator) writeFile(name string, args []Value, flag int) Value {
+	path, ok := ev.filePath(name, args)
+	if !ok {
+		return Nil()
+	}
+	if ev.Quiet {
+		return Bool(true)
+	}
+	var sb strings.Builder
+	for _, a := range args[1:] {
+		sb.WriteString(valueToString(a))
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if err == nil {
+		_, err = f.WriteString(sb.String())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		ev.warn("", "%s: %v", name, err)
+		return Nil()
+	}
+	return Bool(true)
+}
+
+func builtinFileExists(ev *Evaluator, args []Value, env *Env) Value {
+	path, ok := ev.filePath("file-exists?", args)
+	if !ok {
+		return Bool(false)
+	}
+	_, err := os.Stat(path)
+	return Bool(err == nil)
 }
 
 // ============================================================================
@@ -2010,55 +4809,291 @@ func builtinStringAppend(ev *Evaluator, args []Value, env *Env) Value {
 		switch arg.Type {
 		case TypeString:
 			sb.WriteString(arg.Str)
+		case TypeChar:
+			sb.WriteRune(rune(arg.Int))
 		case TypeSymbol:
 			sb.WriteString(arg.Symbol)
-		case TypeNumber:
-			if arg.Number == float64(int64(arg.Number)) {
-				sb.WriteString(fmt.Sprintf("%d", int64(arg.Number)))
-			} else {
-				sb.WriteString(fmt.Sprintf("%g", arg.Number))
-			}
 		default:
 			sb.WriteString(arg.String())
 		}
 	}
-	return Str(sb.String())
+	return Str(sb.String())
+}
+
+func builtinSymbolToString(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Str("")
+	}
+	if args[0].Type == TypeSymbol {
+		return Str(args[0].Symbol)
+	}
+	return Str(args[0].String())
+}
+
+func builtinStringToSymbol(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Sym("")
+	}
+	if args[0].Type == TypeString {
+		return Sym(args[0].Str)
+	}
+	if args[0].Type == TypeSymbol {
+		return args[0]
+	}
+	return Sym(args[0].String())
+}
+
+func builtinNumberToString(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Str("0")
+	}
+	return Str(args[0].String())
+}
+
+// (string->number s [radix]) - the number s spells, or nil if it spells
+// none. The whole string must be the number: no spaces or trailing text.
+// Radix 10 reads integers and floats; other radixes read integers only.
+func builtinStringToNumber(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeString {
+		ev.warn("", "string->number: expected a string")
+		return Nil()
+	}
+	radix := 10
+	if len(args) > 1 {
+		if !args[1].IsInt || args[1].Int < 2 || args[1].Int > 36 {
+			ev.warn("", "string->number: radix must be an integer from 2 to 36, got %s", args[1].String())
+			return Nil()
+		}
+		radix = int(args[1].Int)
+	}
+	s := args[0].Str
+	if n, err := strconv.ParseInt(s, radix, 64); err == nil {
+		return Int(n)
+	}
+	if radix != 10 || strings.ContainsAny(s, "_xXpP") {
+		return Nil()
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return Num(f)
+	}
+	return Nil()
+}
+
+// (substring s start [end]) - indices count characters, not bytes
+func builtinSubstring(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 || args[0].Type != TypeString {
+		return Str("")
+	}
+	runes := []rune(args[0].Str)
+	start := int(args[1].Number)
+	end := len(runes)
+	if len(args) > 2 && args[2].Type == TypeNumber {
+		end = int(args[2].Number)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > len(runes) {
+		end = len(runes)
+	}
+	if start >= end {
+		return Str("")
+	}
+	return Str(string(runes[start:end]))
+}
+
+// (string-split s [sep]) - without sep, splits on runs of whitespace
+func builtinStringSplit(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeString {
+		return Lst()
+	}
+	var parts []string
+	if len(args) > 1 && args[1].Type == TypeString {
+		parts = strings.Split(args[0].Str, args[1].Str)
+	} else {
+		parts = strings.Fields(args[0].Str)
+	}
+	result := make([]Value, len(parts))
+	for i, p := range parts {
+		result[i] = Str(p)
+	}
+	return Lst(result...)
+}
+
+// (string-join list [sep])
+func builtinStringJoin(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || !args[0].IsList() {
+		return Str("")
+	}
+	sep := ""
+	if len(args) > 1 && args[1].Type == TypeString {
+		sep = args[1].Str
+	}
+	parts := make([]string, len(args[0].List))
+	for i, item := range args[0].List {
+		parts[i] = valueToString(item)
+	}
+	return Str(strings.Join(parts, sep))
+}
+
+// (string-replace s old new) - replaces every occurrence
+func builtinStringReplace(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 3 || args[0].Type != TypeString {
+		return Str("")
+	}
+	return Str(strings.ReplaceAll(args[0].Str, valueToString(args[1]), valueToString(args[2])))
+}
+
+func builtinStringUpcase(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeString {
+		return Str("")
+	}
+	return Str(strings.ToUpper(args[0].Str))
+}
+
+func builtinStringDowncase(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeString {
+		return Str("")
+	}
+	return Str(strings.ToLower(args[0].Str))
+}
+
+func builtinStringContains(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 || args[0].Type != TypeString {
+		return Bool(false)
+	}
+	return Bool(strings.Contains(args[0].Str, valueToString(args[1])))
+}
+
+func builtinStringLength(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeString {
+		return Num(0)
+	}
+	return Int(int64(len([]rune(args[0].Str))))
+}
+
+// (string-index s sub) - character index of first match, or -1
+func builtinStringIndex(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 || args[0].Type != TypeString {
+		return Num(-1)
+	}
+	i := strings.Index(args[0].Str, valueToString(args[1]))
+	if i < 0 {
+		return Num(-1)
+	}
+	return Int(int64(len([]rune(args[0].Str[:i]))))
+}
+
+// (string->list s) - the chars of s
+func builtinStringToList(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeString {
+		ev.warn("", "string->list: expected a string")
+		return Lst()
+	}
+	var chars []Value
+	for _, r := range args[0].Str {
+		chars = append(chars, Char(r))
+	}
+	return Lst(chars...)
 }
 
-func builtinSymbolToString(ev *Evaluator, args []Value, env *Env) Value {
-	if len(args) == 0 {
+// (list->string lst) - the string of a list of chars
+func builtinListToString(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeList {
+		ev.warn("", "list->string: expected a list of chars")
 		return Str("")
 	}
-	if args[0].Type == TypeSymbol {
-		return Str(args[0].Symbol)
+	var sb strings.Builder
+	for _, c := range args[0].List {
+		if c.Type != TypeChar {
+			ev.warn("", "list->string: %s is not a char", c.String())
+			return Str("")
+		}
+		sb.WriteRune(rune(c.Int))
 	}
-	return Str(args[0].String())
+	return Str(sb.String())
 }
 
-func builtinStringToSymbol(ev *Evaluator, args []Value, env *Env) Value {
-	if len(args) == 0 {
-		return Sym("")
+// (char->number c) - the code point of c
+func builtinCharToNumber(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeChar {
+		ev.warn("", "char->number: expected a char")
+		return Nil()
 	}
-	if args[0].Type == TypeString {
-		return Sym(args[0].Str)
+	return Int(args[0].Int)
+}
+
+// (number->char n) - the char with code point n
+func builtinNumberToChar(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || !args[0].IsInt || !utf8.ValidRune(rune(args[0].Int)) || int64(rune(args[0].Int)) != args[0].Int {
+		ev.warn("", "number->char: expected a Unicode code point")
+		return Nil()
 	}
-	if args[0].Type == TypeSymbol {
-		return args[0]
+	return Char(rune(args[0].Int))
+}
+
+// regexArgs compiles the pattern and checks the string for the regex builtins
+func (ev *Evaluator) regexArgs(name string, args []Value, n int) (*regexp.Regexp, bool) {
+	if len(args) < n || args[1].Type != TypeString {
+		ev.warn("", "%s: expected a pattern and a string", name)
+		return nil, false
 	}
-	return Sym(args[0].String())
+	re, err := regexp.Compile(valueToString(args[0]))
+	if err != nil {
+		ev.warn("regex:"+valueToString(args[0]), "%s: %v", name, err)
+		return nil, false
+	}
+	return re, true
 }
 
-func builtinNumberToString(ev *Evaluator, args []Value, env *Env) Value {
-	if len(args) == 0 {
-		return Str("0")
+// (regex-match pattern s) - (match group...) for the first match, or nil.
+// Groups that didn't take part in the match are nil.
+func builtinRegexMatch(ev *Evaluator, args []Value, env *Env) Value {
+	re, ok := ev.regexArgs("regex-match", args, 2)
+	if !ok {
+		return Nil()
 	}
-	if args[0].Type == TypeNumber {
-		if args[0].Number == float64(int64(args[0].Number)) {
-			return Str(fmt.Sprintf("%d", int64(args[0].Number)))
+	loc := re.FindStringSubmatchIndex(args[1].Str)
+	if loc == nil {
+		return Nil()
+	}
+	groups := make([]Value, len(loc)/2)
+	for i := range groups {
+		if loc[2*i] < 0 {
+			groups[i] = Nil()
+		} else {
+			groups[i] = Str(args[1].Str[loc[2*i]:loc[2*i+1]])
 		}
-		return Str(fmt.Sprintf("%g", args[0].Number))
 	}
-	return Str(args[0].String())
+	return Lst(groups...)
+}
+
+// (regex-find-all pattern s [limit]) - every non-overlapping match, as strings
+func builtinRegexFindAll(ev *Evaluator, args []Value, env *Env) Value {
+	re, ok := ev.regexArgs("regex-find-all", args, 2)
+	if !ok {
+		return Lst()
+	}
+	limit := -1
+	if len(args) > 2 && args[2].Type == TypeNumber {
+		limit = int(args[2].Number)
+	}
+	matches := re.FindAllString(args[1].Str, limit)
+	result := make([]Value, len(matches))
+	for i, m := range matches {
+		result[i] = Str(m)
+	}
+	return Lst(result...)
+}
+
+// (regex-replace pattern s replacement) - replaces every match; $1 or ${name}
+// in the replacement expand to groups
+func builtinRegexReplace(ev *Evaluator, args []Value, env *Env) Value {
+	re, ok := ev.regexArgs("regex-replace", args, 3)
+	if !ok {
+		return Nil()
+	}
+	return Str(re.ReplaceAllString(args[1].Str, valueToString(args[2])))
 }
 
 // ============================================================================
@@ -2077,6 +5112,9 @@ func builtinRegistrySet(ev *Evaluator, args []Value, env *Env) Value {
 	} else {
 		return Nil()
 	}
+	if ev.sandboxDenied("registry-set!") || !ev.checkCap(name, "write") {
+		return Sym("denied")
+	}
 	ev.Registry[name] = args[1]
 	return args[1]
 }
@@ -2093,6 +5131,9 @@ func builtinRegistryGet(ev *Evaluator, args []Value, env *Env) Value {
 	} else {
 		return Nil()
 	}
+	if !ev.checkCap(name, "read") {
+		return Sym("denied")
+	}
 	if v, ok := ev.Registry[name]; ok {
 		return v
 	}
@@ -2101,7 +5142,7 @@ func builtinRegistryGet(ev *Evaluator, args []Value, env *Env) Value {
 
 func builtinRegistryKeys(ev *Evaluator, args []Value, env *Env) Value {
 	keys := make([]Value, 0, len(ev.Registry))
-	for k := range ev.Registry {
+	for _, k := range sortedKeys(ev.Registry) {
 		keys = append(keys, Sym(k))
 	}
 	return Lst(keys...)
@@ -2135,6 +5176,9 @@ func builtinRegistryDelete(ev *Evaluator, args []Value, env *Env) Value {
 	} else {
 		return Bool(false)
 	}
+	if ev.sandboxDenied("registry-delete!") || !ev.checkCap(name, "write") {
+		return Sym("denied")
+	}
 	if _, ok := ev.Registry[name]; ok {
 		delete(ev.Registry, name)
 		return Bool(true)
@@ -2142,6 +5186,304 @@ func builtinRegistryDelete(ev *Evaluator, args []Value, env *Env) Value {
 	return Bool(false)
 }
 
+// ============================================================================
+// Hash Map Builtins
+// ============================================================================
+
+// (make-map) or (make-map k1 v1 k2 v2 ...)
+func builtinMakeMap(ev *Evaluator, args []Value, env *Env) Value {
+	m := NewHashMap()
+	for i := 0; i+1 < len(args); i += 2 {
+		key := args[i].String()
+		m.Data[key] = args[i+1]
+		m.Keys[key] = args[i]
+	}
+	return Value{Type: TypeMap, Map: m}
+}
+
+// (map-get m key [default])
+func builtinMapGet(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 || args[0].Type != TypeMap {
+		return Nil()
+	}
+	if v, ok := args[0].Map.Data[args[1].String()]; ok {
+		return v
+	}
+	if len(args) > 2 {
+		return args[2]
+	}
+	return Nil()
+}
+
+// (map-set! m key value) - mutates m in place and returns it
+func builtinMapSet(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 3 || args[0].Type != TypeMap {
+		return Nil()
+	}
+	key := args[1].String()
+	args[0].Map.Data[key] = args[2]
+	args[0].Map.Keys[key] = args[1]
+	return args[0]
+}
+
+// (map-keys m) - keys in sorted order
+func builtinMapKeys(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeMap {
+		return Lst()
+	}
+	keys := make([]Value, 0, len(args[0].Map.Data))
+	for _, k := range args[0].Map.SortedKeys() {
+		keys = append(keys, args[0].Map.Keys[k])
+	}
+	return Lst(keys...)
+}
+
+// (assoc key alist) - the first (key value) entry for key, or nil
+func builtinAssoc(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 || args[1].Type != TypeList {
+		return Nil()
+	}
+	for _, entry := range args[1].List {
+		if entry.Type == TypeList && len(entry.List) > 0 && valuesEqual(entry.List[0], args[0]) {
+			return entry
+		}
+	}
+	return Nil()
+}
+
+// (assoc-set key value alist) - a copy of alist with key's entry replaced
+// by (key value), or with it added at the end
+func builtinAssocSet(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 3 || (args[2].Type != TypeList && args[2].Type != TypeNil) {
+		ev.warn("", "assoc-set: expected a key, a value and an association list")
+		return Nil()
+	}
+	entries := make([]Value, 0, len(args[2].List)+1)
+	found := false
+	for _, entry := range args[2].List {
+		if !found && entry.Type == TypeList && len(entry.List) > 0 && valuesEqual(entry.List[0], args[0]) {
+			entry, found = Lst(args[0], args[1]), true
+		}
+		entries = append(entries, entry)
+	}
+	if !found {
+		entries = append(entries, Lst(args[0], args[1]))
+	}
+	return Lst(entries...)
+}
+
+// (alist->map alist) - a map of each (key value) entry; an earlier entry
+// wins over a later one with the same key, as it does for assoc
+func builtinAlistToMap(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || (args[0].Type != TypeList && args[0].Type != TypeNil) {
+		ev.warn("", "alist->map: expected an association list")
+		return Nil()
+	}
+	m := NewHashMap()
+	for _, entry := range args[0].List {
+		if entry.Type != TypeList || len(entry.List) != 2 {
+			ev.warn("", "alist->map: %s is not a (key value) entry", entry.String())
+			return Nil()
+		}
+		k := entry.List[0].String()
+		if _, ok := m.Data[k]; !ok {
+			m.Keys[k] = entry.List[0]
+			m.Data[k] = entry.List[1]
+		}
+	}
+	return Value{Type: TypeMap, Map: m}
+}
+
+// (map->alist m) - ((key value) ...) in key order
+func builtinMapToAlist(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeMap {
+		ev.warn("", "map->alist: expected a map")
+		return Nil()
+	}
+	entries := make([]Value, 0, len(args[0].Map.Data))
+	for _, k := range args[0].Map.SortedKeys() {
+		entries = append(entries, Lst(args[0].Map.Keys[k], args[0].Map.Data[k]))
+	}
+	return Lst(entries...)
+}
+
+func builtinMapHas(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 || args[0].Type != TypeMap {
+		return Bool(false)
+	}
+	_, ok := args[0].Map.Data[args[1].String()]
+	return Bool(ok)
+}
+
+func builtinMapDelete(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 || args[0].Type != TypeMap {
+		return Bool(false)
+	}
+	key := args[1].String()
+	if _, ok := args[0].Map.Data[key]; ok {
+		delete(args[0].Map.Data, key)
+		delete(args[0].Map.Keys, key)
+		return Bool(true)
+	}
+	return Bool(false)
+}
+
+func builtinIsMap(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 {
+		return Bool(false)
+	}
+	return Bool(args[0].Type == TypeMap)
+}
+
+// ============================================================================
+// Set Builtins
+// ============================================================================
+//
+// Sets are mutable and, like maps, compare items by printed form. The
+// combining operations take sets or lists and return new sets.
+
+// setItems returns the items of a set or list argument
+func setItems(v Value) ([]Value, bool) {
+	switch v.Type {
+	case TypeSet:
+		return v.Set.Sorted(), true
+	case TypeList, TypeNil:
+		return v.List, true
+	}
+	return nil, false
+}
+
+func setOf(items []Value) Value {
+	set := NewHashSet()
+	for _, item := range items {
+		set.Add(item)
+	}
+	return Value{Type: TypeSet, Set: set}
+}
+
+// (make-set item...) - a set of the arguments
+func builtinMakeSet(ev *Evaluator, args []Value, env *Env) Value {
+	return setOf(args)
+}
+
+// (list->set lst)
+func builtinListToSet(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || !args[0].IsList() {
+		return setOf(nil)
+	}
+	return setOf(args[0].List)
+}
+
+// (set-add! s item...) - adds in place and returns s
+func builtinSetAdd(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeSet {
+		return Nil()
+	}
+	for _, item := range args[1:] {
+		args[0].Set.Add(item)
+	}
+	return args[0]
+}
+
+// (set-remove! s item) - true if item was there
+func builtinSetRemove(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 || args[0].Type != TypeSet {
+		return Bool(false)
+	}
+	key := args[1].String()
+	if _, ok := args[0].Set.Items[key]; ok {
+		delete(args[0].Set.Items, key)
+		return Bool(true)
+	}
+	return Bool(false)
+}
+
+func builtinSetMember(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 2 || args[0].Type != TypeSet {
+		return Bool(false)
+	}
+	return Bool(args[0].Set.Has(args[1]))
+}
+
+func builtinSetSize(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeSet {
+		return Int(0)
+	}
+	return Int(int64(len(args[0].Set.Items)))
+}
+
+// (set-union a b ...) - items in any argument
+func builtinSetUnion(ev *Evaluator, args []Value, env *Env) Value {
+	result := setOf(nil)
+	for _, arg := range args {
+		items, ok := setItems(arg)
+		if !ok {
+			ev.warn("", "set-union: expected sets or lists, got %s", arg.String())
+			return Nil()
+		}
+		for _, item := range items {
+			result.Set.Add(item)
+		}
+	}
+	return result
+}
+
+// (set-intersect a b ...) - items in every argument
+func builtinSetIntersect(ev *Evaluator, args []Value, env *Env) Value {
+	return ev.setFilter("set-intersect", args, true)
+}
+
+// (set-difference a b ...) - items of a in none of the others
+func builtinSetDifference(ev *Evaluator, args []Value, env *Env) Value {
+	return ev.setFilter("set-difference", args, false)
+}
+
+// setFilter keeps the items of the first argument that are in all of the
+// others (keepShared) or in none of them
+func (ev *Evaluator) setFilter(name string, args []Value, keepShared bool) Value {
+	if len(args) == 0 {
+		return setOf(nil)
+	}
+	var sets []*HashSet
+	for _, arg := range args {
+		items, ok := setItems(arg)
+		if !ok {
+			ev.warn("", "%s: expected sets or lists, got %s", name, arg.String())
+			return Nil()
+		}
+		sets = append(sets, setOf(items).Set)
+	}
+	result := setOf(nil)
+	for k, item := range sets[0].Items {
+		keep := true
+		for _, other := range sets[1:] {
+			if _, in := other.Items[k]; in != keepShared {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			result.Set.Items[k] = item
+		}
+	}
+	return result
+}
+
+// (set->list s) - items in sorted order
+func builtinSetToList(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || args[0].Type != TypeSet {
+		return Lst()
+	}
+	return Lst(args[0].Set.Sorted()...)
+}
+
+func builtinIsSet(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 {
+		return Bool(false)
+	}
+	return Bool(args[0].Type == TypeSet)
+}
+
 // ============================================================================
 // Type Tagging Builtins
 // ============================================================================
@@ -2239,11 +5581,19 @@ func (v Value) IsActor() bool {
 	return v.Type == TypeActor
 }
 
-// (spawn-actor name mailbox-size body)
-// Creates a new actor with the given name, mailbox size, and initial code
+// (spawn-actor name mailbox-size body [:bytes n] [:on-start f] [:on-stop f] [:on-block f] [:scheduler s])
+// Creates a new actor with the given name, mailbox size, and initial code,
+// in the current scheduler or s.
+// :bytes also caps the total size of the messages waiting in its mailbox.
+// The :on- hooks are thunks run as the actor before its first step, when
+// it finishes, and each time it blocks.
 func builtinSpawnActor(ev *Evaluator, args []Value, env *Env) Value {
+	if args, s, ok := schedulerOption(args); ok {
+		return ev.onScheduler("spawn-actor", s, func() Value { return builtinSpawnActor(ev, args, env) })
+	}
+	args, opts := keywordArgs(args)
 	if len(args) < 3 {
-		fmt.Fprintln(os.Stderr, "spawn-actor: need name, mailbox-size, body")
+		ev.warn("", "spawn-actor: need name, mailbox-size, body")
 		return Nil()
 	}
 	
@@ -2253,7 +5603,7 @@ func builtinSpawnActor(ev *Evaluator, args []Value, env *Env) Value {
 	} else if args[0].Type == TypeString {
 		name = args[0].Str
 	} else {
-		fmt.Fprintln(os.Stderr, "spawn-actor: name must be symbol or string")
+		ev.warn("", "spawn-actor: name must be symbol or string")
 		return Nil()
 	}
 	
@@ -2265,17 +5615,91 @@ func builtinSpawnActor(ev *Evaluator, args []Value, env *Env) Value {
 	// Create actor's own environment (inherits from global)
 	actorEnv := NewEnv(ev.GlobalEnv)
 	
-	// The body is a thunk (code to execute)
+	// The body is code to execute, or a function to call with the rest of
+	// the arguments
 	body := args[2]
+	if body.Type == TypeFunc || body.Type == TypeBuiltin {
+		body = callCode(body, args[3:])
+	} else if len(args) > 3 {
+		ev.warn("", "spawn-actor: arguments given with quoted code %s; pass a function to call with them", body.String())
+	}
 	
-	ev.Scheduler.AddActor(name, mailboxSize, actorEnv, body)
+	actor := ev.Scheduler.AddActor(name, mailboxSize, actorEnv, body)
+	if n, ok := opts["bytes"]; ok && n.Type == TypeNumber {
+		actor.Mailbox.ByteCapacity = int(n.Number)
+	}
+	if n, ok := opts["priority"]; ok && n.Type == TypeNumber {
+		actor.Priority = int(n.Number)
+	}
+	for _, hook := range []struct {
+		key  string
+		slot *Value
+	}{{"on-start", &actor.OnStart}, {"on-stop", &actor.OnStop}, {"on-block", &actor.OnBlock}} {
+		if fn, ok := opts[hook.key]; ok {
+			if fn.Type != TypeFunc && fn.Type != TypeBuiltin {
+				ev.warn("", "spawn-actor: :%s must be a function, got %s", hook.key, fn.String())
+				continue
+			}
+			*hook.slot = fn
+		}
+	}
 	
 	// AUTO-TRACE: log the spawn as a fact
-	ev.DatalogDB.AssertAtTime("spawned", ev.Scheduler.StepCount, Atom(name))
+	ev.DatalogDB.assertLifecycle("spawned", ev.now(), Atom(name))
 	
 	return ActorVal(name)
 }
 
+// (become code-or-function [args...]) - the step result that makes the
+// actor run code, or call the function with args, from its next step on
+func builtinBecome(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		ev.warn("", "become: need the code or function to run next")
+		return Nil()
+	}
+	return Lst(append([]Value{Sym("become")}, args...)...)
+}
+
+// nextCode is the code a (become ...) or (continue ...) result asks for:
+// code as it is, or a function to call with the values after it
+func nextCode(result Value) Value {
+	if fn := result.List[1]; fn.Type == TypeFunc || fn.Type == TypeBuiltin {
+		return callCode(fn, result.List[2:])
+	}
+	return result.List[1]
+}
+
+// callCode is code that calls fn with args as they are: lists and symbols
+// are quoted so they aren't evaluated again
+func callCode(fn Value, args []Value) Value {
+	code := []Value{fn}
+	for _, arg := range args {
+		if arg.Type == TypeList || arg.Type == TypeSymbol {
+			arg = Lst(Sym("quote"), arg)
+		}
+		code = append(code, arg)
+	}
+	return Lst(code...)
+}
+
+// (spawn mailbox-size body [args...] [options...]) - spawn-actor under a
+// fresh name, actor-1, actor-2, ...; returns the actor ref
+func builtinSpawn(ev *Evaluator, args []Value, env *Env) Value {
+	if args, s, ok := schedulerOption(args); ok {
+		return ev.onScheduler("spawn", s, func() Value { return builtinSpawn(ev, args, env) })
+	}
+	if positional, _ := keywordArgs(args); len(positional) < 2 {
+		ev.warn("", "spawn: need mailbox-size, body")
+		return Nil()
+	}
+	var name string
+	for name == "" || ev.Scheduler.GetActor(name) != nil {
+		ev.GensymCount++
+		name = fmt.Sprintf("actor-%d", ev.GensymCount)
+	}
+	return builtinSpawnActor(ev, append([]Value{Sym(name)}, args...), env)
+}
+
 // (self) - returns current actor's name
 func builtinSelf(ev *Evaluator, args []Value, env *Env) Value {
 	if ev.Scheduler.CurrentActor == "" {
@@ -2284,17 +5708,15 @@ func builtinSelf(ev *Evaluator, args []Value, env *Env) Value {
 	return Sym(ev.Scheduler.CurrentActor)
 }
 
-// (send-to! actor-name message)
-// Sends a message to the named actor's mailbox
+// (send-to! actor-name message [:priority n])
+// Sends a message to the named actor's mailbox, ahead of any with a
+// lower priority (default 0)
 // Blocks if mailbox is full
 // AUTO-TRACES: asserts (sent from to msg time) fact
 func builtinSendTo(ev *Evaluator, args []Value, env *Env) Value {
+	args, opts := keywordArgs(args)
 	if len(args) < 2 {
-		errKey := "send-to-args"
-		if !ev.SeenErrors[errKey] {
-			ev.SeenErrors[errKey] = true
-			fmt.Fprintln(os.Stderr, "send-to!: need actor-name and message")
-		}
+		ev.warn("send-to-args", "send-to!: need actor-name and message")
 		return Nil()
 	}
 	
@@ -2306,50 +5728,116 @@ func builtinSendTo(ev *Evaluator, args []Value, env *Env) Value {
 	} else if args[0].Type == TypeActor {
 		targetName = args[0].Symbol
 	} else {
-		errKey := "send-to-type"
-		if !ev.SeenErrors[errKey] {
-			ev.SeenErrors[errKey] = true
-			fmt.Fprintln(os.Stderr, "send-to!: target must be symbol, string, or actor ref (use 'actor-name)")
-		}
+		ev.warn("send-to-type", "send-to!: target must be symbol, string, or actor ref (use 'actor-name)")
 		return Nil()
 	}
 	
 	target := ev.Scheduler.GetActor(targetName)
     ev.markGuardSeen() // CSP: send is a synchronization point
 	if target == nil {
-		errKey := "send-to-unknown:" + targetName
-		if !ev.SeenErrors[errKey] {
-			ev.SeenErrors[errKey] = true
-			fmt.Fprintf(os.Stderr, "send-to!: unknown actor %s\n", targetName)
-		}
+		ev.warn("send-to-unknown:"+targetName, "send-to!: unknown actor %s", targetName)
 		return Nil()
 	}
 	
-	message := args[1]
-	
-	if target.Mailbox.SendNow(message) {
-		// AUTO-TRACE: log the send as a fact
-		sender := ev.Scheduler.CurrentActor
-		if sender == "" {
-			sender = "external"
-		}
-		ev.DatalogDB.AssertAtTime("sent", ev.Scheduler.StepCount,
-			Atom(sender), Atom(targetName), ValueToTerm(message))
-		
-		// Message sent successfully
-		// If target was blocked on receive, unblock it
-		if target.State == ActorBlocked && strings.HasPrefix(target.BlockedOn, "recv") {
-			ev.Scheduler.UnblockActor(targetName)
-		}
+	priority := 0
+	if p, ok := opts["priority"]; ok && p.Type == TypeNumber {
+		priority = int(p.Number)
+	}
+	if ev.Scheduler.Faults != nil {
+		return ev.sendFaulty(target, args[1], priority)
+	}
+	return ev.send(target, args[1], priority)
+}
+
+// send puts message in target's mailbox, blocking the running actor while
+// there is no room for it
+func (ev *Evaluator) send(target *Actor, message Value, priority int) Value {
+	if target.Mailbox.Oversized(message) {
+		return ev.oversizedSend(target.Name, target.Mailbox, message)
+	}
+	if target.Mailbox.SendStamped(message, priority, ev.stamp()) {
+		ev.delivered(target, message)
 		return Sym("ok")
+	}
+	return ev.sendBlocked(target, message)
+}
+
+// delivered follows up a message just put in target's mailbox: it logs
+// the send, charges for it, and wakes target if it is waiting to receive
+func (ev *Evaluator) delivered(target *Actor, message Value) {
+	// AUTO-TRACE: log the send as a fact
+	sender := ev.Scheduler.CurrentActor
+	if a := ev.Scheduler.GetActor(sender); a != nil {
+		a.Sent++
 	} else {
-		// Mailbox full, block sender
-		if ev.Scheduler.CurrentActor != "" {
-			ev.Scheduler.BlockActor(ev.Scheduler.CurrentActor, 
-				fmt.Sprintf("send-to %s (full)", targetName))
+		sender = "external"
+	}
+	ev.TraceSend(sender, target.Name, message)
+	if sender == ev.tracing() {
+		ev.TraceGuard(sender, "send")
+	}
+	ev.assertSentStamp(sender, target.Name, message, ev.stamp())
+	ev.emit(SchedEvent{Kind: EventMessageSent, Actor: sender, Target: target.Name, Message: message})
+	if perUnit, ok := ev.Costs.Table["message-size"]; ok {
+		ev.addCost("message-size", perUnit*float64(len(valueToString(message))))
+	}
+	if target.State == ActorBlocked && (strings.HasPrefix(target.BlockedOn, "recv") ||
+		strings.HasPrefix(target.BlockedOn, "ask") && target.isReply(message)) {
+		ev.Scheduler.UnblockActor(target.Name)
+	}
+}
+
+// sendBlocked blocks the running actor until target's mailbox, which is
+// full or out of bytes, has room for message
+func (ev *Evaluator) sendBlocked(target *Actor, message Value) Value {
+	if ev.Scheduler.CurrentActor != "" {
+		reason := fmt.Sprintf("send-to %s (full)", target.Name)
+		if !target.Mailbox.IsFull() {
+			reason = fmt.Sprintf("send-to %s (%d bytes)", target.Name, valueSize(message))
 		}
-		return Blocked(BlockQueueFull)
+		ev.Scheduler.BlockActor(ev.Scheduler.CurrentActor, reason)
+	}
+	return Blocked(BlockQueueFull)
+}
+
+// oversizedSend records a message too big for a queue's byte capacity.
+// It can never be delivered, so the send fails rather than blocking.
+// AUTO-TRACES: asserts (oversized from to size limit time) fact
+func (ev *Evaluator) oversizedSend(to string, q *BoundedQueue, message Value) Value {
+	sender := ev.Scheduler.CurrentActor
+	if sender == "" {
+		sender = "external"
+	}
+	size := valueSize(message)
+	ev.DatalogDB.AssertAtTime("oversized", ev.now(),
+		Atom(sender), Atom(to), NumTerm(float64(size)), NumTerm(float64(q.ByteCapacity)))
+	ev.warn("oversized:"+sender+":"+to, "%s: %d-byte message exceeds %s's %d-byte capacity",
+		sender, size, to, q.ByteCapacity)
+	return Sym("oversized")
+}
+
+// (message-size v) - approximate size of a message in bytes
+func builtinMessageSize(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Int(0)
+	}
+	return Int(int64(valueSize(args[0])))
+}
+
+// (mailbox-bytes [actor-or-queue]) - bytes waiting in a mailbox or queue
+func builtinMailboxBytes(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) > 0 && args[0].Type == TypeQueue {
+		return Int(int64(args[0].Queue.Bytes()))
+	}
+	name := ev.Scheduler.CurrentActor
+	if len(args) > 0 {
+		name = valueToString(args[0])
+	}
+	actor := ev.Scheduler.GetActor(name)
+	if actor == nil {
+		return Int(0)
 	}
+	return Int(int64(actor.Mailbox.Bytes()))
 }
 
 // (receive!) - receive from own mailbox, blocks if empty
@@ -2357,7 +5845,7 @@ func builtinSendTo(ev *Evaluator, args []Value, env *Env) Value {
 func builtinReceive(ev *Evaluator, args []Value, env *Env) Value {
     ev.markGuardSeen() // CSP: mark guard seen
 	if ev.Scheduler.CurrentActor == "" {
-		fmt.Fprintln(os.Stderr, "receive!: no current actor")
+		ev.warn("receive-no-actor", "receive!: no current actor")
 		return Nil()
 	}
 	
@@ -2366,10 +5854,12 @@ func builtinReceive(ev *Evaluator, args []Value, env *Env) Value {
 		return Nil()
 	}
 	
-	if msg, ok := actor.Mailbox.RecvNow(); ok {
+	if msg, stamp, ok := actor.Mailbox.RecvStamped(); ok {
+		actor.Received++
+		actor.mergeClock(stamp)
 		// AUTO-TRACE: log the receive as a fact
-		ev.DatalogDB.AssertAtTime("received", ev.Scheduler.StepCount,
-			Atom(ev.Scheduler.CurrentActor), ValueToTerm(msg))
+		ev.TraceReceive(ev.Scheduler.CurrentActor, msg)
+		ev.assertReceivedStamp(actor, msg)
 		return msg
 	} else {
 		// Mailbox empty, block
@@ -2381,7 +5871,7 @@ func builtinReceive(ev *Evaluator, args []Value, env *Env) Value {
 // (receive-now!) - non-blocking receive, returns 'empty if nothing
 func builtinReceiveNow(ev *Evaluator, args []Value, env *Env) Value {
 	if ev.Scheduler.CurrentActor == "" {
-		fmt.Fprintln(os.Stderr, "receive-now!: no current actor")
+		ev.warn("receive-now-no-actor", "receive-now!: no current actor")
 		return Sym("empty")
 	}
 	
@@ -2390,7 +5880,9 @@ func builtinReceiveNow(ev *Evaluator, args []Value, env *Env) Value {
 		return Sym("empty")
 	}
 	
-	if msg, ok := actor.Mailbox.RecvNow(); ok {
+	if msg, stamp, ok := actor.Mailbox.RecvStamped(); ok {
+		actor.Received++
+		actor.mergeClock(stamp)
 		return msg
 	}
 	return Sym("empty")
@@ -2412,7 +5904,7 @@ func builtinMailboxEmpty(ev *Evaluator, args []Value, env *Env) Value {
 func builtinMailboxFull(ev *Evaluator, args []Value, env *Env) Value {
 	var targetName string
 	if len(args) > 0 {
-		if args[0].Type == TypeSymbol {
+		if args[0].Type == TypeSymbol || args[0].Type == TypeActor {
 			targetName = args[0].Symbol
 		} else if args[0].Type == TypeString {
 			targetName = args[0].Str
@@ -2444,107 +5936,246 @@ func builtinDone(ev *Evaluator, args []Value, env *Env) Value {
 	return Sym("done")
 }
 
-// (run-scheduler max-steps) - run the scheduler
+// (run-scheduler max-steps) or (run-scheduler config) - run the scheduler;
+// :scheduler s runs s instead of the current one
 func builtinRunScheduler(ev *Evaluator, args []Value, env *Env) Value {
-	maxSteps := int64(10000)
-	if len(args) > 0 && args[0].Type == TypeNumber {
-		maxSteps = int64(args[0].Number)
+	if args, s, ok := schedulerOption(args); ok {
+		return ev.onScheduler("run-scheduler", s, func() Value { return builtinRunScheduler(ev, args, env) })
 	}
-	
-	ev.Scheduler.MaxSteps = maxSteps
+	result := runScheduler(ev, args)
+	ev.emit(SchedEvent{Kind: EventRunFinished, Result: result})
+	return result
+}
+
+// (scheduler-step!) - run one actor step; returns (actor result state),
+// or how the run ended if no actor can run
+func builtinSchedulerStep(ev *Evaluator, args []Value, env *Env) Value {
+	actor, end := ev.nextStep()
+	if actor == nil {
+		return end
+	}
+	result := ev.stepActor(actor)
+	ev.Scheduler.CurrentActor = ""
+	return Lst(Sym(actor.Name), result, Sym(actor.StateName()))
+}
+
+// (runnable-actors) - the actors that can run, in the order round-robin
+// would run them
+func builtinRunnableActors(ev *Evaluator, args []Value, env *Env) Value {
+	names := make([]Value, 0, len(ev.Scheduler.RunQueue))
+	for _, name := range ev.Scheduler.RunQueue {
+		names = append(names, Sym(name))
+	}
+	return Lst(names...)
+}
+
+func runScheduler(ev *Evaluator, args []Value) Value {
+	cfg, configured := ev.parseRunConfig(args)
+	if configured {
+		return ev.runConfigured(cfg)
+	}
+	ev.Scheduler.MaxSteps = cfg.MaxSteps
 	ev.Scheduler.StepCount = 0
+	return ev.runSteps(cfg.MaxSteps, nil)
+}
+
+// runSteps schedules actors from the current step until maxSteps,
+// completion or deadlock. afterStep, if set, is called after every step,
+// when the world is between steps; a non-nil result ends the run with it.
+func (ev *Evaluator) runSteps(maxSteps int64, afterStep func() Value) Value {
+	// Top-level code after the run is not attributed to the last actor
+	defer func() { ev.Scheduler.CurrentActor = "" }()
 	
 	for ev.Scheduler.StepCount < maxSteps {
-		// Check termination conditions
-		if ev.Scheduler.AllDone() {
-			return Lst(Sym("completed"), Num(float64(ev.Scheduler.StepCount)))
-		}
-		if ev.Scheduler.IsDeadlocked() {
-			// Return deadlock info
-			blocked := make([]Value, 0)
-			for name, actor := range ev.Scheduler.Actors {
-				if actor.State == ActorBlocked {
-					blocked = append(blocked, Lst(Sym(name), Str(actor.BlockedOn)))
-				}
-			}
-			return Lst(Sym("deadlock"), Num(float64(ev.Scheduler.StepCount)), Lst(blocked...))
-		}
-		
-		// Get next actor
-		actor := ev.Scheduler.NextActor()
+		actor, end := ev.nextStep()
 		if actor == nil {
-			// No runnable actors but not deadlocked - all must be done
-			return Lst(Sym("completed"), Num(float64(ev.Scheduler.StepCount)))
+			return end
 		}
-        
-		ev.resetCSPState(actor.Name) // CSP: reset for new step
-		
-		if ev.Scheduler.Trace {
-			fmt.Printf("[%d] Running %s\n", ev.Scheduler.StepCount, actor.Name)
-		}
-		
-		// Execute one step of actor's code
-		if ev.Scheduler.Trace {
-			fmt.Printf("    code: %s\n", actor.Code.String())
+
+		violations := len(actor.CSPViolations)
+		ev.stepActor(actor)
+		if ev.fuelSpent() {
+			return Blocked(BlockOutOfFuel)
 		}
-		result := ev.Eval(actor.Code, actor.Env)
-		actor.Result = result
-		ev.Scheduler.StepCount++
-		
-		if ev.Scheduler.Trace {
-			fmt.Printf("    result: %s\n", result.String())
+		if ev.Scheduler.CSPAbort && len(actor.CSPViolations) > violations {
+			return Lst(Sym("csp-violation"), Int(ev.Scheduler.StepCount), Sym(actor.Name),
+				Str(actor.CSPViolations[violations]))
 		}
-		
-		// Check result
-		if result.Type == TypeBlocked {
-			// Already blocked by the operation
-			if ev.Scheduler.Trace {
-				fmt.Printf("    %s blocked: %s\n", actor.Name, actor.BlockedOn)
+		if afterStep != nil {
+			if stop := afterStep(); stop.Type != TypeNil {
+				return stop
 			}
-		} else if result.Type == TypeSymbol && result.Symbol == "yield" {
-			// Yielded voluntarily - stays runnable, re-run same code
-			if ev.Scheduler.Trace {
-				fmt.Printf("    %s yielded\n", actor.Name)
-			}
-		} else if result.Type == TypeSymbol && result.Symbol == "done" {
-			// Actor finished
-			ev.Scheduler.MarkDone(actor.Name)
-			if ev.Scheduler.Trace {
-				fmt.Printf("    %s done\n", actor.Name)
-			}
-		} else if result.IsList() && len(result.List) >= 2 {
-			// Check for (next-state new-code) or (become new-code)
-			if result.List[0].IsSymbol() && result.List[0].Symbol == "become" {
-				// AUTO-TRACE: log state change
-				oldState := extractStateName(actor.Code)
-				newState := extractStateName(result.List[1])
-				if oldState != newState {
-					ev.DatalogDB.AssertAtTime("state-change", ev.Scheduler.StepCount,
-						Atom(actor.Name), Atom(oldState), Atom(newState))
-				}
-				
-				// Change actor's code
-				actor.Code = result.List[1]
-				if ev.Scheduler.Trace {
-					fmt.Printf("    %s become %s\n", actor.Name, result.List[1].String())
-				}
-			} else if result.List[0].IsSymbol() && result.List[0].Symbol == "continue" {
-				// Update code and keep running
-				actor.Code = result.List[1]
+		}
+	}
+	
+	return Lst(Sym("max-steps"), Int(int64(ev.Scheduler.StepCount)))
+}
+
+// nextStep readies the world for the next step, delivering the stimuli
+// and timers that are due, and picks the actor to run it. When none can
+// run, it returns nil and how the run ended: completed, deadlock or
+// quiescent.
+func (ev *Evaluator) nextStep() (*Actor, Value) {
+	for {
+		ev.deliverStimuli(false)
+		ev.deliverTimers(false)
+		// Nothing will happen until the next scripted stimulus or timer arrives
+		if !ev.Scheduler.IsDeadlocked() {
+			break
+		}
+		if !(ev.timerBeforeStimulus() && ev.deliverTimers(true) > 0 ||
+			ev.deliverStimuli(true) > 0 || ev.deliverTimers(true) > 0) {
+			break
+		}
+	}
+	ev.noteLifecycle(ev.now())
+	// Check termination conditions
+	if ev.Scheduler.AllDone() {
+		return nil, Lst(Sym("completed"), Int(int64(ev.Scheduler.StepCount)))
+	}
+	if ev.Scheduler.IsDeadlocked() {
+		if ev.Scheduler.Quiescence && ev.Scheduler.IsQuiescent() {
+			return nil, Lst(Sym("quiescent"), Int(ev.Scheduler.StepCount))
+		}
+		// Return deadlock info
+		blocked := make([]Value, 0)
+		for _, name := range ev.Scheduler.Names() {
+			if actor := ev.Scheduler.Actors[name]; actor.State == ActorBlocked {
+				blocked = append(blocked, Lst(Sym(name), Str(actor.BlockedOn)))
 			}
 		}
-		
-		// Try to unblock actors whose conditions may have changed
-		ev.tryUnblockActors()
+		return nil, Lst(Sym("deadlock"), Int(int64(ev.Scheduler.StepCount)), Lst(blocked...))
+	}
+
+	// Get next actor
+	actor := ev.nextActor()
+	if actor == nil {
+		// No runnable actors but not deadlocked - all must be done
+		return nil, Lst(Sym("completed"), Int(int64(ev.Scheduler.StepCount)))
+	}
+	return actor, Nil()
+}
+
+// stepActor runs one step of actor's code and applies the outcome:
+// blocking, yielding, finishing, or becoming new code.
+func (ev *Evaluator) stepActor(actor *Actor) Value {
+	// Stack traces inside the step start at the actor's code, not at
+	// whatever top-level expression is running the scheduler. Steps reuse
+	// one buffer; a step nested in this one gets its own.
+	outer, buf := ev.Exprs, ev.stepExprs
+	ev.stepExprs = nil
+	ev.Exprs = buf[:0]
+	defer func() { ev.stepExprs, ev.Exprs = ev.Exprs[:0], outer }()
+
+	ev.resetCSPState(actor.Name) // CSP: reset for new step
+	ev.emit(SchedEvent{Kind: EventActorScheduled, Actor: actor.Name, Message: actor.Code})
+	if actor.State != ActorRunnable {
+		// A schedule hook stopped it
+		return Nil()
 	}
+	ev.tick(actor)
+	if !actor.Started {
+		actor.Started = true
+		ev.runHook(actor, "on-start", actor.OnStart)
+	}
+	
+	// Execute one step of actor's code, replaying what a blocked try
+	// of it already did
+	progress := actor.progressMark()
+	journaling := ev.journaling
+	ev.journaling = actor
+	ev.startJournal(actor)
+	result := ev.Eval(actor.Code, actor.Env)
+	ev.endJournal(actor, result)
+	ev.journaling = journaling
+	at := ev.now() // When the step happened, for its lifecycle facts
+	actor.Result = result
+	actor.Steps++
+	ev.Scheduler.StepCount++
 	
-	return Lst(Sym("max-steps"), Num(float64(ev.Scheduler.StepCount)))
+	// Check result
+	if actor.ExitReason.Type != TypeNil {
+		// Killed during its own step, so it has already stopped
+		ev.Scheduler.MarkDone(actor.Name)
+	} else if result.Type == TypeBlocked {
+		// Already blocked by the operation
+		ev.emit(SchedEvent{Kind: EventActorBlocked, Actor: actor.Name, Reason: actor.BlockedOn})
+		ev.runHook(actor, "on-block", actor.OnBlock)
+	} else if result.Type == TypeSymbol && result.Symbol == "yield" {
+		// Yielded voluntarily - stays runnable, re-run same code
+	} else if result.Type == TypeSymbol && result.Symbol == "done" {
+		// Actor finished
+		ev.Scheduler.MarkDone(actor.Name)
+		ev.runHook(actor, "on-stop", actor.OnStop)
+		ev.actorExited(actor, result)
+	} else if result.Type == TypeTagged && result.Tagged.Tag == "error" {
+		// Stopped on an error; the builtin that returned it has warned
+		ev.Scheduler.MarkDone(actor.Name)
+		ev.runHook(actor, "on-stop", actor.OnStop)
+		ev.actorExited(actor, result)
+	} else if result.IsList() && len(result.List) >= 2 {
+		// Check for (next-state new-code) or (become new-code)
+		if result.List[0].IsSymbol() && result.List[0].Symbol == "become" {
+			// AUTO-TRACE: log state change
+			code := nextCode(result)
+			oldState := extractStateName(actor.Code)
+			newState := extractStateName(code)
+			if oldState != newState {
+				ev.DatalogDB.AssertAtTime("state-change", ev.now(),
+					Atom(actor.Name), Atom(oldState), Atom(newState))
+			}
+			ev.DatalogDB.assertLifecycle("became", at, Atom(actor.Name), Atom(newState))
+			
+			// Change actor's code
+			actor.Code = code
+			actor.Becomes++
+		} else if result.List[0].IsSymbol() && result.List[0].Symbol == "continue" {
+			// Update code and keep running
+			actor.Code = nextCode(result)
+			actor.Becomes++
+			ev.DatalogDB.assertLifecycle("became", at, Atom(actor.Name), Atom(extractStateName(actor.Code)))
+		}
+	}
+
+	ev.spendBudget(actor)
+	ev.noteProgress(actor, progress)
+	ev.emit(SchedEvent{Kind: EventActorStepped, Actor: actor.Name, Result: result})
+
+	// Try to unblock actors whose conditions may have changed
+	ev.tryUnblockActors()
+	ev.noteLifecycle(at)
+	return result
+}
+
+// runHook calls one of actor's lifecycle hooks, if it has it. Hooks run as
+// the actor but can't change its state: a hook that would block is
+// abandoned with a warning and the actor carries on as before.
+func (ev *Evaluator) runHook(actor *Actor, hook string, fn Value) {
+	if fn.Type == TypeNil {
+		return
+	}
+	state, blockedOn := actor.State, actor.BlockedOn
+	if ev.apply(fn, nil, actor.Env).Type != TypeBlocked {
+		return
+	}
+	ev.warn("hook-blocked:"+actor.Name+":"+hook, "%s: %s hook blocked on %s; hooks must not block",
+		actor.Name, hook, actor.BlockedOn)
+	switch state {
+	case ActorRunnable:
+		ev.Scheduler.UnblockActor(actor.Name)
+	case ActorDone:
+		ev.Scheduler.MarkDone(actor.Name)
+	default:
+		actor.State, actor.BlockedOn = state, blockedOn
+	}
 }
 
 // extractStateName gets the function name from a code expression
 // (counter-loop 5) → "counter-loop"
 // (idle) → "idle"
 // 'done → "done"
+// a call of a function defined as counter-loop → "counter-loop", of a
+// lambda → "lambda"
 func extractStateName(code Value) string {
 	if code.Type == TypeSymbol {
 		return code.Symbol
@@ -2553,13 +6184,21 @@ func extractStateName(code Value) string {
 		if code.List[0].Type == TypeSymbol {
 			return code.List[0].Symbol
 		}
+		if fn := code.List[0]; fn.Type == TypeFunc {
+			if fn.Func.Name != "" {
+				return fn.Func.Name
+			}
+			return "lambda"
+		}
 	}
 	return "unknown"
 }
 
-// Try to unblock actors that can now proceed
+// Try to unblock actors that can now proceed, in name order so they
+// rejoin the run queue the same way every run
 func (ev *Evaluator) tryUnblockActors() {
-	for name, actor := range ev.Scheduler.Actors {
+	for _, name := range ev.Scheduler.Names() {
+		actor := ev.Scheduler.Actors[name]
 		if actor.State != ActorBlocked {
 			continue
 		}
@@ -2569,6 +6208,11 @@ func (ev *Evaluator) tryUnblockActors() {
 			if !actor.Mailbox.IsEmpty() {
 				ev.Scheduler.UnblockActor(name)
 			}
+		} else if strings.HasPrefix(actor.BlockedOn, "ask") {
+			// Blocked in ask! - check if the reply has arrived
+			if _, ok := actor.Mailbox.Find(actor.isReply); ok {
+				ev.Scheduler.UnblockActor(name)
+			}
 		} else if strings.HasPrefix(actor.BlockedOn, "send-to ") {
 			// Blocked on send - check if target mailbox has space
 			parts := strings.Split(actor.BlockedOn, " ")
@@ -2576,7 +6220,12 @@ func (ev *Evaluator) tryUnblockActors() {
 				targetName := parts[1]
 				target := ev.Scheduler.GetActor(targetName)
     ev.markGuardSeen() // CSP: send is a synchronization point
-				if target != nil && !target.Mailbox.IsFull() {
+				// A sender blocked for bytes waits until its message fits
+				var size int
+				if len(parts) >= 4 && parts[3] == "bytes)" {
+					fmt.Sscanf(parts[2], "(%d", &size)
+				}
+				if target != nil && target.Mailbox.HasRoom(size) {
 					ev.Scheduler.UnblockActor(name)
 				}
 			}
@@ -2584,27 +6233,66 @@ func (ev *Evaluator) tryUnblockActors() {
 	}
 }
 
-// (scheduler-status) - print scheduler state
+// (scheduler-status) - each actor, sorted by name, as
+// (name state blocked-on mailbox-len mailbox-cap steps-run)
 func builtinSchedulerStatus(ev *Evaluator, args []Value, env *Env) Value {
-	fmt.Print(ev.Scheduler.Status())
+	s := ev.Scheduler
+	status := make([]Value, 0, len(s.Actors))
+	for _, name := range s.Names() {
+		actor := s.Actors[name]
+		status = append(status, Lst(
+			Sym(name),
+			Sym(actor.StateName()),
+			Str(actor.BlockedOn),
+			Int(int64(len(actor.Mailbox.Data))),
+			Int(int64(actor.Mailbox.Capacity)),
+			Int(actor.Steps),
+		))
+	}
+	return Lst(status...)
+}
+
+// (print-scheduler-status) - print scheduler state
+func builtinPrintSchedulerStatus(ev *Evaluator, args []Value, env *Env) Value {
+	if !ev.Sandbox {
+		fmt.Print(ev.Scheduler.Status())
+	}
 	return Nil()
 }
 
 // (set-trace! bool) - enable/disable execution tracing
 func builtinSetTrace(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) > 0 {
+		if args[0].IsTruthy() && ev.sandboxDenied("set-trace!") {
+			return Bool(ev.Scheduler.Trace)
+		}
 		ev.Scheduler.Trace = args[0].IsTruthy()
 	}
 	return Bool(ev.Scheduler.Trace)
 }
 
+// (set-quiescence! on) - have run-scheduler report (quiescent n) rather
+// than deadlock when every actor waits on an empty mailbox
+func builtinSetQuiescence(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) > 0 {
+		ev.Scheduler.Quiescence = args[0].IsTruthy()
+	}
+	return Bool(ev.Scheduler.Quiescence)
+}
+
+// (quiescent?) - true if every actor that isn't done waits on an empty
+// mailbox and no timers or stimuli are still to come
+func builtinQuiescent(ev *Evaluator, args []Value, env *Env) Value {
+	return Bool(len(ev.Scheduler.Actors) > 0 && ev.Scheduler.IsQuiescent())
+}
+
 // (actor-state name) - get actor's current state
 func builtinActorState(ev *Evaluator, args []Value, env *Env) Value {
 	if len(args) == 0 {
 		return Nil()
 	}
 	var name string
-	if args[0].Type == TypeSymbol {
+	if args[0].Type == TypeSymbol || args[0].Type == TypeActor {
 		name = args[0].Symbol
 	} else if args[0].Type == TypeString {
 		name = args[0].Str
@@ -2617,28 +6305,59 @@ func builtinActorState(ev *Evaluator, args []Value, env *Env) Value {
 		return Nil()
 	}
 	
-	state := "unknown"
-	switch actor.State {
-	case ActorRunnable:
-		state = "runnable"
-	case ActorBlocked:
-		state = "blocked"
-	case ActorDone:
-		state = "done"
-	}
-	
 	return Lst(
-		Sym(state),
+		Sym(actor.StateName()),
 		Str(actor.BlockedOn),
-		Num(float64(len(actor.Mailbox.Data))),
-		Num(float64(actor.Mailbox.Capacity)),
+		Int(int64(len(actor.Mailbox.Data))),
+		Int(int64(actor.Mailbox.Capacity)),
 	)
 }
 
+// (whereis name) - a ref to the named actor while it is alive; nil once it
+// is done or if there is no such actor
+func builtinWhereis(ev *Evaluator, args []Value, env *Env) Value {
+	actor := ev.Scheduler.GetActor(valueToString(args[0]))
+	if actor == nil || actor.State == ActorDone {
+		return Nil()
+	}
+	return ActorVal(actor.Name)
+}
+
+// (actor-exists? name) - true if an actor of that name was spawned, done
+// or not
+func builtinActorExists(ev *Evaluator, args []Value, env *Env) Value {
+	return Bool(ev.Scheduler.GetActor(valueToString(args[0])) != nil)
+}
+
+// (actor-mailbox-size name) - {messages n capacity n bytes n byte-capacity n};
+// a byte-capacity of 0 means no byte limit
+func builtinActorMailboxSize(ev *Evaluator, args []Value, env *Env) Value {
+	actor := ev.Scheduler.GetActor(valueToString(args[0]))
+	if actor == nil {
+		return Nil()
+	}
+	return mapValue(map[string]Value{
+		"messages":      Int(int64(len(actor.Mailbox.Data))),
+		"capacity":      Int(int64(actor.Mailbox.Capacity)),
+		"bytes":         Int(int64(actor.Mailbox.Bytes())),
+		"byte-capacity": Int(int64(actor.Mailbox.ByteCapacity)),
+	})
+}
+
+// (actor-result name) - what the actor's last step returned; nil before
+// its first
+func builtinActorResult(ev *Evaluator, args []Value, env *Env) Value {
+	actor := ev.Scheduler.GetActor(valueToString(args[0]))
+	if actor == nil {
+		return Nil()
+	}
+	return actor.Result
+}
+
 // (list-actors-sched) - list all actors in scheduler
 func builtinListActorsSched(ev *Evaluator, args []Value, env *Env) Value {
 	names := make([]Value, 0, len(ev.Scheduler.Actors))
-	for name := range ev.Scheduler.Actors {
+	for _, name := range ev.Scheduler.Names() {
 		names = append(names, Sym(name))
 	}
 	return Lst(names...)
@@ -2686,7 +6405,8 @@ func countParens(s string) (int, int) {
 
 func runREPL(ev *Evaluator) {
 	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Println("BoundedLISP - Type (exit) to quit")
+	ev.Debugger = NewDebugger(scanner, os.Stdout)
+	fmt.Println("BoundedLISP - Type (exit) to quit, (break) in code to debug")
 	fmt.Print("> ")
 
 	var accum strings.Builder
@@ -2715,18 +6435,31 @@ func runREPL(ev *Evaluator) {
 			closeCount = 0
 
 			parser := NewParser(input)
-			exprs := parser.Parse()
+			exprs, errs := parser.Parse()
+			if len(errs) > 0 {
+				printParseErrors("input", errs)
+				exprs = nil
+			}
 
 			for _, expr := range exprs {
 				result := ev.Eval(expr, nil)
 				if result.Type != TypeNil {
-					fmt.Println(result.String())
+					fmt.Println(PrettyPrint(result))
 				}
 			}
+			ev.Debugger.reset()
 			fmt.Print("> ")
 		} else if openCount > closeCount {
 			// Need more input
 			fmt.Print("  ")
+		} else if closeCount > openCount {
+			// Stray ')' can never balance: report it and start over
+			_, errs := NewParser(accum.String()).Parse()
+			printParseErrors("input", errs)
+			accum.Reset()
+			openCount = 0
+			closeCount = 0
+			fmt.Print("> ")
 		} else {
 			// Unbalanced or empty line
 			fmt.Print("> ")
@@ -2742,8 +6475,16 @@ func runFile(ev *Evaluator, filename string) {
 	}
 
 	parser := NewParser(string(content))
-	exprs := parser.Parse()
+	exprs, errs := parser.Parse()
+	if len(errs) > 0 {
+		printParseErrors(filename, errs)
+		os.Exit(1)
+	}
 
+	// Files it loads are looked for next to it first
+	if abs, err := filepath.Abs(filename); err == nil {
+		ev.Loading = append(ev.Loading, abs)
+	}
 	for _, expr := range exprs {
 		result := ev.Eval(expr, nil)
 		if result.Type == TypeBlocked {
@@ -2752,6 +6493,68 @@ func runFile(ev *Evaluator, filename string) {
 	}
 }
 
+// runRun runs a spec file with a set of features enabled, optionally
+// recording the run for gen-test:
+// philosopher run --features retries,partition-tolerance --record trace.jsonl spec.lisp
+func runRun(ev *Evaluator, args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	features := fs.String("features", "", "comma-separated features to enable for when-feature")
+	record := fs.String("record", "", "write the run's schedule and outcomes to this trace file")
+	seed := fs.Int64("seed", 0, "seed for rand (default: random, saved with --record)")
+	scenario := fs.String("scenario", "", "markdown document whose Step | Actor | Message tables script the run")
+	fs.Int64Var(&ev.Fuel, "fuel", 0, "evaluations each top-level expression may take before it is stopped (default: unlimited)")
+	stackDepthFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: philosopher run [--features a,b,...] [--seed n] [--scenario doc.md] [--fuel n] [--stack-depth n] [--record trace.jsonl] <file.lisp>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	ev.CallStack.Capacity = callStackDepth
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	for _, f := range strings.Split(*features, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			ev.Features[f] = true
+		}
+	}
+	if *seed != 0 {
+		ev.SetSeed(*seed)
+	}
+	if *scenario != "" {
+		doc, err := os.ReadFile(*scenario)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "philosopher run: %v\n", err)
+			os.Exit(1)
+		}
+		if !ev.LoadScenarioTables(string(doc)) {
+			fmt.Fprintf(os.Stderr, "philosopher run: no Step | Actor | Message table in %s\n", *scenario)
+		}
+	}
+	if *record == "" {
+		runFile(ev, fs.Arg(0))
+		return
+	}
+	recorder, err := ev.recordProgram(fs.Arg(0), *record)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "philosopher run: %v\n", err)
+		os.Exit(1)
+	}
+	runFile(ev, fs.Arg(0))
+	if err := recorder.finish(ev, programFinished(ev)); err != nil {
+		fmt.Fprintf(os.Stderr, "philosopher run: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// printParseErrors reports parse errors to stderr as source:line:col: msg
+func printParseErrors(source string, errs []ParseError) {
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", source, e.Line, e.Col, e.Msg)
+	}
+}
+
 func runPrompt(ev *Evaluator, promptArg string) {
 	// Check if argument is a file or a prompt string
 	var prompt string
@@ -2818,11 +6621,17 @@ func runPrompt(ev *Evaluator, promptArg string) {
 	// Parse structured response
 	chatResponse, markdown, lisp := parseStructuredResponse(response)
 
+	// Scenario tables in the prompt or the document script the run
+	if !ev.LoadScenarioTables(prompt) {
+		ev.LoadScenarioTables(markdown)
+	}
+
 	// Execute LISP code
 	if lisp != "" {
 		fmt.Fprintln(os.Stderr, "\n=== Executing LISP ===")
 		parser := NewParser(lisp)
-		exprs := parser.Parse()
+		exprs, errs := parser.Parse()
+		printParseErrors("lisp", errs)
 		for _, expr := range exprs {
 			ev.Eval(expr, ev.GlobalEnv)
 		}
@@ -2854,46 +6663,117 @@ func runPrompt(ev *Evaluator, promptArg string) {
 		for _, f := range ev.DatalogDB.Facts {
 			counts[f.Predicate]++
 		}
-		for pred, count := range counts {
-			fmt.Fprintf(os.Stderr, "  %s: %d\n", pred, count)
+		for _, pred := range sortedKeys(counts) {
+			fmt.Fprintf(os.Stderr, "  %s: %d\n", pred, counts[pred])
 		}
 	}
 }
 
+// defaultCallStackDepth is how many frames the call stack holds unless
+// -stack-depth says otherwise
+const defaultCallStackDepth = 64
+
+// maxCallStackDepth is the deepest call stack allowed. Each frame is a
+// few evaluator calls deep on the Go stack, which overflows (fatally, not
+// as a LISP error) somewhere past 50000 of them
+const maxCallStackDepth = 10000
+
+// callStackDepth is the call stack size for the evaluators main makes
+var callStackDepth = defaultCallStackDepth
+
+// stackDepth is a -stack-depth flag: a number of frames, up to
+// maxCallStackDepth
+type stackDepth int
+
+func (d *stackDepth) String() string { return strconv.Itoa(int(*d)) }
+
+func (d *stackDepth) Set(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return fmt.Errorf("%q is not a positive number of frames", value)
+	}
+	if n > maxCallStackDepth {
+		return fmt.Errorf("%d is past the limit of %d frames", n, maxCallStackDepth)
+	}
+	*d = stackDepth(n)
+	return nil
+}
+
+// stackDepthFlag registers -stack-depth on fs, setting callStackDepth
+func stackDepthFlag(fs *flag.FlagSet) {
+	fs.Var((*stackDepth)(&callStackDepth), "stack-depth", "frames the call stack holds, at most 10000")
+}
+
 func main() {
-	ev := NewEvaluator(64) // 64 frame call stack limit
+	fs := flag.NewFlagSet("philosopher", flag.ExitOnError)
+	stackDepthFlag(fs)
+	mcp := fs.Bool("mcp", false, "serve MCP over stdio")
+	mcpSSE := fs.Bool("mcp-sse", false, "serve MCP over SSE, on the port given (default 3000)")
+	repl := fs.Bool("repl", false, "start the REPL")
+	watch := fs.Bool("watch", false, "run the file given again whenever it changes")
+	prompt := fs.Bool("prompt", false, "run a prompt, given as text or a file")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: philosopher [-stack-depth n] [-mcp | -mcp-sse [port] | -repl | -watch file | -prompt prompt | new ... | run ... | gen-test ... | file.lisp]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+	args := fs.Args()
+	ev := NewEvaluator(callStackDepth)
+
+	switch {
+	case *mcp:
+		runMCPServer()
+		return
+	case *mcpSSE:
+		port := "3000"
+		if len(args) > 0 {
+			port = args[0]
+		}
+		runMCPSSEServer(port)
+		return
+	case *repl:
+		ev.FileAccess = true
+		runREPL(ev)
+		return
+	case *watch:
+		if len(args) < 1 {
+			fmt.Println("Usage: philosopher -watch <file.lisp>")
+			os.Exit(1)
+		}
+		runWatch(args[0])
+		return
+	case *prompt:
+		args = append([]string{"prompt"}, args...)
+	}
 
-	if len(os.Args) > 1 {
-		switch os.Args[1] {
-		case "-mcp":
-			runMCPServer()
+	if len(args) > 0 {
+		switch args[0] {
+		case "new":
+			runNew(args[1:])
 			return
-		case "-mcp-sse":
-			port := "3000"
-			if len(os.Args) > 2 {
-				port = os.Args[2]
-			}
-			runMCPSSEServer(port)
+		case "run":
+			ev.FileAccess = true
+			runRun(ev, args[1:])
 			return
-		case "-repl":
-			runREPL(ev)
+		case "gen-test":
+			runGenTest(args[1:])
 			return
-		case "-prompt", "prompt":
-			if len(os.Args) < 3 {
+		case "prompt":
+			if len(args) < 2 {
 				fmt.Println("Usage: philosopher -prompt <prompt-text-or-file>")
 				fmt.Println("       philosopher -prompt prompts/test-01-counter.md")
 				fmt.Println("       philosopher -prompt \"Build a counter actor\"")
 				os.Exit(1)
 			}
-			runPrompt(ev, os.Args[2])
+			runPrompt(ev, args[1])
 			return
 		default:
 			// File mode - run a .lisp file
-			runFile(ev, os.Args[1])
+			ev.FileAccess = true
+			runFile(ev, args[0])
 			return
 		}
 	}
-
 	// Default: web server mode
 	port := os.Getenv("KRIPKE_PORT")
 	if port == "" {
@@ -2915,6 +6795,8 @@ type Session struct {
 	InputTokens  int
 	OutputTokens int
 	Evaluator    *Evaluator  // For LISP eval and Datalog facts
+	Scheduler    *Scheduler  // The session's actors, run in globalEv (see schedulers.go)
+	Facts        *DatalogDB  // The session's facts and rules; globalEv's while it runs
 	mu           sync.Mutex
 }
 
@@ -2933,7 +6815,8 @@ type DocVersion struct {
 var (
 	sessions   = make(map[string]*Session)
 	sessionsMu sync.RWMutex
-	globalEv   *Evaluator  // Single shared evaluator - no per-session state needed yet
+	globalEv   *Evaluator  // Single shared evaluator; each session has its own scheduler and facts in it
+	globalEvMu sync.Mutex  // Held while globalEv is in use, a session's or not
 )
 
 func getOrCreateSession(id string) *Session {
@@ -2951,14 +6834,40 @@ func getOrCreateSession(id string) *Session {
 		CurrentDoc: "",
 		CreatedAt:  time.Now(),
 		Evaluator:  NewEvaluator(1000),  // Per-session evaluator
+		Scheduler:  NewScheduler(),
+		Facts:      NewDatalogDB(),
 	}
 	sessions[id] = sess
 	return sess
 }
 
+// enterSession makes sess's scheduler and facts globalEv's until the
+// returned function puts the ones before back. Sessions still share
+// globalEv's environment, so a define in one is seen by all. Hold
+// globalEvMu throughout.
+func enterSession(sess *Session) func() {
+	leave := globalEv.useScheduler(sess.Scheduler)
+	facts := globalEv.DatalogDB
+	globalEv.DatalogDB = sess.Facts
+	return func() {
+		globalEv.DatalogDB = facts
+		leave()
+	}
+}
+
+// requestSession is the session named by the request's session_id query
+// parameter, or nil without one
+func requestSession(r *http.Request) *Session {
+	if id := r.URL.Query().Get("session_id"); id != "" {
+		return getOrCreateSession(id)
+	}
+	return nil
+}
+
 func runServer(ev *Evaluator, port string) {
 	// Set the global evaluator
 	globalEv = ev
+	ev.Fuel = serverFuel()
 	
 	// Load LISP modules
 	loadLispModules(ev)
@@ -2971,6 +6880,7 @@ func runServer(ev *Evaluator, port string) {
 	http.HandleFunc("/properties", handleProperties)
 	http.HandleFunc("/diagram", handleDiagram(ev))
 	http.HandleFunc("/facts", handleFacts)  // Debug: show session facts
+	http.HandleFunc("/reference", handleReference)
 	
 	// Check for API keys
 	hasAnthropic := os.Getenv("ANTHROPIC_API_KEY") != ""
@@ -3095,11 +7005,12 @@ func loadLispModules(ev *Evaluator) {
 	// Try to load standard modules
 	modules := []string{"prologue.lisp"}
 	for _, mod := range modules {
-		if content, err := os.ReadFile(mod); err == nil {
-			parser := NewParser(string(content))
-			for _, expr := range parser.Parse() {
-				ev.Eval(expr, nil)
-			}
+		path, err := ev.resolveLoad(mod)
+		if err != nil {
+			continue
+		}
+		if _, err := ev.loadFile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", mod, err)
 		}
 	}
 }
@@ -3138,7 +7049,15 @@ Brief response (1-3 sentences).
 (define name value)           ; global definition
 (define (fn x y) body)        ; function definition
 (let x 5 (+ x 1))             ; single binding
-(let* ((x 5) (y 6)) body)     ; multiple bindings
+(let ((x 5) (y 6)) body)      ; multiple bindings, evaluated in parallel
+(let* ((x 5) (y (+ x 1))) body) ; multiple bindings, in sequence
+(destructure (kind from . rest) msg body) ; bind a list's items by position
+(loop ((i 0)) (if (< i 3) (recur (+ i 1)) i)) ; iteration without a define
+(dotimes (i 3) body)          ; body with i = 0, 1, 2
+(while test body)             ; body until test is false
+(case (nth msg 0) ((buy sell) body) (else body)) ; dispatch on literal values
+(when test body ...)          ; body if test is true, else nil
+(unless test body ...)        ; body if test is false, else nil
 (lambda (x) body)             ; anonymous function
 (if test then else)           ; conditional
 (cond (test1 expr1) ...)      ; multi-branch
@@ -3347,11 +7266,22 @@ func handleChat(w http.ResponseWriter, r *http.Request) {
 	fmt.Printf("[chat] parsed: chat=%d chars, markdown=%d chars, lisp=%d chars\n", 
 		len(chatResponse), len(markdown), len(lisp))
 	
+	// The session's actors and facts are its own: run everything below on them
+	globalEvMu.Lock()
+	defer globalEvMu.Unlock()
+	defer enterSession(sess)()
+	
+	// An edited scenario table, pasted back or in the new document, scripts the run
+	if !globalEv.LoadScenarioTables(req.Message) {
+		globalEv.LoadScenarioTables(markdown)
+	}
+	
 	// Execute LISP code to populate DatalogDB with facts
 	if lisp != "" {
 		fmt.Printf("[chat] executing LISP, facts before=%d\n", len(globalEv.DatalogDB.Facts))
 		parser := NewParser(lisp)
-		exprs := parser.Parse()
+		exprs, errs := parser.Parse()
+		printParseErrors("chat", errs)
 		for _, expr := range exprs {
 			globalEv.Eval(expr, globalEv.GlobalEnv)
 		}
@@ -3425,14 +7355,14 @@ func generateDashboard(ev *Evaluator) string {
 	sb.WriteString("### Facts Summary\n\n")
 	sb.WriteString("| Predicate | Count |\n")
 	sb.WriteString("|-----------|-------|\n")
-	for pred, count := range predCounts {
-		sb.WriteString(fmt.Sprintf("| %s | %d |\n", pred, count))
+	for _, pred := range sortedKeys(predCounts) {
+		sb.WriteString(fmt.Sprintf("| %s | %d |\n", pred, predCounts[pred]))
 	}
 	sb.WriteString(fmt.Sprintf("| **Total** | **%d** |\n\n", factCount))
 	
 	// Build chart of all predicates (excluding spawned)
 	var chartPreds []string
-	for pred := range predCounts {
+	for _, pred := range sortedKeys(predCounts) {
 		if pred != "spawned" {
 			chartPreds = append(chartPreds, pred)
 		}
@@ -3517,7 +7447,8 @@ func generateDashboard(ev *Evaluator) string {
 		}
 		sb.WriteString(fmt.Sprintf("    y-axis \"Count\" 0 --> %d\n", maxY+5))
 		
-		for pred, counts := range seriesData {
+		for _, pred := range chartPreds {
+			counts := seriesData[pred]
 			countStrs := make([]string, len(counts))
 			for i, c := range counts {
 				countStrs[i] = fmt.Sprintf("%d", c)
@@ -3875,6 +7806,11 @@ func handleGetVersion(w http.ResponseWriter, r *http.Request) {
 func handleFacts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	
+	globalEvMu.Lock()
+	defer globalEvMu.Unlock()
+	if sess := requestSession(r); sess != nil {
+		defer enterSession(sess)()
+	}
 	ev := globalEv
 	
 	// Collect facts by predicate
@@ -3899,31 +7835,52 @@ func handleFacts(w http.ResponseWriter, r *http.Request) {
 
 func handleEval(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		Code string `json:"code"`
+		Code      string `json:"code"`
+		Fuel      int64  `json:"fuel"`       // Evaluations each expression may take, up to the server's
+		SessionID string `json:"session_id"` // Run on this session's actors and facts
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	
+	globalEvMu.Lock()
+	defer globalEvMu.Unlock()
+	if req.SessionID != "" {
+		defer enterSession(getOrCreateSession(req.SessionID))()
+	}
 	ev := globalEv
-	// Debug
+	ev.ResetWarnings()
+	ev.Sandbox = true
+	defer func() { ev.Sandbox = false }()
+	defer func(fuel int64) { ev.Fuel = fuel }(ev.Fuel)
+	ev.Fuel = requestFuel(req.Fuel)
 	
 	// Capture output and errors
 	var output strings.Builder
 	var errors []string
 	
 	parser := NewParser(req.Code)
-	exprs := parser.Parse()
+	exprs, parseErrs := parser.Parse()
+	if len(parseErrs) > 0 {
+		// Don't run a half-parsed program; report where it broke instead
+		for _, e := range parseErrs {
+			errors = append(errors, "Parse error: "+e.Error())
+		}
+		exprs = nil
+	}
 	
 	var results []string
+	var values []interface{}
 	for _, expr := range exprs {
 		result := ev.Eval(expr, ev.GlobalEnv)
 		resultStr := result.String()
 		results = append(results, resultStr)
+		values = append(values, jsonOrString(result))
 		
 		// Check for error indicators
-		if strings.HasPrefix(resultStr, "Error:") || 
+		if result.Type == TypeBlocked && result.Blocked.Reason == BlockOutOfFuel ||
+		   strings.HasPrefix(resultStr, "Error:") || 
 		   strings.HasPrefix(resultStr, "Undefined symbol:") ||
 		   strings.HasPrefix(resultStr, "Parse error:") ||
 		   strings.Contains(resultStr, "not a function") ||
@@ -3931,17 +7888,20 @@ func handleEval(w http.ResponseWriter, r *http.Request) {
 		   strings.Contains(resultStr, "expected") {
 			errors = append(errors, resultStr)
 		}
-		output.WriteString(resultStr)
+		output.WriteString(PrettyPrint(result))
 		output.WriteString("\n")
 	}
 	
 	// Debug
 	
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"results": results,
-		"output":  output.String(),
-		"errors":  errors,
-		"success": len(errors) == 0,
+		"results":  results,
+		"values":   values,
+		"output":   output.String(),
+		"errors":   errors,
+		"warnings": ev.Warnings,
+		"warnings_dropped": ev.WarningsDropped,
+		"success":  len(errors) == 0,
 	})
 }
 
@@ -3954,6 +7914,11 @@ func handleProperties(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	var properties []Property
+	globalEvMu.Lock()
+	defer globalEvMu.Unlock()
+	if sess := requestSession(r); sess != nil {
+		defer enterSession(sess)()
+	}
 	ev := globalEv
 	
 	// Debug
@@ -4027,6 +7992,7 @@ func handleProperties(w http.ResponseWriter, r *http.Request) {
 	
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"properties": properties,
+		"warnings":   ev.Warnings,
 	})
 }
 
@@ -4134,7 +8100,7 @@ Sketch:
 		}
 		
 		parser := NewParser(code)
-		exprs := parser.Parse()
+		exprs, _ := parser.Parse()
 		
 		var result string
 		for _, expr := range exprs {
@@ -4307,6 +8273,14 @@ const indexHTML = `<!DOCTYPE html>
             min-width: 80px; text-align: right;
         }
         
+        .warnings-view { padding: 1rem; }
+        .warning-item {
+            background: #161b22; border: 1px solid #30363d; border-left: 3px solid #d29922;
+            border-radius: 6px; padding: 0.5rem 1rem; margin-bottom: 0.5rem;
+            font-family: 'Fira Code', monospace; font-size: 0.85rem; color: #c9d1d9;
+        }
+        .warning-meta { color: #8b949e; font-size: 0.75rem; margin-top: 0.25rem; }
+        
         .empty-state { display: flex; align-items: center; justify-content: center; height: 100%; color: #8b949e; font-style: italic; }
     </style>
 </head>
@@ -4344,6 +8318,7 @@ const indexHTML = `<!DOCTYPE html>
             <div class="spec-tab active" data-tab="markdown" onclick="showTab('markdown')">Document</div>
             <div class="spec-tab" data-tab="code" onclick="showTab('code')">LISP</div>
             <div class="spec-tab" data-tab="properties" onclick="showTab('properties')">Properties</div>
+            <div class="spec-tab" data-tab="warnings" onclick="showTab('warnings')" id="warningsTab">Warnings</div>
             <div class="spec-tab" data-tab="whiteboard" onclick="showTab('whiteboard')">Whiteboard</div>
         </div>
         <div class="tab-content active" id="tab-markdown">
@@ -4361,6 +8336,11 @@ const indexHTML = `<!DOCTYPE html>
                 <div class="empty-state">CTL properties will appear here...</div>
             </div>
         </div>
+        <div class="tab-content" id="tab-warnings">
+            <div class="spec-content warnings-view" id="warningsContent">
+                <div class="empty-state">Runtime warnings will appear here...</div>
+            </div>
+        </div>
         <div class="tab-content" id="tab-whiteboard">
             <div class="whiteboard-controls">
                 <button onclick="clearWhiteboard()">Clear</button>
@@ -4700,7 +8680,7 @@ Click '✨ AI' for smart interpretation."></textarea>
                 const resp = await fetch('/eval', {
                     method: 'POST',
                     headers: { 'Content-Type': 'application/json' },
-                    body: JSON.stringify({ code })
+                    body: JSON.stringify({ session_id: sessionId, code })
                 });
                 if (!resp.ok) {
                     return { success: false, errors: [await resp.text()], output: '' };
@@ -4771,6 +8751,7 @@ Click '✨ AI' for smart interpretation."></textarea>
                     
                     // Auto-execute the generated code
                     const execResult = await executeCode(currentDoc);
+                    updateWarningsPanel(execResult.warnings);
                     
                     if (!execResult.success && execResult.errors && execResult.errors.length > 0) {
                         // Code has errors - attempt auto-fix
@@ -4884,7 +8865,7 @@ Click '✨ AI' for smart interpretation."></textarea>
         async function updatePropertiesPanel() {
             const container = document.getElementById('propertiesContent');
             try {
-                const resp = await fetch('/properties');
+                const resp = await fetch('/properties?session_id=' + encodeURIComponent(sessionId));
                 const data = await resp.json();
                 
                 if (!data.properties || data.properties.length === 0) {
@@ -4916,6 +8897,27 @@ Click '✨ AI' for smart interpretation."></textarea>
             }
         }
         
+        function updateWarningsPanel(warnings) {
+            const container = document.getElementById('warningsContent');
+            const tab = document.getElementById('warningsTab');
+            if (!warnings || warnings.length === 0) {
+                tab.textContent = 'Warnings';
+                container.innerHTML = '<div class="empty-state">No runtime warnings.</div>';
+                return;
+            }
+            tab.textContent = 'Warnings (' + warnings.length + ')';
+            let html = '';
+            for (const w of warnings) {
+                html += '<div class="warning-item">' + escapeHtml(w.message);
+                let meta = 'step ' + w.step;
+                if (w.actor) meta = 'actor ' + w.actor + ', ' + meta;
+                if (w.count > 1) meta += ', repeated ' + w.count + '×';
+                html += '<div class="warning-meta">' + escapeHtml(meta) + '</div>';
+                html += '</div>';
+            }
+            container.innerHTML = html;
+        }
+        
         function updateUsage(usage) {
             const el = document.getElementById('usage');
             const total = usage.total_tokens || 0;
@@ -4956,7 +8958,7 @@ Click '✨ AI' for smart interpretation."></textarea>
 var mcpEvaluator *Evaluator
 
 func runMCPServer() {
-	mcpEvaluator = NewEvaluator(64)
+	mcpEvaluator = NewEvaluator(callStackDepth)
 	loadLispModules(mcpEvaluator)
 
 	fmt.Fprintln(os.Stderr, "BoundedLISP MCP Server (stdio)")
@@ -4998,7 +9000,7 @@ func runMCPServer() {
 }
 
 func runMCPSSEServer(port string) {
-	mcpEvaluator = NewEvaluator(64)
+	mcpEvaluator = NewEvaluator(callStackDepth)
 	loadLispModules(mcpEvaluator)
 
 	fmt.Printf("BoundedLISP MCP Server (SSE) on :%s\n", port)
@@ -5090,8 +9092,17 @@ func mcpCallTool(params map[string]interface{}) map[string]interface{} {
 		if code == "" {
 			result, isErr = "code required", true
 		} else {
+			exprs, errs := NewParser(code).Parse()
+			if len(errs) > 0 {
+				var msgs []string
+				for _, e := range errs {
+					msgs = append(msgs, e.Error())
+				}
+				result, isErr = map[string]interface{}{"parse_errors": msgs}, true
+				break
+			}
 			var results []string
-			for _, expr := range NewParser(code).Parse() {
+			for _, expr := range exprs {
 				results = append(results, mcpEvaluator.Eval(expr, nil).String())
 			}
 			result = map[string]interface{}{"results": results}
@@ -5102,7 +9113,8 @@ func mcpCallTool(params map[string]interface{}) map[string]interface{} {
 		if s, ok := args["max_steps"].(float64); ok {
 			steps = int(s)
 		}
-		for _, expr := range NewParser(fmt.Sprintf("(run-scheduler %d)", steps)).Parse() {
+		exprs, _ := NewParser(fmt.Sprintf("(run-scheduler %d)", steps)).Parse()
+		for _, expr := range exprs {
 			mcpEvaluator.Eval(expr, nil)
 		}
 		states := make(map[string]string)
@@ -5124,7 +9136,8 @@ func mcpCallTool(params map[string]interface{}) map[string]interface{} {
 			ms = int(m)
 		}
 		init, _ := args["initial_state"].(string)
-		for _, expr := range NewParser(fmt.Sprintf("(spawn-actor '%s %d '%s)", n, ms, init)).Parse() {
+		exprs, _ := NewParser(fmt.Sprintf("(spawn-actor '%s %d '%s)", n, ms, init)).Parse()
+		for _, expr := range exprs {
 			mcpEvaluator.Eval(expr, nil)
 		}
 		result = map[string]interface{}{"spawned": n}
@@ -5132,7 +9145,8 @@ func mcpCallTool(params map[string]interface{}) map[string]interface{} {
 	case "send_message":
 		actor, _ := args["actor"].(string)
 		msg, _ := args["message"].(string)
-		for _, expr := range NewParser(fmt.Sprintf("(send-to! '%s %s)", actor, msg)).Parse() {
+		exprs, _ := NewParser(fmt.Sprintf("(send-to! '%s %s)", actor, msg)).Parse()
+		for _, expr := range exprs {
 			mcpEvaluator.Eval(expr, nil)
 		}
 		result = map[string]interface{}{"sent": actor, "message": msg}
@@ -5201,6 +9215,8 @@ func mcpFormatValue(v Value) interface{} {
 		return v.Number
 	case TypeString:
 		return v.Str
+	case TypeChar:
+		return string(rune(v.Int))
 	case TypeBool:
 		return v.Bool
 	case TypeList:
@@ -5248,6 +9264,7 @@ type Fact struct {
 	Predicate string
 	Args      []Term
 	Time      int64 // timestamp for temporal queries
+	Lifecycle bool  // recorded by the scheduler, not the spec (see lifecycle.go)
 }
 
 // Rule is a Horn clause: head :- body
@@ -5275,6 +9292,17 @@ type DatalogDB struct {
 	Rules    []Rule
 	TimeNow  int64 // current simulation time
 	AutoTime bool  // auto-timestamp facts
+	OnAssert func(Fact) // called after each fact is added
+	index    factIndex  // Facts by predicate and first argument (see factindex.go)
+	tables   *tables    // Answers to the subgoals of the query being solved (see tabling.go)
+
+	// Bottom-up evaluation (see seminaive.go)
+	Mode         string          // "bottom-up" to solve every goal bottom-up; "" is top-down
+	BottomUp     map[string]bool // Predicates solved bottom-up whatever the Mode
+	version      int             // Changes made to facts and rules, to tell when materialized is stale
+	materialized *materialized
+	derived      bool // A materialized view: Facts past the first asserted were derived by rules
+	asserted     int
 }
 
 // ============================================================================
@@ -5477,15 +9505,26 @@ func (db *DatalogDB) Assert(pred string, args ...Term) {
 		Time:      db.TimeNow,
 	}
 	db.Facts = append(db.Facts, fact)
+	db.version++
+	if db.OnAssert != nil {
+		db.OnAssert(fact)
+	}
 }
 
 func (db *DatalogDB) AssertAtTime(pred string, time int64, args ...Term) {
-	fact := Fact{
+	db.assertFact(Fact{
 		Predicate: pred,
 		Args:      args,
 		Time:      time,
-	}
+	})
+}
+
+func (db *DatalogDB) assertFact(fact Fact) {
 	db.Facts = append(db.Facts, fact)
+	db.version++
+	if db.OnAssert != nil {
+		db.OnAssert(fact)
+	}
 }
 
 func (db *DatalogDB) Retract(pred string, args ...Term) bool {
@@ -5501,6 +9540,8 @@ func (db *DatalogDB) Retract(pred string, args ...Term) bool {
 			}
 			if match {
 				db.Facts = append(db.Facts[:i], db.Facts[i+1:]...)
+				db.index.reset()
+				db.version++
 				return true
 			}
 		}
@@ -5514,14 +9555,18 @@ func (db *DatalogDB) AddRule(name string, head Fact, body ...Goal) {
 		Head: head,
 		Body: body,
 	})
+	db.version++
 }
 
 func (db *DatalogDB) ClearFacts() {
 	db.Facts = make([]Fact, 0)
+	db.index.reset()
+	db.version++
 }
 
 func (db *DatalogDB) ClearRules() {
 	db.Rules = make([]Rule, 0)
+	db.version++
 }
 
 func (db *DatalogDB) Clear() {
@@ -5556,6 +9601,11 @@ func (db *DatalogDB) solve(goals []Goal, bindings Binding, depth int) []Binding
 	if depth > maxDepth {
 		return nil
 	}
+	if db.tables == nil && len(db.Rules) > 0 {
+		// A query: its subgoals share tables until it is answered
+		db.tables = db.newTables()
+		defer func() { db.tables = nil }()
+	}
 
 	if len(goals) == 0 {
 		return []Binding{bindings}
@@ -5579,6 +9629,9 @@ func (db *DatalogDB) solve(goals []Goal, bindings Binding, depth int) []Binding
 
 	// Handle builtins
 	if goal.IsBuiltin {
+		if goal.Builtin == "is" {
+			return db.solveIs(goal, rest, bindings, depth)
+		}
 		if db.evalBuiltin(goal, bindings) {
 			results = append(results, db.solve(rest, bindings, depth+1)...)
 		}
@@ -5597,12 +9650,35 @@ func (db *DatalogDB) solve(goals []Goal, bindings Binding, depth int) []Binding
 		return db.solveBetween(goal, rest, bindings, depth)
 	}
 
-	// Match against facts
-	for _, fact := range db.Facts {
-		if fact.Predicate == goal.Predicate {
-			if newB, ok := UnifyArgs(goal.Args, fact.Args, bindings); ok {
-				results = append(results, db.solve(rest, newB, depth+1)...)
+	// Derived facts stand in for the rules of predicates solved bottom-up,
+	// unless the rules can't be evaluated that way
+	if db.bottomUp(goal.Predicate) {
+		if view, err := db.Materialize(); err == nil {
+			for _, i := range view.factsFor(goal.Predicate, goal.Args, bindings) {
+				if newB, ok := UnifyArgs(goal.Args, view.Facts[i].Args, bindings); ok {
+					results = append(results, db.solve(rest, newB, depth+1)...)
+				}
 			}
+			return results
+		}
+	}
+
+	// Predicates with rules are tabled (see tabling.go)
+	if db.tables != nil && db.tables.ruled[goal.Predicate] {
+		return db.solveTabled(goal, rest, bindings, depth)
+	}
+	return db.matchClauses(goal, rest, bindings, depth)
+}
+
+// matchClauses solves goal against the facts and rules for its predicate,
+// then solves rest under each answer
+func (db *DatalogDB) matchClauses(goal Goal, rest []Goal, bindings Binding, depth int) []Binding {
+	var results []Binding
+
+	// Match against facts
+	for _, i := range db.factsFor(goal.Predicate, goal.Args, bindings) {
+		if newB, ok := UnifyArgs(goal.Args, db.Facts[i].Args, bindings); ok {
+			results = append(results, db.solve(rest, newB, depth+1)...)
 		}
 	}
 
@@ -5629,7 +9705,8 @@ func (db *DatalogDB) renameVars(rule Rule, depth int) Rule {
 	suffix := fmt.Sprintf("_%d", depth)
 	varMap := make(map[string]string)
 
-	renameTerm := func(t Term) Term {
+	var renameTerm func(t Term) Term
+	renameTerm = func(t Term) Term {
 		if t.IsVar {
 			if newName, ok := varMap[t.Name]; ok {
 				return Var(newName)
@@ -5639,19 +9716,10 @@ func (db *DatalogDB) renameVars(rule Rule, depth int) Rule {
 			return Var(newName)
 		}
 		if t.IsList {
+			// Lists nest, as in (is ?x (+ (* ?a 2) ?b))
 			newList := make([]Term, len(t.List))
 			for i, elem := range t.List {
-				if elem.IsVar {
-					if newName, ok := varMap[elem.Name]; ok {
-						newList[i] = Var(newName)
-					} else {
-						newName := elem.Name + suffix
-						varMap[elem.Name] = newName
-						newList[i] = Var(newName)
-					}
-				} else {
-					newList[i] = elem
-				}
+				newList[i] = renameTerm(elem)
 			}
 			return ListTerm(newList...)
 		}
@@ -5721,6 +9789,8 @@ func (db *DatalogDB) evalBuiltin(goal Goal, b Binding) bool {
 		if left.IsNum && right.IsNum {
 			return left.Num <= right.Num
 		}
+	case "happened-before", "concurrent":
+		return vectorOrder(goal.Builtin, left, right)
 	}
 	return false
 }
@@ -5741,7 +9811,8 @@ func (db *DatalogDB) solveAtTime(goal Goal, rest []Goal, bindings Binding, depth
 
 	var results []Binding
 
-	for _, fact := range db.Facts {
+	for _, i := range db.temporalFacts(predTerm) {
+		fact := db.Facts[i]
 		if predTerm.IsVar || fact.Predicate == predTerm.Name {
 			if newB, ok := UnifyArgs(queryArgs, fact.Args, bindings); ok {
 				// Unify time
@@ -5776,7 +9847,8 @@ func (db *DatalogDB) solveBefore(goal Goal, rest []Goal, bindings Binding, depth
 
 	var results []Binding
 
-	for _, fact := range db.Facts {
+	for _, i := range db.temporalFacts(predTerm) {
+		fact := db.Facts[i]
 		if fact.Time < maxTime {
 			if predTerm.IsVar || fact.Predicate == predTerm.Name {
 				if newB, ok := UnifyArgs(queryArgs, fact.Args, bindings); ok {
@@ -5808,7 +9880,8 @@ func (db *DatalogDB) solveAfter(goal Goal, rest []Goal, bindings Binding, depth
 
 	var results []Binding
 
-	for _, fact := range db.Facts {
+	for _, i := range db.temporalFacts(predTerm) {
+		fact := db.Facts[i]
 		if fact.Time > minTime {
 			if predTerm.IsVar || fact.Predicate == predTerm.Name {
 				if newB, ok := UnifyArgs(queryArgs, fact.Args, bindings); ok {
@@ -5842,7 +9915,8 @@ func (db *DatalogDB) solveBetween(goal Goal, rest []Goal, bindings Binding, dept
 
 	var results []Binding
 
-	for _, fact := range db.Facts {
+	for _, i := range db.temporalFacts(predTerm) {
+		fact := db.Facts[i]
 		if fact.Time >= minTime && fact.Time <= maxTime {
 			if predTerm.IsVar || fact.Predicate == predTerm.Name {
 				if newB, ok := UnifyArgs(queryArgs, fact.Args, bindings); ok {
@@ -5866,9 +9940,13 @@ func (db *DatalogDB) solveBetween(goal Goal, rest []Goal, bindings Binding, dept
 // For a single execution trace, checks every timestep
 func (db *DatalogDB) Always(goal Goal) bool {
 	// Get all unique times from facts
+	// Lifecycle facts record the scheduler, not the model, so their
+	// stamps aren't times it's checked at
 	times := make(map[int64]bool)
 	for _, f := range db.Facts {
-		times[f.Time] = true
+		if !f.Lifecycle {
+			times[f.Time] = true
+		}
 	}
 
 	if len(times) == 0 {
@@ -5948,6 +10026,8 @@ func ValueToTerm(v Value) Term {
 			return Var(v.Symbol[1:])
 		}
 		return Atom(v.Symbol)
+	case TypeActor:
+		return Atom(v.Symbol)
 	case TypeBool:
 		if v.Bool {
 			return Atom("true")
@@ -5988,8 +10068,8 @@ func TermToValue(t Term) Value {
 // BindingsToValue converts bindings to a LISP association list
 func BindingsToValue(b Binding) Value {
 	pairs := make([]Value, 0, len(b))
-	for k, v := range b {
-		pairs = append(pairs, Lst(Sym(k), TermToValue(v)))
+	for _, k := range sortedKeys(b) {
+		pairs = append(pairs, Lst(Sym(k), TermToValue(b[k])))
 	}
 	return Lst(pairs...)
 }
@@ -6009,6 +10089,9 @@ func RegisterDatalogBuiltins(ev *Evaluator) {
 			return Sym("error:assert-needs-predicate")
 		}
 		pred := args[0].Symbol
+		if ev.checkCSPViolation("assert!", pred) {
+			return Nil() // Skipped in strict mode
+		}
 		terms := make([]Term, len(args)-1)
 		for i, a := range args[1:] {
 			terms[i] = ValueToTerm(a)
@@ -6107,33 +10190,46 @@ func RegisterDatalogBuiltins(ev *Evaluator) {
 		return Sym("ok")
 	}})
 
-	// (query pred arg1 ?x ...)
+	// (query pred arg1 ?x ... [:mode 'bottom-up])
 	env.Set("query", Value{Type: TypeBuiltin, Builtin: func(ev *Evaluator, args []Value, env *Env) Value {
+		args, opts := keywordArgs(args)
 		if len(args) < 1 {
 			return Lst()
 		}
-		pred := args[0].Symbol
-		terms := make([]Term, len(args)-1)
-		for i, a := range args[1:] {
-			terms[i] = ValueToTerm(a)
+		bottomUp, ok := queryMode(ev, "query", opts)
+		if !ok {
+			return Nil()
 		}
+		goals := queryGoals("query", args)
 
-		results := ev.DatalogDB.Query(pred, terms...)
+		if bottomUp {
+			results, _ := ev.DatalogDB.QueryGoalsBottomUp(goals...)
+			return bindingsToLisp(results)
+		}
+		results := ev.DatalogDB.QueryGoals(goals...)
 		return bindingsToLisp(results)
 	}})
 
-	// (query-all (goal1) (goal2) ...) - conjunction query
+	// (query-all (goal1) (goal2) ... [:mode 'bottom-up]) - conjunction query
 	env.Set("query-all", Value{Type: TypeBuiltin, Builtin: func(ev *Evaluator, args []Value, env *Env) Value {
-		goals := make([]Goal, 0, len(args))
-		for _, arg := range args {
-			if arg.Type == TypeList && len(arg.List) > 0 {
-				goals = append(goals, parseGoal(arg))
-			}
+		args, opts := keywordArgs(args)
+		bottomUp, ok := queryMode(ev, "query-all", opts)
+		if !ok {
+			return Nil()
 		}
+		goals := queryGoals("query-all", args)
 
+		if bottomUp {
+			results, _ := ev.DatalogDB.QueryGoalsBottomUp(goals...)
+			return bindingsToLisp(results)
+		}
 		results := ev.DatalogDB.QueryGoals(goals...)
 		return bindingsToLisp(results)
 	}})
+	env.Set("datalog-mode!", Value{Type: TypeBuiltin, Builtin: builtinDatalogMode})
+
+	// Status of a property in the registry, as in the Properties table
+	env.Set("property-status", Value{Type: TypeBuiltin, Builtin: builtinPropertyStatus})
 
 	// CTL Temporal Operators
 	// AG: (always? (goal)) - p holds at ALL times (necessarily)
@@ -6224,7 +10320,7 @@ func RegisterDatalogBuiltins(ev *Evaluator) {
 				count++
 			}
 		}
-		return Num(float64(count))
+		return Int(int64(count))
 	}})
 
 	// (sum-facts 'predicate field-index) - sum numeric values at field position
@@ -6315,15 +10411,15 @@ func RegisterDatalogBuiltins(ev *Evaluator) {
 			}
 		}
 		
-		// Sort by time
-		sort.Slice(points, func(i, j int) bool {
+		// Sort by time, keeping insertion order within a tick
+		sort.SliceStable(points, func(i, j int) bool {
 			return points[i].time < points[j].time
 		})
 		
 		// Convert to list of (time value) pairs
 		result := make([]Value, len(points))
 		for i, p := range points {
-			result[i] = Lst(Num(float64(p.time)), Num(p.value))
+			result[i] = Lst(Int(int64(p.time)), Num(p.value))
 		}
 		return Lst(result...)
 	}})
@@ -6355,8 +10451,8 @@ func RegisterDatalogBuiltins(ev *Evaluator) {
 		}
 		
 		result := make([]Value, 0, len(counts))
-		for k, v := range counts {
-			result = append(result, Lst(Str(k), Num(float64(v))))
+		for _, k := range sortedKeys(counts) {
+			result = append(result, Lst(Str(k), Int(int64(counts[k]))))
 		}
 		return Lst(result...)
 	}})
@@ -6393,8 +10489,8 @@ func RegisterDatalogBuiltins(ev *Evaluator) {
 		}
 		
 		result := make([]Value, 0, len(sums))
-		for k, v := range sums {
-			result = append(result, Lst(Str(k), Num(v)))
+		for _, k := range sortedKeys(sums) {
+			result = append(result, Lst(Str(k), Num(sums[k])))
 		}
 		return Lst(result...)
 	}})
@@ -6404,12 +10500,12 @@ func RegisterDatalogBuiltins(ev *Evaluator) {
 		if len(args) > 0 && args[0].Type == TypeNumber {
 			ev.DatalogDB.TimeNow = int64(args[0].Number)
 		}
-		return Num(float64(ev.DatalogDB.TimeNow))
+		return Int(int64(ev.DatalogDB.TimeNow))
 	}})
 
 	// (datalog-time)
 	env.Set("datalog-time", Value{Type: TypeBuiltin, Builtin: func(ev *Evaluator, args []Value, env *Env) Value {
-		return Num(float64(ev.DatalogDB.TimeNow))
+		return Int(int64(ev.DatalogDB.TimeNow))
 	}})
 
 	// (datalog-facts) - list all facts
@@ -6421,7 +10517,7 @@ func RegisterDatalogBuiltins(ev *Evaluator) {
 			for j, t := range f.Args {
 				factTerms[j+1] = TermToValue(t)
 			}
-			factTerms[len(factTerms)-1] = Lst(Sym("@"), Num(float64(f.Time)))
+			factTerms[len(factTerms)-1] = Lst(Sym("@"), Int(int64(f.Time)))
 			facts[i] = Lst(factTerms...)
 		}
 		return Lst(facts...)
@@ -6441,7 +10537,7 @@ func RegisterDatalogBuiltins(ev *Evaluator) {
 
 func isBuiltinOp(s string) bool {
 	switch s {
-	case "=", "!=", "<>", ">", "<", ">=", "<=":
+	case "=", "!=", "<>", ">", "<", ">=", "<=", "happened-before", "concurrent", "is":
 		return true
 	}
 	return false
@@ -6478,6 +10574,28 @@ func parseGoal(v Value) Goal {
 	return Goal{Predicate: pred, Args: args}
 }
 
+// queryGoals are the goals of a call to query or query-all, given its
+// arguments without keywords
+func queryGoals(name string, args []Value) []Goal {
+	if name == "query" {
+		if len(args) < 1 {
+			return nil
+		}
+		terms := make([]Term, len(args)-1)
+		for i, a := range args[1:] {
+			terms[i] = ValueToTerm(a)
+		}
+		return []Goal{{Predicate: args[0].Symbol, Args: terms}}
+	}
+	goals := make([]Goal, 0, len(args))
+	for _, arg := range args {
+		if arg.Type == TypeList && len(arg.List) > 0 {
+			goals = append(goals, parseGoal(arg))
+		}
+	}
+	return goals
+}
+
 func bindingsToLisp(results []Binding) Value {
 	if len(results) == 0 {
 		return Lst()
@@ -6485,11 +10603,7 @@ func bindingsToLisp(results []Binding) Value {
 
 	rows := make([]Value, len(results))
 	for i, b := range results {
-		pairs := make([]Value, 0, len(b))
-		for k, v := range b {
-			pairs = append(pairs, Lst(Sym(k), TermToValue(v)))
-		}
-		rows[i] = Lst(pairs...)
+		rows[i] = BindingsToValue(b)
 	}
 	return Lst(rows...)
 }
@@ -6511,22 +10625,26 @@ func (ev *Evaluator) TraceSend(from, to string, msg Value) {
 	if ev.DatalogDB == nil {
 		return
 	}
-	ev.DatalogDB.Assert("sent",
+	ev.DatalogDB.AssertAtTime("sent", ev.now(),
 		Atom(from),
 		Atom(to),
 		ValueToTerm(msg),
 	)
 }
 
-// TraceReceive records a message receive
+// TraceReceive records a message receive, and the guard it is if
+// auto-tracing is on (see autotrace.go)
 func (ev *Evaluator) TraceReceive(actor string, msg Value) {
 	if ev.DatalogDB == nil {
 		return
 	}
-	ev.DatalogDB.Assert("received",
+	ev.DatalogDB.AssertAtTime("received", ev.now(),
 		Atom(actor),
 		ValueToTerm(msg),
 	)
+	if actor == ev.tracing() {
+		ev.TraceGuard(actor, "receive")
+	}
 }
 
 // TraceStateChange records a state variable change
@@ -6534,7 +10652,7 @@ func (ev *Evaluator) TraceStateChange(actor, varName string, oldVal, newVal Valu
 	if ev.DatalogDB == nil {
 		return
 	}
-	ev.DatalogDB.Assert("state-change",
+	ev.DatalogDB.AssertAtTime("state-change", ev.now(),
 		Atom(actor),
 		Atom(varName),
 		ValueToTerm(oldVal),
@@ -6547,7 +10665,7 @@ func (ev *Evaluator) TraceGuard(actor, guardType string) {
 	if ev.DatalogDB == nil {
 		return
 	}
-	ev.DatalogDB.Assert("guard",
+	ev.DatalogDB.AssertAtTime("guard", ev.now(),
 		Atom(actor),
 		Atom(guardType),
 	)
@@ -6558,7 +10676,7 @@ func (ev *Evaluator) TraceEffect(actor, op, varName string) {
 	if ev.DatalogDB == nil {
 		return
 	}
-	ev.DatalogDB.Assert("effect",
+	ev.DatalogDB.AssertAtTime("effect", ev.now(),
 		Atom(actor),
 		Atom(op),
 		Atom(varName),
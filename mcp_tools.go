@@ -72,6 +72,74 @@ var mcpTools = []map[string]interface{}{
 			"required": []string{"predicate"},
 		},
 	},
+	{
+		"name": "properties",
+		"description": "Check several temporal properties and display them as a table of results.",
+		"inputSchema": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"checks": map[string]interface{}{
+					"type":        "string",
+					"description": "Semicolon-separated 'name: formula' checks, e.g. \"No errors: never? '(error ?x)\"",
+				},
+			},
+		},
+	},
+	{
+		"name": "facts_list",
+		"description": "List the collected facts themselves, rather than counts.",
+		"inputSchema": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"predicate": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list facts with this predicate",
+				},
+				"limit": map[string]interface{}{
+					"type":        "integer",
+					"description": "Max facts to list (default 20)",
+				},
+			},
+		},
+	},
+	{
+		"name": "scenario_table",
+		"description": "Show the scripted stimuli (step, actor, message) as an editable table. Edited rows script the next run.",
+		"inputSchema": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	},
+	{
+		"name": "fairness_report",
+		"description": "Show how the steps taken were shared between actors: each actor's steps, share, longest wait for a turn and remaining step budget.",
+		"inputSchema": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	},
+	{
+		"name": "explanation",
+		"description": "Explain why a query's answers hold: for each answer, the tree of rule instances and facts that derive it, as a mermaid flowchart or nested list.",
+		"inputSchema": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "Goals to answer, e.g. \"(deadlock ?a ?b)\" or \"(waits ?a ?b) (not (done ?a))\"",
+				},
+				"format": map[string]interface{}{
+					"type":        "string",
+					"description": "Optional: 'mermaid' (default) or 'markdown' for nested lists",
+				},
+				"limit": map[string]interface{}{
+					"type":        "integer",
+					"description": "Max answers to explain (default 3)",
+				},
+			},
+			"required": []string{"query"},
+		},
+	},
 	{
 		"name": "metrics_chart",
 		"description": "Render time-series metrics as an xychart. Queries the metrics registry.",
@@ -0,0 +1,101 @@
+package main
+
+// ============================================================================
+// Scheduler Policies
+// ============================================================================
+//
+// The policy decides which runnable actor takes the next step:
+//
+//   round-robin   each runnable actor in turn (the default)
+//   random        any runnable actor, drawn from the seeded rand source
+//   priority      the runnable actor with the highest priority, taking
+//                 turns with any of equal priority
+//
+// Round-robin runs every program the same way, which hides the bugs that
+// only show up under another interleaving. The random policy tries other
+// interleavings, and since it draws from the same seeded source as rand,
+// a seed that turns one up reproduces it:
+//
+//   (set-scheduler-policy! 'random 42)
+//   (run-scheduler 500)
+//
+// A replay script, while it's followed, decides ahead of any policy.
+
+// schedulerPolicies pick the next actor from a non-empty run queue
+var schedulerPolicies = map[string]func(ev *Evaluator) *Actor{
+	"round-robin": func(ev *Evaluator) *Actor {
+		return ev.Scheduler.NextActor()
+	},
+	"random": func(ev *Evaluator) *Actor {
+		s := ev.Scheduler
+		return s.Pick(s.RunQueue[ev.Rand.Intn(len(s.RunQueue))])
+	},
+	"priority": func(ev *Evaluator) *Actor {
+		s := ev.Scheduler
+		best := s.RunQueue[0]
+		for _, name := range s.RunQueue[1:] {
+			if s.Actors[name].Priority > s.Actors[best].Priority {
+				best = name
+			}
+		}
+		return s.Pick(best)
+	},
+}
+
+// policyNames lists the policies, sorted
+func policyNames() []string {
+	return sortedKeys(schedulerPolicies)
+}
+
+// nextActor picks the actor for the next step under the scheduler's policy
+func (ev *Evaluator) nextActor() *Actor {
+	s := ev.Scheduler
+	policy, ok := schedulerPolicies[s.Policy]
+	if !ok || len(s.Script) > 0 || len(s.RunQueue) == 0 {
+		return s.NextActor()
+	}
+	return policy(ev)
+}
+
+// policyName is the policy the scheduler runs under
+func (s *Scheduler) policyName() string {
+	if s.Policy == "" {
+		return "round-robin"
+	}
+	return s.Policy
+}
+
+// (set-scheduler-policy! policy [seed]) - choose how the next actor is
+// picked, reseeding rand if a seed is given; returns the previous policy
+func builtinSetSchedulerPolicy(ev *Evaluator, args []Value, env *Env) Value {
+	name := valueToString(args[0])
+	if _, ok := schedulerPolicies[name]; !ok {
+		ev.warnTrace("", "set-scheduler-policy!: unknown policy %s (expected one of %v)", name, policyNames())
+		return Nil()
+	}
+	previous := ev.Scheduler.policyName()
+	ev.Scheduler.Policy = name
+	if len(args) > 1 {
+		ev.SetSeed(int64(args[1].Number))
+	}
+	return Sym(previous)
+}
+
+// (scheduler-policy) - the policy the scheduler runs under
+func builtinSchedulerPolicy(ev *Evaluator, args []Value, env *Env) Value {
+	return Sym(ev.Scheduler.policyName())
+}
+
+// (set-priority! actor n) - the actor's priority under the priority
+// policy, higher first; returns the previous priority
+func builtinSetPriority(ev *Evaluator, args []Value, env *Env) Value {
+	name := valueToString(args[0])
+	actor := ev.Scheduler.GetActor(name)
+	if actor == nil {
+		ev.warnTrace("", "set-priority!: unknown actor %s", name)
+		return Nil()
+	}
+	previous := actor.Priority
+	actor.Priority = int(args[1].Number)
+	return Int(int64(previous))
+}
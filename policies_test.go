@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Scheduler Policy Tests
+// ============================================================================
+
+// stepper spawns actors that note each step they take in order
+const steppers = `
+	(define order '())
+	(define (stepper name n)
+	  (set! order (cons name order))
+	  (if (> n 1) (list 'become (list 'stepper (list 'quote name) (- n 1))) 'done))
+	(spawn-actor 'a 4 '(stepper 'a 3))
+	(spawn-actor 'b 4 '(stepper 'b 3) :priority 1)
+	(spawn-actor 'c 4 '(stepper 'c 3))
+`
+
+func TestSchedulerPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{"round-robin by default", `
+			(run-scheduler 100)
+			(list (scheduler-policy) (reverse order))`,
+			"(round-robin (a b c a b c a b c))"},
+		{"priority runs the highest first", `
+			(set-scheduler-policy! 'priority)
+			(set-priority! 'c 2)
+			(run-scheduler 100)
+			(reverse order)`,
+			"(c c c b b b a a a)"},
+		{"equal priorities take turns", `
+			(set-scheduler-policy! 'priority)
+			(set-priority! 'a 1)
+			(run-scheduler 100)
+			(reverse order)`,
+			"(a b a b a b c c c)"},
+		{"setting the policy returns the previous one", `
+			(list (set-scheduler-policy! 'random 1) (set-scheduler-policy! 'priority) (scheduler-policy))`,
+			"(round-robin random priority)"},
+		{"configured runs default to the scheduler's policy", `
+			(set-scheduler-policy! 'priority)
+			(run-scheduler :max-steps 100 :seed 1)
+			(list (map-get (run-config) 'policy) (reverse order))`,
+			"(priority (b b b a c a c a c))"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := NewEvaluator(1000)
+			ev.Quiet = true
+			runCode(ev, "(define (reverse l) (fold (lambda (acc x) (cons x acc)) '() l))")
+			runCode(ev, steppers)
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRandomPolicyIsSeeded(t *testing.T) {
+	run := func(seed string) string {
+		ev := NewEvaluator(1000)
+		ev.Quiet = true
+		runCode(ev, steppers)
+		return evalLast(ev, "(set-scheduler-policy! 'random "+seed+") (run-scheduler 100) order").String()
+	}
+	first := run("42")
+	if again := run("42"); again != first {
+		t.Errorf("same seed gave %s, then %s", first, again)
+	}
+	differs := false
+	for _, seed := range []string{"1", "2", "3", "4", "5"} {
+		if run(seed) != first {
+			differs = true
+		}
+	}
+	if !differs {
+		t.Errorf("every seed gave the schedule %s", first)
+	}
+}
+
+func TestUnknownSchedulerPolicy(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	got := evalLast(ev, "(list (set-scheduler-policy! 'fastest) (scheduler-policy) (set-priority! 'nobody 3))").String()
+	if got != "(nil round-robin nil)" {
+		t.Errorf("got %s, want (nil round-robin nil)", got)
+	}
+	if lastWarning(ev, "set-scheduler-policy!: unknown policy fastest (expected one of [priority random round-robin])") == nil {
+		t.Errorf("expected a warning naming the policies, got %v", ev.Warnings)
+	}
+	if lastWarning(ev, "set-priority!: unknown actor nobody") == nil {
+		t.Errorf("expected a warning about the unknown actor, got %v", ev.Warnings)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ============================================================================
+// Pretty Printing
+// ============================================================================
+//
+// PrettyPrint prints a value the way String() does when it fits on a line,
+// and otherwise breaks it over several lines with nested structure
+// indented. Forms headed by a symbol keep their first argument on the
+// head's line and indent the rest by two, like code:
+//
+//   (define (serve n)
+//     (print n)
+//     (become (serve (+ n 1))))
+//
+// Other lists, maps and sets put one item per line under the first.
+
+// prettyWidth is the line width PrettyPrint wraps at
+const prettyWidth = 72
+
+// PrettyPrint prints v wrapped to prettyWidth columns
+func PrettyPrint(v Value) string {
+	return PrettyPrintWidth(v, prettyWidth)
+}
+
+// PrettyPrintWidth prints v wrapped to width columns
+func PrettyPrintWidth(v Value, width int) string {
+	var sb strings.Builder
+	writePretty(&sb, v, 0, width, nil)
+	return sb.String()
+}
+
+// writePretty writes v starting at column col, inside the containers in
+// enclosing (see Value.format)
+func writePretty(sb *strings.Builder, v Value, col, width int, enclosing []interface{}) {
+	flat := v.format(enclosing)
+	if col+len(flat) <= width || flat == "#cycle" {
+		sb.WriteString(flat)
+		return
+	}
+	if c := containerOf(v); c != nil {
+		enclosing = append(enclosing, c)
+	}
+	switch v.Type {
+	case TypeList:
+		if len(v.List) > 1 && v.List[0].Type == TypeSymbol {
+			head := "(" + v.List[0].Symbol + " "
+			sb.WriteString(head)
+			writePretty(sb, v.List[1], col+len(head), width, enclosing)
+			writePrettyLines(sb, v.List[2:], col+2, width, enclosing)
+			sb.WriteByte(')')
+			return
+		}
+		writePrettyItems(sb, "(", ")", v.List, col, width, enclosing)
+	case TypeSet:
+		writePrettyItems(sb, "#{", "}", v.Set.Sorted(), col, width, enclosing)
+	case TypeMap:
+		sb.WriteByte('{')
+		for i, k := range v.Map.SortedKeys() {
+			if i > 0 {
+				sb.WriteString("\n" + strings.Repeat(" ", col+1))
+			}
+			sb.WriteString(k + " ")
+			writePretty(sb, v.Map.Data[k], col+1+len(k)+1, width, enclosing)
+		}
+		sb.WriteByte('}')
+	case TypeTagged:
+		open := fmt.Sprintf("#%s{", v.Tagged.Tag)
+		sb.WriteString(open)
+		writePretty(sb, v.Tagged.Value, col+len(open), width, enclosing)
+		sb.WriteByte('}')
+	default:
+		sb.WriteString(flat)
+	}
+}
+
+// writePrettyItems writes items one per line, lined up after open
+func writePrettyItems(sb *strings.Builder, open, close string, items []Value, col, width int, enclosing []interface{}) {
+	sb.WriteString(open)
+	if len(items) > 0 {
+		writePretty(sb, items[0], col+len(open), width, enclosing)
+		writePrettyLines(sb, items[1:], col+len(open), width, enclosing)
+	}
+	sb.WriteString(close)
+}
+
+// writePrettyLines writes each item on a new line starting at column col
+func writePrettyLines(sb *strings.Builder, items []Value, col, width int, enclosing []interface{}) {
+	for _, item := range items {
+		sb.WriteString("\n" + strings.Repeat(" ", col))
+		writePretty(sb, item, col, width, enclosing)
+	}
+}
+
+// (pp value [:width n]) - print value wrapped and indented
+func builtinPP(ev *Evaluator, args []Value, env *Env) Value {
+	args, opts := keywordArgs(args)
+	if len(args) == 0 {
+		return Nil()
+	}
+	width := prettyWidth
+	if n, ok := opts["width"]; ok && n.Type == TypeNumber && n.Number > 0 {
+		width = int(n.Number)
+	}
+	if ev.hostOutput() {
+		fmt.Println(PrettyPrintWidth(args[0], width))
+	}
+	return Nil()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Pretty Printer Tests
+// ============================================================================
+
+func TestPrettyPrint(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	tests := []struct {
+		code     string
+		width    int
+		expected string
+	}{
+		{`'(a b c)`, 72, `(a b c)`},
+		{`'(define (serve n) (print n) (become (serve (+ n 1))))`, 30,
+			"(define (serve n)\n  (print n)\n  (become (serve (+ n 1))))"},
+		{`'((1 2 3) (4 5 6) (7 8 9))`, 12, "((1 2 3)\n (4 5 6)\n (7 8 9))"},
+		{`'(begin (send-to! bob (order 1 2 3)) done)`, 24,
+			"(begin (send-to! bob\n         (order 1 2 3))\n  done)"},
+		{`(let m (make-map) (begin (map-set! m 'items '(1000 2000)) (map-set! m 'n 2) m))`, 16,
+			"{items (1000\n        2000)\n n 2}"},
+		{`(make-set 'alpha 'beta 'gamma)`, 10, "#{alpha\n  beta\n  gamma}"},
+		{`(tag 'point (list 100 200))`, 12, "#point{(100\n        200)}"},
+		{`"a long string that does not fit"`, 10, `"a long string that does not fit"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			v := evalLast(ev, tt.code)
+			if got := PrettyPrintWidth(v, tt.width); got != tt.expected {
+				t.Errorf("expected\n%s\ngot\n%s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPrettyPrintKeepsWidth(t *testing.T) {
+	ev := NewEvaluator(1000)
+	v := evalLast(ev, `'(define (worker n) (let msg (receive!) (cond ((= msg 'stop) 'done) (else (begin (send-to! 'log (list 'got msg n)) (become (worker (+ n 1))))))))`)
+	out := PrettyPrint(v)
+	for _, line := range strings.Split(out, "\n") {
+		if len(line) > prettyWidth {
+			t.Errorf("line longer than %d columns: %q", prettyWidth, line)
+		}
+	}
+	// Reading the printed form back gives the same value
+	if back := evalLast(ev, "'"+out); back.String() != v.String() {
+		t.Errorf("printed form reads back as %s", back.String())
+	}
+}
+
+func TestCyclicValuesPrint(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	evalLast(ev, `
+		(define m (make-map 'name "loop"))
+		(map-set! m 'self m)
+		(define s (make-stack 2))
+		(push! s 1)
+		(stack-write! s 0 s)
+		(define outer (make-map 'inner (make-map 'back m)))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`m`, `{name "loop" self #cycle}`},
+		{`(list m m)`, `({name "loop" self #cycle} {name "loop" self #cycle})`},
+		{`outer`, `{inner {back {name "loop" self #cycle}}}`},
+		{`(let set (make-set 1) (set-add! set set))`, `#{#cycle 1}`},
+		{`(str m)`, `"{name \"loop\" self #cycle}"`},
+		{`(write-value s)`, "nil"},
+		{`(json-stringify m)`, "nil"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	if lastWarning(ev, "json-stringify: {name \"loop\" self #cycle} contains itself") == nil {
+		t.Errorf("no warning that json-stringify met a cycle")
+	}
+
+	if got := PrettyPrintWidth(evalLast(ev, "m"), 10); got != "{name \"loop\"\n self #cycle}" {
+		t.Errorf("pretty printed as %q", got)
+	}
+	if got := PrettyPrintWidth(evalLast(ev, "(list s 'a)"), 10); got != "(<stack 1/2>\n a)" {
+		t.Errorf("pretty printed as %q", got)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// ============================================================================
+// Timing and Profiling
+// ============================================================================
+//
+// (time expr) evaluates expr and reports how long it took:
+//
+//   (time (run-scheduler 500))
+//   ; time: 12.4ms, 500 steps, 48210 evaluations
+//   ; => {evals 48210 ms 12.4 steps 500 value (completed 500)}
+//
+// (profile expr) also counts, per function, the expressions evaluated in
+// its own body (not in the functions it calls) and how often it was
+// called, and prints the hottest:
+//
+//   (profile (run-scheduler 500) :top 5)
+//   ;   evals  calls  function
+//   ;   31022   4500  bakery-loop
+//   ;    9120   1000  customer
+//   ; => {functions ((bakery-loop 31022 4500) ...) value (completed 500)}
+//
+// Expressions outside any function count toward actor:name or top-level.
+// Builtins have calls but no evaluations of their own.
+
+// profileEntry is what a profile counts for one function
+type profileEntry struct {
+	Evals int64
+	Calls int64
+}
+
+// profileDefaultTop is how many functions profile prints by default
+const profileDefaultTop = 10
+
+// countEval is called for each expression evaluated
+func (ev *Evaluator) countEval() {
+	ev.EvalCount++
+	if ev.Profile != nil {
+		ev.profileEntry(ev.currentFunction()).Evals++
+	}
+}
+
+// countCall is called for each call of fn while profiling
+func (ev *Evaluator) countCall(fn Value) {
+	name := "lambda"
+	switch {
+	case fn.Type == TypeFunc && fn.Func.Name != "":
+		name = fn.Func.Name
+	case fn.Type == TypeBuiltin:
+		name = ev.builtinName(fn)
+	}
+	ev.profileEntry(name).Calls++
+}
+
+func (ev *Evaluator) profileEntry(name string) *profileEntry {
+	e := ev.Profile[name]
+	if e == nil {
+		e = &profileEntry{}
+		ev.Profile[name] = e
+	}
+	return e
+}
+
+// currentFunction names the function whose body is being evaluated
+func (ev *Evaluator) currentFunction() string {
+	if n := len(ev.CallStack.Data); n > 0 {
+		return ev.CallStack.Data[n-1].List[0].Symbol
+	}
+	if ev.Scheduler.CurrentActor != "" {
+		return "actor:" + ev.Scheduler.CurrentActor
+	}
+	return "top-level"
+}
+
+// builtinName finds the name a builtin is bound to globally
+func (ev *Evaluator) builtinName(fn Value) string {
+	if fn.Symbol != "" {
+		return fn.Symbol
+	}
+	ptr := fmt.Sprintf("%p", fn.Builtin)
+	if name, ok := ev.builtinNames[ptr]; ok {
+		return name
+	}
+	if ev.builtinNames == nil {
+		ev.builtinNames = make(map[string]string)
+	}
+	name := "builtin"
+	for _, k := range sortedKeys(ev.GlobalEnv.bindings) {
+		if v := ev.GlobalEnv.bindings[k]; v.Type == TypeBuiltin && fmt.Sprintf("%p", v.Builtin) == ptr {
+			name = k
+			break
+		}
+	}
+	ev.builtinNames[ptr] = name
+	return name
+}
+
+// timed evaluates expr, counting the wall time, actor steps and
+// evaluations it took
+func (ev *Evaluator) timed(expr Value, env *Env) (result Value, elapsed time.Duration, steps, evals int64) {
+	id := ev.Events.Subscribe(func(_ *Evaluator, e SchedEvent) {
+		if e.Kind == EventActorScheduled {
+			steps++
+		}
+	})
+	defer ev.Events.Unsubscribe(id)
+	start, evals0 := time.Now(), ev.EvalCount
+	result = ev.Eval(expr, env)
+	return result, time.Since(start), steps, ev.EvalCount - evals0
+}
+
+// evalTime is the time special form: (time expr)
+func (ev *Evaluator) evalTime(args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Nil()
+	}
+	result, elapsed, steps, evals := ev.timed(args[0], env)
+	ms := float64(elapsed.Microseconds()) / 1000
+	if !ev.Quiet {
+		fmt.Fprintf(os.Stderr, "time: %.1fms, %d steps, %d evaluations\n", ms, steps, evals)
+	}
+	return mapValue(map[string]Value{
+		"value": result,
+		"ms":    Num(ms),
+		"steps": Int(steps),
+		"evals": Int(evals),
+	})
+}
+
+// evalProfile is the profile special form: (profile expr [:top n])
+func (ev *Evaluator) evalProfile(args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Nil()
+	}
+	top := profileDefaultTop
+	if len(args) >= 3 && args[1].IsSymbol() && args[1].Symbol == ":top" {
+		if n := ev.Eval(args[2], env); n.Type == TypeNumber && n.Number > 0 {
+			top = int(n.Number)
+		}
+	}
+
+	outer := ev.Profile
+	ev.Profile = make(map[string]*profileEntry)
+	result, elapsed, steps, evals := ev.timed(args[0], env)
+	counts := ev.Profile
+	ev.Profile = outer
+
+	names := sortedKeys(counts)
+	sort.SliceStable(names, func(i, j int) bool {
+		a, b := counts[names[i]], counts[names[j]]
+		if a.Evals != b.Evals {
+			return a.Evals > b.Evals
+		}
+		return a.Calls > b.Calls
+	})
+	if len(names) > top {
+		names = names[:top]
+	}
+
+	rows := make([]Value, len(names))
+	for i, name := range names {
+		rows[i] = Lst(Sym(name), Int(counts[name].Evals), Int(counts[name].Calls))
+	}
+	if !ev.Quiet {
+		fmt.Fprintf(os.Stderr, "profile: %.1fms, %d steps, %d evaluations\n",
+			float64(elapsed.Microseconds())/1000, steps, evals)
+		fmt.Fprintf(os.Stderr, "  %8s %7s  %s\n", "evals", "calls", "function")
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "  %8d %7d  %s\n", counts[name].Evals, counts[name].Calls, name)
+		}
+	}
+	return mapValue(map[string]Value{
+		"value":     result,
+		"functions": Lst(rows...),
+	})
+}
+
+// mapValue builds a LISP map with symbol keys
+func mapValue(fields map[string]Value) Value {
+	m := NewHashMap()
+	for k, v := range fields {
+		key := Sym(k)
+		m.Keys[key.String()] = key
+		m.Data[key.String()] = v
+	}
+	return Value{Type: TypeMap, Map: m}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Timing and Profiling Tests
+// ============================================================================
+
+func TestTime(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (racer n) (if (> n 0) (list 'become (list 'racer (- n 1))) 'done))
+		(spawn-actor 'a 4 '(racer 3))
+		(spawn-actor 'b 4 '(racer 3))
+		(define timing (time (run-scheduler 100)))`)
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(map-get timing 'value)", "(completed 8)"},
+		{"(map-get timing 'steps)", "8"},
+		{"(> (map-get timing 'evals) 50)", "true"},
+		{"(number? (map-get timing 'ms))", "true"},
+		{"(map-get (time (+ 1 2)) 'value)", "3"},
+		{"(map-get (time (+ 1 2)) 'evals)", "4"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (square x) (* x x))
+		(define (sum-squares n) (if (= n 0) 0 (+ (square n) (sum-squares (- n 1)))))
+		(define report (profile (sum-squares 10) :top 3))`)
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(map-get report 'value)", "385"},
+		{"(map-get report 'functions)", "((sum-squares 166 11) (square 40 10) (top-level 3 0))"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	if ev.Profile != nil {
+		t.Errorf("profiling should stop when profile returns")
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+// ============================================================================
+// Livelock and Starvation
+// ============================================================================
+//
+// A run that ends at max-steps may have been busy or may have been stuck
+// in a way deadlock detection can't see: an actor that keeps running
+// without getting anywhere (livelock), or one that could run but never
+// gets a turn (starvation). Each actor keeps count of its progress, the
+// messages it has received and sent and the times it has become new code,
+// and of how long it has gone without either:
+//
+//   Idle      steps in a row it took without progress
+//   Waiting   steps other actors took while it was waiting to run
+//
+// A run configured with :livelock n stops with
+// (livelock step ((actor idle) ...)) once an actor has gone n steps
+// without progress, and :starvation n stops with
+// (starved step ((actor waiting) ...)) once a runnable actor has waited n
+// steps. Either also asserts (livelock actor step) or (starved actor step)
+// for each actor it names.
+
+// progressMark is how far an actor had got when a step started
+type progressMark struct {
+	received, sent, becomes int
+}
+
+func (a *Actor) progressMark() progressMark {
+	return progressMark{a.Received, a.Sent, a.Becomes}
+}
+
+// noteProgress updates the counts after actor's step, which started at
+// before
+func (ev *Evaluator) noteProgress(actor *Actor, before progressMark) {
+	if actor.progressMark() != before || actor.State == ActorDone {
+		actor.Idle = 0
+	} else {
+		actor.Idle++
+	}
+	actor.Waiting = 0
+	for _, name := range ev.Scheduler.RunQueue {
+		if name != actor.Name {
+			a := ev.Scheduler.Actors[name]
+			a.Waiting++
+			if a.Waiting > a.LongestWait {
+				a.LongestWait = a.Waiting
+			}
+		}
+	}
+}
+
+// progressStop ends a run with livelock or starvation if cfg asks to look
+// for it and it has happened; nil otherwise
+func (ev *Evaluator) progressStop(cfg RunConfig) Value {
+	s := ev.Scheduler
+	for _, check := range []struct {
+		result string
+		limit  int64
+		count  func(a *Actor) int64
+	}{
+		{"livelock", cfg.Livelock, func(a *Actor) int64 { return a.Idle }},
+		{"starved", cfg.Starvation, func(a *Actor) int64 { return a.Waiting }},
+	} {
+		if check.limit <= 0 {
+			continue
+		}
+		var found []Value
+		for _, name := range s.Names() {
+			a := s.Actors[name]
+			if a.State == ActorRunnable && check.count(a) >= check.limit {
+				found = append(found, Lst(Sym(name), Int(check.count(a))))
+				ev.DatalogDB.AssertAtTime(check.result, ev.now(), Atom(name))
+			}
+		}
+		if len(found) > 0 {
+			return Lst(Sym(check.result), Int(s.StepCount), Lst(found...))
+		}
+	}
+	return Nil()
+}
+
+// (actor-progress actor) - {received n sent n becomes n idle n waiting n}
+func builtinActorProgress(ev *Evaluator, args []Value, env *Env) Value {
+	name := valueToString(args[0])
+	a := ev.Scheduler.GetActor(name)
+	if a == nil {
+		ev.warnTrace("", "actor-progress: unknown actor %s", name)
+		return Nil()
+	}
+	return mapValue(map[string]Value{
+		"received": Int(int64(a.Received)),
+		"sent":     Int(int64(a.Sent)),
+		"becomes":  Int(int64(a.Becomes)),
+		"idle":     Int(a.Idle),
+		"waiting":  Int(a.Waiting),
+	})
+}
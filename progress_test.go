@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Livelock and Starvation Tests
+// ============================================================================
+
+func TestLivelock(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (spin) 'yield)
+		(define (worker n) (send-to! 'sink n) (if (> n 0) (list 'become (list 'worker (- n 1))) 'done))
+		(define (sink) (receive!) (list 'become '(sink)))
+		(spawn-actor 'spinner 2 '(spin))
+		(spawn-actor 'worker 4 '(worker 3))
+		(spawn-actor 'sink 4 '(sink))`)
+
+	got := evalLast(ev, "(run-scheduler :max-steps 100 :livelock 5)").String()
+	if !strings.HasPrefix(got, "(livelock 13 ((spinner 5)) {livelock 5 ") {
+		t.Errorf("got %s, want the spinner reported as livelocked at step 13", got)
+	}
+	if got := evalLast(ev, "(query 'livelock '?a)").String(); got != "(((a spinner)))" {
+		t.Errorf("livelock facts = %s", got)
+	}
+	for actor, want := range map[string]string{
+		"worker":  "{becomes 3 idle 0 received 0 sent 4 waiting 0}",
+		"sink":    "{becomes 4 idle 0 received 4 sent 0 waiting 1}",
+		"spinner": "{becomes 0 idle 5 received 0 sent 0 waiting 0}",
+	} {
+		if got := evalLast(ev, "(actor-progress '"+actor+")").String(); got != want {
+			t.Errorf("(actor-progress '%s) = %s, want %s", actor, got, want)
+		}
+	}
+}
+
+func TestStarvation(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (spin) 'yield)
+		(spawn-actor 'hog 2 '(spin) :priority 1)
+		(spawn-actor 'meek 2 '(spin))`)
+
+	got := evalLast(ev, "(run-scheduler :max-steps 100 :policy 'priority :starvation 10)").String()
+	if !strings.HasPrefix(got, "(starved 10 ((meek 10)) ") {
+		t.Errorf("got %s, want meek reported as starved at step 10", got)
+	}
+	if got := evalLast(ev, "(query 'starved '?a)").String(); got != "(((a meek)))" {
+		t.Errorf("starved facts = %s", got)
+	}
+
+	// Without limits the same run just goes on to max-steps
+	got = evalLast(ev, "(run-scheduler :max-steps 20 :policy 'priority)").String()
+	if !strings.HasPrefix(got, "(max-steps ") {
+		t.Errorf("got %s, want the run to end at max-steps", got)
+	}
+	if evalLast(ev, "(actor-progress 'nobody)").Type != TypeNil || lastWarning(ev, "actor-progress: unknown actor nobody") == nil {
+		t.Errorf("expected nil and a warning for an unknown actor, got %v", ev.Warnings)
+	}
+}
@@ -15,33 +15,8 @@
 ; Higher-Order Functions
 ; ----------------------------------------------------------------------------
 
-(define (map f lst)
-  (if (empty? lst)
-      '()
-      (cons (f (first lst)) (map f (rest lst)))))
-
-(define (filter pred lst)
-  (if (empty? lst)
-      '()
-      (if (pred (first lst))
-          (cons (first lst) (filter pred (rest lst)))
-          (filter pred (rest lst)))))
-
-(define (fold f init lst)
-  (if (empty? lst)
-      init
-      (fold f (f init (first lst)) (rest lst))))
-
-(define (reduce f lst)
-  (if (empty? lst)
-      nil
-      (fold f (first lst) (rest lst))))
-
-(define (for-each f lst)
-  (if (empty? lst)
-      nil
-      (do (f (first lst))
-          (for-each f (rest lst)))))
+; map, filter, reduce, fold, fold-left, fold-right and for-each are Go
+; builtins, so they work on lists longer than the call stack.
 
 ; ----------------------------------------------------------------------------
 ; List Utilities
@@ -59,7 +34,7 @@
 (define (member? x lst)
   (if (empty? lst)
       false
-      (if (= x (first lst))
+      (if (equal? x (first lst))
           true
           (member? x (rest lst)))))
 
@@ -71,20 +46,6 @@
             (unique (rest lst))
             (cons x (unique (rest lst)))))))
 
-(define (assoc key lst)
-  (if (empty? lst)
-      nil
-      (if (= key (first (first lst)))
-          (first lst)
-          (assoc key (rest lst)))))
-
-(define (assoc-set key val lst)
-  (if (empty? lst)
-      (list (list key val))
-      (if (= key (first (first lst)))
-          (cons (list key val) (rest lst))
-          (cons (first lst) (assoc-set key val (rest lst))))))
-
 (define (range start end)
   (if (>= start end)
       '()
@@ -229,35 +190,35 @@
       (let type (tag-type formula)
         (let val (tag-value formula)
           (cond
-            ((= type 'ctl-prop)
+            ((equal? type 'ctl-prop)
              (string-append "\\mathit{" (symbol->string val) "}"))
-            ((= type 'ctl-EX)
+            ((equal? type 'ctl-EX)
              (string-append "\\mathbf{EX}\\," (ctl->latex val)))
-            ((= type 'ctl-AX)
+            ((equal? type 'ctl-AX)
              (string-append "\\mathbf{AX}\\," (ctl->latex val)))
-            ((= type 'ctl-EF)
+            ((equal? type 'ctl-EF)
              (string-append "\\mathbf{EF}\\," (ctl->latex val)))
-            ((= type 'ctl-AF)
+            ((equal? type 'ctl-AF)
              (string-append "\\mathbf{AF}\\," (ctl->latex val)))
-            ((= type 'ctl-EG)
+            ((equal? type 'ctl-EG)
              (string-append "\\mathbf{EG}\\," (ctl->latex val)))
-            ((= type 'ctl-AG)
+            ((equal? type 'ctl-AG)
              (string-append "\\mathbf{AG}\\," (ctl->latex val)))
-            ((= type 'ctl-EU)
+            ((equal? type 'ctl-EU)
              (string-append "\\mathbf{E}[" (ctl->latex (first val)) 
                            " \\mathbf{U} " (ctl->latex (second val)) "]"))
-            ((= type 'ctl-AU)
+            ((equal? type 'ctl-AU)
              (string-append "\\mathbf{A}[" (ctl->latex (first val)) 
                            " \\mathbf{U} " (ctl->latex (second val)) "]"))
-            ((= type 'ctl-and)
+            ((equal? type 'ctl-and)
              (string-append "(" (ctl->latex (first val)) 
                            " \\land " (ctl->latex (second val)) ")"))
-            ((= type 'ctl-or)
+            ((equal? type 'ctl-or)
              (string-append "(" (ctl->latex (first val)) 
                            " \\lor " (ctl->latex (second val)) ")"))
-            ((= type 'ctl-not)
+            ((equal? type 'ctl-not)
              (string-append "\\neg " (ctl->latex val)))
-            ((= type 'ctl-implies)
+            ((equal? type 'ctl-implies)
              (string-append "(" (ctl->latex (first val)) 
                            " \\rightarrow " (ctl->latex (second val)) ")"))
             (true
@@ -323,7 +284,7 @@
 (define (metrics-set state key val)
   (if (empty? state)
       (list (cons key val))
-      (if (= key (first (first state)))
+      (if (equal? key (first (first state)))
           (cons (cons key val) (rest state))
           (cons (first state) (metrics-set (rest state) key val)))))
 
@@ -360,25 +321,25 @@
     (let cmd (first msg)
       (let state (registry-get *metrics-key*)
         (cond
-          ((= cmd 'inc)
+          ((equal? cmd 'inc)
            (let name (second msg)
              (let delta (if (> (length msg) 2) (third msg) 1)
                (do
                  (registry-set! *metrics-key* (update-counter state name delta))
                  (list 'become '(metrics-collector-loop))))))
-          ((= cmd 'gauge)
+          ((equal? cmd 'gauge)
            (let name (second msg)
              (let value (third msg)
                (do
                  (registry-set! *metrics-key* (update-gauge state name value))
                  (list 'become '(metrics-collector-loop))))))
-          ((= cmd 'timing)
+          ((equal? cmd 'timing)
            (let name (second msg)
              (let value (third msg)
                (do
                  (registry-set! *metrics-key* (add-timing state name value))
                  (list 'become '(metrics-collector-loop))))))
-          ((= cmd 'get)
+          ((equal? cmd 'get)
            (let sender (second msg)
              (do
                (send-to! sender (list 'metrics state))
@@ -471,18 +432,4 @@
 (define (grammar->flowchart name)
   "graph LR\n    A --> B\n")
 
-; ----------------------------------------------------------------------------
-; Utility Macros (via functions)
-; ----------------------------------------------------------------------------
-
-(define (when cond . body)
-  (if cond
-      (eval (cons 'do body))
-      nil))
-
-(define (unless cond . body)
-  (if cond
-      nil
-      (eval (cons 'do body))))
-
 (println "; Prologue loaded")
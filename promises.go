@@ -0,0 +1,112 @@
+package main
+
+import "fmt"
+
+// ============================================================================
+// Promises
+// ============================================================================
+//
+// An actor that hands out work and wants the results back can collect
+// them from its mailbox, but then it has to sort out which answer is
+// which and what else arrived meanwhile. A promise is a slot for one
+// result that any actor can fill and any actor can wait on:
+//
+//   (let ((p (make-promise)))        ; => #promise{1}
+//     (send-to! 'worker (list 'job p 21))
+//     (await p))                     ; => 42, once the worker has run
+//
+//   ;; in the worker
+//   (deliver! p (* 2 n))             ; => ok
+//
+// await blocks the actor until the promise is delivered, then returns
+// its value, and returns it straight away ever after. A promise is
+// delivered once; delivering it again warns and changes nothing. The
+// promises live in the scheduler, so they are part of snapshots and
+// explored worlds, and the value passed around is just #promise{n}.
+
+// Promise is one make-promise's slot
+type Promise struct {
+	Delivered bool
+	Value     Value
+}
+
+// (make-promise) - a new undelivered promise, #promise{n}
+func builtinMakePromise(ev *Evaluator, args []Value, env *Env) Value {
+	s := ev.Scheduler
+	s.Promises = append(s.Promises, Promise{})
+	return Value{Type: TypeTagged, Tagged: &TaggedValue{Tag: "promise", Value: Int(int64(len(s.Promises)))}}
+}
+
+// promise is the promise v names, or nil if it doesn't name one
+func (ev *Evaluator) promise(v Value) *Promise {
+	if v.Type != TypeTagged || v.Tagged.Tag != "promise" || v.Tagged.Value.Type != TypeNumber {
+		return nil
+	}
+	n := int(v.Tagged.Value.Number)
+	if n < 1 || n > len(ev.Scheduler.Promises) {
+		return nil
+	}
+	return &ev.Scheduler.Promises[n-1]
+}
+
+// awaitReason is the reason an actor waiting on p is blocked
+func awaitReason(p Value) string {
+	return fmt.Sprintf("await %s", p.String())
+}
+
+// (deliver! p v) - fill promise p with v and wake the actors awaiting it
+func builtinDeliver(ev *Evaluator, args []Value, env *Env) Value {
+	p := ev.promise(args[0])
+	if p == nil {
+		ev.warnTrace("", "deliver!: not a promise: %s", args[0].String())
+		return Nil()
+	}
+	if p.Delivered {
+		ev.warnTrace("", "deliver!: %s already delivered", args[0].String())
+		return Nil()
+	}
+	p.Delivered, p.Value = true, args[1]
+	by := ev.Scheduler.CurrentActor
+	if by == "" {
+		by = "external"
+	}
+	ev.DatalogDB.AssertAtTime("promise-delivered", ev.now(),
+		NumTerm(args[0].Tagged.Value.Number), Atom(by), ValueToTerm(args[1]))
+	reason := awaitReason(args[0])
+	for _, name := range ev.Scheduler.Names() {
+		if a := ev.Scheduler.Actors[name]; a.State == ActorBlocked && a.BlockedOn == reason {
+			ev.Scheduler.UnblockActor(name)
+		}
+	}
+	return Sym("ok")
+}
+
+// (await p) - the value of promise p, blocking the running actor until it
+// has been delivered
+func builtinAwait(ev *Evaluator, args []Value, env *Env) Value {
+	p := ev.promise(args[0])
+	if p == nil {
+		ev.warnTrace("", "await: not a promise: %s", args[0].String())
+		return Nil()
+	}
+	ev.markGuardSeen() // CSP: waiting is a synchronization point
+	if p.Delivered {
+		return p.Value
+	}
+	if ev.Scheduler.CurrentActor == "" {
+		ev.warnTrace("", "await: %s not delivered, and no actor to wait", args[0].String())
+		return Nil()
+	}
+	ev.Scheduler.BlockActor(ev.Scheduler.CurrentActor, awaitReason(args[0]))
+	return Blocked(BlockPromise)
+}
+
+// (promise-ready? p) - whether promise p has been delivered
+func builtinPromiseReady(ev *Evaluator, args []Value, env *Env) Value {
+	p := ev.promise(args[0])
+	if p == nil {
+		ev.warnTrace("", "promise-ready?: not a promise: %s", args[0].String())
+		return Nil()
+	}
+	return Bool(p.Delivered)
+}
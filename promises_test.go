@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Promise Tests
+// ============================================================================
+
+func TestPromises(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (worker)
+		  (let ((job (receive!)))
+		    (deliver! (car (cdr job)) (* 2 (car (cdr (cdr job))))))
+		  (list 'become '(worker)))
+		(define (boss)
+		  (let ((p1 (make-promise)) (p2 (make-promise)))
+		    (send-to! 'w1 (list 'job p1 21))
+		    (send-to! 'w2 (list 'job p2 5))
+		    (assert! 'total (+ (await p1) (await p2))))
+		  'done)
+		(spawn-actor 'w1 2 '(worker))
+		(spawn-actor 'w2 2 '(worker))
+		(spawn-actor 'boss 2 '(boss))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		// boss blocks on p1, and its retry reuses the same two promises
+		{"(run-scheduler 50)", `(deadlock 8 ((w1 "recv (empty)") (w2 "recv (empty)")))`},
+		{"(query 'total '?t)", "(((t 52)))"},
+		{"(query 'promise-delivered '?n '?by '?v)", "(((by w1) (n 1) (v 42)) ((by w2) (n 2) (v 10)))"},
+		{"(actor-result 'boss)", "done"},
+		{"(define p (make-promise))", "#promise{3}"},
+		{"(promise-ready? p)", "false"},
+		{"(await p)", "nil"},
+		{"(deliver! p 1)", "ok"},
+		{"(deliver! p 2)", "nil"},
+		{"(list (await p) (promise-ready? p))", "(1 true)"},
+		{"(await 'p)", "nil"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	for _, w := range []string{
+		"await: #promise{3} not delivered, and no actor to wait",
+		"deliver!: #promise{3} already delivered",
+		"await: not a promise: p",
+	} {
+		if lastWarning(ev, w) == nil {
+			t.Errorf("expected a warning %q, got %v", w, ev.Warnings)
+		}
+	}
+}
+
+func TestAwaitBlocks(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define p (make-promise))
+		(define (waiter) (assert! 'got (await p)) 'done)
+		(spawn-actor 'waiter 2 '(waiter))`)
+	if got := evalLast(ev, "(run-scheduler 10)").String(); got != `(deadlock 1 ((waiter "await #promise{1}")))` {
+		t.Fatalf("waiter should wait for the promise, got %s", got)
+	}
+	if got := evalLast(ev, "(begin (deliver! p 'x) (run-scheduler 10))").String(); got != "(completed 1)" {
+		t.Errorf("delivering should wake the waiter, got %s", got)
+	}
+	if got := evalLast(ev, "(query 'got '?v)").String(); got != "(((v x)))" {
+		t.Errorf("waiter got %s", got)
+	}
+}
@@ -321,9 +321,15 @@ func TestPrompt06CSP(t *testing.T) {
 // Helpers
 // ============================================================================
 
+// parseAll returns the parsed expressions, ignoring parse errors
+func parseAll(p *Parser) []Value {
+	exprs, _ := p.Parse()
+	return exprs
+}
+
 func runCode(ev *Evaluator, code string) {
 	parser := NewParser(code)
-	exprs := parser.Parse()
+	exprs := parseAll(parser)
 	for _, expr := range exprs {
 		ev.Eval(expr, ev.GlobalEnv)
 	}
@@ -437,7 +443,7 @@ func TestTemporalAlways(t *testing.T) {
 	`
 	
 	parser := NewParser(code)
-	exprs := parser.Parse()
+	exprs := parseAll(parser)
 	var result Value
 	for _, expr := range exprs {
 		result = ev.Eval(expr, ev.GlobalEnv)
@@ -461,7 +467,7 @@ func TestTemporalAlwaysFails(t *testing.T) {
 	code := `(always? '(temperature ok))`
 	
 	parser := NewParser(code)
-	exprs := parser.Parse()
+	exprs := parseAll(parser)
 	result := ev.Eval(exprs[0], ev.GlobalEnv)
 	
 	if result.Type != TypeBool || result.Bool {
@@ -481,7 +487,7 @@ func TestTemporalEventually(t *testing.T) {
 	code := `(eventually? '(found treasure))`
 	
 	parser := NewParser(code)
-	result := ev.Eval(parser.Parse()[0], ev.GlobalEnv)
+	result := ev.Eval(parseAll(parser)[0], ev.GlobalEnv)
 	
 	if result.Type != TypeBool || !result.Bool {
 		t.Errorf("eventually? should return true, got %v", result)
@@ -500,7 +506,7 @@ func TestTemporalEventuallyFails(t *testing.T) {
 	code := `(eventually? '(found treasure))`
 	
 	parser := NewParser(code)
-	result := ev.Eval(parser.Parse()[0], ev.GlobalEnv)
+	result := ev.Eval(parseAll(parser)[0], ev.GlobalEnv)
 	
 	if result.Type != TypeBool || result.Bool {
 		t.Errorf("eventually? should return false (never found), got %v", result)
@@ -519,7 +525,7 @@ func TestTemporalNever(t *testing.T) {
 	code := `(never? '(error critical))`
 	
 	parser := NewParser(code)
-	result := ev.Eval(parser.Parse()[0], ev.GlobalEnv)
+	result := ev.Eval(parseAll(parser)[0], ev.GlobalEnv)
 	
 	if result.Type != TypeBool || !result.Bool {
 		t.Errorf("never? should return true (no errors), got %v", result)
@@ -539,7 +545,7 @@ func TestTemporalNeverFails(t *testing.T) {
 	code := `(never? '(error critical))`
 	
 	parser := NewParser(code)
-	result := ev.Eval(parser.Parse()[0], ev.GlobalEnv)
+	result := ev.Eval(parseAll(parser)[0], ev.GlobalEnv)
 	
 	if result.Type != TypeBool || result.Bool {
 		t.Errorf("never? should return false (error occurred), got %v", result)
@@ -571,7 +577,7 @@ func TestTemporalWithActorSimulation(t *testing.T) {
 	`
 	
 	parser := NewParser(code)
-	exprs := parser.Parse()
+	exprs := parseAll(parser)
 	var result Value
 	for _, expr := range exprs {
 		result = ev.Eval(expr, ev.GlobalEnv)
@@ -624,7 +630,7 @@ func TestTemporalSafetyViolation(t *testing.T) {
 	`
 	
 	parser := NewParser(code)
-	exprs := parser.Parse()
+	exprs := parseAll(parser)
 	var result Value
 	for _, expr := range exprs {
 		result = ev.Eval(expr, ev.GlobalEnv)
@@ -671,7 +677,7 @@ func TestToolSubstitutionFullPipeline(t *testing.T) {
 	
 	// Execute LISP
 	parser := NewParser(lisp)
-	exprs := parser.Parse()
+	exprs := parseAll(parser)
 	for _, expr := range exprs {
 		ev.Eval(expr, ev.GlobalEnv)
 	}
@@ -867,7 +873,7 @@ func TestFullRendering(t *testing.T) {
 (spawn-actor 'consumer 10 '(consumer))
 (run-scheduler 20)
 `
-	for _, e := range NewParser(lisp).Parse() {
+	for _, e := range parseAll(NewParser(lisp)) {
 		ev.Eval(e, ev.GlobalEnv)
 	}
 	
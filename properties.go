@@ -0,0 +1,326 @@
+package main
+
+import (
+	"strings"
+)
+
+// ============================================================================
+// Properties Table Sync
+// ============================================================================
+//
+// Documents describe their properties in a table under a Properties
+// heading, in English with a Status column:
+//
+//   | Property      | Formal                | Status  |
+//   |---------------|-----------------------|---------|
+//   | No errors     | (never? '(error ?x))  | Pending |
+//
+// After every run the Status column is rewritten from the property
+// registry (*properties*, filled by defproperty), so a hand-written ✅
+// can't outlive the spec it described. Rows are matched to properties by
+// name: "No errors" is no-errors. A row the registry doesn't know whose
+// formal column is a check like (never? '(error ?x)) is registered, so
+// properties can be declared from the document as well. Anything that
+// can't be checked is Pending; every other cell is left as written.
+
+// Statuses written to the Status column
+const (
+	statusPass    = "✅"
+	statusFail    = "❌"
+	statusPending = "Pending"
+)
+
+// propertyKey normalizes a property name for matching: "No Errors" and
+// 'no-errors are the same property
+func propertyKey(name string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(strings.Trim(name, "`'* ")) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return sb.String()
+}
+
+// registeredProperties returns the registry's formulas by property key
+func (ev *Evaluator) registeredProperties() map[string]Value {
+	props := make(map[string]Value)
+	registry, ok := ev.GlobalEnv.Get("*properties*")
+	if !ok || registry.Type != TypeList {
+		return props
+	}
+	// Newest first, so the latest definition of a name wins
+	for i := len(registry.List) - 1; i >= 0; i-- {
+		entry := registry.List[i]
+		if entry.Type == TypeList && len(entry.List) >= 2 {
+			props[propertyKey(valueToString(entry.List[0]))] = entry.List[1]
+		}
+	}
+	return props
+}
+
+// propertyStatus checks a property's formula against the facts. A formula
+// is a check expression, a function of no arguments, or a CTL formula.
+func (ev *Evaluator) propertyStatus(formula Value) string {
+	var holds bool
+	switch formula.Type {
+	case TypeTagged:
+		var ok bool
+		if holds, ok = ev.checkCTL(formula); !ok {
+			return statusPending
+		}
+	case TypeFunc, TypeBuiltin:
+		holds = ev.apply(formula, nil, ev.GlobalEnv).IsTruthy()
+	case TypeList:
+		if len(formula.List) == 0 || !formula.List[0].IsSymbol() {
+			return statusPending
+		}
+		if fn, ok := ev.GlobalEnv.Get(formula.List[0].Symbol); !ok || (fn.Type != TypeFunc && fn.Type != TypeBuiltin) {
+			return statusPending
+		}
+		holds = ev.Eval(formula, ev.GlobalEnv).IsTruthy()
+	default:
+		return statusPending
+	}
+	if holds {
+		return statusPass
+	}
+	return statusFail
+}
+
+// checkCTL decides the CTL formulas a single recorded run can answer:
+// EF/AF p, AG p and AG ¬p for a proposition p (any fact with predicate
+// p), and their conjunctions, disjunctions and negations. ok is false for
+// anything else.
+func (ev *Evaluator) checkCTL(f Value) (holds, ok bool) {
+	if f.Type != TypeTagged {
+		return false, false
+	}
+	both := func() (Value, Value, bool) {
+		v := f.Tagged.Value
+		if v.Type != TypeList || len(v.List) != 2 {
+			return Nil(), Nil(), false
+		}
+		return v.List[0], v.List[1], true
+	}
+	switch f.Tagged.Tag {
+	case "ctl-EF", "ctl-AF":
+		if p, isProp := ctlProposition(f.Tagged.Value); isProp {
+			return ev.DatalogDB.times(p) != nil, true
+		}
+	case "ctl-AG":
+		inner := f.Tagged.Value
+		if p, isProp := ctlProposition(inner); isProp {
+			return ev.DatalogDB.holdsAtEveryTime(p), true
+		}
+		if inner.Type == TypeTagged && inner.Tagged.Tag == "ctl-not" {
+			if p, isProp := ctlProposition(inner.Tagged.Value); isProp {
+				return ev.DatalogDB.times(p) == nil, true
+			}
+		}
+	case "ctl-not":
+		h, ok := ev.checkCTL(f.Tagged.Value)
+		return !h, ok
+	case "ctl-and", "ctl-or":
+		a, b, ok := both()
+		if !ok {
+			return false, false
+		}
+		ha, oka := ev.checkCTL(a)
+		hb, okb := ev.checkCTL(b)
+		if !oka || !okb {
+			return false, false
+		}
+		if f.Tagged.Tag == "ctl-and" {
+			return ha && hb, true
+		}
+		return ha || hb, true
+	}
+	return false, false
+}
+
+// ctlProposition returns p for (prop 'p)
+func ctlProposition(v Value) (string, bool) {
+	if v.Type != TypeTagged || v.Tagged.Tag != "ctl-prop" {
+		return "", false
+	}
+	return valueToString(v.Tagged.Value), true
+}
+
+// times returns the times facts with predicate pred were recorded at
+func (db *DatalogDB) times(pred string) map[int64]bool {
+	var times map[int64]bool
+	for _, f := range db.Facts {
+		if f.Predicate == pred {
+			if times == nil {
+				times = make(map[int64]bool)
+			}
+			times[f.Time] = true
+		}
+	}
+	return times
+}
+
+// holdsAtEveryTime reports whether pred has a fact at every time any fact
+// was recorded
+func (db *DatalogDB) holdsAtEveryTime(pred string) bool {
+	at := db.times(pred)
+	for _, f := range db.Facts {
+		if !at[f.Time] {
+			return false
+		}
+	}
+	return true
+}
+
+// SyncPropertiesTable rewrites the Status column of the table under each
+// Properties heading in markdown from the property registry, registering
+// checkable rows it doesn't know. Everything else is left as written.
+func (ev *Evaluator) SyncPropertiesTable(markdown string) string {
+	lines := strings.Split(markdown, "\n")
+	props := ev.registeredProperties()
+	underHeading, inTable := false, false
+	nameCol, formulaCol, statusCol := -1, -1, -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			underHeading = strings.Contains(strings.ToLower(trimmed), "properties")
+			inTable = false
+			continue
+		}
+		cells, isRow := tableCells(line)
+		if !isRow {
+			if inTable {
+				// One table per heading
+				underHeading, inTable = false, false
+			}
+			continue
+		}
+		if !underHeading {
+			continue
+		}
+		if !inTable {
+			inTable = true
+			nameCol, formulaCol, statusCol = propertiesColumns(cells)
+			continue
+		}
+		if statusCol < 0 || isTableRule(cells) || statusCol >= len(cells) {
+			continue
+		}
+		key := propertyKey(cells[nameCol])
+		formula, known := props[key]
+		if !known && formulaCol >= 0 && formulaCol < len(cells) {
+			if f, ok := parseCheck(cells[formulaCol]); ok {
+				formula, known = f, true
+				ev.registerProperty(key, f)
+				props[key] = f
+			}
+		}
+		status := statusPending
+		if known {
+			status = ev.propertyStatus(formula)
+		}
+		lines[i] = replaceTableCell(line, statusCol, status)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// propertiesColumns finds the name, formula and status columns of a
+// properties table header; formula and status are -1 if missing
+func propertiesColumns(header []string) (name, formula, status int) {
+	name, formula, status = 0, -1, -1
+	for i, h := range header {
+		switch strings.ToLower(h) {
+		case "property", "name":
+			name = i
+		case "formula", "formal", "ctl", "check":
+			formula = i
+		case "status":
+			status = i
+		}
+	}
+	return name, formula, status
+}
+
+// parseCheck reads a formal column that is a check expression, like
+// (never? '(error ?x)) or `eventually? '(done ?a)`
+func parseCheck(cell string) (Value, bool) {
+	src := strings.TrimSpace(strings.Trim(cell, "`"))
+	if !strings.HasPrefix(src, "(") {
+		src = "(" + src + ")"
+	}
+	exprs, errs := NewParser(src).Parse()
+	if len(errs) > 0 || len(exprs) != 1 || exprs[0].Type != TypeList || len(exprs[0].List) < 2 {
+		return Nil(), false
+	}
+	switch exprs[0].List[0].Symbol {
+	case "always?", "eventually?", "possibly?", "never?":
+		return exprs[0], true
+	}
+	return Nil(), false
+}
+
+// registerProperty adds a property from the document to the registry,
+// if the prologue's registry is loaded
+func (ev *Evaluator) registerProperty(name string, formula Value) {
+	if _, ok := ev.GlobalEnv.Get("defproperty"); !ok {
+		return
+	}
+	ev.Eval(Lst(Sym("defproperty"), Lst(Sym("quote"), Sym(name)), Lst(Sym("quote"), formula)), ev.GlobalEnv)
+}
+
+// replaceTableCell replaces cell col of a markdown table row, keeping the
+// rest of the line byte for byte
+func replaceTableCell(line string, col int, text string) string {
+	start := strings.Index(line, "|")
+	n := -1
+	for i := start; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if line[i] != '|' {
+			continue
+		}
+		n++
+		if n == col {
+			end := i + 1
+			for end < len(line) && line[end] != '|' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return line
+			}
+			return line[:i+1] + " " + text + " " + line[end:]
+		}
+	}
+	return line
+}
+
+// (property-status name) - pass, fail or pending for a registered property
+func builtinPropertyStatus(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 {
+		return Nil()
+	}
+	formula, ok := ev.registeredProperties()[propertyKey(valueToString(args[0]))]
+	if !ok {
+		return Nil()
+	}
+	switch ev.propertyStatus(formula) {
+	case statusPass:
+		return Sym("pass")
+	case statusFail:
+		return Sym("fail")
+	}
+	return Sym("pending")
+}
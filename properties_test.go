@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Properties Table Sync Tests
+// ============================================================================
+
+const propertiesDoc = `# Bakery
+
+| Property | Status |
+|----------|--------|
+| Not a properties table | ✅ |
+
+## Properties
+
+| Property | Formal | Status |
+|----------|--------|--------|
+| Bread is baked | EF(baked) | ❌ |
+| No spoilage | AG(¬spoiled) | ✅ |
+| Always open | AG(open) | ✅ |
+| Sells out | ` + "`eventually? '(sold-out ?day)`" + ` | ✅ |
+| Customers are happy | happy customers | ✅ |
+| Responsive | AG(request → AF response) | ✅ |
+
+More text.
+`
+
+func TestSyncPropertiesTable(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	loadLispModules(ev)
+	runCode(ev, `
+		(defproperty 'bread-is-baked (EF (prop 'baked)))
+		(defproperty 'no-spoilage (AG (ctl-not (prop 'spoiled))))
+		(defproperty 'always-open (AG (prop 'open)))
+		(defproperty 'responsive (AG (ctl-implies (prop 'request) (AF (prop 'response)))))
+		(assert! 'open 'shop)
+		(assert! 'baked 12)
+		(assert! 'spoiled 1)`)
+
+	got := ev.SyncPropertiesTable(propertiesDoc)
+	want := strings.NewReplacer(
+		"| Bread is baked | EF(baked) | ❌ |", "| Bread is baked | EF(baked) | ✅ |",
+		"| No spoilage | AG(¬spoiled) | ✅ |", "| No spoilage | AG(¬spoiled) | ❌ |",
+		"| Sells out | `eventually? '(sold-out ?day)` | ✅ |", "| Sells out | `eventually? '(sold-out ?day)` | ❌ |",
+		"| Customers are happy | happy customers | ✅ |", "| Customers are happy | happy customers | Pending |",
+		"| Responsive | AG(request → AF response) | ✅ |", "| Responsive | AG(request → AF response) | Pending |",
+	).Replace(propertiesDoc)
+	if got != want {
+		t.Errorf("synced document:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The row with a checkable formula is now in the registry
+	if got := evalLast(ev, "(property-status 'sells-out)").String(); got != "fail" {
+		t.Errorf("(property-status 'sells-out) = %s, want fail", got)
+	}
+	runCode(ev, "(assert! 'sold-out 3)")
+	if got := ev.SyncPropertiesTable(propertiesDoc); !strings.Contains(got, "| Sells out | `eventually? '(sold-out ?day)` | ✅ |") {
+		t.Errorf("expected the status to follow the facts:\n%s", got)
+	}
+}
+
+func TestPropertyStatus(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	loadLispModules(ev)
+	runCode(ev, `
+		(defproperty 'no-errors '(never? '(error ?x)))
+		(defproperty 'checked (lambda () (> (fact-count 'tick) 1)))
+		(assert! 'tick 1)`)
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(property-status 'no-errors)", "pass"},
+		{"(property-status \"No errors\")", "pass"},
+		{"(property-status 'checked)", "fail"},
+		{"(property-status 'unknown)", "nil"},
+		{"(begin (assert! 'error 'boom) (property-status 'no-errors))", "fail"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// Reference Browser
+// ============================================================================
+//
+// /reference documents the running session: the builtins and functions in
+// the global environment, the document tools with their argument schemas
+// and an example of each rendered against the current facts, and the
+// Datalog rules that have been registered.
+
+// Reference is what /reference serves, as HTML or with ?format=json as JSON
+type Reference struct {
+	Builtins    []string            `json:"builtins"`
+	BuiltinDocs map[string]string   `json:"builtin_docs"`
+	Functions   []ReferenceFunction `json:"functions"`
+	Tools       []ReferenceTool     `json:"tools"`
+	Rules       []string            `json:"rules"`
+}
+
+// ReferenceFunction is a function defined in the session
+type ReferenceFunction struct {
+	Name      string `json:"name"`
+	Signature string `json:"signature"`
+	Doc       string `json:"doc,omitempty"`
+}
+
+// ReferenceTool is a {{tool}} placeholder usable in documents
+type ReferenceTool struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Args        []ReferenceToolArg `json:"args"`
+	Example     string             `json:"example"`  // Placeholder filled in from the session
+	Rendered    string             `json:"rendered"` // Example run against the session
+}
+
+// ReferenceToolArg is one argument of a tool
+type ReferenceToolArg struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+}
+
+// buildReference collects the reference for ev's current session
+func buildReference(ev *Evaluator) Reference {
+	ref := Reference{BuiltinDocs: make(map[string]string)}
+	for name, v := range ev.GlobalEnv.bindings {
+		switch v.Type {
+		case TypeBuiltin:
+			ref.Builtins = append(ref.Builtins, name)
+			ref.BuiltinDocs[name] = builtinDocs[name]
+		case TypeFunc:
+			ref.Functions = append(ref.Functions, ReferenceFunction{Name: name, Signature: functionSignature(name, v.Func), Doc: v.Func.Doc})
+		}
+	}
+	sort.Strings(ref.Builtins)
+	sort.Slice(ref.Functions, func(i, j int) bool { return ref.Functions[i].Name < ref.Functions[j].Name })
+
+	tr := NewToolRegistry(ev)
+	names := make([]string, 0, len(tr.tools))
+	for name := range tr.tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tool := referenceTool(name)
+		tool.Example = referenceExample(ev, tool)
+		tool.Rendered = tr.Process(tool.Example)
+		ref.Tools = append(ref.Tools, tool)
+	}
+
+	for _, r := range ev.DatalogDB.Rules {
+		ref.Rules = append(ref.Rules, ruleString(r))
+	}
+	return ref
+}
+
+// functionSignature prints a function's parameter list like its define
+func functionSignature(name string, f *Function) string {
+	parts := append([]string{name}, f.Params...)
+	for _, p := range f.Optional {
+		parts = append(parts, fmt.Sprintf("(%s %s)", p.Name, p.Default.String()))
+	}
+	if len(f.Keys) > 0 {
+		parts = append(parts, "&key")
+		for _, p := range f.Keys {
+			parts = append(parts, fmt.Sprintf("(%s %s)", p.Name, p.Default.String()))
+		}
+	}
+	if f.RestParam != "" {
+		parts = append(parts, ".", f.RestParam)
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// referenceTool describes a tool from its entry in mcpTools
+func referenceTool(name string) ReferenceTool {
+	tool := ReferenceTool{Name: name}
+	var schema map[string]interface{}
+	for _, def := range mcpTools {
+		if def["name"] != name {
+			continue
+		}
+		tool.Description, _ = def["description"].(string)
+		schema, _ = def["inputSchema"].(map[string]interface{})
+	}
+	props, _ := schema["properties"].(map[string]interface{})
+	required := make(map[string]bool)
+	if req, ok := schema["required"].([]string); ok {
+		for _, r := range req {
+			required[r] = true
+		}
+	}
+	for argName, p := range props {
+		prop, _ := p.(map[string]interface{})
+		arg := ReferenceToolArg{Name: argName, Required: required[argName]}
+		arg.Type, _ = prop["type"].(string)
+		arg.Description, _ = prop["description"].(string)
+		tool.Args = append(tool.Args, arg)
+	}
+	sort.Slice(tool.Args, func(i, j int) bool {
+		if tool.Args[i].Required != tool.Args[j].Required {
+			return tool.Args[i].Required
+		}
+		return tool.Args[i].Name < tool.Args[j].Name
+	})
+	return tool
+}
+
+// referenceExample builds a placeholder for a tool, filling the arguments
+// it knows about from the session's actors and facts.
+func referenceExample(ev *Evaluator, tool ReferenceTool) string {
+	actors := make([]string, 0, len(ev.Scheduler.Actors))
+	for name := range ev.Scheduler.Actors {
+		actors = append(actors, name)
+	}
+	sort.Strings(actors)
+	predicate, query := "", ""
+	if len(ev.DatalogDB.Facts) > 0 {
+		first := ev.DatalogDB.Facts[0]
+		predicate = first.Predicate
+		vars := make([]Term, len(first.Args))
+		for i := range vars {
+			vars[i] = Var(fmt.Sprintf("x%d", i+1))
+		}
+		query = Goal{Predicate: predicate, Args: vars}.String()
+	}
+
+	values := map[string]string{
+		"formula": "eventually? '(spawned ?name)",
+		"name":    "Actors spawned",
+		"checks":  "Messages sent: eventually? '(sent ?from ?to ?msg)",
+		"title":   "Messages",
+		"limit":   "5",
+	}
+	if len(actors) > 0 {
+		values["actor"] = actors[0]
+		values["actors"] = strings.Join(actors, ",")
+	}
+	if predicate != "" {
+		values["predicate"] = predicate
+		values["query"] = query
+	}
+
+	var sb strings.Builder
+	sb.WriteString("{{" + tool.Name)
+	for _, arg := range tool.Args {
+		if v, ok := values[arg.Name]; ok {
+			fmt.Fprintf(&sb, " %s=%q", arg.Name, v)
+		}
+	}
+	sb.WriteString("}}")
+	return sb.String()
+}
+
+// ruleString prints a rule in the form rule takes it
+func ruleString(r Rule) string {
+	parts := []string{"'" + factString(r.Head.Predicate, r.Head.Args)}
+	for _, g := range r.Body {
+		s := factString(g.Predicate, g.Args)
+		if g.IsBuiltin {
+			s = factString(g.Builtin, g.Args)
+		}
+		if g.Negated {
+			s = "(not " + s + ")"
+		}
+		parts = append(parts, "'"+s)
+	}
+	return fmt.Sprintf("(rule '%s %s)", r.Name, strings.Join(parts, " "))
+}
+
+func factString(pred string, args []Term) string {
+	parts := []string{pred}
+	for _, a := range args {
+		parts = append(parts, a.String())
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func handleReference(w http.ResponseWriter, r *http.Request) {
+	globalEvMu.Lock()
+	ref := buildReference(globalEv)
+	globalEvMu.Unlock()
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ref)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := referenceTemplate.Execute(w, ref); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var referenceTemplate = template.Must(template.New("reference").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <title>Reference - Philosophy Calculator</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; max-width: 960px; margin: 2rem auto; padding: 0 1rem; color: #222; }
+        code, pre { font-family: 'SF Mono', Monaco, monospace; font-size: 0.85rem; }
+        pre { background: #f5f5f5; padding: 0.75rem; overflow-x: auto; }
+        table { border-collapse: collapse; width: 100%; margin-bottom: 1rem; }
+        th, td { text-align: left; padding: 0.3rem 0.6rem; border-bottom: 1px solid #ddd; vertical-align: top; }
+        .builtins code { display: inline-block; margin: 0.15rem 0.4rem 0.15rem 0; }
+        .tool { margin-bottom: 2rem; }
+        nav a { margin-right: 1rem; }
+    </style>
+</head>
+<body>
+    <h1>Reference</h1>
+    <nav><a href="#functions">Functions</a><a href="#builtins">Builtins</a><a href="#tools">Tools</a><a href="#rules">Rules</a><a href="?format=json">JSON</a></nav>
+
+    <h2 id="functions">Functions</h2>
+    {{if .Functions}}<table>
+        {{range .Functions}}<tr><td><code>{{.Signature}}</code></td><td>{{.Doc}}</td></tr>
+        {{end}}</table>{{else}}<p>No functions defined in this session yet.</p>{{end}}
+
+    <h2 id="builtins">Builtins</h2>
+    <p>See DIALECT.md for the full language reference, or <code>(doc 'name)</code> in the REPL.</p>
+    <div class="builtins">{{range .Builtins}}<code title="{{index $.BuiltinDocs .}}">{{.}}</code> {{end}}</div>
+
+    <h2 id="tools">Document Tools</h2>
+    <p>Write <code>{{"{{"}}tool key="value"{{"}}"}}</code> in a document to render it against the session.</p>
+    {{range .Tools}}<div class="tool">
+        <h3>{{.Name}}</h3>
+        {{if .Description}}<p>{{.Description}}</p>{{end}}
+        {{if .Args}}<table>
+            <tr><th>Argument</th><th>Type</th><th>Description</th></tr>
+            {{range .Args}}<tr><td><code>{{.Name}}</code>{{if .Required}} (required){{end}}</td><td>{{.Type}}</td><td>{{.Description}}</td></tr>
+            {{end}}</table>{{end}}
+        <p>Example:</p>
+        <pre>{{.Example}}</pre>
+        <p>Renders in this session as:</p>
+        <pre>{{.Rendered}}</pre>
+    </div>{{end}}
+
+    <h2 id="rules">Rules</h2>
+    {{if .Rules}}<pre>{{range .Rules}}{{.}}
+{{end}}</pre>{{else}}<p>No rules registered in this session yet.</p>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Reference Browser Tests
+// ============================================================================
+
+func TestReferenceDocumentsSession(t *testing.T) {
+	saved := globalEv
+	defer func() { globalEv = saved }()
+	globalEv = NewEvaluator(64)
+	runCode(globalEv, `
+		(define (greet name (greeting "hi") &key loud . rest) greeting)
+		(rule 'lonely '(lonely ?a) '(spawned ?a) '(not (sent ?a ?b ?m)))
+		(spawn-actor 'bakery 4 '(done!))
+		(run-scheduler 10)
+	`)
+
+	rec := httptest.NewRecorder()
+	handleReference(rec, httptest.NewRequest("GET", "/reference?format=json", nil))
+	var ref Reference
+	if err := json.Unmarshal(rec.Body.Bytes(), &ref); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+
+	var sig string
+	for _, f := range ref.Functions {
+		if f.Name == "greet" {
+			sig = f.Signature
+		}
+	}
+	if sig != `(greet name (greeting "hi") &key (loud nil) . rest)` {
+		t.Errorf("unexpected signature %q", sig)
+	}
+	if strings.Join(ref.Rules, "\n") != "(rule 'lonely '(lonely ?a) '(spawned ?a) '(not (sent ?a ?b ?m)))" {
+		t.Errorf("unexpected rules %v", ref.Rules)
+	}
+	if !strings.Contains(strings.Join(ref.Builtins, " "), "spawn-actor") {
+		t.Errorf("builtins missing spawn-actor")
+	}
+
+	tools := make(map[string]ReferenceTool)
+	for _, tool := range ref.Tools {
+		tools[tool.Name] = tool
+	}
+	seq, ok := tools["sequence_diagram"]
+	if !ok {
+		t.Fatalf("sequence_diagram missing from %v", ref.Tools)
+	}
+	if len(seq.Args) == 0 || seq.Args[0].Name != "actors" || !seq.Args[0].Required {
+		t.Errorf("expected required actors arg first, got %+v", seq.Args)
+	}
+	if seq.Example != `{{sequence_diagram actors="bakery"}}` {
+		t.Errorf("example should use the session's actors, got %s", seq.Example)
+	}
+	if !strings.Contains(seq.Rendered, "participant bakery") {
+		t.Errorf("example should render against the session, got %s", seq.Rendered)
+	}
+	if prop := tools["property"]; !strings.Contains(prop.Rendered, "✅") {
+		t.Errorf("property example should pass in this session, got %s", prop.Rendered)
+	}
+
+	rec = httptest.NewRecorder()
+	handleReference(rec, httptest.NewRequest("GET", "/reference", nil))
+	if html := rec.Body.String(); !strings.Contains(html, "greet") || !strings.Contains(html, "sequence_diagram") {
+		t.Errorf("HTML reference missing entries")
+	}
+}
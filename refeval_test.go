@@ -0,0 +1,440 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Small-Step Reference Evaluator
+// ============================================================================
+//
+// An independent CEK-style machine for a core subset of BoundedLISP:
+// numbers, booleans, nil, quote, if, single-binding let, begin, lambda,
+// application, and the primitives + - * < = not. Each call to step makes
+// exactly one transition, so the semantics are easy to audit. It shares
+// nothing with Evaluator except the parser, and is used as an oracle for
+// differential testing.
+
+type refKind int
+
+const (
+	refNil refKind = iota
+	refNum
+	refBool
+	refSym
+	refList
+	refClosure
+	refPrim
+)
+
+type refVal struct {
+	kind   refKind
+	num    float64
+	b      bool
+	sym    string
+	list   []refVal
+	params []string
+	body   Value
+	env    *refEnv
+}
+
+type refEnv struct {
+	name   string
+	val    refVal
+	parent *refEnv
+}
+
+func (e *refEnv) lookup(name string) (refVal, bool) {
+	for ; e != nil; e = e.parent {
+		if e.name == name {
+			return e.val, true
+		}
+	}
+	return refVal{}, false
+}
+
+func (e *refEnv) extend(name string, v refVal) *refEnv {
+	return &refEnv{name: name, val: v, parent: e}
+}
+
+func (v refVal) truthy() bool {
+	switch v.kind {
+	case refNil:
+		return false
+	case refBool:
+		return v.b
+	case refNum:
+		return v.num != 0
+	case refList:
+		return len(v.list) > 0
+	}
+	return true
+}
+
+func (v refVal) String() string {
+	switch v.kind {
+	case refNil:
+		return "nil"
+	case refNum:
+		return Num(v.num).String()
+	case refBool:
+		return Bool(v.b).String()
+	case refSym:
+		return v.sym
+	case refList:
+		parts := make([]string, len(v.list))
+		for i, x := range v.list {
+			parts[i] = x.String()
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	case refClosure:
+		return "<function>"
+	case refPrim:
+		return "<builtin>"
+	}
+	return "<unknown>"
+}
+
+func refQuote(v Value) refVal {
+	switch v.Type {
+	case TypeNumber:
+		return refVal{kind: refNum, num: v.Number}
+	case TypeBool:
+		return refVal{kind: refBool, b: v.Bool}
+	case TypeSymbol:
+		return refVal{kind: refSym, sym: v.Symbol}
+	case TypeList:
+		items := make([]refVal, len(v.List))
+		for i, x := range v.List {
+			items[i] = refQuote(x)
+		}
+		return refVal{kind: refList, list: items}
+	}
+	return refVal{kind: refNil}
+}
+
+func refPrimitive(name string, args []refVal) refVal {
+	switch name {
+	case "+", "*":
+		acc := 0.0
+		if name == "*" {
+			acc = 1
+		}
+		for _, a := range args {
+			if name == "+" {
+				acc += a.num
+			} else {
+				acc *= a.num
+			}
+		}
+		return refVal{kind: refNum, num: acc}
+	case "-":
+		if len(args) == 0 {
+			return refVal{kind: refNum}
+		}
+		if len(args) == 1 {
+			return refVal{kind: refNum, num: -args[0].num}
+		}
+		acc := args[0].num
+		for _, a := range args[1:] {
+			acc -= a.num
+		}
+		return refVal{kind: refNum, num: acc}
+	case "<":
+		return refVal{kind: refBool, b: len(args) >= 2 && args[0].num < args[1].num}
+	case "=":
+		return refVal{kind: refBool, b: len(args) < 2 || args[0].String() == args[1].String()}
+	case "not":
+		return refVal{kind: refBool, b: len(args) == 0 || !args[0].truthy()}
+	}
+	return refVal{kind: refNil}
+}
+
+// Continuation frames
+type refFrame struct {
+	kind  string // "if", "let", "begin", "app"
+	exprs []Value
+	name  string
+	done  []refVal
+	env   *refEnv
+}
+
+type refMachine struct {
+	expr   Value  // control when !hasVal
+	val    refVal // control when hasVal
+	hasVal bool
+	env    *refEnv
+	kont   []refFrame
+}
+
+func refGlobalEnv() *refEnv {
+	var env *refEnv
+	for _, p := range []string{"+", "-", "*", "<", "=", "not"} {
+		env = env.extend(p, refVal{kind: refPrim, sym: p})
+	}
+	return env
+}
+
+// step performs one transition; it returns false when the machine halts
+func (m *refMachine) step() bool {
+	if m.hasVal {
+		if len(m.kont) == 0 {
+			return false
+		}
+		f := m.kont[len(m.kont)-1]
+		m.kont = m.kont[:len(m.kont)-1]
+		switch f.kind {
+		case "if":
+			m.hasVal = false
+			m.env = f.env
+			if m.val.truthy() {
+				m.expr = f.exprs[0]
+			} else if len(f.exprs) > 1 {
+				m.expr = f.exprs[1]
+			} else {
+				m.val, m.hasVal = refVal{kind: refNil}, true
+			}
+		case "let":
+			m.hasVal = false
+			m.env = f.env.extend(f.name, m.val)
+			m.expr = Lst(append([]Value{Sym("begin")}, f.exprs...)...)
+		case "begin":
+			m.hasVal = false
+			m.env = f.env
+			m.expr = f.exprs[0]
+			if len(f.exprs) > 1 {
+				m.kont = append(m.kont, refFrame{kind: "begin", exprs: f.exprs[1:], env: f.env})
+			}
+		case "app":
+			done := append(append([]refVal{}, f.done...), m.val)
+			if len(f.exprs) > 0 {
+				m.hasVal = false
+				m.env = f.env
+				m.expr = f.exprs[0]
+				m.kont = append(m.kont, refFrame{kind: "app", exprs: f.exprs[1:], done: done, env: f.env})
+				return true
+			}
+			fn, args := done[0], done[1:]
+			switch fn.kind {
+			case refPrim:
+				m.val = refPrimitive(fn.sym, args)
+			case refClosure:
+				env := fn.env
+				for i, p := range fn.params {
+					arg := refVal{kind: refNil}
+					if i < len(args) {
+						arg = args[i]
+					}
+					env = env.extend(p, arg)
+				}
+				m.hasVal = false
+				m.env = env
+				m.expr = fn.body
+			default:
+				m.val = refVal{kind: refNil}
+			}
+		}
+		return true
+	}
+
+	e := m.expr
+	switch e.Type {
+	case TypeNumber, TypeBool, TypeNil:
+		m.val, m.hasVal = refQuote(e), true
+		return true
+	case TypeSymbol:
+		v, ok := m.env.lookup(e.Symbol)
+		if !ok {
+			v = refVal{kind: refNil}
+		}
+		m.val, m.hasVal = v, true
+		return true
+	case TypeList:
+		if len(e.List) == 0 {
+			m.val, m.hasVal = refVal{kind: refList}, true
+			return true
+		}
+		head := e.List[0]
+		if head.IsSymbol() {
+			switch head.Symbol {
+			case "quote":
+				m.val, m.hasVal = refQuote(e.List[1]), true
+				return true
+			case "if":
+				m.kont = append(m.kont, refFrame{kind: "if", exprs: e.List[2:], env: m.env})
+				m.expr = e.List[1]
+				return true
+			case "let":
+				m.kont = append(m.kont, refFrame{kind: "let", name: e.List[1].Symbol, exprs: e.List[3:], env: m.env})
+				m.expr = e.List[2]
+				return true
+			case "begin":
+				if len(e.List) == 1 {
+					m.val, m.hasVal = refVal{kind: refNil}, true
+					return true
+				}
+				if len(e.List) > 2 {
+					m.kont = append(m.kont, refFrame{kind: "begin", exprs: e.List[2:], env: m.env})
+				}
+				m.expr = e.List[1]
+				return true
+			case "lambda":
+				var params []string
+				for _, p := range e.List[1].List {
+					params = append(params, p.Symbol)
+				}
+				body := e.List[2]
+				if len(e.List) > 3 {
+					body = Lst(append([]Value{Sym("begin")}, e.List[2:]...)...)
+				}
+				m.val, m.hasVal = refVal{kind: refClosure, params: params, body: body, env: m.env}, true
+				return true
+			}
+		}
+		m.kont = append(m.kont, refFrame{kind: "app", exprs: e.List[1:], env: m.env})
+		m.expr = head
+		return true
+	}
+	m.val, m.hasVal = refVal{kind: refNil}, true
+	return true
+}
+
+// refEval runs the machine to completion or until maxSteps transitions
+func refEval(expr Value, maxSteps int) (refVal, bool) {
+	m := &refMachine{expr: expr, env: refGlobalEnv()}
+	for i := 0; i < maxSteps; i++ {
+		if !m.step() {
+			return m.val, true
+		}
+	}
+	return refVal{}, false
+}
+
+// ============================================================================
+// Program Generator
+// ============================================================================
+
+type progGen struct {
+	rng  *rand.Rand
+	vars []string
+	next int
+}
+
+func (g *progGen) fresh() string {
+	g.next++
+	return fmt.Sprintf("v%d", g.next)
+}
+
+// num generates an expression that evaluates to a number
+func (g *progGen) num(depth int) string {
+	choice := g.rng.Intn(8)
+	if depth <= 0 {
+		choice = g.rng.Intn(2)
+	}
+	switch choice {
+	case 0:
+		return fmt.Sprintf("%d", g.rng.Intn(21)-10)
+	case 1:
+		if len(g.vars) > 0 {
+			return g.vars[g.rng.Intn(len(g.vars))]
+		}
+		return fmt.Sprintf("%d", g.rng.Intn(10))
+	case 2:
+		ops := []string{"+", "-", "*"}
+		return fmt.Sprintf("(%s %s %s)", ops[g.rng.Intn(3)], g.num(depth-1), g.num(depth-1))
+	case 3:
+		return fmt.Sprintf("(if %s %s %s)", g.boolean(depth-1), g.num(depth-1), g.num(depth-1))
+	case 4:
+		name := g.fresh()
+		val := g.num(depth - 1)
+		g.vars = append(g.vars, name)
+		body := g.num(depth - 1)
+		g.vars = g.vars[:len(g.vars)-1]
+		return fmt.Sprintf("(let %s %s %s)", name, val, body)
+	case 5:
+		a, b := g.fresh(), g.fresh()
+		g.vars = append(g.vars, a, b)
+		body := g.num(depth - 1)
+		g.vars = g.vars[:len(g.vars)-2]
+		return fmt.Sprintf("((lambda (%s %s) %s) %s %s)", a, b, body, g.num(depth-1), g.num(depth-1))
+	case 6:
+		return fmt.Sprintf("(begin %s %s)", g.num(depth-1), g.num(depth-1))
+	default:
+		// Higher-order: pass a closure to an applier
+		f, x := g.fresh(), g.fresh()
+		g.vars = append(g.vars, x)
+		body := g.num(depth - 1)
+		g.vars = g.vars[:len(g.vars)-1]
+		return fmt.Sprintf("((lambda (%s) (%s %s)) (lambda (%s) %s))", f, f, g.num(depth-1), x, body)
+	}
+}
+
+// boolean generates an expression used in test position
+func (g *progGen) boolean(depth int) string {
+	switch g.rng.Intn(4) {
+	case 0:
+		return fmt.Sprintf("(< %s %s)", g.num(depth), g.num(depth))
+	case 1:
+		return fmt.Sprintf("(= %s %s)", g.num(depth), g.num(depth))
+	case 2:
+		return fmt.Sprintf("(not %s)", g.num(depth))
+	default:
+		return g.num(depth)
+	}
+}
+
+// ============================================================================
+// Differential Tests
+// ============================================================================
+
+func TestReferenceEvaluatorBasics(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(+ 1 2 3)`, "6"},
+		{`(if (< 1 2) 'yes 'no)`, "yes"},
+		{`(if 0 1)`, "nil"},
+		{`(let x 5 (* x x))`, "25"},
+		{`((lambda (f) (f 3)) (lambda (x) (+ x 1)))`, "4"},
+		{`'(a (b 1))`, "(a (b 1))"},
+		{`(begin 1 2 3)`, "3"},
+		{`(let k (lambda (x) (lambda (y) (- x y))) ((k 10) 4))`, "6"},
+	}
+	for _, tt := range tests {
+		got, ok := refEval(parseAll(NewParser(tt.code))[0], 10000)
+		if !ok || got.String() != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got.String(), tt.expected)
+		}
+	}
+}
+
+func TestDifferentialAgainstReference(t *testing.T) {
+	ev := NewEvaluator(1000)
+	g := &progGen{rng: rand.New(rand.NewSource(4505))}
+
+	programs := 3000
+	if testing.Short() {
+		programs = 300
+	}
+
+	failures := 0
+	for i := 0; i < programs && failures < 10; i++ {
+		g.vars = nil
+		code := g.num(4)
+		expr := parseAll(NewParser(code))[0]
+
+		want, ok := refEval(expr, 1000000)
+		if !ok {
+			continue
+		}
+		got := ev.Eval(expr, ev.GlobalEnv)
+		if got.String() != want.String() {
+			failures++
+			t.Errorf("divergence on %s\n  evaluator: %s\n  reference: %s", code, got.String(), want.String())
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+)
+
+// ============================================================================
+// Exactly-Once Steps
+// ============================================================================
+//
+// A step that blocks is abandoned, and when the actor can run again its
+// code is evaluated from the top. Anything the step did before blocking
+// would then happen twice:
+//
+//   (define (worker)
+//     (send-to! 'log 'ready)      ; sent again on every retry
+//     (let ((job (receive!)))     ; blocks while the mailbox is empty
+//       ...))
+//
+// So while a step runs, the calls that have effects are written to the
+// actor's journal: builtins whose names end in !, the ones listed in
+// journaledBuiltins, any builtin that returns a map, set, stack or queue
+// (so later mutations find the same object), and set! of a variable that
+// outlives the step. When the step blocks the journal is kept, and the
+// retry answers those calls from it instead of making them again, up to
+// the point where the step blocked; from there it runs live. A step that
+// finishes, yields or becomes new code clears the journal.
+//
+// Calls are matched by their position among the builtin calls of the
+// step, so this relies on a retry making the same calls in the same order
+// until it reaches the blocking one. If it doesn't, because the step read
+// something another actor changed in the meantime, the step warns and
+// runs the rest live.
+
+// JournalEntry is one call a step made, recorded so a retry can skip it
+type JournalEntry struct {
+	Seq    int    // Position among the builtin calls of the step
+	Op     string // Builtin name, or set!
+	Calls  int    // Builtin calls made while it ran, which a retry skips too
+	Result Value
+}
+
+// journaledBuiltins have effects or vary from call to call without a ! in
+// their names
+var journaledBuiltins = map[string]bool{
+	"rand": true, "random": true, "now": true, "now-millis": true, "gensym": true,
+	"print": true, "println": true, "pp": true,
+	"spawn-actor": true, "spawn": true, "make-promise": true, "run-scheduler": true, "reset-scheduler": true,
+	"write-file": true, "append-file": true, "load": true,
+	"grant": true, "rule": true, "deftest": true,
+}
+
+// journaled reports whether a call to the builtin name that returned
+// result goes in the journal
+func journaled(name string, result Value) bool {
+	if containerOf(result) != nil {
+		return true
+	}
+	return strings.HasSuffix(name, "!") || journaledBuiltins[name]
+}
+
+// startJournal runs before each step of actor
+func (ev *Evaluator) startJournal(actor *Actor) {
+	actor.calls = 0
+	actor.replayed = 0
+}
+
+// endJournal runs after each step of actor: a step that blocked keeps
+// its journal for the retry, any other step clears it
+func (ev *Evaluator) endJournal(actor *Actor, result Value) {
+	if result.Type == TypeBlocked {
+		actor.BlockedAt = actor.calls
+		return
+	}
+	actor.Journal = nil
+	actor.BlockedAt = 0
+}
+
+// replaying reports whether the step being run is retracing a blocked
+// step's calls, before the call that blocked it
+func (ev *Evaluator) replaying() bool {
+	a := ev.journaling
+	return a != nil && a.calls < a.BlockedAt
+}
+
+// journalCall runs a journaled call as the next call of the step: from
+// the journal when the step is retracing one that blocked, otherwise by
+// calling run and recording the result if keep says to
+func (ev *Evaluator) journalCall(op string, run func() Value, keep func(Value) bool) Value {
+	a := ev.journaling
+	a.calls++
+	seq := a.calls
+	if a.replayed < len(a.Journal) && a.Journal[a.replayed].Seq < seq {
+		ev.journalDiverged(a, a.Journal[a.replayed].Op)
+	}
+	if a.replayed < len(a.Journal) && a.Journal[a.replayed].Seq == seq {
+		e := a.Journal[a.replayed]
+		if e.Op == op {
+			// Skip the calls it made too, and their entries
+			a.calls += e.Calls
+			for a.replayed++; a.replayed < len(a.Journal) && a.Journal[a.replayed].Seq <= a.calls; a.replayed++ {
+			}
+			return e.Result
+		}
+		ev.journalDiverged(a, e.Op)
+	}
+	start := a.replayed
+	result := run()
+	if result.Type != TypeBlocked && keep(result) {
+		// Calls made inside this one were recorded as they finished;
+		// this one goes before them, in call order
+		e := JournalEntry{Seq: seq, Op: op, Calls: a.calls - seq, Result: result}
+		a.Journal = append(a.Journal[:start], append([]JournalEntry{e}, a.Journal[start:]...)...)
+		a.replayed++
+	}
+	return result
+}
+
+// journalDiverged gives up on the rest of a's journal when the retry of
+// its step stops making the calls it recorded
+func (ev *Evaluator) journalDiverged(a *Actor, expected string) {
+	ev.warnTrace("journal-diverged:"+a.Name, "%s: step took a different path on retry (expected %s); running the rest of it again",
+		a.Name, expected)
+	a.Journal = a.Journal[:a.replayed]
+	a.BlockedAt = 0
+}
+
+// applyBuiltin calls a builtin, through the journal during an actor step
+func (ev *Evaluator) applyBuiltin(fn Value, args []Value, env *Env) Value {
+	if ev.journaling == nil {
+		return fn.Builtin(ev, args, env)
+	}
+	return ev.journalCall(fn.Symbol,
+		func() Value { return fn.Builtin(ev, args, env) },
+		func(result Value) bool { return journaled(fn.Symbol, result) })
+}
+
+// outlivesStep reports whether set! of name in env changes a variable
+// that is still there when the step is retried: one in the actor's,
+// a module's or the global scope rather than in a call or let
+func outlivesStep(name string, env *Env) bool {
+	for e := env; e != nil; e = e.parent {
+		if e.has(name) {
+			return e.bindings != nil
+		}
+	}
+	return true
+}
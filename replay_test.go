@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Exactly-Once Step Tests
+// ============================================================================
+
+func TestBlockedStepEffectsRunOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{"send and set! before a blocking receive", `
+			(define count 0)
+			(define (worker)
+			  (send-to! 'log 'ready)
+			  (set! count (+ count 1))
+			  (set! result (list (receive!) count))
+			  'done)
+			(spawn-actor 'log 8 '(begin (receive!) (receive!) 'done))
+			(spawn-actor 'worker 4 '(worker))
+			(run-scheduler 10)
+			(send-to! 'worker 'job)
+			(run-scheduler 10)
+			(list result (length (query 'sent 'worker 'log '?m)))`,
+			"((job 1) 1)"},
+		{"a received message survives a send that blocks", `
+			(define got '())
+			(define (relay)
+			  (let ((m (receive!)))
+			    (send-to! 'sink m)
+			    (list 'become '(relay))))
+			(define (sink) (set! got (cons (receive!) got)) (list 'become '(sink)))
+			(spawn-actor 'relay 4 '(relay))
+			(spawn-actor 'sink 1 '(sink))
+			(send-to! 'relay 'a)
+			(send-to! 'relay 'b)
+			(send-to! 'relay 'c)
+			(run-scheduler 50)
+			got`,
+			"(c b a)"},
+		{"containers made before blocking are the same after", `
+			(define (w)
+			  (let ((m (make-map)))
+			    (map-set! m 'n 1)
+			    (receive!)
+			    (set! result (map-get m 'n))
+			    'done))
+			(spawn-actor 'w 4 '(w))
+			(run-scheduler 5)
+			(send-to! 'w 'go)
+			(run-scheduler 5)
+			result`,
+			"1"},
+		{"set! of a let variable runs again with its let", `
+			(define (w)
+			  (let ((n 0))
+			    (set! n (+ n 1))
+			    (receive!)
+			    (set! result n)
+			    'done))
+			(spawn-actor 'w 4 '(w))
+			(run-scheduler 5)
+			(send-to! 'w 'go)
+			(run-scheduler 5)
+			result`,
+			"1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := NewEvaluator(1000)
+			ev.Quiet = true
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+			if w := lastWarning(ev, "w: step took"); w != nil {
+				t.Errorf("unexpected warning: %s", w.Message)
+			}
+		})
+	}
+}
+
+func TestBlockedStepThatDiverges(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	result := evalLast(ev, `
+		(define flag false)
+		(define (w)
+		  (if flag (print "late") (send-to! 'log 'early))
+		  (receive!)
+		  'done)
+		(spawn-actor 'log 8 '(begin (receive!) (receive!) 'done))
+		(spawn-actor 'w 4 '(w))
+		(run-scheduler 10)
+		(set! flag true)
+		(send-to! 'w 'go)
+		(run-scheduler 10)
+		(list (first (actor-state 'w)) (length (query 'sent 'w 'log '?m)))`)
+	if got := result.String(); got != "(done 1)" {
+		t.Errorf("got %s, want (done 1)", got)
+	}
+	if lastWarning(ev, "w: step took a different path on retry (expected send-to!)") == nil {
+		t.Errorf("expected a warning about the retry taking another path, got %v", ev.Warnings)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// Incremental Re-simulation
+// ============================================================================
+//
+// A spec is setup forms, a (run-scheduler N) form, and checks after it.
+// The Resimulator keeps the previous run's checkpoints and the symbols each
+// step looked up. When the next version of the spec differs only in
+// function definitions, the run is resumed from the last checkpoint before
+// the first step that used a changed function, with the new definitions
+// patched in. Anything else (changed spawns, sends, data) is a full run.
+
+// Resimulator re-runs successive versions of a spec
+type Resimulator struct {
+	NewEvaluator func() *Evaluator // Fresh world for a full run
+	Interval     int64             // Steps between checkpoints
+	last         *simRecord
+}
+
+// SimResult describes one run of a spec
+type SimResult struct {
+	World       *Evaluator
+	RunResult   Value    // What run-scheduler returned
+	Incremental bool     // Resumed from a checkpoint of the previous run
+	ResumedFrom int64    // Step the run resumed from
+	Changed     []string // Functions whose definitions changed
+}
+
+type simCheckpoint struct {
+	Step  int64
+	World *Evaluator // Never run: resuming works on a copy
+}
+
+type simRecord struct {
+	Setup       []Value
+	Defs        map[string]string // Function definitions in setup, printed
+	SetupRefs   map[string]bool   // Symbols looked up while running setup
+	StepRefs    []map[string]bool // Symbols looked up by each step
+	Checkpoints []simCheckpoint
+}
+
+func NewResimulator(newEvaluator func() *Evaluator) *Resimulator {
+	return &Resimulator{NewEvaluator: newEvaluator, Interval: 100}
+}
+
+// functionDefName returns the name defined by (define (f ...) ...) or
+// (define f (lambda ...)).
+func functionDefName(form Value) (string, bool) {
+	if !form.IsList() || len(form.List) < 3 || !form.List[0].IsSymbol() || form.List[0].Symbol != "define" {
+		return "", false
+	}
+	target := form.List[1]
+	if target.IsList() && len(target.List) > 0 && target.List[0].IsSymbol() {
+		return target.List[0].Symbol, true
+	}
+	body := form.List[2]
+	if target.IsSymbol() && body.IsList() && len(body.List) > 0 &&
+		body.List[0].IsSymbol() && body.List[0].Symbol == "lambda" {
+		return target.Symbol, true
+	}
+	return "", false
+}
+
+func isRunScheduler(form Value) bool {
+	return form.IsList() && len(form.List) > 0 && form.List[0].IsSymbol() &&
+		form.List[0].Symbol == "run-scheduler"
+}
+
+// splitSpec returns the setup forms, the first top-level run-scheduler
+// form, and the forms after it. ok is false when there is no run.
+func splitSpec(forms []Value) (setup []Value, run Value, checks []Value, ok bool) {
+	for i, f := range forms {
+		if isRunScheduler(f) {
+			return forms[:i], f, forms[i+1:], true
+		}
+	}
+	return forms, Nil(), nil, false
+}
+
+// specDefs splits setup into function definitions and everything else
+func specDefs(setup []Value) (map[string]string, []string) {
+	defs := make(map[string]string)
+	var other []string
+	for _, f := range setup {
+		if name, ok := functionDefName(f); ok {
+			defs[name] = f.String()
+		} else {
+			other = append(other, f.String())
+		}
+	}
+	return defs, other
+}
+
+// changedDefs returns the functions that differ between two setups, or
+// ok=false if anything other than function definitions changed.
+func changedDefs(old, new []Value) ([]string, bool) {
+	oldDefs, oldOther := specDefs(old)
+	newDefs, newOther := specDefs(new)
+	if strings.Join(oldOther, "\n") != strings.Join(newOther, "\n") {
+		return nil, false
+	}
+	var changed []string
+	for name, def := range newDefs {
+		if oldDefs[name] != def {
+			changed = append(changed, name)
+		}
+	}
+	for name := range oldDefs {
+		if _, ok := newDefs[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed, true
+}
+
+// Run evaluates a spec, resuming from the previous run where possible
+func (r *Resimulator) Run(forms []Value) SimResult {
+	setup, run, checks, ok := splitSpec(forms)
+	if !ok {
+		// Nothing to re-simulate: evaluate as is
+		r.last = nil
+		ev := r.NewEvaluator()
+		for _, f := range forms {
+			ev.Eval(f, ev.GlobalEnv)
+		}
+		return SimResult{World: ev, RunResult: Nil()}
+	}
+
+	var result SimResult
+	if res, ok := r.resume(setup, run); ok {
+		result = res
+	} else {
+		result = r.fullRun(setup, run)
+	}
+	ev := result.World
+	ev.emit(SchedEvent{Kind: EventRunFinished, Result: result.RunResult})
+	for _, f := range checks {
+		ev.Eval(f, ev.GlobalEnv)
+	}
+	return result
+}
+
+func (r *Resimulator) fullRun(setup []Value, run Value) SimResult {
+	ev := r.NewEvaluator()
+	rec := &simRecord{Setup: setup, SetupRefs: make(map[string]bool)}
+	rec.Defs, _ = specDefs(setup)
+
+	ev.SymbolRefs = rec.SetupRefs
+	for _, f := range setup {
+		ev.Eval(f, ev.GlobalEnv)
+	}
+	maxSteps := runSchedulerSteps(ev, run)
+	ev.Scheduler.MaxSteps = maxSteps
+	ev.Scheduler.StepCount = 0
+	result := r.record(ev, rec, maxSteps)
+	r.last = rec
+	return SimResult{World: ev, RunResult: result}
+}
+
+func (r *Resimulator) resume(setup []Value, run Value) (SimResult, bool) {
+	last := r.last
+	if last == nil {
+		return SimResult{}, false
+	}
+	changed, ok := changedDefs(last.Setup, setup)
+	if !ok {
+		return SimResult{}, false
+	}
+	for _, name := range changed {
+		if last.SetupRefs[name] {
+			return SimResult{}, false // Used before the run started
+		}
+	}
+
+	// First step that looked up a changed name
+	diverge := int64(len(last.StepRefs))
+	for step, refs := range last.StepRefs {
+		if refsAny(refs, changed) {
+			diverge = int64(step)
+			break
+		}
+	}
+	cp := last.Checkpoints[0]
+	for _, c := range last.Checkpoints {
+		if c.Step <= diverge {
+			cp = c
+		}
+	}
+
+	ev := cloneWorld(cp.World)
+	ev.Quiet = false
+	newDefs := make(map[string]Value)
+	for _, f := range setup {
+		if name, ok := functionDefName(f); ok {
+			newDefs[name] = f
+		}
+	}
+	for _, name := range changed {
+		if def, ok := newDefs[name]; ok {
+			ev.Eval(def, ev.GlobalEnv)
+		} else {
+			delete(ev.GlobalEnv.bindings, name)
+		}
+	}
+
+	rec := &simRecord{Setup: setup, SetupRefs: last.SetupRefs}
+	rec.Defs, _ = specDefs(setup)
+	rec.StepRefs = append(rec.StepRefs, last.StepRefs[:cp.Step]...)
+	for _, c := range last.Checkpoints {
+		if c.Step < cp.Step {
+			rec.Checkpoints = append(rec.Checkpoints, c)
+		}
+	}
+	maxSteps := runSchedulerSteps(ev, run)
+	ev.Scheduler.MaxSteps = maxSteps
+	result := r.record(ev, rec, maxSteps)
+	r.last = rec
+	return SimResult{World: ev, RunResult: result, Incremental: true, ResumedFrom: cp.Step, Changed: changed}, true
+}
+
+// record runs the scheduler from ev's current step, saving the symbols
+// each step looks up and a checkpoint every Interval steps.
+func (r *Resimulator) record(ev *Evaluator, rec *simRecord, maxSteps int64) Value {
+	checkpoint := func() {
+		rec.Checkpoints = append(rec.Checkpoints, simCheckpoint{Step: ev.Scheduler.StepCount, World: cloneWorld(ev)})
+	}
+	checkpoint()
+	ev.SymbolRefs = make(map[string]bool)
+	result := ev.runSteps(maxSteps, func() Value {
+		rec.StepRefs = append(rec.StepRefs, ev.SymbolRefs)
+		ev.SymbolRefs = make(map[string]bool)
+		if r.Interval > 0 && ev.Scheduler.StepCount%r.Interval == 0 {
+			checkpoint()
+		}
+		return Nil()
+	})
+	ev.SymbolRefs = nil
+	if last := rec.Checkpoints[len(rec.Checkpoints)-1]; last.Step != ev.Scheduler.StepCount {
+		checkpoint()
+	}
+	return result
+}
+
+// runSchedulerSteps evaluates the step limit of a run-scheduler form,
+// given directly or in a run configuration
+func runSchedulerSteps(ev *Evaluator, run Value) int64 {
+	args := make([]Value, 0, len(run.List))
+	for _, arg := range run.List[1:] {
+		if arg.IsSymbol() && strings.HasPrefix(arg.Symbol, ":") {
+			args = append(args, arg)
+		} else {
+			args = append(args, ev.Eval(arg, ev.GlobalEnv))
+		}
+	}
+	cfg, _ := ev.parseRunConfig(args)
+	return cfg.MaxSteps
+}
+
+func refsAny(refs map[string]bool, names []string) bool {
+	for _, n := range names {
+		if refs[n] {
+			return true
+		}
+	}
+	return false
+}
+
+// runWatch re-runs filename whenever it changes, re-simulating
+// incrementally when only function definitions were edited.
+func runWatch(filename string) {
+	r := NewResimulator(func() *Evaluator {
+		ev := NewEvaluator(callStackDepth)
+		ev.FileAccess = true
+		return ev
+	})
+	var lastMod time.Time
+	for {
+		info, err := os.Stat(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+			os.Exit(1)
+		}
+		if info.ModTime().Equal(lastMod) {
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		lastMod = info.ModTime()
+
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+			continue
+		}
+		forms, errs := NewParser(string(content)).Parse()
+		if len(errs) > 0 {
+			printParseErrors(filename, errs)
+			continue
+		}
+
+		start := time.Now()
+		res := r.Run(forms)
+		if res.Incremental {
+			fmt.Fprintf(os.Stderr, "=== %s: resumed from step %d (changed: %s) in %v: %s\n",
+				filename, res.ResumedFrom, strings.Join(res.Changed, " "), time.Since(start).Round(time.Millisecond), res.RunResult.String())
+		} else {
+			fmt.Fprintf(os.Stderr, "=== %s: full run in %v: %s\n",
+				filename, time.Since(start).Round(time.Millisecond), res.RunResult.String())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Incremental Re-simulation Tests
+// ============================================================================
+
+const tickerSpec = `
+	(define (tick n)
+	  (if (< n 150)
+	      (list 'become (list 'tick (+ n 1)))
+	      (begin (report n) 'done)))
+	(define (report n) (assert! 'result n))
+	(spawn-actor 'ticker 4 '(tick 0))
+	(run-scheduler 1000)
+`
+
+func parseSpec(t *testing.T, code string) []Value {
+	forms, errs := NewParser(code).Parse()
+	if len(errs) > 0 {
+		t.Fatalf("parse errors: %v", errs)
+	}
+	return forms
+}
+
+func TestResimulatorResumesAfterDefinitionChange(t *testing.T) {
+	r := NewResimulator(func() *Evaluator { return NewEvaluator(64) })
+	r.Interval = 20
+
+	first := r.Run(parseSpec(t, tickerSpec))
+	if first.Incremental {
+		t.Fatalf("first run must be a full run")
+	}
+
+	edited := strings.Replace(tickerSpec, "(assert! 'result n)", "(assert! 'result (* 2 n))", 1)
+	second := r.Run(parseSpec(t, edited))
+	if !second.Incremental {
+		t.Fatalf("changing only report should resume, got a full run")
+	}
+	// report first runs at step 150; the checkpoint before it is 140
+	if second.ResumedFrom != 140 {
+		t.Errorf("expected resume from step 140, got %d", second.ResumedFrom)
+	}
+	if strings.Join(second.Changed, " ") != "report" {
+		t.Errorf("expected report changed, got %v", second.Changed)
+	}
+
+	// Same world as running the edited spec from scratch
+	fresh := NewEvaluator(64)
+	runCode(fresh, edited)
+	want := evalLast(fresh, `(list-facts)`).String()
+	if got := evalLast(second.World, `(list-facts)`).String(); got != want {
+		t.Errorf("incremental facts differ from a full run:\n got %s\nwant %s", got, want)
+	}
+	if got := evalLast(second.World, `(query 'result '?n)`).String(); !strings.Contains(got, "300") {
+		t.Errorf("expected result 300, got %s", got)
+	}
+
+	// Changing anything but function definitions is a full run
+	respawned := strings.Replace(edited, "(spawn-actor 'ticker 4", "(spawn-actor 'ticker 8", 1)
+	if third := r.Run(parseSpec(t, respawned)); third.Incremental {
+		t.Errorf("changed spawn should force a full run")
+	}
+}
+
+func TestResimulatorUnusedChangeReusesFinalState(t *testing.T) {
+	r := NewResimulator(func() *Evaluator { return NewEvaluator(64) })
+	r.Run(parseSpec(t, tickerSpec))
+
+	// A new function that no step calls
+	res := r.Run(parseSpec(t, `(define (unused) 'never-called)`+tickerSpec))
+	if !res.Incremental {
+		t.Fatalf("expected incremental run")
+	}
+	if res.ResumedFrom != 151 {
+		t.Errorf("expected resume from the final step 151, got %d", res.ResumedFrom)
+	}
+	if got := res.RunResult.String(); got != "(completed 151)" {
+		t.Errorf("unexpected run result %s", got)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+// ============================================================================
+// Run Configurations
+// ============================================================================
+//
+// run-scheduler takes either a step limit or a run configuration, given as
+// keyword arguments or as a map built by run-config:
+//
+//   (run-scheduler :max-steps 500 :seed 7 :policy 'random)
+//   (define cfg (run-config :max-steps 500 :quiescence true))
+//   (run-scheduler cfg)
+//
+// A configured run ends its result with the full configuration it ran
+// under, seed included, so (run-scheduler (last result)) repeats it.
+
+// RunConfig says how run-scheduler runs
+type RunConfig struct {
+	MaxSteps   int64
+	Seed       int64
+	SeedSet    bool    // Seed was given rather than drawn for the run
+	Policy     string  // round-robin, random or priority
+	Trace      bool    // Print each step
+	StopOn     []Value // Properties checked after every step: expressions or thunks
+	Quiescence bool    // Report (quiescent n) when every live actor waits on an empty mailbox
+	Record     string  // Trace file to write the run's events to (see tracefile.go)
+	Livelock   int64   // Stop when an actor takes this many steps without progress; 0 = don't
+	Starvation int64   // Stop when a runnable actor waits this many steps to run; 0 = don't
+}
+
+// runConfigKeys are the settings a run configuration can have
+var runConfigKeys = []string{"max-steps", "seed", "policy", "trace", "stop-on-property-failure", "quiescence", "record", "livelock", "starvation"}
+
+func defaultRunConfig() RunConfig {
+	return RunConfig{MaxSteps: 10000, Policy: "round-robin"}
+}
+
+// parseRunConfig reads run-scheduler's arguments. configured is false for
+// the plain (run-scheduler n) form, whose result doesn't carry a config.
+func (ev *Evaluator) parseRunConfig(args []Value) (cfg RunConfig, configured bool) {
+	cfg = defaultRunConfig()
+	cfg.Policy = ev.Scheduler.policyName()
+	cfg.Quiescence = ev.Scheduler.Quiescence
+	positional, opts := keywordArgs(args)
+	if len(positional) > 0 {
+		switch positional[0].Type {
+		case TypeNumber:
+			cfg.MaxSteps = int64(positional[0].Number)
+			if len(opts) == 0 {
+				return cfg, false
+			}
+		case TypeMap:
+			for _, k := range positional[0].Map.SortedKeys() {
+				opts[jsonKey(positional[0].Map.Keys[k])] = positional[0].Map.Data[k]
+			}
+		}
+	}
+	for _, key := range sortedKeys(opts) {
+		v := opts[key]
+		switch key {
+		case "max-steps":
+			if v.Type == TypeNumber {
+				cfg.MaxSteps = int64(v.Number)
+			}
+		case "seed":
+			if v.Type == TypeNumber {
+				cfg.Seed, cfg.SeedSet = int64(v.Number), true
+			}
+		case "policy":
+			if p := valueToString(v); schedulerPolicies[p] != nil {
+				cfg.Policy = p
+			} else {
+				ev.warn("run-config:policy:"+p, "run-scheduler: unknown policy %s; using %s", p, cfg.Policy)
+			}
+		case "trace":
+			cfg.Trace = v.IsTruthy()
+		case "stop-on-property-failure":
+			cfg.StopOn = propertyChecks(v)
+		case "quiescence":
+			cfg.Quiescence = v.IsTruthy()
+		case "record":
+			cfg.Record = valueToString(v)
+		case "livelock":
+			if v.Type == TypeNumber {
+				cfg.Livelock = int64(v.Number)
+			}
+		case "starvation":
+			if v.Type == TypeNumber {
+				cfg.Starvation = int64(v.Number)
+			}
+		default:
+			ev.warn("run-config:"+key, "run-scheduler: unknown setting :%s (expected one of %v)", key, runConfigKeys)
+		}
+	}
+	return cfg, true
+}
+
+// propertyChecks reads :stop-on-property-failure, which is one check or a
+// list of them. A check is an expression or a function of no arguments.
+func propertyChecks(v Value) []Value {
+	switch {
+	case v.Type == TypeNil:
+		return nil
+	case v.Type == TypeList && len(v.List) > 0 && v.List[0].Type == TypeSymbol:
+		return []Value{v}
+	case v.Type == TypeList:
+		return v.List
+	}
+	return []Value{v}
+}
+
+// Value is the configuration as a map with every setting filled in but
+// :record, so running it again doesn't write over the trace
+func (cfg RunConfig) Value() Value {
+	return mapValue(map[string]Value{
+		"max-steps":                Int(cfg.MaxSteps),
+		"seed":                     Int(cfg.Seed),
+		"policy":                   Sym(cfg.Policy),
+		"trace":                    Bool(cfg.Trace),
+		"stop-on-property-failure": Lst(cfg.StopOn...),
+		"quiescence":               Bool(cfg.Quiescence),
+		"livelock":                 Int(cfg.Livelock),
+		"starvation":               Int(cfg.Starvation),
+	})
+}
+
+// failedProperty runs the checks and returns the first that doesn't hold
+func (ev *Evaluator) failedProperty(checks []Value) (Value, bool) {
+	for _, check := range checks {
+		var ok Value
+		if check.Type == TypeFunc || check.Type == TypeBuiltin {
+			ok = ev.apply(check, nil, ev.GlobalEnv)
+		} else {
+			ok = ev.Eval(check, ev.GlobalEnv)
+		}
+		if !ok.IsTruthy() {
+			return check, true
+		}
+	}
+	return Nil(), false
+}
+
+// runConfigured runs the scheduler under cfg. Trace, policy and
+// quiescence apply to this run only.
+func (ev *Evaluator) runConfigured(cfg RunConfig) Value {
+	if !cfg.SeedSet {
+		// Numbers are float64, so keep the seed exact when it's read back
+		cfg.Seed = ev.Rand.Int63n(1 << 53)
+	}
+	ev.SetSeed(cfg.Seed)
+
+	s := ev.Scheduler
+	trace, policy, quiescence := s.Trace, s.Policy, s.Quiescence
+	s.Trace, s.Policy, s.Quiescence = cfg.Trace, cfg.Policy, cfg.Quiescence
+	defer func() { s.Trace, s.Policy, s.Quiescence = trace, policy, quiescence }()
+
+	s.MaxSteps = cfg.MaxSteps
+	s.StepCount = 0
+	var afterStep func() Value
+	if len(cfg.StopOn) > 0 || cfg.Livelock > 0 || cfg.Starvation > 0 {
+		afterStep = func() Value {
+			if check, failed := ev.failedProperty(cfg.StopOn); failed {
+				return Lst(Sym("property-failed"), Int(s.StepCount), check)
+			}
+			return ev.progressStop(cfg)
+		}
+	}
+	var recorder *TraceRecorder
+	if cfg.Record != "" {
+		recorder = ev.recordTrace(cfg)
+	}
+	result := ev.runSteps(cfg.MaxSteps, afterStep)
+	if recorder != nil {
+		if err := recorder.finish(ev, traceFinished(result, s.StepCount)); err != nil {
+			ev.warn("", "run-scheduler: %v", err)
+		}
+	}
+	return Lst(append(result.List, cfg.Value())...)
+}
+
+// (run-config :max-steps n :seed n :policy p :trace b
+// :stop-on-property-failure checks :quiescence b :record file) - a run configuration
+// for run-scheduler, with defaults for anything not given
+func builtinRunConfig(ev *Evaluator, args []Value, env *Env) Value {
+	cfg, _ := ev.parseRunConfig(args)
+	v := cfg.Value()
+	if !cfg.SeedSet {
+		// Drawn when the run starts
+		delete(v.Map.Data, "seed")
+		delete(v.Map.Keys, "seed")
+	}
+	return v
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Run Configuration Tests
+// ============================================================================
+
+const racers = `
+	(define (racer n) (if (> n 0) (begin (assert! 'ran (self) n) (list 'become (list 'racer (- n 1)))) 'done))
+	(spawn-actor 'a 4 '(racer 5))
+	(spawn-actor 'b 4 '(racer 5))
+	(spawn-actor 'c 4 '(racer 5))`
+
+func TestRunConfigResult(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, racers)
+
+	// The plain form is unchanged
+	if got := evalLast(ev, "(run-scheduler 100)").String(); got != "(completed 18)" {
+		t.Errorf("(run-scheduler 100) = %s", got)
+	}
+
+	ev = NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, racers)
+	got := evalLast(ev, "(run-scheduler :max-steps 100 :seed 7 :policy 'random)").String()
+	want := "(completed 18 {livelock 0 max-steps 100 policy random quiescence false seed 7 starvation 0 stop-on-property-failure () trace false})"
+	if got != want {
+		t.Errorf("configured run = %s, want %s", got, want)
+	}
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(run-config :max-steps 50)", "{livelock 0 max-steps 50 policy round-robin quiescence false starvation 0 stop-on-property-failure () trace false}"},
+		{"(run-config :seed 3 :trace true)", "{livelock 0 max-steps 10000 policy round-robin quiescence false seed 3 starvation 0 stop-on-property-failure () trace true}"},
+		{"(run-config :policy 'fastest)", "{livelock 0 max-steps 10000 policy round-robin quiescence false starvation 0 stop-on-property-failure () trace false}"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	if !ev.SeenErrors["run-config:policy:fastest"] {
+		t.Errorf("expected a warning for the unknown policy")
+	}
+}
+
+func TestRunConfigReproducesRun(t *testing.T) {
+	// A random run without a seed records the one it drew; running the
+	// recorded config again gives the same schedule.
+	run := func(code string) (Value, []string) {
+		ev := NewEvaluator(1000)
+		ev.Quiet = true
+		rec, finish := recordRun(ev, "")
+		runCode(ev, racers)
+		result := evalLast(ev, code)
+		finish()
+		return result, rec.Schedule
+	}
+	first, schedule := run("(run-scheduler :policy 'random)")
+	cfg, err := WriteValue(first.List[len(first.List)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, replayed := run("(run-scheduler (read-value " + Str(cfg).String() + "))")
+	if again.String() != first.String() {
+		t.Errorf("rerun gave %s, first run %s", again.String(), first.String())
+	}
+	if strings.Join(replayed, " ") != strings.Join(schedule, " ") {
+		t.Errorf("schedule changed:\n%v\n%v", schedule, replayed)
+	}
+}
+
+func TestRunConfigStopConditions(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (server) (let msg (receive!) (list 'become '(server))))
+		(spawn-actor 'server 4 '(server))
+		(spawn-actor 'client 4 '(begin (send-to! 'server 'hi) 'done))`)
+	if got := evalLast(ev, "(first (run-scheduler :quiescence true))").String(); got != "quiescent" {
+		t.Errorf("waiting server should be quiescent, got %s", got)
+	}
+	if ev.Scheduler.Quiescence {
+		t.Errorf("quiescence should only apply to the configured run")
+	}
+
+	ev = NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (count-up n)
+		  (if (= n 3) (assert! 'error 'overflow) nil)
+		  (list 'become (list 'count-up (+ n 1))))
+		(spawn-actor 'counter 4 '(count-up 0))`)
+	got := evalLast(ev, `(run-scheduler :max-steps 50 :stop-on-property-failure '(never? '(error ?why)))`)
+	if s := got.String(); !strings.HasPrefix(s, "(property-failed 4 (never? (quote (error ?why))) {") {
+		t.Errorf("expected the run to stop when the property failed, got %s", s)
+	}
+	// Thunks work too, and every check must hold
+	got = evalLast(ev, `(run-scheduler :max-steps 5 :stop-on-property-failure (list (lambda () true) (lambda () (< (fact-count 'error) 1))))`)
+	if s := got.String(); !strings.HasPrefix(s, "(property-failed 1 <function>") {
+		t.Errorf("expected the second check to fail, got %s", s)
+	}
+}
+
+func TestQuiescence(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{"plain runs report quiescence once it is set", `
+			(define (server) (let msg (receive!) (list 'become '(server))))
+			(spawn-actor 'server 4 '(server))
+			(spawn-actor 'client 4 '(begin (send-to! 'server 'hi) 'done))
+			(list (set-quiescence! true) (run-scheduler 100) (quiescent?) (run-scheduler :max-steps 100 :seed 0))`,
+			"(true (quiescent 4) true (quiescent 0 {livelock 0 max-steps 100 policy round-robin quiescence true seed 0 starvation 0 stop-on-property-failure () trace false}))"},
+		{"a pending timer isn't quiescent", `
+			(define waits '())
+			(on-schedule-event! (lambda (e) (if (equal? (first e) 'blocked) (set! waits (cons (quiescent?) waits)))))
+			(spawn-actor 'w 4 '(begin (set! result (receive-timeout! 5)) (receive!) 'done))
+			(set-quiescence! true)
+			(list (run-scheduler 100) result waits)`,
+			"((quiescent 2) timeout (true false))"},
+		{"a blocked send is still a deadlock", `
+			(set-quiescence! true)
+			(spawn-actor 'sink 1 '(begin (receive!) (send-to! 'source 'x) 'done))
+			(spawn-actor 'source 1 '(begin (send-to! 'sink 1) (send-to! 'sink 2) (send-to! 'sink 3) 'done))
+			(first (run-scheduler 100))`,
+			"deadlock"},
+		{"off by default", `
+			(spawn-actor 'server 4 '(begin (receive!) 'done))
+			(list (run-scheduler 100) (quiescent?))`,
+			`((deadlock 1 ((server "recv (empty)"))) true)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := NewEvaluator(1000)
+			ev.Quiet = true
+			got := evalLast(ev, tt.code).String()
+			if got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+// ============================================================================
+// Sandbox
+// ============================================================================
+//
+// Anyone who can reach the web port can post code to /eval, and it runs in
+// the server's one shared evaluator. With Sandbox set, that code can't
+// reach outside the evaluator or rewrite the shared registry:
+//
+//   - print, println, pp and print-scheduler-status don't write to the
+//     host's stdout, and (set-trace! true) is denied
+//   - read-file, write-file, append-file, file-exists?, load and
+//     add-load-path! are denied, whatever FileAccess says
+//   - (break) never pauses
+//   - registry-set! and registry-delete! are denied and return denied
+//   - set-call-stack-depth! is denied, leaving the depth every request
+//     shares as it was
+//
+// Denials are warnings. handleEval sets Sandbox for each request; code the
+// server itself runs, such as the spec a chat reply produced, isn't
+// sandboxed.
+
+// sandboxDenied warns and reports true if name is denied in the sandbox
+func (ev *Evaluator) sandboxDenied(name string) bool {
+	if !ev.Sandbox {
+		return false
+	}
+	ev.warn(name+":sandbox", "%s: not allowed in the sandbox", name)
+	return true
+}
+
+// hostOutput reports whether output may go to the host's stdout
+func (ev *Evaluator) hostOutput() bool {
+	return !ev.Quiet && !ev.Sandbox
+}
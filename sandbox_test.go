@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ============================================================================
+// Sandbox Tests
+// ============================================================================
+
+func TestSandboxDenies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(path, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		code     string
+		expected string
+		warning  string
+	}{
+		{`(read-file "` + path + `")`, "nil", "read-file: not allowed in the sandbox"},
+		{`(write-file "` + path + `" "overwritten")`, "nil", "write-file: not allowed in the sandbox"},
+		{`(file-exists? "` + path + `")`, "false", "file-exists?: not allowed in the sandbox"},
+		{`(load "` + path + `")`, "nil", "load: not allowed in the sandbox"},
+		{`(add-load-path! "/etc")`, "nil", "add-load-path!: not allowed in the sandbox"},
+		{`(registry-set! 'balance 0)`, "denied", "registry-set!: not allowed in the sandbox"},
+		{`(registry-delete! 'owner)`, "denied", "registry-delete!: not allowed in the sandbox"},
+		{`(set-trace! true)`, "false", "set-trace!: not allowed in the sandbox"},
+		{`(set-call-stack-depth! 10000)`, "1000", "set-call-stack-depth!: not allowed in the sandbox"},
+	}
+	for _, tt := range tests {
+		ev := NewEvaluator(1000)
+		ev.Quiet = true
+		ev.FileAccess = true
+		ev.Registry["owner"] = Sym("alice")
+		ev.Sandbox = true
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+		if lastWarning(ev, tt.warning) == nil {
+			t.Errorf("%s: no warning %q", tt.code, tt.warning)
+		}
+		if got := evalLast(ev, "(list (registry-keys) (registry-get 'owner))").String(); got != "((owner) alice)" {
+			t.Errorf("%s: the registry became %s", tt.code, got)
+		}
+		if len(ev.LoadPath) != 1 || ev.Scheduler.Trace || ev.CallStack.Capacity != 1000 {
+			t.Errorf("%s: load path %v, trace %v, stack depth %d", tt.code, ev.LoadPath, ev.Scheduler.Trace, ev.CallStack.Capacity)
+		}
+	}
+	if data, _ := os.ReadFile(path); string(data) != "secret" {
+		t.Errorf("the file became %q", data)
+	}
+}
+
+func TestEvalRequestSandboxed(t *testing.T) {
+	saved := globalEv
+	defer func() { globalEv = saved }()
+	globalEv = NewEvaluator(1000)
+	globalEv.Quiet = true
+	globalEv.FileAccess = true
+
+	body, _ := json.Marshal(map[string]string{"code": `(registry-set! 'balance 100) (registry-get 'balance) (+ 1 2)`})
+	rec := httptest.NewRecorder()
+	handleEval(rec, httptest.NewRequest("POST", "/eval", bytes.NewReader(body)))
+
+	var resp struct {
+		Results []string `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := json.Marshal(resp.Results); string(got) != `["denied","nil","3"]` {
+		t.Errorf("results = %s", got)
+	}
+	if globalEv.Sandbox {
+		t.Errorf("the request's sandbox outlived it")
+	}
+	if got := evalLast(globalEv, "(registry-set! 'balance 100)").String(); got != "100" {
+		t.Errorf("after the request, registry-set! = %s", got)
+	}
+}
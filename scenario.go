@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// Scenario Tables
+// ============================================================================
+//
+// A scenario is a list of scripted stimuli: messages delivered to actors
+// from outside the system once the run reaches a given step. The
+// {{scenario_table}} tool renders the current scenario as a markdown table
+//
+//   | Step | Actor  | Message    |
+//   |------|--------|------------|
+//   | 0    | bakery | (order 10) |
+//
+// and any table with those three columns is read back from the document
+// before its LISP runs, so a stakeholder can change a scenario by editing
+// rows instead of code. Messages are written as LISP data.
+
+// ScriptedStimulus is a message delivered to an actor from outside at a step
+type ScriptedStimulus struct {
+	Step    int64
+	Actor   string
+	Message Value
+}
+
+// Value is the stimulus as (step actor message)
+func (s ScriptedStimulus) Value() Value {
+	return Lst(Int(s.Step), Sym(s.Actor), s.Message)
+}
+
+// scenarioColumns are the headers of a scenario table, in order
+var scenarioColumns = []string{"step", "actor", "message"}
+
+// setScenario replaces the scripted stimuli, keeping them in step order
+func (ev *Evaluator) setScenario(stimuli []ScriptedStimulus) {
+	sort.SliceStable(stimuli, func(i, j int) bool { return stimuli[i].Step < stimuli[j].Step })
+	ev.Scheduler.Stimuli = stimuli
+	ev.Scheduler.NextStimulus = 0
+}
+
+// deliverStimuli delivers the pending stimuli that are due by the current
+// step. With force, the next one is delivered even if it isn't due yet,
+// for when nothing else can happen until it arrives. Returns how many
+// were delivered.
+func (ev *Evaluator) deliverStimuli(force bool) int {
+	s := ev.Scheduler
+	delivered := 0
+	for s.NextStimulus < len(s.Stimuli) {
+		stim := s.Stimuli[s.NextStimulus]
+		if stim.Step > s.StepCount && !(force && delivered == 0) {
+			break
+		}
+		s.NextStimulus++
+		delivered++
+		if !deliverStimulus(ev, Sym(stim.Actor), stim.Message) {
+			ev.warn("", "scenario: step %d: couldn't deliver %s to %s", stim.Step, stim.Message.String(), stim.Actor)
+		}
+	}
+	return delivered
+}
+
+// ParseScenarioTables reads the stimuli from every Step | Actor | Message
+// table in markdown. Rows that can't be read are skipped and described in
+// problems.
+func ParseScenarioTables(markdown string) (stimuli []ScriptedStimulus, problems []string, found bool) {
+	inTable := false
+	for n, line := range strings.Split(markdown, "\n") {
+		cells, ok := tableCells(line)
+		if !ok {
+			inTable = false
+			continue
+		}
+		if !inTable {
+			inTable = isScenarioHeader(cells)
+			found = found || inTable
+			continue
+		}
+		if isTableRule(cells) {
+			continue
+		}
+		stim, err := parseScenarioRow(cells)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %v", n+1, err))
+			continue
+		}
+		stimuli = append(stimuli, stim)
+	}
+	return stimuli, problems, found
+}
+
+// tableCells splits a markdown table row into trimmed cells
+func tableCells(line string) ([]string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "|") || !strings.HasSuffix(line, "|") || len(line) < 2 {
+		return nil, false
+	}
+	line = line[1 : len(line)-1]
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			cell.WriteByte('|')
+			i++
+		case line[i] == '|':
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+		default:
+			cell.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(cell.String())), true
+}
+
+func isScenarioHeader(cells []string) bool {
+	if len(cells) != len(scenarioColumns) {
+		return false
+	}
+	for i, c := range cells {
+		if strings.ToLower(c) != scenarioColumns[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isTableRule reports whether cells are a |---|:--:| separator row
+func isTableRule(cells []string) bool {
+	for _, c := range cells {
+		if strings.Trim(c, "-: ") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func parseScenarioRow(cells []string) (ScriptedStimulus, error) {
+	if len(cells) != len(scenarioColumns) {
+		return ScriptedStimulus{}, fmt.Errorf("expected step, actor and message, got %d cells", len(cells))
+	}
+	step, err := strconv.ParseInt(cells[0], 10, 64)
+	if err != nil || step < 0 {
+		return ScriptedStimulus{}, fmt.Errorf("step %q is not a step number", cells[0])
+	}
+	actor := strings.Trim(cells[1], "`'")
+	if actor == "" || strings.ContainsAny(actor, " ()") {
+		return ScriptedStimulus{}, fmt.Errorf("actor %q is not an actor name", cells[1])
+	}
+	exprs, errs := NewParser(strings.Trim(cells[2], "`")).Parse()
+	if len(errs) > 0 || len(exprs) != 1 {
+		return ScriptedStimulus{}, fmt.Errorf("message %q is not a single LISP value", cells[2])
+	}
+	msg := exprs[0]
+	if msg.Type == TypeList && len(msg.List) == 2 && msg.List[0].IsSymbol() && msg.List[0].Symbol == "quote" {
+		msg = msg.List[1]
+	}
+	return ScriptedStimulus{Step: step, Actor: actor, Message: msg}, nil
+}
+
+// LoadScenarioTables replaces the scenario with the tables in markdown, if
+// it has any, warning about rows that can't be read. Returns whether the
+// document had a scenario table.
+func (ev *Evaluator) LoadScenarioTables(markdown string) bool {
+	stimuli, problems, found := ParseScenarioTables(markdown)
+	for _, p := range problems {
+		ev.warn("", "scenario_table: %s", p)
+	}
+	if found {
+		ev.setScenario(stimuli)
+	}
+	return found
+}
+
+// toolScenarioTable renders the scenario as an editable table
+func toolScenarioTable(ev *Evaluator, args map[string]string) string {
+	s := ev.Scheduler
+	var sb strings.Builder
+	sb.WriteString("| Step | Actor | Message |\n")
+	sb.WriteString("|------|-------|---------|\n")
+	for _, stim := range s.Stimuli {
+		msg := strings.ReplaceAll(stim.Message.String(), "|", `\|`)
+		sb.WriteString(fmt.Sprintf("| %d | %s | %s |\n", stim.Step, stim.Actor, msg))
+	}
+	if len(s.Stimuli) == 0 {
+		sb.WriteString("\n*No scripted stimuli; add rows to deliver messages on the next run*\n")
+	} else if s.NextStimulus > 0 {
+		sb.WriteString(fmt.Sprintf("\n*%d of %d delivered*\n", s.NextStimulus, len(s.Stimuli)))
+	}
+	return sb.String()
+}
+
+// (schedule-stimuli! '((step actor message) ...)) - script messages to
+// deliver from outside as the next runs reach each step
+func builtinScheduleStimuli(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) < 1 || (args[0].Type != TypeList && args[0].Type != TypeNil) {
+		ev.warn("", "schedule-stimuli!: expected a list of (step actor message)")
+		return Nil()
+	}
+	var stimuli []ScriptedStimulus
+	for _, entry := range args[0].List {
+		if entry.Type != TypeList || len(entry.List) != 3 || entry.List[0].Type != TypeNumber {
+			ev.warn("", "schedule-stimuli!: %s is not (step actor message)", entry.String())
+			continue
+		}
+		stimuli = append(stimuli, ScriptedStimulus{
+			Step:    int64(entry.List[0].Number),
+			Actor:   valueToString(entry.List[1]),
+			Message: entry.List[2],
+		})
+	}
+	ev.setScenario(stimuli)
+	return Int(int64(len(stimuli)))
+}
+
+// (pending-stimuli) - the scripted stimuli not yet delivered
+func builtinPendingStimuli(ev *Evaluator, args []Value, env *Env) Value {
+	s := ev.Scheduler
+	pending := make([]Value, 0, len(s.Stimuli)-s.NextStimulus)
+	for _, stim := range s.Stimuli[s.NextStimulus:] {
+		pending = append(pending, stim.Value())
+	}
+	return Lst(pending...)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Scenario Table Tests
+// ============================================================================
+
+const bakeryDoc = `# Bakery
+
+Edit the rows below to change what customers order.
+
+| Step | Actor | Message |
+|------|-------|---------|
+| 0 | bakery | (order 10) |
+| 3 | bakery | 'cancel |
+| soon | bakery | (order 2) |
+| 2 | bakery | "a \| b" |
+
+| Name | Value |
+|------|-------|
+| 1 | ignored |
+`
+
+func TestParseScenarioTables(t *testing.T) {
+	stimuli, problems, found := ParseScenarioTables(bakeryDoc)
+	if !found {
+		t.Fatal("expected the scenario table to be found")
+	}
+	var got []string
+	for _, s := range stimuli {
+		got = append(got, s.Value().String())
+	}
+	want := `(0 bakery (order 10)) (3 bakery cancel) (2 bakery "a | b")`
+	if strings.Join(got, " ") != want {
+		t.Errorf("stimuli = %s, want %s", strings.Join(got, " "), want)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], `step "soon"`) {
+		t.Errorf("expected one problem with the step column, got %v", problems)
+	}
+	if _, _, found := ParseScenarioTables("| Name | Value |\n|---|---|\n| a | b |\n"); found {
+		t.Errorf("a table with other columns isn't a scenario")
+	}
+}
+
+func TestScenarioTableRoundTrip(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	tr := NewToolRegistry(ev)
+	if out := tr.Process("{{scenario_table}}"); !strings.Contains(out, "No scripted stimuli") {
+		t.Errorf("empty scenario should say so:\n%s", out)
+	}
+
+	evalLast(ev, `(schedule-stimuli! '((5 till "x|y") (1 till (ring 3))))`)
+	out := tr.Process("## Scenario\n\n{{scenario_table}}")
+	if !strings.Contains(out, "| 1 | till | (ring 3) |\n| 5 | till | \"x\\|y\" |") {
+		t.Errorf("expected rows in step order:\n%s", out)
+	}
+
+	// The rendered table, edited by hand, scripts the next run
+	edited := strings.Replace(out, "(ring 3)", "(ring 4)", 1)
+	fresh := NewEvaluator(1000)
+	if !fresh.LoadScenarioTables(edited) {
+		t.Fatal("rendered table wasn't read back")
+	}
+	if got := evalLast(fresh, "(pending-stimuli)").String(); got != `((1 till (ring 4)) (5 till "x|y"))` {
+		t.Errorf("pending-stimuli = %s", got)
+	}
+}
+
+func TestScriptedStimuliDriveRun(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (till total)
+		  (let msg (receive!)
+		    (if (eq? msg 'close)
+		        (begin (assert! 'closed total) 'done)
+		        (begin (assert! 'rang (nth msg 1)) (list 'become (list 'till (+ total (nth msg 1))))))))
+		(define (ticker n) (if (> n 0) (list 'become (list 'ticker (- n 1))) 'done))
+		(spawn-actor 'till 8 '(till 0))
+		(spawn-actor 'ticker 8 '(ticker 6))`)
+	ev.LoadScenarioTables(`
+| Step | Actor | Message |
+|------|-------|---------|
+| 0 | till | (ring 3) |
+| 4 | till | (ring 4) |
+| 40 | till | close |
+`)
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(first (run-scheduler 100))", "completed"},
+		// The last stimulus is delivered early once everyone is waiting
+		{"(query 'closed '?t)", "(((t 7)))"},
+		{"(pending-stimuli)", "()"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	if out := NewToolRegistry(ev).Process("{{scenario_table}}"); !strings.Contains(out, "*3 of 3 delivered*") {
+		t.Errorf("expected delivery count:\n%s", out)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+// ============================================================================
+// Multiple Schedulers
+// ============================================================================
+//
+// Actors live in a scheduler, and by default there is one. Separate
+// experiments in one process can each have their own, so their actors,
+// mailboxes, timers and step counts don't mix:
+//
+//   (define lab (make-scheduler))                   ; => #scheduler{2}
+//   (spawn-actor 'p 4 '(producer 3) :scheduler lab)
+//   (run-scheduler 100 :scheduler lab)
+//   (with-scheduler lab (scheduler-status))         ; anything else
+//
+// spawn-actor, spawn and run-scheduler take :scheduler s; with-scheduler
+// runs its body with s as the current scheduler, so every actor builtin
+// in it, send-to! and actor-state among them, works on s's actors.
+// (current-scheduler) is the current one. The schedulers share the global
+// environment and the Datalog database. An actor can't switch schedulers
+// while it is running. The web server's sessions each have a scheduler
+// and a database of their own (see enterSession).
+
+// (make-scheduler) - a new scheduler with no actors, #scheduler{n}
+func builtinMakeScheduler(ev *Evaluator, args []Value, env *Env) Value {
+	return ev.schedulerValue(NewScheduler())
+}
+
+// (current-scheduler) - the scheduler actor builtins work on
+func builtinCurrentScheduler(ev *Evaluator, args []Value, env *Env) Value {
+	return ev.schedulerValue(ev.Scheduler)
+}
+
+// schedulerValue is the value that names s, numbering s if it is new.
+// The scheduler ev started with is always #scheduler{1}.
+func (ev *Evaluator) schedulerValue(s *Scheduler) Value {
+	if len(ev.schedulers) == 0 {
+		ev.schedulers = append(ev.schedulers, ev.Scheduler)
+	}
+	n := 0
+	for i, known := range ev.schedulers {
+		if known == s {
+			n = i + 1
+		}
+	}
+	if n == 0 {
+		ev.schedulers = append(ev.schedulers, s)
+		n = len(ev.schedulers)
+	}
+	return Value{Type: TypeTagged, Tagged: &TaggedValue{Tag: "scheduler", Value: Int(int64(n))}}
+}
+
+// scheduler is the scheduler v names, or nil if v doesn't name one
+func (ev *Evaluator) scheduler(v Value) *Scheduler {
+	if v.Type != TypeTagged || v.Tagged.Tag != "scheduler" || v.Tagged.Value.Type != TypeNumber {
+		return nil
+	}
+	n := int(v.Tagged.Value.Number)
+	if n < 1 || n > len(ev.schedulers) {
+		return nil
+	}
+	return ev.schedulers[n-1]
+}
+
+// useScheduler makes s the current scheduler until the returned function
+// puts the one before back
+func (ev *Evaluator) useScheduler(s *Scheduler) func() {
+	before := ev.Scheduler
+	ev.Scheduler = s
+	return func() { ev.Scheduler = before }
+}
+
+// onScheduler runs run with the scheduler v names as the current one
+func (ev *Evaluator) onScheduler(op string, v Value, run func() Value) Value {
+	s := ev.scheduler(v)
+	if s == nil {
+		ev.warnTrace("", "%s: not a scheduler: %s", op, v.String())
+		return Nil()
+	}
+	if actor := ev.Scheduler.CurrentActor; actor != "" && s != ev.Scheduler {
+		ev.warnTrace("", "%s: can't switch schedulers while actor %s is running", op, actor)
+		return Nil()
+	}
+	defer ev.useScheduler(s)()
+	return run()
+}
+
+// schedulerOption takes :scheduler s out of args; ok is false without it
+func schedulerOption(args []Value) (rest []Value, s Value, ok bool) {
+	for i := 0; i+1 < len(args); i++ {
+		if args[i].IsSymbol() && args[i].Symbol == ":scheduler" {
+			rest = append(append(rest, args[:i]...), args[i+2:]...)
+			return rest, args[i+1], true
+		}
+	}
+	return args, Nil(), false
+}
+
+// evalWithScheduler evaluates (with-scheduler s body...)
+func (ev *Evaluator) evalWithScheduler(args []Value, env *Env) Value {
+	if len(args) == 0 {
+		ev.warnTrace("", "with-scheduler: need a scheduler and a body")
+		return Nil()
+	}
+	s := ev.Eval(args[0], env)
+	if s.Type == TypeBlocked {
+		return s
+	}
+	return ev.onScheduler("with-scheduler", s, func() Value {
+		result := Nil()
+		for _, e := range args[1:] {
+			if result = ev.Eval(e, env); result.Type == TypeBlocked {
+				break
+			}
+		}
+		return result
+	})
+}
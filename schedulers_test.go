@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+// ============================================================================
+// Multiple Scheduler Tests
+// ============================================================================
+
+func TestSchedulers(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (pinger n) (send-to! 'ponger n) (if (> n 0) (list 'become (list 'pinger (- n 1))) 'done))
+		(define (ponger) (receive!) (list 'become '(ponger)))
+		(spawn-actor 'ponger 2 '(ponger))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(define lab (make-scheduler))", "#scheduler{2}"},
+		{"(current-scheduler)", "#scheduler{1}"},
+		{"(spawn-actor 'pinger 2 '(pinger 2) :scheduler lab)", "<actor:pinger>"},
+		{"(spawn-actor 'ponger 2 '(ponger) :scheduler lab)", "<actor:ponger>"},
+		{"(spawn 2 '(ponger) :scheduler lab)", "<actor:actor-1>"},
+		// Each runs only its own actors
+		{"(list-actors-sched)", "(ponger)"},
+		{"(run-scheduler 50 :scheduler lab)", `(deadlock 8 ((actor-1 "recv (empty)") (ponger "recv (empty)")))`},
+		{"(actor-state 'pinger)", "nil"},
+		{"(with-scheduler lab (actor-state 'pinger))", `(done "" 0 2)`},
+		{"(with-scheduler lab (current-scheduler))", "#scheduler{2}"},
+		{"(run-scheduler 50)", `(deadlock 1 ((ponger "recv (empty)")))`},
+		// Both report to the one database
+		{"(length (query 'spawned '?a))", "4"},
+		// The current scheduler is put back after
+		{"(current-scheduler)", "#scheduler{1}"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+
+	runCode(ev, `
+		(define (hopper) (with-scheduler lab (self)) 'done)
+		(spawn-actor 'hopper 2 '(hopper))
+		(run-scheduler 10)`)
+	if lastWarning(ev, "with-scheduler: can't switch schedulers while actor hopper is running") == nil {
+		t.Errorf("expected a warning that hopper can't switch schedulers, got %v", ev.Warnings)
+	}
+	if got := evalLast(ev, "(run-scheduler 10 :scheduler 5)"); got.Type != TypeNil || lastWarning(ev, "run-scheduler: not a scheduler: 5") == nil {
+		t.Errorf("run-scheduler on a non-scheduler = %s, want nil and a warning", got.String())
+	}
+}
+
+func TestSessionsKeepTheirOwnActorsAndFacts(t *testing.T) {
+	saved, savedSessions := globalEv, sessions
+	defer func() { globalEv, sessions = saved, savedSessions }()
+	globalEv = NewEvaluator(1000)
+	globalEv.Quiet = true
+	sessions = make(map[string]*Session)
+
+	eval := func(session, code string) string {
+		body, _ := json.Marshal(map[string]interface{}{"session_id": session, "code": code})
+		rec := httptest.NewRecorder()
+		handleEval(rec, httptest.NewRequest("POST", "/eval", bytes.NewReader(body)))
+		var resp struct {
+			Results []string `json:"results"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Results) == 0 {
+			t.Fatalf("%s: %s", code, rec.Body.String())
+		}
+		return resp.Results[len(resp.Results)-1]
+	}
+	eval("a", "(assert! 'owner 'alice) (spawn-actor 'worker 2 '(done!))")
+	eval("b", "(assert! 'owner 'bob)")
+
+	tests := []struct {
+		session, code, expected string
+	}{
+		{"a", "(query 'owner '?who)", "(((who alice)))"},
+		{"b", "(query 'owner '?who)", "(((who bob)))"},
+		{"b", "(list-actors-sched)", "()"},
+		{"a", "(list-actors-sched)", "(worker)"},
+		{"", "(query 'owner '?who)", "()"},
+	}
+	for _, tt := range tests {
+		if got := eval(tt.session, tt.code); got != tt.expected {
+			t.Errorf("session %q: %s = %s, want %s", tt.session, tt.code, got, tt.expected)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handleFacts(rec, httptest.NewRequest("GET", "/facts?session_id=b", nil))
+	var facts struct {
+		Total int `json:"total_facts"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &facts); err != nil || facts.Total != 1 {
+		t.Errorf("/facts for session b = %s, want its one fact", rec.Body.String())
+	}
+}
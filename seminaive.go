@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ============================================================================
+// Bottom-Up Evaluation
+// ============================================================================
+//
+// solve works top-down: a goal is matched against facts, then against the
+// heads of rules, whose bodies are solved in turn. A query derives only
+// what it asks about, but the next query derives it all again.
+//
+// Bottom-up evaluation runs the rules forward instead, deriving every fact
+// they imply until no rule derives anything new, and answers queries from
+// the facts plus the derived ones:
+//
+//   (query 'path 0 '?x :mode 'bottom-up)        ; this query only
+//   (query-all '(path 0 ?x) '(goal ?x) :mode 'bottom-up)
+//   (datalog-mode! 'bottom-up 'path 'reach)     ; these predicates always
+//   (datalog-mode! 'bottom-up)                  ; every rule
+//   (datalog-mode! 'top-down)                   ; back to the default
+//
+// The rules are evaluated semi-naively: after the first round, a rule is
+// only run against the facts the previous round derived for at least one
+// of its recursive goals, so each derivation is found about once rather
+// than once per round. Rules with negation are evaluated in strata, each
+// predicate after everything it negates has been derived in full.
+//
+// Termination follows from the Datalog restrictions, which are checked
+// before anything is derived: every variable in a rule's head must appear
+// in a plain goal of its body (or on the left of an is goal), so derived
+// facts only recombine terms already in the database; no predicate may
+// depend on its own negation; and a rule that computes with is may not be
+// recursive (see arithgoals.go). Temporal goals (at-time, before, after,
+// between) in rule bodies see asserted facts only.
+//
+// The derived facts are kept apart from the asserted ones, in a view of
+// the database that is reused until a fact or rule changes.
+
+// materialized is a database's rules run to fixpoint, and when that was
+type materialized struct {
+	view    *DatalogDB // Asserted facts then derived ones, without rules
+	version int        // db.version it was derived at
+	facts   int        // len(db.Facts) it was derived at
+	err     error
+}
+
+// bottomUp reports whether goals on pred are answered bottom-up
+func (db *DatalogDB) bottomUp(pred string) bool {
+	return db.Mode == "bottom-up" || db.BottomUp[pred]
+}
+
+// Materialize runs the rules to fixpoint, returning a database holding
+// the asserted facts followed by every fact the rules derive from them
+func (db *DatalogDB) Materialize() (*DatalogDB, error) {
+	if m := db.materialized; m != nil && m.version == db.version && m.facts == len(db.Facts) {
+		return m.view, m.err
+	}
+	view, err := db.derive()
+	db.materialized = &materialized{view: view, version: db.version, facts: len(db.Facts), err: err}
+	return view, err
+}
+
+// QueryGoalsBottomUp solves goals against the materialized database
+func (db *DatalogDB) QueryGoalsBottomUp(goals ...Goal) ([]Binding, error) {
+	view, err := db.Materialize()
+	if err != nil {
+		return nil, err
+	}
+	return view.QueryGoals(goals...), nil
+}
+
+// CheckBottomUp reports why the rules can't be evaluated bottom-up, or nil
+func (db *DatalogDB) CheckBottomUp() error {
+	for _, r := range db.Rules {
+		if err := r.checkSafe(); err != nil {
+			return err
+		}
+		if r.computes() && db.dependsOn(r.Head.Predicate, r.Head.Predicate) {
+			return fmt.Errorf("rule %s: an is goal in recursive %s could compute new numbers forever", r.name(), r.Head.Predicate)
+		}
+	}
+	_, err := db.strata()
+	return err
+}
+
+// computes reports whether r has an is goal
+func (r Rule) computes() bool {
+	for _, g := range r.Body {
+		if g.IsBuiltin && g.Builtin == "is" {
+			return true
+		}
+	}
+	return false
+}
+
+// dependsOn reports whether some rule for from uses to, directly or
+// through other rules
+func (db *DatalogDB) dependsOn(from, to string) bool {
+	seen := map[string]bool{from: true}
+	for frontier := []string{from}; len(frontier) > 0; {
+		p := frontier[0]
+		frontier = frontier[1:]
+		for _, r := range db.Rules {
+			if r.Head.Predicate != p {
+				continue
+			}
+			for _, g := range r.Body {
+				if g.IsBuiltin {
+					continue
+				}
+				if g.Predicate == to {
+					return true
+				}
+				if !seen[g.Predicate] {
+					seen[g.Predicate] = true
+					frontier = append(frontier, g.Predicate)
+				}
+			}
+		}
+	}
+	return false
+}
+
+// derive computes the materialized view
+func (db *DatalogDB) derive() (*DatalogDB, error) {
+	if err := db.CheckBottomUp(); err != nil {
+		return nil, err
+	}
+	strata, _ := db.strata()
+
+	work := NewDatalogDB()
+	work.Facts = append(work.Facts, db.Facts...)
+	work.derived, work.asserted = true, len(db.Facts)
+	seen := make(map[string]bool, len(db.Facts))
+	for _, f := range db.Facts {
+		seen[f.key()] = true
+	}
+	var derived []Fact
+
+	for _, stratum := range strata {
+		in := make(map[string]bool, len(stratum))
+		for _, p := range stratum {
+			in[p] = true
+		}
+		var rules []Rule
+		for _, r := range db.Rules {
+			if in[r.Head.Predicate] {
+				rules = append(rules, r)
+			}
+		}
+
+		// The first round runs every rule on everything; later rounds run
+		// each recursive goal against only the last round's new facts,
+		// filed under a predicate of their own
+		delta := db.fire(work, rules, -1, "", seen)
+		for round := 1; len(delta) > 0; round++ {
+			derived = append(derived, delta...)
+			work.Facts = append(work.Facts, delta...)
+			prefix := fmt.Sprintf("\x00delta%d ", round)
+			for _, f := range delta {
+				f.Predicate = prefix + f.Predicate
+				work.Facts = append(work.Facts, f)
+			}
+			var next []Fact
+			for _, r := range rules {
+				for j, g := range r.Body {
+					if in[g.Predicate] && !g.Negated && !g.IsBuiltin {
+						next = append(next, db.fire(work, []Rule{r}, j, prefix, seen)...)
+					}
+				}
+			}
+			delta = next
+		}
+	}
+
+	view := NewDatalogDB()
+	view.Facts = append(append(view.Facts, db.Facts...), derived...)
+	view.TimeNow = db.TimeNow
+	view.derived, view.asserted = true, len(db.Facts)
+	return view, nil
+}
+
+// fire solves the bodies of rules in work and returns the head facts that
+// aren't already known. If j isn't -1, body goal j matches only the facts
+// filed under prefix, the last round's.
+func (db *DatalogDB) fire(work *DatalogDB, rules []Rule, j int, prefix string, seen map[string]bool) []Fact {
+	var fresh []Fact
+	for _, r := range rules {
+		body := r.Body
+		if j >= 0 {
+			body = append([]Goal(nil), r.Body...)
+			body[j].Predicate = prefix + body[j].Predicate
+		}
+		for _, b := range work.solve(body, make(Binding), 0) {
+			f := Fact{Predicate: r.Head.Predicate, Args: make([]Term, len(r.Head.Args)), Time: db.TimeNow}
+			for i, a := range r.Head.Args {
+				f.Args[i] = b.Deref(a)
+			}
+			if k := f.key(); !seen[k] {
+				seen[k] = true
+				fresh = append(fresh, f)
+			}
+		}
+	}
+	return fresh
+}
+
+// key identifies a fact by its predicate and arguments
+func (f Fact) key() string {
+	var sb strings.Builder
+	sb.WriteString(f.Predicate)
+	for _, a := range f.Args {
+		sb.WriteByte(' ')
+		a.writeKey(&sb)
+	}
+	return sb.String()
+}
+
+// writeKey writes t so that terms of different kinds never look alike,
+// as the atom 1 and the number 1 do when printed
+func (t Term) writeKey(sb *strings.Builder) {
+	switch {
+	case t.IsList:
+		sb.WriteByte('(')
+		for i, x := range t.List {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			x.writeKey(sb)
+		}
+		sb.WriteByte(')')
+	case t.IsNum:
+		sb.WriteByte('#')
+		sb.WriteString(t.String())
+	default:
+		sb.WriteString(t.String())
+	}
+}
+
+// checkSafe reports a variable in r's head that no plain goal of its body
+// binds, which could make it derive facts about terms that aren't in the
+// database
+func (r Rule) checkSafe() error {
+	bound := make(map[string]bool)
+	for _, g := range r.Body {
+		if !g.Negated && !g.IsBuiltin {
+			for _, a := range g.Args {
+				a.vars(bound)
+			}
+		}
+		if !g.Negated && g.Builtin == "is" && len(g.Args) == 2 {
+			g.Args[0].vars(bound)
+		}
+	}
+	used := make(map[string]bool)
+	for _, a := range r.Head.Args {
+		a.vars(used)
+	}
+	for _, v := range sortedKeys(used) {
+		if !bound[v] {
+			return fmt.Errorf("rule %s: ?%s in its head isn't bound by a goal of its body", r.name(), v)
+		}
+	}
+	return nil
+}
+
+func (r Rule) name() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Head.Predicate
+}
+
+// vars adds the names of the variables in t to into
+func (t Term) vars(into map[string]bool) {
+	if t.IsVar {
+		into[t.Name] = true
+	}
+	for _, x := range t.List {
+		x.vars(into)
+	}
+}
+
+func (g Goal) String() string {
+	pred := g.Predicate
+	if g.IsBuiltin {
+		pred = g.Builtin
+	}
+	s := ListTerm(append([]Term{Atom(pred)}, g.Args...)...).String()
+	if g.Negated {
+		return "(not " + s + ")"
+	}
+	return s
+}
+
+// strata orders the predicates rules define so that each comes after
+// every predicate it depends on through negation, grouping them by
+// stratum. It fails if a predicate depends on its own negation.
+func (db *DatalogDB) strata() ([][]string, error) {
+	defined := make(map[string]bool)
+	for _, r := range db.Rules {
+		defined[r.Head.Predicate] = true
+	}
+	level := make(map[string]int)
+	for changed := true; changed; {
+		changed = false
+		for _, r := range db.Rules {
+			h := r.Head.Predicate
+			for _, g := range r.Body {
+				if g.IsBuiltin || !defined[g.Predicate] {
+					continue
+				}
+				need := level[g.Predicate]
+				if g.Negated {
+					need++
+				}
+				if level[h] < need {
+					if need > len(defined) {
+						return nil, fmt.Errorf("rule %s: %s depends on its own negation", r.name(), h)
+					}
+					level[h] = need
+					changed = true
+				}
+			}
+		}
+	}
+	var strata [][]string
+	for _, p := range sortedKeys(defined) {
+		for len(strata) <= level[p] {
+			strata = append(strata, nil)
+		}
+		strata[level[p]] = append(strata[level[p]], p)
+	}
+	return strata, nil
+}
+
+// queryMode reads the :mode option of query and query-all: true for
+// bottom-up, false for top-down, and ok false if it is neither
+func queryMode(ev *Evaluator, name string, opts map[string]Value) (bottomUp, ok bool) {
+	v, given := opts["mode"]
+	if !given {
+		return false, true
+	}
+	switch mode := valueToString(v); mode {
+	case "bottom-up":
+		if err := ev.DatalogDB.CheckBottomUp(); err != nil {
+			ev.warnTrace("", "%s: can't evaluate bottom-up: %v", name, err)
+			return false, false
+		}
+		return true, true
+	case "top-down":
+		return false, true
+	default:
+		ev.warnTrace("", "%s: :mode must be top-down or bottom-up, got %s", name, mode)
+		return false, false
+	}
+}
+
+// (datalog-mode! 'bottom-up|'top-down [pred ...]) - how goals on the
+// given predicates, or on every predicate, are solved from now on
+func builtinDatalogMode(ev *Evaluator, args []Value, env *Env) Value {
+	db := ev.DatalogDB
+	mode := valueToString(args[0])
+	if mode != "bottom-up" && mode != "top-down" {
+		ev.warnTrace("", "datalog-mode!: mode must be top-down or bottom-up, got %s", mode)
+		return Nil()
+	}
+	if mode == "bottom-up" {
+		if err := db.CheckBottomUp(); err != nil {
+			ev.warnTrace("", "datalog-mode!: can't evaluate bottom-up: %v", err)
+			return Nil()
+		}
+	}
+	if len(args) == 1 {
+		db.Mode = mode
+		db.BottomUp = nil
+		if mode == "top-down" {
+			db.Mode = ""
+		}
+		return Sym("ok")
+	}
+	if db.BottomUp == nil {
+		db.BottomUp = make(map[string]bool)
+	}
+	for _, p := range args[1:] {
+		if mode == "bottom-up" {
+			db.BottomUp[valueToString(p)] = true
+		} else {
+			delete(db.BottomUp, valueToString(p))
+		}
+	}
+	return Sym("ok")
+}
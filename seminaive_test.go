@@ -0,0 +1,169 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Bottom-Up Evaluation Tests
+// ============================================================================
+
+// chainDB has edges 0 -> 1 -> ... -> n and path rules over them
+func chainDB(n int) *DatalogDB {
+	db := NewDatalogDB()
+	for i := 0; i < n; i++ {
+		db.Assert("edge", NumTerm(float64(i)), NumTerm(float64(i+1)))
+	}
+	db.AddRule("path-base",
+		Fact{Predicate: "path", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "edge", Args: []Term{Var("X"), Var("Y")}},
+	)
+	db.AddRule("path-trans",
+		Fact{Predicate: "path", Args: []Term{Var("X"), Var("Z")}},
+		Goal{Predicate: "edge", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "path", Args: []Term{Var("Y"), Var("Z")}},
+	)
+	return db
+}
+
+func TestBottomUpLongChain(t *testing.T) {
+	db := chainDB(150)
+	path := Goal{Predicate: "path", Args: []Term{NumTerm(0), Var("Y")}}
+
+	if got := len(db.QueryGoals(path)); got != 150 {
+		t.Errorf("top-down found %d paths from 0, want 150", got)
+	}
+	results, err := db.QueryGoalsBottomUp(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 150 {
+		t.Errorf("bottom-up found %d paths from 0, want 150", len(results))
+	}
+
+	// Marking the predicate makes plain queries, and rules using it, go bottom-up
+	db.BottomUp = map[string]bool{"path": true}
+	if got := len(db.Query("path", NumTerm(0), Var("Y"))); got != 150 {
+		t.Errorf("with path bottom-up, found %d paths from 0, want 150", got)
+	}
+	if got := len(db.Query("path", Var("X"), Var("Y"))); got != 150*151/2 {
+		t.Errorf("found %d paths in all, want %d", got, 150*151/2)
+	}
+
+	// A new edge is seen by the next query
+	db.Assert("edge", NumTerm(150), NumTerm(151))
+	if got := len(db.Query("path", NumTerm(0), Var("Y"))); got != 151 {
+		t.Errorf("after a new edge, found %d paths from 0, want 151", got)
+	}
+}
+
+func TestBottomUpMatchesTopDown(t *testing.T) {
+	db := NewDatalogDB()
+	for _, p := range [][2]string{{"tom", "bob"}, {"bob", "ann"}, {"bob", "pat"}, {"pat", "jim"}} {
+		db.Assert("parent", Atom(p[0]), Atom(p[1]))
+	}
+	db.Assert("person", Atom("sue"))
+	for _, p := range []string{"tom", "bob", "ann", "pat", "jim"} {
+		db.Assert("person", Atom(p))
+	}
+	db.AddRule("ancestor-base",
+		Fact{Predicate: "ancestor", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "parent", Args: []Term{Var("X"), Var("Y")}},
+	)
+	db.AddRule("ancestor-step",
+		Fact{Predicate: "ancestor", Args: []Term{Var("X"), Var("Z")}},
+		Goal{Predicate: "parent", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "ancestor", Args: []Term{Var("Y"), Var("Z")}},
+	)
+	// A second stratum: people with no ancestor
+	db.AddRule("not-descendant",
+		Fact{Predicate: "not-descendant", Args: []Term{Var("X")}},
+		Goal{Predicate: "person", Args: []Term{Var("X")}},
+		Goal{Predicate: "ancestor", Args: []Term{Var("Any"), Var("X")}, Negated: true},
+	)
+
+	for _, q := range []Goal{
+		{Predicate: "ancestor", Args: []Term{Atom("tom"), Var("Who")}},
+		{Predicate: "not-descendant", Args: []Term{Var("Who")}},
+	} {
+		want := answers(db.QueryGoals(q), "Who")
+		results, err := db.QueryGoalsBottomUp(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := answers(results, "Who")
+		if len(strings.Fields(got)) != len(strings.Fields(want)) {
+			t.Errorf("%s: bottom-up %s, top-down %s", q.String(), got, want)
+		}
+		for _, w := range strings.Fields(want) {
+			if !strings.Contains(" "+got, " "+w+" ") {
+				t.Errorf("%s: bottom-up %s is missing %s", q.String(), got, w)
+			}
+		}
+	}
+}
+
+func TestBottomUpRestrictions(t *testing.T) {
+	unsafe := NewDatalogDB()
+	unsafe.AddRule("anything",
+		Fact{Predicate: "likes", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "person", Args: []Term{Var("X")}},
+	)
+	if err := unsafe.CheckBottomUp(); err == nil || !strings.Contains(err.Error(), "?Y") {
+		t.Errorf("unbound head variable: got %v", err)
+	}
+
+	paradox := NewDatalogDB()
+	paradox.AddRule("barber",
+		Fact{Predicate: "shaves", Args: []Term{Var("X")}},
+		Goal{Predicate: "man", Args: []Term{Var("X")}},
+		Goal{Predicate: "shaves", Args: []Term{Var("X")}, Negated: true},
+	)
+	if _, err := paradox.QueryGoalsBottomUp(Goal{Predicate: "shaves", Args: []Term{Var("X")}}); err == nil {
+		t.Error("a predicate depending on its own negation was evaluated")
+	}
+}
+
+func TestDatalogModeBuiltin(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(assert! 'edge 'a 'b)
+		(assert! 'edge 'b 'c)
+		(rule 'p1 '(path ?x ?y) '(edge ?x ?y))
+		(rule 'p2 '(path ?x ?z) '(edge ?x ?y) '(path ?y ?z))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(length (query 'path 'a '?y :mode 'bottom-up))", "2"},
+		{"(length (query-all '(path a ?y) '(edge ?y ?z) :mode 'bottom-up))", "1"},
+		{"(datalog-mode! 'bottom-up 'path)", "ok"},
+		{"(length (query 'path '?x '?y))", "3"},
+		{"(datalog-mode! 'top-down)", "ok"},
+		{"(query 'path 'a '?y :mode 'sideways)", "nil"},
+		{"(datalog-mode! 'sideways)", "nil"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+
+	runCode(ev, "(rule 'bad '(likes ?x ?y) '(edge ?x ?z))")
+	if got := evalLast(ev, "(datalog-mode! 'bottom-up)").String(); got != "nil" {
+		t.Errorf("datalog-mode! with an unsafe rule = %s, want nil", got)
+	}
+	if lastWarning(ev, "datalog-mode!: can't evaluate bottom-up") == nil {
+		t.Errorf("expected a warning about the unsafe rule, got %v", ev.Warnings)
+	}
+}
+
+func BenchmarkBottomUpChain(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		db := chainDB(100)
+		db.QueryGoalsBottomUp(Goal{Predicate: "path", Args: []Term{NumTerm(0), NumTerm(10)}})
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ============================================================================
+// Value Serialization
+// ============================================================================
+//
+// WriteValue prints a Value in a canonical S-expression form that ReadValue
+// reads back to an equal Value of the same type. Unlike String(), integers
+// and floats stay distinct, tagged values, maps, stacks and queues keep
+// their type, and symbols that would read back as something else are
+// escaped. Output is canonical: one space between items and map entries in
+// key order, so equal values always serialize to the same text.
+//
+//   42  4.0  "text"  sym  nil  true  (a b)
+//   #\a  #\space  #\x7f       chars, named or by hex code point when unprintable
+//   #(float "+Inf")           non-finite floats
+//   #(symbol "two words")     symbols that aren't plain tokens
+//   #(tagged point (1 2))
+//   #(map ("k" 1) (k 2))      (key value) pairs
+//   #(set 1 a "b")            items in key order
+//   #(stack 4 "name" (a b))   capacity, resource name, items bottom first
+//   #(queue 4 "" (a b))       capacity, resource name, items front first
+//   #(queue 4 "" (a b) 64)    ... and byte capacity, when the queue has one
+//
+// Functions, builtins and other runtime objects have no serialized form.
+//
+// The reader also takes the source shorthands 'x, `x, ,x and ,@x and
+// skips ; comments, so read turns program text into the lists eval runs.
+
+// WriteValue serializes v, failing on values with no serialized form
+func WriteValue(v Value) (string, error) {
+	if hasCycle(v) {
+		return "", fmt.Errorf("%s contains itself and has no serialized form", v.String())
+	}
+	var sb strings.Builder
+	if err := writeValue(&sb, v); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func writeValue(sb *strings.Builder, v Value) error {
+	switch v.Type {
+	case TypeNil:
+		sb.WriteString("nil")
+	case TypeBool:
+		if v.Bool {
+			sb.WriteString("true")
+		} else {
+			sb.WriteString("false")
+		}
+	case TypeNumber:
+		writeNumber(sb, v)
+	case TypeString:
+		sb.WriteString(strconv.Quote(v.Str))
+	case TypeChar:
+		sb.WriteString(charLiteral(rune(v.Int)))
+	case TypeSymbol:
+		if plainSymbol(v.Symbol) {
+			sb.WriteString(v.Symbol)
+		} else {
+			sb.WriteString("#(symbol " + strconv.Quote(v.Symbol) + ")")
+		}
+	case TypeList:
+		sb.WriteByte('(')
+		if err := writeItems(sb, v.List); err != nil {
+			return err
+		}
+		sb.WriteByte(')')
+	case TypeTagged:
+		sb.WriteString("#(tagged ")
+		if err := writeValue(sb, Sym(v.Tagged.Tag)); err != nil {
+			return err
+		}
+		sb.WriteByte(' ')
+		if err := writeValue(sb, v.Tagged.Value); err != nil {
+			return err
+		}
+		sb.WriteByte(')')
+	case TypeMap:
+		sb.WriteString("#(map")
+		for _, k := range v.Map.SortedKeys() {
+			sb.WriteString(" (")
+			if err := writeItems(sb, []Value{v.Map.Keys[k], v.Map.Data[k]}); err != nil {
+				return err
+			}
+			sb.WriteByte(')')
+		}
+		sb.WriteByte(')')
+	case TypeSet:
+		sb.WriteString("#(set")
+		for _, item := range v.Set.Sorted() {
+			sb.WriteByte(' ')
+			if err := writeValue(sb, item); err != nil {
+				return err
+			}
+		}
+		sb.WriteByte(')')
+	case TypeStack:
+		return writeContainer(sb, "stack", v.Stack.Capacity, v.Stack.Name, v.Stack.Data, 0)
+	case TypeQueue:
+		return writeContainer(sb, "queue", v.Queue.Capacity, v.Queue.Name, v.Queue.Data, v.Queue.ByteCapacity)
+	default:
+		return fmt.Errorf("%s has no serialized form", v.String())
+	}
+	return nil
+}
+
+func writeItems(sb *strings.Builder, items []Value) error {
+	for i, item := range items {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		if err := writeValue(sb, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeContainer(sb *strings.Builder, kind string, capacity int, name string, data []Value, byteCapacity int) error {
+	fmt.Fprintf(sb, "#(%s %d %s (", kind, capacity, strconv.Quote(name))
+	if err := writeItems(sb, data); err != nil {
+		return err
+	}
+	sb.WriteByte(')')
+	if byteCapacity > 0 {
+		fmt.Fprintf(sb, " %d", byteCapacity)
+	}
+	sb.WriteByte(')')
+	return nil
+}
+
+// writeNumber writes integers bare and floats with a '.' or exponent
+func writeNumber(sb *strings.Builder, v Value) {
+	if v.IsInt {
+		sb.WriteString(strconv.FormatInt(v.Int, 10))
+		return
+	}
+	if math.IsInf(v.Number, 0) || math.IsNaN(v.Number) {
+		sb.WriteString("#(float " + strconv.Quote(strconv.FormatFloat(v.Number, 'g', -1, 64)) + ")")
+		return
+	}
+	s := strconv.FormatFloat(v.Number, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	sb.WriteString(s)
+}
+
+// plainSymbol reports whether a symbol reads back as itself when written bare
+func plainSymbol(s string) bool {
+	if s == "" || s == "nil" || s == "true" || s == "false" {
+		return false
+	}
+	if strings.ContainsAny(s, " \t\r\n()\"#;'`,") {
+		return false
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err != nil
+}
+
+// ReadValue reads one serialized value; anything after it is an error
+func ReadValue(s string) (Value, error) {
+	r := &valueReader{src: s}
+	v, err := r.read()
+	if err != nil {
+		return Nil(), err
+	}
+	r.skipSpace()
+	if r.pos < len(r.src) {
+		return Nil(), r.errorf("unexpected %q after value", r.src[r.pos:])
+	}
+	return v, nil
+}
+
+type valueReader struct {
+	src string
+	pos int
+}
+
+func (r *valueReader) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("offset %d: %s", r.pos, fmt.Sprintf(format, args...))
+}
+
+func (r *valueReader) skipSpace() {
+	for r.pos < len(r.src) {
+		switch {
+		case strings.IndexByte(" \t\r\n", r.src[r.pos]) >= 0:
+			r.pos++
+		case r.src[r.pos] == ';':
+			for r.pos < len(r.src) && r.src[r.pos] != '\n' {
+				r.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// readShorthands are the source prefixes and the forms they stand for
+var readShorthands = []struct{ prefix, form string }{
+	{",@", "unquote-splicing"},
+	{",", "unquote"},
+	{"'", "quote"},
+	{"`", "quasiquote"},
+}
+
+func (r *valueReader) read() (Value, error) {
+	r.skipSpace()
+	if r.pos >= len(r.src) {
+		return Nil(), r.errorf("unexpected end of input")
+	}
+	switch r.src[r.pos] {
+	case '(':
+		r.pos++
+		items, err := r.readItems()
+		if err != nil {
+			return Nil(), err
+		}
+		return Lst(items...), nil
+	case ')':
+		return Nil(), r.errorf("unexpected ')'")
+	case '"':
+		return r.readString()
+	case '\'', '`', ',':
+		for _, sh := range readShorthands {
+			if strings.HasPrefix(r.src[r.pos:], sh.prefix) {
+				r.pos += len(sh.prefix)
+				v, err := r.read()
+				if err != nil {
+					return Nil(), err
+				}
+				return Lst(InternedSym(sh.form), v), nil
+			}
+		}
+	case '#':
+		if strings.HasPrefix(r.src[r.pos:], `#\`) {
+			return r.readChar()
+		}
+		if !strings.HasPrefix(r.src[r.pos:], "#(") {
+			return Nil(), r.errorf("expected '#(' or '#\\'")
+		}
+		start := r.pos
+		r.pos += 2
+		items, err := r.readItems()
+		if err != nil {
+			return Nil(), err
+		}
+		v, err := readSpecial(items)
+		if err != nil {
+			return Nil(), fmt.Errorf("offset %d: %v", start, err)
+		}
+		return v, nil
+	}
+	return r.readToken(), nil
+}
+
+// readItems reads values up to and including the closing ')'
+func (r *valueReader) readItems() ([]Value, error) {
+	items := []Value{}
+	for {
+		r.skipSpace()
+		if r.pos >= len(r.src) {
+			return nil, r.errorf("unclosed '('")
+		}
+		if r.src[r.pos] == ')' {
+			r.pos++
+			return items, nil
+		}
+		v, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+}
+
+func (r *valueReader) readString() (Value, error) {
+	start := r.pos
+	r.pos++
+	for r.pos < len(r.src) {
+		switch r.src[r.pos] {
+		case '\\':
+			r.pos += 2
+			continue
+		case '"':
+			r.pos++
+			s, err := strconv.Unquote(r.src[start:r.pos])
+			if err != nil {
+				return Nil(), fmt.Errorf("offset %d: bad string: %v", start, err)
+			}
+			return Str(s), nil
+		}
+		r.pos++
+	}
+	return Nil(), fmt.Errorf("offset %d: unterminated string", start)
+}
+
+// readChar reads #\ and the rune or name after it
+func (r *valueReader) readChar() (Value, error) {
+	start := r.pos
+	r.pos += 2
+	if r.pos >= len(r.src) {
+		return Nil(), r.errorf("expected a character after '#\\'")
+	}
+	_, size := utf8.DecodeRuneInString(r.src[r.pos:])
+	r.pos += size
+	for r.pos < len(r.src) && strings.IndexByte(" \t\r\n()\"", r.src[r.pos]) < 0 {
+		r.pos++
+	}
+	c, ok := parseChar(r.src[start+2 : r.pos])
+	if !ok {
+		return Nil(), fmt.Errorf("offset %d: unknown character %s", start, r.src[start:r.pos])
+	}
+	return Char(c), nil
+}
+
+func (r *valueReader) readToken() Value {
+	start := r.pos
+	for r.pos < len(r.src) && strings.IndexByte(" \t\r\n()\"", r.src[r.pos]) < 0 {
+		r.pos++
+	}
+	tok := r.src[start:r.pos]
+	switch tok {
+	case "nil":
+		return Nil()
+	case "true":
+		return Bool(true)
+	case "false":
+		return Bool(false)
+	}
+	if n, err := strconv.ParseInt(tok, 10, 64); err == nil {
+		return Int(n)
+	}
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return Num(f)
+	}
+	return Sym(tok)
+}
+
+// readSpecial builds the value for a #( ... ) form
+func readSpecial(items []Value) (Value, error) {
+	if len(items) == 0 || items[0].Type != TypeSymbol {
+		return Nil(), fmt.Errorf("expected a type after '#('")
+	}
+	kind, args := items[0].Symbol, items[1:]
+	switch kind {
+	case "float":
+		if len(args) == 1 && args[0].Type == TypeString {
+			if f, err := strconv.ParseFloat(args[0].Str, 64); err == nil {
+				return Num(f), nil
+			}
+		}
+	case "symbol":
+		if len(args) == 1 && args[0].Type == TypeString {
+			return Sym(args[0].Str), nil
+		}
+	case "tagged":
+		if len(args) == 2 && args[0].Type == TypeSymbol {
+			return Value{Type: TypeTagged, Tagged: &TaggedValue{Tag: args[0].Symbol, Value: args[1]}}, nil
+		}
+	case "map":
+		m := NewHashMap()
+		for _, entry := range args {
+			if entry.Type != TypeList || len(entry.List) != 2 {
+				return Nil(), fmt.Errorf("map entries are (key value) pairs")
+			}
+			k := entry.List[0].String()
+			m.Keys[k] = entry.List[0]
+			m.Data[k] = entry.List[1]
+		}
+		return Value{Type: TypeMap, Map: m}, nil
+	case "set":
+		set := NewHashSet()
+		for _, item := range args {
+			set.Add(item)
+		}
+		return Value{Type: TypeSet, Set: set}, nil
+	case "stack", "queue":
+		if len(args) == 3 && args[0].IsInt && args[1].Type == TypeString && args[2].Type == TypeList {
+			capacity, data := int(args[0].Int), args[2].List
+			if len(data) > capacity {
+				return Nil(), fmt.Errorf("%s holds %d items but has capacity %d", kind, len(data), capacity)
+			}
+			if kind == "stack" {
+				s := NewStack(capacity)
+				s.Name = args[1].Str
+				s.Data = append(s.Data, data...)
+				return Value{Type: TypeStack, Stack: s}, nil
+			}
+			q := NewQueue(capacity)
+			q.Name = args[1].Str
+			q.Data = append(q.Data, data...)
+			return Value{Type: TypeQueue, Queue: q}, nil
+		}
+		if kind == "queue" && len(args) == 4 && args[3].IsInt {
+			v, err := readSpecial(items[:4])
+			if err == nil {
+				v.Queue.ByteCapacity = int(args[3].Int)
+			}
+			return v, err
+		}
+	default:
+		return Nil(), fmt.Errorf("unknown type #(%s ...)", kind)
+	}
+	return Nil(), fmt.Errorf("malformed #(%s ...)", kind)
+}
+
+// (write-value v) - canonical serialized string, nil if v can't be serialized
+func builtinWriteValue(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 {
+		return Nil()
+	}
+	s, err := WriteValue(args[0])
+	if err != nil {
+		ev.warn("", "write-value: %v", err)
+		return Nil()
+	}
+	return Str(s)
+}
+
+// (repr v) - a string read turns back into v. Values with no serialized
+// form, such as functions, get their printed form instead.
+func builtinRepr(ev *Evaluator, args []Value, env *Env) Value {
+	if s, err := WriteValue(args[0]); err == nil {
+		return Str(s)
+	}
+	return Str(args[0].String())
+}
+
+// (read str) - the value str's text stands for: whatever repr or
+// write-value wrote, or a datum of source text, unevaluated
+func builtinRead(ev *Evaluator, args []Value, env *Env) Value {
+	v, err := ReadValue(args[0].Str)
+	if err != nil {
+		ev.warnTrace("", "read: %v", err)
+		return Nil()
+	}
+	return v
+}
+
+// (read-value str) - the value a write-value string stands for
+func builtinReadValue(ev *Evaluator, args []Value, env *Env) Value {
+	if len(args) == 0 || args[0].Type != TypeString {
+		ev.warn("", "read-value: expected a string")
+		return Nil()
+	}
+	v, err := ReadValue(args[0].Str)
+	if err != nil {
+		ev.warn("", "read-value: %v", err)
+		return Nil()
+	}
+	return v
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// ============================================================================
+// Value Serialization Tests
+// ============================================================================
+
+func TestWriteValueRoundTrip(t *testing.T) {
+	ev := NewEvaluator(1000)
+
+	tests := []struct {
+		code    string
+		written string
+	}{
+		{`42`, `42`},
+		{`4.0`, `4.0`},
+		{`2.5`, `2.5`},
+		{`1e21`, `1e+21`},
+		{`"say \"hi\"\n"`, `"say \"hi\"\n"`},
+		{`'sym`, `sym`},
+		{`(string->symbol "two words")`, `#(symbol "two words")`},
+		{`(string->symbol "42")`, `#(symbol "42")`},
+		{`(string->symbol "nil")`, `#(symbol "nil")`},
+		{`nil`, `nil`},
+		{`true`, `true`},
+		{`(list 1 2.0 (list 'a "b") '())`, `(1 2.0 (a "b") ())`},
+		{`(tag 'point (list 1 2))`, `#(tagged point (1 2))`},
+		{`(let m (make-map) (begin (map-set! m 'b 2) (map-set! m "a" (list 1)) m))`, `#(map ("a" (1)) (b 2))`},
+		{`(let s (make-stack 4) (begin (push! s 'x) (push! s 2) s))`, `#(stack 4 "" (x 2))`},
+		{`(let q (make-queue 3 'orders) (begin (send! q "o1") q))`, `#(queue 3 "orders" ("o1"))`},
+		{`(let q (make-queue 3 'orders :bytes 64) (begin (send! q "o1") q))`, `#(queue 3 "orders" ("o1") 64)`},
+		{`(make-set 'b 2 "a")`, `#(set "a" 2 b)`},
+		{`(list #\a #\space #\( (number->char 127))`, `(#\a #\space #\( #\x7f)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			v := evalLast(ev, tt.code)
+			written, err := WriteValue(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written != tt.written {
+				t.Errorf("WriteValue = %s, want %s", written, tt.written)
+			}
+			back, err := ReadValue(written)
+			if err != nil {
+				t.Fatalf("ReadValue(%s): %v", written, err)
+			}
+			again, _ := WriteValue(back)
+			if back.Type != v.Type || back.IsInt != v.IsInt || again != written {
+				t.Errorf("round trip changed %s into %s", written, again)
+			}
+		})
+	}
+
+	inf, _ := WriteValue(Num(math.Inf(-1)))
+	if back, err := ReadValue(inf); err != nil || !math.IsInf(back.Number, -1) {
+		t.Errorf("-Inf did not round trip through %s: %v", inf, err)
+	}
+}
+
+func TestWriteValueBuiltins(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(write-value (list 1 1.5 'a))`, `"(1 1.5 a)"`},
+		{`(read-value "(1 1.0 #(tagged ok \"x\"))")`, `(1 1 #ok{"x"})`},
+		{`(integer? (nth (read-value "(1 1.0)") 1))`, "false"},
+		{`(equals (read-value (write-value '(a (b c) "d"))) '(a (b c) "d"))`, "true"},
+		{`(write-value car)`, "nil"},
+		{`(read-value "(a b")`, "nil"},
+		{`(read-value "a b")`, "nil"},
+		{`(read-value "#(stack 1 \"\" (a b))")`, "nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReadAndRepr(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{`(repr '(a "b" 2.0))`, `"(a \"b\" 2.0)"`},
+		{`(repr (tag 'ok 1))`, `"#(tagged ok 1)"`},
+		{`(begin (define s (make-stack 4)) (push-now! s 'a) (repr s))`, `"#(stack 4 \"\" (a))"`},
+		{`(begin (define s (make-stack 4)) (push-now! s 'a) (define back (read (repr s))) (list (pop-now! back) (stack-empty? s)))`, "(a false)"},
+		{`(begin (define m (make-map)) (map-set! m 'k (make-set)) (equal? (read (repr m)) m))`, "true"},
+		{`(equal? (read (repr (tag 'point (list 1 2.5)))) (tag 'point (list 1 2.5)))`, "true"},
+		{`(repr car)`, `"<builtin>"`},
+		{`(read "'x")`, "(quote x)"},
+		{"(read \"`(a ,b ,@c)\")", "(quasiquote (a (unquote b) (unquote-splicing c)))"},
+		{`(eval (read "(let ((x 1) (y 2)) (+ x y)) ; sum"))`, "3"},
+		{`(read "#\\a")`, `#\a`},
+		{`(read "(a b")`, "nil"},
+		{`(read 'x)`, `#error{(read "a string for argument 1" x)}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+			}
+		})
+	}
+
+	// Symbols the reader would take apart are escaped
+	for _, name := range []string{"a,b", "`x", "'y"} {
+		s, err := WriteValue(Sym(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back, err := ReadValue(s); err != nil || back.Type != TypeSymbol || back.Symbol != name {
+			t.Errorf("%q wrote as %s and read back as %s (%v)", name, s, back.String(), err)
+		}
+	}
+}
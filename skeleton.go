@@ -0,0 +1,368 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// Spec Skeletons
+// ============================================================================
+//
+// `philosopher new` writes a runnable starting spec without calling an LLM:
+// the actors, contracts as Datalog rules, a scenario search, the run, and
+// default property checks, plus a markdown document whose {{tool}}
+// placeholders render once the spec has run.
+
+// specTemplate generates a spec and its document for a list of actor names
+type specTemplate struct {
+	Summary   string
+	Usage     string // What the actor names mean, in order
+	MinActors int
+	Lisp      func(actors []string) string
+	Doc       func(actors []string) string
+}
+
+var specTemplates = map[string]specTemplate{
+	"request-reply": {
+		Summary:   "clients send requests to a server and wait for each reply",
+		Usage:     "clients..., server",
+		MinActors: 2,
+		Lisp:      requestReplyLisp,
+		Doc:       requestReplyDoc,
+	},
+	"pipeline": {
+		Summary:   "a source feeds items through stages to a sink",
+		Usage:     "source, stages..., sink",
+		MinActors: 2,
+		Lisp:      pipelineLisp,
+		Doc:       pipelineDoc,
+	},
+}
+
+// skeletonRequests is how many requests or items each skeleton sends
+const skeletonRequests = 3
+
+func runNew(args []string) {
+	fs := flag.NewFlagSet("new", flag.ExitOnError)
+	template := fs.String("template", "request-reply", "spec template")
+	actorList := fs.String("actors", "", "comma-separated actor names")
+	name := fs.String("name", "", "file name without extension (default: the template name)")
+	dir := fs.String("dir", ".", "directory to write the spec and document to")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: philosopher new --template <name> --actors a,b,... [--name file] [--dir dir]")
+		fmt.Fprintln(os.Stderr, "\nTemplates:")
+		for _, t := range templateNames() {
+			fmt.Fprintf(os.Stderr, "  %-14s %s (actors: %s)\n", t, specTemplates[t].Summary, specTemplates[t].Usage)
+		}
+		fmt.Fprintln(os.Stderr, "\nFlags:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	lisp, doc, err := generateSkeleton(*template, splitActors(*actorList))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "philosopher new: %v\n", err)
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *name == "" {
+		*name = *template
+	}
+	lispPath := filepath.Join(*dir, *name+".lisp")
+	docPath := filepath.Join(*dir, *name+".md")
+	for _, p := range []string{lispPath, docPath} {
+		if _, err := os.Stat(p); err == nil {
+			fmt.Fprintf(os.Stderr, "philosopher new: %s already exists\n", p)
+			os.Exit(1)
+		}
+	}
+	if err := os.WriteFile(lispPath, []byte(lisp), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "philosopher new: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(docPath, []byte(doc), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "philosopher new: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s and %s\n", lispPath, docPath)
+	fmt.Printf("Run it with: philosopher %s\n", lispPath)
+}
+
+// generateSkeleton returns the spec and document for a template
+func generateSkeleton(template string, actors []string) (string, string, error) {
+	t, ok := specTemplates[template]
+	if !ok {
+		return "", "", fmt.Errorf("unknown template %q (have %s)", template, strings.Join(templateNames(), ", "))
+	}
+	if len(actors) < t.MinActors {
+		return "", "", fmt.Errorf("template %s needs at least %d actors: %s", template, t.MinActors, t.Usage)
+	}
+	seen := make(map[string]bool)
+	for _, a := range actors {
+		if !isSkeletonName(a) {
+			return "", "", fmt.Errorf("actor name %q must be letters, digits, - or _", a)
+		}
+		if seen[a] {
+			return "", "", fmt.Errorf("actor %s listed twice", a)
+		}
+		seen[a] = true
+	}
+	return t.Lisp(actors), t.Doc(actors), nil
+}
+
+func templateNames() []string {
+	names := make([]string, 0, len(specTemplates))
+	for n := range specTemplates {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func splitActors(s string) []string {
+	var actors []string
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			actors = append(actors, a)
+		}
+	}
+	return actors
+}
+
+func isSkeletonName(s string) bool {
+	for i, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == '-':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+func skeletonSection(sb *strings.Builder, title string) {
+	sb.WriteString("\n; ----------------------------------------------------------------------------\n")
+	sb.WriteString("; " + title + "\n")
+	sb.WriteString("; ----------------------------------------------------------------------------\n\n")
+}
+
+func skeletonHeader(sb *strings.Builder, template string, actors []string) {
+	sb.WriteString("; ============================================================================\n")
+	fmt.Fprintf(sb, "; %s: %s\n", template, strings.Join(actors, ", "))
+	sb.WriteString("; Generated by `philosopher new`. Replace the actor bodies with your\n")
+	sb.WriteString("; protocol, keep the contracts and properties in step, then run this file.\n")
+	sb.WriteString("; ============================================================================\n")
+}
+
+// skeletonCheck is a default property: Op is always?, eventually? or never?
+type skeletonCheck struct {
+	Name, Op, Goal string
+}
+
+// skeletonChecks prints each property with its result
+func skeletonChecks(sb *strings.Builder, checks []skeletonCheck) {
+	for _, c := range checks {
+		fmt.Fprintf(sb, "(print \"%s:\" (%s '%s))\n", c.Name, c.Op, c.Goal)
+	}
+}
+
+// skeletonPropertyTable is a {{properties}} placeholder for the same checks
+func skeletonPropertyTable(checks []skeletonCheck) string {
+	parts := make([]string, len(checks))
+	for i, c := range checks {
+		parts[i] = fmt.Sprintf("%s: %s '%s", c.Name, c.Op, c.Goal)
+	}
+	return fmt.Sprintf("{{properties checks=\"%s\"}}\n", strings.Join(parts, "; "))
+}
+
+// ============================================================================
+// request-reply
+// ============================================================================
+
+var requestReplyChecks = []skeletonCheck{
+	{"Every request is answered", "never?", "(unanswered ?c ?id)"},
+	{"No unsolicited replies", "never?", "(unsolicited ?c ?id)"},
+	{"Some request is answered", "eventually?", "(answered ?c ?id)"},
+}
+
+func requestReplyLisp(actors []string) string {
+	clients, server := actors[:len(actors)-1], actors[len(actors)-1]
+	var sb strings.Builder
+	skeletonHeader(&sb, "request-reply", actors)
+
+	skeletonSection(&sb, "Actors")
+	fmt.Fprintf(&sb, `; Client: send request n, wait for its reply, repeat up to limit
+(define (send-request n limit)
+  (if (> n limit)
+      'done
+      (begin
+        (send-to! '%s (list 'request (self) n))
+        (list 'become (list 'await-reply n limit)))))
+
+(define (await-reply n limit)
+  (let reply (receive!)
+    (list 'become (list 'send-request (+ n 1) limit))))
+
+; Server: answer (request from id) with (reply id), stop after remaining
+(define (serve remaining)
+  (if (= remaining 0)
+      'done
+      (let req (receive!)
+        (list 'become (list 'answer (list 'quote (nth req 1)) (nth req 2) remaining)))))
+
+(define (answer client id remaining)
+  (send-to! client (list 'reply id))
+  (list 'become (list 'serve (- remaining 1))))
+
+`, server)
+	fmt.Fprintf(&sb, "(spawn-actor '%s 8 '(serve %d))\n", server, skeletonRequests*len(clients))
+	for _, c := range clients {
+		fmt.Fprintf(&sb, "(spawn-actor '%s 4 '(send-request 1 %d))\n", c, skeletonRequests)
+	}
+
+	skeletonSection(&sb, "Contracts")
+	fmt.Fprintf(&sb, `; Every request gets the matching reply
+(rule 'asked '(asked ?c ?id) '(sent ?c %[1]s (request ?c ?id)))
+(rule 'answered '(answered ?c ?id) '(sent %[1]s ?c (reply ?id)))
+(rule 'unanswered '(unanswered ?c ?id) '(asked ?c ?id) '(not (answered ?c ?id)))
+
+; The server only replies to requests it was sent
+(rule 'unsolicited '(unsolicited ?c ?id) '(answered ?c ?id) '(not (asked ?c ?id)))
+`, server)
+
+	skeletonSection(&sb, "Scenario")
+	fmt.Fprintf(&sb, `; Search (on a copy of the world) for a schedule that answers %[1]s's
+; first request. Replay it step by step with (run-scenario first-reply).
+(define first-reply
+  (find-trace-satisfying '(eventually (answered %[1]s 1)) :steps 50 :seed 1))
+(print "Scenario:" first-reply)
+`, clients[0])
+
+	skeletonSection(&sb, "Run")
+	sb.WriteString("(print (run-scheduler 1000))\n")
+
+	skeletonSection(&sb, "Properties")
+	skeletonChecks(&sb, requestReplyChecks)
+	return sb.String()
+}
+
+func requestReplyDoc(actors []string) string {
+	clients, server := actors[:len(actors)-1], actors[len(actors)-1]
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Request-Reply: %s\n\n", strings.Join(actors, ", "))
+	sb.WriteString("_Describe what the system is for and what each request means._\n\n")
+	sb.WriteString("## Actors\n\n| Actor | Role |\n|-------|------|\n")
+	for _, c := range clients {
+		fmt.Fprintf(&sb, "| %s | Sends %d requests to %s, waiting for each reply |\n", c, skeletonRequests, server)
+	}
+	fmt.Fprintf(&sb, "| %s | Answers each `(request client id)` with `(reply id)` |\n\n", server)
+	sb.WriteString("## Contracts\n\n")
+	sb.WriteString("- Every request gets the matching reply (`unanswered` is empty).\n")
+	fmt.Fprintf(&sb, "- %s only replies to requests it was sent (`unsolicited` is empty).\n\n", server)
+	sb.WriteString("## Message Flow\n\n")
+	fmt.Fprintf(&sb, "{{sequence_diagram actors=\"%s\"}}\n\n", strings.Join(actors, ","))
+	sb.WriteString("## Properties\n\n")
+	sb.WriteString(skeletonPropertyTable(requestReplyChecks))
+	sb.WriteString("\n## Facts\n\n{{facts_table}}\n")
+	return sb.String()
+}
+
+// ============================================================================
+// pipeline
+// ============================================================================
+
+var pipelineChecks = []skeletonCheck{
+	{"No item is lost", "never?", "(lost ?id)"},
+	{"Items reach the sink", "eventually?", "(delivered ?id)"},
+}
+
+func pipelineLisp(actors []string) string {
+	source, sink := actors[0], actors[len(actors)-1]
+	stages := actors[1 : len(actors)-1]
+	var sb strings.Builder
+	skeletonHeader(&sb, "pipeline", actors)
+
+	skeletonSection(&sb, "Actors")
+	sb.WriteString(`; Source: emit items 1..limit downstream
+(define (emit next n limit)
+  (if (> n limit)
+      'done
+      (begin
+        (send-to! next (list 'item n))
+        (list 'become (list 'emit (list 'quote next) (+ n 1) limit)))))
+
+; Stage: receive an item, then pass it on
+(define (stage next remaining)
+  (if (= remaining 0)
+      'done
+      (let item (receive!)
+        (list 'become (list 'pass (list 'quote next) (list 'quote item) remaining)))))
+
+(define (pass next item remaining)
+  (send-to! next item)
+  (list 'become (list 'stage (list 'quote next) (- remaining 1))))
+
+; Sink: record each item as delivered
+(define (collect remaining)
+  (if (= remaining 0)
+      'done
+      (let item (receive!)
+        (begin
+          (assert! 'delivered (nth item 1))
+          (list 'become (list 'collect (- remaining 1)))))))
+
+`)
+	fmt.Fprintf(&sb, "(spawn-actor '%s 4 '(emit '%s 1 %d))\n", source, actors[1], skeletonRequests)
+	for i, s := range stages {
+		fmt.Fprintf(&sb, "(spawn-actor '%s 4 '(stage '%s %d))\n", s, actors[i+2], skeletonRequests)
+	}
+	fmt.Fprintf(&sb, "(spawn-actor '%s 4 '(collect %d))\n", sink, skeletonRequests)
+
+	skeletonSection(&sb, "Contracts")
+	fmt.Fprintf(&sb, `; Every item the source emits reaches the sink
+(rule 'emitted '(emitted ?id) '(sent %s ?next (item ?id)))
+(rule 'lost '(lost ?id) '(emitted ?id) '(not (delivered ?id)))
+`, source)
+
+	skeletonSection(&sb, "Scenario")
+	fmt.Fprintf(&sb, `; Search (on a copy of the world) for a schedule that delivers the
+; first item. Replay it step by step with (run-scenario first-delivery).
+(define first-delivery
+  (find-trace-satisfying '(eventually (delivered 1)) :steps 50 :seed 1))
+(print "Scenario:" first-delivery)
+`)
+
+	skeletonSection(&sb, "Run")
+	sb.WriteString("(print (run-scheduler 1000))\n")
+
+	skeletonSection(&sb, "Properties")
+	skeletonChecks(&sb, pipelineChecks)
+	return sb.String()
+}
+
+func pipelineDoc(actors []string) string {
+	source, sink := actors[0], actors[len(actors)-1]
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Pipeline: %s\n\n", strings.Join(actors, " → "))
+	sb.WriteString("_Describe what flows through the pipeline and what each stage does to it._\n\n")
+	sb.WriteString("## Actors\n\n| Actor | Role |\n|-------|------|\n")
+	fmt.Fprintf(&sb, "| %s | Emits items 1..%d |\n", source, skeletonRequests)
+	for _, s := range actors[1 : len(actors)-1] {
+		fmt.Fprintf(&sb, "| %s | Passes each item on |\n", s)
+	}
+	fmt.Fprintf(&sb, "| %s | Records each item as `(delivered id)` |\n\n", sink)
+	sb.WriteString("## Contracts\n\n")
+	fmt.Fprintf(&sb, "- Every item %s emits is delivered (`lost` is empty).\n\n", source)
+	sb.WriteString("## Message Flow\n\n")
+	fmt.Fprintf(&sb, "{{sequence_diagram actors=\"%s\"}}\n\n", strings.Join(actors, ","))
+	sb.WriteString("## Properties\n\n")
+	sb.WriteString(skeletonPropertyTable(pipelineChecks))
+	sb.WriteString("\n## Facts\n\n{{facts_table}}\n")
+	return sb.String()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Spec Skeleton Tests
+// ============================================================================
+
+func TestSkeletonsRunAndPass(t *testing.T) {
+	cases := []struct {
+		template string
+		actors   []string
+	}{
+		{"request-reply", []string{"client", "server"}},
+		{"request-reply", []string{"alice", "bob", "db"}},
+		{"pipeline", []string{"source", "sink"}},
+		{"pipeline", []string{"source", "parse", "enrich", "sink"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.template+"/"+strings.Join(tc.actors, ","), func(t *testing.T) {
+			lisp, doc, err := generateSkeleton(tc.template, tc.actors)
+			if err != nil {
+				t.Fatal(err)
+			}
+			forms, errs := NewParser(lisp).Parse()
+			if len(errs) > 0 {
+				t.Fatalf("generated spec has parse errors: %v\n%s", errs, lisp)
+			}
+			ev := NewEvaluator(64)
+			ev.Quiet = true
+			for _, f := range forms {
+				ev.Eval(f, ev.GlobalEnv)
+			}
+			if len(ev.Warnings) > 0 {
+				t.Errorf("generated spec warned: %v", ev.Warnings)
+			}
+			for name, a := range ev.Scheduler.Actors {
+				if a.State != ActorDone {
+					t.Errorf("actor %s should finish, is blocked on %q", name, a.BlockedOn)
+				}
+			}
+
+			rendered := NewToolRegistry(ev).Process(doc)
+			if strings.Contains(rendered, "{{") || strings.Contains(rendered, "<!--") {
+				t.Errorf("placeholders left unrendered:\n%s", rendered)
+			}
+			if strings.Contains(rendered, "❌") || strings.Contains(rendered, "❓") {
+				t.Errorf("default properties should hold:\n%s", rendered)
+			}
+		})
+	}
+}
+
+func TestSkeletonErrors(t *testing.T) {
+	cases := []struct {
+		template string
+		actors   []string
+		want     string
+	}{
+		{"gossip", []string{"a", "b"}, "unknown template"},
+		{"request-reply", []string{"server"}, "at least 2 actors"},
+		{"pipeline", []string{"a", "a"}, "listed twice"},
+		{"pipeline", []string{"a", "(b)"}, "must be letters"},
+	}
+	for _, tc := range cases {
+		if _, _, err := generateSkeleton(tc.template, tc.actors); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s %v: expected error containing %q, got %v", tc.template, tc.actors, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+// ============================================================================
+// World Snapshots
+// ============================================================================
+//
+// (snapshot-world) saves a deep copy of everything a run can change: the
+// actors with their mailboxes, environments and code, the global
+// environment, the registry, modules, capabilities, costs, timers, the
+// rand sequence and Datalog facts. (restore-world! snap) puts the world back the way it was,
+// so one setup can be run down several branches:
+//
+//   (define before (snapshot-world))
+//   (send-to! 'bakery '(order 3))   (run-scheduler 100) ...
+//   (restore-world! before)
+//   (send-to! 'bakery '(order 10))  (run-scheduler 100) ...
+//
+// A snapshot is never run itself; restoring copies it again, so it can be
+// restored any number of times. Global bindings are restored in place,
+// so code holding the global environment sees the restored values, and
+// globals defined since the snapshot, such as the one holding it, are
+// kept.
+
+// (snapshot-world) - a snapshot of the whole world, #snapshot{n}
+func builtinSnapshotWorld(ev *Evaluator, args []Value, env *Env) Value {
+	ev.snapshots = append(ev.snapshots, cloneWorld(ev))
+	return Value{Type: TypeTagged, Tagged: &TaggedValue{Tag: "snapshot", Value: Int(int64(len(ev.snapshots)))}}
+}
+
+// (restore-world! snap) - put the world back the way it was when snap was
+// taken
+func builtinRestoreWorld(ev *Evaluator, args []Value, env *Env) Value {
+	snap := ev.snapshot(args[0])
+	if snap == nil {
+		ev.warnTrace("", "restore-world!: not a snapshot: %s", args[0].String())
+		return Nil()
+	}
+	if actor := ev.Scheduler.CurrentActor; actor != "" {
+		ev.warnTrace("", "restore-world!: can't restore while actor %s is running", actor)
+		return Nil()
+	}
+	ev.restoreWorld(snap)
+	return Sym("ok")
+}
+
+// snapshot is the world v names, or nil if v isn't one of ev's snapshots
+func (ev *Evaluator) snapshot(v Value) *Evaluator {
+	if v.Type != TypeTagged || v.Tagged.Tag != "snapshot" || v.Tagged.Value.Type != TypeNumber {
+		return nil
+	}
+	n := int(v.Tagged.Value.Number)
+	if n < 1 || n > len(ev.snapshots) {
+		return nil
+	}
+	return ev.snapshots[n-1]
+}
+
+// restoreWorld makes ev's world a copy of snap's. The global environment,
+// scheduler, database and cost tracker are updated in place; the event bus,
+// warnings and settings such as Quiet and Fuel are ev's own.
+func (ev *Evaluator) restoreWorld(snap *Evaluator) {
+	defined := make(map[string]Value)
+	for name, v := range ev.GlobalEnv.bindings {
+		if _, ok := snap.GlobalEnv.bindings[name]; !ok {
+			defined[name] = v
+		}
+	}
+	c := newWorldCopier()
+	c.envs[snap.GlobalEnv] = ev.GlobalEnv
+	c.fill(ev.GlobalEnv, snap.GlobalEnv)
+	for name, v := range defined {
+		ev.GlobalEnv.bindings[name] = v
+	}
+	w := c.world(snap)
+
+	ev.Registry = w.Registry
+	ev.GensymCount = w.GensymCount
+	ev.CapMode = w.CapMode
+	ev.Grants = w.Grants
+	ev.Modules = w.Modules
+	ev.Features = w.Features
+	ev.Rand, ev.randSrc, ev.Seed = w.Rand, w.randSrc, w.Seed
+	*ev.Scheduler = *w.Scheduler
+	*ev.Costs = *w.Costs
+	onAssert := ev.DatalogDB.OnAssert
+	*ev.DatalogDB = *w.DatalogDB
+	ev.DatalogDB.OnAssert = onAssert
+}
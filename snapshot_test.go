@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Snapshot Tests
+// ============================================================================
+
+func TestSnapshotAndRestore(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define sold 0)
+		(define (bakery)
+		  (let ((m (receive!)))
+		    (set! sold (+ sold (car (cdr m))))
+		    (assert! 'sold (car (cdr m)))
+		    (list 'become '(bakery))))
+		(spawn-actor 'bakery 4 '(bakery))
+		(define before (snapshot-world))`)
+
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{"the first branch runs", `
+			(send-to! 'bakery '(order 3))
+			(run-scheduler 100)
+			(list sold (query 'sold '?n))`,
+			"(3 (((n 3))))"},
+		{"restoring undoes its globals and facts", `
+			(list (restore-world! before) sold (query 'sold '?n) (actor-state 'bakery))`,
+			`(ok 0 () (runnable "" 0 4))`},
+		{"a second branch starts from the snapshot", `
+			(send-to! 'bakery '(order 10))
+			(run-scheduler 100)
+			(list sold (query 'sold '?n))`,
+			"(10 (((n 10))))"},
+		{"a snapshot can be restored again", `
+			(restore-world! before)
+			(list sold (query 'sold '?n) before)`,
+			"(0 () #snapshot{1})"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.expected)
+		}
+	}
+}
+
+func TestRestoreWorldRestoresRand(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, "(rand 1000) (rand 1000) (define before (snapshot-world))")
+	first := evalLast(ev, "(list (rand 1000) (rand 1000) (rand 1000))").String()
+	evalLast(ev, "(restore-world! before)")
+	if again := evalLast(ev, "(list (rand 1000) (rand 1000) (rand 1000))").String(); again != first {
+		t.Errorf("after restoring, rand gave %s, want %s again", again, first)
+	}
+}
+
+func TestRestoreWorldErrors(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define snap (snapshot-world))
+		(define (restorer) (restore-world! snap) 'done)
+		(spawn-actor 'restorer 2 '(restorer))`)
+
+	if got := evalLast(ev, "(restore-world! '(snapshot 1))"); got.Type != TypeNil {
+		t.Errorf("restoring a list gave %s, want nil", got.String())
+	}
+	if lastWarning(ev, "restore-world!: not a snapshot: (snapshot 1)") == nil {
+		t.Errorf("expected a warning about the bad snapshot, got %v", ev.Warnings)
+	}
+	evalLast(ev, "(run-scheduler 10)")
+	if lastWarning(ev, "restore-world!: can't restore while actor restorer is running") == nil {
+		t.Errorf("expected a warning about restoring from an actor, got %v", ev.Warnings)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ============================================================================
+// Stack Traces
+// ============================================================================
+//
+// The evaluator keeps the chain of expressions it is in the middle of,
+// each with the call-stack depth it was entered at. An undefined symbol or
+// a call to something that isn't a function is reported with that chain,
+// outermost first, naming the function each expression is in:
+//
+//   Undefined symbol: totl
+//     in actor till: (till 0)
+//     in till: (let msg (receive!) (become (till (+ totl ...
+//     in till: (+ totl (nth msg 1))
+//     in till: totl
+
+// evalFrame is an expression being evaluated
+type evalFrame struct {
+	Expr  Value
+	Depth int // Call-stack depth when it was entered
+}
+
+// maxTraceLines bounds a printed trace; the middle of deep recursion is elided
+const maxTraceLines = 12
+
+// traceExprWidth is how much of each expression a trace shows
+const traceExprWidth = 60
+
+// pushExpr records that expr is being evaluated and returns its index
+func (ev *Evaluator) pushExpr(expr Value) int {
+	ev.Exprs = append(ev.Exprs, evalFrame{Expr: expr, Depth: len(ev.CallStack.Data)})
+	return len(ev.Exprs) - 1
+}
+
+// stackTrace describes the expressions being evaluated, outermost first
+func (ev *Evaluator) stackTrace() []string {
+	where := "top level"
+	if ev.Scheduler != nil && ev.Scheduler.CurrentActor != "" {
+		where = "actor " + ev.Scheduler.CurrentActor
+	}
+	lines := make([]string, 0, len(ev.Exprs))
+	for _, f := range ev.Exprs {
+		in := where
+		if f.Depth > 0 && f.Depth <= len(ev.CallStack.Data) {
+			in = ev.CallStack.Data[f.Depth-1].List[0].Symbol
+		}
+		expr := f.Expr.String()
+		if len(expr) > traceExprWidth {
+			expr = expr[:traceExprWidth-3] + "..."
+		}
+		lines = append(lines, fmt.Sprintf("in %s: %s", in, expr))
+	}
+	return lines
+}
+
+// warnTrace warns like warn, attaching the stack trace and printing it
+// under the message the first time the key is seen
+func (ev *Evaluator) warnTrace(key string, format string, args ...interface{}) {
+	n := len(ev.Warnings)
+	ev.warn(key, format, args...)
+	if len(ev.Warnings) == n {
+		return
+	}
+	trace := ev.stackTrace()
+	ev.Warnings[n].Stack = trace
+	if ev.Quiet {
+		return
+	}
+	for i, line := range trace {
+		if len(trace) > maxTraceLines && i == 3 {
+			fmt.Fprintf(os.Stderr, "  ... %d more\n", len(trace)-maxTraceLines)
+		}
+		if len(trace) > maxTraceLines && i >= 3 && i < len(trace)-(maxTraceLines-3) {
+			continue
+		}
+		fmt.Fprintln(os.Stderr, "  "+line)
+	}
+}
+
+// undefinedHead reports whether a call's head is a symbol with no
+// binding, which has already been reported as undefined
+func (ev *Evaluator) undefinedHead(head Value, env *Env) bool {
+	if !head.IsSymbol() {
+		return false
+	}
+	if _, ok := env.Get(head.Symbol); ok {
+		return false
+	}
+	_, ok := ev.lookupQualified(head.Symbol)
+	return !ok
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Stack Trace Tests
+// ============================================================================
+
+// lastWarning returns the most recent warning whose message starts with prefix
+func lastWarning(ev *Evaluator, prefix string) *Warning {
+	for i := len(ev.Warnings) - 1; i >= 0; i-- {
+		if strings.HasPrefix(ev.Warnings[i].Message, prefix) {
+			return &ev.Warnings[i]
+		}
+	}
+	return nil
+}
+
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		name    string
+		code    string
+		message string
+		trace   []string
+	}{
+		{
+			"undefined symbol in a function",
+			"(define (area r) (* pi r r)) (define (report r) (list 'area (area r))) (report 2)",
+			"Undefined symbol: pi",
+			[]string{
+				"in top level: (report 2)",
+				"in report: (list (quote area) (area r))",
+				"in report: (area r)",
+				"in area: (* pi r r)",
+				"in area: pi",
+			},
+		},
+		{
+			"bad apply",
+			"(define (twice f x) (f (f x))) (twice 3 1)",
+			"Cannot apply f: 3 is not a function",
+			[]string{
+				"in top level: (twice 3 1)",
+				"in twice: (f (f x))",
+				"in twice: (f x)",
+			},
+		},
+		{
+			"inside an actor",
+			`(define (till total) (let msg (receive!) (list 'become (list 'till (+ totl 1)))))
+			 (spawn-actor 'till 4 '(till 0))
+			 (send-to! 'till 'ring)
+			 (run-scheduler 3)`,
+			"Undefined symbol: totl",
+			[]string{
+				"in actor till: (till 0)",
+				"in till: (list (quote become) (list (quote till) (+ totl 1)))",
+				"in till: (list (quote till) (+ totl 1))",
+				"in till: (+ totl 1)",
+				"in till: totl",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := NewEvaluator(100)
+			ev.Quiet = true
+			runCode(ev, tt.code)
+			w := lastWarning(ev, tt.message)
+			if w == nil {
+				t.Fatalf("no warning %q in %v", tt.message, ev.Warnings)
+			}
+			if strings.Join(w.Stack, "\n") != strings.Join(tt.trace, "\n") {
+				t.Errorf("trace:\n%s\nwant:\n%s", strings.Join(w.Stack, "\n"), strings.Join(tt.trace, "\n"))
+			}
+			if len(ev.Exprs) != 0 {
+				t.Errorf("expression chain not unwound: %d left", len(ev.Exprs))
+			}
+		})
+	}
+}
+
+func TestCallingUndefinedReportsOnce(t *testing.T) {
+	ev := NewEvaluator(100)
+	ev.Quiet = true
+	runCode(ev, "(frobnicate 1 2)")
+	if len(ev.Warnings) != 1 || ev.Warnings[0].Message != "Undefined symbol: frobnicate" {
+		t.Errorf("expected just the undefined symbol, got %v", ev.Warnings)
+	}
+}
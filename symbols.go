@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+)
+
+// ============================================================================
+// Symbol Interning
+// ============================================================================
+//
+// The parser interns every symbol it reads: each distinct name gets a
+// small integer ID, the same for every evaluator in the process. Function
+// and let scopes are frames of (ID, name, value) slots, so looking up a
+// parsed symbol compares integers instead of hashing its name, and binding
+// a call's arguments doesn't allocate a map. Compiled function bodies go
+// further and address their own parameters and let names by position (see
+// compile.go). Symbols made at run time, by string->symbol or by Sym in
+// Go, aren't interned and are looked up by name; both find the same
+// bindings.
+
+// SymbolID is an interned symbol name; 0 means not interned
+type SymbolID uint32
+
+var symbolTable = struct {
+	sync.Mutex
+	ids map[string]SymbolID
+}{ids: make(map[string]SymbolID)}
+
+// Intern returns name's ID, assigning the next one the first time
+func Intern(name string) SymbolID {
+	symbolTable.Lock()
+	defer symbolTable.Unlock()
+	id, ok := symbolTable.ids[name]
+	if !ok {
+		id = SymbolID(len(symbolTable.ids) + 1)
+		symbolTable.ids[name] = id
+	}
+	return id
+}
+
+// InternedSym is Sym with the name interned, as the parser makes symbols
+func InternedSym(name string) Value {
+	v := Sym(name)
+	v.SymID = Intern(name)
+	return v
+}
+
+// frameLayout is the IDs bindParams binds f's parameters under, in slot
+// order, or nil if f wasn't made by newFunction
+func (f *Function) frameLayout() []SymbolID {
+	if len(f.paramIDs) != len(f.Params) {
+		return nil
+	}
+	var layout []SymbolID
+	valid := true
+	add := func(id SymbolID) {
+		valid = valid && id != 0
+		for _, seen := range layout {
+			if seen == id {
+				return // A repeated name reuses its slot
+			}
+		}
+		layout = append(layout, id)
+	}
+	for _, id := range f.paramIDs {
+		add(id)
+	}
+	for _, p := range f.Optional {
+		add(p.ID)
+	}
+	for _, p := range f.Keys {
+		add(p.ID)
+	}
+	if f.RestParam != "" {
+		add(f.restID)
+	}
+	if !valid {
+		return nil
+	}
+	return layout
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Symbol Interning and Frame Tests
+// ============================================================================
+
+func TestIntern(t *testing.T) {
+	if Intern("balance") != Intern("balance") {
+		t.Errorf("the same name interned to different IDs")
+	}
+	if Intern("balance") == Intern("deposit") {
+		t.Errorf("different names interned to the same ID")
+	}
+	parsed := parseAll(NewParser("(deposit 'balance)"))[0]
+	if id := parsed.List[0].SymID; id != Intern("deposit") {
+		t.Errorf("parsed deposit has ID %d, want %d", id, Intern("deposit"))
+	}
+	if id := parsed.List[1].List[0].SymID; id != Intern("quote") {
+		t.Errorf("parsed quote has ID %d, want %d", id, Intern("quote"))
+	}
+	if Sym("balance").SymID != 0 {
+		t.Errorf("Sym interned its name")
+	}
+}
+
+func TestFrameLookup(t *testing.T) {
+	global := NewEnv(nil)
+	global.Set("x", Int(1))
+	frame := NewFrame(global, 2)
+	frame.Set("y", Int(2))
+	frame.setSym(InternedSym("x"), Int(3))
+
+	for _, sym := range []Value{InternedSym("x"), Sym("x")} {
+		if v, ok := frame.Lookup(sym); !ok || v.String() != "3" {
+			t.Errorf("Lookup(%s, interned %v) = %s, want the frame's 3", sym.Symbol, sym.SymID != 0, v.String())
+		}
+	}
+	if v, ok := frame.Get("y"); !ok || v.String() != "2" {
+		t.Errorf("Get(y) = %s, want 2", v.String())
+	}
+	frame.SetLocal("y", Int(4))
+	frame.SetLocal("x", Int(5))
+	if len(frame.frame) != 2 || frame.frame[0].Value.String() != "4" || frame.frame[1].Value.String() != "5" {
+		t.Errorf("SetLocal didn't update the frame in place: %v", frame.local())
+	}
+	if v, _ := global.Get("x"); v.String() != "1" {
+		t.Errorf("the global x changed to %s", v.String())
+	}
+}
+
+func TestFrameScoping(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		// Let shadows a parameter; the parameter is back after it
+		{"(define (f x) (list (let x (* x 10) x) x)) (list (f 1) (f 2))", "((10 1) (20 2))"},
+		// set! on a parameter updates the frame a closure captured
+		{"(define (counter n) (lambda () (set! n (+ n 1)) n)) (define c (counter 5)) (c) (c) (c)", "8"},
+		// A repeated parameter name binds one slot, the last argument
+		{"(define (g a a) a) (list (g 1 2) (g 3 4))", "(2 4)"},
+		// Optional, keyword and rest parameters
+		{"(define (h a (b 10) . more) (list a b more)) (list (h 1) (h 1 2 3 4))", "((1 10 ()) (1 2 (3 4)))"},
+		{"(define (k a &key (n 3)) (+ a n)) (list (k 1) (k 1 :n 5) (k 2 :n 5))", "(4 6 7)"},
+		// Symbols made at run time find the same bindings
+		{"(define (e x) (eval (list (string->symbol \"+\") (string->symbol \"x\") 1))) (define x 100) (list (e 1) (e 2))", "(101 101)"},
+		// Scheme-style let evaluates every value in the enclosing scope
+		{"(define x 100) (define (f a) (let ((x a) (y x) (z)) (list x y z))) (list (f 1) (f 2))", "((1 100 nil) (2 100 nil))"},
+		{"(define (g n) (let ((m (* n 2)) (k 1)) (set! k (+ k m)) k)) (list (g 1) (g 2) (g 3))", "(3 5 7)"},
+		{"(define (h) (let ((a 1) (a 2)) a)) (list (h) (h) (let () 'empty))", "(2 2 empty)"},
+		{"(define (sum n acc) (let ((n1 (- n 1)) (acc1 (+ acc n))) (if (= n 0) acc (sum n1 acc1)))) (sum 5000 0)", "12502500"},
+		// Redefining a global after a function is compiled
+		{"(define y 1) (define (get-y) y) (get-y) (get-y) (define y 2) (get-y)", "2"},
+	}
+	for _, tt := range tests {
+		for _, interpret := range []bool{true, false} {
+			_, result := runBoth(tt.code, interpret)
+			if result.String() != tt.expected {
+				t.Errorf("%s (interpret %v) = %s, want %s", tt.code, interpret, result.String(), tt.expected)
+			}
+		}
+	}
+}
+
+// ============================================================================
+// Benchmarks
+// ============================================================================
+
+// lookupProgram spends its time looking up parameters and let names
+const lookupProgram = `
+	(define (walk n a b c d acc)
+	  (if (= n 0)
+	    acc
+	    (let x (+ a b)
+	      (let y (+ c d)
+	        (walk (- n 1) b c d a (+ acc x y))))))
+	(walk 20000 1 2 3 4 0)`
+
+func BenchmarkLookup(b *testing.B) {
+	benchmarkProgram(b, lookupProgram)
+}
+
+func BenchmarkFrameBinding(b *testing.B) {
+	x, y := InternedSym("x"), InternedSym("y")
+	global := NewEnv(nil)
+	b.Run("map", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			env := NewEnv(global)
+			env.Set("x", Int(1))
+			env.Set("y", Int(2))
+			env.Get("x")
+			env.Get("y")
+		}
+	})
+	b.Run("frame", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			env := NewFrame(global, 2)
+			env.setSym(x, Int(1))
+			env.setSym(y, Int(2))
+			env.Lookup(x)
+			env.Lookup(y)
+		}
+	})
+}
@@ -0,0 +1,222 @@
+package main
+
+import "fmt"
+
+// ============================================================================
+// Tabling
+// ============================================================================
+//
+// Solved top-down, a recursive rule derives the same subgoal over and over:
+// (path 0 ?y) over a chain asks (path 1 ?y) once for every way of reaching
+// 1, and left recursion, (path ?x ?z) :- (path ?x ?y) (edge ?y ?z), asks
+// itself the same question until maxDepth cuts it off.
+//
+// So within a query, each distinct call to a predicate that has rules, the
+// predicate with its arguments as bound at the time (path 1 ?y is one
+// call, however ?y is named), is solved once and its answers kept in a
+// table. Later calls, in the same query, read the table instead.
+//
+// A call may need itself: (path 1 ?y) asks (path 2 ?y), which, given a
+// cycle, asks (path 1 ?y) again. The inner call gets the answers found so
+// far, and the outermost call of such a cycle, its leader, solves its
+// clauses again until a pass adds no answer to any table in the cycle.
+// Only then are the tables complete. Each pass can only add answers, and
+// a Datalog program has finitely many, so this ends where plain recursion
+// would have gone on forever.
+//
+// Tables hold answers, not derivations: a fact derivable two ways is one
+// answer, and the bindings of a rule's own variables aren't reported.
+// Calls nest only as deep as the chain of distinct calls, so the depth
+// limit no longer cuts recursive rules short; maxTableNesting stops
+// only programs that would ask infinitely many questions, as counting
+// up with is can.
+
+const maxTableNesting = 10000
+
+// tables are the answers to the calls made while solving one query
+type tables struct {
+	ruled   map[string]bool   // Predicates with rules, which are tabled
+	calls   map[string]*table // By call (see callKey)
+	stack   []*table          // Calls being solved, outermost first
+	pending []*table          // Calls left incomplete, waiting on a call further out
+	added   int               // Answers added to any table so far
+	fills   int               // Times a call's clauses have been solved so far
+	renamed int               // Answers with variables read so far, for fresh names
+}
+
+// table is one call's answers
+type table struct {
+	key      string   // Its callKey
+	goal     Goal     // The call, its variables named by position
+	answers  [][]Term // Its arguments in each answer
+	seen     map[string]bool
+	complete bool // Every answer has been found
+	active   bool // On the stack
+	pos      int  // Its place on the stack while active
+	low      int  // Lowest stack place of an incomplete call it read
+	filled   int  // Value of fills when it was last solved
+}
+
+func (db *DatalogDB) newTables() *tables {
+	ruled := make(map[string]bool)
+	for _, r := range db.Rules {
+		ruled[r.Head.Predicate] = true
+	}
+	return &tables{ruled: ruled, calls: make(map[string]*table)}
+}
+
+// callKey is goal as called under b, with its variables renamed in order
+// of appearance, so that calls differing only in variable names share a
+// table, and the renamed goal
+func callKey(goal Goal, b Binding) (string, Goal) {
+	names := make(map[string]string)
+	var rename func(t Term) Term
+	rename = func(t Term) Term {
+		if t.IsVar {
+			if _, ok := names[t.Name]; !ok {
+				names[t.Name] = fmt.Sprintf("_T%d", len(names))
+			}
+			return Var(names[t.Name])
+		}
+		if t.IsList {
+			list := make([]Term, len(t.List))
+			for i, x := range t.List {
+				list[i] = rename(x)
+			}
+			return ListTerm(list...)
+		}
+		return t
+	}
+	call := Goal{Predicate: goal.Predicate, Args: make([]Term, len(goal.Args))}
+	for i, a := range goal.Args {
+		call.Args[i] = rename(b.Deref(a))
+	}
+	return Fact{Predicate: call.Predicate, Args: call.Args}.key(), call
+}
+
+// solveTabled solves goal from its call's table, filling the table first
+// if it isn't complete, then solves rest under each answer
+func (db *DatalogDB) solveTabled(goal Goal, rest []Goal, bindings Binding, depth int) []Binding {
+	ts := db.tables
+	key, call := callKey(goal, bindings)
+	t := ts.calls[key]
+	if t == nil {
+		t = &table{key: key, goal: call, seen: make(map[string]bool)}
+		ts.calls[key] = t
+	}
+	if !t.complete && !t.active {
+		if len(ts.stack) >= maxTableNesting {
+			return nil
+		}
+		db.fillTable(t)
+	}
+	if !t.complete && len(ts.stack) > 0 {
+		// Read while it is being solved: whatever reads it can't be
+		// complete before it is
+		caller := ts.stack[len(ts.stack)-1]
+		low := t.pos
+		if !t.active {
+			low = t.low
+		}
+		if low < caller.low {
+			caller.low = low
+		}
+	}
+
+	var results []Binding
+	answers := t.answers // Answers added from here on are found by a later pass
+	for _, ans := range answers {
+		if !groundTerms(ans) {
+			ans = ts.freshVars(ans)
+		}
+		if newB, ok := UnifyArgs(goal.Args, ans, bindings); ok {
+			results = append(results, db.solve(rest, newB, depth+1)...)
+		}
+	}
+	return results
+}
+
+// fillTable solves t's call, repeating while t leads a cycle of calls
+// that is still finding answers
+func (db *DatalogDB) fillTable(t *table) {
+	ts := db.tables
+	t.active, t.pos, t.low = true, len(ts.stack), len(ts.stack)
+	ts.stack = append(ts.stack, t)
+	for {
+		ts.fills++
+		pass, before := ts.fills, ts.added
+		t.filled = pass
+		for _, b := range db.matchClauses(t.goal, nil, make(Binding), 0) {
+			ans := make([]Term, len(t.goal.Args))
+			for i, a := range t.goal.Args {
+				ans[i] = b.Deref(a)
+			}
+			if k := (Fact{Args: ans}).key(); !t.seen[k] {
+				t.seen[k] = true
+				t.answers = append(t.answers, ans)
+				ts.added++
+			}
+		}
+		if t.low < t.pos {
+			// Part of a cycle led further out, which will ask again
+			ts.pending = append(ts.pending, t)
+			break
+		}
+		if ts.added > before {
+			continue
+		}
+		// A pass that found nothing new: the calls of the cycle solved in
+		// it are complete, and any it didn't get to are dropped
+		t.complete = true
+		kept := ts.pending[:0]
+		for _, u := range ts.pending {
+			switch {
+			case u.complete:
+			case u.low < t.pos:
+				kept = append(kept, u)
+			case u.filled > pass:
+				u.complete = true
+			default:
+				delete(ts.calls, u.key)
+			}
+		}
+		ts.pending = kept
+		break
+	}
+	ts.stack = ts.stack[:len(ts.stack)-1]
+	t.active = false
+}
+
+// freshVars renames the variables in an answer apart from the caller's
+func (ts *tables) freshVars(ans []Term) []Term {
+	ts.renamed++
+	suffix := fmt.Sprintf("_a%d", ts.renamed)
+	var rename func(t Term) Term
+	rename = func(t Term) Term {
+		if t.IsVar {
+			return Var(t.Name + suffix)
+		}
+		if t.IsList {
+			list := make([]Term, len(t.List))
+			for i, x := range t.List {
+				list[i] = rename(x)
+			}
+			return ListTerm(list...)
+		}
+		return t
+	}
+	out := make([]Term, len(ans))
+	for i, a := range ans {
+		out[i] = rename(a)
+	}
+	return out
+}
+
+func groundTerms(terms []Term) bool {
+	for _, t := range terms {
+		if !t.ground() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Tabling Tests
+// ============================================================================
+
+// sortedAnswers is answers, sorted, for checks that don't care about order
+func sortedAnswers(results []Binding, name string) string {
+	fields := strings.Fields(answers(results, name))
+	sort.Strings(fields)
+	return strings.Join(fields, " ")
+}
+
+func edgesDB(edges ...string) *DatalogDB {
+	db := NewDatalogDB()
+	for _, e := range edges {
+		ends := strings.Split(e, ">")
+		db.Assert("edge", Atom(ends[0]), Atom(ends[1]))
+	}
+	return db
+}
+
+func TestTablingLeftRecursion(t *testing.T) {
+	// Left recursion asks (reach ?x ?y) of itself before anything else
+	db := edgesDB("a>b", "b>c", "c>a", "c>d")
+	db.AddRule("reach-edge",
+		Fact{Predicate: "reach", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "edge", Args: []Term{Var("X"), Var("Y")}},
+	)
+	db.AddRule("reach-more",
+		Fact{Predicate: "reach", Args: []Term{Var("X"), Var("Z")}},
+		Goal{Predicate: "reach", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "edge", Args: []Term{Var("Y"), Var("Z")}},
+	)
+
+	tests := []struct {
+		goal     Goal
+		variable string
+		expected string
+	}{
+		{Goal{Predicate: "reach", Args: []Term{Atom("a"), Var("Y")}}, "Y", "a b c d"},
+		{Goal{Predicate: "reach", Args: []Term{Atom("d"), Var("Y")}}, "Y", ""},
+		{Goal{Predicate: "reach", Args: []Term{Var("X"), Atom("a")}}, "X", "a b c"},
+		{Goal{Predicate: "reach", Args: []Term{Var("X"), Var("X")}}, "X", "a b c"},
+	}
+	for _, tt := range tests {
+		if got := sortedAnswers(db.QueryGoals(tt.goal), tt.variable); got != tt.expected {
+			t.Errorf("%s: ?%s = %q, want %q", tt.goal.String(), tt.variable, got, tt.expected)
+		}
+	}
+	if got := len(db.Query("reach", Var("X"), Var("Y"))); got != 12 {
+		t.Errorf("found %d reach answers, want 12 with no repeats", got)
+	}
+}
+
+func TestTablingMutualRecursion(t *testing.T) {
+	// Two predicates in one cycle of calls, plus a negation outside it
+	db := edgesDB("a>b", "b>c", "c>d", "d>a", "x>y")
+	db.AddRule("even-start",
+		Fact{Predicate: "even", Args: []Term{Var("X"), Var("X")}},
+		Goal{Predicate: "edge", Args: []Term{Var("X"), Var("Any")}},
+	)
+	db.AddRule("even-step",
+		Fact{Predicate: "even", Args: []Term{Var("X"), Var("Z")}},
+		Goal{Predicate: "odd", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "edge", Args: []Term{Var("Y"), Var("Z")}},
+	)
+	db.AddRule("odd-step",
+		Fact{Predicate: "odd", Args: []Term{Var("X"), Var("Z")}},
+		Goal{Predicate: "even", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "edge", Args: []Term{Var("Y"), Var("Z")}},
+	)
+	db.AddRule("odd-only",
+		Fact{Predicate: "odd-only", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "odd", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "even", Args: []Term{Var("X"), Var("Y")}, Negated: true},
+	)
+
+	if got := sortedAnswers(db.Query("even", Atom("a"), Var("Y")), "Y"); got != "a c" {
+		t.Errorf("even steps from a reach %q, want a c", got)
+	}
+	if got := sortedAnswers(db.Query("odd", Atom("a"), Var("Y")), "Y"); got != "b d" {
+		t.Errorf("odd steps from a reach %q, want b d", got)
+	}
+	if got := sortedAnswers(db.Query("odd-only", Atom("x"), Var("Y")), "Y"); got != "y" {
+		t.Errorf("odd-only from x reaches %q, want y", got)
+	}
+}
+
+func TestTablingDeepChain(t *testing.T) {
+	// Far deeper than maxDepth
+	db := chainDB(300)
+	if got := len(db.Query("path", NumTerm(0), Var("Y"))); got != 300 {
+		t.Errorf("found %d paths from 0 along 300 edges, want 300", got)
+	}
+	if got := len(db.Query("path", NumTerm(0), NumTerm(300))); got != 1 {
+		t.Errorf("found %d paths from 0 to 300, want 1", got)
+	}
+}
+
+func TestTablingReportsQueryVariablesOnly(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(assert! 'parent 'tom 'bob)
+		(assert! 'parent 'bob 'ann)
+		(rule 'grandparent '(grandparent ?x ?z) '(parent ?x ?y) '(parent ?y ?z))
+		(rule 'anyone '(knows ?x ?y) '(parent ?x ?z))`)
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"(query 'grandparent '?gp '?gc)", "(((gc ann) (gp tom)))"},
+		// An answer with a variable left in it is still an answer
+		{"(length (query 'knows 'tom '?who))", "1"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+}
+
+func BenchmarkTabledLeftRecursion(b *testing.B) {
+	db := NewDatalogDB()
+	for i := 0; i < 100; i++ {
+		db.Assert("edge", NumTerm(float64(i)), NumTerm(float64((i+1)%100)))
+	}
+	db.AddRule("reach-edge",
+		Fact{Predicate: "reach", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "edge", Args: []Term{Var("X"), Var("Y")}},
+	)
+	db.AddRule("reach-more",
+		Fact{Predicate: "reach", Args: []Term{Var("X"), Var("Z")}},
+		Goal{Predicate: "reach", Args: []Term{Var("X"), Var("Y")}},
+		Goal{Predicate: "edge", Args: []Term{Var("Y"), Var("Z")}},
+	)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		db.Query("reach", NumTerm(0), Var("Y"))
+	}
+}
@@ -15,7 +15,7 @@
 
 (define (assert-eq actual expected name)
   (set! *tests-run* (+ *tests-run* 1))
-  (if (= actual expected)
+  (if (equal? actual expected)
       (do
         (set! *tests-passed* (+ *tests-passed* 1))
         (println "  ✓ " name))
@@ -338,7 +338,7 @@
 ; Now check actor can receive
 (spawn-actor 'receiver 4 
   '(let msg (receive!) 
-     (if (= (first msg) 'test-msg) 'got-it 'wrong)))
+     (if (equal? msg 'test-msg) 'got-it 'wrong)))
 (send-to! 'receiver 'test-msg)
 (run-scheduler 5)
 
@@ -400,7 +400,7 @@
 (spawn-actor 'wait-then-done 8 
   '(do 
      (let msg (receive!)
-       (if (= msg 'wake) 'done 'error))))
+       (if (equal? msg 'wake) 'done 'error))))
 
 ; Send a message
 (send-to! 'wait-then-done 'wake)
@@ -461,15 +461,15 @@
 (assert-eq (first (rest '((a b) (c d)))) '(c d) "nested rest")
 
 ; Deep equality
-(assert-true (= '(a (b c) d) '(a (b c) d)) "deep list equality")
-(assert-false (= '(a (b c) d) '(a (b x) d)) "deep list inequality")
+(assert-true (equal? '(a (b c) d) '(a (b c) d)) "deep list equality")
+(assert-false (equal? '(a (b c) d) '(a (b x) d)) "deep list inequality")
 
 ; Numbers in lists
 (assert-eq (+ (first '(1 2 3)) (second '(1 2 3))) 3 "arithmetic on list elements")
 
 ; Symbols vs strings
-(assert-false (= 'hello "hello") "symbol not equal to string")
-(assert-true (= (symbol->string 'hello) "hello") "converted symbol equals string")
+(assert-false (equal? 'hello "hello") "symbol not equal to string")
+(assert-true (equal? (symbol->string 'hello) "hello") "converted symbol equals string")
 
 ; ============================================================================
 ; Recursion and Higher-Order Functions
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ============================================================================
+// Timers
+// ============================================================================
+//
+// Time in a run is counted in scheduler steps, or in virtual time once
+// advance-time! is used (see vtime.go). (send-after! n target msg) sends
+// msg once n more steps have run, and (receive-timeout! n) is a receive!
+// that gives up after n steps:
+//
+//   (send-after! 5 (self) 'retry)   ; => ok, and retry arrives 5 steps on
+//   (receive-timeout! 10)           ; => the next message, or timeout
+//
+// Pending timers sit in the scheduler's timer wheel, a bucket per step
+// they are due at. Each step, runSteps fires the buckets that have come
+// due. When every actor is waiting, nothing else can happen until the
+// next timer, so it fires early, the same way scripted stimuli arrive;
+// in virtual time the clock moves ahead to it.
+
+// Timer is a message to deliver, or a receive-timeout! to end, at a step
+type Timer struct {
+	Due     int64
+	From    string // Actor that set it, or external
+	Target  string
+	Message Value
+	Timeout int   // For a receive-timeout!, the actor's wait it ends; 0 otherwise
+	Stamp   Stamp // Clocks as of when it was set, which its message carries
+}
+
+// addTimer puts t in the wheel
+func (s *Scheduler) addTimer(t Timer) {
+	if s.Timers == nil {
+		s.Timers = make(map[int64][]Timer)
+	}
+	s.Timers[t.Due] = append(s.Timers[t.Due], t)
+}
+
+// timerSteps returns the steps timers are due at, earliest first
+func (s *Scheduler) timerSteps() []int64 {
+	steps := make([]int64, 0, len(s.Timers))
+	for step := range s.Timers {
+		steps = append(steps, step)
+	}
+	sort.Slice(steps, func(i, j int) bool { return steps[i] < steps[j] })
+	return steps
+}
+
+// deliverTimers fires the timers due by the current step, in the order
+// they were set. With force, the earliest bucket fires even if it isn't
+// due yet, for when nothing else can happen until it does. Returns how
+// many fired.
+func (ev *Evaluator) deliverTimers(force bool) int {
+	s := ev.Scheduler
+	fired := 0
+	for _, step := range s.timerSteps() {
+		if step > s.now() && !(force && fired == 0) {
+			break
+		}
+		if s.Virtual && step > s.VirtualTime {
+			ev.setTime(step)
+		}
+		timers := s.Timers[step]
+		delete(s.Timers, step)
+		for _, t := range timers {
+			ev.fireTimer(t)
+		}
+		fired += len(timers)
+	}
+	return fired
+}
+
+// timerBeforeStimulus reports whether the next timer is due before the
+// next scripted stimulus, so it should be the one forced. Stimuli are
+// scripted by step, so in virtual time they come first.
+func (ev *Evaluator) timerBeforeStimulus() bool {
+	s := ev.Scheduler
+	steps := s.timerSteps()
+	if len(steps) == 0 || s.Virtual {
+		return false
+	}
+	return s.NextStimulus >= len(s.Stimuli) || steps[0] < s.Stimuli[s.NextStimulus].Step
+}
+
+// fireTimer delivers a timer's message, or ends the receive-timeout! it
+// was set for if the actor is still waiting in it
+func (ev *Evaluator) fireTimer(t Timer) {
+	target := ev.Scheduler.GetActor(t.Target)
+	if target == nil || target.State == ActorDone {
+		return
+	}
+	if t.Timeout != 0 {
+		if target.Timeout == t.Timeout {
+			target.TimedOut = true
+			ev.Scheduler.UnblockActor(t.Target)
+		}
+		return
+	}
+	if !target.Mailbox.SendStamped(t.Message, 0, t.Stamp) {
+		ev.warn("timer-full:"+t.Target, "%s: mailbox full, dropped timer message %s", t.Target, t.Message.String())
+		return
+	}
+	ev.TraceSend(t.From, t.Target, t.Message)
+	ev.assertSentStamp(t.From, t.Target, t.Message, t.Stamp)
+	ev.emit(SchedEvent{Kind: EventMessageSent, Actor: t.From, Target: t.Target, Message: t.Message})
+	if target.State == ActorBlocked && strings.HasPrefix(target.BlockedOn, "recv") {
+		ev.Scheduler.UnblockActor(t.Target)
+	}
+}
+
+// (send-after! n target msg) - send msg to target once n more steps have run
+func builtinSendAfter(ev *Evaluator, args []Value, env *Env) Value {
+	target := valueToString(args[1])
+	if ev.Scheduler.GetActor(target) == nil {
+		ev.warnTrace("send-after-unknown:"+target, "send-after!: unknown actor %s", target)
+		return Nil()
+	}
+	from := ev.Scheduler.CurrentActor
+	if from == "" {
+		from = "external"
+	}
+	ev.Scheduler.addTimer(Timer{Due: ev.now() + int64(args[0].Number), From: from, Target: target, Message: args[2], Stamp: ev.stamp()})
+	return Sym("ok")
+}
+
+// (receive-timeout! n) - receive from own mailbox, or timeout if nothing
+// has arrived after n steps
+// AUTO-TRACES: asserts (received actor msg time) fact
+func builtinReceiveTimeout(ev *Evaluator, args []Value, env *Env) Value {
+	ev.markGuardSeen()
+	actor := ev.Scheduler.GetActor(ev.Scheduler.CurrentActor)
+	if actor == nil {
+		ev.warn("receive-no-actor", "receive-timeout!: no current actor")
+		return Nil()
+	}
+	if msg, stamp, ok := actor.Mailbox.RecvStamped(); ok {
+		actor.Received++
+		actor.mergeClock(stamp)
+		actor.Timeout, actor.TimedOut = 0, false
+		ev.TraceReceive(actor.Name, msg)
+		ev.assertReceivedStamp(actor, msg)
+		return msg
+	}
+	if actor.TimedOut || args[0].Number <= 0 {
+		actor.Timeout, actor.TimedOut = 0, false
+		return Sym("timeout")
+	}
+	n := int64(args[0].Number)
+	if actor.Timeout == 0 {
+		// A retried step waits on the timer its first try set
+		ev.Scheduler.timeouts++
+		actor.Timeout = ev.Scheduler.timeouts
+		ev.Scheduler.addTimer(Timer{Due: ev.now() + n, Target: actor.Name, Timeout: actor.Timeout})
+	}
+	ev.Scheduler.BlockActor(actor.Name, fmt.Sprintf("recv (empty, timeout %d)", n))
+	return Blocked(BlockQueueEmpty)
+}
+
+// (pending-timers) - ((due from target msg) ...) for the timers not yet fired
+func builtinPendingTimers(ev *Evaluator, args []Value, env *Env) Value {
+	var items []Value
+	for _, step := range ev.Scheduler.timerSteps() {
+		for _, t := range ev.Scheduler.Timers[step] {
+			if t.Timeout == 0 {
+				items = append(items, Lst(Int(t.Due), Sym(t.From), Sym(t.Target), t.Message))
+			}
+		}
+	}
+	return Lst(items...)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Timer Tests
+// ============================================================================
+
+func TestTimers(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{"send-after! delivers after the delay", `
+			(define got '())
+			(define (pinger) (send-after! 3 'ponger 'ping) 'done)
+			(define (ponger) (set! got (receive!)) 'done)
+			(spawn-actor 'pinger 4 '(pinger))
+			(spawn-actor 'ponger 4 '(ponger))
+			(list (run-scheduler 20) got (query 'sent 'pinger 'ponger '?m))`,
+			"((completed 3) ping (((m ping))))"},
+		{"the delay is counted in steps", `
+			(define n 0)
+			(spawn-actor 'busy 4 '(begin (set! n (+ n 1)) (if (< n 8) 'yield 'done)))
+			(spawn-actor 'pinger 4 '(begin (send-after! 3 'ponger 'ping) 'done))
+			(spawn-actor 'ponger 4 '(begin (receive!) 'done))
+			(run-scheduler 50)
+			(filter (lambda (f) (equal? (first f) 'sent)) (datalog-facts))`,
+			"((sent pinger ponger ping (@ 4)))"},
+		{"timers due at the same step fire in the order they were set", `
+			(define got '())
+			(define (sink) (set! got (cons (receive!) got)) (list 'become '(sink)))
+			(spawn-actor 'sink 4 '(sink))
+			(send-after! 2 'sink 'b)
+			(send-after! 1 'sink 'a)
+			(send-after! 2 'sink 'c)
+			(run-scheduler 20)
+			(reverse got)`,
+			"(a b c)"},
+		{"receive-timeout! times out", `
+			(define got '())
+			(spawn-actor 'w 4 '(begin (set! got (receive-timeout! 5)) 'done))
+			(list (run-scheduler 20) got)`,
+			"((completed 2) timeout)"},
+		{"receive-timeout! takes a message that arrives in time", `
+			(define got '())
+			(spawn-actor 'w 4 '(begin (set! got (receive-timeout! 5)) 'done))
+			(spawn-actor 'ticker 4 '(begin (send-after! 2 'w 'hello) 'done))
+			(list (run-scheduler 20) got (pending-timers))`,
+			"((completed 3) hello ())"},
+		{"a retry loop built from timeouts", `
+			(define tries 0)
+			(define (client)
+			  (set! tries (+ tries 1))
+			  (send-to! 'server 'request)
+			  (let ((reply (receive-timeout! 4)))
+			    (if (equal? reply 'timeout)
+			        (list 'become '(client))
+			        'done)))
+			(define (server)
+			  (receive!)
+			  (if (< tries 3) (list 'become '(server)) (begin (send-to! 'client 'reply) (list 'become '(server)))))
+			(spawn-actor 'client 4 '(client))
+			(spawn-actor 'server 4 '(server))
+			(list (first (run-scheduler 100)) tries (first (actor-state 'client)))`,
+			"(deadlock 3 done)"},
+		{"pending timers are listed", `
+			(spawn-actor 'w 4 '(receive!))
+			(send-after! 7 'w '(tick 1))
+			(pending-timers)`,
+			"((7 external w (tick 1)))"},
+		{"unknown target", `(send-after! 1 'nobody 'x)`, "nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := NewEvaluator(1000)
+			ev.Quiet = true
+			runCode(ev, "(define (reverse l) (fold-left (lambda (acc x) (cons x acc)) '() l))")
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
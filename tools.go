@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -32,6 +33,9 @@ func NewToolRegistry(ev *Evaluator) *ToolRegistry {
 	tr.tools["metrics_chart"] = toolMetricsChart
 	tr.tools["tla_spec"] = toolTLASpec
 	tr.tools["alloy_spec"] = toolAlloySpec
+	tr.tools["scenario_table"] = toolScenarioTable
+	tr.tools["fairness_report"] = toolFairnessReport
+	tr.tools["explanation"] = toolExplanation
 	
 	return tr
 }
@@ -62,6 +66,9 @@ func (tr *ToolRegistry) Process(markdown string) string {
 		return fmt.Sprintf("<!-- Unknown tool: %s -->", toolName)
 	})
 	
+	// Status columns come from the property registry, not the author
+	result = tr.ev.SyncPropertiesTable(result)
+	
 	// Clean up mermaid blocks
 	return cleanMermaidBlocks(result)
 }
@@ -385,6 +392,11 @@ func toolPropertiesTable(ev *Evaluator, args map[string]string) string {
 		}
 	}
 	
+	// Say which protocol variant these results are for
+	if names := ev.featureNames(); len(names) > 0 {
+		sb.WriteString("\n*Features: " + strings.Join(names, ", ") + "*\n")
+	}
+	
 	return sb.String()
 }
 
@@ -420,7 +432,8 @@ func toolFactsList(ev *Evaluator, args map[string]string) string {
 		byPred[f.Predicate] = append(byPred[f.Predicate], f)
 	}
 	
-	for pred, pFacts := range byPred {
+	for _, pred := range sortedKeys(byPred) {
+		pFacts := byPred[pred]
 		sb.WriteString(fmt.Sprintf("**%s** (%d):\n", pred, len(pFacts)))
 		for _, f := range pFacts {
 			args := make([]string, len(f.Args))
@@ -505,9 +518,9 @@ func toolFactsSummary(ev *Evaluator, args map[string]string) string {
 	sb.WriteString("|-----------|-------|\n")
 	
 	total := 0
-	for pred, count := range counts {
-		sb.WriteString(fmt.Sprintf("| %s | %d |\n", pred, count))
-		total += count
+	for _, pred := range sortedKeys(counts) {
+		sb.WriteString(fmt.Sprintf("| %s | %d |\n", pred, counts[pred]))
+		total += counts[pred]
 	}
 	
 	sb.WriteString(fmt.Sprintf("| **Total** | **%d** |\n", total))
@@ -592,6 +605,7 @@ func toolMetricsChart(ev *Evaluator, args map[string]string) string {
 		}
 	}
 	
+	sort.Strings(predList)
 	if len(predList) == 0 {
 		sb.WriteString("    y-axis \"Count\" 0 --> 10\n")
 		sb.WriteString("    line \"no data\" [0]\n")
@@ -633,7 +647,8 @@ func toolMetricsChart(ev *Evaluator, args map[string]string) string {
 	}
 	sb.WriteString(fmt.Sprintf("    y-axis \"Count\" 0 --> %d\n", maxY+10))
 	
-	for pred, counts := range seriesData {
+	for _, pred := range predList {
+		counts := seriesData[pred]
 		countStrs := make([]string, len(counts))
 		for i, c := range counts {
 			countStrs[i] = fmt.Sprintf("%d", c)
@@ -0,0 +1,103 @@
+package main
+
+// ============================================================================
+// Topics and Broadcast
+// ============================================================================
+//
+// A producer that sends to each consumer by name has to keep a list of
+// them. With topics the consumers sign up instead:
+//
+//   (subscribe! 'prices)           ; in each consumer
+//   (publish! 'prices '(bread 3))  ; in the producer: => 2, the number sent to
+//
+// and (broadcast! msg) sends to every live actor but the sender.
+//
+// Either is all or nothing: while any recipient's mailbox is full, the
+// sender blocks as send-to! would on that mailbox, and nobody gets the
+// message until they all have room. A recipient whose mailbox could never
+// hold it is skipped and reported the way send-to! reports it.
+
+// subscribeTopic puts the actor on a topic; subscribing twice is harmless
+func (ev *Evaluator) subscribeTopic(name string, args []Value) Value {
+	actor := ev.subscriber(name, args)
+	if actor == nil {
+		return Nil()
+	}
+	topic := valueToString(args[0])
+	if !actor.onTopic(topic) {
+		actor.Topics = append(actor.Topics, topic)
+	}
+	return Sym("ok")
+}
+
+// unsubscribeTopic takes the actor off a topic; true if it was on it
+func (ev *Evaluator) unsubscribeTopic(name string, args []Value) Value {
+	actor := ev.subscriber(name, args)
+	if actor == nil {
+		return Nil()
+	}
+	topic := valueToString(args[0])
+	for i, t := range actor.Topics {
+		if t == topic {
+			actor.Topics = append(actor.Topics[:i:i], actor.Topics[i+1:]...)
+			return Bool(true)
+		}
+	}
+	return Bool(false)
+}
+
+func (a *Actor) onTopic(topic string) bool {
+	for _, t := range a.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// (publish! topic msg) - send msg to every live actor subscribed to topic;
+// returns how many it went to
+func builtinPublish(ev *Evaluator, args []Value, env *Env) Value {
+	topic := valueToString(args[0])
+	var to []*Actor
+	for _, name := range ev.Scheduler.Names() {
+		if a := ev.Scheduler.Actors[name]; a.State != ActorDone && a.onTopic(topic) {
+			to = append(to, a)
+		}
+	}
+	return ev.sendAll(to, args[1])
+}
+
+// (broadcast! msg) - send msg to every live actor but the sender; returns
+// how many it went to
+func builtinBroadcast(ev *Evaluator, args []Value, env *Env) Value {
+	var to []*Actor
+	for _, name := range ev.Scheduler.Names() {
+		if a := ev.Scheduler.Actors[name]; a.State != ActorDone && name != ev.Scheduler.CurrentActor {
+			to = append(to, a)
+		}
+	}
+	return ev.sendAll(to, args[0])
+}
+
+// sendAll sends message to each of to, in order, once all of them have
+// room for it
+func (ev *Evaluator) sendAll(to []*Actor, message Value) Value {
+	ev.markGuardSeen() // CSP: send is a synchronization point
+	for _, a := range to {
+		if !a.Mailbox.Oversized(message) && !a.Mailbox.Fits(message) {
+			return ev.sendBlocked(a, message)
+		}
+	}
+	sent := 0
+	for _, a := range to {
+		if a.Mailbox.Oversized(message) {
+			ev.oversizedSend(a.Name, a.Mailbox, message)
+			continue
+		}
+		a.Mailbox.SendStamped(message, 0, ev.stamp())
+		ev.delivered(a, message)
+		sent++
+	}
+	return Int(int64(sent))
+}
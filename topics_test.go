@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Topic and Broadcast Tests
+// ============================================================================
+
+func TestTopicsAndBroadcast(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{"published messages reach the subscribers", `
+			(define seen '())
+			(define (consumer) (set! seen (cons (list (self) (receive!)) seen)) (list 'become '(consumer)))
+			(spawn-actor 'a 4 '(begin (subscribe! 'prices) (list 'become '(consumer))))
+			(spawn-actor 'b 4 '(begin (subscribe! 'prices) (subscribe! 'prices) (list 'become '(consumer))))
+			(spawn-actor 'c 4 '(consumer))
+			(run-scheduler 10)
+			(list (publish! 'prices 'bread) (run-scheduler 10) seen)`,
+			`(2 (deadlock 4 ((a "recv (empty)") (b "recv (empty)") (c "recv (empty)"))) ((b bread) (a bread)))`},
+		{"subscribing another actor and unsubscribing", `
+			(spawn-actor 'a 4 '(begin (receive!) 'done))
+			(subscribe! 'news 'a)
+			(list (publish! 'news 1) (unsubscribe! 'news 'a) (unsubscribe! 'news 'a) (publish! 'news 2))`,
+			"(1 true false 0)"},
+		{"broadcast skips the sender and finished actors", `
+			(spawn-actor 'gone 4 ''done)
+			(run-scheduler 5)
+			(spawn-actor 'a 4 '(begin (receive!) 'done))
+			(spawn-actor 'b 4 '(begin (set! result (broadcast! 'hello)) (receive!) 'done))
+			(run-scheduler 5)
+			(list result (mailbox-empty? 'a) (mailbox-empty? 'b) (length (query 'sent 'b '?to 'hello)))`,
+			"(1 true true 1)"},
+		{"a full mailbox holds up everyone", `
+			(spawn-actor 'slow 1 ''yield)
+			(spawn-actor 'fast 4 '(begin (receive!) 'done))
+			(send-to! 'slow 'first)
+			(spawn-actor 'producer 4 '(begin (broadcast! 'tick) 'done))
+			(run-scheduler 4)
+			(list (actor-state 'producer) (mailbox-empty? 'fast))`,
+			`((blocked "send-to slow (full)" 0 4) true)`},
+		{"the blocked sender goes on once there is room", `
+			(define got '())
+			(spawn-actor 'slow 1 '(begin (set! got (cons (receive!) got)) (receive!) (set! got (cons 'last got)) 'done))
+			(spawn-actor 'fast 4 '(begin (receive!) 'done))
+			(send-to! 'slow 'first)
+			(spawn-actor 'producer 4 '(begin (set! result (broadcast! 'tick)) 'done))
+			(list (run-scheduler 20) result got)`,
+			"((completed 5) 2 (last first))"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := NewEvaluator(1000)
+			ev.Quiet = true
+			if got := evalLast(ev, tt.code).String(); got != tt.expected {
+				t.Errorf("got %s, want %s", got, tt.expected)
+			}
+		})
+	}
+}
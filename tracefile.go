@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ============================================================================
+// Trace Files
+// ============================================================================
+//
+// A deadlock that turns up once under the random policy is gone on the
+// next run unless something wrote down the schedule that caused it.
+// (run-scheduler ... :record "run.jsonl") writes a trace file with one JSON
+// object per line: the configuration the run used, then every actor
+// scheduled, message sent, fact asserted and actor blocked, in order, and
+// last how the run ended:
+//
+//   {"step":0,"event":"run-started","config":"#(map (max-steps 500) ...)"}
+//   {"step":0,"event":"actor-scheduled","actor":"alice"}
+//   {"step":0,"event":"message-sent","actor":"alice","target":"bob","message":"hello"}
+//   ...
+//   {"step":42,"event":"run-finished","result":"(deadlock 42 ...)"}
+//
+// (replay-trace "run.jsonl") runs the scheduler again under the same
+// configuration, scheduling the recorded actor at every step, and checks
+// each event against the trace. Like the original run, it starts from
+// whatever actors and facts the program has set up by then.
+//
+// philosopher run --record writes the same events for a whole program,
+// between a program-started line with the spec, seed and features and a
+// program-finished line with the fact counts and rule outcomes; gen-test
+// reads those (see gentest.go).
+
+// TraceEvent is one line of a trace file. Only the fields relevant to the
+// event are set.
+type TraceEvent struct {
+	Step    int64  `json:"step"`
+	Event   string `json:"event"`
+	Actor   string `json:"actor,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Message string `json:"message,omitempty"` // write-value form
+	Fact    string `json:"fact,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Result  string `json:"result,omitempty"`
+	Config  string `json:"config,omitempty"` // write-value form of the run configuration
+
+	// For program-started and program-finished (see gentest.go)
+	Spec       string `json:"spec,omitempty"`
+	Seed       int64  `json:"seed,omitempty"`
+	Features   string `json:"features,omitempty"`    // Comma-separated
+	FactCounts string `json:"fact_counts,omitempty"` // write-value form of ((pred count) ...)
+	Properties string `json:"properties,omitempty"`  // write-value form of ((head holds) ...)
+}
+
+// traceEvent is e as a trace line; false for events a trace leaves out
+func traceEvent(e SchedEvent) (TraceEvent, bool) {
+	te := TraceEvent{Step: e.Step, Event: e.Kind.String(), Actor: e.Actor}
+	switch e.Kind {
+	case EventActorScheduled:
+	case EventMessageSent:
+		te.Target, te.Message = e.Target, writtenForm(e.Message)
+	case EventFactAsserted:
+		te.Fact = factString(e.Fact.Predicate, e.Fact.Args)
+	case EventActorBlocked:
+		te.Reason = e.Reason
+	default:
+		return TraceEvent{}, false
+	}
+	return te, true
+}
+
+// writtenForm is v as write-value writes it, or printed if it can't be
+func writtenForm(v Value) string {
+	s, err := WriteValue(v)
+	if err != nil {
+		return v.String()
+	}
+	return s
+}
+
+// traceStarted is the first line of a trace, with the run's configuration.
+// Property checks that can't be written out are left off.
+func traceStarted(cfg RunConfig, step int64) TraceEvent {
+	config, err := WriteValue(cfg.Value())
+	if err != nil {
+		cfg.StopOn = nil
+		config = writtenForm(cfg.Value())
+	}
+	return TraceEvent{Step: step, Event: "run-started", Config: config}
+}
+
+// traceFinished is the last line of a trace, with the run's result
+func traceFinished(result Value, step int64) TraceEvent {
+	return TraceEvent{Step: step, Event: "run-finished", Result: result.String()}
+}
+
+// TraceRecorder writes a run's events to a trace file as they happen
+type TraceRecorder struct {
+	file *os.File
+	enc  *json.Encoder
+	id   int // Event bus subscription
+	err  error
+}
+
+// recordTrace starts writing a trace of the run cfg describes to
+// cfg.Record. Returns nil, having warned, if the file can't be written.
+func (ev *Evaluator) recordTrace(cfg RunConfig) *TraceRecorder {
+	path, ok := ev.filePath("run-scheduler", []Value{Str(cfg.Record)})
+	if !ok {
+		return nil
+	}
+	r, err := ev.newTraceRecorder(path, traceStarted(cfg, ev.Scheduler.StepCount))
+	if err != nil {
+		ev.warn("", "run-scheduler: %v", err)
+		return nil
+	}
+	return r
+}
+
+// newTraceRecorder creates the trace file at path, writes first to it and
+// writes every event after that until finish
+func (ev *Evaluator) newTraceRecorder(path string, first TraceEvent) (*TraceRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &TraceRecorder{file: f, enc: json.NewEncoder(f)}
+	r.write(first)
+	r.id = ev.Events.Subscribe(func(ev *Evaluator, e SchedEvent) {
+		if te, ok := traceEvent(e); ok {
+			r.write(te)
+		}
+	})
+	return r, nil
+}
+
+func (r *TraceRecorder) write(te TraceEvent) {
+	if r.err == nil {
+		r.err = r.enc.Encode(te)
+	}
+}
+
+// finish ends the trace with last and closes the file
+func (r *TraceRecorder) finish(ev *Evaluator, last TraceEvent) error {
+	ev.Events.Unsubscribe(r.id)
+	r.write(last)
+	if err := r.file.Close(); r.err == nil {
+		r.err = err
+	}
+	if r.err != nil {
+		return fmt.Errorf("writing %s: %v", r.file.Name(), r.err)
+	}
+	return nil
+}
+
+// readTrace reads a trace file written by run-scheduler's :record or by
+// philosopher run --record
+func readTrace(path string) ([]TraceEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var events []TraceEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var te TraceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &te); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		events = append(events, te)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(events) == 0 || events[0].Event != "run-started" && events[0].Event != "program-started" {
+		return nil, fmt.Errorf("%s: not a trace file (no run-started or program-started line)", path)
+	}
+	return events, nil
+}
+
+// (replay-trace "file") - run the scheduler again the way a recorded run
+// went. Returns the run's result, or (diverged step expected got) with the
+// first event that came out differently.
+func builtinReplayTrace(ev *Evaluator, args []Value, env *Env) Value {
+	path, ok := ev.filePath("replay-trace", args)
+	if !ok {
+		return Nil()
+	}
+	want, err := readTrace(path)
+	if err != nil {
+		ev.warnTrace("", "replay-trace: %v", err)
+		return Nil()
+	}
+	if want[0].Event != "run-started" {
+		ev.warnTrace("", "replay-trace: %s records a whole program; use philosopher gen-test to replay it", path)
+		return Nil()
+	}
+	config, err := ReadValue(want[0].Config)
+	if err != nil {
+		ev.warnTrace("", "replay-trace: %s: bad configuration: %v", path, err)
+		return Nil()
+	}
+	cfg, _ := ev.parseRunConfig([]Value{config})
+	var schedule []string
+	for _, te := range want {
+		if te.Event == "actor-scheduled" {
+			schedule = append(schedule, te.Actor)
+		}
+	}
+
+	s := ev.Scheduler
+	script := s.Script
+	s.Script, s.Diverged = schedule, false
+	defer func() { s.Script = script }()
+	got := []TraceEvent{traceStarted(cfg, s.StepCount)}
+	id := ev.Events.Subscribe(func(ev *Evaluator, e SchedEvent) {
+		if te, ok := traceEvent(e); ok {
+			got = append(got, te)
+		}
+	})
+	result := ev.runConfigured(cfg)
+	ev.Events.Unsubscribe(id)
+	got = append(got, traceFinished(Lst(result.List[:len(result.List)-1]...), s.StepCount))
+
+	for i := 0; i < len(want) || i < len(got); i++ {
+		var w, g TraceEvent
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if w != g {
+			step := w.Step
+			if i >= len(want) {
+				step = g.Step
+			}
+			return Lst(Sym("diverged"), Int(step), Str(traceLine(w)), Str(traceLine(g)))
+		}
+	}
+	return result
+}
+
+// traceLine is te as it appears in a trace file, or "" for no event
+func traceLine(te TraceEvent) string {
+	if te == (TraceEvent{}) {
+		return ""
+	}
+	data, _ := json.Marshal(te)
+	return string(data)
+}
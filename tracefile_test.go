@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Trace File Tests
+// ============================================================================
+
+// pingPong ends deadlocked after a schedule that depends on the seed
+const pingPong = `
+	(define (pinger n) (send-to! 'ponger n) (if (> n 0) (list 'become (list 'pinger (- n 1))) 'done))
+	(define (ponger) (let ((m (receive!))) (assert! 'got m) (list 'become '(ponger))))
+	(spawn-actor 'pinger 2 '(pinger 3))
+	(spawn-actor 'ponger 2 '(ponger))`
+
+func TestRecordAndReplayTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.jsonl")
+	world := func(code string) *Evaluator {
+		ev := NewEvaluator(1000)
+		ev.Quiet = true
+		ev.FileAccess = true
+		runCode(ev, code)
+		return ev
+	}
+
+	recorded := evalLast(world(pingPong), `(run-scheduler :max-steps 50 :policy 'random :record "`+path+`")`).String()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for _, want := range []string{
+		`"event":"run-started","config":"#(map (livelock 0) (max-steps 50) (policy random)`,
+		`"event":"actor-scheduled","actor":"pinger"}`,
+		`"event":"message-sent","actor":"pinger","target":"ponger","message":"3"}`,
+		`"event":"fact-asserted","fact":"(got 3)"}`,
+		`"event":"actor-blocked","actor":"ponger","reason":"recv (empty)"}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("trace has no line with %s:\n%s", want, data)
+		}
+	}
+	if last := lines[len(lines)-1]; !strings.Contains(last, `"event":"run-finished","result":"(deadlock `) {
+		t.Errorf("trace should end with the result, got %s", last)
+	}
+
+	// A new world running the same program goes the same way
+	if got := evalLast(world(pingPong), `(replay-trace "`+path+`")`).String(); got != recorded {
+		t.Errorf("replay gave %s, recorded run %s", got, recorded)
+	}
+
+	// One that doesn't says where it went another way
+	changed := strings.Replace(pingPong, "(assert! 'got m)", "(assert! 'got (* m 10))", 1)
+	got := evalLast(world(changed), `(replay-trace "`+path+`")`)
+	if got.Type != TypeList || len(got.List) != 4 || got.List[0].String() != "diverged" {
+		t.Fatalf("changed program replayed as %s", got.String())
+	}
+	if !strings.Contains(got.List[2].Str, `"fact":"(got 3)"`) || !strings.Contains(got.List[3].Str, `"fact":"(got 30)"`) {
+		t.Errorf("expected the first different fact, got %s", got.String())
+	}
+}
+
+func TestReplayTraceErrors(t *testing.T) {
+	dir := t.TempDir()
+	notTrace := filepath.Join(dir, "notes.jsonl")
+	os.WriteFile(notTrace, []byte(`{"step":0,"event":"actor-scheduled","actor":"a"}`+"\n"), 0644)
+
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	ev.FileAccess = true
+	tests := []struct {
+		code    string
+		warning string
+	}{
+		{`(replay-trace "` + notTrace + `")`, "replay-trace: " + notTrace + ": not a trace file"},
+		{`(replay-trace "` + filepath.Join(dir, "missing.jsonl") + `")`, "replay-trace: open"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code); got.Type != TypeNil {
+			t.Errorf("%s = %s, want nil", tt.code, got.String())
+		}
+		if lastWarning(ev, tt.warning) == nil {
+			t.Errorf("%s: expected a warning starting %q, got %v", tt.code, tt.warning, ev.Warnings)
+		}
+	}
+
+	ev.FileAccess = false
+	if got := evalLast(ev, `(run-scheduler :max-steps 5 :record "`+filepath.Join(dir, "run.jsonl")+`")`).String(); !strings.HasPrefix(got, "(completed 0") {
+		t.Errorf("a run that can't record should still run, got %s", got)
+	}
+	if lastWarning(ev, "run-scheduler: file access is disabled") == nil {
+		t.Errorf("expected a warning that the trace wasn't written")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import "sort"
+
+// ============================================================================
+// Vector Clocks
+// ============================================================================
+//
+// A Lamport clock puts every cause before its effects, but also puts
+// unrelated events in some order, so it can't say whether one event
+// could have caused another. With (vector-clocks! true) each actor also
+// keeps a vector clock, a count for every actor it has heard from:
+//
+//   - every step an actor takes advances its own count by one
+//   - a message carries its sender's vector, as of the send
+//   - receiving it raises each of the receiver's counts to the message's,
+//     then advances its own
+//
+// One event happened before another exactly when the first's vector is
+// no later in any count and earlier in at least one; when neither is
+// before the other they are concurrent, and no schedule could have let
+// one affect the other. Sends and receives are recorded as
+// (vector-sent from to msg vector) and (vector-received actor msg vector),
+// with the vector as a list ((actor count) ...) sorted by actor, and the
+// Datalog builtins happened-before and concurrent compare two of them:
+//
+//   (rule 'out-of-order '(out-of-order ?a ?m1 ?m2)
+//     '(vector-sent ?s1 ?a ?m1 ?v1) '(vector-sent ?s2 ?a ?m2 ?v2)
+//     '(happened-before ?v1 ?v2)
+//     '(vector-received ?a ?m2 ?r2) '(vector-received ?a ?m1 ?r1)
+//     '(happened-before ?r2 ?r1))
+//
+// Vector clocks cost a copy of the vector on every send, so they are off
+// until asked for.
+
+// VectorClock counts, for each actor, the steps of it that are known of
+type VectorClock map[string]int64
+
+func (v VectorClock) copy() VectorClock {
+	if v == nil {
+		return nil
+	}
+	cp := make(VectorClock, len(v))
+	for k, n := range v {
+		cp[k] = n
+	}
+	return cp
+}
+
+// merge raises each count in v to other's
+func (v VectorClock) merge(other VectorClock) {
+	for k, n := range other {
+		if n > v[k] {
+			v[k] = n
+		}
+	}
+}
+
+// before reports whether v happened before other
+func (v VectorClock) before(other VectorClock) bool {
+	earlier := false
+	for k, n := range v {
+		if n > other[k] {
+			return false
+		}
+		if n < other[k] {
+			earlier = true
+		}
+	}
+	for k, n := range other {
+		if _, ok := v[k]; !ok && n > 0 {
+			earlier = true
+		}
+	}
+	return earlier
+}
+
+func (v VectorClock) names() []string {
+	names := make([]string, 0, len(v))
+	for k := range v {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// term is v as ((actor count) ...), sorted by actor
+func (v VectorClock) term() Term {
+	entries := make([]Term, 0, len(v))
+	for _, k := range v.names() {
+		entries = append(entries, ListTerm(Atom(k), NumTerm(float64(v[k]))))
+	}
+	return ListTerm(entries...)
+}
+
+func (v VectorClock) value() Value {
+	entries := make([]Value, 0, len(v))
+	for _, k := range v.names() {
+		entries = append(entries, Lst(Sym(k), Int(v[k])))
+	}
+	return Lst(entries...)
+}
+
+// termVectorClock reads a vector written by term; false if t isn't one
+func termVectorClock(t Term) (VectorClock, bool) {
+	if !t.IsList {
+		return nil, false
+	}
+	v := make(VectorClock, len(t.List))
+	for _, e := range t.List {
+		if !e.IsList || len(e.List) != 2 || e.List[0].IsVar || !e.List[1].IsNum {
+			return nil, false
+		}
+		v[e.List[0].String()] = int64(e.List[1].Num)
+	}
+	return v, true
+}
+
+// vectorOrder evaluates the happened-before and concurrent builtins
+func vectorOrder(op string, left, right Term) bool {
+	l, ok := termVectorClock(left)
+	if !ok {
+		return false
+	}
+	r, ok := termVectorClock(right)
+	if !ok {
+		return false
+	}
+	if op == "happened-before" {
+		return l.before(r)
+	}
+	return !l.before(r) && !r.before(l) && !left.Equal(right)
+}
+
+// (vector-clocks! on) - turn vector clocks on or off; turning them off
+// drops every actor's vector
+func builtinVectorClocks(ev *Evaluator, args []Value, env *Env) Value {
+	ev.Scheduler.VectorClocks = args[0].IsTruthy()
+	if !ev.Scheduler.VectorClocks {
+		for _, a := range ev.Scheduler.Actors {
+			a.VClock = nil
+		}
+	}
+	return Sym("ok")
+}
+
+// (vector-time [actor]) - the vector clock of actor, or of the running
+// actor, as ((actor count) ...); () outside any or while vector clocks
+// are off
+func builtinVectorTime(ev *Evaluator, args []Value, env *Env) Value {
+	name := ev.Scheduler.CurrentActor
+	if len(args) > 0 {
+		name = valueToString(args[0])
+	}
+	a := ev.Scheduler.GetActor(name)
+	if a == nil {
+		if len(args) > 0 {
+			ev.warnTrace("", "vector-time: unknown actor %s", name)
+			return Nil()
+		}
+		return Lst()
+	}
+	return a.VClock.value()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Vector Clock Tests
+// ============================================================================
+
+func TestVectorClocks(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (a) (send-to! 'c 'm1) (send-to! 'b 'go) 'done)
+		(define (b) (receive!) (send-to! 'c 'm2) 'done)
+		(define (x) (send-to! 'c 'm3) 'done)
+		(define (c) (receive!) (list 'become '(c)))
+		(spawn-actor 'a 4 '(a))
+		(spawn-actor 'b 4 '(b))
+		(spawn-actor 'x 4 '(x))
+		(spawn-actor 'c 4 '(c))`)
+	if got := evalLast(ev, "(vector-time 'a)").String(); got != "()" {
+		t.Errorf("vector clocks should start off, a has %s", got)
+	}
+	runCode(ev, `
+		(vector-clocks! true)
+		(run-scheduler 50)
+		(rule 'causes '(causes ?m1 ?m2)
+		  '(vector-sent ?s1 ?t1 ?m1 ?v1) '(vector-sent ?s2 ?t2 ?m2 ?v2) '(happened-before ?v1 ?v2))
+		(rule 'unrelated '(unrelated ?m1 ?m2)
+		  '(vector-sent ?s1 ?t1 ?m1 ?v1) '(vector-sent ?s2 ?t2 ?m2 ?v2) '(concurrent ?v1 ?v2))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		// m2 was sent after b heard from a, so it carries a's count too
+		{"(query 'vector-sent 'a 'c '?m '?v)", "(((m m1) (v ((a 1)))))"},
+		{"(query 'vector-sent 'b 'c '?m '?v)", "(((m m2) (v ((a 1) (b 2)))))"},
+		{"(query 'vector-received 'c 'm2 '?v)", "(((v ((a 1) (b 2) (c 4)))))"},
+		{"(length (query 'causes 'm1 'm2))", "1"},
+		{"(query 'causes 'm2 'm1)", "()"},
+		{"(query 'causes 'm1 'm1)", "()"},
+		// a sent m1 and go in the same step, so neither is before the other
+		{"(query 'causes 'm1 'go)", "()"},
+		{"(query 'unrelated 'm1 'go)", "()"},
+		{"(length (query 'unrelated 'm3 '?m))", "3"},
+		{"(vector-time 'c)", "((a 1) (b 2) (c 7) (x 1))"},
+		{"(vector-time)", "()"},
+		{"(vector-time 'nobody)", "nil"},
+		{"(begin (vector-clocks! false) (vector-time 'c))", "()"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	if lastWarning(ev, "vector-time: unknown actor nobody") == nil {
+		t.Errorf("expected a warning about the unknown actor, got %v", ev.Warnings)
+	}
+}
+
+func TestVectorClockOrder(t *testing.T) {
+	v := func(counts ...int64) VectorClock {
+		vc := make(VectorClock)
+		for i, n := range counts {
+			if n != 0 {
+				vc[string(rune('a'+i))] = n
+			}
+		}
+		return vc
+	}
+	tests := []struct {
+		left, right VectorClock
+		before      bool
+		concurrent  bool
+	}{
+		{v(1), v(1, 1), true, false},
+		{v(1, 1), v(1), false, false},
+		{v(2, 1), v(2, 1), false, false},
+		{v(2), v(1, 1), false, true},
+		{v(), v(0, 0, 1), true, false},
+	}
+	for _, tt := range tests {
+		l, r := tt.left.term(), tt.right.term()
+		if got := vectorOrder("happened-before", l, r); got != tt.before {
+			t.Errorf("happened-before %s %s = %v", l, r, got)
+		}
+		if got := vectorOrder("concurrent", l, r); got != tt.concurrent {
+			t.Errorf("concurrent %s %s = %v", l, r, got)
+		}
+	}
+	if vectorOrder("happened-before", Atom("x"), v(1).term()) {
+		t.Errorf("happened-before should fail for a term that isn't a vector")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+// ============================================================================
+// Virtual Time
+// ============================================================================
+//
+// Facts are stamped with the step they were asserted at, and timers count
+// steps, so "time" in a run is however many steps it happened to take. A
+// simulation with its own notion of time, days in a bakery or ticks of a
+// protocol's clock, moves a virtual clock instead:
+//
+//   (advance-time! 1)   ; => 1, the new time
+//   (virtual-time)      ; => 1
+//
+// From the first advance-time! on, time in the run is virtual:
+//
+//   - facts are stamped with the virtual time, so before, after, between,
+//     at-time and the metrics_chart tool go by it, and (datalog-time)
+//     follows it
+//   - send-after! and receive-timeout! count virtual time, firing once
+//     advance-time! has moved the clock far enough
+//   - when every actor is waiting on a timer, the clock jumps ahead to
+//     the earliest, as a discrete-event simulation would
+//
+// Facts asserted before then keep their step stamps, so a simulation that
+// uses virtual time should start with (advance-time! 0).
+
+// now is the time facts and timers are stamped with: the virtual time
+// once it is in use, the step count otherwise
+func (s *Scheduler) now() int64 {
+	if s.Virtual {
+		return s.VirtualTime
+	}
+	return s.StepCount
+}
+
+func (ev *Evaluator) now() int64 { return ev.Scheduler.now() }
+
+// setTime moves the virtual clock to t, turning virtual time on
+func (ev *Evaluator) setTime(t int64) {
+	ev.Scheduler.Virtual = true
+	ev.Scheduler.VirtualTime = t
+	ev.DatalogDB.TimeNow = t
+}
+
+// (advance-time! n) - move the virtual clock n ahead; returns the new time
+func builtinAdvanceTime(ev *Evaluator, args []Value, env *Env) Value {
+	if args[0].Number < 0 {
+		ev.warnTrace("", "advance-time!: time can't go back (%s)", args[0].String())
+		return Nil()
+	}
+	ev.setTime(ev.Scheduler.VirtualTime + int64(args[0].Number))
+	return Int(ev.Scheduler.VirtualTime)
+}
+
+// (virtual-time) - the virtual clock; 0 until advance-time! is used
+func builtinVirtualTime(ev *Evaluator, args []Value, env *Env) Value {
+	return Int(ev.Scheduler.VirtualTime)
+}
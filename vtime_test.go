@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+)
+
+// ============================================================================
+// Virtual Time Tests
+// ============================================================================
+
+func TestVirtualTime(t *testing.T) {
+	ev := NewEvaluator(1000)
+	ev.Quiet = true
+	runCode(ev, `
+		(define (day n) (advance-time! 1) (send-to! 'baker n) (if (> n 1) (list 'become (list 'day (- n 1))) 'done))
+		(define (baker) (assert! 'baked (receive-timeout! 5)) (list 'become '(baker)))
+		(spawn-actor 'baker 4 '(baker))
+		(spawn-actor 'days 4 '(day 3))`)
+
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		// Until advance-time! is used, facts are stamped with steps
+		{"(virtual-time)", "0"},
+		{"(query 'at-time 'spawned 'days '?t)", "(((t 0)))"},
+		{"(run-scheduler 8)", "(max-steps 8)"},
+		{"(list (virtual-time) (datalog-time))", "(3 3)"},
+		{"(query 'at-time 'baked '?n '?t)", "(((n 3) (t 2)) ((n 2) (t 3)) ((n 1) (t 3)))"},
+		{"(length (query 'between 'sent '?from '?to '?msg 2 3))", "2"},
+		// Nothing else happens until baker's timeout, so the clock jumps to it
+		{"(run-scheduler 2)", "(max-steps 2)"},
+		{"(query 'at-time 'baked 'timeout '?t)", "(((t 8)))"},
+		{"(begin (send-after! 4 'baker 'late) (pending-timers))", "((12 external baker late))"},
+		{"(advance-time! 4)", "12"},
+		{"(run-scheduler 1)", "(max-steps 1)"},
+		{"(query 'at-time 'baked 'late '?t)", "(((t 12)))"},
+		{"(advance-time! -1)", "nil"},
+	}
+	for _, tt := range tests {
+		if got := evalLast(ev, tt.code).String(); got != tt.expected {
+			t.Errorf("%s = %s, want %s", tt.code, got, tt.expected)
+		}
+	}
+	if lastWarning(ev, "advance-time!: time can't go back") == nil {
+		t.Errorf("expected a warning about going back in time, got %v", ev.Warnings)
+	}
+}